@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"peertech.de/axion/pkg/manifest"
+	"peertech.de/axion/pkg/orchestrator"
+)
+
+// errNotApproved is returned when the user declines to apply the planned changes
+var errNotApproved = errors.New("apply cancelled")
+
+// checkApprovable fails if apply can't prompt for approval and --auto-approve isn't set
+func checkApprovable() error {
+	switch {
+	case outputFormat != "":
+		return errors.New("--output requires --auto-approve for apply")
+	case manifestFile == manifest.Stdin:
+		return errors.New("reading the manifest from stdin requires --auto-approve")
+	case !isTerminal(os.Stdin):
+		return errors.New("stdin is not a terminal, use --auto-approve to apply without confirmation")
+	}
+	return nil
+}
+
+// isTerminal reports whether f is connected to a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// changeCount returns the number of resources the plan in summary would change
+func changeCount(summary *orchestrator.Summary) int {
+	n := 0
+	for _, a := range summary.Attempts {
+		if a.NeedsApply {
+			n++
+		}
+	}
+	return n
+}
+
+// confirmApply prints the number of planned changes to out and asks for approval on in,
+// only "yes" approves
+func confirmApply(in io.Reader, out io.Writer, changes, unchanged int) (bool, error) {
+	fmt.Fprintf(out, "\nPlan: %d to change, %d unchanged.\n\n", changes, unchanged)
+	fmt.Fprintln(out, "Do you want to perform these actions?")
+	fmt.Fprintln(out, "  Only 'yes' will be accepted to approve.")
+	fmt.Fprint(out, "\n  Enter a value: ")
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	fmt.Fprintln(out)
+	return strings.TrimSpace(answer) == "yes", nil
+}
@@ -2,29 +2,53 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
-	"os/signal"
-	"path/filepath"
-	"strings"
-	"syscall"
 
+	"github.com/go-openapi/runtime"
+	httptransport "github.com/go-openapi/runtime/client"
+	"github.com/go-openapi/strfmt"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"gopkg.in/yaml.v3"
 
 	"peertech.de/axion/api/client"
+	"peertech.de/axion/pkg/agentgrpc"
 	"peertech.de/axion/pkg/config"
 	"peertech.de/axion/pkg/manifest"
+	manifestcue "peertech.de/axion/pkg/manifest/cue"
 	manifeststarlark "peertech.de/axion/pkg/manifest/starlark"
 	manifestyaml "peertech.de/axion/pkg/manifest/yaml"
+	"peertech.de/axion/pkg/metrics"
 	"peertech.de/axion/pkg/orchestrator"
+	"peertech.de/axion/pkg/report"
+	"peertech.de/axion/pkg/secret"
+	"peertech.de/axion/pkg/signing"
+	"peertech.de/axion/pkg/tracing"
 )
 
 var endpoint string
 var configFile string
 var concurrency int
 var manifestFile string
+var variables []string
+var variableFiles []string
+var allowedEnv []string
+var inferDependencies bool
+var tlsConfig config.TLSConfig
+var tokenFile string
+var token string
+var signingSecretFile string
+var outputFormat string
+var inventoryFile string
+var parallelHosts int
+var contextName string
+var targets []string
+var skips []string
 
 func main() {
 	rootCmd := &cobra.Command{
@@ -32,92 +56,417 @@ func main() {
 		Short:         "Declarative configuration manager",
 		SilenceErrors: true,
 		SilenceUsage:  true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := setupLogging(); err != nil {
+				return err
+			}
+			if err := setupMetrics(); err != nil {
+				return err
+			}
+			return applyContext(cmd)
+		},
 	}
 
 	rootCmd.PersistentFlags().StringVar(&endpoint, "endpoint", "http://localhost:8080",
-		"API endpoint (e.g., https://localhost:8080)")
+		"API endpoint (e.g., https://localhost:8080, grpcs://localhost:9090 for gRPC)")
 	rootCmd.PersistentFlags().StringVar(&configFile, "config", "",
 		"Path to optional YAML configuration file")
+	rootCmd.PersistentFlags().StringVar(&contextName, "context", "",
+		"Context providing the endpoint and credentials not given as flags (default: the current context)")
+	rootCmd.RegisterFlagCompletionFunc("context", completeContexts)
+	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v",
+		"Log the API calls to stderr, repeat (-vv) to log the HTTP requests as well")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logFormatText,
+		"Format of the log written to stderr: text or json")
+	rootCmd.RegisterFlagCompletionFunc("log-format", cobra.FixedCompletions([]string{logFormatText, logFormatJSON}, cobra.ShellCompDirectiveNoFileComp))
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false,
+		"Don't color the output (also disabled by setting NO_COLOR)")
+	rootCmd.PersistentFlags().BoolVar(&noEmoji, "no-emoji", false,
+		"Report progress as plain text lines instead of emoji")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false,
+		"Only report changes, applied resources, failures, rollbacks and warnings")
+	rootCmd.PersistentFlags().BoolVar(&logReport, "log-report", false,
+		"Report progress as structured log events on stderr, formatted by --log-format")
+	rootCmd.PersistentFlags().IntVar(&diffContext, "diff-context", report.DefaultDiffContext,
+		"Unchanged lines of diffs shown around each change, longer runs are collapsed (-1 shows all)")
+	rootCmd.PersistentFlags().BoolVar(&timings, "timings", false,
+		"Print a table of the resources with their operation, result and duration after each run, the slowest first")
+	rootCmd.PersistentFlags().StringVar(&metricsURL, "metrics", "",
+		"Push the metrics of each run to this Prometheus Pushgateway (http or https URL) or StatsD daemon\n"+
+			"(statsd://host:port/prefix)")
+	rootCmd.PersistentFlags().StringVar(&metricsJob, "metrics-job", metrics.DefaultJob,
+		"Job the metrics are pushed to the Pushgateway as")
+	rootCmd.PersistentFlags().DurationVar(&requestTimeout, "request-timeout", defaultRequestTimeout,
+		"Fail API requests the agent doesn't respond to in time (0 disables the limit)")
 	rootCmd.PersistentFlags().IntVar(&concurrency, "concurrency", 1,
 		"Maximum number of resources to process concurrently (default: 1 for sequential processing)")
+	rootCmd.PersistentFlags().StringArrayVar(&allowedEnv, "allow-env", nil,
+		"Environment variable manifests are allowed to read, can be repeated, \"*\" allows all (default: none)")
+
+	rootCmd.PersistentFlags().BoolVar(&inferDependencies, "infer-dependencies", false,
+		"Make files and directories depend on the managed directory containing them")
+
+	rootCmd.PersistentFlags().StringVar(&tlsConfig.CertFile, "tls-cert", "",
+		"Path to the client certificate presented to the agent (mutual TLS)")
+	rootCmd.PersistentFlags().StringVar(&tlsConfig.KeyFile, "tls-key", "",
+		"Path to the private key of the client certificate")
+	rootCmd.PersistentFlags().StringVar(&tlsConfig.CAFile, "tls-ca", "",
+		"Path to the CA bundle verifying the agent certificate (default: system roots)")
+	rootCmd.PersistentFlags().BoolVar(&tlsConfig.InsecureSkipVerify, "insecure", false,
+		"Don't verify the agent certificate (insecure, for tests with self-signed certificates only)")
+	rootCmd.PersistentFlags().StringVar(&tokenFile, "token-file", "",
+		"Path to the file holding the bearer token presented to the agent")
+	rootCmd.PersistentFlags().StringVar(&token, "token", "",
+		"Bearer token presented to the agent (default: $"+config.TokenEnvVar+"), prefer --token-file or the\n"+
+			"environment variable to keep it out of the process list")
+	// The names of the TLS flags used by other tools
+	rootCmd.SetGlobalNormalizationFunc(normalizeFlagName)
+	rootCmd.PersistentFlags().StringVar(&signingSecretFile, "signing-secret-file", "",
+		"Path to the file holding the shared secret requests are signed with")
 
 	rootCmd.AddCommand(cmdPlan())
 	rootCmd.AddCommand(cmdApply())
-
-	if err := rootCmd.Execute(); err != nil {
+	rootCmd.AddCommand(cmdLint())
+	rootCmd.AddCommand(cmdRender())
+	rootCmd.AddCommand(cmdVars())
+	rootCmd.AddCommand(cmdLastApplied())
+	rootCmd.AddCommand(cmdDrift())
+	rootCmd.AddCommand(cmdState())
+	rootCmd.AddCommand(cmdImport())
+	rootCmd.AddCommand(cmdDestroy())
+	rootCmd.AddCommand(cmdPkg())
+	rootCmd.AddCommand(cmdExec())
+	rootCmd.AddCommand(cmdCp())
+	rootCmd.AddCommand(cmdEvents())
+	rootCmd.AddCommand(cmdCompletion())
+	rootCmd.AddCommand(cmdVersion())
+	rootCmd.AddCommand(cmdConfig())
+	rootCmd.AddCommand(cmdWatch())
+	rootCmd.AddCommand(cmdEnforce())
+	rootCmd.AddCommand(cmdBackups())
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "axionctl")
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %s\n", prettifyError(err))
 		os.Exit(1)
 	}
+
+	err = rootCmd.Execute()
+	if serr := shutdownTracing(context.Background()); serr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to flush traces: %s\n", serr)
+	}
+	var code exitCode
+	if errors.As(err, &code) {
+		os.Exit(int(code))
+	}
+	if err != nil && runTimeout > 0 && errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("run exceeded the timeout of %s: %w", runTimeout, err)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", prettifyError(err))
+		os.Exit(exitStatus(err))
+	}
+}
+
+// flagAliases maps the names of flags used by other tools to the flags of axionctl
+var flagAliases = map[string]string{
+	"ca-cert":     "tls-ca",
+	"client-cert": "tls-cert",
+	"client-key":  "tls-key",
+
+	"host-concurrency": "parallel",
+}
+
+// normalizeFlagName resolves the flag aliases
+func normalizeFlagName(f *pflag.FlagSet, name string) pflag.NormalizedName {
+	if alias, ok := flagAliases[name]; ok {
+		name = alias
+	}
+	return pflag.NormalizedName(name)
 }
 
 func cmdPlan() *cobra.Command {
+	var (
+		skipReadinessCheck bool
+		detailedExitCode   bool
+		planFile           string
+	)
+
 	cmd := &cobra.Command{
 		Use:   "plan",
 		Short: "Preview configuration changes without applying them",
 		Long: `Plan evaluates the manifest against the current system state and shows
-what changes would be made without actually applying them.`,
+what changes would be made without actually applying them.
+
+With --out, the plan is saved for "axionctl apply <planfile>", which only applies
+it if the host state didn't change since planning.
+
+With --detailed-exitcode, plan exits with 0 if there are no changes, 2 if changes
+are pending and 1 on errors.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			ctx, cancel := runContext()
 			defer cancel()
 
+			if err := validateOutput(outputFormat); err != nil {
+				return err
+			}
+
+			if planFile != "" && manifestFile == "-" {
+				return errors.New("plans of manifests read from stdin can't be saved")
+			}
+
+			if inventoryFile != "" {
+				if planFile != "" {
+					return errors.New("plans of inventories can't be saved")
+				}
+				inv, err := loadInventory(cmd.Flags().Changed("endpoint"))
+				if err != nil {
+					return err
+				}
+				return planInventory(ctx, inv, skipReadinessCheck, detailedExitCode)
+			}
+
 			cfg, err := setupConfig(false, "", concurrency, endpoint)
 			if err != nil {
 				return err
 			}
 
-			o, err := setupOrchestrator(cfg, manifestFile)
+			if err := negotiate(ctx, cfg); err != nil {
+				return err
+			}
+			if !skipReadinessCheck {
+				if err := checkReadiness(ctx, cfg); err != nil {
+					return err
+				}
+			}
+
+			o, err := setupOrchestrator(cfg, manifestFile, endpoint, "")
 			if err != nil {
 				return err
 			}
 
 			summary := o.Run(ctx, true)
+			pushMetrics(ctx, endpoint, summary, true)
+			if err := writeJUnit(summary, cfg.Secrets.Redact); err != nil {
+				return err
+			}
+			if err := writeRunReport(summary, true, cfg.Secrets.Redact); err != nil {
+				return err
+			}
+			if outputFormat != "" {
+				if err := printSummary(os.Stdout, outputFormat, summary, true, cfg.Secrets.Redact); err != nil {
+					return err
+				}
+			}
 			if summary.Error != nil {
 				return summary.Error
 			}
+			if outputFormat == "" {
+				printPlanDrift(summary)
+			}
+
+			if planFile != "" && summary.Success {
+				if err := savePlan(planFile, summary, cfg.Secrets.Redact); err != nil {
+					return err
+				}
+			}
+
+			if detailedExitCode {
+				if !summary.Success {
+					return errors.New("plan failed")
+				}
+				if changeCount(summary) > 0 {
+					return exitCode(2)
+				}
+			}
 
 			return nil
 		},
 	}
 
 	cmd.Flags().StringVar(&manifestFile, "manifest", "",
-		"Path to YAML manifest file containing resource definitions (required)")
+		"Path to manifest file (YAML, JSON, Starlark or CUE) containing resource definitions, - reads from stdin (required)")
 	cmd.MarkFlagRequired("manifest")
+	cmd.RegisterFlagCompletionFunc("manifest", completeManifest)
+	cmd.Flags().StringArrayVar(&variables, "var", nil,
+		"Set a manifest variable (key=value), overrides variables from the manifest and var files")
+	cmd.Flags().StringArrayVar(&variableFiles, "var-file", nil,
+		"Path to YAML file with manifest variables, can be repeated")
+	cmd.Flags().BoolVar(&skipReadinessCheck, "skip-readiness-check", false,
+		"Don't check that the agent is ready before starting")
+	cmd.Flags().DurationVar(&runTimeout, "timeout", 0,
+		"Abort the run if it takes longer, e.g. 15m (default: no limit)")
+	cmd.Flags().StringVar(&outputFormat, "output", "",
+		"Print the summary as json or yaml instead of reporting progress")
+	cmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions([]string{outputJSON, outputYAML}, cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().StringVar(&inventoryFile, "inventory", "",
+		"Path to YAML file listing the agent endpoints (and their variables) to run against instead of --endpoint")
+	cmd.Flags().IntVar(&parallelHosts, "parallel", 1,
+		"Maximum number of inventory hosts to run against at the same time")
+	cmd.Flags().StringArrayVar(&targets, "target", nil,
+		"Only process the resource with this id and its dependencies, can be repeated")
+	cmd.RegisterFlagCompletionFunc("target", completeResourceIDs)
+	cmd.Flags().StringArrayVar(&skips, "skip", nil,
+		"Don't process the resource with this id and the resources depending on it, can be repeated")
+	cmd.RegisterFlagCompletionFunc("skip", completeResourceIDs)
+	cmd.Flags().BoolVar(&detailedExitCode, "detailed-exitcode", false,
+		"Exit with 0 if there are no changes, 2 if changes are pending and 1 on errors")
+	cmd.Flags().StringVar(&planFile, "out", "",
+		"Save the plan to this file, to be applied with \"axionctl apply <planfile>\"")
+	cmd.Flags().StringVar(&junitFile, "junit", "",
+		"Write the result as JUnit XML report to this file, with a test case per resource")
+	cmd.Flags().StringVar(&reportFile, "report", "",
+		"Write a report of the run with the diffs, outcomes and durations of the resources to this file, as HTML if it ends with .html and as Markdown otherwise")
 
 	return cmd
 }
 
 func cmdApply() *cobra.Command {
 	var (
-		enableBackups bool
-		backupDir     string
+		enableBackups      bool
+		backupDir          string
+		agentBackups       bool
+		skipReadinessCheck bool
+		autoApprove        bool
 	)
 
 	cmd := &cobra.Command{
-		Use:   "apply",
+		Use:   "apply [planfile]",
 		Short: "Apply the configuration to the target system",
 		Long: `Apply evaluates the manifest and makes the necessary changes to bring
 the system to the desired state defined in the manifest.
 
-WARNING: This command makes actual changes to your system.
-Always run 'plan' first to review changes.`,
+The changes are planned and shown first, and only applied once confirmed with
+"yes". Pass --auto-approve to apply without confirmation, e.g. in CI.
+
+Given a plan saved with "axionctl plan --out", apply plans again and applies the
+changes without confirmation if they are still the ones saved, and fails without
+changes if the manifest or the host state changed since planning.
+
+WARNING: This command makes actual changes to your system.`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			ctx, cancel := runContext()
 			defer cancel()
 
+			if err := validateOutput(outputFormat); err != nil {
+				return err
+			}
+
+			var saved *orchestrator.Plan
+			if len(args) == 1 {
+				if inventoryFile != "" {
+					return errors.New("saved plans can't be applied to inventories")
+				}
+				var err error
+				if saved, err = loadPlan(cmd, args[0]); err != nil {
+					return err
+				}
+			} else if manifestFile == "" {
+				return errors.New(`required flag(s) "manifest" not set`)
+			}
+
+			if !autoApprove && saved == nil {
+				if err := checkApprovable(); err != nil {
+					return err
+				}
+			}
+
+			if inventoryFile != "" {
+				inv, err := loadInventory(cmd.Flags().Changed("endpoint"))
+				if err != nil {
+					return err
+				}
+				return applyInventory(ctx, inv, enableBackups, backupDir, agentBackups, skipReadinessCheck, autoApprove)
+			}
+
 			cfg, err := setupConfig(enableBackups, backupDir, concurrency, endpoint)
 			if err != nil {
 				return err
 			}
+			if agentBackups {
+				cfg.AgentBackups = true
+			}
 
-			o, err := setupOrchestrator(cfg, manifestFile)
+			if err := negotiate(ctx, cfg); err != nil {
+				return err
+			}
+			if !skipReadinessCheck {
+				if err := checkReadiness(ctx, cfg); err != nil {
+					return err
+				}
+			}
+
+			o, err := setupOrchestrator(cfg, manifestFile, endpoint, "")
 			if err != nil {
 				return err
 			}
 
-			summary := o.Run(ctx, false)
+			var summary *orchestrator.Summary
+			if saved != nil {
+				current := o.Run(ctx, true)
+				if current.Error != nil {
+					return current.Error
+				}
+				if !current.Success {
+					return errors.New("plan failed, no changes were applied")
+				}
+				if err := saved.Verify(current); err != nil {
+					return fmt.Errorf("refusing to apply the saved plan: %w", err)
+				}
+				if changeCount(current) == 0 {
+					summary = current
+				}
+			} else if !autoApprove {
+				plan := o.Run(ctx, true)
+				if plan.Error != nil {
+					return plan.Error
+				}
+				if !plan.Success {
+					return errors.New("plan failed, no changes were applied")
+				}
+
+				if changeCount(plan) == 0 {
+					// Nothing to confirm, the plan is the result of the apply
+					fmt.Println("No changes, the system is in the desired state.")
+					summary = plan
+				} else {
+					changes := changeCount(plan)
+					approved, err := confirmApply(os.Stdin, os.Stdout, changes, plan.TotalCount-changes)
+					if err != nil {
+						return err
+					}
+					if !approved {
+						return errNotApproved
+					}
+				}
+			}
+
+			if summary == nil {
+				summary = o.Run(ctx, false)
+			}
+			pushMetrics(ctx, endpoint, summary, false)
+			if err := updateState(endpoint, manifestFile, summary, cfg.Secrets.Redact); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to update state: %s\n", err)
+			}
+			if err := writeJUnit(summary, cfg.Secrets.Redact); err != nil {
+				return err
+			}
+			if err := writeRunReport(summary, false, cfg.Secrets.Redact); err != nil {
+				return err
+			}
+			if outputFormat != "" {
+				if err := printSummary(os.Stdout, outputFormat, summary, false, cfg.Secrets.Redact); err != nil {
+					return err
+				}
+			}
 			if summary.Error != nil {
 				return summary.Error
 			}
+			if !summary.Success {
+				return applyError(summary)
+			}
+			pruneBackups(ctx, cfg)
 
 			return nil
 		},
@@ -133,13 +482,46 @@ Always run 'plan' first to review changes.`,
 			"\n"+
 			"Backups enable automatic rollback if subsequent resources fail during apply.\n"+
 			"Highly recommended for production environments.")
+	cmd.Flags().BoolVar(&autoApprove, "auto-approve", false,
+		"Apply the changes without planning them first and asking for confirmation")
+	cmd.Flags().StringVar(&junitFile, "junit", "",
+		"Write the result as JUnit XML report to this file, with a test case per resource")
+	cmd.Flags().StringVar(&reportFile, "report", "",
+		"Write a report of the run with the diffs, outcomes and durations of the resources to this file, as HTML if it ends with .html and as Markdown otherwise")
 	cmd.Flags().StringVar(&backupDir, "backup-dir", config.DefaultBackupDir(),
 		"Directory to store backups (only used when --enable-backups is set)\n"+
 			"Defaults to $AXION_BACKUP_DIR or ~/.config/axion/backups\n"+
 			"Directory will be created if it doesn't exist")
+	addRetentionFlags(cmd)
+	addBackupStorageFlag(cmd.Flags())
+	addDiscardBackupsFlag(cmd)
+	cmd.Flags().BoolVar(&agentBackups, "agent-backups", false,
+		"Keep backups on the agent instead of transferring them (only used when\n"+
+			"--enable-backups is set and the agent has backups enabled)")
 	cmd.Flags().StringVar(&manifestFile, "manifest", "",
-		"Path to YAML manifest file containing resource definitions (required)")
-	cmd.MarkFlagRequired("manifest")
+		"Path to manifest file (YAML, JSON, Starlark or CUE) containing resource definitions, - reads from stdin (required unless a plan is given)")
+	cmd.RegisterFlagCompletionFunc("manifest", completeManifest)
+	cmd.Flags().StringArrayVar(&variables, "var", nil,
+		"Set a manifest variable (key=value), overrides variables from the manifest and var files")
+	cmd.Flags().StringArrayVar(&variableFiles, "var-file", nil,
+		"Path to YAML file with manifest variables, can be repeated")
+	cmd.Flags().BoolVar(&skipReadinessCheck, "skip-readiness-check", false,
+		"Don't check that the agent is ready before starting")
+	cmd.Flags().DurationVar(&runTimeout, "timeout", 0,
+		"Abort the run if it takes longer, e.g. 15m (default: no limit)")
+	cmd.Flags().StringVar(&outputFormat, "output", "",
+		"Print the summary as json or yaml instead of reporting progress")
+	cmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions([]string{outputJSON, outputYAML}, cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().StringVar(&inventoryFile, "inventory", "",
+		"Path to YAML file listing the agent endpoints (and their variables) to run against instead of --endpoint")
+	cmd.Flags().IntVar(&parallelHosts, "parallel", 1,
+		"Maximum number of inventory hosts to run against at the same time")
+	cmd.Flags().StringArrayVar(&targets, "target", nil,
+		"Only process the resource with this id and its dependencies, can be repeated")
+	cmd.RegisterFlagCompletionFunc("target", completeResourceIDs)
+	cmd.Flags().StringArrayVar(&skips, "skip", nil,
+		"Don't process the resource with this id and the resources depending on it, can be repeated")
+	cmd.RegisterFlagCompletionFunc("skip", completeResourceIDs)
 
 	return cmd
 }
@@ -147,6 +529,7 @@ Always run 'plan' first to review changes.`,
 func setupConfig(enableBackups bool, backupDir string, concurrency int, endpoint string) (*config.Config, error) {
 	cfg := &config.Config{
 		Concurrency: concurrency,
+		Secrets:     secret.NewResolver(),
 	}
 
 	if configFile != "" {
@@ -160,6 +543,54 @@ func setupConfig(enableBackups bool, backupDir string, concurrency int, endpoint
 	}
 
 	// Overrides file config
+	vars, err := parseVariables(context.Background(), cfg.Secrets, variableFiles, variables)
+	if err != nil {
+		return nil, err
+	}
+	if len(vars) > 0 {
+		if cfg.Variables == nil {
+			cfg.Variables = make(map[string]any, len(vars))
+		}
+		for k, v := range vars {
+			cfg.Variables[k] = v
+		}
+	}
+
+	if len(allowedEnv) > 0 {
+		cfg.AllowedEnv = allowedEnv
+	}
+
+	if inferDependencies {
+		cfg.InferDependencies = true
+	}
+
+	if tlsConfig.CertFile != "" {
+		cfg.TLS.CertFile = tlsConfig.CertFile
+	}
+	if tlsConfig.KeyFile != "" {
+		cfg.TLS.KeyFile = tlsConfig.KeyFile
+	}
+	if tlsConfig.CAFile != "" {
+		cfg.TLS.CAFile = tlsConfig.CAFile
+	}
+	if tlsConfig.ServerName != "" {
+		cfg.TLS.ServerName = tlsConfig.ServerName
+	}
+	if tlsConfig.InsecureSkipVerify {
+		cfg.TLS.InsecureSkipVerify = true
+	}
+	if tokenFile != "" {
+		cfg.TokenFile = tokenFile
+	}
+	if token != "" {
+		cfg.BearerToken = token
+	} else if env := os.Getenv(config.TokenEnvVar); env != "" {
+		cfg.BearerToken = env
+	}
+	if signingSecretFile != "" {
+		cfg.SigningSecretFile = signingSecretFile
+	}
+
 	if enableBackups {
 		cfg.EnableBackups = true
 	}
@@ -170,6 +601,10 @@ func setupConfig(enableBackups bool, backupDir string, concurrency int, endpoint
 		cfg.BackupDir = config.DefaultBackupDir()
 	}
 
+	if err := setupBackups(cfg, endpoint); err != nil {
+		return nil, err
+	}
+
 	// Validate backup directory
 	if cfg.EnableBackups {
 		if err := config.ValidateBackupDir(cfg.BackupDir); err != nil {
@@ -182,6 +617,18 @@ func setupConfig(enableBackups bool, backupDir string, concurrency int, endpoint
 		return nil, fmt.Errorf("invalid endpoint URL: %w", err)
 	}
 
+	token, err := cfg.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	var signingSecret []byte
+	if cfg.SigningSecretFile != "" {
+		if signingSecret, err = signing.LoadSecret(cfg.SigningSecretFile); err != nil {
+			return nil, err
+		}
+	}
+
 	scheme := u.Scheme
 	if scheme == "" {
 		scheme = "https"
@@ -192,54 +639,136 @@ func setupConfig(enableBackups bool, backupDir string, concurrency int, endpoint
 		return nil, fmt.Errorf("invalid endpoint: missing host in %q", endpoint)
 	}
 
-	cfg.Client = client.NewHTTPClientWithConfig(nil, &client.TransportConfig{
-		Host:     host,
-		BasePath: "/api/v1",
-		Schemes:  []string{scheme},
-	})
+	// grpc:// and grpcs:// select the gRPC transport, plain and TLS encrypted
+	if scheme == "grpc" || scheme == "grpcs" {
+		if signingSecret != nil {
+			return nil, fmt.Errorf("request signing is not supported over gRPC, use client certificates")
+		}
+		var tlsCfg *tls.Config
+		if scheme == "grpcs" {
+			if tlsCfg, err = cfg.TLS.ClientConfig(); err != nil {
+				return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+			}
+		}
+		transport, err := agentgrpc.NewTransport(host, tlsCfg, token)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Client = client.New(loggingTransport{transport}, strfmt.Default)
+		return cfg, nil
+	}
+
+	rt := http.DefaultTransport
+	if cfg.TLS != (config.TLSConfig{}) {
+		rt, err = httptransport.TLSTransport(httptransport.TLSClientOptions{
+			Certificate:        cfg.TLS.CertFile,
+			Key:                cfg.TLS.KeyFile,
+			CA:                 cfg.TLS.CAFile,
+			ServerName:         cfg.TLS.ServerName,
+			InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+	}
+	rt = withRequestTimeout(rt, requestTimeout)
+	if signingSecret != nil {
+		rt = &signing.Transport{Base: rt, Secret: signingSecret}
+	}
+	rt = loggingRoundTripper{Base: rt}
+	transport := httptransport.NewWithClient(host, "/api/v1", []string{scheme}, &http.Client{Transport: rt})
+	transport.Consumers["text/event-stream"] = runtime.ByteStreamConsumer()
+	if token != "" {
+		transport.DefaultAuthentication = httptransport.BearerToken(token)
+	}
+	// Calls within a traced run get a client span and pass the trace context on
+	cfg.Client = client.New(loggingTransport{transport.WithOpenTelemetry()}, strfmt.Default)
 
 	return cfg, nil
 }
 
-func setupOrchestrator(cfg *config.Config, manifestFile string) (*orchestrator.Orchestrator, error) {
-	opts := []orchestrator.Option{}
+// setupOrchestrator loads the manifest into a new orchestrator with the state last
+// applied to endpoint, host prefixes the progress report in runs against multiple hosts
+func setupOrchestrator(cfg *config.Config, manifestFile, endpoint, host string) (*orchestrator.Orchestrator, error) {
+	opts := []orchestrator.Option{
+		orchestrator.WithReporter(report.NewRedactingReporter(newReporter(host), cfg.Secrets.Redact)),
+	}
 	if cfg.EnableBackups {
 		opts = append(opts, orchestrator.WithEnableBackups())
 	}
+	// The backups of destroyed resources are all that is left of them
+	if cfg.EnableBackups && cfg.DiscardBackups && !destroying {
+		opts = append(opts, orchestrator.WithDiscardBackups())
+	}
 	if cfg.Concurrency > 1 {
 		opts = append(opts, orchestrator.WithConcurrency(cfg.Concurrency))
 	}
-	o := orchestrator.NewOrchestrator(opts...)
-
-	var resources []orchestrator.ResourceSpec
-	var err error
+	if cfg.InferDependencies {
+		opts = append(opts, orchestrator.WithInferDependencies())
+	}
+	if len(targets) > 0 {
+		opts = append(opts, orchestrator.WithTargets(targets...))
+	}
+	if len(skips) > 0 {
+		opts = append(opts, orchestrator.WithSkip(skips...))
+	}
+	if destroying {
+		opts = append(opts, orchestrator.WithDestroy())
+	}
+	if applied := appliedResources(endpoint); applied != nil {
+		opts = append(opts, orchestrator.WithApplied(applied))
+	}
 
-	var loader manifest.Loader
+	data, err := manifest.Read(manifestFile)
+	if err != nil {
+		return nil, manifestError(fmt.Errorf("failed to read manifest: %w", err))
+	}
 
-	switch strings.ToLower(filepath.Ext(manifestFile)) {
-	case ".yaml", ".yml":
-		loader = &manifestyaml.Loader{}
-	case ".json":
-	case ".star":
-		loader = &manifeststarlark.Loader{}
-	default:
-		return nil, fmt.Errorf("unsupported manifest file extension: %s", manifestFile)
+	loader, err := newLoader(manifestFile)
+	if err != nil {
+		return nil, manifestError(err)
 	}
 
-	resources, err = loader.Load(context.Background(), cfg, manifestFile)
+	resources, err := loader.Load(context.Background(), cfg, manifestFile)
 	if err != nil {
-		return nil, err
+		return nil, manifestError(err)
 	}
+	opts = append(opts, orchestrator.WithManifestDigest(manifest.Digest(data, cfg.Variables, resources)))
 
+	o := orchestrator.NewOrchestrator(opts...)
 	for _, r := range resources {
 		if err := o.Add(r); err != nil {
-			return nil, fmt.Errorf("failed to add resource %q: %w", r.Resource.Name(), err)
+			return nil, manifestError(fmt.Errorf("failed to add resource %q: %w", r.Resource.Name(), err))
 		}
 	}
 
 	return o, nil
 }
 
+// newLoader returns the manifest loader for the format of the manifest at path
+func newLoader(path string) (manifest.Loader, error) {
+	data, err := manifest.Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	format, err := manifest.DetectFormat(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case manifest.FormatYAML, manifest.FormatJSON:
+		return &manifestyaml.Loader{}, nil
+	case manifest.FormatStarlark:
+		return &manifeststarlark.Loader{}, nil
+	case manifest.FormatCUE:
+		return &manifestcue.Loader{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported manifest format %q", format)
+	}
+}
+
 func prettifyError(err error) string {
 	// Traverse wrapped errors and build a list
 	type unwrapper interface {
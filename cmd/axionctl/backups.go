@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"peertech.de/axion/pkg/backup"
+	"peertech.de/axion/pkg/config"
+)
+
+var backupKeepLast int
+var backupMaxAge time.Duration
+var backupMaxSize string
+var backupStorage string
+var discardBackups bool
+
+// addRetentionFlags adds the flags limiting the backups kept in the backup directory
+func addRetentionFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&backupKeepLast, "backup-keep-last", 0,
+		"Number of backups kept per resource, including the current one (default: 3 unless another limit is set)")
+	cmd.Flags().DurationVar(&backupMaxAge, "backup-max-age", 0,
+		"Remove backups taken longer ago, e.g. 720h (default: no limit)")
+	cmd.Flags().StringVar(&backupMaxSize, "backup-max-size", "",
+		"Total size of the backups, e.g. 500MB, the oldest are removed beyond it (default: no limit)")
+}
+
+// addBackupStorageFlag adds the flag of the remote storage backups are copied to
+func addBackupStorageFlag(flags *pflag.FlagSet) {
+	flags.StringVar(&backupStorage, "backup-storage", "",
+		"Copy the backups to this remote storage: s3://bucket/prefix (credentials from $AWS_*),\n"+
+			"sftp://user@host/path (keys of the SSH agent) or a local directory, e.g. a network share")
+}
+
+// addDiscardBackupsFlag adds the flag removing the backups of successful runs
+func addDiscardBackupsFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&discardBackups, "discard-backups", false,
+		"Remove the backups taken by the run once all changes are applied, they are only\n"+
+			"kept if the run fails")
+}
+
+// setupBackups applies the retention and storage flags to the config file, without any
+// retention limit the backup.DefaultPolicy applies. The remote storage is only set up if
+// backups are enabled, the backups of each endpoint are kept apart in it.
+func setupBackups(cfg *config.Config, endpoint string) error {
+	if backupStorage != "" {
+		cfg.BackupStorage = backupStorage
+	}
+	if discardBackups {
+		cfg.DiscardBackups = true
+	}
+	if cfg.EnableBackups && cfg.BackupStorage != "" {
+		s, err := backup.Open(cfg.BackupStorage)
+		if err != nil {
+			return err
+		}
+		cfg.RemoteBackups = backup.WithPrefix(s, backupPrefix(endpoint))
+	}
+
+	if backupKeepLast < 0 {
+		return fmt.Errorf("--backup-keep-last must not be negative")
+	}
+	if backupKeepLast > 0 {
+		cfg.BackupRetention.KeepLast = backupKeepLast
+	}
+	if backupMaxAge > 0 {
+		cfg.BackupRetention.MaxAge = backupMaxAge
+	}
+	if backupMaxSize != "" {
+		size, err := backup.ParseSize(backupMaxSize)
+		if err != nil {
+			return fmt.Errorf("invalid --backup-max-size: %w", err)
+		}
+		cfg.BackupRetention.MaxSize = size
+	}
+
+	if cfg.BackupRetention.IsZero() {
+		cfg.BackupRetention = backup.DefaultPolicy
+	}
+	return nil
+}
+
+// backupPrefix returns the prefix of the backups of the endpoint in the remote storage
+func backupPrefix(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "local"
+	}
+	return u.Host
+}
+
+// pruneBackups removes the backups exceeding the retention after a successful run, if
+// backups are enabled. Failing to prune doesn't fail the run.
+func pruneBackups(ctx context.Context, cfg *config.Config) {
+	if !cfg.EnableBackups {
+		return
+	}
+	for _, s := range backupStorages(cfg) {
+		if _, err := backup.Prune(ctx, s, cfg.BackupRetention, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to prune backups in %s: %s\n", s, err)
+		}
+	}
+}
+
+// backupStorages returns the backup directory and the remote storage, if configured
+func backupStorages(cfg *config.Config) []backup.Storage {
+	storages := []backup.Storage{backup.Dir(cfg.BackupDir)}
+	if cfg.RemoteBackups != nil {
+		storages = append(storages, cfg.RemoteBackups)
+	}
+	return storages
+}
+
+func cmdBackups() *cobra.Command {
+	var dir string
+
+	cmd := &cobra.Command{
+		Use:   "backups",
+		Short: "Inspect and prune the backups taken before changing resources",
+		Long: `Backups manages the backups apply and destroy take with --enable-backups in
+--backup-dir, and copy to --backup-storage. Each resource has a current backup, which
+rollbacks restore, and the backups it superseded as versions. After successful runs,
+the backups exceeding the retention (--backup-keep-last, --backup-max-age and
+--backup-max-size) are pruned.
+
+Given --backup-storage, the backups commands work on the remote storage, with the
+backups of all endpoints, instead of the backup directory.`,
+	}
+
+	cmd.PersistentFlags().StringVar(&dir, "backup-dir", config.DefaultBackupDir(),
+		"Directory the backups are stored in\n"+
+			"Defaults to $AXION_BACKUP_DIR or ~/.config/axion/backups")
+	addBackupStorageFlag(cmd.PersistentFlags())
+
+	cmd.AddCommand(cmdBackupsList(&dir))
+	cmd.AddCommand(cmdBackupsPrune(&dir))
+
+	return cmd
+}
+
+func cmdBackupsList(dir *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the backups, the newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := setupConfig(false, *dir, concurrency, endpoint)
+			if err != nil {
+				return err
+			}
+			s, err := openBackups(cfg)
+			if err != nil {
+				return err
+			}
+			backups, err := backup.List(cmd.Context(), s)
+			if err != nil {
+				return err
+			}
+			printBackups(backups)
+			return nil
+		},
+	}
+}
+
+func cmdBackupsPrune(dir *string) *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove the backups exceeding the retention",
+		Long: `Prune removes the backups exceeding the retention given by --backup-keep-last,
+--backup-max-age and --backup-max-size, or configured in the config file. Without
+any limit, the last 3 backups of each resource are kept.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := setupConfig(false, *dir, concurrency, endpoint)
+			if err != nil {
+				return err
+			}
+
+			s, err := openBackups(cfg)
+			if err != nil {
+				return err
+			}
+
+			var expired []backup.Backup
+			if dryRun {
+				backups, err := backup.List(cmd.Context(), s)
+				if err != nil {
+					return err
+				}
+				expired = cfg.BackupRetention.Expired(backups, time.Now())
+			} else if expired, err = backup.Prune(cmd.Context(), s, cfg.BackupRetention, time.Now()); err != nil {
+				return err
+			}
+
+			printBackups(expired)
+			var size int64
+			for _, b := range expired {
+				size += b.Size
+			}
+			verb := "Removed"
+			if dryRun {
+				verb = "Would remove"
+			}
+			fmt.Printf("\n%s %d backup(s), %d bytes\n", verb, len(expired), size)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		"Only list the backups which would be removed")
+	addRetentionFlags(cmd)
+
+	return cmd
+}
+
+// openBackups returns the storage the backups commands work on, the remote storage of
+// all endpoints if configured and the backup directory otherwise
+func openBackups(cfg *config.Config) (backup.Storage, error) {
+	if cfg.BackupStorage == "" {
+		return backup.Dir(cfg.BackupDir), nil
+	}
+	return backup.Open(cfg.BackupStorage)
+}
+
+// printBackups writes the backups to stdout as table
+func printBackups(backups []backup.Backup) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESOURCE\tVERSION\tSIZE\tTAKEN")
+	for _, b := range backups {
+		version := "current"
+		if !b.Current {
+			version = "superseded"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", b.Resource, version, b.Size, b.Time.Format(time.RFC3339))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func cmdCompletion() *cobra.Command {
+	return &cobra.Command{
+		Use:   "completion bash|zsh|fish",
+		Short: "Generate the shell completion script",
+		Long: `Completion prints the completion script for the shell. Resource IDs are
+completed from the manifest given with --manifest.
+
+  bash: source <(axionctl completion bash)
+  zsh:  axionctl completion zsh > "${fpath[1]}/_axionctl"
+  fish: axionctl completion fish > ~/.config/fish/completions/axionctl.fish`,
+		Args:                  cobra.ExactArgs(1),
+		ValidArgs:             []string{"bash", "zsh", "fish"},
+		DisableFlagsInUseLine: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := cmd.Root()
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletionV2(os.Stdout, true)
+			case "zsh":
+				return root.GenZshCompletion(os.Stdout)
+			case "fish":
+				return root.GenFishCompletion(os.Stdout, true)
+			}
+			return fmt.Errorf("unsupported shell %q: must be bash, zsh or fish", args[0])
+		},
+	}
+}
+
+// completeManifest completes the --manifest flag with files of the supported formats
+func completeManifest(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{"yaml", "yml", "json", "star", "cue"}, cobra.ShellCompDirectiveFilterFileExt
+}
+
+// completeResourceIDs completes the IDs of the resources in the manifest given with
+// --manifest, nothing is completed if it can't be loaded
+func completeResourceIDs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if manifestFile == "" {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	cfg, err := setupConfig(false, "", concurrency, endpoint)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	loader, err := newLoader(manifestFile)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	specs, err := loader.Load(context.Background(), cfg, manifestFile)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var ids []string
+	for _, rs := range specs {
+		if strings.HasPrefix(rs.Id, toComplete) {
+			ids = append(ids, rs.Id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"peertech.de/axion/pkg/config"
+)
+
+// applyContext fills the connection flags of cmd which weren't given from the context
+// selected with --context, or the current context
+func applyContext(cmd *cobra.Command) error {
+	contexts, err := config.LoadContexts(config.DefaultContextsFile())
+	if err != nil {
+		return err
+	}
+
+	name := contextName
+	if name == "" {
+		name = contexts.Current
+	}
+	if name == "" {
+		return nil
+	}
+	c, err := contexts.Get(name)
+	if err != nil {
+		return err
+	}
+
+	set := func(flag, value string) error {
+		f := cmd.Flags().Lookup(flag)
+		if f == nil || f.Changed || value == "" {
+			return nil
+		}
+		return cmd.Flags().Set(flag, value)
+	}
+
+	// An inventory names the endpoints itself
+	if !cmd.Flags().Changed("inventory") {
+		if err := set("endpoint", c.Endpoint); err != nil {
+			return err
+		}
+	}
+	for flag, value := range map[string]string{
+		"tls-cert":   c.TLS.CertFile,
+		"tls-key":    c.TLS.KeyFile,
+		"tls-ca":     c.TLS.CAFile,
+		"token-file": c.TokenFile,
+		"backup-dir": c.BackupDir,
+	} {
+		if err := set(flag, value); err != nil {
+			return err
+		}
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = c.TLS.ServerName
+	}
+	if c.TLS.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return nil
+}
+
+func cmdConfig() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage the contexts selecting the agent to talk to",
+		Long: `Config manages named contexts holding the endpoint, TLS settings, token and
+backup directory of an agent, stored in $AXION_CONFIG or ~/.config/axion/config.
+The current context, or the one selected with --context, provides the settings not
+given as flags.`,
+		// The contexts are managed, not applied
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+	}
+
+	cmd.AddCommand(cmdGetContexts())
+	cmd.AddCommand(cmdCurrentContext())
+	cmd.AddCommand(cmdUseContext())
+	cmd.AddCommand(cmdSetContext())
+	cmd.AddCommand(cmdDeleteContext())
+
+	return cmd
+}
+
+func cmdGetContexts() *cobra.Command {
+	return &cobra.Command{
+		Use:   "get-contexts",
+		Short: "List the contexts, the current one is marked with *",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			contexts, err := config.LoadContexts(config.DefaultContextsFile())
+			if err != nil {
+				return err
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "CURRENT\tNAME\tENDPOINT")
+			for _, name := range contexts.Names() {
+				current := ""
+				if name == contexts.Current {
+					current = "*"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", current, name, contexts.Contexts[name].Endpoint)
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+func cmdCurrentContext() *cobra.Command {
+	return &cobra.Command{
+		Use:   "current-context",
+		Short: "Print the name of the current context",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			contexts, err := config.LoadContexts(config.DefaultContextsFile())
+			if err != nil {
+				return err
+			}
+			if contexts.Current == "" {
+				return fmt.Errorf("no current context is set")
+			}
+			fmt.Println(contexts.Current)
+			return nil
+		},
+	}
+}
+
+func cmdUseContext() *cobra.Command {
+	return &cobra.Command{
+		Use:               "use-context NAME",
+		Short:             "Make a context the current context",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeContexts,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := config.DefaultContextsFile()
+			contexts, err := config.LoadContexts(path)
+			if err != nil {
+				return err
+			}
+			if _, err := contexts.Get(args[0]); err != nil {
+				return err
+			}
+
+			contexts.Current = args[0]
+			if err := contexts.Save(path); err != nil {
+				return fmt.Errorf("failed to save contexts: %w", err)
+			}
+			fmt.Printf("Switched to context %q\n", args[0])
+			return nil
+		},
+	}
+}
+
+func cmdSetContext() *cobra.Command {
+	var (
+		serverName string
+		backupDir  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "set-context NAME",
+		Short: "Create a context or update the settings of a context",
+		Long: `Set-context stores the connection flags given (--endpoint, --tls-cert,
+--tls-key, --tls-ca, --insecure, --token-file) and --tls-server-name and --backup-dir
+in the context, settings not given are kept.
+
+  axionctl config set-context prod --endpoint https://prod:8080 --token-file ~/.axion/prod.token`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeContexts,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := config.DefaultContextsFile()
+			contexts, err := config.LoadContexts(path)
+			if err != nil {
+				return err
+			}
+
+			c := contexts.Contexts[args[0]]
+			flags := cmd.Flags()
+			if flags.Changed("endpoint") {
+				c.Endpoint = endpoint
+			}
+			if flags.Changed("tls-cert") {
+				c.TLS.CertFile = tlsConfig.CertFile
+			}
+			if flags.Changed("tls-key") {
+				c.TLS.KeyFile = tlsConfig.KeyFile
+			}
+			if flags.Changed("tls-ca") {
+				c.TLS.CAFile = tlsConfig.CAFile
+			}
+			if flags.Changed("insecure") {
+				c.TLS.InsecureSkipVerify = tlsConfig.InsecureSkipVerify
+			}
+			if flags.Changed("tls-server-name") {
+				c.TLS.ServerName = serverName
+			}
+			if flags.Changed("token-file") {
+				c.TokenFile = tokenFile
+			}
+			if flags.Changed("backup-dir") {
+				c.BackupDir = backupDir
+			}
+
+			if contexts.Contexts == nil {
+				contexts.Contexts = make(map[string]config.Context)
+			}
+			contexts.Contexts[args[0]] = c
+			if contexts.Current == "" {
+				contexts.Current = args[0]
+			}
+			if err := contexts.Save(path); err != nil {
+				return fmt.Errorf("failed to save contexts: %w", err)
+			}
+			fmt.Printf("Context %q saved\n", args[0])
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&serverName, "tls-server-name", "",
+		"Name the agent certificate is verified against (default: the endpoint host)")
+	cmd.Flags().StringVar(&backupDir, "backup-dir", "",
+		"Directory to store backups of apply --enable-backups in")
+
+	return cmd
+}
+
+func cmdDeleteContext() *cobra.Command {
+	return &cobra.Command{
+		Use:               "delete-context NAME",
+		Short:             "Delete a context",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeContexts,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := config.DefaultContextsFile()
+			contexts, err := config.LoadContexts(path)
+			if err != nil {
+				return err
+			}
+			if _, err := contexts.Get(args[0]); err != nil {
+				return err
+			}
+
+			delete(contexts.Contexts, args[0])
+			if contexts.Current == args[0] {
+				contexts.Current = ""
+			}
+			if err := contexts.Save(path); err != nil {
+				return fmt.Errorf("failed to save contexts: %w", err)
+			}
+			fmt.Printf("Context %q deleted\n", args[0])
+			return nil
+		},
+	}
+}
+
+// completeContexts completes the names of the contexts
+func completeContexts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	contexts, err := config.LoadContexts(config.DefaultContextsFile())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return contexts.Names(), cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"peertech.de/axion/pkg/resource"
+)
+
+// remotePrefix marks the paths of cp on the agent
+const remotePrefix = "agent:"
+
+func cmdCp() *cobra.Command {
+	return &cobra.Command{
+		Use:   "cp <src> <dst>",
+		Short: "Copy files and directories to or from the endpoint",
+		Long: `Cp copies a file or directory between the local machine and the endpoint
+without a manifest. The path on the endpoint is prefixed with agent:
+
+  axionctl cp agent:/etc/nginx ./backup
+  axionctl cp ./nginx.conf agent:/etc/nginx/nginx.conf
+
+Like cp, the source is copied into the destination if that is an existing
+directory. Directories copied to the endpoint replace the directory there as a
+whole. The transfer progress is shown on terminals unless --quiet is given.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			src, srcRemote := strings.CutPrefix(args[0], remotePrefix)
+			dst, dstRemote := strings.CutPrefix(args[1], remotePrefix)
+			if srcRemote == dstRemote {
+				return errors.New("exactly one of source and destination must be on the agent (agent:/path)")
+			}
+
+			cfg, err := setupConfig(false, "", 1, endpoint)
+			if err != nil {
+				return err
+			}
+			if err := negotiate(ctx, cfg); err != nil {
+				return err
+			}
+
+			var progress resource.ProgressFunc
+			if !quiet && isTerminal(os.Stderr) {
+				p := &transferProgress{name: args[0]}
+				defer p.done()
+				progress = p.update
+			}
+
+			var copied string
+			if srcRemote {
+				copied, err = resource.Download(ctx, cfg, src, dst, progress)
+			} else {
+				copied, err = resource.Upload(ctx, cfg, src, dst, progress)
+			}
+			if err != nil {
+				return err
+			}
+
+			if dstRemote {
+				copied = remotePrefix + copied
+			}
+			fmt.Printf("Copied %s to %s\n", args[0], copied)
+			return nil
+		},
+	}
+}
+
+// transferProgress renders the progress of a transfer on a single line of stderr
+type transferProgress struct {
+	name    string
+	last    time.Time
+	printed bool
+}
+
+func (p *transferProgress) update(transferred, total int64) {
+	// Redrawing on every write would flood slow terminals
+	if time.Since(p.last) < 100*time.Millisecond && transferred != total {
+		return
+	}
+	p.last = time.Now()
+	p.printed = true
+
+	if total < 0 {
+		fmt.Fprintf(os.Stderr, "\r%s: %s", p.name, formatBytes(transferred))
+		return
+	}
+	percent := 100 * transferred / max(total, 1)
+	fmt.Fprintf(os.Stderr, "\r%s: %s / %s (%d%%)", p.name, formatBytes(transferred), formatBytes(total), percent)
+}
+
+// done ends the progress line
+func (p *transferProgress) done() {
+	if p.printed {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// formatBytes formats n bytes with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/orchestrator"
+	"peertech.de/axion/pkg/resource"
+)
+
+// destroying makes setupOrchestrator remove the resources of the manifest
+var destroying bool
+
+func cmdDestroy() *cobra.Command {
+	var (
+		enableBackups      bool
+		backupDir          string
+		skipReadinessCheck bool
+		autoApprove        bool
+		planOnly           bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "destroy",
+		Short: "Remove the files, directories, symlinks and packages of the manifest",
+		Long: `Destroy removes the resources of the manifest from the system: files,
+directories and symlinks are deleted and packages are removed, each after the
+resources depending on it. Commands, scripts and services are left alone.
+
+Destroy plans first, showing the resources which would be deleted with the size of
+their content and the resources depending on them, and asks for confirmation unless
+--auto-approve is given. With --plan, it stops after showing the plan.
+
+WARNING: Directories are deleted with everything in them.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := runContext()
+			defer cancel()
+
+			if !planOnly && !autoApprove {
+				if err := checkApprovable(); err != nil {
+					return err
+				}
+			}
+
+			cfg, err := setupConfig(enableBackups, backupDir, concurrency, endpoint)
+			if err != nil {
+				return err
+			}
+			if err := negotiate(ctx, cfg); err != nil {
+				return err
+			}
+			if !skipReadinessCheck {
+				if err := checkReadiness(ctx, cfg); err != nil {
+					return err
+				}
+			}
+
+			destroying = true
+			o, err := setupOrchestrator(cfg, manifestFile, endpoint, "")
+			if err != nil {
+				return err
+			}
+
+			plan := o.Run(ctx, true)
+			if plan.Error != nil {
+				return plan.Error
+			}
+			if !plan.Success {
+				return errors.New("plan failed, nothing was destroyed")
+			}
+
+			deletions := changeCount(plan)
+			if deletions == 0 {
+				fmt.Println("Nothing to destroy, none of the resources exist.")
+				return nil
+			}
+			printDestroyPlan(ctx, cfg, o, plan)
+			if planOnly {
+				return nil
+			}
+
+			if !autoApprove {
+				approved, err := confirmApply(os.Stdin, os.Stdout, deletions, plan.TotalCount-deletions)
+				if err != nil {
+					return err
+				}
+				if !approved {
+					return errNotApproved
+				}
+			}
+
+			summary := o.Run(ctx, false)
+			if err := updateState(endpoint, manifestFile, summary, cfg.Secrets.Redact); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to update state: %s\n", err)
+			}
+			if summary.Error != nil {
+				return summary.Error
+			}
+			if !summary.Success {
+				return applyError(summary)
+			}
+			pruneBackups(ctx, cfg)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&manifestFile, "manifest", "",
+		"Path to manifest file (YAML, JSON, Starlark or CUE) containing resource definitions, - reads from stdin (required)")
+	cmd.MarkFlagRequired("manifest")
+	cmd.RegisterFlagCompletionFunc("manifest", completeManifest)
+	cmd.Flags().StringArrayVar(&variables, "var", nil,
+		"Set a manifest variable (key=value), overrides variables from the manifest and var files")
+	cmd.Flags().StringArrayVar(&variableFiles, "var-file", nil,
+		"Path to YAML file with manifest variables, can be repeated")
+	cmd.Flags().BoolVar(&planOnly, "plan", false,
+		"Only show what would be destroyed")
+	cmd.Flags().BoolVar(&autoApprove, "auto-approve", false,
+		"Destroy the resources without asking for confirmation")
+	cmd.Flags().BoolVar(&enableBackups, "enable-backups", false,
+		"Back up the resources before deleting them, they are restored if destroying another one fails")
+	cmd.Flags().StringVar(&backupDir, "backup-dir", config.DefaultBackupDir(),
+		"Directory to store backups (only used when --enable-backups is set)")
+	addRetentionFlags(cmd)
+	addBackupStorageFlag(cmd.Flags())
+	cmd.Flags().BoolVar(&skipReadinessCheck, "skip-readiness-check", false,
+		"Don't check that the agent is ready before starting")
+	cmd.Flags().DurationVar(&runTimeout, "timeout", 0,
+		"Abort the run if it takes longer, e.g. 15m (default: no limit)")
+	cmd.Flags().StringArrayVar(&targets, "target", nil,
+		"Only destroy the resource with this id and the resources depending on it, can be repeated")
+	cmd.RegisterFlagCompletionFunc("target", completeResourceIDs)
+	cmd.Flags().StringArrayVar(&skips, "skip", nil,
+		"Don't destroy the resource with this id and its dependencies, can be repeated")
+	cmd.RegisterFlagCompletionFunc("skip", completeResourceIDs)
+
+	return cmd
+}
+
+// printDestroyPlan prints the resources the plan in summary deletes, with the size of
+// the content of files and directories and the resources depending on them
+func printDestroyPlan(ctx context.Context, cfg *config.Config, o *orchestrator.Orchestrator, summary *orchestrator.Summary) {
+	fmt.Println("\nResources to destroy:")
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tRESOURCE\tSIZE\tDEPENDENTS")
+	for _, id := range summary.Order {
+		a := summary.Attempts[id]
+		if a == nil || !a.NeedsApply {
+			continue
+		}
+
+		size := "-"
+		kind, p, _ := strings.Cut(a.Name, ":")
+		if kind == "file" || kind == "directory" {
+			if n, err := resource.Size(ctx, cfg, p, kind == "directory"); err == nil {
+				size = formatBytes(n)
+			} else {
+				size = "unknown"
+				fmt.Fprintf(os.Stderr, "Warning: failed to determine the size of %s: %s\n", p, prettifyError(err))
+			}
+		}
+
+		dependents := strings.Join(o.Dependents(id), ", ")
+		if dependents == "" {
+			dependents = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", a.Id, cfg.Secrets.Redact(a.Name), size, dependents)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"peertech.de/axion/pkg/orchestrator"
+)
+
+func cmdDrift() *cobra.Command {
+	var (
+		skipReadinessCheck bool
+		driftExitCode      bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "drift",
+		Short: "Report resources which drifted since the last apply",
+		Long: `Drift evaluates every resource of the manifest the endpoint was last converged
+with, see last-applied, and only reports the resources whose state differs from it,
+with their diff. Nothing is changed. --manifest checks another manifest instead.
+Resources whose specification changed since they were applied are reported as
+pending changes rather than drift, see "axionctl state".
+
+With --exit-code, drift exits with 0 if there is no drift, 2 if resources drifted
+and 1 on errors, e.g. for monitoring cron jobs.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := runContext()
+			defer cancel()
+
+			if err := validateOutput(outputFormat); err != nil {
+				return err
+			}
+
+			applied, err := lastApplied(endpoint)
+			if err != nil && manifestFile == "" {
+				return fmt.Errorf("%w, drift requires --manifest", err)
+			}
+			if manifestFile == "" {
+				manifestFile = applied.Manifest
+			}
+
+			// Resources without drift are left out
+			quiet = true
+
+			cfg, summary, err := runManifest(ctx, true, skipReadinessCheck)
+			if err != nil {
+				return err
+			}
+			if outputFormat != "" {
+				if err := printSummary(os.Stdout, outputFormat, summary, true, cfg.Secrets.Redact); err != nil {
+					return err
+				}
+			}
+			if !summary.Success {
+				return errors.New("drift check failed")
+			}
+
+			// Resources classified by the state are told apart from pending changes
+			if applied != nil && applied.Digest != summary.ManifestDigest && summary.DriftCounts().Total() == 0 {
+				fmt.Fprintf(os.Stderr, "Warning: the manifest changed since it was applied at %s, differences may be pending changes instead of drift\n",
+					applied.AppliedAt.Format(time.RFC3339))
+			}
+
+			drifted := driftedAttempts(summary)
+			if outputFormat == "" {
+				printDrift(summary, drifted)
+			}
+			if driftExitCode && len(drifted) > 0 {
+				return exitCode(2)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&manifestFile, "manifest", "",
+		"Path to manifest file (YAML, JSON, Starlark or CUE) to check instead of the one last applied")
+	cmd.RegisterFlagCompletionFunc("manifest", completeManifest)
+	cmd.Flags().StringArrayVar(&variables, "var", nil,
+		"Set a manifest variable (key=value), overrides variables from the manifest and var files")
+	cmd.Flags().StringArrayVar(&variableFiles, "var-file", nil,
+		"Path to YAML file with manifest variables, can be repeated")
+	cmd.Flags().BoolVar(&skipReadinessCheck, "skip-readiness-check", false,
+		"Don't check that the agent is ready before starting")
+	cmd.Flags().BoolVar(&driftExitCode, "exit-code", false,
+		"Exit with 2 if resources drifted")
+	cmd.Flags().StringVar(&outputFormat, "output", "",
+		"Print the summary as json or yaml instead of reporting drift")
+	cmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions([]string{outputJSON, outputYAML}, cobra.ShellCompDirectiveNoFileComp))
+
+	return cmd
+}
+
+// driftedAttempts returns the attempts of the resources which drifted, in order.
+// Resources the state doesn't classify, e.g. applied by earlier versions, drifted if
+// they need changes.
+func driftedAttempts(summary *orchestrator.Summary) []*orchestrator.Attempt {
+	var drifted []*orchestrator.Attempt
+	for _, id := range summary.Order {
+		a := summary.Attempts[id]
+		if a.Drift == orchestrator.DriftDrifted || (a.Drift == "" && a.NeedsApply) {
+			drifted = append(drifted, a)
+		}
+	}
+	return drifted
+}
+
+// printDrift prints the drifted resources of summary, and the pending changes of
+// resources changed in the manifest
+func printDrift(summary *orchestrator.Summary, drifted []*orchestrator.Attempt) {
+	if len(drifted) == 0 {
+		fmt.Printf("\nNo drift in any of the %d resources.\n", summary.TotalCount)
+	} else {
+		fmt.Printf("\nDrift detected in %d of %d resources:\n", len(drifted), summary.TotalCount)
+		for _, a := range drifted {
+			fmt.Printf("  %s (%s)\n", a.Id, a.Name)
+		}
+	}
+
+	var pending []*orchestrator.Attempt
+	for _, id := range summary.Order {
+		if a := summary.Attempts[id]; a.Drift == orchestrator.DriftChangedInManifest && a.NeedsApply {
+			pending = append(pending, a)
+		}
+	}
+	if len(pending) > 0 {
+		fmt.Printf("\nChanged in the manifest since applied, not drift, %d resources:\n", len(pending))
+		for _, a := range pending {
+			fmt.Printf("  %s (%s)\n", a.Id, a.Name)
+		}
+	}
+}
+
+// printPlanDrift prints the resources of the plan in summary by drift, if the state
+// classifies them, with the drifted ones
+func printPlanDrift(summary *orchestrator.Summary) {
+	c := summary.DriftCounts()
+	if c.Total() == 0 {
+		return
+	}
+
+	fmt.Printf("\nSince the last apply: %d drifted, %d changed in manifest, %d in sync.\n", c.Drifted, c.ChangedInManifest, c.InSync)
+	for _, id := range summary.Order {
+		if a := summary.Attempts[id]; a.Drift == orchestrator.DriftDrifted {
+			fmt.Printf("  drifted: %s (%s)\n", a.Id, a.Name)
+		}
+	}
+}
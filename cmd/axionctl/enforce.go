@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/manifest"
+	"peertech.de/axion/pkg/orchestrator"
+)
+
+// webhookTimeout bounds the delivery of a notification
+const webhookTimeout = 10 * time.Second
+
+// Events notified to the --webhook of enforce
+const (
+	enforceDrift   = "drift"
+	enforceFailure = "failure"
+)
+
+// enforceNotification is posted to the --webhook of enforce
+type enforceNotification struct {
+	Event    string         `json:"event"`
+	Endpoint string         `json:"endpoint"`
+	Manifest string         `json:"manifest"`
+	Time     time.Time      `json:"time"`
+	Error    string         `json:"error,omitempty"`
+	Summary  *summaryOutput `json:"summary,omitempty"`
+}
+
+func cmdEnforce() *cobra.Command {
+	var (
+		interval           time.Duration
+		jitter             float64
+		maxBackoff         time.Duration
+		webhook            string
+		skipReadinessCheck bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "enforce",
+		Short: "Apply the manifest periodically, repairing drift",
+		Long: `Enforce applies the manifest every --interval without confirmation, so changes
+made to the system outside of the manifest are reverted. Each interval is randomized
+by up to --jitter, so agents enforced from many places aren't hit at the same time.
+
+After a failed run the interval doubles with every further failure, up to
+--max-backoff. With --webhook, a JSON notification is posted whenever changes were
+needed or a run failed. Enforce runs until interrupted.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			if interval <= 0 {
+				return errors.New("--interval must be positive")
+			}
+			if jitter < 0 || jitter > 1 {
+				return errors.New("--jitter must be between 0 and 1")
+			}
+			if manifestFile == manifest.Stdin {
+				return errors.New("enforce can't read the manifest from stdin")
+			}
+
+			failures := 0
+			for {
+				cfg, summary, err := runManifest(ctx, false, skipReadinessCheck)
+				if ctx.Err() != nil {
+					return nil
+				}
+
+				failed := err != nil || !summary.Success
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %s\n", prettifyError(err))
+				}
+				if failed {
+					failures++
+				} else {
+					failures = 0
+				}
+
+				if webhook != "" && (failed || changeCount(summary) > 0) {
+					if err := notifyEnforce(ctx, webhook, cfg, summary, err); err != nil {
+						fmt.Fprintf(os.Stderr, "Warning: failed to notify webhook: %s\n", err)
+					}
+				}
+
+				delay := enforceDelay(interval, jitter, maxBackoff, failures)
+				fmt.Printf("%s ⏰ Next run at %s\n", time.Now().Format(time.TimeOnly),
+					time.Now().Add(delay).Format(time.TimeOnly))
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(delay):
+				}
+			}
+		},
+	}
+
+	cmd.Flags().DurationVar(&interval, "interval", 10*time.Minute,
+		"Time between runs")
+	cmd.Flags().Float64Var(&jitter, "jitter", 0.1,
+		"Randomize each interval by up to this fraction of it (0 to 1)")
+	cmd.Flags().DurationVar(&maxBackoff, "max-backoff", time.Hour,
+		"Maximum time between runs while they fail")
+	cmd.Flags().StringVar(&webhook, "webhook", "",
+		"URL a JSON notification is posted to when changes were needed or a run failed")
+	cmd.Flags().BoolVar(&skipReadinessCheck, "skip-readiness-check", false,
+		"Don't check that the agent is ready before each run")
+	cmd.Flags().StringVar(&manifestFile, "manifest", "",
+		"Path to manifest file (YAML, JSON, Starlark or CUE) containing resource definitions (required)")
+	cmd.MarkFlagRequired("manifest")
+	cmd.RegisterFlagCompletionFunc("manifest", completeManifest)
+	cmd.Flags().StringArrayVar(&variables, "var", nil,
+		"Set a manifest variable (key=value), overrides variables from the manifest and var files")
+	cmd.Flags().StringArrayVar(&variableFiles, "var-file", nil,
+		"Path to YAML file with manifest variables, can be repeated")
+
+	return cmd
+}
+
+// enforceDelay returns the time until the next run, the interval doubles with every
+// consecutive failure up to maxBackoff and is randomized by up to jitter
+func enforceDelay(interval time.Duration, jitter float64, maxBackoff time.Duration, failures int) time.Duration {
+	delay := interval
+	for i := 0; i < failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if failures > 0 && delay > maxBackoff {
+		delay = max(maxBackoff, interval)
+	}
+
+	if jitter > 0 {
+		spread := float64(delay) * jitter
+		delay += time.Duration(spread * (2*rand.Float64() - 1))
+	}
+	return delay
+}
+
+// notifyEnforce posts the result of a run to the webhook
+func notifyEnforce(ctx context.Context, webhook string, cfg *config.Config, summary *orchestrator.Summary, runErr error) error {
+	redact := func(s string) string { return s }
+	if cfg != nil {
+		redact = cfg.Secrets.Redact
+	}
+
+	n := enforceNotification{
+		Event:    enforceDrift,
+		Endpoint: endpoint,
+		Manifest: manifestFile,
+		Time:     time.Now().UTC(),
+	}
+	if summary != nil {
+		s := newSummaryOutput(summary, false, redact)
+		n.Summary = &s
+	}
+	if runErr != nil || summary == nil || !summary.Success {
+		n.Event = enforceFailure
+	}
+	if runErr != nil {
+		n.Error = redact(runErr.Error())
+	}
+
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
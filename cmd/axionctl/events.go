@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	ops_events "peertech.de/axion/api/client/events"
+	"peertech.de/axion/api/models"
+	"peertech.de/axion/pkg/version"
+)
+
+func cmdEvents() *cobra.Command {
+	var (
+		types      []string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "events",
+		Short: "Watch the operations performed by the agent",
+		Long: `Events streams the changes the agent makes and the commands it runs as they
+happen, e.g. to follow a large apply from another terminal. It runs until interrupted.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			cfg, err := setupConfig(false, "", 1, endpoint)
+			if err != nil {
+				return err
+			}
+			if err := negotiate(ctx, cfg); err != nil {
+				return err
+			}
+			if err := requireCapability(cfg, version.CapabilityEvents); err != nil {
+				return err
+			}
+
+			params := ops_events.NewStreamEventsParamsWithContext(ctx)
+			params.Type = types
+
+			stream := &sseDecoder{handle: func(data []byte) {
+				if jsonOutput {
+					fmt.Println(string(data))
+					return
+				}
+				var ev models.AgentEvent
+				if err := json.Unmarshal(data, &ev); err != nil {
+					fmt.Fprintf(os.Stderr, "Invalid event: %v\n", err)
+					return
+				}
+				fmt.Println(formatEvent(&ev))
+			}}
+
+			if _, err := cfg.Client.Events.StreamEvents(params, stream); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&types, "type", nil,
+		"Only show events whose type starts with the prefix (e.g. command, file.changed), can be repeated")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false,
+		"Print the events as JSON lines")
+
+	return cmd
+}
+
+func formatEvent(ev *models.AgentEvent) string {
+	subject := ev.Path
+	switch {
+	case ev.Command != "":
+		subject = ev.Command
+	case ev.Name != "" && ev.Action != "":
+		subject = ev.Name + " (" + ev.Action + ")"
+	case ev.Name != "":
+		subject = ev.Name
+	}
+
+	line := fmt.Sprintf("%s %-18s %s", time.Time(ev.Time).Local().Format(time.TimeOnly), ev.Type, subject)
+	switch {
+	case ev.Error != "":
+		line += " failed: " + ev.Error
+	case ev.ExitCode != nil:
+		line += fmt.Sprintf(" exit=%d", *ev.ExitCode)
+	}
+	if ev.Client != "" {
+		line += " [" + ev.Client + "]"
+	}
+	return line
+}
+
+// sseDecoder decodes the server-sent events written to it and passes the data of each
+// event on, comments and the other fields are ignored
+type sseDecoder struct {
+	handle func(data []byte)
+	buf    []byte
+	data   [][]byte
+}
+
+func (d *sseDecoder) Write(p []byte) (int, error) {
+	d.buf = append(d.buf, p...)
+	for {
+		i := bytes.IndexByte(d.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := strings.TrimSuffix(string(d.buf[:i]), "\r")
+		d.buf = d.buf[i+1:]
+
+		switch {
+		case line == "":
+			// A blank line dispatches the event
+			if len(d.data) > 0 {
+				d.handle(bytes.Join(d.data, []byte("\n")))
+				d.data = nil
+			}
+		case strings.HasPrefix(line, "data:"):
+			value := strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+			d.data = append(d.data, []byte(value))
+		}
+	}
+}
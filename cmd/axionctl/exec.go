@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"peertech.de/axion/api/models"
+	"peertech.de/axion/pkg/resource"
+	"peertech.de/axion/pkg/version"
+)
+
+func cmdExec() *cobra.Command {
+	var (
+		timeout time.Duration
+		stream  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "exec <command>",
+		Short: "Run a command on the endpoint ad-hoc",
+		Long: `Exec runs a one-off command on the endpoint without a manifest and prints
+its output. Arguments are joined into the command, use -- to pass flags to it:
+
+  axionctl exec -- systemctl status nginx
+
+Axionctl exits with the exit code of the command.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			cfg, err := setupConfig(false, "", 1, endpoint)
+			if err != nil {
+				return err
+			}
+			if err := negotiate(ctx, cfg); err != nil {
+				return err
+			}
+			if stream {
+				if err := requireCapability(cfg, version.CapabilityCommandStream); err != nil {
+					return err
+				}
+			}
+
+			c := resource.NewCommand(cfg, strings.Join(args, " "), resource.WithTimeout(timeout))
+			if err := c.Validate(); err != nil {
+				return err
+			}
+			if stream {
+				c.SetOutput(func(stream, line string) {
+					if stream == models.CommandEventStreamStderr {
+						fmt.Fprintln(os.Stderr, line)
+					} else {
+						fmt.Println(line)
+					}
+				})
+			}
+
+			result, err := c.Run(ctx)
+			if err != nil {
+				return err
+			}
+
+			if !stream {
+				fmt.Print(result.Stdout)
+				fmt.Fprint(os.Stderr, result.Stderr)
+			}
+			if result.Truncated {
+				fmt.Fprintf(os.Stderr, "Warning: output truncated by the agent (stdout %d bytes, stderr %d bytes)\n",
+					result.StdoutSize, result.StderrSize)
+			}
+			if result.ExitCode != 0 {
+				return exitCode(result.ExitCode)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second,
+		"Time the command may run before it is killed")
+	cmd.Flags().BoolVar(&stream, "stream", false,
+		"Print the output while the command runs instead of once it finished")
+
+	return cmd
+}
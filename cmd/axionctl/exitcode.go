@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"peertech.de/axion/pkg/orchestrator"
+	"peertech.de/axion/pkg/resource"
+)
+
+// Exit codes of the categories of failures, so automation can react to them. plan and
+// drift exit with 2 if changes are pending.
+const (
+	exitFailure        = 1 // any other failure
+	exitManifest       = 3 // the manifest can't be read, parsed or validated
+	exitConnection     = 4 // the agent can't be reached, is incompatible or rejects the credentials
+	exitRolledBack     = 5 // the apply failed, the applied changes were rolled back
+	exitRollbackFailed = 6 // the apply failed and changes remain which weren't rolled back
+)
+
+// exitCode is returned by commands to exit with the status without reporting an error
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(c))
+}
+
+// categorizedError is reported like err, axionctl exits with code afterwards
+type categorizedError struct {
+	code int
+	err  error
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() error {
+	return e.err
+}
+
+// manifestError marks err as failure to load the manifest
+func manifestError(err error) error {
+	return &categorizedError{code: exitManifest, err: err}
+}
+
+// connectionError marks err as failure to talk to the agent
+func connectionError(err error) error {
+	return &categorizedError{code: exitConnection, err: err}
+}
+
+// applyError returns the error of the failed apply of summary, categorized by whether
+// the changes applied before the failure were rolled back
+func applyError(summary *orchestrator.Summary) error {
+	if summary.RollbackCount < summary.AppliedCount {
+		return &categorizedError{code: exitRollbackFailed, err: fmt.Errorf("apply failed, %d of %d applied change(s) were not rolled back",
+			summary.AppliedCount-summary.RollbackCount, summary.AppliedCount)}
+	}
+	if summary.AppliedCount > 0 {
+		return &categorizedError{code: exitRolledBack, err: fmt.Errorf("apply failed, %d applied change(s) were rolled back", summary.AppliedCount)}
+	}
+	return &categorizedError{code: exitRolledBack, err: errors.New("apply failed, no changes were applied")}
+}
+
+// exitStatus returns the status axionctl exits with after failing with err
+func exitStatus(err error) int {
+	var categorized *categorizedError
+	if errors.As(err, &categorized) {
+		return categorized.code
+	}
+
+	// Runs interrupted or timed out are no connection failures, although context errors
+	// are net.Errors
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return exitFailure
+	}
+
+	// The connection may fail or credentials may be rejected in the middle of a run
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) {
+		return exitConnection
+	}
+	var apiErr *resource.APIError
+	if errors.As(err, &apiErr) && (apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden) {
+		return exitConnection
+	}
+	return exitFailure
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-openapi/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	ops_health "peertech.de/axion/api/client/health"
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/version"
+)
+
+// negotiate checks that the agent speaks the API version of axionctl and records its
+// capabilities, so unsupported features are avoided. Agents predating the version
+// endpoint are assumed to support all features of their API version.
+func negotiate(ctx context.Context, cfg *config.Config) error {
+	resp, err := cfg.Client.Health.GetVersion(ops_health.NewGetVersionParamsWithContext(ctx))
+	if err != nil {
+		if notSupported(err) {
+			return nil
+		}
+		return connectionError(fmt.Errorf("failed to query agent version: %w", err))
+	}
+
+	v := resp.Payload
+	if v.APIVersion != version.APIVersion {
+		return connectionError(fmt.Errorf("agent %s speaks API %s, axionctl %s requires %s",
+			v.Version, v.APIVersion, version.Version, version.APIVersion))
+	}
+	cfg.Capabilities = v.Capabilities
+	if cfg.Capabilities == nil {
+		cfg.Capabilities = []string{}
+	}
+	return nil
+}
+
+// requireCapability fails if the agent lacks a feature a command can't do without
+func requireCapability(cfg *config.Config, capability string) error {
+	if !cfg.Supports(capability) {
+		return fmt.Errorf("agent doesn't support %s", capability)
+	}
+	return nil
+}
+
+// checkReadiness pings the readiness of the agent, so a run doesn't start against an
+// agent which would fail half way through it
+func checkReadiness(ctx context.Context, cfg *config.Config) error {
+	if !cfg.Supports(version.CapabilityHealth) {
+		return nil
+	}
+
+	_, err := cfg.Client.Health.GetReadiness(ops_health.NewGetReadinessParamsWithContext(ctx))
+	if err == nil {
+		return nil
+	}
+
+	var notReady *ops_health.GetReadinessServiceUnavailable
+	if errors.As(err, &notReady) && notReady.Payload != nil {
+		var failed []string
+		for _, check := range notReady.Payload.Checks {
+			if check.Status == "ok" {
+				continue
+			}
+			failed = append(failed, fmt.Sprintf("%s %s: %s", check.Name, check.Path, check.Message))
+		}
+		return connectionError(fmt.Errorf("agent is not ready: %s", strings.Join(failed, "; ")))
+	}
+
+	if notSupported(err) {
+		fmt.Fprintln(os.Stderr, "Warning: agent doesn't report its readiness, skipping the check")
+		return nil
+	}
+
+	return connectionError(fmt.Errorf("failed to check agent readiness: %w", err))
+}
+
+// notSupported reports whether err is the response of an agent predating an endpoint
+func notSupported(err error) bool {
+	var apiErr *runtime.APIError
+	if errors.As(err, &apiErr) && apiErr.IsCode(http.StatusNotFound) {
+		return true
+	}
+	return status.Code(err) == codes.Unimplemented
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	manifestyaml "peertech.de/axion/pkg/manifest/yaml"
+	"peertech.de/axion/pkg/resource"
+)
+
+func cmdImport() *cobra.Command {
+	var out string
+
+	cmd := &cobra.Command{
+		Use:   "import <path>...",
+		Short: "Generate manifest resources from existing files and directories",
+		Long: `Import reads the files, directories and symlinks at the given paths on the
+endpoint and prints YAML manifest resources matching their current mode, owner,
+group and target, as a starting point for managing existing hosts.
+
+Paths may be glob patterns, see path.Match, which are quoted to keep the shell from
+expanding them locally:
+
+  axionctl import /etc/nginx '/etc/nginx/conf.d/*.conf'
+
+Patterns are matched by downloading the directory they start with, keep it small.
+Files depend on the imported directory closest to them. The content of files is not
+imported.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := runContext()
+			defer cancel()
+
+			cfg, err := setupConfig(false, "", 1, endpoint)
+			if err != nil {
+				return err
+			}
+			if err := negotiate(ctx, cfg); err != nil {
+				return err
+			}
+
+			var paths []string
+			for _, arg := range args {
+				if !strings.ContainsAny(arg, `*?[`) {
+					paths = append(paths, path.Clean(arg))
+					continue
+				}
+				matches, err := resource.Glob(ctx, cfg, arg)
+				if err != nil {
+					return err
+				}
+				if len(matches) == 0 {
+					return fmt.Errorf("no paths match %s", arg)
+				}
+				paths = append(paths, matches...)
+			}
+
+			var observed []*resource.Observed
+			seen := make(map[string]bool)
+			for _, p := range paths {
+				if seen[p] {
+					continue
+				}
+				seen[p] = true
+
+				o, err := resource.Inspect(ctx, cfg, p)
+				if err != nil {
+					return err
+				}
+				observed = append(observed, o)
+			}
+
+			var buf bytes.Buffer
+			fmt.Fprintf(&buf, "# Imported from %s at %s\n", endpoint, time.Now().UTC().Format(time.RFC3339))
+			if err := writeImported(&buf, observed); err != nil {
+				return err
+			}
+
+			if out == "" {
+				_, err := os.Stdout.Write(buf.Bytes())
+				return err
+			}
+			if _, err := os.Stat(out); err == nil {
+				return fmt.Errorf("%s exists already", out)
+			}
+			if err := os.WriteFile(out, buf.Bytes(), 0644); err != nil {
+				return err
+			}
+			fmt.Printf("Imported %d resource(s) to %s\n", len(observed), out)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&out, "out", "",
+		"Write the manifest to this file instead of stdout, it must not exist")
+
+	return cmd
+}
+
+// writeImported writes the manifest with the resources managing the observed paths to w
+func writeImported(w io.Writer, observed []*resource.Observed) error {
+	ids := make(map[string]string, len(observed))
+	taken := make(map[string]bool, len(observed))
+	for _, o := range observed {
+		id := importID(o.Path)
+		for i := 2; taken[id]; i++ {
+			id = fmt.Sprintf("%s-%d", importID(o.Path), i)
+		}
+		taken[id] = true
+		ids[o.Path] = id
+	}
+
+	m := manifestyaml.Manifest{Resources: make([]manifestyaml.Resource, 0, len(observed))}
+	for _, o := range observed {
+		res := manifestyaml.Resource{
+			Id:         ids[o.Path],
+			Type:       o.Type,
+			State:      string(resource.StatePresent),
+			Properties: map[string]any{"path": o.Path},
+		}
+		for k, v := range map[string]string{"mode": o.Mode, "owner": o.Owner, "group": o.Group, "target": o.Target} {
+			if v != "" {
+				res.Properties[k] = v
+			}
+		}
+
+		// Depend on the closest imported parent directory
+		for dir := path.Dir(o.Path); dir != "/" && dir != "."; dir = path.Dir(dir) {
+			if id, ok := ids[dir]; ok {
+				res.Dependencies = []string{id}
+				break
+			}
+		}
+
+		m.Resources = append(m.Resources, res)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// importID derives the id of the resource managing p from the path, e.g. etc-nginx-conf
+// for /etc/nginx.conf
+func importID(p string) string {
+	id := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, strings.TrimPrefix(p, "/"))
+
+	id = strings.Trim(id, "-")
+	for strings.Contains(id, "--") {
+		id = strings.ReplaceAll(id, "--", "-")
+	}
+	if id == "" {
+		return "root"
+	}
+	return id
+}
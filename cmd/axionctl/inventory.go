@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/inventory"
+	"peertech.de/axion/pkg/orchestrator"
+)
+
+// hostRun is the run of the manifest against a host of the inventory
+type hostRun struct {
+	host    inventory.Host
+	cfg     *config.Config
+	o       *orchestrator.Orchestrator
+	summary *orchestrator.Summary
+	err     error
+}
+
+// hostSummaryOutput is the serialized result of a run against a host
+type hostSummaryOutput struct {
+	Host     string         `json:"host" yaml:"host"`
+	Endpoint string         `json:"endpoint" yaml:"endpoint"`
+	Error    string         `json:"error,omitempty" yaml:"error,omitempty"`
+	Summary  *summaryOutput `json:"summary,omitempty" yaml:"summary,omitempty"`
+}
+
+// failed reports whether the run against the host failed
+func (r *hostRun) failed() bool {
+	return r.err != nil || (r.summary != nil && !r.summary.Success)
+}
+
+// redact removes sensitive values from s, with the secrets of the host if it was set up
+func (r *hostRun) redact(s string) string {
+	if r.cfg == nil {
+		return s
+	}
+	return r.cfg.Secrets.Redact(s)
+}
+
+// prepare connects to the host and loads the manifest with the variables of the host
+func (r *hostRun) prepare(ctx context.Context, setup func(h inventory.Host) (*config.Config, error), skipReadinessCheck bool) error {
+	cfg, err := setup(r.host)
+	if err != nil {
+		return err
+	}
+	r.cfg = cfg
+
+	if len(r.host.Variables) > 0 {
+		if cfg.Variables == nil {
+			cfg.Variables = make(map[string]any, len(r.host.Variables))
+		}
+		for k, v := range r.host.Variables {
+			cfg.Variables[k] = v
+		}
+	}
+
+	if err := negotiate(ctx, cfg); err != nil {
+		return err
+	}
+	if !skipReadinessCheck {
+		if err := checkReadiness(ctx, cfg); err != nil {
+			return err
+		}
+	}
+
+	r.o, err = setupOrchestrator(cfg, manifestFile, r.host.Endpoint, r.host.Name)
+	return err
+}
+
+// run runs the manifest against the host
+func (r *hostRun) run(ctx context.Context, planOnly bool) {
+	r.summary = r.o.Run(ctx, planOnly)
+	r.err = r.summary.Error
+	pushMetrics(ctx, r.host.Name, r.summary, planOnly)
+}
+
+// prepareHosts sets up the runs against the hosts of the inventory
+func prepareHosts(ctx context.Context, inv *inventory.Inventory, setup func(h inventory.Host) (*config.Config, error), skipReadinessCheck bool) []*hostRun {
+	runs := make([]*hostRun, len(inv.Hosts))
+	for i, h := range inv.Hosts {
+		runs[i] = &hostRun{host: h}
+	}
+	eachHost(runs, func(r *hostRun) {
+		r.err = r.prepare(ctx, setup, skipReadinessCheck)
+	})
+	return runs
+}
+
+// eachHost calls fn for the runs which haven't failed, up to --parallel (or
+// --host-concurrency) at a time
+func eachHost(runs []*hostRun, fn func(r *hostRun)) {
+	sem := make(chan struct{}, max(parallelHosts, 1))
+	var wg sync.WaitGroup
+	for _, r := range runs {
+		if r.failed() {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(r)
+		}()
+	}
+	wg.Wait()
+}
+
+// failedHosts returns the number of hosts whose run failed
+func failedHosts(runs []*hostRun) int {
+	n := 0
+	for _, r := range runs {
+		if r.failed() {
+			n++
+		}
+	}
+	return n
+}
+
+// hostChanges returns the number of changed and unchanged resources across all hosts
+func hostChanges(runs []*hostRun) (changes, unchanged int) {
+	for _, r := range runs {
+		if r.summary != nil {
+			n := changeCount(r.summary)
+			changes += n
+			unchanged += r.summary.TotalCount - n
+		}
+	}
+	return changes, unchanged
+}
+
+// printHosts writes the result of the runs to stdout, as table or in --output format
+func printHosts(runs []*hostRun, plan bool) error {
+	if outputFormat != "" {
+		out := make([]hostSummaryOutput, len(runs))
+		for i, r := range runs {
+			out[i] = hostSummaryOutput{Host: r.host.Name, Endpoint: r.host.Endpoint}
+			if r.summary != nil {
+				s := newSummaryOutput(r.summary, plan, r.redact)
+				out[i].Summary = &s
+			}
+			if r.err != nil {
+				out[i].Error = r.redact(r.err.Error())
+			}
+		}
+		return encodeOutput(os.Stdout, outputFormat, out)
+	}
+
+	printHostTable(os.Stdout, runs)
+	return nil
+}
+
+// printHostTable writes a table with the result of the run against each host to w,
+// followed by the totals if there are several hosts
+func printHostTable(w io.Writer, runs []*hostRun) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "HOST\tENDPOINT\tSTATUS\tCHANGES\tAPPLIED\tFAILED\tSKIPPED\tROLLED BACK\tERROR")
+
+	var total hostCounts
+	for _, r := range runs {
+		status := "ok"
+		if r.failed() {
+			status = "failed"
+		}
+
+		c := newHostCounts(r.summary)
+		total.add(c)
+
+		var msg string
+		if r.err != nil {
+			msg = r.redact(prettifyError(r.err))
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			r.host.Name, r.host.Endpoint, status, c.changes, c.applied, c.failed, c.skipped, c.rolledBack, msg)
+	}
+
+	if len(runs) > 1 {
+		status := fmt.Sprintf("%d/%d ok", len(runs)-failedHosts(runs), len(runs))
+		fmt.Fprintf(tw, "TOTAL\t\t%s\t%d\t%d\t%d\t%d\t%d\t\n",
+			status, total.changes, total.applied, total.failed, total.skipped, total.rolledBack)
+	}
+	tw.Flush()
+}
+
+// hostCounts counts the outcomes of the resources of a run against a host
+type hostCounts struct {
+	changes    int
+	applied    int
+	failed     int
+	skipped    int
+	rolledBack int
+}
+
+// newHostCounts counts the outcomes in summary, which is nil if the run didn't start
+func newHostCounts(summary *orchestrator.Summary) hostCounts {
+	if summary == nil {
+		return hostCounts{}
+	}
+	c := hostCounts{
+		changes:    changeCount(summary),
+		applied:    summary.AppliedCount,
+		skipped:    summary.SkippedCount,
+		rolledBack: summary.RollbackCount,
+	}
+	for _, a := range summary.Attempts {
+		if a.EvaluationError != nil || a.BackupError != nil || a.ApplyError != nil {
+			c.failed++
+		}
+	}
+	return c
+}
+
+func (c *hostCounts) add(o hostCounts) {
+	c.changes += o.changes
+	c.applied += o.applied
+	c.failed += o.failed
+	c.skipped += o.skipped
+	c.rolledBack += o.rolledBack
+}
+
+// planInventory plans the manifest against all hosts of the inventory
+func planInventory(ctx context.Context, inv *inventory.Inventory, skipReadinessCheck, detailedExitCode bool) error {
+	runs := prepareHosts(ctx, inv, func(h inventory.Host) (*config.Config, error) {
+		return setupConfig(false, "", concurrency, h.Endpoint)
+	}, skipReadinessCheck)
+
+	eachHost(runs, func(r *hostRun) { r.run(ctx, true) })
+	if err := writeHostsJUnit(runs); err != nil {
+		return err
+	}
+	if err := writeHostsRunReport(runs, true); err != nil {
+		return err
+	}
+	if err := printHosts(runs, true); err != nil {
+		return err
+	}
+
+	if n := failedHosts(runs); n > 0 {
+		return fmt.Errorf("plan failed on %d of %d hosts", n, len(runs))
+	}
+	if changes, _ := hostChanges(runs); detailedExitCode && changes > 0 {
+		return exitCode(2)
+	}
+	return nil
+}
+
+// applyInventory applies the manifest to all hosts of the inventory. No host is changed
+// if any of them can't be reached or, unless autoApprove is set, fails to plan.
+func applyInventory(ctx context.Context, inv *inventory.Inventory, enableBackups bool, backupDir string, agentBackups, skipReadinessCheck, autoApprove bool) error {
+	runs := prepareHosts(ctx, inv, func(h inventory.Host) (*config.Config, error) {
+		// Each host gets its own backup directory, the paths of hosts overlap
+		dir := backupDir
+		if dir != "" {
+			dir = filepath.Join(dir, h.Name)
+		}
+		cfg, err := setupConfig(enableBackups, dir, concurrency, h.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if agentBackups {
+			cfg.AgentBackups = true
+		}
+		return cfg, nil
+	}, skipReadinessCheck)
+
+	if n := failedHosts(runs); n > 0 {
+		printHosts(runs, true)
+		return &categorizedError{code: hostsExitStatus(runs), err: fmt.Errorf("failed to prepare %d of %d hosts, no changes were applied", n, len(runs))}
+	}
+
+	apply := true
+	if !autoApprove {
+		eachHost(runs, func(r *hostRun) { r.run(ctx, true) })
+		printHostTable(os.Stdout, runs)
+		if n := failedHosts(runs); n > 0 {
+			return fmt.Errorf("plan failed on %d of %d hosts, no changes were applied", n, len(runs))
+		}
+
+		changes, unchanged := hostChanges(runs)
+		if changes == 0 {
+			// Nothing to confirm, the plans are the results of the apply
+			fmt.Println("No changes, all hosts are in the desired state.")
+			apply = false
+		} else {
+			approved, err := confirmApply(os.Stdin, os.Stdout, changes, unchanged)
+			if err != nil {
+				return err
+			}
+			if !approved {
+				return errNotApproved
+			}
+		}
+	}
+
+	if apply {
+		eachHost(runs, func(r *hostRun) { r.run(ctx, false) })
+		if err := writeHostsJUnit(runs); err != nil {
+			return err
+		}
+		if err := writeHostsRunReport(runs, false); err != nil {
+			return err
+		}
+		if err := printHosts(runs, false); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range runs {
+		if r.summary != nil {
+			if err := updateState(r.host.Endpoint, manifestFile, r.summary, r.redact); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to update state of %s: %s\n", r.host.Name, err)
+			}
+		}
+		if r.failed() {
+			continue
+		}
+		pruneBackups(ctx, r.cfg)
+	}
+
+	if n := failedHosts(runs); n > 0 {
+		return &categorizedError{code: hostsExitStatus(runs), err: fmt.Errorf("apply failed on %d of %d hosts", n, len(runs))}
+	}
+	return nil
+}
+
+// hostsExitStatus returns the exit status of the most severe failure of the runs
+func hostsExitStatus(runs []*hostRun) int {
+	code := exitFailure
+	for _, r := range runs {
+		switch {
+		case r.err != nil:
+			code = max(code, exitStatus(r.err))
+		case r.failed():
+			code = max(code, exitStatus(applyError(r.summary)))
+		}
+	}
+	return code
+}
+
+// loadInventory loads the --inventory file, it fails if --endpoint is given as well
+func loadInventory(endpointSet bool) (*inventory.Inventory, error) {
+	if endpointSet {
+		return nil, errors.New("--endpoint and --inventory are mutually exclusive")
+	}
+	return inventory.Load(inventoryFile)
+}
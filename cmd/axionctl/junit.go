@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"peertech.de/axion/pkg/orchestrator"
+)
+
+var junitFile string
+
+// writeJUnit writes the summary of the run against the endpoint as JUnit XML report to
+// --junit, if given
+func writeJUnit(summary *orchestrator.Summary, redact func(string) string) error {
+	if junitFile == "" {
+		return nil
+	}
+	return writeJUnitFile(orchestrator.NewJUnitSuite(manifestFile, summary, redact))
+}
+
+// writeHostsJUnit writes the runs against the hosts of an inventory as JUnit XML report
+// to --junit, if given, with a test suite per host
+func writeHostsJUnit(runs []*hostRun) error {
+	if junitFile == "" {
+		return nil
+	}
+
+	suites := make([]orchestrator.JUnitSuite, 0, len(runs))
+	for _, r := range runs {
+		var suite orchestrator.JUnitSuite
+		if r.summary != nil {
+			suite = orchestrator.NewJUnitSuite(r.host.Name, r.summary, r.redact)
+		} else {
+			suite = orchestrator.JUnitSuite{Name: r.host.Name}
+			if r.err != nil {
+				suite.AddError("prepare", r.redact(r.err.Error()))
+			}
+		}
+		suites = append(suites, suite)
+	}
+	return writeJUnitFile(suites...)
+}
+
+func writeJUnitFile(suites ...orchestrator.JUnitSuite) error {
+	f, err := os.Create(junitFile)
+	if err != nil {
+		return fmt.Errorf("failed to write JUnit report: %w", err)
+	}
+	if err := orchestrator.WriteJUnit(f, suites...); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write JUnit report: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write JUnit report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/manifest"
+	"peertech.de/axion/pkg/orchestrator"
+)
+
+func cmdLint() *cobra.Command {
+	var strict bool
+
+	cmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Check a manifest for errors without contacting the agent",
+		Long: `Lint loads the manifest offline and reports schema violations, invalid
+resource configurations, unknown or circular dependencies and style issues such as
+unused variables or resources managing the same path.
+
+Exits with a non-zero status if errors are found, or warnings with --strict.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := setupConfig(false, "", concurrency, endpoint)
+			if err != nil {
+				return err
+			}
+
+			diags := lint(context.Background(), cfg, manifestFile)
+
+			var errs, warnings int
+			for _, d := range diags {
+				fmt.Println(d)
+				if d.Severity == manifest.SeverityError {
+					errs++
+				} else {
+					warnings++
+				}
+			}
+
+			if errs > 0 || (strict && warnings > 0) {
+				return fmt.Errorf("lint found %d error(s) and %d warning(s)", errs, warnings)
+			}
+			if len(diags) == 0 {
+				fmt.Println("No problems found")
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&manifestFile, "manifest", "",
+		"Path to manifest file (YAML, JSON, Starlark or CUE) containing resource definitions, - reads from stdin (required)")
+	cmd.MarkFlagRequired("manifest")
+	cmd.RegisterFlagCompletionFunc("manifest", completeManifest)
+	cmd.Flags().StringArrayVar(&variables, "var", nil,
+		"Set a manifest variable (key=value), overrides variables from the manifest and var files")
+	cmd.Flags().StringArrayVar(&variableFiles, "var-file", nil,
+		"Path to YAML file with manifest variables, can be repeated")
+	cmd.Flags().BoolVar(&strict, "strict", false,
+		"Treat warnings as errors")
+
+	return cmd
+}
+
+// lint loads the manifest and collects all problems found. Loading errors stop the lint
+// early as no resources are available for the remaining checks.
+func lint(ctx context.Context, cfg *config.Config, path string) []manifest.Diagnostic {
+	at := manifest.Position{File: path}
+
+	failure := func(err error) manifest.Diagnostic {
+		return manifest.Diagnostic{Severity: manifest.SeverityError, Position: at, Message: err.Error()}
+	}
+
+	loader, err := newLoader(path)
+	if err != nil {
+		return []manifest.Diagnostic{failure(err)}
+	}
+
+	specs, err := loader.Load(ctx, cfg, path)
+	if err != nil {
+		var schemaErr *manifest.SchemaError
+		if !errors.As(err, &schemaErr) {
+			return []manifest.Diagnostic{failure(err)}
+		}
+
+		diags := make([]manifest.Diagnostic, len(schemaErr.Issues))
+		for i, issue := range schemaErr.Issues {
+			diags[i] = manifest.Diagnostic{
+				Severity: manifest.SeverityError,
+				Position: issue.Position,
+				Message:  issue.Message,
+			}
+		}
+		return diags
+	}
+
+	var diags []manifest.Diagnostic
+
+	// Resource validation and dependency checks
+	var opts []orchestrator.Option
+	if cfg.InferDependencies {
+		opts = append(opts, orchestrator.WithInferDependencies())
+	}
+	o := orchestrator.NewOrchestrator(opts...)
+	for _, spec := range specs {
+		if err := o.Add(spec); err != nil {
+			diags = append(diags, failure(err))
+		}
+	}
+	if err := o.Validate(); err != nil {
+		diags = append(diags, failure(err))
+	}
+
+	// Resources managing the same target will overwrite each other
+	managed := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		name := spec.Resource.Name()
+		if other, ok := managed[name]; ok {
+			diags = append(diags, manifest.Diagnostic{
+				Severity: manifest.SeverityWarning,
+				Position: at,
+				Message:  fmt.Sprintf("resources %q and %q both manage %s", other, spec.Id, name),
+			})
+			continue
+		}
+		managed[name] = spec.Id
+	}
+
+	if l, ok := loader.(manifest.Linter); ok {
+		warnings, err := l.Lint(cfg, path)
+		if err != nil {
+			diags = append(diags, failure(err))
+		}
+		diags = append(diags, warnings...)
+	}
+
+	return diags
+}
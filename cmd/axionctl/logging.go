@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var verbosity int
+var logFormat string
+
+// setupLogging configures the logger for transport-level details on stderr, separate
+// from the progress report. Only warnings are logged by default, -v adds the API
+// operations and -vv the HTTP requests they make.
+func setupLogging() error {
+	level := zerolog.WarnLevel
+	switch {
+	case verbosity >= 2:
+		level = zerolog.DebugLevel
+	case verbosity == 1:
+		level = zerolog.InfoLevel
+	}
+
+	switch logFormat {
+	case logFormatText:
+		w := zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.TimeOnly}
+		log.Logger = zerolog.New(w).Level(level).With().Timestamp().Logger()
+	case logFormatJSON:
+		log.Logger = zerolog.New(os.Stderr).Level(level).With().Timestamp().Logger()
+	default:
+		return fmt.Errorf("invalid log format %q, must be %s or %s", logFormat, logFormatText, logFormatJSON)
+	}
+	return nil
+}
+
+// loggingTransport logs the operations submitted via the REST or gRPC transport
+type loggingTransport struct {
+	runtime.ClientTransport
+}
+
+// Submit implements runtime.ClientTransport
+func (t loggingTransport) Submit(op *runtime.ClientOperation) (any, error) {
+	start := time.Now()
+	resp, err := t.ClientTransport.Submit(op)
+
+	e := log.Info()
+	if err != nil {
+		e = e.Err(err)
+	}
+	e.Str("operation", op.ID).
+		Dur("duration", time.Since(start)).
+		Msg("API call")
+	return resp, err
+}
+
+// loggingRoundTripper logs the HTTP requests sent via Base
+type loggingRoundTripper struct {
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t loggingRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.Base.RoundTrip(r)
+
+	if e := log.Debug(); e.Enabled() {
+		e.Str("method", r.Method).
+			Str("url", r.URL.String()).
+			Dur("duration", time.Since(start))
+		if err != nil {
+			e.Err(err)
+		} else {
+			e.Int("status", resp.StatusCode).
+				Int64("content_length", resp.ContentLength)
+			if id := resp.Header.Get("X-Request-ID"); id != "" {
+				e.Str("request_id", id)
+			}
+		}
+		e.Msg("HTTP request")
+	}
+	return resp, err
+}
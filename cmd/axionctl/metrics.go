@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"peertech.de/axion/pkg/metrics"
+	"peertech.de/axion/pkg/orchestrator"
+)
+
+var metricsURL string
+var metricsJob string
+
+// metricsPusher pushes the metrics of runs to --metrics, nil if not given
+var metricsPusher metrics.Pusher
+
+// setupMetrics sets up the pusher of the metrics of runs, if --metrics is given
+func setupMetrics() error {
+	if metricsURL == "" {
+		return nil
+	}
+	p, err := metrics.New(metricsURL, metricsJob)
+	if err != nil {
+		return err
+	}
+	metricsPusher = p
+	return nil
+}
+
+// pushMetrics pushes the metrics of the run against instance, if --metrics is given.
+// Failing to push the metrics doesn't fail the run.
+func pushMetrics(ctx context.Context, instance string, summary *orchestrator.Summary, plan bool) {
+	if metricsPusher == nil {
+		return
+	}
+
+	operation := metrics.OperationApply
+	if plan {
+		operation = metrics.OperationPlan
+	}
+	run := metrics.Run{
+		Instance:  instance,
+		Operation: operation,
+		Success:   summary.Success && summary.Error == nil,
+		Changes:   changeCount(summary),
+		Counts:    summary.Counts(),
+		Duration:  summary.Duration,
+		Time:      time.Now(),
+	}
+	// The run may have been interrupted, the metrics should still be delivered
+	if err := metricsPusher.Push(context.WithoutCancel(ctx), run); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to push metrics: %s\n", err)
+	}
+}
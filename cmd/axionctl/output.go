@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+
+	"peertech.de/axion/pkg/orchestrator"
+)
+
+// Output formats of plan and apply, the human readable report is printed by default
+const (
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+// Operations performed on a resource, as reported in the serialized summary
+const (
+	operationNone       = "none"
+	operationChange     = "change"
+	operationApplied    = "applied"
+	operationRolledBack = "rolled_back"
+	operationFailed     = "failed"
+	operationSkipped    = "skipped"
+	operationExcluded   = "excluded"
+)
+
+// summaryOutput is the serialized form of an orchestrator.Summary
+type summaryOutput struct {
+	Success        bool             `json:"success" yaml:"success"`
+	Plan           bool             `json:"plan" yaml:"plan"`
+	ManifestDigest string           `json:"manifest_digest,omitempty" yaml:"manifest_digest,omitempty"`
+	Error          string           `json:"error,omitempty" yaml:"error,omitempty"`
+	Total          int              `json:"total" yaml:"total"`
+	Applied        int              `json:"applied" yaml:"applied"`
+	Skipped        int              `json:"skipped" yaml:"skipped"`
+	Excluded       int              `json:"excluded" yaml:"excluded"`
+	RolledBack     int              `json:"rolled_back" yaml:"rolled_back"`
+	Drift          *driftOutput     `json:"drift,omitempty" yaml:"drift,omitempty"`
+	Resources      []resourceOutput `json:"resources" yaml:"resources"`
+}
+
+// driftOutput is the serialized form of orchestrator.DriftCounts
+type driftOutput struct {
+	InSync            int `json:"in_sync" yaml:"in_sync"`
+	Drifted           int `json:"drifted" yaml:"drifted"`
+	ChangedInManifest int `json:"changed_in_manifest" yaml:"changed_in_manifest"`
+}
+
+// resourceOutput is the serialized form of an orchestrator.Attempt
+type resourceOutput struct {
+	Id            string `json:"id" yaml:"id"`
+	Name          string `json:"name" yaml:"name"`
+	Operation     string `json:"operation" yaml:"operation"`
+	Drift         string `json:"drift,omitempty" yaml:"drift,omitempty"`
+	Diff          string `json:"diff,omitempty" yaml:"diff,omitempty"`
+	BackedUp      bool   `json:"backed_up,omitempty" yaml:"backed_up,omitempty"`
+	Error         string `json:"error,omitempty" yaml:"error,omitempty"`
+	RollbackError string `json:"rollback_error,omitempty" yaml:"rollback_error,omitempty"`
+}
+
+// validateOutput checks the value of the --output flag
+func validateOutput(format string) error {
+	switch format {
+	case "", outputJSON, outputYAML:
+		return nil
+	}
+	return fmt.Errorf("invalid output format %q: must be %s or %s", format, outputJSON, outputYAML)
+}
+
+// printSummary writes summary to w in format, redact is applied to diffs and errors
+func printSummary(w io.Writer, format string, summary *orchestrator.Summary, plan bool, redact func(string) string) error {
+	return encodeOutput(w, format, newSummaryOutput(summary, plan, redact))
+}
+
+// encodeOutput writes v to w in format
+func encodeOutput(w io.Writer, format string, v any) error {
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	return validateOutput(format)
+}
+
+func newSummaryOutput(summary *orchestrator.Summary, plan bool, redact func(string) string) summaryOutput {
+	errString := func(err error) string {
+		if err == nil {
+			return ""
+		}
+		return redact(err.Error())
+	}
+
+	out := summaryOutput{
+		Success:        summary.Success,
+		Plan:           plan,
+		ManifestDigest: summary.ManifestDigest,
+		Error:          errString(summary.Error),
+		Total:          summary.TotalCount,
+		Applied:        summary.AppliedCount,
+		Skipped:        summary.SkippedCount,
+		Excluded:       summary.ExcludedCount,
+		RolledBack:     summary.RollbackCount,
+		Resources:      make([]resourceOutput, 0, len(summary.Order)),
+	}
+	if c := summary.DriftCounts(); c.Total() > 0 {
+		out.Drift = &driftOutput{InSync: c.InSync, Drifted: c.Drifted, ChangedInManifest: c.ChangedInManifest}
+	}
+
+	for _, id := range summary.Order {
+		a := summary.Attempts[id]
+
+		var err error
+		switch {
+		case a.EvaluationError != nil:
+			err = a.EvaluationError
+		case a.BackupError != nil:
+			err = a.BackupError
+		case a.ApplyError != nil:
+			err = a.ApplyError
+		}
+
+		out.Resources = append(out.Resources, resourceOutput{
+			Id:            a.Id,
+			Name:          redact(a.Name),
+			Operation:     operation(a),
+			Drift:         string(a.Drift),
+			Diff:          redact(a.Changes),
+			BackedUp:      a.BackedUp,
+			Error:         errString(err),
+			RollbackError: errString(a.RollbackError),
+		})
+	}
+	return out
+}
+
+// operation returns the operation performed on the resource of a
+func operation(a *orchestrator.Attempt) string {
+	switch {
+	case a.Excluded:
+		return operationExcluded
+	case a.Skipped:
+		return operationSkipped
+	case a.RolledBack:
+		return operationRolledBack
+	case a.EvaluationError != nil || a.BackupError != nil || a.ApplyError != nil:
+		return operationFailed
+	case a.Applied:
+		return operationApplied
+	case a.NeedsApply:
+		return operationChange
+	}
+	return operationNone
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	ops_packages "peertech.de/axion/api/client/packages"
+	"peertech.de/axion/api/models"
+	"peertech.de/axion/pkg/config"
+)
+
+func cmdPkg() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pkg",
+		Short: "Manage packages on the endpoint ad-hoc",
+		Long: `Pkg queries, installs, removes and pins packages through the package
+manager of the endpoint (apt, dnf or zypper) without a manifest.`,
+	}
+
+	cmd.AddCommand(cmdPkgInfo())
+	cmd.AddCommand(cmdPkgInstall())
+	cmd.AddCommand(cmdPkgRemove())
+	cmd.AddCommand(cmdPkgPin(true))
+	cmd.AddCommand(cmdPkgPin(false))
+
+	return cmd
+}
+
+func cmdPkgInfo() *cobra.Command {
+	return &cobra.Command{
+		Use:   "info <name>",
+		Short: "Show the installed version of a package",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := setupConfig(false, "", 1, endpoint)
+			if err != nil {
+				return err
+			}
+
+			params := ops_packages.NewGetPackageParamsWithContext(cmd.Context())
+			params.Name = args[0]
+
+			resp, err := cfg.Client.Packages.GetPackage(params)
+			if err != nil {
+				var notFound *ops_packages.GetPackageNotFound
+				if errors.As(err, &notFound) {
+					return fmt.Errorf("package %s is not installed", args[0])
+				}
+				return packageCommandError(err)
+			}
+
+			printPackage(resp.Payload)
+			return nil
+		},
+	}
+}
+
+func cmdPkgInstall() *cobra.Command {
+	var version string
+	var pin bool
+
+	cmd := &cobra.Command{
+		Use:   "install <name>",
+		Short: "Install a package",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := setupConfig(false, "", 1, endpoint)
+			if err != nil {
+				return err
+			}
+
+			req := &models.PackageRequest{Version: version}
+			if cmd.Flags().Changed("pin") {
+				req.Pinned = &pin
+			}
+			pkg, err := putPackage(cmd.Context(), cfg, args[0], req)
+			if err != nil {
+				return err
+			}
+
+			printPackage(pkg)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&version, "version", "",
+		"Version to install (default: candidate version of the package manager)")
+	cmd.Flags().BoolVar(&pin, "pin", false,
+		"Pin the package to the installed version")
+
+	return cmd
+}
+
+func cmdPkgRemove() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove a package",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := setupConfig(false, "", 1, endpoint)
+			if err != nil {
+				return err
+			}
+
+			params := ops_packages.NewDeletePackageParamsWithContext(cmd.Context())
+			params.Name = args[0]
+
+			if _, err := cfg.Client.Packages.DeletePackage(params); err != nil {
+				return packageCommandError(err)
+			}
+
+			fmt.Printf("Package %s removed\n", args[0])
+			return nil
+		},
+	}
+}
+
+// cmdPkgPin returns the pin command if pinned is set, the unpin command otherwise
+func cmdPkgPin(pinned bool) *cobra.Command {
+	use, short := "pin <name>", "Pin a package to its installed version"
+	if !pinned {
+		use, short = "unpin <name>", "Release a pinned package"
+	}
+
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := setupConfig(false, "", 1, endpoint)
+			if err != nil {
+				return err
+			}
+
+			// Without a version an installed package is left as it is
+			pkg, err := putPackage(cmd.Context(), cfg, args[0], &models.PackageRequest{Pinned: &pinned})
+			if err != nil {
+				return err
+			}
+
+			printPackage(pkg)
+			return nil
+		},
+	}
+}
+
+func putPackage(ctx context.Context, cfg *config.Config, name string, req *models.PackageRequest) (*models.Package, error) {
+	params := ops_packages.NewPutPackageParamsWithContext(ctx)
+	params.Name = name
+	params.Package = req
+
+	resp, err := cfg.Client.Packages.PutPackage(params)
+	if err != nil {
+		return nil, packageCommandError(err)
+	}
+	return resp.Payload, nil
+}
+
+func printPackage(pkg *models.Package) {
+	if pkg == nil {
+		return
+	}
+	fmt.Printf("Name:    %s\n", pkg.Name)
+	fmt.Printf("Version: %s\n", pkg.Version)
+	fmt.Printf("Pinned:  %t\n", pkg.Pinned)
+	fmt.Printf("Manager: %s\n", pkg.Manager)
+}
+
+// packageCommandError returns the message and the package manager output of an agent
+// error response
+func packageCommandError(err error) error {
+	var withPayload interface{ GetPayload() *models.Error }
+	if !errors.As(err, &withPayload) || withPayload.GetPayload() == nil {
+		return err
+	}
+
+	payload := withPayload.GetPayload()
+	if payload.Details == "" {
+		return errors.New(payload.Message)
+	}
+	return fmt.Errorf("%s\n%s", payload.Message, strings.TrimRight(payload.Details, "\n"))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"peertech.de/axion/pkg/orchestrator"
+)
+
+// savePlan saves the plan of summary with the endpoint, manifest and scope of the run
+func savePlan(path string, summary *orchestrator.Summary, redact func(string) string) error {
+	plan, err := orchestrator.NewPlan(summary, redact)
+	if err != nil {
+		return err
+	}
+	plan.Endpoint = endpoint
+	plan.Manifest = manifestFile
+	plan.Targets = targets
+	plan.Skip = skips
+
+	if err := plan.Write(path); err != nil {
+		return fmt.Errorf("failed to save plan: %w", err)
+	}
+	if outputFormat == "" {
+		fmt.Printf("Plan with %d change(s) saved to %s, apply it with \"axionctl apply %s\"\n",
+			plan.Changes(), path, path)
+	}
+	return nil
+}
+
+// loadPlan reads the plan saved at path and runs the apply against its endpoint,
+// manifest and scope. Flags may repeat them but not name others.
+func loadPlan(cmd *cobra.Command, path string) (*orchestrator.Plan, error) {
+	plan, err := orchestrator.ReadPlan(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint != plan.Endpoint {
+		return nil, fmt.Errorf("plan was saved for %s, not %s", plan.Endpoint, endpoint)
+	}
+	if manifestFile == "" {
+		manifestFile = plan.Manifest
+	} else if manifestFile != plan.Manifest {
+		return nil, fmt.Errorf("plan was saved for manifest %s, not %s", plan.Manifest, manifestFile)
+	}
+
+	if cmd.Flags().Changed("target") && !slices.Equal(targets, plan.Targets) {
+		return nil, fmt.Errorf("--target differs from the targets of the plan")
+	}
+	if cmd.Flags().Changed("skip") && !slices.Equal(skips, plan.Skip) {
+		return nil, fmt.Errorf("--skip differs from the skipped resources of the plan")
+	}
+	targets, skips = plan.Targets, plan.Skip
+
+	return plan, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"peertech.de/axion/pkg/manifest"
+)
+
+func cmdRender() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "render",
+		Short: "Print the fully resolved manifest without contacting the agent",
+		Long: `Render loads the manifest offline and prints it as YAML after templating,
+variable substitution and module expansion, showing the resources exactly as they
+would be applied. Secret values are redacted.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := setupConfig(false, "", concurrency, endpoint)
+			if err != nil {
+				return err
+			}
+
+			loader, err := newLoader(manifestFile)
+			if err != nil {
+				return err
+			}
+
+			r, ok := loader.(manifest.Renderer)
+			if !ok {
+				return fmt.Errorf("rendering is not supported for manifest %q", manifestFile)
+			}
+
+			out, err := r.Render(context.Background(), cfg, manifestFile)
+			if err != nil {
+				return err
+			}
+
+			fmt.Print(cfg.Secrets.Redact(string(out)))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&manifestFile, "manifest", "",
+		"Path to manifest file (YAML, JSON, Starlark or CUE) containing resource definitions, - reads from stdin (required)")
+	cmd.MarkFlagRequired("manifest")
+	cmd.RegisterFlagCompletionFunc("manifest", completeManifest)
+	cmd.Flags().StringArrayVar(&variables, "var", nil,
+		"Set a manifest variable (key=value), overrides variables from the manifest and var files")
+	cmd.Flags().StringArrayVar(&variableFiles, "var-file", nil,
+		"Path to YAML file with manifest variables, can be repeated")
+
+	return cmd
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"peertech.de/axion/pkg/report"
+)
+
+var noColor bool
+var noEmoji bool
+var quiet bool
+var logReport bool
+var timings bool
+var diffContext int
+
+// hostReportMu serializes the reports of the runs against multiple hosts
+var hostReportMu sync.Mutex
+
+// newReporter returns the reporter selected by the flags, host prefixes the messages
+// in runs against multiple hosts. Terminals get the live progress view unless emoji
+// are disabled or the output is quiet, colors are used on terminals for labels and diffs
+// unless disabled with --no-color or NO_COLOR. Diffs are collapsed to --diff-context.
+// With --log-report the events are logged to stderr instead, next to the serialized
+// summary if there is one. --timings adds a table of the resources sorted by duration
+// after each run.
+func newReporter(host string) report.Reporter {
+	if logReport {
+		return newLogReporter(host)
+	}
+	if outputFormat != "" {
+		// Only the serialized summary is written to stdout
+		return report.NilReporter{}
+	}
+
+	tty := isTerminal(os.Stdout)
+	color := tty && !noColor && os.Getenv("NO_COLOR") == ""
+
+	var reporter report.Reporter
+	switch {
+	case !noEmoji && !quiet && tty && host == "":
+		reporter = report.NewProgressReporter(os.Stdout)
+	case !noEmoji:
+		reporter = report.NewEmojiReporter(os.Stdout, os.Stdout)
+	case color:
+		reporter = report.NewColorReporter(os.Stdout, os.Stdout)
+	default:
+		reporter = report.NewPlainReporter(os.Stdout, os.Stdout)
+	}
+	reporter = report.NewDiffReporter(reporter, report.DiffRenderer{Color: color, Context: diffContext})
+
+	if quiet {
+		reporter = report.NewQuietReporter(reporter)
+	}
+	if timings {
+		// Wraps the quiet reporter to time the resources it leaves out as well
+		reporter = report.NewTimingReporter(reporter, os.Stdout)
+	}
+	if host != "" {
+		reporter = report.NewHostReporter(reporter, host)
+		reporter = report.NewSyncReporter(reporter, &hostReportMu)
+	}
+	return reporter
+}
+
+// newLogReporter returns the reporter logging the events with the logger set up by
+// setupLogging. The progress is logged at info level unless -vv enables debug.
+func newLogReporter(host string) report.Reporter {
+	logger := log.Logger.Level(min(log.Logger.GetLevel(), zerolog.InfoLevel))
+	if host != "" {
+		logger = logger.With().Str("host", host).Logger()
+	}
+
+	var reporter report.Reporter = report.NewLogReporter(logger)
+	if quiet {
+		reporter = report.NewQuietReporter(reporter)
+	}
+	return reporter
+}
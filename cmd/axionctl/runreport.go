@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"peertech.de/axion/pkg/orchestrator"
+)
+
+var reportFile string
+
+// writeRunReport writes the summary of the run against the endpoint as run report to
+// --report, if given
+func writeRunReport(summary *orchestrator.Summary, plan bool, redact func(string) string) error {
+	if reportFile == "" {
+		return nil
+	}
+	r := orchestrator.NewRunReport(manifestFile, plan)
+	r.Add(endpoint, summary, redact)
+	return writeRunReportFile(r)
+}
+
+// writeHostsRunReport writes the runs against the hosts of an inventory as run report to
+// --report, if given, with a section per host
+func writeHostsRunReport(runs []*hostRun, plan bool) error {
+	if reportFile == "" {
+		return nil
+	}
+
+	r := orchestrator.NewRunReport(manifestFile, plan)
+	for _, run := range runs {
+		switch {
+		case run.summary != nil:
+			r.Add(run.host.Name, run.summary, run.redact)
+		case run.err != nil:
+			r.AddError(run.host.Name, run.redact(run.err.Error()))
+		}
+	}
+	return writeRunReportFile(r)
+}
+
+// writeRunReportFile writes the report as HTML if --report ends with .html or .htm and
+// as Markdown otherwise
+func writeRunReportFile(r *orchestrator.RunReport) error {
+	f, err := os.Create(reportFile)
+	if err != nil {
+		return fmt.Errorf("failed to write run report: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(reportFile)) {
+	case ".html", ".htm":
+		err = r.WriteHTML(f)
+	default:
+		err = r.WriteMarkdown(f)
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write run report: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write run report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/manifest"
+	"peertech.de/axion/pkg/orchestrator"
+	"peertech.de/axion/pkg/resource"
+	"peertech.de/axion/pkg/state"
+)
+
+func cmdState() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "Inspect the resources axionctl believes to manage on each endpoint",
+		Long: `State shows the local state kept by apply for each endpoint: the manifest last
+applied, the resources it applied, the last operation which changed them and the ETag
+of their state on the agent. The state is kept in $AXION_STATE_DIR or
+~/.config/axion/state.`,
+	}
+
+	cmd.AddCommand(cmdStateList())
+	cmd.AddCommand(cmdStateShow())
+	cmd.AddCommand(cmdStateRm())
+
+	return cmd
+}
+
+func cmdStateList() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the endpoints with a state",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			states, err := state.NewStore(config.DefaultStateDir()).List()
+			if err != nil {
+				return err
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "ENDPOINT\tMANIFEST\tRESOURCES\tUPDATED")
+			for _, st := range states {
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", st.Endpoint, st.Manifest, len(st.Resources), st.Updated.Format(time.RFC3339))
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+func cmdStateShow() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the state of the resources of the endpoint",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(outputFormat); err != nil {
+				return err
+			}
+
+			st, err := loadState()
+			if err != nil {
+				return err
+			}
+			if outputFormat != "" {
+				return encodeOutput(os.Stdout, outputFormat, st)
+			}
+
+			fmt.Printf("Endpoint: %s\n", st.Endpoint)
+			fmt.Printf("Manifest: %s\n", st.Manifest)
+			fmt.Printf("Digest:   %s\n", st.Digest)
+			fmt.Printf("Updated:  %s\n\n", st.Updated.Format(time.RFC3339))
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tNAME\tOPERATION\tETAG\tUPDATED")
+			for _, r := range st.Resources {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Id, r.Name, r.Operation, r.ETag, r.Updated.Format(time.RFC3339))
+			}
+			return tw.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&outputFormat, "output", "",
+		"Print the state as json or yaml")
+	cmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions([]string{outputJSON, outputYAML}, cobra.ShellCompDirectiveNoFileComp))
+
+	return cmd
+}
+
+func cmdStateRm() *cobra.Command {
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "rm <id>...",
+		Short: "Remove resources from the state of the endpoint",
+		Long: `Rm removes the resources with the given ids from the state of the endpoint, so
+axionctl no longer believes to manage them. Nothing is changed on the endpoint. With
+--all, the whole state of the endpoint is removed.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store := state.NewStore(config.DefaultStateDir())
+			if all {
+				if len(args) > 0 {
+					return errors.New("--all removes all resources, no ids may be given")
+				}
+				if err := store.Delete(endpoint); err != nil {
+					return err
+				}
+				fmt.Printf("Removed the state of %s\n", endpoint)
+				return nil
+			}
+			if len(args) == 0 {
+				return errors.New("no resources given, use --all to remove the whole state")
+			}
+
+			st, err := loadState()
+			if err != nil {
+				return err
+			}
+			if err := st.Remove(args...); err != nil {
+				return err
+			}
+			if err := store.Save(st); err != nil {
+				return err
+			}
+			fmt.Printf("Removed %d resource(s) from the state of %s\n", len(args), endpoint)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false,
+		"Remove the whole state of the endpoint")
+
+	return cmd
+}
+
+func cmdLastApplied() *cobra.Command {
+	return &cobra.Command{
+		Use:   "last-applied",
+		Short: "Show the manifest the endpoint was last converged with",
+		Long: `Last-applied shows the manifest and its digest recorded in the state of the
+endpoint by the last successful apply of the whole manifest, see "axionctl state".`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			st, err := lastApplied(endpoint)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Endpoint:   %s\n", st.Endpoint)
+			fmt.Printf("Manifest:   %s\n", st.Manifest)
+			fmt.Printf("Digest:     %s\n", st.Digest)
+			fmt.Printf("Applied at: %s\n", st.AppliedAt.Format(time.RFC3339))
+			fmt.Printf("Changes:    %d of %d resources\n", st.Changes, st.Total)
+			return nil
+		},
+	}
+}
+
+// lastApplied returns the state of endpoint, if the whole manifest has been applied to
+// it before
+func lastApplied(endpoint string) (*state.State, error) {
+	st, err := state.NewStore(config.DefaultStateDir()).Load(endpoint)
+	if errors.Is(err, state.ErrNotFound) || err == nil && st.AppliedAt.IsZero() {
+		return nil, fmt.Errorf("no manifest has been applied to %s yet", endpoint)
+	}
+	return st, err
+}
+
+// loadState returns the state of --endpoint
+func loadState() (*state.State, error) {
+	st, err := state.NewStore(config.DefaultStateDir()).Load(endpoint)
+	if errors.Is(err, state.ErrNotFound) {
+		return nil, fmt.Errorf("no state of %s, nothing has been applied to it yet", endpoint)
+	}
+	return st, err
+}
+
+// appliedResources returns the resources recorded in the state of endpoint for
+// orchestrator.WithApplied, nil if nothing has been applied to it yet
+func appliedResources(endpoint string) map[string]orchestrator.Applied {
+	st, err := state.NewStore(config.DefaultStateDir()).Load(endpoint)
+	if err != nil {
+		if !errors.Is(err, state.ErrNotFound) {
+			fmt.Fprintf(os.Stderr, "Warning: failed to read the state of %s: %s\n", endpoint, err)
+		}
+		return nil
+	}
+
+	applied := make(map[string]orchestrator.Applied, len(st.Resources))
+	for _, r := range st.Resources {
+		applied[r.Id] = orchestrator.Applied{ETag: r.ETag, Digest: r.Digest}
+	}
+	return applied
+}
+
+// updateState merges the resources applied by the run of summary into the state of
+// endpoint, their names are redacted. Resources which failed or were rolled back keep
+// their previous state. Successful applies of the whole manifest record it as last
+// applied; runs excluding resources with --target or --skip don't converge the host
+// with it.
+func updateState(endpoint, path string, summary *orchestrator.Summary, redact func(string) string) error {
+	store := state.NewStore(config.DefaultStateDir())
+	st, err := store.Load(endpoint)
+	if errors.Is(err, state.ErrNotFound) {
+		st, err = &state.State{Endpoint: endpoint}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var resources []state.Resource
+	for _, id := range summary.Order {
+		a := summary.Attempts[id]
+		switch operation(a) {
+		case operationApplied, operationNone:
+			op := a.Operation.String()
+			if a.Applied && a.Operation == resource.OperationNone {
+				// The resource doesn't report what it did, e.g. a command
+				op = "apply"
+			}
+			resources = append(resources, state.Resource{Id: a.Id, Name: redact(a.Name), Operation: op, ETag: a.ETag, Digest: a.Digest})
+		}
+	}
+	if len(resources) == 0 && st.Resources == nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	complete := summary.Success && summary.ExcludedCount == 0
+	if complete || st.Manifest == "" {
+		st.Manifest = manifestPath(path)
+		st.Digest = summary.ManifestDigest
+	}
+	if complete && !destroying {
+		st.AppliedAt = now
+		st.Changes = summary.AppliedCount
+		st.Total = summary.TotalCount
+	}
+	st.Merge(resources, complete, now)
+	return store.Save(st)
+}
+
+// manifestPath returns the absolute path of the manifest at path, unless it is read from
+// stdin
+func manifestPath(path string) string {
+	if path != manifest.Stdin {
+		if abs, err := filepath.Abs(path); err == nil {
+			return abs
+		}
+	}
+	return path
+}
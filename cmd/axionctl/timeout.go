@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// defaultRequestTimeout exceeds the longest commands run synchronously, longer ones
+// run as jobs which are polled
+const defaultRequestTimeout = 10 * time.Minute
+
+var runTimeout time.Duration
+var requestTimeout time.Duration
+
+// runContext returns the context of a run, cancelled on SIGINT and SIGTERM and once
+// --timeout expired
+func runContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if runTimeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, runTimeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// withRequestTimeout returns rt failing requests the agent doesn't respond to within
+// timeout. Only the wait for the response headers is limited, so streamed responses
+// and large transfers may take longer.
+func withRequestTimeout(rt http.RoundTripper, timeout time.Duration) http.RoundTripper {
+	t, ok := rt.(*http.Transport)
+	if !ok || timeout <= 0 {
+		return rt
+	}
+	t = t.Clone()
+	t.ResponseHeaderTimeout = timeout
+	return t
+}
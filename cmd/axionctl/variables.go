@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"peertech.de/axion/pkg/secret"
+)
+
+// parseVariables builds the variable overrides from --var-file and --var flags. Files are
+// merged in the order given, individual --var assignments are applied last and therefore
+// take precedence. SOPS encrypted files are decrypted and all of their values are marked
+// sensitive.
+func parseVariables(ctx context.Context, secrets *secret.Resolver, files, assignments []string) (map[string]any, error) {
+	vars := make(map[string]any)
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read variable file: %w", err)
+		}
+
+		if secret.IsEncrypted(data) {
+			data, err = secrets.DecryptFile(ctx, file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt variable file: %w", err)
+			}
+		}
+
+		var fileVars map[string]any
+		if err := yaml.Unmarshal(data, &fileVars); err != nil {
+			return nil, fmt.Errorf("failed to parse variable file %q: %w", file, err)
+		}
+
+		for k, v := range fileVars {
+			vars[k] = v
+		}
+	}
+
+	for _, assignment := range assignments {
+		key, value, ok := strings.Cut(assignment, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid variable assignment %q, expected key=value", assignment)
+		}
+		vars[key] = value
+	}
+
+	return vars, nil
+}
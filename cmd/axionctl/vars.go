@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"peertech.de/axion/pkg/manifest"
+	"peertech.de/axion/pkg/secret"
+)
+
+// maxVariableValue limits the length of the values shown in the variable table
+const maxVariableValue = 60
+
+// variableOutput is the serialized form of a manifest.Variable
+type variableOutput struct {
+	Name       string   `json:"name" yaml:"name"`
+	Source     string   `json:"source" yaml:"source"`
+	Value      any      `json:"value" yaml:"value"`
+	Sensitive  bool     `json:"sensitive,omitempty" yaml:"sensitive,omitempty"`
+	References []string `json:"references" yaml:"references"`
+}
+
+func cmdVars() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "vars",
+		Short: "List the variables of a manifest without contacting the agent",
+		Long: `Vars lists the variables declared in the manifest or set with --var, --var-file
+or the config file, as well as the environment variables the manifest reads. For
+each variable its source, the resolved value and the resources referencing it are
+shown. Remote variables are fetched and secret references resolved, sensitive
+values are masked.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(outputFormat); err != nil {
+				return err
+			}
+
+			cfg, err := setupConfig(false, "", concurrency, endpoint)
+			if err != nil {
+				return err
+			}
+
+			loader, err := newLoader(manifestFile)
+			if err != nil {
+				return err
+			}
+			inspector, ok := loader.(manifest.VariableInspector)
+			if !ok {
+				return fmt.Errorf("listing variables is not supported for manifest %q", manifestFile)
+			}
+
+			ctx := context.Background()
+			vars, err := inspector.Variables(ctx, cfg, manifestFile)
+			if err != nil {
+				return err
+			}
+
+			sources, err := overrideSources(ctx, cfg.Secrets)
+			if err != nil {
+				return err
+			}
+
+			out := make([]variableOutput, 0, len(vars))
+			for _, v := range vars {
+				o := variableOutput{
+					Name:       v.Name,
+					Source:     v.Source,
+					Value:      v.Value,
+					Sensitive:  v.Sensitive,
+					References: v.References,
+				}
+				if o.Source == manifest.SourceOverride {
+					o.Source = "config"
+					if source, ok := sources[v.Name]; ok {
+						o.Source = source
+					}
+				}
+				if o.Sensitive {
+					o.Value = secret.Redacted
+				}
+				if o.References == nil {
+					o.References = []string{}
+				}
+				out = append(out, o)
+			}
+
+			if outputFormat != "" {
+				return encodeOutput(os.Stdout, outputFormat, out)
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tSOURCE\tVALUE\tRESOURCES")
+			for _, o := range out {
+				refs := strings.Join(o.References, ",")
+				if refs == "" {
+					refs = "-"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", o.Name, o.Source, formatVariable(o.Value), refs)
+			}
+			return tw.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&manifestFile, "manifest", "",
+		"Path to manifest file (YAML, JSON, Starlark or CUE) containing resource definitions, - reads from stdin (required)")
+	cmd.MarkFlagRequired("manifest")
+	cmd.RegisterFlagCompletionFunc("manifest", completeManifest)
+	cmd.Flags().StringArrayVar(&variables, "var", nil,
+		"Set a manifest variable (key=value), overrides variables from the manifest and var files")
+	cmd.Flags().StringArrayVar(&variableFiles, "var-file", nil,
+		"Path to YAML file with manifest variables, can be repeated")
+	cmd.Flags().StringVar(&outputFormat, "output", "",
+		"Print the variables as json or yaml")
+	cmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions([]string{outputJSON, outputYAML}, cobra.ShellCompDirectiveNoFileComp))
+
+	return cmd
+}
+
+// overrideSources returns the flag each overriding variable was set with, variables
+// missing from the result are set in the config file
+func overrideSources(ctx context.Context, secrets *secret.Resolver) (map[string]string, error) {
+	fileVars, err := parseVariables(ctx, secrets, variableFiles, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make(map[string]string)
+	for name := range fileVars {
+		sources[name] = "--var-file"
+	}
+	for _, assignment := range variables {
+		name, _, _ := strings.Cut(assignment, "=")
+		sources[name] = "--var"
+	}
+	return sources, nil
+}
+
+// formatVariable formats the value of a variable on a single line
+func formatVariable(value any) string {
+	s, ok := value.(string)
+	if !ok {
+		data, err := json.Marshal(value)
+		if err != nil {
+			s = fmt.Sprint(value)
+		} else {
+			s = string(data)
+		}
+	}
+	s = strings.ReplaceAll(s, "\n", " ")
+
+	if r := []rune(s); len(r) > maxVariableValue {
+		s = string(r[:maxVariableValue-1]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	ops_health "peertech.de/axion/api/client/health"
+	"peertech.de/axion/pkg/version"
+)
+
+func cmdVersion() *cobra.Command {
+	var remote bool
+
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print the version of axionctl and, with --remote, of the agent",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Println(version.Describe("axionctl"))
+			if !remote {
+				return nil
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			cfg, err := setupConfig(false, "", 1, endpoint)
+			if err != nil {
+				return err
+			}
+
+			resp, err := cfg.Client.Health.GetVersion(ops_health.NewGetVersionParamsWithContext(ctx))
+			if err != nil {
+				if notSupported(err) {
+					return fmt.Errorf("agent at %s predates the version endpoint", endpoint)
+				}
+				return fmt.Errorf("failed to query agent version: %w", err)
+			}
+
+			v := resp.Payload
+			fmt.Println(version.Format("axiond", v.Version, v.APIVersion, v.Commit, v.BuildDate))
+			if len(v.Capabilities) > 0 {
+				fmt.Printf("  capabilities: %s\n", strings.Join(v.Capabilities, ", "))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&remote, "remote", false,
+		"Query the version of the agent at --endpoint as well")
+
+	return cmd
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/manifest"
+	"peertech.de/axion/pkg/orchestrator"
+)
+
+func cmdWatch() *cobra.Command {
+	var (
+		apply              bool
+		debounce           time.Duration
+		skipReadinessCheck bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Plan or apply the manifest again whenever it changes",
+		Long: `Watch plans the manifest and plans it again whenever a file in the directory
+of the manifest or of a --var-file changes, e.g. for a development loop against a
+test VM. With --apply, the changes are applied without confirmation instead.
+
+Changes are debounced, a burst of writes triggers a single run. Watch runs until
+interrupted.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			if manifestFile == manifest.Stdin {
+				return errors.New("watch can't read the manifest from stdin")
+			}
+
+			watcher, err := fsnotify.NewWatcher()
+			if err != nil {
+				return fmt.Errorf("failed to watch manifest: %w", err)
+			}
+			defer watcher.Close()
+
+			// Directories are watched as editors replace files instead of writing them
+			for _, dir := range watchDirs(manifestFile, variableFiles) {
+				if err := watcher.Add(dir); err != nil {
+					return fmt.Errorf("failed to watch %q: %w", dir, err)
+				}
+			}
+
+			run := func() {
+				if _, _, err := runManifest(ctx, !apply, skipReadinessCheck); err != nil && ctx.Err() == nil {
+					fmt.Fprintf(os.Stderr, "Error: %s\n", prettifyError(err))
+				}
+				if ctx.Err() == nil {
+					fmt.Printf("%s 👀 Watching %s for changes...\n", time.Now().Format(time.TimeOnly), manifestFile)
+				}
+			}
+			run()
+
+			var timer <-chan time.Time
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case ev, ok := <-watcher.Events:
+					if !ok {
+						return nil
+					}
+					if ignoredChange(ev) {
+						continue
+					}
+					timer = time.After(debounce)
+				case err, ok := <-watcher.Errors:
+					if !ok {
+						return nil
+					}
+					fmt.Fprintf(os.Stderr, "Warning: watch error: %s\n", err)
+				case <-timer:
+					timer = nil
+					run()
+				}
+			}
+		},
+	}
+
+	cmd.Flags().BoolVar(&apply, "apply", false,
+		"Apply the changes without confirmation instead of planning them")
+	cmd.Flags().DurationVar(&debounce, "debounce", 500*time.Millisecond,
+		"How long to wait for further changes before running")
+	cmd.Flags().BoolVar(&skipReadinessCheck, "skip-readiness-check", false,
+		"Don't check that the agent is ready before each run")
+	cmd.Flags().StringVar(&manifestFile, "manifest", "",
+		"Path to manifest file (YAML, JSON, Starlark or CUE) containing resource definitions (required)")
+	cmd.MarkFlagRequired("manifest")
+	cmd.RegisterFlagCompletionFunc("manifest", completeManifest)
+	cmd.Flags().StringArrayVar(&variables, "var", nil,
+		"Set a manifest variable (key=value), overrides variables from the manifest and var files")
+	cmd.Flags().StringArrayVar(&variableFiles, "var-file", nil,
+		"Path to YAML file with manifest variables, can be repeated")
+	cmd.Flags().StringArrayVar(&targets, "target", nil,
+		"Only process the resource with this id and its dependencies, can be repeated")
+	cmd.RegisterFlagCompletionFunc("target", completeResourceIDs)
+	cmd.Flags().StringArrayVar(&skips, "skip", nil,
+		"Don't process the resource with this id and the resources depending on it, can be repeated")
+	cmd.RegisterFlagCompletionFunc("skip", completeResourceIDs)
+
+	return cmd
+}
+
+// runManifest loads the manifest and variables again and plans or applies them against
+// --endpoint, successful applies are recorded
+func runManifest(ctx context.Context, planOnly, skipReadinessCheck bool) (*config.Config, *orchestrator.Summary, error) {
+	cfg, err := setupConfig(false, "", concurrency, endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := negotiate(ctx, cfg); err != nil {
+		return nil, nil, err
+	}
+	if !skipReadinessCheck {
+		if err := checkReadiness(ctx, cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	o, err := setupOrchestrator(cfg, manifestFile, endpoint, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	summary := o.Run(ctx, planOnly)
+	pushMetrics(ctx, endpoint, summary, planOnly)
+	if !planOnly {
+		if err := updateState(endpoint, manifestFile, summary, cfg.Secrets.Redact); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to update state: %s\n", err)
+		}
+	}
+	if summary.Error != nil {
+		return cfg, summary, summary.Error
+	}
+	return cfg, summary, nil
+}
+
+// watchDirs returns the directories holding the manifest and the variable files
+func watchDirs(manifestFile string, variableFiles []string) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, path := range append([]string{manifestFile}, variableFiles...) {
+		dir := filepath.Dir(path)
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// ignoredChange reports whether ev doesn't change the content of a file, or concerns a
+// temporary or hidden file, e.g. of an editor
+func ignoredChange(ev fsnotify.Event) bool {
+	if !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Rename) && !ev.Has(fsnotify.Remove) {
+		return true
+	}
+	name := filepath.Base(ev.Name)
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") || strings.HasSuffix(name, ".swp")
+}
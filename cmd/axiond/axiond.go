@@ -2,24 +2,86 @@ package main
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/jessevdk/go-flags"
 	"github.com/rs/zerolog/log"
 
 	"peertech.de/axion/pkg/api"
+	"peertech.de/axion/pkg/signing"
+	"peertech.de/axion/pkg/tracing"
+	"peertech.de/axion/pkg/version"
 )
 
+type options struct {
+	ListenAddr        string        `long:"listen" default:"0.0.0.0:8080" description:"Address to listen on, unix:/path listens on a Unix socket"`
+	GRPCListenAddr    string        `long:"grpc-listen" description:"Address the gRPC API listens on, unix:/path listens on a Unix socket (default: disabled)"`
+	TLSCert           string        `long:"tls-cert" description:"Path to the server certificate, enables TLS"`
+	TLSKey            string        `long:"tls-key" description:"Path to the server private key"`
+	TLSReload         time.Duration `long:"tls-reload-interval" default:"10s" description:"Interval the certificate and key are checked for changes and reloaded (0 disables reloading)"`
+	ClientCA          string        `long:"client-ca" description:"Path to the CA bundle verifying client certificates"`
+	RequireClientCert bool          `long:"require-client-cert" description:"Reject clients without a certificate signed by the client CA"`
+	AllowedPaths      []string      `long:"allowed-path" description:"Restrict file, directory and content requests to this path prefix (repeatable)"`
+	Policy            string        `long:"policy" description:"Path to the policy restricting commands and paths"`
+	SigningSecret     string        `long:"signing-secret-file" description:"Path to the shared secret REST requests have to be signed with (HMAC-SHA256)"`
+	AuditLog          string        `long:"audit-log" description:"Path to the file mutating requests are appended to"`
+	AuditSyslog       bool          `long:"audit-syslog" description:"Send audit entries to syslog"`
+	MaxUploadSize     int64         `long:"max-upload-size" description:"Maximum size in bytes of archives uploaded at once (default: 1GB)"`
+	MaxChunkedUpload  int64         `long:"max-chunked-upload-size" description:"Maximum size in bytes of archives uploaded in chunks (default: 16GB)"`
+	MaxUploadSessions int           `long:"max-upload-sessions" description:"Maximum number of open chunked upload sessions (default: 8)"`
+	MaxStagedUpload   int64         `long:"max-staged-upload-size" description:"Maximum total size in bytes of the archives of open upload sessions (default: 32GB)"`
+	UploadTempDir     string        `long:"upload-temp-dir" description:"Directory uploads are spooled to before extraction (default: system temp dir)"`
+	BackupDir         string        `long:"backup-dir" description:"Directory backups taken on the agent are kept in (default: disabled)"`
+	BackupRetention   time.Duration `long:"backup-retention" default:"24h" description:"How long backups taken on the agent are kept"`
+	RateLimit         float64       `long:"rate-limit" description:"Requests per second each client may send (0 disables the limit)"`
+	RateBurst         int           `long:"rate-burst" description:"Requests a client may send in a burst (default: the rate limit)"`
+	MaxMutations      int           `long:"max-concurrent-mutations" description:"Maximum number of mutating requests and command jobs executing at the same time (0 disables the cap)"`
+	MaxCommandOutput  int           `long:"max-command-output" description:"Maximum number of bytes kept of the stdout and stderr of a command each (default: 16MB)"`
+	ShutdownTimeout   time.Duration `long:"shutdown-timeout" default:"30s" description:"How long in-flight mutations and command jobs may take to finish on shutdown"`
+	Version           bool          `long:"version" description:"Print the version and exit"`
+}
+
 func main() {
+	var opts options
+	if _, err := flags.Parse(&opts); err != nil {
+		if flags.WroteHelp(err) {
+			return
+		}
+		os.Exit(1)
+	}
+	if opts.Version {
+		fmt.Println(version.Describe("axiond"))
+		return
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	api := api.New(
-		api.WithListenAddr("0.0.0.0:8080"),
-	)
+	apiOpts, err := apiOptions(opts)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid options")
+		return
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, "axiond")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set up tracing")
+		return
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Warn().Err(err).Msg("Failed to flush traces")
+		}
+	}()
+
+	api := api.New(apiOpts...)
 	if err := api.Initialize(); err != nil {
 		log.Error().Err(err).Msg("Failed to initialize api")
 		return
@@ -36,6 +98,16 @@ func main() {
 		}
 	}()
 
+	if api.GRPCEnabled() {
+		log.Info().Str("addr", opts.GRPCListenAddr).Msg("Serving gRPC api...")
+		go func() {
+			if err := api.ServeGRPC(); err != nil {
+				log.Error().Err(err).Msg("Failed to serve gRPC api")
+				cancel()
+			}
+		}()
+	}
+
 	<-ctx.Done()
 	log.Info().Msg("Stopping api...")
 	if err := api.Stop(); err != nil {
@@ -44,3 +116,95 @@ func main() {
 
 	log.Info().Msg("Done")
 }
+
+// apiOptions converts the command line options into api options
+func apiOptions(opts options) ([]api.Option, error) {
+	out := []api.Option{
+		api.WithListenAddr(opts.ListenAddr),
+		api.WithGracefulTimeout(opts.ShutdownTimeout),
+	}
+
+	if opts.GRPCListenAddr != "" {
+		out = append(out, api.WithGRPCListenAddr(opts.GRPCListenAddr))
+	}
+
+	if opts.TLSCert != "" || opts.TLSKey != "" {
+		reloader, err := api.NewCertReloader(opts.TLSCert, opts.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load server certificate: %w", err)
+		}
+		out = append(out, api.WithCertReloader(reloader, opts.TLSReload))
+	}
+
+	if opts.ClientCA != "" {
+		pem, err := os.ReadFile(opts.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA %q", opts.ClientCA)
+		}
+		out = append(out, api.WithClientCAs(pool))
+	}
+
+	if opts.RequireClientCert {
+		out = append(out, api.WithRequireClientCert())
+	}
+
+	if len(opts.AllowedPaths) > 0 {
+		out = append(out, api.WithAllowedPaths(opts.AllowedPaths...))
+	}
+
+	if opts.Policy != "" {
+		policy, err := api.LoadPolicy(opts.Policy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load policy: %w", err)
+		}
+		out = append(out, api.WithPolicy(policy))
+	}
+
+	if opts.SigningSecret != "" {
+		secret, err := signing.LoadSecret(opts.SigningSecret)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, api.WithSigningSecret(secret))
+	}
+
+	if opts.AuditLog != "" {
+		out = append(out, api.WithAuditLog(opts.AuditLog))
+	}
+	if opts.AuditSyslog {
+		out = append(out, api.WithAuditSyslog())
+	}
+
+	if opts.MaxUploadSize > 0 {
+		out = append(out, api.WithMaxUploadSize(opts.MaxUploadSize))
+	}
+	if opts.MaxChunkedUpload > 0 {
+		out = append(out, api.WithMaxChunkedUploadSize(opts.MaxChunkedUpload))
+	}
+	if opts.MaxUploadSessions > 0 || opts.MaxStagedUpload > 0 {
+		out = append(out, api.WithMaxUploadSessions(opts.MaxUploadSessions, opts.MaxStagedUpload))
+	}
+	if opts.UploadTempDir != "" {
+		out = append(out, api.WithUploadTempDir(opts.UploadTempDir))
+	}
+
+	if opts.BackupDir != "" {
+		out = append(out, api.WithBackupDir(opts.BackupDir, opts.BackupRetention))
+	}
+
+	if opts.RateLimit > 0 {
+		out = append(out, api.WithRateLimit(opts.RateLimit, opts.RateBurst))
+	}
+	if opts.MaxMutations > 0 {
+		out = append(out, api.WithMaxConcurrentMutations(opts.MaxMutations))
+	}
+	if opts.MaxCommandOutput > 0 {
+		out = append(out, api.WithMaxCommandOutput(opts.MaxCommandOutput))
+	}
+
+	return out, nil
+}
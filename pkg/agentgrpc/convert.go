@@ -0,0 +1,416 @@
+package agentgrpc
+
+import (
+	"github.com/go-openapi/strfmt"
+
+	"peertech.de/axion/api/grpc/agentpb"
+	"peertech.de/axion/api/models"
+)
+
+// The conversions between the REST models and the protobuf messages are shared by the
+// agent, which serves the gRPC calls through its REST handlers, and the client
+// transport, which hands the messages to the generated REST client.
+
+func ErrorToProto(m *models.Error) *agentpb.Error {
+	if m == nil {
+		return nil
+	}
+	return &agentpb.Error{Code: m.Code, Message: m.Message, Details: m.Details, RequestId: m.RequestID}
+}
+
+func ErrorFromProto(p *agentpb.Error) *models.Error {
+	if p == nil {
+		return nil
+	}
+	return &models.Error{Code: p.Code, Message: p.Message, Details: p.Details, RequestID: p.RequestId}
+}
+
+func FilePropertiesToProto(m *models.FileProperties, etag string) *agentpb.FileProperties {
+	if m == nil {
+		return &agentpb.FileProperties{Etag: etag}
+	}
+	return &agentpb.FileProperties{
+		Mode:     m.Mode,
+		Owner:    m.Owner,
+		Group:    m.Group,
+		Checksum: m.Checksum,
+		Etag:     etag,
+	}
+}
+
+func FilePropertiesFromProto(p *agentpb.FileProperties) *models.FileProperties {
+	if p == nil {
+		return nil
+	}
+	return &models.FileProperties{Mode: p.Mode, Owner: p.Owner, Group: p.Group, Checksum: p.Checksum}
+}
+
+func BatchStatResponseToProto(m *models.BatchStatResponse) *agentpb.BatchStatResponse {
+	if m == nil {
+		return nil
+	}
+	p := &agentpb.BatchStatResponse{}
+	for _, r := range m.Results {
+		result := &agentpb.BatchStatResult{Path: r.Path, Error: ErrorToProto(r.Error)}
+		if r.Properties != nil {
+			result.Properties = FilePropertiesToProto(r.Properties, r.Etag)
+		}
+		p.Results = append(p.Results, result)
+	}
+	return p
+}
+
+func BatchStatResponseFromProto(p *agentpb.BatchStatResponse) *models.BatchStatResponse {
+	if p == nil {
+		return nil
+	}
+	m := &models.BatchStatResponse{}
+	for _, r := range p.Results {
+		result := &models.BatchStatResult{
+			Path:       r.Path,
+			Properties: FilePropertiesFromProto(r.Properties),
+			Error:      ErrorFromProto(r.Error),
+		}
+		if r.Properties != nil {
+			result.Etag = r.Properties.Etag
+		}
+		m.Results = append(m.Results, result)
+	}
+	return m
+}
+
+func DirectoryPropertiesToProto(m *models.DirectoryProperties, etag string) *agentpb.DirectoryProperties {
+	if m == nil {
+		return &agentpb.DirectoryProperties{Etag: etag}
+	}
+	return &agentpb.DirectoryProperties{Mode: m.Mode, Owner: m.Owner, Group: m.Group, Etag: etag}
+}
+
+func DirectoryPropertiesFromProto(p *agentpb.DirectoryProperties) *models.DirectoryProperties {
+	if p == nil {
+		return nil
+	}
+	return &models.DirectoryProperties{Mode: p.Mode, Owner: p.Owner, Group: p.Group}
+}
+
+func SymlinkPropertiesToProto(m *models.SymlinkProperties, etag string) *agentpb.SymlinkProperties {
+	if m == nil {
+		return &agentpb.SymlinkProperties{Etag: etag}
+	}
+	return &agentpb.SymlinkProperties{Target: m.Target, Etag: etag}
+}
+
+func SymlinkPropertiesFromProto(p *agentpb.SymlinkProperties) *models.SymlinkProperties {
+	if p == nil {
+		return nil
+	}
+	return &models.SymlinkProperties{Target: p.Target}
+}
+
+func UploadSessionRequestToProto(m *models.UploadSessionRequest) *agentpb.UploadSessionRequest {
+	if m == nil {
+		return nil
+	}
+	return &agentpb.UploadSessionRequest{Path: m.Path, Recursive: m.Recursive, Preserve: m.Preserve, Format: m.Format, Size: m.Size, Sha256: m.Sha256}
+}
+
+func UploadSessionRequestFromProto(p *agentpb.UploadSessionRequest) *models.UploadSessionRequest {
+	if p == nil {
+		return nil
+	}
+	return &models.UploadSessionRequest{Path: p.Path, Recursive: p.Recursive, Preserve: p.Preserve, Format: p.Format, Size: p.Size, Sha256: p.Sha256}
+}
+
+func UploadSessionToProto(m *models.UploadSession) *agentpb.UploadSession {
+	if m == nil {
+		return nil
+	}
+	return &agentpb.UploadSession{Id: m.ID, Path: m.Path, Recursive: m.Recursive, Preserve: m.Preserve, Format: m.Format, Size: m.Size, Offset: m.Offset}
+}
+
+func UploadSessionFromProto(p *agentpb.UploadSession) *models.UploadSession {
+	if p == nil {
+		return nil
+	}
+	return &models.UploadSession{ID: p.Id, Path: p.Path, Recursive: p.Recursive, Preserve: p.Preserve, Format: p.Format, Size: p.Size, Offset: p.Offset}
+}
+
+func BackupToProto(m *models.Backup) *agentpb.Backup {
+	if m == nil {
+		return nil
+	}
+	return &agentpb.Backup{Id: m.ID, Path: m.Path, Type: m.Type, Size: m.Size, Created: m.Created.String()}
+}
+
+func BackupFromProto(p *agentpb.Backup) *models.Backup {
+	if p == nil {
+		return nil
+	}
+	// An invalid timestamp leaves created unset, it is informational only
+	created, _ := strfmt.ParseDateTime(p.Created)
+	return &models.Backup{ID: p.Id, Path: p.Path, Type: p.Type, Size: p.Size, Created: created}
+}
+
+func CommandRequestToProto(m *models.CommandRequest) *agentpb.CommandRequest {
+	if m == nil {
+		return nil
+	}
+	return &agentpb.CommandRequest{
+		Command:           m.Command,
+		ExpectedExitCodes: m.ExpectedExitCodes,
+		Timeout:           m.Timeout,
+		MaxOutput:         m.MaxOutput,
+		OutputRetention:   m.OutputRetention,
+	}
+}
+
+func CommandRequestFromProto(p *agentpb.CommandRequest) *models.CommandRequest {
+	if p == nil {
+		return nil
+	}
+	return &models.CommandRequest{
+		Command:           p.Command,
+		ExpectedExitCodes: p.ExpectedExitCodes,
+		Timeout:           p.Timeout,
+		MaxOutput:         p.MaxOutput,
+		OutputRetention:   p.OutputRetention,
+	}
+}
+
+func ScriptRequestToProto(m *models.ScriptRequest) *agentpb.ScriptRequest {
+	if m == nil {
+		return nil
+	}
+	return &agentpb.ScriptRequest{
+		Script:            m.Script,
+		Interpreter:       m.Interpreter,
+		Args:              m.Args,
+		ExpectedExitCodes: m.ExpectedExitCodes,
+		Timeout:           m.Timeout,
+		MaxOutput:         m.MaxOutput,
+		OutputRetention:   m.OutputRetention,
+	}
+}
+
+func ScriptRequestFromProto(p *agentpb.ScriptRequest) *models.ScriptRequest {
+	if p == nil {
+		return nil
+	}
+	return &models.ScriptRequest{
+		Script:            p.Script,
+		Interpreter:       p.Interpreter,
+		Args:              p.Args,
+		ExpectedExitCodes: p.ExpectedExitCodes,
+		Timeout:           p.Timeout,
+		MaxOutput:         p.MaxOutput,
+		OutputRetention:   p.OutputRetention,
+	}
+}
+
+func CommandResponseToProto(m *models.CommandResponse) *agentpb.CommandResponse {
+	if m == nil {
+		return nil
+	}
+	return &agentpb.CommandResponse{
+		ExitCode:   m.ExitCode,
+		Stdout:     m.Stdout,
+		Stderr:     m.Stderr,
+		Success:    m.Success,
+		Truncated:  m.Truncated,
+		StdoutSize: m.StdoutSize,
+		StderrSize: m.StderrSize,
+	}
+}
+
+func CommandResponseFromProto(p *agentpb.CommandResponse) *models.CommandResponse {
+	if p == nil {
+		return nil
+	}
+	return &models.CommandResponse{
+		ExitCode:   p.ExitCode,
+		Stdout:     p.Stdout,
+		Stderr:     p.Stderr,
+		Success:    p.Success,
+		Truncated:  p.Truncated,
+		StdoutSize: p.StdoutSize,
+		StderrSize: p.StderrSize,
+	}
+}
+
+func CommandEventToProto(m *models.CommandEvent) *agentpb.CommandEvent {
+	return &agentpb.CommandEvent{
+		Stream: m.Stream,
+		Data:   m.Data,
+		Result: CommandResponseToProto(m.Result),
+		Error:  ErrorToProto(m.Error),
+	}
+}
+
+func CommandEventFromProto(p *agentpb.CommandEvent) *models.CommandEvent {
+	return &models.CommandEvent{
+		Stream: p.Stream,
+		Data:   p.Data,
+		Result: CommandResponseFromProto(p.Result),
+		Error:  ErrorFromProto(p.Error),
+	}
+}
+
+func CommandJobToProto(m *models.CommandJob) *agentpb.CommandJob {
+	if m == nil {
+		return nil
+	}
+	return &agentpb.CommandJob{
+		Id:      m.ID,
+		Command: m.Command,
+		State:   m.State,
+		Result:  CommandResponseToProto(m.Result),
+		Error:   ErrorToProto(m.Error),
+	}
+}
+
+func CommandJobFromProto(p *agentpb.CommandJob) *models.CommandJob {
+	if p == nil {
+		return nil
+	}
+	return &models.CommandJob{
+		ID:      p.Id,
+		Command: p.Command,
+		State:   p.State,
+		Result:  CommandResponseFromProto(p.Result),
+		Error:   ErrorFromProto(p.Error),
+	}
+}
+
+func PackageToProto(m *models.Package) *agentpb.Package {
+	if m == nil {
+		return nil
+	}
+	return &agentpb.Package{Name: m.Name, Version: m.Version, Pinned: m.Pinned, Manager: m.Manager}
+}
+
+func PackageFromProto(p *agentpb.Package) *models.Package {
+	if p == nil {
+		return nil
+	}
+	return &models.Package{Name: p.Name, Version: p.Version, Pinned: p.Pinned, Manager: p.Manager}
+}
+
+func ServiceStatusToProto(m *models.ServiceStatus) *agentpb.ServiceStatus {
+	if m == nil {
+		return nil
+	}
+	return &agentpb.ServiceStatus{
+		Name:          m.Name,
+		LoadState:     m.LoadState,
+		ActiveState:   m.ActiveState,
+		SubState:      m.SubState,
+		UnitFileState: m.UnitFileState,
+		Active:        m.Active,
+		Enabled:       m.Enabled,
+		MainPid:       m.MainPid,
+	}
+}
+
+func ServiceStatusFromProto(p *agentpb.ServiceStatus) *models.ServiceStatus {
+	if p == nil {
+		return nil
+	}
+	return &models.ServiceStatus{
+		Name:          p.Name,
+		LoadState:     p.LoadState,
+		ActiveState:   p.ActiveState,
+		SubState:      p.SubState,
+		UnitFileState: p.UnitFileState,
+		Active:        p.Active,
+		Enabled:       p.Enabled,
+		MainPid:       p.MainPid,
+	}
+}
+
+func HealthToProto(m *models.Health) *agentpb.Health {
+	if m == nil {
+		return nil
+	}
+	p := &agentpb.Health{
+		Status:        m.Status,
+		Version:       m.Version,
+		BuildDate:     m.BuildDate,
+		UptimeSeconds: m.UptimeSeconds,
+	}
+	if m.Auth != nil {
+		p.Auth = &agentpb.HealthAuth{
+			Tls:                m.Auth.TLS,
+			ClientCertificates: m.Auth.ClientCertificates,
+			Policy:             m.Auth.Policy,
+			PathSandbox:        m.Auth.PathSandbox,
+		}
+	}
+	for _, c := range m.Checks {
+		p.Checks = append(p.Checks, &agentpb.HealthCheck{
+			Name:       c.Name,
+			Status:     c.Status,
+			Message:    c.Message,
+			Path:       c.Path,
+			FreeBytes:  c.FreeBytes,
+			TotalBytes: c.TotalBytes,
+		})
+	}
+	return p
+}
+
+func HealthFromProto(p *agentpb.Health) *models.Health {
+	if p == nil {
+		return nil
+	}
+	m := &models.Health{
+		Status:        p.Status,
+		Version:       p.Version,
+		BuildDate:     p.BuildDate,
+		UptimeSeconds: p.UptimeSeconds,
+	}
+	if p.Auth != nil {
+		m.Auth = &models.HealthAuth{
+			TLS:                p.Auth.Tls,
+			ClientCertificates: p.Auth.ClientCertificates,
+			Policy:             p.Auth.Policy,
+			PathSandbox:        p.Auth.PathSandbox,
+		}
+	}
+	for _, c := range p.Checks {
+		m.Checks = append(m.Checks, &models.HealthCheck{
+			Name:       c.Name,
+			Status:     c.Status,
+			Message:    c.Message,
+			Path:       c.Path,
+			FreeBytes:  c.FreeBytes,
+			TotalBytes: c.TotalBytes,
+		})
+	}
+	return m
+}
+
+func AgentVersionToProto(m *models.AgentVersion) *agentpb.AgentVersion {
+	if m == nil {
+		return nil
+	}
+	return &agentpb.AgentVersion{
+		Version:      m.Version,
+		BuildDate:    m.BuildDate,
+		ApiVersion:   m.APIVersion,
+		Capabilities: m.Capabilities,
+		Commit:       m.Commit,
+	}
+}
+
+func AgentVersionFromProto(p *agentpb.AgentVersion) *models.AgentVersion {
+	if p == nil {
+		return nil
+	}
+	return &models.AgentVersion{
+		Version:      p.Version,
+		BuildDate:    p.BuildDate,
+		APIVersion:   p.ApiVersion,
+		Capabilities: p.Capabilities,
+		Commit:       p.Commit,
+	}
+}
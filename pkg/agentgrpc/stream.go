@@ -0,0 +1,54 @@
+package agentgrpc
+
+import "io"
+
+// ChunkSize is the maximum size of the data of a streamed message, well below the
+// default gRPC message size limit of 4MB
+const ChunkSize = 1024 * 1024
+
+// StreamReader reads the data of streamed messages, recv returns the data of the next
+// message and io.EOF once the stream is done
+type StreamReader struct {
+	buf  []byte
+	recv func() ([]byte, error)
+}
+
+// NewStreamReader returns a reader of the stream, starting with the data of an already
+// received message
+func NewStreamReader(first []byte, recv func() ([]byte, error)) *StreamReader {
+	return &StreamReader{buf: first, recv: recv}
+}
+
+func (r *StreamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		data, err := r.recv()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = data
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// SendChunks sends the content of r in chunks of at most ChunkSize. Every chunk is a
+// new slice, gRPC may still hold on to a sent message.
+func SendChunks(r io.Reader, send func([]byte) error) error {
+	for {
+		buf := make([]byte, ChunkSize)
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := send(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,711 @@
+// Package agentgrpc implements the gRPC transport between axionctl and the agent.
+package agentgrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/go-openapi/strfmt"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"peertech.de/axion/api/grpc/agentpb"
+	"peertech.de/axion/api/models"
+)
+
+// Transport submits the operations of the generated REST client as gRPC calls. The
+// responses are handed to the operation readers like HTTP responses, so everything
+// built on the REST client works unchanged.
+type Transport struct {
+	conn   *grpc.ClientConn
+	client agentpb.AgentClient
+}
+
+// NewTransport returns a transport connecting to the agent at target (host:port), the
+// connection is unencrypted if tlsConfig is nil. The calls present token as bearer
+// token unless it is empty.
+func NewTransport(target string, tlsConfig *tls.Config, token string) (*Transport, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		// Calls within a traced run get a client span and pass the trace context on
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	}
+	if token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerToken{token: token, secure: tlsConfig != nil}))
+	}
+
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client: %w", err)
+	}
+	return &Transport{conn: conn, client: agentpb.NewAgentClient(conn)}, nil
+}
+
+// bearerToken presents a token as the authorization of every call
+type bearerToken struct {
+	token  string
+	secure bool
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials
+func (b bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + b.token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials, the token is
+// only required to be encrypted if the connection is
+func (b bearerToken) RequireTransportSecurity() bool {
+	return b.secure
+}
+
+func (t *Transport) Close() error {
+	return t.conn.Close()
+}
+
+// Submit implements runtime.ClientTransport
+func (t *Transport) Submit(op *runtime.ClientOperation) (any, error) {
+	req := &request{
+		method:     op.Method,
+		pattern:    op.PathPattern,
+		header:     http.Header{},
+		query:      url.Values{},
+		pathParams: make(map[string]string),
+	}
+	if err := op.Params.WriteToRequest(req, strfmt.Default); err != nil {
+		return nil, err
+	}
+
+	// Like the HTTP transport, the timeout only applies without a context
+	ctx := op.Context
+	if ctx == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), req.timeout)
+		defer cancel()
+	}
+
+	resp, err := t.call(ctx, op.ID, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.body.Close()
+
+	consumer := runtime.JSONConsumer()
+	if resp.binary {
+		consumer = runtime.ByteStreamConsumer()
+	}
+	return op.Reader.ReadResponse(resp, consumer)
+}
+
+func (t *Transport) call(ctx context.Context, id string, req *request) (*response, error) {
+	path := req.query.Get("path")
+	ifMatch := req.header.Get("If-Match")
+
+	switch id {
+	case "getFileProperties":
+		out, err := t.client.GetFileProperties(ctx, &agentpb.PathRequest{Path: path})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, FilePropertiesFromProto(out), "ETag", out.Etag)
+
+	case "batchStatFiles":
+		in := &agentpb.BatchStatRequest{}
+		if body, ok := req.body.(*models.BatchStatRequest); ok && body != nil {
+			in.Paths = body.Paths
+		}
+		out, err := t.client.BatchStatFiles(ctx, in)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, BatchStatResponseFromProto(out))
+
+	case "putFile":
+		props, _ := req.body.(*models.FileProperties)
+		out, err := t.client.PutFile(ctx, &agentpb.PutFileRequest{
+			Path:       path,
+			IfMatch:    ifMatch,
+			Properties: FilePropertiesToProto(props, ""),
+		})
+		return putResponse(out, err)
+
+	case "deleteFile":
+		_, err := t.client.DeleteFile(ctx, &agentpb.DeleteRequest{Path: path, IfMatch: ifMatch})
+		return emptyResponse(err)
+
+	case "getFileContent":
+		stream, err := t.client.GetFileContent(ctx, &agentpb.PathRequest{Path: path})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return chunkResponse(stream)
+
+	case "putFileContent":
+		stream, err := t.client.PutFileContent(ctx)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = sendStream(stream, &agentpb.PutFileContentRequest{
+			Msg: &agentpb.PutFileContentRequest_Header{Header: &agentpb.PutFileContentHeader{
+				Path:    path,
+				IfMatch: ifMatch,
+				Sha256:  req.header.Get("Content-SHA256"),
+			}},
+		}, req.body, func(data []byte) *agentpb.PutFileContentRequest {
+			return &agentpb.PutFileContentRequest{Msg: &agentpb.PutFileContentRequest_Data{Data: data}}
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return putResponse(stream.CloseAndRecv())
+
+	case "getDirectoryProperties":
+		out, err := t.client.GetDirectoryProperties(ctx, &agentpb.PathRequest{Path: path})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, DirectoryPropertiesFromProto(out), "ETag", out.Etag)
+
+	case "putDirectory":
+		props, _ := req.body.(*models.DirectoryProperties)
+		out, err := t.client.PutDirectory(ctx, &agentpb.PutDirectoryRequest{
+			Path:       path,
+			IfMatch:    ifMatch,
+			Properties: DirectoryPropertiesToProto(props, ""),
+		})
+		return putResponse(out, err)
+
+	case "deleteDirectory":
+		_, err := t.client.DeleteDirectory(ctx, &agentpb.DeleteRequest{Path: path, IfMatch: ifMatch})
+		return emptyResponse(err)
+
+	case "getSymlinkProperties":
+		out, err := t.client.GetSymlinkProperties(ctx, &agentpb.PathRequest{Path: path})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, SymlinkPropertiesFromProto(out), "ETag", out.Etag)
+
+	case "putSymlink":
+		props, _ := req.body.(*models.SymlinkProperties)
+		out, err := t.client.PutSymlink(ctx, &agentpb.PutSymlinkRequest{
+			Path:       path,
+			IfMatch:    ifMatch,
+			Force:      req.query.Get("force") == "true",
+			Properties: SymlinkPropertiesToProto(props, ""),
+		})
+		return putResponse(out, err)
+
+	case "deleteSymlink":
+		_, err := t.client.DeleteSymlink(ctx, &agentpb.DeleteRequest{Path: path, IfMatch: ifMatch})
+		return emptyResponse(err)
+
+	case "upload":
+		stream, err := t.client.Upload(ctx)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = sendStream(stream, &agentpb.UploadRequest{
+			Msg: &agentpb.UploadRequest_Header{Header: &agentpb.UploadHeader{
+				Path:          path,
+				Recursive:     req.query.Get("recursive") == "true",
+				Preserve:      req.query.Get("preserve") == "true",
+				Sha256:        req.header.Get("Content-SHA256"),
+				ArchiveFormat: req.header.Get("X-Archive-Format"),
+			}},
+		}, req.body, func(data []byte) *agentpb.UploadRequest {
+			return &agentpb.UploadRequest{Msg: &agentpb.UploadRequest_Data{Data: data}}
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return putResponse(stream.CloseAndRecv())
+
+	case "download":
+		stream, err := t.client.Download(ctx, &agentpb.DownloadRequest{
+			Path:           path,
+			Recursive:      req.query.Get("recursive") == "true",
+			Preserve:       req.query.Get("preserve") == "true",
+			AcceptEncoding: req.header.Get("Accept-Encoding"),
+			Include:        req.query["include"],
+			Exclude:        req.query["exclude"],
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return chunkResponse(stream)
+
+	case "initUpload":
+		session, _ := req.body.(*models.UploadSessionRequest)
+		out, err := t.client.InitUpload(ctx, UploadSessionRequestToProto(session))
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusCreated, UploadSessionFromProto(out))
+
+	case "getUploadSession":
+		out, err := t.client.GetUploadSession(ctx, &agentpb.UploadSessionId{Id: req.pathParams["id"]})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, UploadSessionFromProto(out))
+
+	case "appendUpload":
+		offset, err := strconv.ParseInt(req.header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload offset: %w", err)
+		}
+		stream, err := t.client.AppendUpload(ctx)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = sendStream(stream, &agentpb.AppendUploadRequest{
+			Msg: &agentpb.AppendUploadRequest_Header{Header: &agentpb.AppendUploadHeader{
+				Id:     req.pathParams["id"],
+				Offset: offset,
+				Sha256: req.header.Get("Content-SHA256"),
+			}},
+		}, req.body, func(data []byte) *agentpb.AppendUploadRequest {
+			return &agentpb.AppendUploadRequest{Msg: &agentpb.AppendUploadRequest_Data{Data: data}}
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		out, err := stream.CloseAndRecv()
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, UploadSessionFromProto(out))
+
+	case "commitUpload":
+		return putResponse(t.client.CommitUpload(ctx, &agentpb.UploadSessionId{Id: req.pathParams["id"]}))
+
+	case "abortUpload":
+		_, err := t.client.AbortUpload(ctx, &agentpb.UploadSessionId{Id: req.pathParams["id"]})
+		return emptyResponse(err)
+
+	case "createBackup":
+		out, err := t.client.CreateBackup(ctx, &agentpb.CreateBackupRequest{
+			Path:    path,
+			Include: req.query["include"],
+			Exclude: req.query["exclude"],
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusCreated, BackupFromProto(out))
+
+	case "getBackup":
+		out, err := t.client.GetBackup(ctx, &agentpb.BackupId{Id: req.pathParams["id"]})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, BackupFromProto(out))
+
+	case "deleteBackup":
+		_, err := t.client.DeleteBackup(ctx, &agentpb.BackupId{Id: req.pathParams["id"]})
+		return emptyResponse(err)
+
+	case "restoreBackup":
+		return putResponse(t.client.RestoreBackup(ctx, &agentpb.BackupId{Id: req.pathParams["id"]}))
+
+	case "executeCommand":
+		command, _ := req.body.(*models.CommandRequest)
+		out, err := t.client.ExecuteCommand(ctx, CommandRequestToProto(command))
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, CommandResponseFromProto(out))
+
+	case "executeCommandStream":
+		command, _ := req.body.(*models.CommandRequest)
+		stream, err := t.client.ExecuteCommandStream(ctx, CommandRequestToProto(command))
+		if err != nil {
+			return errorResponse(err)
+		}
+		return eventResponse(stream)
+
+	case "executeScript":
+		script, _ := req.body.(*models.ScriptRequest)
+		out, err := t.client.ExecuteScript(ctx, ScriptRequestToProto(script))
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, CommandResponseFromProto(out))
+
+	case "executeScriptStream":
+		script, _ := req.body.(*models.ScriptRequest)
+		stream, err := t.client.ExecuteScriptStream(ctx, ScriptRequestToProto(script))
+		if err != nil {
+			return errorResponse(err)
+		}
+		return eventResponse(stream)
+
+	case "executeCommandAsync":
+		command, _ := req.body.(*models.CommandRequest)
+		out, err := t.client.ExecuteCommandAsync(ctx, CommandRequestToProto(command))
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusAccepted, CommandJobFromProto(out))
+
+	case "getCommandJob":
+		out, err := t.client.GetCommandJob(ctx, &agentpb.CommandJobId{Id: req.pathParams["id"]})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, CommandJobFromProto(out))
+
+	case "cancelCommandJob":
+		out, err := t.client.CancelCommandJob(ctx, &agentpb.CommandJobId{Id: req.pathParams["id"]})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, CommandJobFromProto(out))
+
+	case "getPackage":
+		out, err := t.client.GetPackage(ctx, &agentpb.PackageName{Name: req.pathParams["name"]})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, PackageFromProto(out))
+
+	case "putPackage":
+		pr := &agentpb.PutPackageRequest{Name: req.pathParams["name"]}
+		if body, ok := req.body.(*models.PackageRequest); ok && body != nil {
+			pr.Version = body.Version
+			pr.Pinned = body.Pinned
+		}
+		out, err := t.client.PutPackage(ctx, pr)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, PackageFromProto(out))
+
+	case "deletePackage":
+		_, err := t.client.DeletePackage(ctx, &agentpb.PackageName{Name: req.pathParams["name"]})
+		return emptyResponse(err)
+
+	case "getService":
+		out, err := t.client.GetService(ctx, &agentpb.ServiceName{Name: req.pathParams["name"]})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, ServiceStatusFromProto(out))
+
+	case "serviceAction":
+		out, err := t.client.ServiceAction(ctx, &agentpb.ServiceActionRequest{
+			Name:   req.pathParams["name"],
+			Action: req.pathParams["action"],
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, ServiceStatusFromProto(out))
+
+	case "getHealth":
+		out, err := t.client.GetHealth(ctx, &agentpb.Empty{})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, HealthFromProto(out))
+
+	case "getReadiness":
+		out, err := t.client.GetReadiness(ctx, &agentpb.Empty{})
+		if err != nil {
+			return errorResponse(err)
+		}
+		code := http.StatusOK
+		if out.Status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+		return jsonResponse(code, HealthFromProto(out))
+
+	case "getVersion":
+		out, err := t.client.GetVersion(ctx, &agentpb.Empty{})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, AgentVersionFromProto(out))
+	}
+
+	return nil, fmt.Errorf("operation %s is not supported by the gRPC transport", id)
+}
+
+// clientStream is the sending side of a client streaming call
+type clientStream[T any] interface {
+	Send(*T) error
+}
+
+// sendStream sends the header followed by the body in chunks. A failed send means the
+// agent ended the call, the caller receives its error when closing the stream.
+func sendStream[T any](stream clientStream[T], header *T, body any, chunk func([]byte) *T) error {
+	r, ok := body.(io.Reader)
+	if !ok {
+		return fmt.Errorf("request body is not a stream")
+	}
+
+	if err := stream.Send(header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+
+	err := SendChunks(r, func(data []byte) error {
+		return stream.Send(chunk(data))
+	})
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// request captures the parameters the generated client writes for an operation
+type request struct {
+	method     string
+	pattern    string
+	header     http.Header
+	query      url.Values
+	pathParams map[string]string
+	body       any
+	timeout    time.Duration
+}
+
+func (r *request) SetHeaderParam(name string, values ...string) error {
+	r.header[http.CanonicalHeaderKey(name)] = values
+	return nil
+}
+
+func (r *request) GetHeaderParams() http.Header {
+	return r.header
+}
+
+func (r *request) SetQueryParam(name string, values ...string) error {
+	r.query[name] = values
+	return nil
+}
+
+func (r *request) SetFormParam(name string, _ ...string) error {
+	return fmt.Errorf("form parameter %s is not supported by the gRPC transport", name)
+}
+
+func (r *request) SetPathParam(name string, value string) error {
+	r.pathParams[name] = value
+	return nil
+}
+
+func (r *request) GetQueryParams() url.Values {
+	return r.query
+}
+
+func (r *request) SetFileParam(name string, _ ...runtime.NamedReadCloser) error {
+	return fmt.Errorf("file parameter %s is not supported by the gRPC transport", name)
+}
+
+func (r *request) SetBodyParam(body any) error {
+	r.body = body
+	return nil
+}
+
+func (r *request) SetTimeout(timeout time.Duration) error {
+	r.timeout = timeout
+	return nil
+}
+
+func (r *request) GetMethod() string {
+	return r.method
+}
+
+func (r *request) GetPath() string {
+	path := r.pattern
+	for name, value := range r.pathParams {
+		path = strings.ReplaceAll(path, "{"+name+"}", url.PathEscape(value))
+	}
+	return path
+}
+
+func (r *request) GetBody() []byte {
+	return nil
+}
+
+func (r *request) GetBodyParam() any {
+	return r.body
+}
+
+func (r *request) GetFileParam() map[string][]runtime.NamedReadCloser {
+	return nil
+}
+
+// response is the outcome of a call presented as HTTP response to the operation
+// readers
+type response struct {
+	code   int
+	header http.Header
+	body   io.ReadCloser
+	// binary bodies are streamed as is, all others are JSON
+	binary bool
+}
+
+func (r *response) Code() int {
+	return r.code
+}
+
+func (r *response) Message() string {
+	return http.StatusText(r.code)
+}
+
+func (r *response) GetHeader(name string) string {
+	return r.header.Get(name)
+}
+
+func (r *response) GetHeaders(name string) []string {
+	return r.header.Values(name)
+}
+
+func (r *response) Body() io.ReadCloser {
+	return r.body
+}
+
+// jsonResponse returns the payload encoded as JSON, followed by pairs of header names
+// and values set if not empty
+func jsonResponse(code int, payload any, headers ...string) (*response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode response: %w", err)
+	}
+
+	header := http.Header{}
+	for i := 0; i+1 < len(headers); i += 2 {
+		if headers[i+1] != "" {
+			header.Set(headers[i], headers[i+1])
+		}
+	}
+	return &response{code: code, header: header, body: io.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func emptyResponse(err error) (*response, error) {
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &response{code: http.StatusNoContent, header: http.Header{}, body: http.NoBody}, nil
+}
+
+func putResponse(out *agentpb.PutResponse, err error) (*response, error) {
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	code := http.StatusNoContent
+	if out.Created {
+		code = http.StatusCreated
+	}
+	header := http.Header{}
+	if out.Etag != "" {
+		header.Set("ETag", out.Etag)
+	}
+	return &response{code: code, header: header, body: http.NoBody}, nil
+}
+
+// errorResponse returns the error payload the agent attached to the status of a failed
+// call as error response. Failures without a payload, e.g. connection errors, are
+// returned as is.
+func errorResponse(err error) (*response, error) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil, err
+	}
+	for _, detail := range st.Details() {
+		if payload, ok := detail.(*agentpb.Error); ok {
+			return jsonResponse(int(payload.Code), ErrorFromProto(payload))
+		}
+	}
+	return nil, err
+}
+
+// chunkResponse streams the received chunks as binary body. The first chunk is awaited
+// for its metadata and so that a failed call results in an error response.
+func chunkResponse(stream interface {
+	Recv() (*agentpb.ContentChunk, error)
+}) (*response, error) {
+	first, err := stream.Recv()
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	header := http.Header{}
+	if first.Etag != "" {
+		header.Set("ETag", first.Etag)
+	}
+	if first.Sha256 != "" {
+		header.Set("Content-SHA256", first.Sha256)
+	}
+	if first.ArchiveType != "" {
+		// Agents predating zstd support only send gzip compressed archives
+		format := first.ArchiveFormat
+		if format == "" {
+			format = "tar.gz"
+		}
+		header.Set("X-Archive-Format", format)
+		header.Set("X-Archive-Type", first.ArchiveType)
+	}
+
+	body := NewStreamReader(first.Data, func() ([]byte, error) {
+		chunk, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return chunk.Data, nil
+	})
+	return &response{code: http.StatusOK, header: header, body: io.NopCloser(body), binary: true}, nil
+}
+
+// eventResponse streams the received command events as newline delimited JSON, the
+// body of the REST endpoint
+func eventResponse(stream interface {
+	Recv() (*agentpb.CommandEvent, error)
+}) (*response, error) {
+	first, err := stream.Recv()
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	encode := func(ev *agentpb.CommandEvent) ([]byte, error) {
+		data, err := json.Marshal(CommandEventFromProto(ev))
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+
+	data, err := encode(first)
+	if err != nil {
+		return nil, err
+	}
+	body := NewStreamReader(data, func() ([]byte, error) {
+		ev, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return encode(ev)
+	})
+	return &response{code: http.StatusOK, header: http.Header{}, body: io.NopCloser(body), binary: true}, nil
+}
@@ -7,72 +7,287 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-openapi/analysis"
 	"github.com/go-openapi/loads"
+	"github.com/go-openapi/runtime"
 	"github.com/rs/zerolog/hlog"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
+	"peertech.de/axion/api/grpc/agentpb"
 	"peertech.de/axion/api/models"
 	"peertech.de/axion/api/restapi"
 	"peertech.de/axion/api/restapi/operations"
+	ops_audit "peertech.de/axion/api/restapi/operations/audit"
+	ops_backup "peertech.de/axion/api/restapi/operations/backup"
 	ops_command "peertech.de/axion/api/restapi/operations/command"
 	ops_content "peertech.de/axion/api/restapi/operations/content"
 	ops_directories "peertech.de/axion/api/restapi/operations/directories"
+	ops_events "peertech.de/axion/api/restapi/operations/events"
 	ops_files "peertech.de/axion/api/restapi/operations/files"
+	ops_health "peertech.de/axion/api/restapi/operations/health"
+	ops_packages "peertech.de/axion/api/restapi/operations/packages"
+	ops_services "peertech.de/axion/api/restapi/operations/services"
+	ops_symlinks "peertech.de/axion/api/restapi/operations/symlinks"
+	"peertech.de/axion/pkg/output"
 )
 
+// apiBasePath is the path the REST API is served below
+const apiBasePath = "/api/v1"
+
 func New(opts ...Option) *API {
 	var options Options
 	for _, opt := range opts {
 		opt(&options)
 	}
 
-	return &API{options: options}
+	if options.MaxUploadSize == 0 {
+		options.MaxUploadSize = defaultMaxUploadSize
+	}
+	if options.MaxChunkedUploadSize == 0 {
+		options.MaxChunkedUploadSize = defaultMaxChunkedUploadSize
+	}
+	if options.MaxUploadSessions == 0 {
+		options.MaxUploadSessions = defaultMaxUploadSessions
+	}
+	if options.MaxStagedUploadSize == 0 {
+		options.MaxStagedUploadSize = defaultMaxStagedUploadSize
+	}
+	if options.MaxCommandOutput == 0 {
+		options.MaxCommandOutput = output.DefaultLimit
+	}
+
+	return &API{
+		options: options,
+		jobs:    newJobStore(),
+		uploads: newUploadStore(options.UploadTempDir, options.MaxUploadSessions, options.MaxStagedUploadSize),
+		events:  newEventBus(),
+		paths:   newPathLocks(),
+		drain:   newDrainer(),
+		started: time.Now(),
+	}
 }
 
 type API struct {
 	options    Options
 	httpServer *http.Server
+	// grpcServer serves the gRPC API, nil if it is disabled
+	grpcServer *grpc.Server
+
+	// jobs holds the asynchronously executed commands
+	jobs *jobStore
+	// uploads holds the sessions of chunked uploads
+	uploads *uploadStore
+	// events distributes the operations performed to the event streams
+	events *eventBus
+	// audit records the mutating requests, nil if auditing is disabled
+	audit *auditLog
+	// backups holds the backups taken on the agent, nil if backups are disabled
+	backups *backupStore
+	// rateLimiter limits the requests per client, nil if unlimited
+	rateLimiter *rateLimiter
+	// mutations caps the concurrent mutations, nil if unlimited
+	mutations mutationLimiter
+	// paths serializes the mutations of the same path
+	paths *pathLocks
+	// drain tracks the in-flight mutations to finish them on shutdown
+	drain *drainer
+	// packagesMu serializes the package manager operations
+	packagesMu sync.Mutex
+
+	// allowedPaths are the allowed path prefixes with symlinks resolved
+	allowedPaths []string
+
+	// activated are the sockets passed by systemd socket activation by name, nil if
+	// the agent wasn't socket activated
+	activated map[string]net.Listener
+
+	// stopCertReload stops watching the TLS certificate for changes
+	stopCertReload context.CancelFunc
+
+	// started is when the API was created, stopping is set once it is being stopped
+	started  time.Time
+	stopping atomic.Bool
 }
 
 func (a *API) Initialize() error {
-	if a.options.ListenAddr == "" {
+	var err error
+	if a.activated, err = activationListeners(); err != nil {
+		return err
+	}
+
+	if a.options.ListenAddr == "" && a.activated["http"] == nil {
 		return fmt.Errorf("missing listen addr")
 	}
+	if a.options.RequireClientCert && a.options.ClientCAs == nil {
+		return fmt.Errorf("requiring client certificates needs client CAs")
+	}
+	if a.options.ClientCAs != nil && a.options.ServerTLSConfig == nil {
+		return fmt.Errorf("client certificates need a server TLS config")
+	}
+	grpcEnabled := a.options.GRPCListenAddr != "" || a.activated[grpcSocketName] != nil
+	if len(a.options.SigningSecret) > 0 && grpcEnabled && !a.options.RequireClientCert {
+		return fmt.Errorf("gRPC calls can't be signed, signed requests need client certificates for the gRPC API")
+	}
+
+	for _, prefix := range a.options.AllowedPaths {
+		if !filepath.IsAbs(prefix) {
+			return fmt.Errorf("allowed path %q is not absolute", prefix)
+		}
+		resolved, err := resolvePath(prefix)
+		if err != nil {
+			return fmt.Errorf("failed to resolve allowed path %q: %w", prefix, err)
+		}
+		a.allowedPaths = append(a.allowedPaths, resolved)
+	}
 
 	swaggerSpec, _, err := getSwaggerSpec()
 	if err != nil {
 		return err
 	}
 
+	if a.options.MaxUploadSize < 0 || a.options.MaxChunkedUploadSize < 0 || a.options.MaxStagedUploadSize < 0 {
+		return fmt.Errorf("upload size limits must not be negative")
+	}
+	if a.options.MaxUploadSessions < 0 {
+		return fmt.Errorf("upload session limit must not be negative")
+	}
+	if a.options.MaxCommandOutput < 0 {
+		return fmt.Errorf("command output limit must not be negative")
+	}
+	if a.options.UploadTempDir != "" {
+		if fi, err := os.Stat(a.options.UploadTempDir); err != nil || !fi.IsDir() {
+			return fmt.Errorf("upload temp dir %q is not a directory", a.options.UploadTempDir)
+		}
+	}
+
+	if a.options.RateLimit < 0 || a.options.RateBurst < 0 || a.options.MaxConcurrentMutations < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if a.options.RateLimit > 0 {
+		a.rateLimiter = newRateLimiter(a.options.RateLimit, a.options.RateBurst)
+	}
+	if a.options.MaxConcurrentMutations > 0 {
+		a.mutations = newMutationLimiter(a.options.MaxConcurrentMutations)
+	}
+
+	if a.options.AuditLog != "" || a.options.AuditSyslog {
+		if a.audit, err = newAuditLog(a.options.AuditLog, a.options.AuditSyslog); err != nil {
+			return err
+		}
+	}
+	if a.options.BackupDir != "" {
+		if a.backups, err = newBackupStore(a.options.BackupDir, a.options.BackupRetention); err != nil {
+			return err
+		}
+	}
+
 	openAPI := operations.NewConfigurationManagementAPI(swaggerSpec)
 	openAPI.ServeError = serveError
+	openAPI.JSONProducer = requestIDProducer(runtime.JSONProducer())
+	openAPI.RegisterProducer(eventStreamMime, runtime.ByteStreamProducer())
 
 	// Content
 	openAPI.ContentDownloadHandler = ops_content.DownloadHandlerFunc(a.handleDownload)
 	openAPI.ContentUploadHandler = ops_content.UploadHandlerFunc(a.handleUpload)
+	openAPI.ContentInitUploadHandler = ops_content.InitUploadHandlerFunc(a.handleInitUpload)
+	openAPI.ContentGetUploadSessionHandler = ops_content.GetUploadSessionHandlerFunc(a.handleGetUploadSession)
+	openAPI.ContentAppendUploadHandler = ops_content.AppendUploadHandlerFunc(a.handleAppendUpload)
+	openAPI.ContentCommitUploadHandler = ops_content.CommitUploadHandlerFunc(a.handleCommitUpload)
+	openAPI.ContentAbortUploadHandler = ops_content.AbortUploadHandlerFunc(a.handleAbortUpload)
 
 	// Files
 	openAPI.CommandExecuteCommandHandler = ops_command.ExecuteCommandHandlerFunc(a.handleCommand)
+	openAPI.CommandExecuteCommandStreamHandler = ops_command.ExecuteCommandStreamHandlerFunc(a.handleCommandStream)
+	openAPI.CommandExecuteScriptHandler = ops_command.ExecuteScriptHandlerFunc(a.handleScript)
+	openAPI.CommandExecuteScriptStreamHandler = ops_command.ExecuteScriptStreamHandlerFunc(a.handleScriptStream)
+	openAPI.CommandExecuteCommandAsyncHandler = ops_command.ExecuteCommandAsyncHandlerFunc(a.handleCommandAsync)
+	openAPI.CommandGetCommandJobHandler = ops_command.GetCommandJobHandlerFunc(a.handleGetCommandJob)
+	openAPI.CommandCancelCommandJobHandler = ops_command.CancelCommandJobHandlerFunc(a.handleCancelCommandJob)
 
 	// Files
 	openAPI.FilesGetFilePropertiesHandler = ops_files.GetFilePropertiesHandlerFunc(a.handleGetFileProperties)
+	openAPI.FilesBatchStatFilesHandler = ops_files.BatchStatFilesHandlerFunc(a.handleBatchStatFiles)
 	openAPI.FilesPutFileHandler = ops_files.PutFileHandlerFunc(a.handlePutFile)
 	openAPI.FilesDeleteFileHandler = ops_files.DeleteFileHandlerFunc(a.handleDeleteFile)
+	openAPI.FilesGetFileContentHandler = ops_files.GetFileContentHandlerFunc(a.handleGetFileContent)
+	openAPI.FilesPutFileContentHandler = ops_files.PutFileContentHandlerFunc(a.handlePutFileContent)
 
 	// Directories
 	openAPI.DirectoriesGetDirectoryPropertiesHandler = ops_directories.GetDirectoryPropertiesHandlerFunc(a.handleGetDirectoryProperties)
 	openAPI.DirectoriesPutDirectoryHandler = ops_directories.PutDirectoryHandlerFunc(a.handlePutDirectory)
 	openAPI.DirectoriesDeleteDirectoryHandler = ops_directories.DeleteDirectoryHandlerFunc(a.handleDeleteDirectory)
 
+	// Symlinks
+	openAPI.SymlinksGetSymlinkPropertiesHandler = ops_symlinks.GetSymlinkPropertiesHandlerFunc(a.handleGetSymlinkProperties)
+	openAPI.SymlinksPutSymlinkHandler = ops_symlinks.PutSymlinkHandlerFunc(a.handlePutSymlink)
+	openAPI.SymlinksDeleteSymlinkHandler = ops_symlinks.DeleteSymlinkHandlerFunc(a.handleDeleteSymlink)
+
+	// Packages
+	openAPI.PackagesGetPackageHandler = ops_packages.GetPackageHandlerFunc(a.handleGetPackage)
+	openAPI.PackagesPutPackageHandler = ops_packages.PutPackageHandlerFunc(a.handlePutPackage)
+	openAPI.PackagesDeletePackageHandler = ops_packages.DeletePackageHandlerFunc(a.handleDeletePackage)
+
+	// Services
+	openAPI.ServicesGetServiceHandler = ops_services.GetServiceHandlerFunc(a.handleGetService)
+	openAPI.ServicesServiceActionHandler = ops_services.ServiceActionHandlerFunc(a.handleServiceAction)
+
+	// Audit
+	openAPI.AuditGetAuditLogHandler = ops_audit.GetAuditLogHandlerFunc(a.handleGetAuditLog)
+
+	// Backup
+	openAPI.BackupCreateBackupHandler = ops_backup.CreateBackupHandlerFunc(a.handleCreateBackup)
+	openAPI.BackupGetBackupHandler = ops_backup.GetBackupHandlerFunc(a.handleGetBackup)
+	openAPI.BackupDeleteBackupHandler = ops_backup.DeleteBackupHandlerFunc(a.handleDeleteBackup)
+	openAPI.BackupRestoreBackupHandler = ops_backup.RestoreBackupHandlerFunc(a.handleRestoreBackup)
+
+	// Events
+	openAPI.EventsStreamEventsHandler = ops_events.StreamEventsHandlerFunc(a.handleStreamEvents)
+
+	// Health
+	openAPI.HealthGetHealthHandler = ops_health.GetHealthHandlerFunc(a.handleGetHealth)
+	openAPI.HealthGetReadinessHandler = ops_health.GetReadinessHandlerFunc(a.handleGetReadiness)
+	openAPI.HealthGetVersionHandler = ops_health.GetVersionHandlerFunc(a.handleGetVersion)
+
+	if a.options.CertReloader != nil && a.options.CertReloadInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		a.stopCertReload = cancel
+		go a.options.CertReloader.Watch(ctx, a.options.CertReloadInterval)
+	}
+
 	// Initialize the mux
 	mux := http.NewServeMux()
-	mux.Handle("/health", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("OK"))
-	}))
-	mux.Handle("/api/v1/", requestLogger(openAPI.Serve(nil)))
+	mux.Handle("/healthz", a.healthHandler(false))
+	mux.Handle("/readyz", a.healthHandler(true))
+	handler := a.events.originHandler(a.limitHandler(openAPI.Serve(a.rbacHandler)))
+	handler = a.signatureHandler(handler)
+	if a.audit != nil {
+		handler = a.audit.auditHandler(handler)
+	}
+	handler = requestIDHandler(requestLogger(handler))
+	// Continues the trace of REST clients, gRPC calls nest below their gRPC span
+	handler = otelhttp.NewHandler(handler, "axiond", otelhttp.WithSpanNameFormatter(
+		func(_ string, r *http.Request) string { return r.Method + " " + r.URL.Path },
+	))
+	mux.Handle(apiBasePath+"/", handler)
+
+	if grpcEnabled {
+		grpcOpts := []grpc.ServerOption{grpc.StatsHandler(otelgrpc.NewServerHandler())}
+		if a.options.ServerTLSConfig != nil {
+			grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(a.tlsConfig())))
+		}
+		a.grpcServer = grpc.NewServer(grpcOpts...)
+		agentpb.RegisterAgentServer(a.grpcServer, &grpcAgent{handler: handler})
+	}
 
 	a.httpServer = &http.Server{
 		Handler:      mux,
@@ -92,31 +307,58 @@ func serveError(rw http.ResponseWriter, r *http.Request, err error) {
 
 	rw.WriteHeader(http.StatusInternalServerError)
 	if r == nil || r.Method != http.MethodHead {
-		_, _ = rw.Write(errorAsJSON(err.Error()))
+		_, _ = rw.Write(errorAsJSON(err.Error(), rw.Header().Get(requestIDHeader)))
 	}
 
 }
 
-func errorAsJSON(err string) []byte {
+func errorAsJSON(err, requestID string) []byte {
 	b, _ := json.Marshal(struct {
-		Code    int64  `json:"code"`
-		Message string `json:"message"`
-	}{http.StatusInternalServerError, err})
+		Code      int64  `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"requestId,omitempty"`
+	}{http.StatusInternalServerError, err, requestID})
 	return b
 }
 
 func (a *API) listener() (net.Listener, error) {
-	var ln net.Listener
-	var err error
+	ln, err := a.bind("http", a.options.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
 
-	if a.options.ServerTLSConfig == nil {
-		ln, err = net.Listen("tcp", a.options.ListenAddr)
-	} else {
+	if a.options.ServerTLSConfig != nil {
 		log.Info().Msg("Utilizing TLS...")
-		ln, err = tls.Listen("tcp", a.options.ListenAddr, a.options.ServerTLSConfig)
+		ln = tls.NewListener(ln, a.tlsConfig())
+	}
+	return ln, nil
+}
+
+// bind returns the socket with the given name passed by systemd, or binds addr if
+// there is none
+func (a *API) bind(name, addr string) (net.Listener, error) {
+	if ln, ok := a.activated[name]; ok {
+		log.Info().Str("socket", name).Msg("Using socket passed by systemd...")
+		return ln, nil
+	}
+	return listen(addr)
+}
+
+// tlsConfig returns the server TLS config extended by the client certificate settings
+func (a *API) tlsConfig() *tls.Config {
+	cfg := a.options.ServerTLSConfig.Clone()
+	if a.options.ClientCAs == nil {
+		return cfg
 	}
 
-	return ln, err
+	cfg.ClientCAs = a.options.ClientCAs
+	if a.options.RequireClientCert {
+		log.Info().Msg("Requiring client certificates...")
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg
 }
 
 func (a *API) Serve() error {
@@ -126,14 +368,75 @@ func (a *API) Serve() error {
 	}
 	defer ln.Close()
 
+	if err := sdNotify("READY=1"); err != nil {
+		log.Warn().Err(err).Msg("Failed to notify systemd")
+	}
 	return a.httpServer.Serve(ln)
 }
 
+// GRPCEnabled reports whether the gRPC API is served, see ServeGRPC
+func (a *API) GRPCEnabled() bool {
+	return a.grpcServer != nil
+}
+
+// ServeGRPC serves the gRPC API until the API is stopped
+func (a *API) ServeGRPC() error {
+	if a.grpcServer == nil {
+		return fmt.Errorf("gRPC is not enabled")
+	}
+
+	ln, err := a.bind(grpcSocketName, a.options.GRPCListenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to bind gRPC listener: %w", err)
+	}
+	defer ln.Close()
+
+	return a.grpcServer.Serve(ln)
+}
+
 func (a *API) Stop() error {
 	stopctx, cancel := context.WithTimeout(context.Background(), a.options.GracefulTimeout)
 	defer cancel()
 
-	return a.httpServer.Shutdown(stopctx)
+	a.stopping.Store(true)
+	if err := sdNotify("STOPPING=1"); err != nil {
+		log.Warn().Err(err).Msg("Failed to notify systemd")
+	}
+	if a.stopCertReload != nil {
+		a.stopCertReload()
+	}
+
+	// Finish the in-flight mutations and command jobs while still serving reads, new
+	// mutations are rejected meanwhile. Jobs are started by mutations only, so none
+	// is started once these are drained.
+	if err := a.drain.drain(stopctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to finish in-flight mutations")
+	} else if err := a.jobs.drain(stopctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to finish running command jobs")
+	}
+
+	a.jobs.close()
+	a.events.close()
+	err := a.httpServer.Shutdown(stopctx)
+	if a.grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			a.grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-stopctx.Done():
+			a.grpcServer.Stop()
+		}
+	}
+	// Discard after the shutdown, so no commit is in-flight
+	a.uploads.close()
+	if a.audit != nil {
+		// Close after the shutdown, so in-flight requests are still recorded
+		a.audit.close()
+	}
+	return err
 }
 
 func getSwaggerSpec() (*loads.Document, *analysis.Spec, error) {
@@ -150,7 +453,7 @@ func getSwaggerSpec() (*loads.Document, *analysis.Spec, error) {
 func requestLogger(next http.Handler) http.Handler {
 	accessHandler := hlog.AccessHandler(
 		func(r *http.Request, status, size int, duration time.Duration) {
-			log.Info().
+			log.Ctx(r.Context()).Info().
 				Str("method", r.Method).
 				Str("url", r.URL.Path).
 				Str("proto", r.Proto).
@@ -197,9 +500,10 @@ func newAPIError(code int, opts ...ErrorOption) *models.Error {
 }
 
 type OpError struct {
-	Code  int
-	Msg   string
-	Cause error
+	Code    int
+	Msg     string
+	Details string
+	Cause   error
 }
 
 func (e *OpError) Error() string {
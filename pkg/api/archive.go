@@ -0,0 +1,140 @@
+package api
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The compressions of transferred TAR archives, as given by X-Archive-Format
+const (
+	archiveFormatGzip = "tar.gz"
+	archiveFormatZstd = "tar.zst"
+)
+
+// negotiateArchiveFormat picks the format of a download, zstd if the Accept-Encoding
+// header of the client lists it and gzip otherwise
+func negotiateArchiveFormat(acceptEncoding *string) string {
+	if acceptEncoding == nil {
+		return archiveFormatGzip
+	}
+	for _, coding := range strings.Split(*acceptEncoding, ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if strings.TrimSpace(name) != "zstd" {
+			continue
+		}
+		// q=0 explicitly refuses the coding
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok && strings.Trim(q, "0.") == "" {
+			continue
+		}
+		return archiveFormatZstd
+	}
+	return archiveFormatGzip
+}
+
+// archiveFormatOrDefault returns format, gzip if none was given
+func archiveFormatOrDefault(format *string) string {
+	if format == nil || *format == "" {
+		return archiveFormatGzip
+	}
+	return *format
+}
+
+// newDecompressor returns a reader of the TAR stream compressed in src with format
+func newDecompressor(src io.Reader, format string) (io.ReadCloser, error) {
+	switch format {
+	case archiveFormatGzip:
+		gzr, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		return gzr, nil
+	case archiveFormatZstd:
+		zr, err := zstd.NewReader(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// newCompressor returns a writer compressing the TAR stream written to it into dst
+// with format
+func newCompressor(dst io.Writer, format string) (io.WriteCloser, error) {
+	switch format {
+	case archiveFormatGzip:
+		return gzip.NewWriter(dst), nil
+	case archiveFormatZstd:
+		return zstd.NewWriter(dst)
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// archiveFilter selects the entries of a directory archive by glob patterns. Patterns
+// are matched against the slash separated path relative to the directory, those
+// without a slash against the name of the entry at any depth.
+type archiveFilter struct {
+	include []string
+	exclude []string
+}
+
+// newArchiveFilter returns the filter of the given patterns, nil if there are none
+func newArchiveFilter(include, exclude []string) (*archiveFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	for _, pattern := range append(append([]string(nil), include...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return &archiveFilter{include: include, exclude: exclude}, nil
+}
+
+// excluded reports whether the entry rel is left out, directories with their content
+func (f *archiveFilter) excluded(rel string) bool {
+	return f != nil && matchGlob(f.exclude, rel)
+}
+
+// included reports whether the file rel is archived, with include patterns only files
+// matching one of them or within a matching directory are
+func (f *archiveFilter) included(rel string) bool {
+	if f == nil || len(f.include) == 0 {
+		return true
+	}
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		if matchGlob(f.include, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes the filter, for use in ETags
+func (f *archiveFilter) String() string {
+	if f == nil {
+		return ""
+	}
+	return fmt.Sprintf("include=%q,exclude=%q", f.include, f.exclude)
+}
+
+func matchGlob(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		// The patterns are validated by newArchiveFilter
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/go-openapi/strfmt"
+	"github.com/rs/zerolog/log"
+
+	"peertech.de/axion/api/models"
+	ops_audit "peertech.de/axion/api/restapi/operations/audit"
+)
+
+// auditRecentSize is the number of entries kept in memory for the audit endpoint
+const auditRecentSize = 1000
+
+// auditLog records the mutating requests to an append-only file and optionally to
+// syslog. The most recent entries are kept in memory to be queried via the API.
+type auditLog struct {
+	mu     sync.Mutex
+	file   *os.File
+	syslog *syslog.Writer
+	recent []*models.AuditEntry
+}
+
+func newAuditLog(path string, useSyslog bool) (*auditLog, error) {
+	a := &auditLog{}
+
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		a.file = f
+	}
+
+	if useSyslog {
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "axiond")
+		if err != nil {
+			a.close()
+			return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		a.syslog = w
+	}
+
+	return a, nil
+}
+
+func (a *auditLog) record(entry *models.AuditEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode audit entry")
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file != nil {
+		// Sync every entry, the log is worthless if it loses the last operations
+		if _, err := a.file.Write(append(line, '\n')); err != nil {
+			log.Error().Err(err).Msg("Failed to write audit entry")
+		} else if err := a.file.Sync(); err != nil {
+			log.Error().Err(err).Msg("Failed to sync audit log")
+		}
+	}
+	if a.syslog != nil {
+		if err := a.syslog.Info(string(line)); err != nil {
+			log.Error().Err(err).Msg("Failed to send audit entry to syslog")
+		}
+	}
+
+	if len(a.recent) == auditRecentSize {
+		copy(a.recent, a.recent[1:])
+		a.recent = a.recent[:auditRecentSize-1]
+	}
+	a.recent = append(a.recent, entry)
+}
+
+// last returns up to n of the most recent entries, oldest first
+func (a *auditLog) last(n int) []*models.AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if n > len(a.recent) {
+		n = len(a.recent)
+	}
+	out := make([]*models.AuditEntry, n)
+	copy(out, a.recent[len(a.recent)-n:])
+	return out
+}
+
+func (a *auditLog) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file != nil {
+		a.file.Close()
+	}
+	if a.syslog != nil {
+		a.syslog.Close()
+	}
+}
+
+// auditHandler records every mutating request passing through next
+func (a *auditLog) auditHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !isMutation(r) {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		details := &auditDetails{values: make(map[string]string)}
+		r = r.WithContext(context.WithValue(r.Context(), auditDetailsKey{}, details))
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		a.record(&models.AuditEntry{
+			Time:       strfmt.DateTime(start.UTC()),
+			RequestID:  requestIDOf(r.Context()),
+			Client:     clientID(r),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.RawQuery,
+			Status:     int64(rec.status),
+			DurationMs: time.Since(start).Milliseconds(),
+			Details:    details.snapshot(),
+		})
+	})
+}
+
+func (api *API) handleGetAuditLog(params ops_audit.GetAuditLogParams) middleware.Responder {
+	if api.audit == nil {
+		return ops_audit.NewGetAuditLogNotImplemented().
+			WithPayload(newAPIError(http.StatusNotImplemented, WithMessage("Audit logging is not enabled")))
+	}
+
+	limit := 100
+	if params.Limit != nil {
+		limit = int(*params.Limit)
+	}
+	return ops_audit.NewGetAuditLogOK().WithPayload(api.audit.last(limit))
+}
+
+type auditDetailsKey struct{}
+
+// auditDetails are the operation specific details handlers add to the audit entry
+// of their request
+type auditDetails struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (d *auditDetails) snapshot() map[string]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.values) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(d.values))
+	for k, v := range d.values {
+		out[k] = v
+	}
+	return out
+}
+
+// auditDetail adds a detail to the audit entry of the request, a no-op if auditing is
+// disabled
+func auditDetail(ctx context.Context, key, value string) {
+	d, ok := ctx.Value(auditDetailsKey{}).(*auditDetails)
+	if !ok {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.values[key] = value
+}
+
+// statusRecorder captures the status written to the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer, e.g. to flush
+// streamed command output
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
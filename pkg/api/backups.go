@@ -0,0 +1,333 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/go-openapi/strfmt"
+	"github.com/rs/zerolog/log"
+
+	"peertech.de/axion/api/models"
+	ops_backup "peertech.de/axion/api/restapi/operations/backup"
+)
+
+// defaultBackupRetention is how long backups are kept on the agent
+const defaultBackupRetention = 24 * time.Hour
+
+// backupIDPattern matches the identifiers of backups, as generated by newJobId
+var backupIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+var errBackupNotFound = newOpError(http.StatusNotFound, "Backup not found", nil)
+
+// backupStore keeps the backups of the agent in a directory, each as a zstd compressed
+// archive next to its metadata. Backups survive restarts of the agent.
+type backupStore struct {
+	dir       string
+	retention time.Duration
+
+	// mu serializes pruning with the creation of backups
+	mu sync.Mutex
+}
+
+func newBackupStore(dir string, retention time.Duration) (*backupStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create backup dir: %w", err)
+	}
+	if retention <= 0 {
+		retention = defaultBackupRetention
+	}
+	return &backupStore{dir: dir, retention: retention}, nil
+}
+
+func (s *backupStore) archivePath(id string) string {
+	return filepath.Join(s.dir, id+"."+archiveFormatZstd)
+}
+
+func (s *backupStore) metadataPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// create archives the file or directory at path with write, which writes the archive
+// to the given file
+func (s *backupStore) create(path string, isDirectory bool, write func(*os.File) error) (*models.Backup, error) {
+	id, err := newJobId()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+
+	// Write to a temporary file, so a failed backup never appears
+	tmp, err := os.CreateTemp(s.dir, "."+id+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = write(tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	backup := &models.Backup{
+		ID:      id,
+		Path:    path,
+		Type:    "file",
+		Size:    fi.Size(),
+		Created: strfmt.DateTime(time.Now().UTC()),
+	}
+	if isDirectory {
+		backup.Type = "directory"
+	}
+
+	metadata, err := json.Marshal(backup)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), s.archivePath(id)); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(s.metadataPath(id), metadata, 0600); err != nil {
+		os.Remove(s.archivePath(id))
+		return nil, err
+	}
+	return backup, nil
+}
+
+// get returns the metadata of the backup with the given id
+func (s *backupStore) get(id string) (*models.Backup, error) {
+	if !backupIDPattern.MatchString(id) {
+		return nil, errBackupNotFound
+	}
+
+	data, err := os.ReadFile(s.metadataPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errBackupNotFound
+		}
+		return nil, err
+	}
+
+	var backup models.Backup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("invalid metadata of backup %s: %w", id, err)
+	}
+	return &backup, nil
+}
+
+// remove deletes the backup with the given id
+func (s *backupStore) remove(id string) error {
+	if !backupIDPattern.MatchString(id) {
+		return errBackupNotFound
+	}
+
+	// The metadata goes first, a backup without it is gone
+	if err := os.Remove(s.metadataPath(id)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errBackupNotFound
+		}
+		return err
+	}
+	if err := os.Remove(s.archivePath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// prune removes the backups exceeding the retention, s.mu must be held
+func (s *backupStore) prune() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		log.Warn().Err(err).Str("dir", s.dir).Msg("Failed to list backups")
+		return
+	}
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !backupIDPattern.MatchString(id) {
+			continue
+		}
+		backup, err := s.get(id)
+		if err != nil || time.Since(time.Time(backup.Created)) < s.retention {
+			continue
+		}
+		if err := s.remove(id); err != nil {
+			log.Warn().Err(err).Str("backup", id).Msg("Failed to remove expired backup")
+		}
+	}
+}
+
+func (api *API) handleCreateBackup(params ops_backup.CreateBackupParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleCreateBackup").
+		Str("path", params.Path).
+		Logger()
+
+	if api.backups == nil {
+		return ops_backup.NewCreateBackupNotImplemented().
+			WithPayload(newAPIError(http.StatusNotImplemented, WithMessage("Backups are not enabled")))
+	}
+	if params.Path == "" {
+		return ops_backup.NewCreateBackupBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Missing file path")))
+	}
+	if err := api.checkPath(params.HTTPRequest.Context(), params.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_backup.NewCreateBackupForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
+
+	fi, err := os.Stat(params.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ops_backup.NewCreateBackupNotFound().
+				WithPayload(newAPIError(http.StatusNotFound, WithMessage("File or directory not found")))
+		}
+
+		scopedLog.Error().Err(err).Msg("Failed to stat path")
+		return ops_backup.NewCreateBackupInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to access path")))
+	}
+
+	filter, err := newArchiveFilter(params.Include, params.Exclude)
+	if err != nil {
+		return ops_backup.NewCreateBackupBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage(err.Error())))
+	}
+	if filter != nil && !fi.IsDir() {
+		return ops_backup.NewCreateBackupBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Include and exclude patterns only apply to directory backups")))
+	}
+
+	backup, err := api.backups.create(params.Path, fi.IsDir(), func(f *os.File) error {
+		return api.writeArchive(f, params.Path, fi.IsDir(), filter, true, archiveFormatZstd)
+	})
+	if err != nil {
+		scopedLog.Error().Err(err).Msg("Failed to create backup")
+		return ops_backup.NewCreateBackupInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to create backup")))
+	}
+
+	scopedLog.Info().Str("backup", backup.ID).Int64("size", backup.Size).Msg("Created backup")
+	return ops_backup.NewCreateBackupCreated().WithPayload(backup)
+}
+
+func (api *API) handleGetBackup(params ops_backup.GetBackupParams) middleware.Responder {
+	if api.backups == nil {
+		return ops_backup.NewGetBackupNotImplemented().
+			WithPayload(newAPIError(http.StatusNotImplemented, WithMessage("Backups are not enabled")))
+	}
+
+	backup, err := api.backups.get(params.ID)
+	if err != nil {
+		if errors.Is(err, errBackupNotFound) {
+			return ops_backup.NewGetBackupNotFound().
+				WithPayload(newAPIError(http.StatusNotFound, WithMessage("Backup not found")))
+		}
+		log.Error().Err(err).Str("handler", "handleGetBackup").Str("backup", params.ID).Msg("Failed to read backup")
+		return ops_backup.NewGetBackupInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to read backup")))
+	}
+	return ops_backup.NewGetBackupOK().WithPayload(backup)
+}
+
+func (api *API) handleDeleteBackup(params ops_backup.DeleteBackupParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleDeleteBackup").
+		Str("backup", params.ID).
+		Logger()
+
+	if api.backups == nil {
+		return ops_backup.NewDeleteBackupNotImplemented().
+			WithPayload(newAPIError(http.StatusNotImplemented, WithMessage("Backups are not enabled")))
+	}
+
+	if err := api.backups.remove(params.ID); err != nil {
+		if errors.Is(err, errBackupNotFound) {
+			return ops_backup.NewDeleteBackupNotFound().
+				WithPayload(newAPIError(http.StatusNotFound, WithMessage("Backup not found")))
+		}
+		scopedLog.Error().Err(err).Msg("Failed to remove backup")
+		return ops_backup.NewDeleteBackupInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to remove backup")))
+	}
+	return ops_backup.NewDeleteBackupNoContent()
+}
+
+func (api *API) handleRestoreBackup(params ops_backup.RestoreBackupParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleRestoreBackup").
+		Str("backup", params.ID).
+		Logger()
+
+	if api.backups == nil {
+		return ops_backup.NewRestoreBackupNotImplemented().
+			WithPayload(newAPIError(http.StatusNotImplemented, WithMessage("Backups are not enabled")))
+	}
+
+	backup, err := api.backups.get(params.ID)
+	if err != nil {
+		if errors.Is(err, errBackupNotFound) {
+			return ops_backup.NewRestoreBackupNotFound().
+				WithPayload(newAPIError(http.StatusNotFound, WithMessage("Backup not found")))
+		}
+		scopedLog.Error().Err(err).Msg("Failed to read backup")
+		return ops_backup.NewRestoreBackupInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to read backup")))
+	}
+	scopedLog = scopedLog.With().Str("path", backup.Path).Logger()
+
+	// The allowed paths or the policy may have changed since the backup was created
+	if err := api.checkPath(params.HTTPRequest.Context(), backup.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_backup.NewRestoreBackupForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
+
+	content, err := os.Open(api.backups.archivePath(backup.ID))
+	if err != nil {
+		scopedLog.Error().Err(err).Msg("Failed to open backup")
+		return ops_backup.NewRestoreBackupInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to open backup")))
+	}
+
+	existed, err := api.extractUpload(params.HTTPRequest.Context(), backup.Path, backup.Type == "directory", true, archiveFormatZstd, content)
+	if err != nil {
+		var oe *OpError
+		if !errors.As(err, &oe) {
+			oe = newOpError(http.StatusInternalServerError, "Restore failed", err)
+		}
+
+		if oe.Code == http.StatusConflict {
+			return ops_backup.NewRestoreBackupConflict().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		}
+		scopedLog.Error().Err(oe.Cause).Msg(oe.Msg)
+		return ops_backup.NewRestoreBackupInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage(oe.Msg)))
+	}
+
+	api.events.publish(params.HTTPRequest.Context(), &models.AgentEvent{Type: eventBackupRestored, Path: backup.Path})
+	if existed {
+		return ops_backup.NewRestoreBackupNoContent()
+	}
+	return ops_backup.NewRestoreBackupCreated()
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CertReloader serves the server certificate and reloads it once the certificate or
+// key file changes, so certificates can be rotated without restarting the agent
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// certPEM and keyPEM are the contents the current certificate was loaded from
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// NewCertReloader loads the certificate, it fails if the files are missing or invalid
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, for use as tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch checks the files for changes every interval until ctx is done. A change which
+// fails to load, e.g. as only one of the files has been replaced yet, keeps the current
+// certificate and is retried.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		reloaded, err := r.reload()
+		if err != nil {
+			log.Warn().Err(err).Str("cert", r.certFile).Msg("Failed to reload TLS certificate")
+			continue
+		}
+		if reloaded {
+			log.Info().Str("cert", r.certFile).Msg("Reloaded TLS certificate")
+		}
+	}
+}
+
+// reload loads the certificate if the files changed since it was last loaded. The
+// contents are compared as rotations replacing symlinks or preserving the modification
+// time would go unnoticed otherwise.
+func (r *CertReloader) reload() (bool, error) {
+	certPEM, err := os.ReadFile(r.certFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read key: %w", err)
+	}
+
+	r.mu.RLock()
+	unchanged := bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.certPEM, r.keyPEM = &cert, certPEM, keyPEM
+	return true, nil
+}
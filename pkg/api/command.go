@@ -3,26 +3,35 @@ package api
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"os/exec"
+	"slices"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/go-openapi/runtime/middleware"
 	"github.com/google/shlex"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 
 	"peertech.de/axion/api/models"
 	ops_command "peertech.de/axion/api/restapi/operations/command"
+	"peertech.de/axion/pkg/output"
+	"peertech.de/axion/pkg/tracing"
 )
 
 func (api *API) handleCommand(params ops_command.ExecuteCommandParams) middleware.Responder {
-	scopedLog := log.With().
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
 		Str("handler", "handleCommand").
 		Str("command", params.Command.Command).
 		Logger()
 
+	auditDetail(params.HTTPRequest.Context(), "command", params.Command.Command)
+
 	if params.Command.Command == "" {
 		return ops_command.NewExecuteCommandBadRequest().
 			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Command cannot be empty")))
@@ -45,9 +54,13 @@ func (api *API) handleCommand(params ops_command.ExecuteCommandParams) middlewar
 			case http.StatusBadRequest:
 				return ops_command.NewExecuteCommandBadRequest().
 					WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+			case http.StatusForbidden:
+				scopedLog.Warn().Err(oe.Cause).Msg(oe.Msg)
+				return ops_command.NewExecuteCommandForbidden().
+					WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
 			case http.StatusRequestTimeout:
 				return ops_command.NewExecuteCommandRequestTimeout().
-					WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+					WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg), WithDetails(oe.Details)))
 			case http.StatusInternalServerError:
 				scopedLog.Error().Err(oe.Cause).Msg(oe.Msg)
 				return ops_command.NewExecuteCommandInternalServerError().
@@ -98,42 +111,118 @@ func (api *API) executeCommand(ctx context.Context, scopedLog zerolog.Logger, r
 	if len(parts) == 0 {
 		return nil, newOpError(http.StatusBadRequest, "Empty command", nil)
 	}
+	if err := api.options.Policy.AllowsCommand(parts); err != nil {
+		return nil, newOpError(http.StatusForbidden, "Command not allowed", err)
+	}
 
-	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	return api.captureCommand(ctx, scopedLog, r, parts)
+}
+
+// captureCommand runs the command given by parts for the request r and returns its
+// result with the captured output
+func (api *API) captureCommand(ctx context.Context, scopedLog zerolog.Logger, r *models.CommandRequest, parts []string) (*models.CommandResponse, error) {
+	ctx, cancel := commandContext(ctx, r)
+	defer cancel()
+
+	api.events.publish(ctx, &models.AgentEvent{Type: eventCommandStarted, Command: r.Command})
 
 	// Capture output
-	var stdout, stderr strings.Builder
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	stdout, stderr := api.commandOutput(r)
+	exitCode, err := runCommand(ctx, parts, stdout, stderr)
+	api.events.commandFinished(ctx, r.Command, exitCode, err)
+	if err != nil {
+		var oe *OpError
+		if errors.As(err, &oe) && oe.Code == http.StatusRequestTimeout {
+			oe.Details = partialOutput(stdout.String(), stderr.String())
+		}
+		return nil, err
+	}
+
+	result := &models.CommandResponse{
+		ExitCode:   int64(exitCode),
+		Stdout:     stdout.String(),
+		Stderr:     stderr.String(),
+		Truncated:  stdout.Truncated() || stderr.Truncated(),
+		StdoutSize: stdout.Size(),
+		StderrSize: stderr.Size(),
+	}
 
-	err = cmd.Run()
+	scopedLog.Debug().
+		Int64("exit_code", result.ExitCode).
+		Int64("stdout_size", result.StdoutSize).
+		Int64("stderr_size", result.StderrSize).
+		Bool("truncated", result.Truncated).
+		Msg("Command execution completed")
 
-	// Determine exit code
+	return result, nil
+}
+
+// commandOutput returns the buffers keeping the stdout and stderr of the command, up
+// to the limit of the agent or the lower one of the request
+func (api *API) commandOutput(r *models.CommandRequest) (stdout, stderr *output.Buffer) {
+	limit := api.options.MaxCommandOutput
+	if r.MaxOutput > 0 && r.MaxOutput < int64(limit) {
+		limit = int(r.MaxOutput)
+	}
+	retention := output.Retention(r.OutputRetention)
+	return output.NewBuffer(limit, retention), output.NewBuffer(limit, retention)
+}
+
+// runCommand runs the command given by parts, writing its output to stdout and stderr.
+// An exit code is returned for commands that ran to completion, an *OpError otherwise.
+func runCommand(ctx context.Context, parts []string, stdout, stderr io.Writer) (int, error) {
+	ctx, span := tracing.Start(ctx, "exec", attribute.String("axion.command", parts[0]))
+	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	tracing.End(span, err)
+
+	// Determine exit code. A command killed on timeout exits with an error as well, so
+	// check for the timeout first.
 	exitCode := 0
 	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return 0, newOpError(http.StatusRequestTimeout, "Command execution timed out", err)
+		} else if exitError, ok := err.(*exec.ExitError); ok {
 			if status, ok := exitError.Sys().(syscall.WaitStatus); ok {
 				exitCode = status.ExitStatus()
 			}
-		} else if ctx.Err() == context.DeadlineExceeded {
-			return nil, newOpError(http.StatusRequestTimeout, "Command execution timed out", err)
 		} else {
 			// Other execution errors (command not found, permission denied, etc.)
-			return nil, newOpError(http.StatusInternalServerError, "Command execution failed", err)
+			return 0, newOpError(http.StatusInternalServerError, "Command execution failed", err)
 		}
 	}
 
-	result := &models.CommandResponse{
-		ExitCode: int64(exitCode),
-		Stdout:   stdout.String(),
-		Stderr:   stderr.String(),
+	return exitCode, nil
+}
+
+// isExpectedExitCode reports whether code is one of the expected exit codes of r,
+// defaulting to 0
+func isExpectedExitCode(r *models.CommandRequest, code int64) bool {
+	if len(r.ExpectedExitCodes) == 0 {
+		return code == 0
 	}
+	return slices.Contains(r.ExpectedExitCodes, code)
+}
 
-	scopedLog.Debug().
-		Int64("exit_code", result.ExitCode).
-		Int("stdout_len", len(result.Stdout)).
-		Int("stderr_len", len(result.Stderr)).
-		Msg("Command execution completed")
+// commandContext returns a context enforcing the timeout of the command request, if any
+func commandContext(ctx context.Context, r *models.CommandRequest) (context.Context, context.CancelFunc) {
+	if r.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(r.Timeout)*time.Second)
+}
 
-	return result, nil
+// partialOutput formats the output of a command which did not run to completion
+func partialOutput(stdout, stderr string) string {
+	var sb strings.Builder
+	if stdout != "" {
+		fmt.Fprintf(&sb, "Stdout:\n%s\n", stdout)
+	}
+	if stderr != "" {
+		fmt.Fprintf(&sb, "Stderr:\n%s\n", stderr)
+	}
+	return sb.String()
 }
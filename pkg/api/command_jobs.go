@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/google/shlex"
+	"github.com/rs/zerolog/log"
+
+	"peertech.de/axion/api/models"
+	ops_command "peertech.de/axion/api/restapi/operations/command"
+)
+
+// jobRetention is how long finished command jobs are kept for polling
+const jobRetention = time.Hour
+
+// job is a command executing in the background
+type job struct {
+	id      string
+	command string
+	cancel  context.CancelFunc
+	// requestID is the ID of the request starting the job
+	requestID string
+	// owner is the client which started the job, see jobOwner
+	owner string
+
+	// Guarded by jobStore.mu
+	state    string
+	result   *models.CommandResponse
+	err      *models.Error
+	finished time.Time
+}
+
+// jobStore holds the command jobs of the agent. Jobs run detached from the request
+// that started them and are cancelled when the store is closed.
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+	// running tracks the jobs still executing
+	running sync.WaitGroup
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newJobStore() *jobStore {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobStore{
+		jobs:   make(map[string]*job),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// start runs fn in the background as a new job started by the request requestID of the
+// client owner and returns the job
+func (s *jobStore) start(command, requestID, owner string, fn func(ctx context.Context) (*models.CommandResponse, error)) (*models.CommandJob, error) {
+	id, err := newJobId()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	j := &job{
+		id:        id,
+		command:   command,
+		cancel:    cancel,
+		requestID: requestID,
+		owner:     owner,
+		state:     models.CommandJobStateRunning,
+	}
+
+	s.mu.Lock()
+	s.prune()
+	s.jobs[id] = j
+	s.mu.Unlock()
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer cancel()
+		result, err := fn(ctx)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		j.finished = time.Now()
+		switch {
+		case j.state == models.CommandJobStateCancelled:
+			// Keep the cancelled state, the command was killed
+		case err != nil:
+			j.state = models.CommandJobStateFailed
+			var oe *OpError
+			if errors.As(err, &oe) {
+				j.err = newAPIError(oe.Code, WithMessage(oe.Msg), WithDetails(oe.Details))
+			} else {
+				j.err = newAPIError(http.StatusInternalServerError, WithMessage("Command execution failed"))
+			}
+			j.err.RequestID = j.requestID
+		default:
+			j.state = models.CommandJobStateCompleted
+			j.result = result
+		}
+	}()
+
+	return s.view(j), nil
+}
+
+// get returns the job with the given id. Jobs of other clients than owner aren't
+// found, they must not see their commands and output.
+func (s *jobStore) get(id, owner string) (*models.CommandJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok || j.owner != owner {
+		return nil, false
+	}
+	return s.view(j), true
+}
+
+// stop cancels the job with the given id of the client owner if it is still running
+func (s *jobStore) stop(id, owner string) (*models.CommandJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok || j.owner != owner {
+		return nil, false
+	}
+	if j.state == models.CommandJobStateRunning {
+		j.state = models.CommandJobStateCancelled
+		j.cancel()
+	}
+	return s.view(j), true
+}
+
+// drain waits until the running jobs are finished, it fails only if ctx is done first.
+// No jobs must be started meanwhile.
+func (s *jobStore) drain(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close cancels all running jobs
+func (s *jobStore) close() {
+	s.cancel()
+}
+
+// prune removes jobs finished longer than the retention ago, s.mu must be held
+func (s *jobStore) prune() {
+	for id, j := range s.jobs {
+		if !j.finished.IsZero() && time.Since(j.finished) > jobRetention {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// view returns the API representation of a job, s.mu must be held
+func (s *jobStore) view(j *job) *models.CommandJob {
+	return &models.CommandJob{
+		ID:      j.id,
+		Command: j.command,
+		State:   j.state,
+		Result:  j.result,
+		Error:   j.err,
+	}
+}
+
+// jobOwner identifies the client of r as owner of the jobs it starts: by its subject if
+// the client was authorized by role, otherwise by its certificate or address
+func jobOwner(r *http.Request) string {
+	if authz := authorizationOf(r.Context()); authz != nil {
+		return authz.subject
+	}
+	return clientID(r)
+}
+
+func newJobId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (api *API) handleCommandAsync(params ops_command.ExecuteCommandAsyncParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleCommandAsync").
+		Str("command", params.Command.Command).
+		Logger()
+
+	auditDetail(params.HTTPRequest.Context(), "command", params.Command.Command)
+
+	if params.Command.Command == "" {
+		return ops_command.NewExecuteCommandAsyncBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Command cannot be empty")))
+	}
+
+	// Reject invalid and disallowed commands before the job is started
+	parts, err := shlex.Split(params.Command.Command)
+	if err != nil || len(parts) == 0 {
+		return ops_command.NewExecuteCommandAsyncBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Invalid command syntax")))
+	}
+	if err := api.options.Policy.AllowsCommand(parts); err != nil {
+		scopedLog.Warn().Err(err).Msg("Command not allowed")
+		return ops_command.NewExecuteCommandAsyncForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Command not allowed")))
+	}
+
+	req := params.Command
+	origin := eventOriginOf(params.HTTPRequest.Context())
+	requestID := requestIDOf(params.HTTPRequest.Context())
+	j, err := api.jobs.start(req.Command, requestID, jobOwner(params.HTTPRequest), func(ctx context.Context) (*models.CommandResponse, error) {
+		ctx = withEventOrigin(ctx, origin)
+
+		// The job outlives the request, so it takes its own mutation slot
+		if err := api.mutations.acquire(ctx); err != nil {
+			return nil, newOpError(http.StatusServiceUnavailable, "Job cancelled while waiting for a free slot", err)
+		}
+		defer api.mutations.release()
+
+		result, err := api.executeCommand(ctx, scopedLog, req)
+		if err != nil {
+			return nil, err
+		}
+		result.Success = isExpectedExitCode(req, result.ExitCode)
+		return result, nil
+	})
+	if err != nil {
+		scopedLog.Error().Err(err).Msg("Failed to start command job")
+		return ops_command.NewExecuteCommandAsyncInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to start command job")))
+	}
+
+	scopedLog.Debug().Str("job", j.ID).Msg("Command job started")
+	return ops_command.NewExecuteCommandAsyncAccepted().WithPayload(j)
+}
+
+func (api *API) handleGetCommandJob(params ops_command.GetCommandJobParams) middleware.Responder {
+	j, ok := api.jobs.get(params.ID, jobOwner(params.HTTPRequest))
+	if !ok {
+		return ops_command.NewGetCommandJobNotFound().
+			WithPayload(newAPIError(http.StatusNotFound, WithMessage("Command job not found")))
+	}
+	return ops_command.NewGetCommandJobOK().WithPayload(j)
+}
+
+func (api *API) handleCancelCommandJob(params ops_command.CancelCommandJobParams) middleware.Responder {
+	j, ok := api.jobs.stop(params.ID, jobOwner(params.HTTPRequest))
+	if !ok {
+		return ops_command.NewCancelCommandJobNotFound().
+			WithPayload(newAPIError(http.StatusNotFound, WithMessage("Command job not found")))
+	}
+	return ops_command.NewCancelCommandJobOK().WithPayload(j)
+}
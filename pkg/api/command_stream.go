@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/go-openapi/runtime"
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/google/shlex"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"peertech.de/axion/api/models"
+	ops_command "peertech.de/axion/api/restapi/operations/command"
+)
+
+func (api *API) handleCommandStream(params ops_command.ExecuteCommandStreamParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleCommandStream").
+		Str("command", params.Command.Command).
+		Logger()
+
+	auditDetail(params.HTTPRequest.Context(), "command", params.Command.Command)
+
+	if params.Command.Command == "" {
+		return ops_command.NewExecuteCommandStreamBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Command cannot be empty")))
+	}
+
+	// Reject invalid commands before the stream is started
+	parts, err := shlex.Split(params.Command.Command)
+	if err != nil || len(parts) == 0 {
+		return ops_command.NewExecuteCommandStreamBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Invalid command syntax")))
+	}
+	if err := api.options.Policy.AllowsCommand(parts); err != nil {
+		scopedLog.Warn().Err(err).Msg("Command not allowed")
+		return ops_command.NewExecuteCommandStreamForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Command not allowed")))
+	}
+
+	return api.streamCommand(params.HTTPRequest.Context(), scopedLog, params.Command, parts)
+}
+
+// streamCommand returns the responder running the command given by parts for the
+// request r, streaming its output as events
+func (api *API) streamCommand(ctx context.Context, scopedLog zerolog.Logger, r *models.CommandRequest, parts []string) middleware.Responder {
+	return middleware.ResponderFunc(func(rw http.ResponseWriter, _ runtime.Producer) {
+		rw.Header().Set("Content-Type", runtime.DefaultMime)
+		rw.WriteHeader(http.StatusOK)
+
+		events := &eventWriter{rw: rw, rc: http.NewResponseController(rw)}
+		stdout := &lineWriter{stream: models.CommandEventStreamStdout, events: events}
+		stderr := &lineWriter{stream: models.CommandEventStreamStderr, events: events}
+
+		ctx, cancel := commandContext(ctx, r)
+		defer cancel()
+
+		api.events.publish(ctx, &models.AgentEvent{Type: eventCommandStarted, Command: r.Command})
+		exitCode, err := runCommand(ctx, parts, stdout, stderr)
+		api.events.commandFinished(ctx, r.Command, exitCode, err)
+		stdout.Close()
+		stderr.Close()
+
+		if err != nil {
+			var oe *OpError
+			if !errors.As(err, &oe) {
+				oe = newOpError(http.StatusInternalServerError, "Command execution failed", err)
+			}
+			scopedLog.Error().Err(oe.Cause).Msg(oe.Msg)
+			events.write(&models.CommandEvent{Error: newAPIError(oe.Code, WithMessage(oe.Msg))})
+			return
+		}
+
+		result := &models.CommandResponse{
+			ExitCode:   int64(exitCode),
+			Success:    isExpectedExitCode(r, int64(exitCode)),
+			StdoutSize: stdout.size,
+			StderrSize: stderr.size,
+		}
+		scopedLog.Debug().
+			Int64("exit_code", result.ExitCode).
+			Bool("success", result.Success).
+			Msg("Command execution completed")
+
+		events.write(&models.CommandEvent{Result: result})
+	})
+}
+
+// eventWriter writes newline delimited command events, flushing after every event so
+// that the client receives output as soon as it is produced
+type eventWriter struct {
+	mu sync.Mutex
+	rw http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (w *eventWriter) write(ev *models.CommandEvent) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Write errors mean the client went away, the command is cancelled via the request
+	// context in that case
+	if _, err := w.rw.Write(append(data, '\n')); err != nil {
+		return
+	}
+	_ = w.rc.Flush()
+}
+
+// maxEventData limits the output sent in a single event, longer lines are split so
+// that output without newlines isn't buffered without bound
+const maxEventData = 64 << 10
+
+// lineWriter turns the output written to a stream into one event per line
+type lineWriter struct {
+	stream string
+	events *eventWriter
+	buf    []byte
+	// size is the number of bytes written
+	size int64
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.size += int64(len(p))
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.events.write(&models.CommandEvent{Stream: w.stream, Data: string(w.buf[:i])})
+		w.buf = w.buf[i+1:]
+	}
+	for len(w.buf) >= maxEventData {
+		w.events.write(&models.CommandEvent{Stream: w.stream, Data: string(w.buf[:maxEventData])})
+		w.buf = w.buf[maxEventData:]
+	}
+	return len(p), nil
+}
+
+// Close writes the remaining output not terminated by a newline
+func (w *lineWriter) Close() error {
+	if len(w.buf) > 0 {
+		w.events.write(&models.CommandEvent{Stream: w.stream, Data: string(w.buf)})
+		w.buf = nil
+	}
+	return nil
+}
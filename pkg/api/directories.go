@@ -1,6 +1,8 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
@@ -8,16 +10,19 @@ import (
 	"os/user"
 	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/go-openapi/runtime/middleware"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 
 	"peertech.de/axion/api/models"
 	ops_directories "peertech.de/axion/api/restapi/operations/directories"
+	"peertech.de/axion/pkg/tracing"
 )
 
 func (api *API) handleGetDirectoryProperties(params ops_directories.GetDirectoryPropertiesParams) middleware.Responder {
-	scopedLog := log.With().
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
 		Str("handler", "handleGetDirectoryProperties").
 		Str("path", params.Path).
 		Logger()
@@ -25,6 +30,11 @@ func (api *API) handleGetDirectoryProperties(params ops_directories.GetDirectory
 	if params.Path == "" {
 		return middleware.Error(http.StatusBadRequest, "Directory path cannot be empty")
 	}
+	if err := api.checkPath(params.HTTPRequest.Context(), params.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_directories.NewGetDirectoryPropertiesForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
 
 	fi, err := os.Stat(params.Path)
 	if err != nil {
@@ -43,6 +53,11 @@ func (api *API) handleGetDirectoryProperties(params ops_directories.GetDirectory
 			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Path is not a directory")))
 	}
 
+	etag := generateDirectoryETag(fi)
+	if etagNoneMatch(params.IfNoneMatch, etag) {
+		return ops_directories.NewGetDirectoryPropertiesNotModified().WithETag(etag)
+	}
+
 	stat := fi.Sys().(*syscall.Stat_t)
 	owner, err := user.LookupId(fmt.Sprint(stat.Uid))
 	if err != nil {
@@ -64,12 +79,11 @@ func (api *API) handleGetDirectoryProperties(params ops_directories.GetDirectory
 		Group: group.Name,
 	}
 
-	etag := generateFileETag(fi)
 	return ops_directories.NewGetDirectoryPropertiesOK().WithETag(etag).WithPayload(directory)
 }
 
 func (api *API) handlePutDirectory(params ops_directories.PutDirectoryParams) middleware.Responder {
-	scopedLog := log.With().
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
 		Str("handler", "handlePutDirectory").
 		Str("path", params.Path).
 		Logger()
@@ -78,6 +92,11 @@ func (api *API) handlePutDirectory(params ops_directories.PutDirectoryParams) mi
 		return ops_directories.NewPutDirectoryBadRequest().
 			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Directory path cannot be empty")))
 	}
+	if err := api.checkPath(params.HTTPRequest.Context(), params.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_directories.NewPutDirectoryForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
 
 	var (
 		mode     *os.FileMode
@@ -129,6 +148,13 @@ func (api *API) handlePutDirectory(params ops_directories.PutDirectoryParams) mi
 		gid = &id
 	}
 
+	unlock, err := api.paths.lock(params.Path)
+	if err != nil {
+		return ops_directories.NewPutDirectoryConflict().
+			WithPayload(newAPIError(http.StatusConflict, WithMessage("Path is locked by another operation")))
+	}
+	defer unlock()
+
 	fi, err := os.Stat(params.Path)
 	directoryExists := err == nil
 
@@ -139,7 +165,7 @@ func (api *API) handlePutDirectory(params ops_directories.PutDirectoryParams) mi
 				WithPayload(newAPIError(http.StatusPreconditionFailed, WithMessage("Directory does not exist for conditional update")))
 		}
 
-		currentETag := generateFileETag(fi)
+		currentETag := generateDirectoryETag(fi)
 		if ifMatch != currentETag {
 			return ops_directories.NewPutDirectoryConflict().
 				WithPayload(newAPIError(http.StatusConflict, WithMessage("ETag mismatch")))
@@ -149,7 +175,9 @@ func (api *API) handlePutDirectory(params ops_directories.PutDirectoryParams) mi
 			WithPayload(newAPIError(http.StatusPreconditionRequired, WithMessage("Missing If-Match header")))
 	}
 
+	_, span := tracing.Start(params.HTTPRequest.Context(), "fs.putDirectory", attribute.String("axion.path", params.Path))
 	created, err := putDirectory(params.Path, mode, uid, gid)
+	tracing.End(span, err)
 	if err != nil {
 		var oe *OpError
 		if errors.As(err, &oe) {
@@ -161,6 +189,7 @@ func (api *API) handlePutDirectory(params ops_directories.PutDirectoryParams) mi
 		}
 	}
 
+	api.events.publish(params.HTTPRequest.Context(), &models.AgentEvent{Type: eventDirectoryChanged, Path: params.Path})
 	if created {
 		return ops_directories.NewPutDirectoryCreated()
 	}
@@ -169,7 +198,7 @@ func (api *API) handlePutDirectory(params ops_directories.PutDirectoryParams) mi
 }
 
 func (api *API) handleDeleteDirectory(params ops_directories.DeleteDirectoryParams) middleware.Responder {
-	scopedLog := log.With().
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
 		Str("handler", "handleDeleteDirectory").
 		Str("path", params.Path).
 		Logger()
@@ -178,6 +207,19 @@ func (api *API) handleDeleteDirectory(params ops_directories.DeleteDirectoryPara
 		return ops_directories.NewDeleteDirectoryBadRequest().
 			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Directory path cannot be empty")))
 	}
+	if err := api.checkPath(params.HTTPRequest.Context(), params.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_directories.NewDeleteDirectoryForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
+
+	// The whole tree is removed, so nothing below may be mutated meanwhile
+	unlock, err := api.paths.lockTree(params.Path)
+	if err != nil {
+		return ops_directories.NewDeleteDirectoryConflict().
+			WithPayload(newAPIError(http.StatusConflict, WithMessage("Path is locked by another operation")))
+	}
+	defer unlock()
 
 	fi, err := os.Stat(params.Path)
 	if err != nil {
@@ -196,13 +238,15 @@ func (api *API) handleDeleteDirectory(params ops_directories.DeleteDirectoryPara
 			WithPayload(newAPIError(http.StatusPreconditionRequired, WithMessage("Missing If-Match header")))
 	}
 
-	currentETag := generateFileETag(fi)
+	currentETag := generateDirectoryETag(fi)
 	if ifMatch != currentETag {
 		return ops_directories.NewDeleteDirectoryConflict().
 			WithPayload(newAPIError(http.StatusConflict, WithMessage("ETag mismatch")))
 	}
 
+	_, span := tracing.Start(params.HTTPRequest.Context(), "fs.removeAll", attribute.String("axion.path", params.Path))
 	err = os.RemoveAll(params.Path)
+	tracing.End(span, err)
 	switch {
 	case err == nil:
 	case errors.Is(err, os.ErrPermission):
@@ -217,6 +261,7 @@ func (api *API) handleDeleteDirectory(params ops_directories.DeleteDirectoryPara
 			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to delete directory")))
 	}
 
+	api.events.publish(params.HTTPRequest.Context(), &models.AgentEvent{Type: eventDirectoryDeleted, Path: params.Path})
 	return ops_directories.NewDeleteDirectoryNoContent()
 }
 
@@ -275,3 +320,21 @@ func putDirectory(path string, mode *os.FileMode, uid, gid *int) (created bool,
 
 	return created, nil
 }
+
+// generateDirectoryETag derives the ETag of a directory from its mode, ownership and
+// modification time, which changes with its entries
+func generateDirectoryETag(fi os.FileInfo) string {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+
+	data := fmt.Sprintf("%v:%d:%d:%s",
+		fi.Mode().Perm(),
+		stat.Uid,
+		stat.Gid,
+		fi.ModTime().UTC().Format(time.RFC3339Nano),
+	)
+	sum := sha256.Sum256([]byte(data))
+	return `W/"` + hex.EncodeToString(sum[:]) + `"`
+}
@@ -2,13 +2,16 @@ package api
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/go-openapi/runtime/middleware"
 	"github.com/rs/zerolog"
@@ -18,16 +21,24 @@ import (
 )
 
 func (api *API) handleDownload(params ops_content.DownloadParams) middleware.Responder {
-	scopedLog := log.With().
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
 		Str("handler", "handleDownload").
 		Str("path", params.Path).
 		Bool("recursive", params.Recursive != nil && *params.Recursive).
+		Bool("preserve", params.Preserve != nil && *params.Preserve).
+		Strs("include", params.Include).
+		Strs("exclude", params.Exclude).
 		Logger()
 
 	if params.Path == "" {
 		return ops_content.NewDownloadBadRequest().
 			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Missing file path")))
 	}
+	if err := api.checkPath(params.HTTPRequest.Context(), params.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_content.NewDownloadForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
 
 	fi, err := os.Stat(params.Path)
 	if err != nil {
@@ -52,42 +63,91 @@ func (api *API) handleDownload(params ops_content.DownloadParams) middleware.Res
 			WithPayload(newAPIError(http.StatusConflict, WithMessage("Path is a file, use recursive=false for file downloads")))
 	}
 
-	return api.handleTarDownload(scopedLog, params.Path, fi.IsDir())
-}
+	filter, err := newArchiveFilter(params.Include, params.Exclude)
+	if err != nil {
+		return ops_content.NewDownloadBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage(err.Error())))
+	}
+	if filter != nil && !fi.IsDir() {
+		return ops_content.NewDownloadBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Include and exclude patterns only apply to directory downloads")))
+	}
 
-func (api *API) handleTarDownload(scopedLog zerolog.Logger, path string, isDirectory bool) middleware.Responder {
-	pr, pw := io.Pipe()
+	preserve := params.Preserve != nil && *params.Preserve
+	format := negotiateArchiveFormat(params.AcceptEncoding)
 
-	go func() {
-		defer pw.Close()
+	etag, err := downloadETag(params.Path, fi, filter, preserve, format)
+	if err != nil {
+		scopedLog.Error().Err(err).Msg("Failed to calculate ETag")
+		return ops_content.NewDownloadInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to access path")))
+	}
+	if etagNoneMatch(params.IfNoneMatch, etag) {
+		return ops_content.NewDownloadNotModified().WithETag(etag)
+	}
 
-		// Create gzip writer
-		gzw := gzip.NewWriter(pw)
-		defer func() {
-			if err := gzw.Close(); err != nil {
-				scopedLog.Error().Err(err).Msg("Failed to close gzip writer")
-			}
-		}()
+	return api.handleTarDownload(scopedLog, params.Path, fi.IsDir(), filter, preserve, format, etag)
+}
 
-		// Create tar writer
-		tw := tar.NewWriter(gzw)
-		defer func() {
-			if err := tw.Close(); err != nil {
-				scopedLog.Error().Err(err).Msg("Failed to close tar writer")
+// downloadETag derives the weak ETag of the archive of path. Files are identified by
+// their ETag, directories by the metadata of all entries, as hashing their content
+// would read the whole tree twice. Only the entries selected by filter are considered.
+func downloadETag(path string, fi os.FileInfo, filter *archiveFilter, preserve bool, format string) (string, error) {
+	h := sha256.New()
+	// Archives with and without the preserved metadata or in other formats differ
+	if filter != nil {
+		fmt.Fprintf(h, "%s:", filter)
+	}
+	if preserve {
+		fmt.Fprint(h, "preserve:")
+	}
+	if format != archiveFormatGzip {
+		fmt.Fprintf(h, "%s:", format)
+	}
+	if !fi.IsDir() {
+		etag, err := currentFileETag(path, fi)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "file:%s", etag)
+	} else {
+		err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
 			}
-		}()
-
-		var err error
-		if isDirectory {
-			err = api.addDirectoryToTar(tw, path, "")
-		} else {
-			err = api.addFileToTar(tw, path, filepath.Base(path))
+			rel, err := filepath.Rel(path, p)
+			if err != nil {
+				return err
+			}
+			if rel != "." {
+				if skip, err := skipEntry(filter, filepath.ToSlash(rel), info); skip {
+					return err
+				}
+			}
+			var uid, gid uint32
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+				uid, gid = stat.Uid, stat.Gid
+			}
+			fmt.Fprintf(h, "%s:%v:%d:%d:%d:%s\n", rel, info.Mode(), uid, gid, info.Size(),
+				info.ModTime().UTC().Format(time.RFC3339Nano))
+			return nil
+		})
+		if err != nil {
+			return "", err
 		}
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)) + `"`, nil
+}
 
+func (api *API) handleTarDownload(scopedLog zerolog.Logger, path string, isDirectory bool, filter *archiveFilter, preserve bool, format, etag string) middleware.Responder {
+	pr, pw := io.Pipe()
+
+	go func() {
+		err := api.writeArchive(pw, path, isDirectory, filter, preserve, format)
 		if err != nil {
 			scopedLog.Error().Err(err).Msg("Failed to create tar archive")
-			pw.CloseWithError(err)
 		}
+		pw.CloseWithError(err)
 	}()
 
 	archiveType := "file"
@@ -95,16 +155,45 @@ func (api *API) handleTarDownload(scopedLog zerolog.Logger, path string, isDirec
 		archiveType = "directory"
 	}
 
-	filename := filepath.Base(path) + ".tar.gz"
+	filename := filepath.Base(path) + "." + format
 
 	return ops_content.NewDownloadOK().
 		WithPayload(pr).
+		WithETag(etag).
 		WithContentDisposition(fmt.Sprintf("attachment; filename=\"%s\"", filename)).
-		WithXArchiveFormat("tar.gz").
+		WithXArchiveFormat(format).
 		WithXArchiveType(archiveType)
 }
 
-func (api *API) addFileToTar(tarWriter *tar.Writer, filePath, tarPath string) error {
+// writeArchive writes the archive of the file or directory at path to w, compressed
+// with format
+func (api *API) writeArchive(w io.Writer, path string, isDirectory bool, filter *archiveFilter, preserve bool, format string) error {
+	// Create compressing writer
+	cw, err := newCompressor(w, format)
+	if err != nil {
+		return err
+	}
+
+	// Create tar writer
+	tw := tar.NewWriter(cw)
+
+	if isDirectory {
+		err = api.addDirectoryToTar(tw, path, "", filter, preserve)
+	} else {
+		err = api.addFileToTar(tw, path, filepath.Base(path), preserve)
+	}
+
+	// Close both in any case, the compressor may hold resources
+	if cerr := tw.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to close tar writer: %w", cerr)
+	}
+	if cerr := cw.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to close compressor: %w", cerr)
+	}
+	return err
+}
+
+func (api *API) addFileToTar(tarWriter *tar.Writer, filePath, tarPath string, preserve bool) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to open file %s: %w", filePath, err)
@@ -117,11 +206,10 @@ func (api *API) addFileToTar(tarWriter *tar.Writer, filePath, tarPath string) er
 	}
 
 	// Create tar header
-	header, err := tar.FileInfoHeader(info, "")
+	header, err := tarHeader(filePath, tarPath, info, preserve, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create tar header for %s: %w", filePath, err)
+		return err
 	}
-	header.Name = tarPath
 
 	// Write header
 	if err := tarWriter.WriteHeader(header); err != nil {
@@ -136,7 +224,8 @@ func (api *API) addFileToTar(tarWriter *tar.Writer, filePath, tarPath string) er
 	return nil
 }
 
-func (api *API) addDirectoryToTar(tarWriter *tar.Writer, sourcePath, tarBasePath string) error {
+func (api *API) addDirectoryToTar(tarWriter *tar.Writer, sourcePath, tarBasePath string, filter *archiveFilter, preserve bool) error {
+	links := make(map[inode]string)
 	return filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return fmt.Errorf("walk error for %s: %w", path, err)
@@ -155,21 +244,23 @@ func (api *API) addDirectoryToTar(tarWriter *tar.Writer, sourcePath, tarBasePath
 		if tarPath == "." || tarPath == "" {
 			return nil
 		}
+		if skip, err := skipEntry(filter, filepath.ToSlash(relPath), info); skip {
+			return err
+		}
 
 		// Create tar header
-		header, err := tar.FileInfoHeader(info, "")
+		header, err := tarHeader(path, tarPath, info, preserve, links)
 		if err != nil {
-			return fmt.Errorf("failed to create tar header for %s: %w", path, err)
+			return err
 		}
-		header.Name = tarPath
 
 		// Write header
 		if err := tarWriter.WriteHeader(header); err != nil {
 			return fmt.Errorf("failed to write tar header for %s: %w", path, err)
 		}
 
-		// Write file content if it's a regular file
-		if info.Mode().IsRegular() {
+		// Write file content if it's a regular file, hardlinks refer to an earlier entry
+		if header.Typeflag == tar.TypeReg {
 			file, err := os.Open(path)
 			if err != nil {
 				return fmt.Errorf("failed to open file %s: %w", path, err)
@@ -184,3 +275,68 @@ func (api *API) addDirectoryToTar(tarWriter *tar.Writer, sourcePath, tarBasePath
 		return nil
 	})
 }
+
+// skipEntry reports whether the entry rel is left out of a directory archive, with the
+// error for filepath.Walk to skip the content of directories. Sockets can't be archived
+// and are left out as well.
+func skipEntry(filter *archiveFilter, rel string, info os.FileInfo) (bool, error) {
+	if filter.excluded(rel) {
+		if info.IsDir() {
+			return true, filepath.SkipDir
+		}
+		return true, nil
+	}
+	if info.IsDir() {
+		return false, nil
+	}
+	return info.Mode()&os.ModeSocket != 0 || !filter.included(rel), nil
+}
+
+// inode identifies a file across its hardlinks
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// tarHeader creates the header of the entry tarPath for the file at path. With preserve,
+// the extended attributes are included and files linked to an entry recorded in links
+// become hardlinks to it. The owner is always included, it's only applied on extraction
+// with preserve though.
+func tarHeader(path, tarPath string, info os.FileInfo, preserve bool, links map[inode]string) (*tar.Header, error) {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read symlink %s: %w", path, err)
+		}
+		link = target
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tar header for %s: %w", path, err)
+	}
+	header.Name = tarPath
+	if !preserve {
+		return header, nil
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && links != nil && info.Mode().IsRegular() && stat.Nlink > 1 {
+		id := inode{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}
+		if first, ok := links[id]; ok {
+			header.Typeflag = tar.TypeLink
+			header.Linkname = first
+			header.Size = 0
+			return header, nil
+		}
+		links[id] = tarPath
+	}
+
+	// Extended attributes of symlinks would be read from their targets
+	if link == "" {
+		if err := readXattrs(path, header); err != nil {
+			return nil, fmt.Errorf("failed to read extended attributes of %s: %w", path, err)
+		}
+	}
+	return header, nil
+}
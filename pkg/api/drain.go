@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"sync"
+)
+
+// drainRetryAfter is the Retry-After in seconds of mutations rejected while draining,
+// long enough for the agent to be restarted
+const drainRetryAfter = 5
+
+// drainer tracks the in-flight mutations, so they can be finished on shutdown while
+// further ones are rejected
+type drainer struct {
+	mu       sync.Mutex
+	active   int
+	draining bool
+	// idle is closed once draining and no mutation is active anymore
+	idle chan struct{}
+}
+
+func newDrainer() *drainer {
+	return &drainer{idle: make(chan struct{})}
+}
+
+// enter registers a mutation, it fails once draining started
+func (d *drainer) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.draining {
+		return false
+	}
+	d.active++
+	return true
+}
+
+// leave unregisters a mutation registered by enter
+func (d *drainer) leave() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.active--
+	if d.draining && d.active == 0 {
+		close(d.idle)
+	}
+}
+
+// drain rejects further mutations and waits until the active ones are finished, it
+// fails only if ctx is done first
+func (d *drainer) drain(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.draining {
+		d.draining = true
+		if d.active == 0 {
+			close(d.idle)
+		}
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-d.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/go-openapi/strfmt"
+
+	"peertech.de/axion/api/models"
+	ops_events "peertech.de/axion/api/restapi/operations/events"
+)
+
+const (
+	eventFileChanged      = "file.changed"
+	eventFileDeleted      = "file.deleted"
+	eventDirectoryChanged = "directory.changed"
+	eventDirectoryDeleted = "directory.deleted"
+	eventSymlinkChanged   = "symlink.changed"
+	eventSymlinkDeleted   = "symlink.deleted"
+	eventContentUploaded  = "content.uploaded"
+	eventBackupRestored   = "backup.restored"
+	eventPackageChanged   = "package.changed"
+	eventPackageRemoved   = "package.removed"
+	eventServiceChanged   = "service.changed"
+	eventCommandStarted   = "command.started"
+	eventCommandFinished  = "command.finished"
+)
+
+const (
+	// eventBufferSize is the number of events buffered per subscriber, further events
+	// are dropped until the subscriber catches up
+	eventBufferSize = 256
+	// eventKeepAlive is the interval of the comments keeping idle streams alive
+	eventKeepAlive = 15 * time.Second
+
+	eventStreamMime = "text/event-stream"
+)
+
+// eventBus distributes the events of the agent to the subscribed event streams
+type eventBus struct {
+	mu     sync.Mutex
+	seq    int64
+	subs   map[*eventSubscriber]struct{}
+	closed bool
+}
+
+type eventSubscriber struct {
+	ch    chan *models.AgentEvent
+	types []string
+}
+
+// wants reports whether the subscriber asked for events of the type
+func (s *eventSubscriber) wants(typ string) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+	for _, prefix := range s.types {
+		if strings.HasPrefix(typ, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[*eventSubscriber]struct{})}
+}
+
+// publish sends the event to all subscribers wanting it, attributed to the request of
+// ctx. It never blocks, events for subscribers with a full buffer are dropped.
+func (b *eventBus) publish(ctx context.Context, ev *models.AgentEvent) {
+	if origin, ok := ctx.Value(eventOriginKey{}).(eventOrigin); ok {
+		ev.RequestID = origin.requestID
+		ev.Client = origin.client
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	b.seq++
+	ev.ID = b.seq
+	ev.Time = strfmt.DateTime(time.Now().UTC())
+
+	for s := range b.subs {
+		if !s.wants(ev.Type) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+		}
+	}
+}
+
+// subscribe returns a subscriber for the events with the given type prefixes, all if
+// none are given. Its channel is closed once the bus is closed.
+func (b *eventBus) subscribe(types []string) *eventSubscriber {
+	s := &eventSubscriber{ch: make(chan *models.AgentEvent, eventBufferSize), types: types}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(s.ch)
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+func (b *eventBus) unsubscribe(s *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// close ends all event streams, so they don't hold up the shutdown
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subs {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+type eventOriginKey struct{}
+
+// eventOrigin identifies the request causing events
+type eventOrigin struct {
+	requestID string
+	client    string
+}
+
+// originHandler attributes the events published while serving a request to it
+func (b *eventBus) originHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		origin := eventOrigin{requestID: requestIDOf(r.Context()), client: clientID(r)}
+		next.ServeHTTP(rw, r.WithContext(withEventOrigin(r.Context(), origin)))
+	})
+}
+
+func withEventOrigin(ctx context.Context, origin eventOrigin) context.Context {
+	return context.WithValue(ctx, eventOriginKey{}, origin)
+}
+
+// eventOriginOf returns the origin of ctx, to attribute events of work detached from
+// the request to it
+func eventOriginOf(ctx context.Context) eventOrigin {
+	origin, _ := ctx.Value(eventOriginKey{}).(eventOrigin)
+	return origin
+}
+
+// commandFinished publishes the end of a command, err is set if it didn't run to
+// completion
+func (b *eventBus) commandFinished(ctx context.Context, command string, exitCode int, err error) {
+	ev := &models.AgentEvent{Type: eventCommandFinished, Command: command}
+	if err != nil {
+		ev.Error = err.Error()
+	} else {
+		code := int64(exitCode)
+		ev.ExitCode = &code
+	}
+	b.publish(ctx, ev)
+}
+
+func (api *API) handleStreamEvents(params ops_events.StreamEventsParams) middleware.Responder {
+	return middleware.ResponderFunc(func(rw http.ResponseWriter, _ runtime.Producer) {
+		sub := api.events.subscribe(params.Type)
+		defer api.events.unsubscribe(sub)
+
+		rc := http.NewResponseController(rw)
+		// The stream is open for as long as the client wants, not bound by the write
+		// timeout of the server
+		_ = rc.SetWriteDeadline(time.Time{})
+
+		rw.Header().Set("Content-Type", eventStreamMime)
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.WriteHeader(http.StatusOK)
+		_ = rc.Flush()
+
+		keepAlive := time.NewTicker(eventKeepAlive)
+		defer keepAlive.Stop()
+
+		ctx := params.HTTPRequest.Context()
+		for {
+			var err error
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-sub.ch:
+				if !ok {
+					return
+				}
+				data, merr := json.Marshal(ev)
+				if merr != nil {
+					continue
+				}
+				_, err = fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+			case <-keepAlive.C:
+				_, err = io.WriteString(rw, ": keep-alive\n\n")
+			}
+			if err != nil {
+				return
+			}
+			_ = rc.Flush()
+		}
+	})
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+
+	"peertech.de/axion/api/models"
+	ops_files "peertech.de/axion/api/restapi/operations/files"
+	"peertech.de/axion/pkg/tracing"
+)
+
+func (api *API) handleGetFileContent(params ops_files.GetFileContentParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleGetFileContent").
+		Str("path", params.Path).
+		Logger()
+
+	if params.Path == "" {
+		return ops_files.NewGetFileContentBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("File path cannot be empty")))
+	}
+	if err := api.checkPath(params.HTTPRequest.Context(), params.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_files.NewGetFileContentForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
+
+	fi, err := os.Lstat(params.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ops_files.NewGetFileContentNotFound().WithPayload(newAPIError(http.StatusNotFound))
+		}
+
+		scopedLog.Error().Err(err).Msg("Failed to stat file")
+		return ops_files.NewGetFileContentInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to stat file")))
+	}
+	if !fi.Mode().IsRegular() {
+		return ops_files.NewGetFileContentBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Path is not a regular file")))
+	}
+
+	fd, err := os.Open(params.Path)
+	if err != nil {
+		scopedLog.Error().Err(err).Msg("Failed to open file")
+		return ops_files.NewGetFileContentInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to open file")))
+	}
+
+	// The checksum is sent as header, so hash the content before streaming it
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, fd); err != nil {
+		fd.Close()
+		scopedLog.Error().Err(err).Msg("Failed to calculate file checksum")
+		return ops_files.NewGetFileContentInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to calculate file checksum")))
+	}
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		fd.Close()
+		scopedLog.Error().Err(err).Msg("Failed to rewind file")
+		return ops_files.NewGetFileContentInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to read file")))
+	}
+
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	etag := generateFileETag(fi, checksum)
+	if etagNoneMatch(params.IfNoneMatch, etag) {
+		fd.Close()
+		return ops_files.NewGetFileContentNotModified().WithETag(etag)
+	}
+
+	return ops_files.NewGetFileContentOK().
+		WithETag(etag).
+		WithContentSha256(checksum).
+		WithPayload(fd)
+}
+
+func (api *API) handlePutFileContent(params ops_files.PutFileContentParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handlePutFileContent").
+		Str("path", params.Path).
+		Logger()
+
+	defer params.Content.Close()
+
+	if params.Path == "" {
+		return ops_files.NewPutFileContentBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("File path cannot be empty")))
+	}
+	if err := api.checkPath(params.HTTPRequest.Context(), params.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_files.NewPutFileContentForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
+
+	unlock, err := api.paths.lock(params.Path)
+	if err != nil {
+		return ops_files.NewPutFileContentConflict().
+			WithPayload(newAPIError(http.StatusConflict, WithMessage("Path is locked by another operation")))
+	}
+	defer unlock()
+
+	fi, err := os.Lstat(params.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		scopedLog.Error().Err(err).Msg("Failed to stat file")
+		return ops_files.NewPutFileContentInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to stat file")))
+	}
+	fileExists := err == nil
+	if fileExists && !fi.Mode().IsRegular() {
+		return ops_files.NewPutFileContentBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Path is not a regular file")))
+	}
+
+	ifMatch := params.HTTPRequest.Header.Get("If-Match")
+	if ifMatch != "" {
+		if !fileExists {
+			return ops_files.NewPutFileContentPreconditionFailed().
+				WithPayload(newAPIError(http.StatusPreconditionFailed, WithMessage("File does not exist for conditional update")))
+		}
+
+		currentETag, err := currentFileETag(params.Path, fi)
+		if err != nil {
+			scopedLog.Error().Err(err).Msg("Failed to calculate file checksum")
+			return ops_files.NewPutFileContentInternalServerError().
+				WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to calculate file checksum")))
+		}
+		if ifMatch != currentETag {
+			return ops_files.NewPutFileContentConflict().
+				WithPayload(newAPIError(http.StatusConflict, WithMessage("ETag mismatch")))
+		}
+	} else if fileExists {
+		// File exists but no If-Match header sent
+		return ops_files.NewPutFileContentPreconditionRequired().
+			WithPayload(newAPIError(http.StatusPreconditionRequired, WithMessage("Missing If-Match header")))
+	}
+
+	var expected string
+	if params.ContentSha256 != nil {
+		expected = strings.ToLower(*params.ContentSha256)
+	}
+
+	_, span := tracing.Start(params.HTTPRequest.Context(), "fs.writeFile", attribute.String("axion.path", params.Path))
+	checksum, err := writeFileContent(params.Path, params.Content, expected, fi)
+	tracing.End(span, err)
+	if err != nil {
+		var oe *OpError
+		if errors.As(err, &oe) && oe.Code == http.StatusUnprocessableEntity {
+			return ops_files.NewPutFileContentUnprocessableEntity().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		}
+
+		scopedLog.Error().Err(err).Msg("Failed to write file")
+		return ops_files.NewPutFileContentInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to write file")))
+	}
+
+	fi, err = os.Stat(params.Path)
+	if err != nil {
+		scopedLog.Error().Err(err).Msg("Failed to stat file after write")
+		return ops_files.NewPutFileContentInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to stat file after write")))
+	}
+
+	api.events.publish(params.HTTPRequest.Context(), &models.AgentEvent{Type: eventFileChanged, Path: params.Path})
+
+	etag := generateFileETag(fi, checksum)
+	if fileExists {
+		return ops_files.NewPutFileContentNoContent().WithETag(etag)
+	}
+	return ops_files.NewPutFileContentCreated().WithETag(etag)
+}
+
+// writeFileContent atomically replaces the content of the file at path with src. The
+// content is verified against the expected checksum, if any, before the file is
+// replaced. Mode and ownership of the existing file, given by fi, are kept. The
+// checksum of the written content is returned.
+func writeFileContent(path string, src io.Reader, expected string, fi os.FileInfo) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", newOpError(http.StatusInternalServerError, "Failed to create temporary file", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), src); err != nil {
+		tmp.Close()
+		return "", newOpError(http.StatusInternalServerError, "Failed to write file", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", newOpError(http.StatusInternalServerError, "Failed to write file", err)
+	}
+
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	if expected != "" && expected != checksum {
+		return "", newOpError(http.StatusUnprocessableEntity, "Content-SHA256 mismatch, the content was corrupted in transfer", nil)
+	}
+
+	mode := os.FileMode(0644)
+	if fi != nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return "", newOpError(http.StatusInternalServerError, "Failed to chmod file", err)
+	}
+	if fi != nil {
+		stat := fi.Sys().(*syscall.Stat_t)
+		if err := os.Chown(tmp.Name(), int(stat.Uid), int(stat.Gid)); err != nil {
+			return "", newOpError(http.StatusInternalServerError, "Failed to chown file", err)
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", newOpError(http.StatusInternalServerError, "Failed to replace file", err)
+	}
+	return checksum, nil
+}
@@ -10,18 +10,21 @@ import (
 	"os"
 	"os/user"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
-	"time"
 
 	"github.com/go-openapi/runtime/middleware"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 
 	"peertech.de/axion/api/models"
 	ops_files "peertech.de/axion/api/restapi/operations/files"
+	"peertech.de/axion/pkg/tracing"
 )
 
 func (api *API) handleGetFileProperties(params ops_files.GetFilePropertiesParams) middleware.Responder {
-	scopedLog := log.With().
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
 		Str("handler", "handleGetFileProperties").
 		Str("path", params.Path).
 		Logger()
@@ -29,38 +32,64 @@ func (api *API) handleGetFileProperties(params ops_files.GetFilePropertiesParams
 	if params.Path == "" {
 		return middleware.Error(http.StatusBadRequest, "File path cannot be empty")
 	}
+	if err := api.checkPath(params.HTTPRequest.Context(), params.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_files.NewGetFilePropertiesForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
 
-	fi, err := os.Stat(params.Path)
+	file, etag, err := statFile(params.Path)
 	if err != nil {
-		if os.IsNotExist(err) {
+		var oe *OpError
+		errors.As(err, &oe)
+		switch oe.Code {
+		case http.StatusNotFound:
 			return ops_files.NewGetFilePropertiesNotFound().WithPayload(newAPIError(http.StatusNotFound))
+		case http.StatusBadRequest:
+			return ops_files.NewGetFilePropertiesBadRequest().
+				WithPayload(newAPIError(http.StatusBadRequest, WithMessage(oe.Msg)))
 		}
-
-		scopedLog.Error().Err(err).Msg("Failed to stat file")
+		scopedLog.Error().Err(err).Msg(oe.Msg)
 		return ops_files.NewGetFilePropertiesInternalServerError().
-			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to stat file")))
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage(oe.Msg)))
+	}
+
+	if etagNoneMatch(params.IfNoneMatch, etag) {
+		return ops_files.NewGetFilePropertiesNotModified().WithETag(etag)
 	}
+	return ops_files.NewGetFilePropertiesOK().WithETag(etag).WithPayload(file)
+}
 
-	checksum, err := calculateFileChecksum(params.Path)
+// statFile returns the properties and the ETag of the file at path. Missing files and
+// symlinks are reported as *OpError with 404 and 400.
+func statFile(path string) (*models.FileProperties, string, error) {
+	fi, err := os.Lstat(path)
 	if err != nil {
-		scopedLog.Error().Err(err).Msg("Failed to calculate file checksum")
-		return ops_files.NewGetFilePropertiesInternalServerError().
-			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to calculate file checksum")))
+		if os.IsNotExist(err) {
+			return nil, "", newOpError(http.StatusNotFound, "File not found", err)
+		}
+		return nil, "", newOpError(http.StatusInternalServerError, "Failed to stat file", err)
+	}
+
+	// Don't report the properties of the link target as the ones of the file
+	if isSymlink(fi) {
+		return nil, "", newOpError(http.StatusBadRequest, "Path is a symlink", nil)
+	}
+
+	checksum, err := calculateFileChecksum(path)
+	if err != nil {
+		return nil, "", newOpError(http.StatusInternalServerError, "Failed to calculate file checksum", err)
 	}
 
 	stat := fi.Sys().(*syscall.Stat_t)
 	owner, err := user.LookupId(fmt.Sprint(stat.Uid))
 	if err != nil {
-		scopedLog.Error().Err(err).Msg("Failed to lookup user id")
-		return ops_files.NewGetFilePropertiesInternalServerError().
-			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to lookup user id")))
+		return nil, "", newOpError(http.StatusInternalServerError, "Failed to lookup user id", err)
 	}
 
 	group, err := user.LookupGroupId(fmt.Sprint(stat.Gid))
 	if err != nil {
-		scopedLog.Error().Err(err).Msg("Failed to lookup group id")
-		return ops_files.NewGetFilePropertiesInternalServerError().
-			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to lookup group id")))
+		return nil, "", newOpError(http.StatusInternalServerError, "Failed to lookup group id", err)
 	}
 
 	file := &models.FileProperties{
@@ -69,13 +98,11 @@ func (api *API) handleGetFileProperties(params ops_files.GetFilePropertiesParams
 		Group:    group.Name,
 		Checksum: checksum,
 	}
-
-	etag := generateFileETag(fi)
-	return ops_files.NewGetFilePropertiesOK().WithETag(etag).WithPayload(file)
+	return file, generateFileETag(fi, checksum), nil
 }
 
 func (api *API) handlePutFile(params ops_files.PutFileParams) middleware.Responder {
-	scopedLog := log.With().
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
 		Str("handler", "handlePutFile").
 		Str("path", params.Path).
 		Logger()
@@ -84,6 +111,11 @@ func (api *API) handlePutFile(params ops_files.PutFileParams) middleware.Respond
 		return ops_files.NewPutFileBadRequest().
 			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("File path cannot be empty")))
 	}
+	if err := api.checkPath(params.HTTPRequest.Context(), params.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_files.NewPutFileForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
 
 	var (
 		mode     *os.FileMode
@@ -135,8 +167,20 @@ func (api *API) handlePutFile(params ops_files.PutFileParams) middleware.Respond
 		gid = &id
 	}
 
-	fi, err := os.Stat(params.Path)
+	// The ETag is validated and the file mutated without other requests in between
+	unlock, err := api.paths.lock(params.Path)
+	if err != nil {
+		return ops_files.NewPutFileConflict().
+			WithPayload(newAPIError(http.StatusConflict, WithMessage("Path is locked by another operation")))
+	}
+	defer unlock()
+
+	fi, err := os.Lstat(params.Path)
 	fileExists := err == nil
+	if fileExists && isSymlink(fi) {
+		return ops_files.NewPutFileBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Path is a symlink")))
+	}
 
 	ifMatch := params.HTTPRequest.Header.Get("If-Match")
 	if ifMatch != "" {
@@ -145,7 +189,12 @@ func (api *API) handlePutFile(params ops_files.PutFileParams) middleware.Respond
 				WithPayload(newAPIError(http.StatusPreconditionFailed, WithMessage("File does not exist for conditional update")))
 		}
 
-		currentETag := generateFileETag(fi)
+		currentETag, err := currentFileETag(params.Path, fi)
+		if err != nil {
+			scopedLog.Error().Err(err).Msg("Failed to calculate file checksum")
+			return ops_files.NewPutFileInternalServerError().
+				WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to calculate file checksum")))
+		}
 		if ifMatch != currentETag {
 			return ops_files.NewPutFileConflict().
 				WithPayload(newAPIError(http.StatusConflict, WithMessage("ETag mismatch")))
@@ -156,7 +205,9 @@ func (api *API) handlePutFile(params ops_files.PutFileParams) middleware.Respond
 			WithPayload(newAPIError(http.StatusPreconditionRequired, WithMessage("Missing If-Match header")))
 	}
 
+	_, span := tracing.Start(params.HTTPRequest.Context(), "fs.putFile", attribute.String("axion.path", params.Path))
 	created, err := putFile(params.Path, mode, uid, gid)
+	tracing.End(span, err)
 	if err != nil {
 		var oe *OpError
 		if errors.As(err, &oe) {
@@ -169,15 +220,21 @@ func (api *API) handlePutFile(params ops_files.PutFileParams) middleware.Respond
 		}
 	}
 
+	api.events.publish(params.HTTPRequest.Context(), &models.AgentEvent{Type: eventFileChanged, Path: params.Path})
+
+	var etag string
+	if fi, err := os.Stat(params.Path); err == nil {
+		etag, _ = currentFileETag(params.Path, fi)
+	}
 	if created {
-		return ops_files.NewPutFileCreated()
+		return ops_files.NewPutFileCreated().WithETag(etag)
 	}
 
-	return ops_files.NewPutFileNoContent()
+	return ops_files.NewPutFileNoContent().WithETag(etag)
 }
 
 func (api *API) handleDeleteFile(params ops_files.DeleteFileParams) middleware.Responder {
-	scopedLog := log.With().
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
 		Str("handler", "handleDeleteFile").
 		Str("path", params.Path).
 		Logger()
@@ -186,8 +243,20 @@ func (api *API) handleDeleteFile(params ops_files.DeleteFileParams) middleware.R
 		return ops_files.NewPutFileBadRequest().
 			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("File path cannot be empty")))
 	}
+	if err := api.checkPath(params.HTTPRequest.Context(), params.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_files.NewDeleteFileForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
+
+	unlock, err := api.paths.lock(params.Path)
+	if err != nil {
+		return ops_files.NewDeleteFileConflict().
+			WithPayload(newAPIError(http.StatusConflict, WithMessage("Path is locked by another operation")))
+	}
+	defer unlock()
 
-	fi, err := os.Stat(params.Path)
+	fi, err := os.Lstat(params.Path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return ops_files.NewDeleteFileNoContent()
@@ -198,19 +267,31 @@ func (api *API) handleDeleteFile(params ops_files.DeleteFileParams) middleware.R
 			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to stat file")))
 	}
 
+	if isSymlink(fi) {
+		return ops_files.NewDeleteFileBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Path is a symlink")))
+	}
+
 	ifMatch := params.HTTPRequest.Header.Get("If-Match")
 	if ifMatch == "" {
 		return ops_files.NewDeleteFilePreconditionRequired().
 			WithPayload(newAPIError(http.StatusPreconditionRequired, WithMessage("Missing If-Match header")))
 	}
 
-	currentETag := generateFileETag(fi)
+	currentETag, err := currentFileETag(params.Path, fi)
+	if err != nil {
+		scopedLog.Error().Err(err).Msg("Failed to calculate file checksum")
+		return ops_files.NewDeleteFileInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to calculate file checksum")))
+	}
 	if ifMatch != currentETag {
 		return ops_files.NewDeleteFileConflict().
 			WithPayload(newAPIError(http.StatusConflict, WithMessage("ETag mismach")))
 	}
 
+	_, span := tracing.Start(params.HTTPRequest.Context(), "fs.remove", attribute.String("axion.path", params.Path))
 	err = os.Remove(params.Path)
+	tracing.End(span, err)
 	switch {
 	case err == nil:
 	case errors.Is(err, os.ErrPermission):
@@ -225,6 +306,7 @@ func (api *API) handleDeleteFile(params ops_files.DeleteFileParams) middleware.R
 			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to delete file")))
 	}
 
+	api.events.publish(params.HTTPRequest.Context(), &models.AgentEvent{Type: eventFileDeleted, Path: params.Path})
 	return ops_files.NewDeleteFileNoContent()
 }
 
@@ -295,7 +377,10 @@ func decodeFileMode(modeString string) (os.FileMode, error) {
 	return os.FileMode(mode), nil
 }
 
-func generateFileETag(fi os.FileInfo) string {
+// generateFileETag derives the ETag of a file from its content checksum, mode and
+// ownership. Unlike the modification time the checksum changes with every content
+// change, even if the modification time is preserved.
+func generateFileETag(fi os.FileInfo, checksum string) string {
 	stat, ok := fi.Sys().(*syscall.Stat_t)
 	if !ok {
 		return ""
@@ -305,10 +390,34 @@ func generateFileETag(fi os.FileInfo) string {
 		fi.Mode().Perm(),
 		stat.Uid,
 		stat.Gid,
-		fi.ModTime().UTC().Format(time.RFC3339Nano),
+		checksum,
 	)
 	sum := sha256.Sum256([]byte(data))
-	return `W/"` + hex.EncodeToString(sum[:]) + `"`
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// etagNoneMatch reports whether the If-None-Match header lists etag, i.e. the client
+// has a current copy. ETags are compared weakly, as required for If-None-Match.
+func etagNoneMatch(ifNoneMatch *string, etag string) bool {
+	if ifNoneMatch == nil || etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(*ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// currentFileETag returns the ETag of the file at path described by fi
+func currentFileETag(path string, fi os.FileInfo) (string, error) {
+	checksum, err := calculateFileChecksum(path)
+	if err != nil {
+		return "", err
+	}
+	return generateFileETag(fi, checksum), nil
 }
 
 func calculateFileChecksum(path string) (string, error) {
@@ -324,3 +433,57 @@ func calculateFileChecksum(path string) (string, error) {
 	}
 	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
+
+// batchStatWorkers is the number of files stat'ed and checksummed concurrently
+const batchStatWorkers = 8
+
+func (api *API) handleBatchStatFiles(params ops_files.BatchStatFilesParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleBatchStatFiles").
+		Logger()
+
+	if params.Request == nil || len(params.Request.Paths) == 0 {
+		return ops_files.NewBatchStatFilesBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Paths cannot be empty")))
+	}
+
+	results := make([]*models.BatchStatResult, len(params.Request.Paths))
+	slots := make(chan struct{}, batchStatWorkers)
+	var wg sync.WaitGroup
+	for i, path := range params.Request.Paths {
+		results[i] = &models.BatchStatResult{Path: path}
+
+		if path == "" {
+			results[i].Error = newAPIError(http.StatusBadRequest, WithMessage("File path cannot be empty"))
+			continue
+		}
+		if err := api.checkPath(params.HTTPRequest.Context(), path); err != nil {
+			scopedLog.Warn().Err(err).Str("path", path).Msg("Path not allowed")
+			results[i].Error = newAPIError(http.StatusForbidden, WithMessage("Path not allowed"))
+			continue
+		}
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(result *models.BatchStatResult) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			file, etag, err := statFile(result.Path)
+			if err != nil {
+				var oe *OpError
+				errors.As(err, &oe)
+				if oe.Code == http.StatusInternalServerError {
+					scopedLog.Error().Err(err).Str("path", result.Path).Msg(oe.Msg)
+				}
+				result.Error = newAPIError(oe.Code, WithMessage(oe.Msg))
+				return
+			}
+			result.Properties = file
+			result.Etag = etag
+		}(results[i])
+	}
+	wg.Wait()
+
+	return ops_files.NewBatchStatFilesOK().WithPayload(&models.BatchStatResponse{Results: results})
+}
@@ -0,0 +1,782 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-openapi/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"peertech.de/axion/api/grpc/agentpb"
+	"peertech.de/axion/api/models"
+	"peertech.de/axion/pkg/agentgrpc"
+)
+
+// grpcAgent serves the gRPC API by passing every call as request through the REST
+// handlers, so both APIs share the validation, policy, limits and audit log
+type grpcAgent struct {
+	agentpb.UnimplementedAgentServer
+
+	handler http.Handler
+}
+
+// grpcRequest describes the REST request serving a call
+type grpcRequest struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	// body is encoded as JSON unless it is a reader, which is streamed as is
+	body any
+}
+
+func (g *grpcAgent) GetFileProperties(ctx context.Context, req *agentpb.PathRequest) (*agentpb.FileProperties, error) {
+	var props models.FileProperties
+	rec, err := g.call(ctx, grpcRequest{method: http.MethodGet, path: "/files", query: pathQuery(req.Path)}, &props)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.FilePropertiesToProto(&props, rec.header.Get("ETag")), nil
+}
+
+func (g *grpcAgent) BatchStatFiles(ctx context.Context, req *agentpb.BatchStatRequest) (*agentpb.BatchStatResponse, error) {
+	var result models.BatchStatResponse
+	_, err := g.call(ctx, grpcRequest{
+		method: http.MethodPost,
+		path:   "/files:batchStat",
+		body:   &models.BatchStatRequest{Paths: req.Paths},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.BatchStatResponseToProto(&result), nil
+}
+
+func (g *grpcAgent) PutFile(ctx context.Context, req *agentpb.PutFileRequest) (*agentpb.PutResponse, error) {
+	rec, err := g.call(ctx, grpcRequest{
+		method: http.MethodPut,
+		path:   "/files",
+		query:  pathQuery(req.Path),
+		header: ifMatchHeader(req.IfMatch),
+		body:   agentgrpc.FilePropertiesFromProto(req.Properties),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return rec.putResponse(), nil
+}
+
+func (g *grpcAgent) DeleteFile(ctx context.Context, req *agentpb.DeleteRequest) (*agentpb.Empty, error) {
+	_, err := g.call(ctx, grpcRequest{
+		method: http.MethodDelete,
+		path:   "/files",
+		query:  pathQuery(req.Path),
+		header: ifMatchHeader(req.IfMatch),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &agentpb.Empty{}, nil
+}
+
+func (g *grpcAgent) GetFileContent(req *agentpb.PathRequest, stream agentpb.Agent_GetFileContentServer) error {
+	return g.stream(stream.Context(), grpcRequest{
+		method: http.MethodGet,
+		path:   "/files/content",
+		query:  pathQuery(req.Path),
+	}, stream.Send)
+}
+
+func (g *grpcAgent) PutFileContent(stream agentpb.Agent_PutFileContentServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	header := first.GetHeader()
+	if header == nil {
+		return status.Error(codes.InvalidArgument, "the first message must be the header")
+	}
+
+	h := ifMatchHeader(header.IfMatch)
+	if header.Sha256 != "" {
+		h.Set("Content-SHA256", header.Sha256)
+	}
+	body := agentgrpc.NewStreamReader(nil, func() ([]byte, error) {
+		msg, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return msg.GetData(), nil
+	})
+
+	rec, err := g.call(stream.Context(), grpcRequest{
+		method: http.MethodPut,
+		path:   "/files/content",
+		query:  pathQuery(header.Path),
+		header: h,
+		body:   body,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(rec.putResponse())
+}
+
+func (g *grpcAgent) GetDirectoryProperties(ctx context.Context, req *agentpb.PathRequest) (*agentpb.DirectoryProperties, error) {
+	var props models.DirectoryProperties
+	rec, err := g.call(ctx, grpcRequest{method: http.MethodGet, path: "/directories", query: pathQuery(req.Path)}, &props)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.DirectoryPropertiesToProto(&props, rec.header.Get("ETag")), nil
+}
+
+func (g *grpcAgent) PutDirectory(ctx context.Context, req *agentpb.PutDirectoryRequest) (*agentpb.PutResponse, error) {
+	rec, err := g.call(ctx, grpcRequest{
+		method: http.MethodPut,
+		path:   "/directories",
+		query:  pathQuery(req.Path),
+		header: ifMatchHeader(req.IfMatch),
+		body:   agentgrpc.DirectoryPropertiesFromProto(req.Properties),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return rec.putResponse(), nil
+}
+
+func (g *grpcAgent) DeleteDirectory(ctx context.Context, req *agentpb.DeleteRequest) (*agentpb.Empty, error) {
+	_, err := g.call(ctx, grpcRequest{
+		method: http.MethodDelete,
+		path:   "/directories",
+		query:  pathQuery(req.Path),
+		header: ifMatchHeader(req.IfMatch),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &agentpb.Empty{}, nil
+}
+
+func (g *grpcAgent) GetSymlinkProperties(ctx context.Context, req *agentpb.PathRequest) (*agentpb.SymlinkProperties, error) {
+	var props models.SymlinkProperties
+	rec, err := g.call(ctx, grpcRequest{method: http.MethodGet, path: "/symlinks", query: pathQuery(req.Path)}, &props)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.SymlinkPropertiesToProto(&props, rec.header.Get("ETag")), nil
+}
+
+func (g *grpcAgent) PutSymlink(ctx context.Context, req *agentpb.PutSymlinkRequest) (*agentpb.PutResponse, error) {
+	query := pathQuery(req.Path)
+	query.Set("force", strconv.FormatBool(req.Force))
+
+	rec, err := g.call(ctx, grpcRequest{
+		method: http.MethodPut,
+		path:   "/symlinks",
+		query:  query,
+		header: ifMatchHeader(req.IfMatch),
+		body:   agentgrpc.SymlinkPropertiesFromProto(req.Properties),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return rec.putResponse(), nil
+}
+
+func (g *grpcAgent) DeleteSymlink(ctx context.Context, req *agentpb.DeleteRequest) (*agentpb.Empty, error) {
+	_, err := g.call(ctx, grpcRequest{
+		method: http.MethodDelete,
+		path:   "/symlinks",
+		query:  pathQuery(req.Path),
+		header: ifMatchHeader(req.IfMatch),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &agentpb.Empty{}, nil
+}
+
+func (g *grpcAgent) Upload(stream agentpb.Agent_UploadServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	header := first.GetHeader()
+	if header == nil {
+		return status.Error(codes.InvalidArgument, "the first message must be the header")
+	}
+
+	query := pathQuery(header.Path)
+	query.Set("recursive", strconv.FormatBool(header.Recursive))
+	query.Set("preserve", strconv.FormatBool(header.Preserve))
+	h := http.Header{}
+	if header.Sha256 != "" {
+		h.Set("Content-SHA256", header.Sha256)
+	}
+	if header.ArchiveFormat != "" {
+		h.Set("X-Archive-Format", header.ArchiveFormat)
+	}
+	body := agentgrpc.NewStreamReader(nil, func() ([]byte, error) {
+		msg, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return msg.GetData(), nil
+	})
+
+	rec, err := g.call(stream.Context(), grpcRequest{
+		method: http.MethodPost,
+		path:   "/upload",
+		query:  query,
+		header: h,
+		body:   body,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(rec.putResponse())
+}
+
+func (g *grpcAgent) Download(req *agentpb.DownloadRequest, stream agentpb.Agent_DownloadServer) error {
+	query := pathQuery(req.Path)
+	query.Set("recursive", strconv.FormatBool(req.Recursive))
+	query.Set("preserve", strconv.FormatBool(req.Preserve))
+	query["include"] = req.Include
+	query["exclude"] = req.Exclude
+	h := http.Header{}
+	if req.AcceptEncoding != "" {
+		h.Set("Accept-Encoding", req.AcceptEncoding)
+	}
+
+	return g.stream(stream.Context(), grpcRequest{
+		method: http.MethodGet,
+		path:   "/download",
+		query:  query,
+		header: h,
+	}, stream.Send)
+}
+
+func (g *grpcAgent) InitUpload(ctx context.Context, req *agentpb.UploadSessionRequest) (*agentpb.UploadSession, error) {
+	var session models.UploadSession
+	_, err := g.call(ctx, grpcRequest{
+		method: http.MethodPost,
+		path:   "/uploads",
+		body:   agentgrpc.UploadSessionRequestFromProto(req),
+	}, &session)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.UploadSessionToProto(&session), nil
+}
+
+func (g *grpcAgent) GetUploadSession(ctx context.Context, req *agentpb.UploadSessionId) (*agentpb.UploadSession, error) {
+	var session models.UploadSession
+	_, err := g.call(ctx, grpcRequest{method: http.MethodGet, path: "/uploads/" + url.PathEscape(req.Id)}, &session)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.UploadSessionToProto(&session), nil
+}
+
+func (g *grpcAgent) AppendUpload(stream agentpb.Agent_AppendUploadServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	header := first.GetHeader()
+	if header == nil {
+		return status.Error(codes.InvalidArgument, "the first message must be the header")
+	}
+
+	h := http.Header{}
+	h.Set("Upload-Offset", strconv.FormatInt(header.Offset, 10))
+	if header.Sha256 != "" {
+		h.Set("Content-SHA256", header.Sha256)
+	}
+	body := agentgrpc.NewStreamReader(nil, func() ([]byte, error) {
+		msg, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return msg.GetData(), nil
+	})
+
+	var session models.UploadSession
+	_, err = g.call(stream.Context(), grpcRequest{
+		method: http.MethodPatch,
+		path:   "/uploads/" + url.PathEscape(header.Id),
+		header: h,
+		body:   body,
+	}, &session)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(agentgrpc.UploadSessionToProto(&session))
+}
+
+func (g *grpcAgent) CommitUpload(ctx context.Context, req *agentpb.UploadSessionId) (*agentpb.PutResponse, error) {
+	rec, err := g.call(ctx, grpcRequest{method: http.MethodPost, path: "/uploads/" + url.PathEscape(req.Id) + "/commit"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return rec.putResponse(), nil
+}
+
+func (g *grpcAgent) AbortUpload(ctx context.Context, req *agentpb.UploadSessionId) (*agentpb.Empty, error) {
+	_, err := g.call(ctx, grpcRequest{method: http.MethodDelete, path: "/uploads/" + url.PathEscape(req.Id)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &agentpb.Empty{}, nil
+}
+
+func (g *grpcAgent) CreateBackup(ctx context.Context, req *agentpb.CreateBackupRequest) (*agentpb.Backup, error) {
+	query := pathQuery(req.Path)
+	query["include"] = req.Include
+	query["exclude"] = req.Exclude
+
+	var backup models.Backup
+	_, err := g.call(ctx, grpcRequest{method: http.MethodPost, path: "/backup", query: query}, &backup)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.BackupToProto(&backup), nil
+}
+
+func (g *grpcAgent) GetBackup(ctx context.Context, req *agentpb.BackupId) (*agentpb.Backup, error) {
+	var backup models.Backup
+	_, err := g.call(ctx, grpcRequest{method: http.MethodGet, path: "/backup/" + url.PathEscape(req.Id)}, &backup)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.BackupToProto(&backup), nil
+}
+
+func (g *grpcAgent) DeleteBackup(ctx context.Context, req *agentpb.BackupId) (*agentpb.Empty, error) {
+	_, err := g.call(ctx, grpcRequest{method: http.MethodDelete, path: "/backup/" + url.PathEscape(req.Id)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &agentpb.Empty{}, nil
+}
+
+func (g *grpcAgent) RestoreBackup(ctx context.Context, req *agentpb.BackupId) (*agentpb.PutResponse, error) {
+	rec, err := g.call(ctx, grpcRequest{method: http.MethodPost, path: "/restore/" + url.PathEscape(req.Id)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return rec.putResponse(), nil
+}
+
+func (g *grpcAgent) ExecuteCommand(ctx context.Context, req *agentpb.CommandRequest) (*agentpb.CommandResponse, error) {
+	var result models.CommandResponse
+	_, err := g.call(ctx, grpcRequest{
+		method: http.MethodPost,
+		path:   "/command",
+		body:   agentgrpc.CommandRequestFromProto(req),
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.CommandResponseToProto(&result), nil
+}
+
+func (g *grpcAgent) ExecuteCommandStream(req *agentpb.CommandRequest, stream agentpb.Agent_ExecuteCommandStreamServer) error {
+	return g.streamCommandEvents(stream.Context(), grpcRequest{
+		method: http.MethodPost,
+		path:   "/command/stream",
+		body:   agentgrpc.CommandRequestFromProto(req),
+	}, stream.Send)
+}
+
+func (g *grpcAgent) ExecuteScript(ctx context.Context, req *agentpb.ScriptRequest) (*agentpb.CommandResponse, error) {
+	var result models.CommandResponse
+	_, err := g.call(ctx, grpcRequest{
+		method: http.MethodPost,
+		path:   "/scripts",
+		body:   agentgrpc.ScriptRequestFromProto(req),
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.CommandResponseToProto(&result), nil
+}
+
+func (g *grpcAgent) ExecuteScriptStream(req *agentpb.ScriptRequest, stream agentpb.Agent_ExecuteScriptStreamServer) error {
+	return g.streamCommandEvents(stream.Context(), grpcRequest{
+		method: http.MethodPost,
+		path:   "/scripts/stream",
+		body:   agentgrpc.ScriptRequestFromProto(req),
+	}, stream.Send)
+}
+
+// streamCommandEvents serves a request streaming command events, each event is passed
+// on to send
+func (g *grpcAgent) streamCommandEvents(ctx context.Context, req grpcRequest, sendEvent func(*agentpb.CommandEvent) error) error {
+	// The REST handler writes newline delimited events, pass on every complete line
+	var buf []byte
+	send := func(p []byte) error {
+		buf = append(buf, p...)
+		for {
+			i := bytes.IndexByte(buf, '\n')
+			if i < 0 {
+				return nil
+			}
+			var ev models.CommandEvent
+			if err := json.Unmarshal(buf[:i], &ev); err != nil {
+				return err
+			}
+			buf = buf[i+1:]
+			if err := sendEvent(agentgrpc.CommandEventToProto(&ev)); err != nil {
+				return err
+			}
+		}
+	}
+
+	rec := &grpcResponse{header: http.Header{}, send: send}
+	if err := g.serve(ctx, req, rec); err != nil {
+		return err
+	}
+	return rec.err()
+}
+
+func (g *grpcAgent) ExecuteCommandAsync(ctx context.Context, req *agentpb.CommandRequest) (*agentpb.CommandJob, error) {
+	var job models.CommandJob
+	_, err := g.call(ctx, grpcRequest{
+		method: http.MethodPost,
+		path:   "/commands",
+		body:   agentgrpc.CommandRequestFromProto(req),
+	}, &job)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.CommandJobToProto(&job), nil
+}
+
+func (g *grpcAgent) GetCommandJob(ctx context.Context, req *agentpb.CommandJobId) (*agentpb.CommandJob, error) {
+	var job models.CommandJob
+	_, err := g.call(ctx, grpcRequest{method: http.MethodGet, path: "/commands/" + url.PathEscape(req.Id)}, &job)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.CommandJobToProto(&job), nil
+}
+
+func (g *grpcAgent) CancelCommandJob(ctx context.Context, req *agentpb.CommandJobId) (*agentpb.CommandJob, error) {
+	var job models.CommandJob
+	_, err := g.call(ctx, grpcRequest{method: http.MethodDelete, path: "/commands/" + url.PathEscape(req.Id)}, &job)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.CommandJobToProto(&job), nil
+}
+
+func (g *grpcAgent) GetPackage(ctx context.Context, req *agentpb.PackageName) (*agentpb.Package, error) {
+	var pkg models.Package
+	_, err := g.call(ctx, grpcRequest{method: http.MethodGet, path: "/packages/" + url.PathEscape(req.Name)}, &pkg)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.PackageToProto(&pkg), nil
+}
+
+func (g *grpcAgent) PutPackage(ctx context.Context, req *agentpb.PutPackageRequest) (*agentpb.Package, error) {
+	var pkg models.Package
+	_, err := g.call(ctx, grpcRequest{
+		method: http.MethodPut,
+		path:   "/packages/" + url.PathEscape(req.Name),
+		body:   &models.PackageRequest{Version: req.Version, Pinned: req.Pinned},
+	}, &pkg)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.PackageToProto(&pkg), nil
+}
+
+func (g *grpcAgent) DeletePackage(ctx context.Context, req *agentpb.PackageName) (*agentpb.Empty, error) {
+	_, err := g.call(ctx, grpcRequest{method: http.MethodDelete, path: "/packages/" + url.PathEscape(req.Name)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &agentpb.Empty{}, nil
+}
+
+func (g *grpcAgent) GetService(ctx context.Context, req *agentpb.ServiceName) (*agentpb.ServiceStatus, error) {
+	var svc models.ServiceStatus
+	_, err := g.call(ctx, grpcRequest{method: http.MethodGet, path: "/services/" + url.PathEscape(req.Name)}, &svc)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.ServiceStatusToProto(&svc), nil
+}
+
+func (g *grpcAgent) ServiceAction(ctx context.Context, req *agentpb.ServiceActionRequest) (*agentpb.ServiceStatus, error) {
+	var svc models.ServiceStatus
+	_, err := g.call(ctx, grpcRequest{
+		method: http.MethodPost,
+		path:   "/services/" + url.PathEscape(req.Name) + "/" + url.PathEscape(req.Action),
+	}, &svc)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.ServiceStatusToProto(&svc), nil
+}
+
+func (g *grpcAgent) GetHealth(ctx context.Context, req *agentpb.Empty) (*agentpb.Health, error) {
+	var h models.Health
+	_, err := g.call(ctx, grpcRequest{method: http.MethodGet, path: "/healthz"}, &h)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.HealthToProto(&h), nil
+}
+
+func (g *grpcAgent) GetReadiness(ctx context.Context, req *agentpb.Empty) (*agentpb.Health, error) {
+	// An agent which isn't ready responds with the failed checks, pass them on
+	rec := &grpcResponse{header: http.Header{}}
+	if err := g.serve(ctx, grpcRequest{method: http.MethodGet, path: "/readyz"}, rec); err != nil {
+		return nil, err
+	}
+	if rec.status != http.StatusServiceUnavailable {
+		if err := rec.err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var h models.Health
+	if err := json.Unmarshal(rec.body.Bytes(), &h); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to decode response: %v", err)
+	}
+	return agentgrpc.HealthToProto(&h), nil
+}
+
+func (g *grpcAgent) GetVersion(ctx context.Context, req *agentpb.Empty) (*agentpb.AgentVersion, error) {
+	var v models.AgentVersion
+	_, err := g.call(ctx, grpcRequest{method: http.MethodGet, path: "/version"}, &v)
+	if err != nil {
+		return nil, err
+	}
+	return agentgrpc.AgentVersionToProto(&v), nil
+}
+
+// call serves req and decodes the JSON response into out, unless it is nil
+func (g *grpcAgent) call(ctx context.Context, req grpcRequest, out any) (*grpcResponse, error) {
+	rec := &grpcResponse{header: http.Header{}}
+	if err := g.serve(ctx, req, rec); err != nil {
+		return nil, err
+	}
+	if err := rec.err(); err != nil {
+		return nil, err
+	}
+
+	if out != nil && rec.body.Len() > 0 {
+		if err := json.Unmarshal(rec.body.Bytes(), out); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to decode response: %v", err)
+		}
+	}
+	return rec, nil
+}
+
+// stream serves req and sends the response body in chunks, the response headers are
+// passed along with the first one
+func (g *grpcAgent) stream(ctx context.Context, req grpcRequest, send func(*agentpb.ContentChunk) error) error {
+	first := true
+	rec := &grpcResponse{header: http.Header{}}
+	chunk := func(data []byte) *agentpb.ContentChunk {
+		c := &agentpb.ContentChunk{Data: data}
+		if first {
+			c.Etag = rec.header.Get("ETag")
+			c.Sha256 = rec.header.Get("Content-SHA256")
+			c.ArchiveType = rec.header.Get("X-Archive-Type")
+			c.ArchiveFormat = rec.header.Get("X-Archive-Format")
+			first = false
+		}
+		return c
+	}
+	rec.send = func(p []byte) error {
+		for len(p) > 0 {
+			n := min(len(p), agentgrpc.ChunkSize)
+			if err := send(chunk(bytes.Clone(p[:n]))); err != nil {
+				return err
+			}
+			p = p[n:]
+		}
+		return nil
+	}
+
+	if err := g.serve(ctx, req, rec); err != nil {
+		return err
+	}
+	if err := rec.err(); err != nil {
+		return err
+	}
+
+	// Empty content, still pass on the headers
+	if first {
+		return send(chunk(nil))
+	}
+	return nil
+}
+
+// serve passes req through the REST handlers, attributing it to the peer of the call
+func (g *grpcAgent) serve(ctx context.Context, req grpcRequest, rw http.ResponseWriter) error {
+	var body io.Reader = http.NoBody
+	contentType := ""
+	switch b := req.body.(type) {
+	case nil:
+	case io.Reader:
+		body, contentType = b, runtime.DefaultMime
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to encode request: %v", err)
+		}
+		body, contentType = bytes.NewReader(data), runtime.JSONMime
+	}
+
+	u := url.URL{Path: apiBasePath + req.path, RawQuery: req.query.Encode()}
+	r, err := http.NewRequestWithContext(ctx, req.method, u.String(), body)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+	}
+	for k, v := range req.header {
+		r.Header[k] = v
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if contentType == runtime.DefaultMime {
+		// Streamed, the length is unknown
+		r.ContentLength = -1
+	}
+	r.Proto = "gRPC"
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDHeader); len(ids) > 0 {
+			r.Header.Set(requestIDHeader, ids[0])
+		}
+		if auth := md.Get("authorization"); len(auth) > 0 {
+			r.Header.Set("Authorization", auth[0])
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &info.State
+		}
+	}
+
+	g.handler.ServeHTTP(rw, r)
+	return nil
+}
+
+func pathQuery(path string) url.Values {
+	return url.Values{"path": []string{path}}
+}
+
+func ifMatchHeader(etag string) http.Header {
+	h := http.Header{}
+	if etag != "" {
+		h.Set("If-Match", etag)
+	}
+	return h
+}
+
+// grpcResponse records the response of the REST handlers. Successful response bodies
+// are passed to send if set, all others are buffered.
+type grpcResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	send   func([]byte) error
+}
+
+func (w *grpcResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *grpcResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *grpcResponse) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.send != nil && w.ok() {
+		if err := w.send(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return w.body.Write(p)
+}
+
+// Flush is a no-op, sent chunks are flushed by gRPC
+func (w *grpcResponse) Flush() {}
+
+func (w *grpcResponse) ok() bool {
+	return w.status == 0 || (w.status >= 200 && w.status < 300)
+}
+
+func (w *grpcResponse) putResponse() *agentpb.PutResponse {
+	return &agentpb.PutResponse{
+		Created: w.status == http.StatusCreated,
+		Etag:    w.header.Get("ETag"),
+	}
+}
+
+// err converts an error response into a gRPC status carrying the error payload
+func (w *grpcResponse) err() error {
+	if w.ok() {
+		return nil
+	}
+
+	var payload models.Error
+	if err := json.Unmarshal(w.body.Bytes(), &payload); err != nil || payload.Message == "" {
+		payload.Message = http.StatusText(w.status)
+	}
+	// Validation errors carry their own codes, the status is what REST clients see
+	payload.Code = int64(w.status)
+
+	st := status.New(grpcCode(w.status), payload.Message)
+	if withDetails, err := st.WithDetails(agentgrpc.ErrorToProto(&payload)); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// grpcCode maps the HTTP status of an error response to the closest gRPC code
+func grpcCode(status int) codes.Code {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusRequestTimeout:
+		return codes.DeadlineExceeded
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusPreconditionFailed, http.StatusPreconditionRequired:
+		return codes.FailedPrecondition
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/rs/zerolog/log"
+
+	"peertech.de/axion/api/models"
+	ops_health "peertech.de/axion/api/restapi/operations/health"
+	"peertech.de/axion/pkg/version"
+)
+
+const (
+	healthOK   = "ok"
+	healthFail = "fail"
+
+	// minFreeDiskSpace is the free space a managed path needs for the agent to be ready
+	minFreeDiskSpace = 64 * 1024 * 1024
+)
+
+// health reports the version, uptime and authentication settings of the agent, with
+// checks the readiness checks are run as well
+func (api *API) health(checks bool) *models.Health {
+	h := &models.Health{
+		Status:        healthOK,
+		Version:       version.Version,
+		BuildDate:     version.BuildDate,
+		UptimeSeconds: int64(time.Since(api.started).Seconds()),
+		Auth: &models.HealthAuth{
+			TLS:                api.options.ServerTLSConfig != nil,
+			ClientCertificates: "disabled",
+			Policy:             api.options.Policy != nil,
+			PathSandbox:        len(api.allowedPaths) > 0,
+		},
+	}
+	if api.options.ClientCAs != nil {
+		h.Auth.ClientCertificates = "optional"
+		if api.options.RequireClientCert {
+			h.Auth.ClientCertificates = "required"
+		}
+	}
+	if !checks {
+		return h
+	}
+
+	if api.stopping.Load() {
+		h.Checks = append(h.Checks, &models.HealthCheck{
+			Name: "shutdown", Status: healthFail, Message: "agent is shutting down",
+		})
+	}
+
+	// The managed paths are the allowed ones, the whole filesystem without
+	managed := api.allowedPaths
+	if len(managed) == 0 {
+		managed = []string{"/"}
+	}
+	for _, path := range managed {
+		h.Checks = append(h.Checks, checkDiskSpace(path))
+	}
+	h.Checks = append(h.Checks, checkWritable(api.options.UploadTempDir))
+
+	for _, check := range h.Checks {
+		if check.Status != healthOK {
+			h.Status = healthFail
+		}
+	}
+	return h
+}
+
+// checkDiskSpace checks that the filesystem of path has enough space left for changes
+func checkDiskSpace(path string) *models.HealthCheck {
+	check := &models.HealthCheck{Name: "diskSpace", Path: path, Status: healthOK}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		check.Status = healthFail
+		check.Message = err.Error()
+		return check
+	}
+
+	check.FreeBytes = int64(stat.Bavail) * int64(stat.Bsize)
+	check.TotalBytes = int64(stat.Blocks) * int64(stat.Bsize)
+	if check.FreeBytes < minFreeDiskSpace {
+		check.Status = healthFail
+		check.Message = fmt.Sprintf("only %d bytes free, %d required", check.FreeBytes, minFreeDiskSpace)
+	}
+	return check
+}
+
+// checkWritable checks that uploads can be spooled to dir, the default directory for
+// temporary files if empty
+func checkWritable(dir string) *models.HealthCheck {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	check := &models.HealthCheck{Name: "uploadTempDir", Path: dir, Status: healthOK}
+
+	f, err := os.CreateTemp(dir, ".axion-readyz-*")
+	if err != nil {
+		check.Status = healthFail
+		check.Message = err.Error()
+		return check
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return check
+}
+
+func (api *API) handleGetHealth(params ops_health.GetHealthParams) middleware.Responder {
+	return ops_health.NewGetHealthOK().WithPayload(api.health(false))
+}
+
+func (api *API) handleGetReadiness(params ops_health.GetReadinessParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().Str("handler", "getReadiness").Logger()
+
+	h := api.health(true)
+	if h.Status != healthOK {
+		scopedLog.Warn().Msg("Agent is not ready")
+		return ops_health.NewGetReadinessServiceUnavailable().WithPayload(h)
+	}
+	return ops_health.NewGetReadinessOK().WithPayload(h)
+}
+
+func (api *API) handleGetVersion(params ops_health.GetVersionParams) middleware.Responder {
+	capabilities := []string{
+		version.CapabilityCommandStream,
+		version.CapabilityAsyncCommands,
+		version.CapabilityChunkedUploads,
+		version.CapabilityBatchStat,
+		version.CapabilityEvents,
+		version.CapabilityHealth,
+		version.CapabilityZstd,
+		version.CapabilityScripts,
+	}
+	if api.audit != nil {
+		capabilities = append(capabilities, version.CapabilityAudit)
+	}
+	if api.grpcServer != nil {
+		capabilities = append(capabilities, version.CapabilityGRPC)
+	}
+	if api.backups != nil {
+		capabilities = append(capabilities, version.CapabilityBackups)
+	}
+
+	return ops_health.NewGetVersionOK().WithPayload(&models.AgentVersion{
+		Version:      version.Version,
+		Commit:       version.Commit,
+		BuildDate:    version.BuildDate,
+		APIVersion:   version.APIVersion,
+		Capabilities: capabilities,
+	})
+}
+
+// healthHandler serves the health report outside of the API, for probes of service
+// managers and load balancers
+func (api *API) healthHandler(checks bool) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		h := api.health(checks)
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-cache")
+		if h.Status != healthOK {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if r.Method != http.MethodHead {
+			_ = json.NewEncoder(rw).Encode(h)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiterIdle is how long the bucket of an idle client is kept
+const rateLimiterIdle = 10 * time.Minute
+
+// rateLimiter limits the requests per client with a token bucket each
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	clients   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		clients:   make(map[string]*tokenBucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// allow takes a token from the bucket of the client. If none is left it returns false
+// and the time until the next token is available.
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastPrune) > rateLimiterIdle {
+		l.prune(now)
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune removes the buckets of idle clients, l.mu must be held
+func (l *rateLimiter) prune(now time.Time) {
+	for client, b := range l.clients {
+		if now.Sub(b.last) > rateLimiterIdle {
+			delete(l.clients, client)
+		}
+	}
+	l.lastPrune = now
+}
+
+// mutationLimiter caps the number of concurrently executing mutations
+type mutationLimiter chan struct{}
+
+func newMutationLimiter(n int) mutationLimiter {
+	return make(mutationLimiter, n)
+}
+
+// acquire waits for a free slot, it fails only if ctx is done first. A nil limiter
+// doesn't limit.
+func (m mutationLimiter) acquire(ctx context.Context) error {
+	if m == nil {
+		return nil
+	}
+	select {
+	case m <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m mutationLimiter) release() {
+	if m == nil {
+		return
+	}
+	<-m
+}
+
+// limitHandler enforces the per-client rate limit and the mutation concurrency cap on
+// the requests passing through next, and rejects mutations while the agent is draining
+func (a *API) limitHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if a.rateLimiter != nil {
+			if ok, wait := a.rateLimiter.allow(clientID(r)); !ok {
+				seconds := int(math.Ceil(wait.Seconds()))
+				rw.Header().Set("Retry-After", strconv.Itoa(seconds))
+				writeJSONError(rw, http.StatusTooManyRequests, "Rate limit exceeded")
+				return
+			}
+		}
+
+		if isMutation(r) {
+			if !a.drain.enter() {
+				rw.Header().Set("Retry-After", strconv.Itoa(drainRetryAfter))
+				writeJSONError(rw, http.StatusServiceUnavailable, "Agent is shutting down")
+				return
+			}
+			defer a.drain.leave()
+
+			if err := a.mutations.acquire(r.Context()); err != nil {
+				writeJSONError(rw, http.StatusServiceUnavailable, "Request cancelled while waiting for a free slot")
+				return
+			}
+			defer a.mutations.release()
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func isMutation(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	// Reads with a request body
+	if r.URL.Path == apiBasePath+"/files:batchStat" {
+		return false
+	}
+	return true
+}
+
+// clientID identifies the client by the common name of its certificate, falling back
+// to its address without the port
+func clientID(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeJSONError(rw http.ResponseWriter, code int, msg string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	payload := newAPIError(code, WithMessage(msg))
+	payload.RequestID = rw.Header().Get(requestIDHeader)
+	_ = json.NewEncoder(rw).Encode(payload)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// errPathLocked is the cause of the conflicts reported for paths locked by another
+// request
+var errPathLocked = errors.New("path is locked")
+
+// pathLocks are advisory locks on the paths being mutated, so the ETag validated by a
+// request can't be changed by another one before it is done. A request conflicting
+// with a lock held by another one fails instead of waiting, as the state it expects is
+// likely to be changed anyway.
+type pathLocks struct {
+	mu sync.Mutex
+	// locks maps the locked paths to whether the tree below is locked as well
+	locks map[string]bool
+}
+
+func newPathLocks() *pathLocks {
+	return &pathLocks{locks: make(map[string]bool)}
+}
+
+// lock locks the path and returns the function releasing it. It fails if the path or
+// a tree containing it is locked already.
+func (l *pathLocks) lock(path string) (unlock func(), err error) {
+	return l.acquire(path, false)
+}
+
+// lockTree locks the path and everything below it and returns the function releasing
+// it. It fails if any of these paths or a tree containing the path is locked already.
+func (l *pathLocks) lockTree(path string) (unlock func(), err error) {
+	return l.acquire(path, true)
+}
+
+func (l *pathLocks) acquire(path string, tree bool) (func(), error) {
+	path = filepath.Clean(path)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for locked, lockedTree := range l.locks {
+		if locked == path || (lockedTree && isBelow(path, locked)) || (tree && isBelow(locked, path)) {
+			return nil, newOpError(http.StatusConflict, "Path is locked by another operation", errPathLocked)
+		}
+	}
+	l.locks[path] = tree
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.locks, path)
+	}, nil
+}
+
+// isBelow reports whether path is below the directory dir, both must be clean
+func isBelow(path, dir string) bool {
+	if dir == "/" {
+		return path != "/"
+	}
+	return strings.HasPrefix(path, dir+"/")
+}
@@ -2,14 +2,75 @@ package api
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"time"
 )
 
 type Option func(*Options)
 
 type Options struct {
+	// ListenAddr is the TCP address the REST API listens on, or the path of a Unix
+	// socket prefixed with unix:. Sockets passed by systemd take precedence.
 	ListenAddr      string
 	ServerTLSConfig *tls.Config
+	// CertReloader serves the certificate of the ServerTLSConfig and is checked for
+	// changes every CertReloadInterval while the API is running
+	CertReloader       *CertReloader
+	CertReloadInterval time.Duration
+	// GRPCListenAddr is the address the gRPC API listens on, it is disabled if empty
+	// unless systemd passes a socket named grpc. It uses the same TLS settings as the
+	// REST API.
+	GRPCListenAddr string
+
+	// ClientCAs verify client certificates, RequireClientCert rejects clients without a
+	// valid certificate (mutual TLS). Both require a ServerTLSConfig.
+	ClientCAs         *x509.CertPool
+	RequireClientCert bool
+
+	// AllowedPaths restricts the file, directory and content endpoints to paths below
+	// these absolute prefixes, empty allows all paths
+	AllowedPaths []string
+
+	// Policy restricts the commands and paths clients may use, nil allows everything
+	Policy *Policy
+
+	// SigningSecret is the shared secret REST requests have to be signed with, see the
+	// signing package. Requests are not signed if empty.
+	SigningSecret []byte
+
+	// AuditLog is the file mutating requests are appended to, AuditSyslog sends them
+	// to syslog as well. Auditing is disabled if neither is set.
+	AuditLog    string
+	AuditSyslog bool
+
+	// MaxUploadSize limits the size of archives uploaded at once, MaxChunkedUploadSize
+	// the size of archives uploaded in chunks. Both default if 0.
+	MaxUploadSize        int64
+	MaxChunkedUploadSize int64
+	// MaxUploadSessions limits the number of open upload sessions, MaxStagedUploadSize
+	// the total size of their archives. Both default if 0.
+	MaxUploadSessions   int
+	MaxStagedUploadSize int64
+	// UploadTempDir is where uploaded archives are spooled before extraction, the
+	// default directory for temporary files if empty
+	UploadTempDir string
+
+	// BackupDir is where backups taken on the agent are kept for BackupRetention,
+	// backups are disabled if empty
+	BackupDir       string
+	BackupRetention time.Duration
+
+	// RateLimit is the number of requests per second each client may send with bursts
+	// of RateBurst, 0 disables the limit
+	RateLimit float64
+	RateBurst int
+	// MaxConcurrentMutations caps the mutating requests and command jobs executing at
+	// the same time, further ones wait for a free slot. 0 disables the cap.
+	MaxConcurrentMutations int
+
+	// MaxCommandOutput is the number of bytes of stdout and stderr each kept of a
+	// command, clients may ask for less. It defaults if 0.
+	MaxCommandOutput int
 
 	// HTTP relevant options
 	GracefulTimeout time.Duration
@@ -24,12 +85,123 @@ func WithListenAddr(laddr string) Option {
 	}
 }
 
+func WithGRPCListenAddr(laddr string) Option {
+	return func(o *Options) {
+		o.GRPCListenAddr = laddr
+	}
+}
+
 func WithServerTLSConfig(cfg *tls.Config) Option {
 	return func(o *Options) {
 		o.ServerTLSConfig = cfg
 	}
 }
 
+// WithCertReloader serves TLS with the certificate of r, reloading it on changes
+func WithCertReloader(r *CertReloader, interval time.Duration) Option {
+	return func(o *Options) {
+		o.CertReloader = r
+		o.CertReloadInterval = interval
+		o.ServerTLSConfig = &tls.Config{
+			GetCertificate: r.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+	}
+}
+
+func WithClientCAs(pool *x509.CertPool) Option {
+	return func(o *Options) {
+		o.ClientCAs = pool
+	}
+}
+
+func WithRequireClientCert() Option {
+	return func(o *Options) {
+		o.RequireClientCert = true
+	}
+}
+
+func WithAllowedPaths(prefixes ...string) Option {
+	return func(o *Options) {
+		o.AllowedPaths = append(o.AllowedPaths, prefixes...)
+	}
+}
+
+func WithPolicy(p *Policy) Option {
+	return func(o *Options) {
+		o.Policy = p
+	}
+}
+
+func WithAuditLog(path string) Option {
+	return func(o *Options) {
+		o.AuditLog = path
+	}
+}
+
+func WithAuditSyslog() Option {
+	return func(o *Options) {
+		o.AuditSyslog = true
+	}
+}
+
+func WithBackupDir(dir string, retention time.Duration) Option {
+	return func(o *Options) {
+		o.BackupDir = dir
+		o.BackupRetention = retention
+	}
+}
+
+func WithMaxUploadSize(n int64) Option {
+	return func(o *Options) {
+		o.MaxUploadSize = n
+	}
+}
+
+func WithMaxChunkedUploadSize(n int64) Option {
+	return func(o *Options) {
+		o.MaxChunkedUploadSize = n
+	}
+}
+
+func WithMaxUploadSessions(n int, size int64) Option {
+	return func(o *Options) {
+		o.MaxUploadSessions = n
+		o.MaxStagedUploadSize = size
+	}
+}
+
+func WithUploadTempDir(dir string) Option {
+	return func(o *Options) {
+		o.UploadTempDir = dir
+	}
+}
+
+func WithRateLimit(rps float64, burst int) Option {
+	return func(o *Options) {
+		o.RateLimit = rps
+		o.RateBurst = burst
+	}
+}
+
+func WithMaxConcurrentMutations(n int) Option {
+	return func(o *Options) {
+		o.MaxConcurrentMutations = n
+	}
+}
+
+func WithSigningSecret(secret []byte) Option {
+	return func(o *Options) {
+		o.SigningSecret = secret
+	}
+}
+
+func WithMaxCommandOutput(n int) Option {
+	return func(o *Options) {
+		o.MaxCommandOutput = n
+	}
+}
+
 func WithGracefulTimeout(d time.Duration) Option {
 	return func(o *Options) {
 		o.GracefulTimeout = d
@@ -0,0 +1,307 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"peertech.de/axion/api/models"
+)
+
+var (
+	// Package names and versions are passed as arguments to the package manager, so
+	// restrict them to what the supported package managers accept and never allow a
+	// leading '-'
+	packageNameRegexp    = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9+._-]*$`)
+	packageVersionRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9+.:~_-]*$`)
+
+	errPackageNotInstalled = errors.New("package not installed")
+	errNoPackageManager    = errors.New("no supported package manager found")
+)
+
+// packageManager abstracts over the package manager of the host
+type packageManager interface {
+	// Name returns the name of the package manager
+	Name() string
+	// Query returns the installed version of the package, errPackageNotInstalled if
+	// it isn't installed
+	Query(ctx context.Context, name string) (string, error)
+	// Pinned reports whether the package is held at its installed version
+	Pinned(ctx context.Context, name string) (bool, error)
+	// Install installs the package, in the given version if not empty
+	Install(ctx context.Context, name, version string) error
+	// Remove removes the package
+	Remove(ctx context.Context, name string) error
+	// Pin holds or releases the package
+	Pin(ctx context.Context, name string, pinned bool) error
+}
+
+// detectPackageManager returns the package manager of the host, in order of
+// preference apt, dnf and zypper
+func detectPackageManager() (packageManager, error) {
+	switch {
+	case hasBinary("apt-get") && hasBinary("dpkg-query"):
+		return aptManager{}, nil
+	case hasBinary("dnf") && hasBinary("rpm"):
+		return dnfManager{}, nil
+	case hasBinary("zypper") && hasBinary("rpm"):
+		return zypperManager{}, nil
+	}
+	return nil, errNoPackageManager
+}
+
+func hasBinary(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// runHostCommand runs a host tool like a package manager and returns its stdout. A
+// failing command results in an OpError carrying the output as details.
+func runHostCommand(ctx context.Context, env []string, parts ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		oe := newOpError(http.StatusInternalServerError, fmt.Sprintf("%s failed", parts[0]), err)
+		oe.Details = partialOutput(stdout.String(), stderr.String())
+		return "", oe
+	}
+	return stdout.String(), nil
+}
+
+// exitCode returns the exit code of a failed command, -1 if it didn't exit
+func exitCode(err error) int {
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	var oe *OpError
+	if errors.As(err, &oe) && oe.Cause != nil {
+		return exitCode(oe.Cause)
+	}
+	return -1
+}
+
+// hostCommandError logs the error and converts it to an API error carrying the output
+// of the host command, if any
+func hostCommandError(scopedLog zerolog.Logger, err error, msg string) *models.Error {
+	scopedLog.Error().Err(err).Msg(msg)
+
+	var oe *OpError
+	if errors.As(err, &oe) {
+		return newAPIError(http.StatusInternalServerError, WithMessage(msg), WithDetails(oe.Details))
+	}
+	return newAPIError(http.StatusInternalServerError, WithMessage(msg))
+}
+
+// queryRPM returns the installed version of the package from the rpm database
+func queryRPM(ctx context.Context, name string) (string, error) {
+	out, err := runHostCommand(ctx, nil, "rpm", "-q", "--qf", "%{EPOCH}:%{VERSION}-%{RELEASE}\n", name)
+	if err != nil {
+		// rpm exits with 1 if the package isn't installed
+		if exitCode(err) == 1 {
+			return "", errPackageNotInstalled
+		}
+		return "", err
+	}
+
+	// Multiple versions may be installed (e.g. kernels), the last one is the newest
+	lines := strings.Fields(out)
+	if len(lines) == 0 {
+		return "", errPackageNotInstalled
+	}
+	return strings.TrimPrefix(lines[len(lines)-1], "(none):"), nil
+}
+
+type aptManager struct{}
+
+var aptEnv = []string{"DEBIAN_FRONTEND=noninteractive"}
+
+func (aptManager) Name() string {
+	return "apt"
+}
+
+func (aptManager) Query(ctx context.Context, name string) (string, error) {
+	out, err := runHostCommand(ctx, nil, "dpkg-query", "-W", "-f", "${Status}\t${Version}", name)
+	if err != nil {
+		// dpkg-query exits with 1 if the package is unknown
+		if exitCode(err) == 1 {
+			return "", errPackageNotInstalled
+		}
+		return "", err
+	}
+
+	// Removed packages keep their status entry until purged
+	status, version, _ := strings.Cut(out, "\t")
+	if !strings.HasSuffix(status, " installed") || version == "" {
+		return "", errPackageNotInstalled
+	}
+	return version, nil
+}
+
+func (aptManager) Pinned(ctx context.Context, name string) (bool, error) {
+	out, err := runHostCommand(ctx, nil, "apt-mark", "showhold", name)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) == name, nil
+}
+
+func (aptManager) Install(ctx context.Context, name, version string) error {
+	pkg := name
+	if version != "" {
+		pkg += "=" + version
+	}
+	_, err := runHostCommand(ctx, aptEnv, "apt-get", "install", "-y", "-q", "--allow-downgrades", "--allow-change-held-packages", pkg)
+	return err
+}
+
+func (aptManager) Remove(ctx context.Context, name string) error {
+	_, err := runHostCommand(ctx, aptEnv, "apt-get", "remove", "-y", "-q", name)
+	return err
+}
+
+func (aptManager) Pin(ctx context.Context, name string, pinned bool) error {
+	action := "unhold"
+	if pinned {
+		action = "hold"
+	}
+	_, err := runHostCommand(ctx, nil, "apt-mark", action, name)
+	return err
+}
+
+type dnfManager struct{}
+
+func (dnfManager) Name() string {
+	return "dnf"
+}
+
+func (dnfManager) Query(ctx context.Context, name string) (string, error) {
+	return queryRPM(ctx, name)
+}
+
+func (dnfManager) Pinned(ctx context.Context, name string) (bool, error) {
+	out, err := runHostCommand(ctx, nil, "dnf", "-q", "versionlock", "list", name)
+	if err != nil {
+		return false, err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		if nevraName(scanner.Text()) == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// nevraName returns the name of the package in a version lock, given as NEVRA
+// ([epoch:]name-[epoch:]version-release.arch), or an empty string if entry isn't one.
+// Names can contain dashes themselves, so the version and release are cut off from the
+// end.
+func nevraName(entry string) string {
+	entry = strings.TrimSpace(entry)
+	i := strings.LastIndex(entry, "-")
+	if i < 0 {
+		return ""
+	}
+	j := strings.LastIndex(entry[:i], "-")
+	if j < 0 {
+		return ""
+	}
+	name := entry[:j]
+	if _, rest, ok := strings.Cut(name, ":"); ok {
+		name = rest
+	}
+	return name
+}
+
+func (dnfManager) Install(ctx context.Context, name, version string) error {
+	pkg := name
+	if version != "" {
+		pkg += "-" + version
+	}
+	// Install doesn't downgrade, so a pinned older version needs an explicit downgrade
+	if _, err := runHostCommand(ctx, nil, "dnf", "install", "-y", "-q", pkg); err != nil {
+		if version == "" {
+			return err
+		}
+		_, err = runHostCommand(ctx, nil, "dnf", "downgrade", "-y", "-q", pkg)
+		return err
+	}
+	return nil
+}
+
+func (dnfManager) Remove(ctx context.Context, name string) error {
+	_, err := runHostCommand(ctx, nil, "dnf", "remove", "-y", "-q", name)
+	return err
+}
+
+func (dnfManager) Pin(ctx context.Context, name string, pinned bool) error {
+	action := "delete"
+	if pinned {
+		action = "add"
+	}
+	_, err := runHostCommand(ctx, nil, "dnf", "-q", "versionlock", action, name)
+	return err
+}
+
+type zypperManager struct{}
+
+func (zypperManager) Name() string {
+	return "zypper"
+}
+
+func (zypperManager) Query(ctx context.Context, name string) (string, error) {
+	return queryRPM(ctx, name)
+}
+
+func (zypperManager) Pinned(ctx context.Context, name string) (bool, error) {
+	out, err := runHostCommand(ctx, nil, "zypper", "--non-interactive", "--quiet", "locks")
+	if err != nil {
+		return false, err
+	}
+	// Locks are listed as table rows: # | Name | Type | Repository
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		columns := strings.Split(scanner.Text(), "|")
+		if len(columns) > 1 && strings.TrimSpace(columns[1]) == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (zypperManager) Install(ctx context.Context, name, version string) error {
+	pkg := name
+	if version != "" {
+		pkg += "=" + version
+	}
+	_, err := runHostCommand(ctx, nil, "zypper", "--non-interactive", "--quiet", "install", "--oldpackage", pkg)
+	return err
+}
+
+func (zypperManager) Remove(ctx context.Context, name string) error {
+	_, err := runHostCommand(ctx, nil, "zypper", "--non-interactive", "--quiet", "remove", name)
+	return err
+}
+
+func (zypperManager) Pin(ctx context.Context, name string, pinned bool) error {
+	action := "removelock"
+	if pinned {
+		action = "addlock"
+	}
+	_, err := runHostCommand(ctx, nil, "zypper", "--non-interactive", "--quiet", action, name)
+	return err
+}
@@ -0,0 +1,18 @@
+package api
+
+import "testing"
+
+func TestNevraName(t *testing.T) {
+	tests := map[string]string{
+		"nginx-1:1.20.1-14.el9.*":            "nginx",
+		"nginx-mod-stream-1.20.1-14.el9.*":   "nginx-mod-stream",
+		"0:python3-libs-3.9.18-1.el9.x86_64": "python3-libs",
+		"  bash-0:5.1.8-6.el9.*":             "bash",
+		"nginx":                              "",
+	}
+	for entry, expected := range tests {
+		if name := nevraName(entry); name != expected {
+			t.Errorf("%q: expected name %q, got %q", entry, expected, name)
+		}
+	}
+}
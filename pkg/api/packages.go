@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/rs/zerolog/log"
+
+	"peertech.de/axion/api/models"
+	ops_packages "peertech.de/axion/api/restapi/operations/packages"
+)
+
+func (api *API) handleGetPackage(params ops_packages.GetPackageParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleGetPackage").
+		Str("package", params.Name).
+		Logger()
+
+	if !packageNameRegexp.MatchString(params.Name) {
+		return ops_packages.NewGetPackageBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Invalid package name")))
+	}
+
+	mgr, err := detectPackageManager()
+	if err != nil {
+		return ops_packages.NewGetPackageNotImplemented().
+			WithPayload(newAPIError(http.StatusNotImplemented, WithMessage("No supported package manager found")))
+	}
+
+	api.packagesMu.Lock()
+	defer api.packagesMu.Unlock()
+
+	pkg, err := queryPackage(params.HTTPRequest.Context(), mgr, params.Name)
+	if err != nil {
+		if errors.Is(err, errPackageNotInstalled) {
+			return ops_packages.NewGetPackageNotFound().
+				WithPayload(newAPIError(http.StatusNotFound, WithMessage("Package not installed")))
+		}
+		return ops_packages.NewGetPackageInternalServerError().
+			WithPayload(hostCommandError(scopedLog, err, "Failed to query package"))
+	}
+
+	return ops_packages.NewGetPackageOK().WithPayload(pkg)
+}
+
+func (api *API) handlePutPackage(params ops_packages.PutPackageParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handlePutPackage").
+		Str("package", params.Name).
+		Logger()
+
+	if !packageNameRegexp.MatchString(params.Name) {
+		return ops_packages.NewPutPackageBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Invalid package name")))
+	}
+	if params.Package == nil {
+		return ops_packages.NewPutPackageBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Missing package request")))
+	}
+	version := params.Package.Version
+	if version != "" && !packageVersionRegexp.MatchString(version) {
+		return ops_packages.NewPutPackageBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Invalid package version")))
+	}
+
+	mgr, err := detectPackageManager()
+	if err != nil {
+		return ops_packages.NewPutPackageNotImplemented().
+			WithPayload(newAPIError(http.StatusNotImplemented, WithMessage("No supported package manager found")))
+	}
+
+	api.packagesMu.Lock()
+	defer api.packagesMu.Unlock()
+
+	ctx := params.HTTPRequest.Context()
+
+	current, err := queryPackage(ctx, mgr, params.Name)
+	if err != nil && !errors.Is(err, errPackageNotInstalled) {
+		return ops_packages.NewPutPackageInternalServerError().
+			WithPayload(hostCommandError(scopedLog, err, "Failed to query package"))
+	}
+	installed := err == nil
+
+	pinned := installed && current.Pinned
+	if !installed || (version != "" && !packageVersionMatches(current.Version, version)) {
+		// A pinned package can't change its version, release it for the install and
+		// pin it again afterwards unless asked otherwise
+		if pinned {
+			if err := mgr.Pin(ctx, params.Name, false); err != nil {
+				return ops_packages.NewPutPackageInternalServerError().
+					WithPayload(hostCommandError(scopedLog, err, "Failed to unpin package"))
+			}
+			pinned = false
+			if params.Package.Pinned == nil {
+				params.Package.Pinned = &current.Pinned
+			}
+		}
+
+		scopedLog.Info().Str("version", version).Msg("Installing package")
+		if err := mgr.Install(ctx, params.Name, version); err != nil {
+			return ops_packages.NewPutPackageInternalServerError().
+				WithPayload(hostCommandError(scopedLog, err, "Failed to install package"))
+		}
+	}
+
+	if params.Package.Pinned != nil && *params.Package.Pinned != pinned {
+		if err := mgr.Pin(ctx, params.Name, *params.Package.Pinned); err != nil {
+			return ops_packages.NewPutPackageInternalServerError().
+				WithPayload(hostCommandError(scopedLog, err, "Failed to pin package"))
+		}
+	}
+
+	pkg, err := queryPackage(ctx, mgr, params.Name)
+	if err != nil {
+		return ops_packages.NewPutPackageInternalServerError().
+			WithPayload(hostCommandError(scopedLog, err, "Failed to query package after install"))
+	}
+
+	api.events.publish(ctx, &models.AgentEvent{Type: eventPackageChanged, Name: params.Name})
+	return ops_packages.NewPutPackageOK().WithPayload(pkg)
+}
+
+func (api *API) handleDeletePackage(params ops_packages.DeletePackageParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleDeletePackage").
+		Str("package", params.Name).
+		Logger()
+
+	if !packageNameRegexp.MatchString(params.Name) {
+		return ops_packages.NewDeletePackageBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Invalid package name")))
+	}
+
+	mgr, err := detectPackageManager()
+	if err != nil {
+		return ops_packages.NewDeletePackageNotImplemented().
+			WithPayload(newAPIError(http.StatusNotImplemented, WithMessage("No supported package manager found")))
+	}
+
+	api.packagesMu.Lock()
+	defer api.packagesMu.Unlock()
+
+	ctx := params.HTTPRequest.Context()
+
+	current, err := queryPackage(ctx, mgr, params.Name)
+	if err != nil {
+		if errors.Is(err, errPackageNotInstalled) {
+			return ops_packages.NewDeletePackageNoContent()
+		}
+		return ops_packages.NewDeletePackageInternalServerError().
+			WithPayload(hostCommandError(scopedLog, err, "Failed to query package"))
+	}
+
+	if current.Pinned {
+		if err := mgr.Pin(ctx, params.Name, false); err != nil {
+			return ops_packages.NewDeletePackageInternalServerError().
+				WithPayload(hostCommandError(scopedLog, err, "Failed to unpin package"))
+		}
+	}
+
+	scopedLog.Info().Msg("Removing package")
+	if err := mgr.Remove(ctx, params.Name); err != nil {
+		return ops_packages.NewDeletePackageInternalServerError().
+			WithPayload(hostCommandError(scopedLog, err, "Failed to remove package"))
+	}
+
+	api.events.publish(ctx, &models.AgentEvent{Type: eventPackageRemoved, Name: params.Name})
+	return ops_packages.NewDeletePackageNoContent()
+}
+
+func queryPackage(ctx context.Context, mgr packageManager, name string) (*models.Package, error) {
+	version, err := mgr.Query(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	pinned, err := mgr.Pinned(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Package{
+		Name:    name,
+		Version: version,
+		Pinned:  pinned,
+		Manager: mgr.Name(),
+	}, nil
+}
+
+// packageVersionMatches reports whether the installed version satisfies the wanted
+// one, which may omit the package release (e.g. "1.2.3" matches "1.2.3-1ubuntu1")
+func packageVersionMatches(installed, wanted string) bool {
+	return installed == wanted || strings.HasPrefix(installed, wanted+"-")
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy restricts what the agent may do on behalf of its clients. Commands rules
+// match the command line, Paths rules the paths touched by the file endpoints.
+//
+// Rules are glob patterns where '*' matches any sequence of characters, e.g.
+// "/usr/bin/systemctl restart *" or "/etc/nginx/*".
+//
+// With Roles, clients are authorized by the role bound to them by Subjects in addition,
+// clients without role are rejected.
+type Policy struct {
+	Commands Rules `yaml:"commands"`
+	Paths    Rules `yaml:"paths"`
+
+	Roles    map[string]*Role `yaml:"roles"`
+	Subjects []Subject        `yaml:"subjects"`
+}
+
+// Rules allow or deny values by pattern. Deny rules take precedence, if allow rules
+// are present a value has to match at least one of them.
+type Rules struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// LoadPolicy reads the policy from the YAML file at path
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+	if err := p.compile(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (p *Policy) compile() error {
+	if err := p.Commands.compile(); err != nil {
+		return fmt.Errorf("invalid command rule: %w", err)
+	}
+	if err := p.Paths.compile(); err != nil {
+		return fmt.Errorf("invalid path rule: %w", err)
+	}
+	return p.compileRBAC()
+}
+
+// AllowsCommand checks the command given by parts against the command rules. The
+// command line is matched as given and with the binary resolved to its absolute
+// path, so rules can't be bypassed by calling a binary by its name.
+func (p *Policy) AllowsCommand(parts []string) error {
+	if p == nil {
+		return nil
+	}
+
+	candidates := []string{strings.Join(parts, " ")}
+	if resolved, err := exec.LookPath(parts[0]); err == nil {
+		if abs, err := filepath.Abs(resolved); err == nil && abs != parts[0] {
+			candidates = append(candidates, strings.Join(append([]string{abs}, parts[1:]...), " "))
+		}
+	}
+
+	if !p.Commands.allows(candidates...) {
+		return fmt.Errorf("command %q is not allowed by policy", candidates[0])
+	}
+	return nil
+}
+
+// AllowsPath checks the cleaned path and the path with its symlinks resolved, see
+// resolvePath, against the path rules. Both have to be allowed, so rules can't be
+// bypassed through a symlink into a denied location.
+func (p *Policy) AllowsPath(path string) error {
+	if p == nil {
+		return nil
+	}
+
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path %q: %w", path, err)
+	}
+	return p.allowsResolvedPath(path, resolved)
+}
+
+// allowsResolvedPath is AllowsPath for a path resolved by the caller, e.g. with the
+// symlink itself kept for paths of symlinks
+func (p *Policy) allowsResolvedPath(path, resolved string) error {
+	if p == nil {
+		return nil
+	}
+
+	path = filepath.Clean(path)
+	if !p.Paths.allows(path) {
+		return fmt.Errorf("path %q is not allowed by policy", path)
+	}
+	if resolved != path && !p.Paths.allows(resolved) {
+		return fmt.Errorf("path %q resolves to %q, which is not allowed by policy", path, resolved)
+	}
+	return nil
+}
+
+func (r *Rules) compile() error {
+	var err error
+	if r.allow, err = compilePatterns(r.Allow); err != nil {
+		return err
+	}
+	if r.deny, err = compilePatterns(r.Deny); err != nil {
+		return err
+	}
+	return nil
+}
+
+// allows reports whether none of the values is denied and, if allow rules are
+// present, any of them is allowed
+func (r *Rules) allows(values ...string) bool {
+	for _, v := range values {
+		if matchAny(r.deny, v) {
+			return false
+		}
+	}
+	if len(r.allow) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if matchAny(r.allow, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	out := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			return nil, fmt.Errorf("empty pattern")
+		}
+		quoted := strings.Split(pattern, "*")
+		for i := range quoted {
+			quoted[i] = regexp.QuoteMeta(quoted[i])
+		}
+		re, err := regexp.Compile("^" + strings.Join(quoted, ".*") + "$")
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+func matchAny(res []*regexp.Regexp, v string) bool {
+	for _, re := range res {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllowsPathSymlink(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range []string{"app", "secret"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// app/conf leads into the denied directory
+	if err := os.Symlink(filepath.Join(dir, "secret"), filepath.Join(dir, "app", "conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	policies := map[string]*Policy{
+		"allow": {Paths: Rules{Allow: []string{filepath.Join(dir, "app", "*")}}},
+		"deny":  {Paths: Rules{Deny: []string{filepath.Join(dir, "secret", "*")}}},
+	}
+	for name, p := range policies {
+		t.Run(name, func(t *testing.T) {
+			if err := p.compile(); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.AllowsPath(filepath.Join(dir, "app", "app.conf")); err != nil {
+				t.Errorf("expected path to be allowed, got %v", err)
+			}
+			if err := p.AllowsPath(filepath.Join(dir, "app", "conf", "passwd")); err == nil {
+				t.Errorf("expected path through the symlink to be denied")
+			}
+		})
+	}
+}
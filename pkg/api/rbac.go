@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/go-openapi/strfmt"
+	"github.com/rs/zerolog/log"
+
+	"peertech.de/axion/api/models"
+)
+
+// Role grants the operations (by operation ID, e.g. getFileProperties) and paths its
+// subjects may use. Both are rules like the ones of the policy, without allow rules
+// nothing is allowed.
+type Role struct {
+	Operations Rules `yaml:"operations"`
+	Paths      Rules `yaml:"paths"`
+}
+
+// Subject binds identities to a role. Clients are identified by the common name of
+// their certificate or by a bearer token, tokens are given by their hex encoded
+// SHA-256 digest.
+type Subject struct {
+	Name         string   `yaml:"name"`
+	Role         string   `yaml:"role"`
+	Certificates []string `yaml:"certificates"`
+	Tokens       []string `yaml:"tokens"`
+}
+
+// publicOperations are allowed without role, so clients can always check whether the
+// agent is compatible and ready
+var publicOperations = map[string]bool{
+	"getHealth":    true,
+	"getReadiness": true,
+	"getVersion":   true,
+}
+
+func (r *Role) compile() error {
+	if err := r.Operations.compile(); err != nil {
+		return fmt.Errorf("invalid operation rule: %w", err)
+	}
+	if err := r.Paths.compile(); err != nil {
+		return fmt.Errorf("invalid path rule: %w", err)
+	}
+	return nil
+}
+
+// allowsOperation reports whether the role grants the operation
+func (r *Role) allowsOperation(id string) bool {
+	return len(r.Operations.allow) > 0 && r.Operations.allows(id)
+}
+
+// allowsPath reports whether the role grants both the cleaned path and the path with
+// its symlinks resolved, see Policy.AllowsPath
+func (r *Role) allowsPath(path, resolved string) bool {
+	return len(r.Paths.allow) > 0 && r.Paths.allows(filepath.Clean(path)) && r.Paths.allows(resolved)
+}
+
+// compileRBAC validates the roles and subjects of the policy
+func (p *Policy) compileRBAC() error {
+	for name, role := range p.Roles {
+		if role == nil {
+			return fmt.Errorf("role %q is empty", name)
+		}
+		if err := role.compile(); err != nil {
+			return fmt.Errorf("role %q: %w", name, err)
+		}
+	}
+	for i, s := range p.Subjects {
+		if _, ok := p.Roles[s.Role]; !ok {
+			return fmt.Errorf("subject %d: unknown role %q", i, s.Role)
+		}
+		for _, token := range s.Tokens {
+			if b, err := hex.DecodeString(token); err != nil || len(b) != sha256.Size {
+				return fmt.Errorf("subject %d: token is not a SHA-256 digest", i)
+			}
+		}
+	}
+	return nil
+}
+
+// rbacEnabled reports whether the policy authorizes clients by their role
+func (p *Policy) rbacEnabled() bool {
+	return p != nil && len(p.Roles) > 0
+}
+
+// subjectOf returns the subject the request is authenticated as, nil if none matches
+func (p *Policy) subjectOf(r *http.Request) *Subject {
+	var digest []byte
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		sum := sha256.Sum256([]byte(token))
+		digest = sum[:]
+	}
+	var commonName string
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		commonName = r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	for i := range p.Subjects {
+		s := &p.Subjects[i]
+		if digest != nil {
+			for _, token := range s.Tokens {
+				expected, _ := hex.DecodeString(token)
+				if subtle.ConstantTimeCompare(digest, expected) == 1 {
+					return s
+				}
+			}
+		}
+		if commonName != "" {
+			for _, cn := range s.Certificates {
+				if cn == commonName {
+					return s
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type authorizationKey struct{}
+
+// authorization is the role a request is authorized by
+type authorization struct {
+	subject string
+	role    *Role
+	request *http.Request
+}
+
+func authorizationOf(ctx context.Context) *authorization {
+	authz, _ := ctx.Value(authorizationKey{}).(*authorization)
+	return authz
+}
+
+// rbacHandler authorizes the operations routed to next by the role of the client. It
+// is passed to the router, so the operation of the request is known.
+func (api *API) rbacHandler(next http.Handler) http.Handler {
+	policy := api.options.Policy
+	if !policy.rbacEnabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var operation string
+		if route := middleware.MatchedRouteFrom(r); route != nil && route.Operation != nil {
+			operation = route.Operation.ID
+		}
+		if publicOperations[operation] {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		subject := policy.subjectOf(r)
+		if subject == nil {
+			api.auditDenial(r, http.StatusUnauthorized, "", "unknown client")
+			writeJSONError(rw, http.StatusUnauthorized, "Unknown client")
+			return
+		}
+
+		role := policy.Roles[subject.Role]
+		if !role.allowsOperation(operation) {
+			api.auditDenial(r, http.StatusForbidden, subject.Name, fmt.Sprintf("operation %s not allowed for role %s", operation, subject.Role))
+			writeJSONError(rw, http.StatusForbidden, "Operation not allowed")
+			return
+		}
+
+		authz := &authorization{subject: subject.Name, role: role, request: r}
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), authorizationKey{}, authz)))
+	})
+}
+
+// checkRolePath reports an error if the role of the request in ctx doesn't grant the
+// path, resolved to resolved. Requests without role, e.g. if authorization is disabled,
+// may use all paths.
+func (api *API) checkRolePath(ctx context.Context, path, resolved string) error {
+	authz := authorizationOf(ctx)
+	if authz == nil || authz.role.allowsPath(path, resolved) {
+		return nil
+	}
+
+	err := fmt.Errorf("path %q is not allowed for the role of %s", filepath.Clean(path), authz.subject)
+	api.auditDenial(authz.request, http.StatusForbidden, authz.subject, err.Error())
+	return err
+}
+
+// auditDenial records that the request was denied with status. Mutations are recorded
+// by the audit handler with the reason added, other requests get an entry of their own.
+func (api *API) auditDenial(r *http.Request, status int, subject, reason string) {
+	log.Ctx(r.Context()).Warn().
+		Str("subject", subject).
+		Str("reason", reason).
+		Msg("Request denied")
+
+	if api.audit == nil {
+		return
+	}
+	if isMutation(r) {
+		auditDetail(r.Context(), "denied", reason)
+		return
+	}
+
+	details := map[string]string{"denied": reason}
+	if subject != "" {
+		details["subject"] = subject
+	}
+	api.audit.record(&models.AuditEntry{
+		Time:      strfmt.DateTime(time.Now().UTC()),
+		RequestID: requestIDOf(r.Context()),
+		Client:    clientID(r),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Status:    int64(status),
+		Details:   details,
+	})
+}
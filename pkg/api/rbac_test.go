@@ -0,0 +1,40 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRoleAllowsPathSymlink(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range []string{"app", "secret"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret"), filepath.Join(dir, "app", "conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	role := &Role{Paths: Rules{Allow: []string{filepath.Join(dir, "app", "*")}}}
+	if err := role.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]bool{
+		filepath.Join(dir, "app", "app.conf"):       true,
+		filepath.Join(dir, "app", "conf", "passwd"): false,
+	} {
+		resolved, err := resolvePath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed := role.allowsPath(path, resolved); allowed != expected {
+			t.Errorf("%s: expected allowed %v, got %v", path, expected, allowed)
+		}
+	}
+}
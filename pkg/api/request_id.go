@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/go-openapi/runtime"
+	"github.com/rs/zerolog/log"
+
+	"peertech.de/axion/api/models"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+
+	// maxRequestIDLength bounds the request IDs accepted from clients
+	maxRequestIDLength = 128
+)
+
+type requestIDKey struct{}
+
+// requestIDHandler identifies every request passing through next by the request ID
+// sent by the client, or a generated one if there is none or it is invalid. The ID is
+// returned in the response header, added to the log entries of the request and to its
+// error payloads.
+func requestIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID, _ = newJobId()
+			r.Header.Set(requestIDHeader, requestID)
+		}
+		rw.Header().Set(requestIDHeader, requestID)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+		ctx = log.With().Str("request_id", requestID).Logger().WithContext(ctx)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+	})
+}
+
+// requestIDOf returns the request ID of ctx, empty outside of requests
+func requestIDOf(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// validRequestID reports whether the request ID of a client is safe to be logged
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDProducer adds the request ID returned in the response header to the error
+// payloads written by producer
+func requestIDProducer(producer runtime.Producer) runtime.Producer {
+	return runtime.ProducerFunc(func(w io.Writer, data any) error {
+		if e, ok := data.(*models.Error); ok && e != nil && e.RequestID == "" {
+			if rw, ok := w.(http.ResponseWriter); ok {
+				e.RequestID = rw.Header().Get(requestIDHeader)
+			}
+		}
+		return producer.Produce(w, data)
+	})
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkPath reports an error if path lies outside the allowed path prefixes or is
+// denied by the policy or the role of the request in ctx
+func (api *API) checkPath(ctx context.Context, path string) error {
+	return api.checkResolvedPath(ctx, path, resolvePath)
+}
+
+// checkLinkPath is checkPath for paths of symlinks, the link itself is checked rather
+// than its target
+func (api *API) checkLinkPath(ctx context.Context, path string) error {
+	return api.checkResolvedPath(ctx, path, func(path string) (string, error) {
+		parent, err := resolvePath(filepath.Dir(path))
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(parent, filepath.Base(path)), nil
+	})
+}
+
+func (api *API) checkResolvedPath(ctx context.Context, path string, resolve func(string) (string, error)) error {
+	if len(api.options.AllowedPaths) == 0 && api.options.Policy == nil {
+		return nil
+	}
+
+	resolved, err := resolve(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path %q: %w", path, err)
+	}
+	if len(api.options.AllowedPaths) > 0 && !withinPrefixes(resolved, api.allowedPaths) {
+		return fmt.Errorf("path %q is outside of the allowed paths", path)
+	}
+	if err := api.options.Policy.allowsResolvedPath(path, resolved); err != nil {
+		return err
+	}
+	return api.checkRolePath(ctx, path, resolved)
+}
+
+// resolvePath returns the absolute path with symlinks of its existing ancestors
+// resolved, so a symlink can't be used to escape an allowed prefix
+func resolvePath(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	// Resolve the longest existing part of the path, the rest doesn't exist yet and
+	// can't contain symlinks
+	existing, rest := path, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return path, nil
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+}
+
+// withinPrefixes reports whether path equals or lies below any of the prefixes
+func withinPrefixes(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if path == prefix || prefix == string(os.PathSeparator) ||
+			strings.HasPrefix(path, prefix+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/go-openapi/runtime"
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/google/shlex"
+	"github.com/rs/zerolog/log"
+
+	"peertech.de/axion/api/models"
+	ops_command "peertech.de/axion/api/restapi/operations/command"
+)
+
+// defaultInterpreter executes scripts which don't name an interpreter
+const defaultInterpreter = "/bin/sh"
+
+func (api *API) handleScript(params ops_command.ExecuteScriptParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleScript").
+		Logger()
+
+	r, parts, cleanup, err := api.prepareScript(params.HTTPRequest, params.Script)
+	if err != nil {
+		var oe *OpError
+		if !errors.As(err, &oe) {
+			oe = newOpError(http.StatusInternalServerError, "Failed to prepare script", err)
+		}
+		switch oe.Code {
+		case http.StatusBadRequest:
+			return ops_command.NewExecuteScriptBadRequest().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		case http.StatusForbidden:
+			scopedLog.Warn().Err(oe.Cause).Msg(oe.Msg)
+			return ops_command.NewExecuteScriptForbidden().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		default:
+			scopedLog.Error().Err(oe.Cause).Msg(oe.Msg)
+			return ops_command.NewExecuteScriptInternalServerError().
+				WithPayload(newAPIError(http.StatusInternalServerError, WithMessage(oe.Msg)))
+		}
+	}
+	defer cleanup()
+
+	result, err := api.captureCommand(params.HTTPRequest.Context(), scopedLog, r, parts)
+	if err != nil {
+		var oe *OpError
+		if !errors.As(err, &oe) {
+			oe = newOpError(http.StatusInternalServerError, "Script execution failed", err)
+		}
+		if oe.Code == http.StatusRequestTimeout {
+			return ops_command.NewExecuteScriptRequestTimeout().
+				WithPayload(newAPIError(oe.Code, WithMessage("Script execution timed out"), WithDetails(oe.Details)))
+		}
+		scopedLog.Error().Err(oe.Cause).Msg(oe.Msg)
+		return ops_command.NewExecuteScriptInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Script execution failed")))
+	}
+
+	result.Success = isExpectedExitCode(r, result.ExitCode)
+	return ops_command.NewExecuteScriptOK().WithPayload(result)
+}
+
+func (api *API) handleScriptStream(params ops_command.ExecuteScriptStreamParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleScriptStream").
+		Logger()
+
+	// Reject invalid scripts before the stream is started
+	r, parts, cleanup, err := api.prepareScript(params.HTTPRequest, params.Script)
+	if err != nil {
+		var oe *OpError
+		if !errors.As(err, &oe) {
+			oe = newOpError(http.StatusInternalServerError, "Failed to prepare script", err)
+		}
+		switch oe.Code {
+		case http.StatusBadRequest:
+			return ops_command.NewExecuteScriptStreamBadRequest().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		case http.StatusForbidden:
+			scopedLog.Warn().Err(oe.Cause).Msg(oe.Msg)
+			return ops_command.NewExecuteScriptStreamForbidden().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		default:
+			scopedLog.Error().Err(oe.Cause).Msg(oe.Msg)
+			return ops_command.NewExecuteScriptStreamInternalServerError().
+				WithPayload(newAPIError(http.StatusInternalServerError, WithMessage(oe.Msg)))
+		}
+	}
+
+	stream := api.streamCommand(params.HTTPRequest.Context(), scopedLog, r, parts)
+	return middleware.ResponderFunc(func(rw http.ResponseWriter, producer runtime.Producer) {
+		defer cleanup()
+		stream.WriteResponse(rw, producer)
+	})
+}
+
+// prepareScript writes the script to a temporary file only the agent can read and
+// returns the command request and command line executing it with its interpreter. The
+// file is removed by cleanup once the script has finished.
+func (api *API) prepareScript(req *http.Request, s *models.ScriptRequest) (r *models.CommandRequest, parts []string, cleanup func(), err error) {
+	if s == nil || s.Script == "" {
+		return nil, nil, nil, newOpError(http.StatusBadRequest, "Script cannot be empty", nil)
+	}
+
+	interpreter := s.Interpreter
+	if interpreter == "" {
+		interpreter = defaultInterpreter
+	}
+	parts, err = shlex.Split(interpreter)
+	if err != nil || len(parts) == 0 {
+		return nil, nil, nil, newOpError(http.StatusBadRequest, "Invalid interpreter syntax", err)
+	}
+
+	// The command as shown in the audit log and events, the path of the file is random
+	command := strings.Join(slices.Concat(parts, []string{"<script>"}, s.Args), " ")
+	digest := sha256.Sum256([]byte(s.Script))
+	auditDetail(req.Context(), "command", command)
+	auditDetail(req.Context(), "script_sha256", hex.EncodeToString(digest[:]))
+
+	f, err := os.CreateTemp("", "axion-script-*")
+	if err != nil {
+		return nil, nil, nil, newOpError(http.StatusInternalServerError, "Failed to create script file", err)
+	}
+	cleanup = func() {
+		if err := os.Remove(f.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Str("path", f.Name()).Msg("Failed to remove script file")
+		}
+	}
+	_, err = f.WriteString(s.Script)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, nil, newOpError(http.StatusInternalServerError, "Failed to write script file", err)
+	}
+
+	parts = slices.Concat(parts, []string{f.Name()}, s.Args)
+	if err := api.options.Policy.AllowsCommand(parts); err != nil {
+		cleanup()
+		return nil, nil, nil, newOpError(http.StatusForbidden, "Interpreter not allowed", err)
+	}
+
+	r = &models.CommandRequest{
+		Command:           command,
+		ExpectedExitCodes: s.ExpectedExitCodes,
+		Timeout:           s.Timeout,
+		MaxOutput:         s.MaxOutput,
+		OutputRetention:   s.OutputRetention,
+	}
+	return r, parts, cleanup, nil
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/rs/zerolog/log"
+
+	"peertech.de/axion/api/models"
+	ops_services "peertech.de/axion/api/restapi/operations/services"
+)
+
+var (
+	// Unit names are passed as arguments to systemctl, never allow a leading '-'
+	serviceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9:_.@\\][a-zA-Z0-9:_.@\\-]*$`)
+
+	errServiceNotFound = errors.New("service not found")
+	errNoSystemd       = errors.New("systemd is not available")
+)
+
+// serviceProperties are the unit properties queried via systemctl show
+var serviceProperties = []string{"Id", "LoadState", "ActiveState", "SubState", "UnitFileState", "MainPID"}
+
+func (api *API) handleGetService(params ops_services.GetServiceParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleGetService").
+		Str("service", params.Name).
+		Logger()
+
+	if !serviceNameRegexp.MatchString(params.Name) {
+		return ops_services.NewGetServiceBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Invalid service name")))
+	}
+
+	status, err := queryService(params.HTTPRequest.Context(), params.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNoSystemd):
+			return ops_services.NewGetServiceNotImplemented().
+				WithPayload(newAPIError(http.StatusNotImplemented, WithMessage("systemd is not available")))
+		case errors.Is(err, errServiceNotFound):
+			return ops_services.NewGetServiceNotFound().
+				WithPayload(newAPIError(http.StatusNotFound, WithMessage("Service not found")))
+		}
+		return ops_services.NewGetServiceInternalServerError().
+			WithPayload(hostCommandError(scopedLog, err, "Failed to query service"))
+	}
+
+	return ops_services.NewGetServiceOK().WithPayload(status)
+}
+
+func (api *API) handleServiceAction(params ops_services.ServiceActionParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleServiceAction").
+		Str("service", params.Name).
+		Str("action", params.Action).
+		Logger()
+
+	if !serviceNameRegexp.MatchString(params.Name) {
+		return ops_services.NewServiceActionBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Invalid service name")))
+	}
+	switch params.Action {
+	case "start", "stop", "restart", "reload", "enable", "disable":
+	default:
+		return ops_services.NewServiceActionBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Invalid service action")))
+	}
+
+	ctx := params.HTTPRequest.Context()
+
+	// Query first, systemctl reports unknown units inconsistently across actions
+	if _, err := queryService(ctx, params.Name); err != nil {
+		switch {
+		case errors.Is(err, errNoSystemd):
+			return ops_services.NewServiceActionNotImplemented().
+				WithPayload(newAPIError(http.StatusNotImplemented, WithMessage("systemd is not available")))
+		case errors.Is(err, errServiceNotFound):
+			return ops_services.NewServiceActionNotFound().
+				WithPayload(newAPIError(http.StatusNotFound, WithMessage("Service not found")))
+		}
+		return ops_services.NewServiceActionInternalServerError().
+			WithPayload(hostCommandError(scopedLog, err, "Failed to query service"))
+	}
+
+	scopedLog.Info().Msg("Performing service action")
+	if _, err := runHostCommand(ctx, nil, "systemctl", params.Action, "--", params.Name); err != nil {
+		return ops_services.NewServiceActionInternalServerError().
+			WithPayload(hostCommandError(scopedLog, err, "Failed to "+params.Action+" service"))
+	}
+
+	api.events.publish(ctx, &models.AgentEvent{Type: eventServiceChanged, Name: params.Name, Action: params.Action})
+
+	status, err := queryService(ctx, params.Name)
+	if err != nil {
+		return ops_services.NewServiceActionInternalServerError().
+			WithPayload(hostCommandError(scopedLog, err, "Failed to query service after "+params.Action))
+	}
+
+	return ops_services.NewServiceActionOK().WithPayload(status)
+}
+
+// queryService returns the state of the unit, errServiceNotFound if systemd doesn't
+// know it
+func queryService(ctx context.Context, name string) (*models.ServiceStatus, error) {
+	if !systemdAvailable() {
+		return nil, errNoSystemd
+	}
+
+	args := []string{"systemctl", "show", "--no-pager"}
+	for _, p := range serviceProperties {
+		args = append(args, "--property="+p)
+	}
+	out, err := runHostCommand(ctx, nil, append(args, "--", name)...)
+	if err != nil {
+		return nil, err
+	}
+
+	props := make(map[string]string, len(serviceProperties))
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		if k, v, ok := strings.Cut(scanner.Text(), "="); ok {
+			props[k] = v
+		}
+	}
+
+	// systemctl show succeeds for unknown units, only the load state tells
+	if props["LoadState"] == "not-found" || props["LoadState"] == "" {
+		return nil, errServiceNotFound
+	}
+
+	mainPid, _ := strconv.ParseInt(props["MainPID"], 10, 64)
+	activeState := props["ActiveState"]
+	unitFileState := props["UnitFileState"]
+
+	return &models.ServiceStatus{
+		Name:          props["Id"],
+		LoadState:     props["LoadState"],
+		ActiveState:   activeState,
+		SubState:      props["SubState"],
+		UnitFileState: unitFileState,
+		Active:        activeState == "active" || activeState == "activating" || activeState == "reloading",
+		Enabled:       unitFileState == "enabled" || unitFileState == "enabled-runtime",
+		MainPid:       mainPid,
+	}, nil
+}
+
+// systemdAvailable reports whether the host was booted with systemd, as sd_booted(3)
+// does
+func systemdAvailable() bool {
+	if !hasBinary("systemctl") {
+		return false
+	}
+	fi, err := os.Lstat("/run/systemd/system")
+	return err == nil && fi.IsDir()
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"peertech.de/axion/pkg/signing"
+)
+
+const (
+	// signatureMaxSkew is how far the date of a signed request may deviate from the
+	// clock of the agent
+	signatureMaxSkew = 5 * time.Minute
+
+	// maxSignedBodySize limits the bodies buffered to verify their digest, streamed
+	// bodies are sent unsigned
+	maxSignedBodySize = 32 << 20
+)
+
+// nonceCache remembers the nonces of signed requests until their date is outside the
+// allowed skew, so a captured request can't be replayed
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+// add records the nonce of a request dated at date, it fails if the nonce was seen
+func (c *nonceCache) add(nonce string, date, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for n, d := range c.seen {
+		if now.Sub(d) > signatureMaxSkew {
+			delete(c.seen, n)
+		}
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	c.seen[nonce] = date
+	return true
+}
+
+// signatureHandler rejects requests to next which aren't signed with the signing secret
+// or replay an earlier request. gRPC calls are authenticated by client certificates.
+func (a *API) signatureHandler(next http.Handler) http.Handler {
+	secret := a.options.SigningSecret
+	if len(secret) == 0 {
+		return next
+	}
+
+	nonces := newNonceCache()
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Proto == "gRPC" {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		if err := verifySignature(r, secret, nonces, time.Now()); err != nil {
+			a.auditDenial(r, http.StatusUnauthorized, "", err.Error())
+			writeJSONError(rw, http.StatusUnauthorized, "Invalid request signature")
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// verifySignature checks the signature headers and the body of r, the body is replaced
+// by the verified copy
+func verifySignature(r *http.Request, secret []byte, nonces *nonceCache, now time.Time) error {
+	date := r.Header.Get(signing.DateHeader)
+	nonce := r.Header.Get(signing.NonceHeader)
+	contentHash := r.Header.Get(signing.ContentHeader)
+	signature := r.Header.Get(signing.SignatureHeader)
+	if date == "" || nonce == "" || contentHash == "" || signature == "" {
+		return errors.New("request is not signed")
+	}
+
+	secs, err := strconv.ParseInt(date, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid signature date %q", date)
+	}
+	signed := time.Unix(secs, 0)
+	if skew := now.Sub(signed); skew > signatureMaxSkew || skew < -signatureMaxSkew {
+		return fmt.Errorf("signature date %s is outside of the allowed skew", signed.UTC().Format(time.RFC3339))
+	}
+
+	if !signing.Verify(secret, signature, r.Method, r.URL.RequestURI(), date, nonce, contentHash) {
+		return errors.New("signature mismatch")
+	}
+
+	if contentHash != signing.UnsignedPayload {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+		if err != nil {
+			return fmt.Errorf("failed to read body: %w", err)
+		}
+		if len(body) > maxSignedBodySize {
+			return errors.New("signed body is too large")
+		}
+		if signing.ContentHash(body) != contentHash {
+			return errors.New("body digest mismatch")
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if !nonces.add(nonce, signed, now) {
+		log.Ctx(r.Context()).Warn().Str("nonce", nonce).Msg("Replayed request")
+		return errors.New("nonce was used before")
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+
+	"peertech.de/axion/api/models"
+	ops_symlinks "peertech.de/axion/api/restapi/operations/symlinks"
+	"peertech.de/axion/pkg/tracing"
+)
+
+func (api *API) handleGetSymlinkProperties(params ops_symlinks.GetSymlinkPropertiesParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleGetSymlinkProperties").
+		Str("path", params.Path).
+		Logger()
+
+	if params.Path == "" {
+		return middleware.Error(http.StatusBadRequest, "Symlink path cannot be empty")
+	}
+	if err := api.checkLinkPath(params.HTTPRequest.Context(), params.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_symlinks.NewGetSymlinkPropertiesForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
+
+	fi, err := os.Lstat(params.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ops_symlinks.NewGetSymlinkPropertiesNotFound().WithPayload(newAPIError(http.StatusNotFound))
+		}
+
+		scopedLog.Error().Err(err).Msg("Failed to stat symlink")
+		return ops_symlinks.NewGetSymlinkPropertiesInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to stat symlink")))
+	}
+
+	if !isSymlink(fi) {
+		return ops_symlinks.NewGetSymlinkPropertiesBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Path is not a symlink")))
+	}
+
+	target, err := os.Readlink(params.Path)
+	if err != nil {
+		scopedLog.Error().Err(err).Msg("Failed to read symlink")
+		return ops_symlinks.NewGetSymlinkPropertiesInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to read symlink")))
+	}
+
+	etag := generateSymlinkETag(fi, target)
+	return ops_symlinks.NewGetSymlinkPropertiesOK().WithETag(etag).WithPayload(&models.SymlinkProperties{Target: target})
+}
+
+func (api *API) handlePutSymlink(params ops_symlinks.PutSymlinkParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handlePutSymlink").
+		Str("path", params.Path).
+		Logger()
+
+	if params.Path == "" {
+		return ops_symlinks.NewPutSymlinkBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Symlink path cannot be empty")))
+	}
+	if err := api.checkLinkPath(params.HTTPRequest.Context(), params.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_symlinks.NewPutSymlinkForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
+	if params.Properties == nil || params.Properties.Target == "" {
+		return ops_symlinks.NewPutSymlinkBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Symlink target cannot be empty")))
+	}
+
+	target := params.Properties.Target
+	force := params.Force != nil && *params.Force
+
+	unlock, err := api.paths.lock(params.Path)
+	if err != nil {
+		return ops_symlinks.NewPutSymlinkConflict().
+			WithPayload(newAPIError(http.StatusConflict, WithMessage("Path is locked by another operation")))
+	}
+	defer unlock()
+
+	fi, err := os.Lstat(params.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		scopedLog.Error().Err(err).Msg("Failed to stat symlink")
+		return ops_symlinks.NewPutSymlinkInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to stat symlink")))
+	}
+	exists := err == nil
+
+	ifMatch := params.HTTPRequest.Header.Get("If-Match")
+	switch {
+	case exists && !isSymlink(fi):
+		// Anything but a symlink has no symlink ETag, replacing it requires force
+		if !force {
+			return ops_symlinks.NewPutSymlinkConflict().
+				WithPayload(newAPIError(http.StatusConflict, WithMessage("Path exists and is not a symlink, use force=true to replace it")))
+		}
+		if err := os.Remove(params.Path); err != nil {
+			if errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST) {
+				return ops_symlinks.NewPutSymlinkConflict().
+					WithPayload(newAPIError(http.StatusConflict, WithMessage("Path is a non-empty directory")))
+			}
+			scopedLog.Error().Err(err).Msg("Failed to remove existing path")
+			return ops_symlinks.NewPutSymlinkInternalServerError().
+				WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to remove existing path")))
+		}
+		exists = false
+	case ifMatch != "":
+		if !exists {
+			return ops_symlinks.NewPutSymlinkPreconditionFailed().
+				WithPayload(newAPIError(http.StatusPreconditionFailed, WithMessage("Symlink does not exist for conditional update")))
+		}
+
+		current, err := os.Readlink(params.Path)
+		if err != nil {
+			scopedLog.Error().Err(err).Msg("Failed to read symlink")
+			return ops_symlinks.NewPutSymlinkInternalServerError().
+				WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to read symlink")))
+		}
+		if ifMatch != generateSymlinkETag(fi, current) {
+			return ops_symlinks.NewPutSymlinkConflict().
+				WithPayload(newAPIError(http.StatusConflict, WithMessage("ETag mismatch")))
+		}
+		if current == target {
+			return ops_symlinks.NewPutSymlinkNoContent().WithETag(ifMatch)
+		}
+	case exists:
+		// Symlink exists but no If-Match header sent
+		return ops_symlinks.NewPutSymlinkPreconditionRequired().
+			WithPayload(newAPIError(http.StatusPreconditionRequired, WithMessage("Missing If-Match header")))
+	}
+
+	_, span := tracing.Start(params.HTTPRequest.Context(), "fs.symlink", attribute.String("axion.path", params.Path))
+	err = replaceSymlink(target, params.Path)
+	tracing.End(span, err)
+	if err != nil {
+		scopedLog.Error().Err(err).Msg("Failed to create symlink")
+		return ops_symlinks.NewPutSymlinkInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to create symlink")))
+	}
+
+	fi, err = os.Lstat(params.Path)
+	if err != nil {
+		scopedLog.Error().Err(err).Msg("Failed to stat symlink after creation")
+		return ops_symlinks.NewPutSymlinkInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to stat symlink after creation")))
+	}
+
+	api.events.publish(params.HTTPRequest.Context(), &models.AgentEvent{Type: eventSymlinkChanged, Path: params.Path})
+
+	etag := generateSymlinkETag(fi, target)
+	if !exists {
+		return ops_symlinks.NewPutSymlinkCreated().WithETag(etag)
+	}
+	return ops_symlinks.NewPutSymlinkNoContent().WithETag(etag)
+}
+
+func (api *API) handleDeleteSymlink(params ops_symlinks.DeleteSymlinkParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleDeleteSymlink").
+		Str("path", params.Path).
+		Logger()
+
+	if params.Path == "" {
+		return ops_symlinks.NewDeleteSymlinkBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Symlink path cannot be empty")))
+	}
+	if err := api.checkLinkPath(params.HTTPRequest.Context(), params.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_symlinks.NewDeleteSymlinkForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
+
+	unlock, err := api.paths.lock(params.Path)
+	if err != nil {
+		return ops_symlinks.NewDeleteSymlinkConflict().
+			WithPayload(newAPIError(http.StatusConflict, WithMessage("Path is locked by another operation")))
+	}
+	defer unlock()
+
+	fi, err := os.Lstat(params.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ops_symlinks.NewDeleteSymlinkNoContent()
+		}
+
+		scopedLog.Error().Err(err).Msg("Failed to stat symlink")
+		return ops_symlinks.NewDeleteSymlinkInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to stat symlink")))
+	}
+
+	if !isSymlink(fi) {
+		return ops_symlinks.NewDeleteSymlinkBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Path is not a symlink")))
+	}
+
+	ifMatch := params.HTTPRequest.Header.Get("If-Match")
+	if ifMatch == "" {
+		return ops_symlinks.NewDeleteSymlinkPreconditionRequired().
+			WithPayload(newAPIError(http.StatusPreconditionRequired, WithMessage("Missing If-Match header")))
+	}
+
+	target, err := os.Readlink(params.Path)
+	if err != nil {
+		scopedLog.Error().Err(err).Msg("Failed to read symlink")
+		return ops_symlinks.NewDeleteSymlinkInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to read symlink")))
+	}
+	if ifMatch != generateSymlinkETag(fi, target) {
+		return ops_symlinks.NewDeleteSymlinkConflict().
+			WithPayload(newAPIError(http.StatusConflict, WithMessage("ETag mismatch")))
+	}
+
+	_, span := tracing.Start(params.HTTPRequest.Context(), "fs.remove", attribute.String("axion.path", params.Path))
+	err = os.Remove(params.Path)
+	tracing.End(span, err)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		scopedLog.Error().Err(err).Msg("Failed to delete symlink")
+		return ops_symlinks.NewDeleteSymlinkInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to delete symlink")))
+	}
+
+	api.events.publish(params.HTTPRequest.Context(), &models.AgentEvent{Type: eventSymlinkDeleted, Path: params.Path})
+	return ops_symlinks.NewDeleteSymlinkNoContent()
+}
+
+// replaceSymlink atomically points the symlink at path to target by renaming a new
+// symlink over it
+func replaceSymlink(target, path string) error {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"."+hex.EncodeToString(b))
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func isSymlink(fi os.FileInfo) bool {
+	return fi.Mode()&os.ModeSymlink != 0
+}
+
+func generateSymlinkETag(fi os.FileInfo, target string) string {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+
+	data := fmt.Sprintf("%s:%d:%d:%s",
+		target,
+		stat.Uid,
+		stat.Gid,
+		fi.ModTime().UTC().Format(time.RFC3339Nano),
+	)
+	sum := sha256.Sum256([]byte(data))
+	return `W/"` + hex.EncodeToString(sum[:]) + `"`
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd socket activation
+const listenFdsStart = 3
+
+// grpcSocketName is the FileDescriptorName= of the activated socket serving the gRPC
+// API, all other sockets serve the REST API
+const grpcSocketName = "grpc"
+
+// unixSocketMode is the mode of the Unix sockets the agent creates, restricting access
+// to the user and group of the agent
+const unixSocketMode = 0660
+
+// activationListeners returns the listeners passed by systemd socket activation by
+// their name, nil if the agent wasn't socket activated
+func activationListeners() (map[string]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// Commands executed by the agent must not take the sockets for theirs
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make(map[string]net.Listener, n)
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+
+		name := "http"
+		if i < len(names) && names[i] == grpcSocketName {
+			name = grpcSocketName
+		}
+		if _, ok := listeners[name]; ok {
+			return nil, fmt.Errorf("more than one %s socket passed by systemd", name)
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s socket passed by systemd: %w", name, err)
+		}
+		listeners[name] = ln
+	}
+	return listeners, nil
+}
+
+// listen binds addr, a TCP address or the path of a Unix socket prefixed with unix:
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	// A socket left behind by an agent which didn't stop cleanly fails the bind
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, unixSocketMode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// sdNotify sends state to the service manager if the agent runs as a systemd service
+// of Type=notify, and does nothing otherwise
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -2,104 +2,291 @@ package api
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/go-openapi/runtime/middleware"
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 
+	"peertech.de/axion/api/models"
 	ops_content "peertech.de/axion/api/restapi/operations/content"
+	"peertech.de/axion/pkg/tracing"
 )
 
 const (
-	maxUploadSize = 1024 * 1024 * 1024 // 1GB limit
+	// defaultMaxUploadSize limits the size of archives uploaded at once
+	defaultMaxUploadSize = 1024 * 1024 * 1024 // 1GB limit
 )
 
 func (api *API) handleUpload(params ops_content.UploadParams) middleware.Responder {
-	scopedLog := log.With().
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
 		Str("handler", "handleUpload").
 		Str("path", params.Path).
 		Bool("recursive", params.Recursive != nil && *params.Recursive).
+		Bool("preserve", params.Preserve != nil && *params.Preserve).
+		Str("format", archiveFormatOrDefault(params.XArchiveFormat)).
 		Logger()
 
 	if params.Path == "" {
 		return ops_content.NewUploadBadRequest().
 			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Missing file path")))
 	}
+	if err := api.checkPath(params.HTTPRequest.Context(), params.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_content.NewUploadForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
 
 	// Check content size
-	if params.HTTPRequest.ContentLength > maxUploadSize {
+	if params.HTTPRequest.ContentLength > api.options.MaxUploadSize {
 		return ops_content.NewUploadRequestEntityTooLarge().
 			WithPayload(newAPIError(http.StatusRequestEntityTooLarge, WithMessage("Upload too large")))
 	}
 
+	// Receive and verify the complete archive before anything is extracted
+	var expected string
+	if params.ContentSha256 != nil {
+		expected = *params.ContentSha256
+	}
+	content, err := spoolUpload(params.Content, expected, api.options.MaxUploadSize, api.options.UploadTempDir)
+	if err != nil {
+		var oe *OpError
+		if errors.As(err, &oe) {
+			switch oe.Code {
+			case http.StatusRequestEntityTooLarge:
+				return ops_content.NewUploadRequestEntityTooLarge().
+					WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+			case http.StatusUnprocessableEntity:
+				scopedLog.Warn().Msg(oe.Msg)
+				return ops_content.NewUploadUnprocessableEntity().
+					WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+			}
+		}
+		scopedLog.Error().Err(err).Msg("Failed to receive archive")
+		return ops_content.NewUploadInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to receive archive")))
+	}
+
 	recursive := params.Recursive != nil && *params.Recursive
+	preserve := params.Preserve != nil && *params.Preserve
+	format := archiveFormatOrDefault(params.XArchiveFormat)
+
+	existed, err := api.extractUpload(params.HTTPRequest.Context(), params.Path, recursive, preserve, format, content)
+	if err != nil {
+		var oe *OpError
+		if !errors.As(err, &oe) {
+			oe = newOpError(http.StatusInternalServerError, "Upload failed", err)
+		}
+
+		switch oe.Code {
+		case http.StatusConflict:
+			return ops_content.NewUploadConflict().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		case http.StatusUnprocessableEntity:
+			scopedLog.Error().Err(oe.Cause).Msg(oe.Msg)
+			return ops_content.NewUploadUnprocessableEntity().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		default:
+			scopedLog.Error().Err(oe.Cause).Msg(oe.Msg)
+			return ops_content.NewUploadInternalServerError().
+				WithPayload(newAPIError(http.StatusInternalServerError, WithMessage(oe.Msg)))
+		}
+	}
+
+	api.events.publish(params.HTTPRequest.Context(), &models.AgentEvent{Type: eventContentUploaded, Path: params.Path})
+	if existed {
+		return ops_content.NewUploadNoContent()
+	}
+	return ops_content.NewUploadCreated()
+}
+
+// extractUpload extracts the archive in content compressed with format to path, as a directory if
+// recursive or as a single file otherwise. It reports whether path existed before.
+//
+// The archive is staged and moved into place once complete, so a partially extracted
+// file or tree never appears at path. The archive is merged into an existing directory,
+// see mergeDirectory. With preserve, the owners, extended attributes and hardlinks
+// recorded in the archive are restored as well.
+func (api *API) extractUpload(ctx context.Context, path string, recursive, preserve bool, format string, content io.ReadCloser) (existed bool, err error) {
+	defer content.Close()
+
+	_, span := tracing.Start(ctx, "fs.extract",
+		attribute.String("axion.path", path),
+		attribute.Bool("axion.recursive", recursive),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	// A directory is replaced as a whole, so nothing below may be mutated meanwhile
+	lock := api.paths.lock
+	if recursive {
+		lock = api.paths.lockTree
+	}
+	unlock, err := lock(path)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
 
 	// Check for path conflicts
-	if fi, err := os.Stat(params.Path); err == nil {
+	fi, err := os.Stat(path)
+	if err == nil {
+		existed = true
 		if fi.IsDir() && !recursive {
-			return ops_content.NewUploadConflict().
-				WithPayload(newAPIError(http.StatusConflict, WithMessage("Path is a directory, use recursive=true for directory uploads")))
+			return existed, newOpError(http.StatusConflict, "Path is a directory, use recursive=true for directory uploads", nil)
 		}
 		if !fi.IsDir() && recursive {
-			return ops_content.NewUploadConflict().
-				WithPayload(newAPIError(http.StatusConflict, WithMessage("Path is a file, use recursive=false for file uploads")))
+			return existed, newOpError(http.StatusConflict, "Path is a file, use recursive=false for file uploads", nil)
 		}
 	}
 
+	// Create parent directories if they don't exist
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return existed, newOpError(http.StatusInternalServerError, "Failed to create parent directories", err)
+	}
+
 	if recursive {
-		return api.handleDirectoryUpload(scopedLog, params)
+		// Stage on the file system of the target, so the tree can be renamed into place:
+		// next to a new directory and within an existing one, which may be a mount point
+		parent := dir
+		if existed {
+			parent = path
+		}
+		staging, err := os.MkdirTemp(parent, "."+filepath.Base(path)+".upload-*")
+		if err != nil {
+			return existed, newOpError(http.StatusInternalServerError, "Failed to create staging directory", err)
+		}
+		defer os.RemoveAll(staging)
+
+		if err := api.extractTarArchive(content, staging, preserve, format); err != nil {
+			return existed, newOpError(http.StatusUnprocessableEntity, "Failed to extract archive", err)
+		}
+
+		if existed {
+			if err := mergeDirectory(staging, path, preserve); err != nil {
+				return existed, newOpError(http.StatusInternalServerError, "Failed to move directory into place", err)
+			}
+			return existed, nil
+		}
+
+		// MkdirTemp creates private directories
+		if err := os.Chmod(staging, 0755); err != nil {
+			return existed, newOpError(http.StatusInternalServerError, "Failed to chmod directory", err)
+		}
+		if err := os.Rename(staging, path); err != nil {
+			return existed, newOpError(http.StatusInternalServerError, "Failed to move directory into place", err)
+		}
+		return existed, nil
+	}
+
+	staging, err := os.CreateTemp(dir, "."+filepath.Base(path)+".upload-*")
+	if err != nil {
+		return existed, newOpError(http.StatusInternalServerError, "Failed to create staging file", err)
 	}
+	staging.Close()
+	defer os.Remove(staging.Name())
 
-	return api.handleFileUpload(scopedLog, params)
+	// Extract single file from archive
+	if err := api.extractSingleFileFromTar(content, staging.Name(), preserve, format); err != nil {
+		return existed, newOpError(http.StatusUnprocessableEntity, "Failed to extract file from archive", err)
+	}
+	if existed && !preserve {
+		if err := copyOwnership(staging.Name(), fi); err != nil {
+			return existed, newOpError(http.StatusInternalServerError, "Failed to chown file", err)
+		}
+	}
 
+	if err := os.Rename(staging.Name(), path); err != nil {
+		return existed, newOpError(http.StatusInternalServerError, "Failed to move file into place", err)
+	}
+	return existed, nil
 }
 
-func (api *API) handleDirectoryUpload(scopedLog zerolog.Logger, params ops_content.UploadParams) middleware.Responder {
-	existed := true
-	if _, err := os.Stat(params.Path); os.IsNotExist(err) {
-		existed = false
+// mergeDirectory moves the entries of the staged directory into the existing directory
+// at path, descending into directories present in both. Entries of path missing from the
+// archive are kept, entries of another type are replaced. Every entry is renamed into
+// place on its own, the existing directories keep their mode and owner unless preserve.
+func mergeDirectory(staging, path string, preserve bool) error {
+	entries, err := os.ReadDir(staging)
+	if err != nil {
+		return err
 	}
 
-	// Create target directory if it doesn't exist
-	if err := os.MkdirAll(params.Path, 0755); err != nil {
-		scopedLog.Error().Err(err).Msg("Failed to create target directory")
-		return ops_content.NewUploadInternalServerError().
-			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to create target directory")))
+	for _, entry := range entries {
+		src := filepath.Join(staging, entry.Name())
+		dst := filepath.Join(path, entry.Name())
+
+		fi, err := os.Lstat(dst)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return err
+		case fi.IsDir() && entry.IsDir():
+			if err := mergeDirectory(src, dst, preserve); err != nil {
+				return err
+			}
+			if preserve {
+				if err := copyMetadata(src, dst); err != nil {
+					return err
+				}
+			}
+			continue
+		case fi.IsDir() || entry.IsDir():
+			if err := os.RemoveAll(dst); err != nil {
+				return err
+			}
+		}
+
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// Extract tar.gz archive to directory
-	if err := api.extractTarArchive(params.Content, params.Path); err != nil {
-		scopedLog.Error().Err(err).Msg("Failed to extract archive")
-		return ops_content.NewUploadUnprocessableEntity().
-			WithPayload(newAPIError(http.StatusUnprocessableEntity, WithMessage("Failed to extract archive")))
+// copyMetadata applies the mode and owner of the directory src to dst
+func copyMetadata(src, dst string) error {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if err := copyOwnership(dst, fi); err != nil {
+		return err
 	}
+	return os.Chmod(dst, fi.Mode().Perm())
+}
 
-	if existed {
-		return ops_content.NewUploadNoContent()
-	} else {
-		return ops_content.NewUploadCreated()
+// copyOwnership changes the owner of path to the one of fi
+func copyOwnership(path string, fi os.FileInfo) error {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
 	}
+	return os.Lchown(path, int(stat.Uid), int(stat.Gid))
 }
 
-func (api *API) extractTarArchive(src io.ReadCloser, destDir string) error {
+func (api *API) extractTarArchive(src io.ReadCloser, destDir string, preserve bool, format string) error {
 	defer src.Close()
 
-	// Create gzip reader
-	gzr, err := gzip.NewReader(src)
+	// Create decompressing reader
+	dr, err := newDecompressor(src, format)
 	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
+		return err
 	}
-	defer gzr.Close()
+	defer dr.Close()
 
 	// Create tar reader
-	tr := tar.NewReader(gzr)
+	tr := tar.NewReader(dr)
 
 	for {
 		header, err := tr.Next()
@@ -114,7 +301,7 @@ func (api *API) extractTarArchive(src io.ReadCloser, destDir string) error {
 		destPath := filepath.Join(destDir, header.Name)
 
 		// Pevent path traversal
-		if !strings.HasPrefix(destPath, filepath.Clean(destDir)+string(os.PathSeparator)) {
+		if !withinDir(destDir, destPath) {
 			return fmt.Errorf("invalid file path in archive: %s", header.Name)
 		}
 
@@ -137,57 +324,99 @@ func (api *API) extractTarArchive(src io.ReadCloser, destDir string) error {
 				return fmt.Errorf("failed to create symlink %s: %w", header.Name, err)
 			}
 
+		case tar.TypeLink:
+			if !preserve {
+				log.Warn().Str("file", header.Name).Msg("Skipping hardlink in archive, preserve is not set")
+				continue
+			}
+			if err := createHardlink(destDir, header.Linkname, destPath); err != nil {
+				return fmt.Errorf("failed to create hardlink %s: %w", header.Name, err)
+			}
+			// The metadata is shared with the linked file
+			continue
+
 		default:
 			// Skip unsupported file types
 			log.Warn().
 				Str("file", header.Name).
 				Str("type", string(rune(header.Typeflag))).
 				Msg("Skipping unsupported file type in archive")
+			continue
+		}
+
+		if preserve {
+			if err := restoreMetadata(destPath, header); err != nil {
+				return fmt.Errorf("failed to restore metadata of %s: %w", header.Name, err)
+			}
 		}
 	}
 
 	return nil
 }
 
-func (api *API) handleFileUpload(scopedLog zerolog.Logger, params ops_content.UploadParams) middleware.Responder {
-	existed := true
-	if _, err := os.Stat(params.Path); os.IsNotExist(err) {
-		existed = false
-	}
+// withinDir reports whether path is inside of dir
+func withinDir(dir, path string) bool {
+	return strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator))
+}
 
-	// Create parent directories if they don't exist
-	if err := os.MkdirAll(filepath.Dir(params.Path), 0755); err != nil {
-		scopedLog.Error().Err(err).Msg("Failed to create parent directories")
-		return ops_content.NewUploadInternalServerError().
-			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to create parent directories")))
+// restoreMetadata applies the owner and extended attributes recorded in header to path
+func restoreMetadata(path string, header *tar.Header) error {
+	if err := os.Lchown(path, header.Uid, header.Gid); err != nil {
+		return err
+	}
+	// Extended attributes can't be set on symlinks without following them
+	if header.Typeflag == tar.TypeSymlink {
+		return nil
 	}
+	// Changing the owner clears the setuid and setgid bits, which are restored as well
+	if mode := header.FileInfo().Mode(); mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky) != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+	return writeXattrs(path, header)
+}
 
-	// Extract single file from tar.gz archive
-	if err := api.extractSingleFileFromTar(params.Content, params.Path); err != nil {
-		scopedLog.Error().Err(err).Msg("Failed to extract file from archive")
-		return ops_content.NewUploadUnprocessableEntity().
-			WithPayload(newAPIError(http.StatusUnprocessableEntity, WithMessage("Failed to extract file from archive")))
+// createHardlink links linkPath to the entry target extracted to destDir before
+func createHardlink(destDir, target, linkPath string) error {
+	targetPath := filepath.Join(destDir, target)
+	if !withinDir(destDir, targetPath) {
+		return fmt.Errorf("invalid link target in archive: %s", target)
+	}
+	// Symlinks extracted before must not lead the link out of destDir either
+	root, err := filepath.EvalSymlinks(destDir)
+	if err != nil {
+		return err
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(targetPath))
+	if err != nil {
+		return fmt.Errorf("invalid link target in archive: %s", target)
+	}
+	if parent != root && !withinDir(root, parent) {
+		return fmt.Errorf("invalid link target in archive: %s", target)
 	}
 
-	if existed {
-		return ops_content.NewUploadNoContent()
-	} else {
-		return ops_content.NewUploadCreated()
+	if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
 	}
+	if err := os.Remove(linkPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove existing file: %w", err)
+	}
+	return os.Link(targetPath, linkPath)
 }
 
-func (api *API) extractSingleFileFromTar(src io.ReadCloser, destPath string) error {
+func (api *API) extractSingleFileFromTar(src io.ReadCloser, destPath string, preserve bool, format string) error {
 	defer src.Close()
 
-	// Create gzip reader
-	gzr, err := gzip.NewReader(src)
+	// Create decompressing reader
+	dr, err := newDecompressor(src, format)
 	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
+		return err
 	}
-	defer gzr.Close()
+	defer dr.Close()
 
 	// Create tar reader
-	tr := tar.NewReader(gzr)
+	tr := tar.NewReader(dr)
 
 	// Read entry
 	header, err := tr.Next()
@@ -204,6 +433,11 @@ func (api *API) extractSingleFileFromTar(src io.ReadCloser, destPath string) err
 	if err := api.extractTarFile(tr, destPath, os.FileMode(header.Mode)); err != nil {
 		return err // No need to wrap, extractTarFile has good errors
 	}
+	if preserve {
+		if err := restoreMetadata(destPath, header); err != nil {
+			return fmt.Errorf("failed to restore metadata: %w", err)
+		}
+	}
 
 	// Check for extra data after the first file
 	if _, err := tr.Next(); err != io.EOF {
@@ -233,6 +467,11 @@ func (api *API) extractTarFile(tarReader *tar.Reader, destPath string, mode os.F
 		return fmt.Errorf("failed to write file content: %w", err)
 	}
 
+	// The mode given to OpenFile is subject to the umask and ignored for existing files
+	if err := destFile.Chmod(mode); err != nil {
+		return fmt.Errorf("failed to chmod file: %w", err)
+	}
+
 	return nil
 }
 
@@ -254,3 +493,51 @@ func (api *API) createSymlink(target, linkPath string) error {
 
 	return nil
 }
+
+// spoolUpload reads src into a temporary file in dir, failing if it exceeds limit,
+// and verifies its SHA-256 checksum against expected if not empty. The returned reader
+// removes the temporary file once closed.
+func spoolUpload(src io.ReadCloser, expected string, limit int64, dir string) (io.ReadCloser, error) {
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(dir, "axion-upload-*")
+	if err != nil {
+		return nil, newOpError(http.StatusInternalServerError, "Failed to create temporary file", err)
+	}
+
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(src, limit+1))
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, newOpError(http.StatusInternalServerError, "Failed to receive archive", err)
+	}
+
+	if n > limit {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, newOpError(http.StatusRequestEntityTooLarge, "Upload too large", nil)
+	}
+
+	if expected != "" && !strings.EqualFold(expected, hex.EncodeToString(hasher.Sum(nil))) {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, newOpError(http.StatusUnprocessableEntity, "Content-SHA256 mismatch, the archive was corrupted in transfer", nil)
+	}
+
+	return &spooledFile{File: tmp}, nil
+}
+
+// spooledFile is a temporary file removed on Close
+type spooledFile struct {
+	*os.File
+}
+
+func (f *spooledFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
@@ -0,0 +1,431 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/rs/zerolog/log"
+
+	"peertech.de/axion/api/models"
+	ops_content "peertech.de/axion/api/restapi/operations/content"
+)
+
+const (
+	// defaultMaxChunkedUploadSize limits the size of archives uploaded in chunks
+	defaultMaxChunkedUploadSize = 16 * 1024 * 1024 * 1024 // 16GB limit
+	// maxChunkSize limits the size of a single chunk
+	maxChunkSize = 64 * 1024 * 1024 // 64MB limit
+	// defaultMaxUploadSessions limits the number of open upload sessions
+	defaultMaxUploadSessions = 8
+	// defaultMaxStagedUploadSize limits the total size of the open upload sessions
+	defaultMaxStagedUploadSize = 32 * 1024 * 1024 * 1024 // 32GB limit
+	// uploadSessionRetention is how long idle upload sessions are kept
+	uploadSessionRetention = 24 * time.Hour
+)
+
+// errUploadIncomplete is returned when committing a session before all of its content
+// was received, the session can still be resumed
+var errUploadIncomplete = newOpError(http.StatusConflict, "Upload incomplete", nil)
+
+// errTooManyUploads is returned when creating a session would exceed the number of open
+// sessions or the total size of their archives
+var errTooManyUploads = newOpError(http.StatusTooManyRequests, "Too many or too large open uploads", nil)
+
+// uploadSession is an upload received in chunks into a temporary file
+type uploadSession struct {
+	id        string
+	path      string
+	recursive bool
+	preserve  bool
+	format    string
+	size      int64
+	sha256    string
+
+	// Guarded by mu, which also serializes the appends
+	mu      sync.Mutex
+	file    *os.File
+	offset  int64
+	updated time.Time
+}
+
+// uploadStore holds the upload sessions of the agent. The sessions are limited by
+// number and by the total size of their archives, which are reserved in the temp
+// directory as soon as a session is created.
+type uploadStore struct {
+	// tempDir holds the content of the sessions
+	tempDir     string
+	maxSessions int
+	maxStaged   int64
+
+	mu       sync.Mutex
+	sessions map[string]*uploadSession
+}
+
+func newUploadStore(tempDir string, maxSessions int, maxStaged int64) *uploadStore {
+	return &uploadStore{
+		tempDir:     tempDir,
+		maxSessions: maxSessions,
+		maxStaged:   maxStaged,
+		sessions:    make(map[string]*uploadSession),
+	}
+}
+
+// create starts a new upload session backed by a temporary file. It fails with
+// errTooManyUploads if the session exceeds the limits of the store.
+func (s *uploadStore) create(path string, recursive, preserve bool, format string, size int64, sum string) (*uploadSession, error) {
+	id, err := newJobId()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+	staged := size
+	for _, session := range s.sessions {
+		staged += session.size
+	}
+	if len(s.sessions) >= s.maxSessions || staged > s.maxStaged {
+		return nil, errTooManyUploads
+	}
+
+	file, err := os.CreateTemp(s.tempDir, "axion-upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	session := &uploadSession{
+		id:        id,
+		path:      path,
+		recursive: recursive,
+		preserve:  preserve,
+		format:    format,
+		size:      size,
+		sha256:    strings.ToLower(sum),
+		file:      file,
+		updated:   time.Now(),
+	}
+	s.sessions[id] = session
+	return session, nil
+}
+
+// get returns the session with the given id
+func (s *uploadStore) get(id string) (*uploadSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	return session, ok
+}
+
+// remove discards the session with the given id and its content
+func (s *uploadStore) remove(id string) bool {
+	s.mu.Lock()
+	session, ok := s.sessions[id]
+	delete(s.sessions, id)
+	s.mu.Unlock()
+
+	if ok {
+		session.discard()
+	}
+	return ok
+}
+
+// close discards all sessions
+func (s *uploadStore) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		session.discard()
+		delete(s.sessions, id)
+	}
+}
+
+// prune discards sessions idle longer than the retention, s.mu must be held
+func (s *uploadStore) prune() {
+	for id, session := range s.sessions {
+		session.mu.Lock()
+		idle := time.Since(session.updated)
+		session.mu.Unlock()
+
+		if idle > uploadSessionRetention {
+			session.discard()
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// append writes the chunk at offset, which has to match the offset of the session. A
+// chunk which can't be received completely or fails verification is discarded.
+func (u *uploadSession) append(offset int64, chunk io.Reader, expected string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.file == nil {
+		return newOpError(http.StatusNotFound, "Upload session not found", nil)
+	}
+	if offset != u.offset {
+		return newOpError(http.StatusConflict, "Upload-Offset does not match the session offset", nil)
+	}
+
+	limit := min(int64(maxChunkSize), u.size-u.offset)
+	hasher := sha256.New()
+	w := io.MultiWriter(io.NewOffsetWriter(u.file, u.offset), hasher)
+
+	n, err := io.Copy(w, io.LimitReader(chunk, limit+1))
+	switch {
+	case err != nil:
+		u.file.Truncate(u.offset)
+		return newOpError(http.StatusInternalServerError, "Failed to receive chunk", err)
+	case n > limit:
+		u.file.Truncate(u.offset)
+		return newOpError(http.StatusRequestEntityTooLarge, "Chunk too large or exceeding the upload size", nil)
+	case expected != "" && !strings.EqualFold(expected, hex.EncodeToString(hasher.Sum(nil))):
+		u.file.Truncate(u.offset)
+		return newOpError(http.StatusUnprocessableEntity, "Content-SHA256 mismatch, the chunk was corrupted in transfer", nil)
+	}
+
+	u.offset += n
+	u.updated = time.Now()
+	return nil
+}
+
+// content verifies the received archive and returns a reader for it
+func (u *uploadSession) content() (io.ReadCloser, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.offset != u.size {
+		return nil, errUploadIncomplete
+	}
+
+	if u.sha256 != "" {
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, io.NewSectionReader(u.file, 0, u.size)); err != nil {
+			return nil, newOpError(http.StatusInternalServerError, "Failed to read upload", err)
+		}
+		if u.sha256 != hex.EncodeToString(hasher.Sum(nil)) {
+			return nil, newOpError(http.StatusUnprocessableEntity, "Content-SHA256 mismatch, the archive was corrupted in transfer", nil)
+		}
+	}
+
+	return io.NopCloser(io.NewSectionReader(u.file, 0, u.size)), nil
+}
+
+// discard removes the temporary file of the session
+func (u *uploadSession) discard() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.file != nil {
+		u.file.Close()
+		os.Remove(u.file.Name())
+		u.file = nil
+	}
+}
+
+// view returns the API representation of the session
+func (u *uploadSession) view() *models.UploadSession {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return &models.UploadSession{
+		ID:        u.id,
+		Path:      u.path,
+		Recursive: u.recursive,
+		Preserve:  u.preserve,
+		Format:    u.format,
+		Size:      u.size,
+		Offset:    u.offset,
+	}
+}
+
+func (api *API) handleInitUpload(params ops_content.InitUploadParams) middleware.Responder {
+	req := params.Session
+	if req == nil || req.Path == "" {
+		return ops_content.NewInitUploadBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Missing file path")))
+	}
+
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleInitUpload").
+		Str("path", req.Path).
+		Bool("recursive", req.Recursive).
+		Bool("preserve", req.Preserve).
+		Str("format", archiveFormatOrDefault(&req.Format)).
+		Int64("size", req.Size).
+		Logger()
+
+	if err := api.checkPath(params.HTTPRequest.Context(), req.Path); err != nil {
+		scopedLog.Warn().Err(err).Msg("Path not allowed")
+		return ops_content.NewInitUploadForbidden().
+			WithPayload(newAPIError(http.StatusForbidden, WithMessage("Path not allowed")))
+	}
+	if req.Size <= 0 {
+		return ops_content.NewInitUploadBadRequest().
+			WithPayload(newAPIError(http.StatusBadRequest, WithMessage("Upload size must be positive")))
+	}
+	if req.Size > api.options.MaxChunkedUploadSize {
+		return ops_content.NewInitUploadRequestEntityTooLarge().
+			WithPayload(newAPIError(http.StatusRequestEntityTooLarge, WithMessage("Upload too large")))
+	}
+
+	session, err := api.uploads.create(req.Path, req.Recursive, req.Preserve, archiveFormatOrDefault(&req.Format), req.Size, req.Sha256)
+	if errors.Is(err, errTooManyUploads) {
+		scopedLog.Warn().Msg(errTooManyUploads.Msg)
+		return ops_content.NewInitUploadTooManyRequests().
+			WithPayload(newAPIError(http.StatusTooManyRequests, WithMessage(errTooManyUploads.Msg)))
+	}
+	if err != nil {
+		scopedLog.Error().Err(err).Msg("Failed to create upload session")
+		return ops_content.NewInitUploadInternalServerError().
+			WithPayload(newAPIError(http.StatusInternalServerError, WithMessage("Failed to create upload session")))
+	}
+
+	return ops_content.NewInitUploadCreated().WithPayload(session.view())
+}
+
+func (api *API) handleGetUploadSession(params ops_content.GetUploadSessionParams) middleware.Responder {
+	session, ok := api.uploads.get(params.ID)
+	if !ok {
+		return ops_content.NewGetUploadSessionNotFound().
+			WithPayload(newAPIError(http.StatusNotFound, WithMessage("Upload session not found")))
+	}
+	return ops_content.NewGetUploadSessionOK().WithPayload(session.view())
+}
+
+func (api *API) handleAppendUpload(params ops_content.AppendUploadParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleAppendUpload").
+		Str("id", params.ID).
+		Int64("offset", params.UploadOffset).
+		Logger()
+
+	defer params.Chunk.Close()
+
+	session, ok := api.uploads.get(params.ID)
+	if !ok {
+		return ops_content.NewAppendUploadNotFound().
+			WithPayload(newAPIError(http.StatusNotFound, WithMessage("Upload session not found")))
+	}
+
+	var expected string
+	if params.ContentSha256 != nil {
+		expected = *params.ContentSha256
+	}
+
+	if err := session.append(params.UploadOffset, params.Chunk, expected); err != nil {
+		var oe *OpError
+		if !errors.As(err, &oe) {
+			oe = newOpError(http.StatusInternalServerError, "Failed to append chunk", err)
+		}
+
+		switch oe.Code {
+		case http.StatusNotFound:
+			return ops_content.NewAppendUploadNotFound().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		case http.StatusConflict:
+			return ops_content.NewAppendUploadConflict().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		case http.StatusRequestEntityTooLarge:
+			return ops_content.NewAppendUploadRequestEntityTooLarge().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		case http.StatusUnprocessableEntity:
+			scopedLog.Warn().Msg(oe.Msg)
+			return ops_content.NewAppendUploadUnprocessableEntity().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		default:
+			scopedLog.Error().Err(oe.Cause).Msg(oe.Msg)
+			return ops_content.NewAppendUploadInternalServerError().
+				WithPayload(newAPIError(http.StatusInternalServerError, WithMessage(oe.Msg)))
+		}
+	}
+
+	return ops_content.NewAppendUploadOK().WithPayload(session.view())
+}
+
+func (api *API) handleCommitUpload(params ops_content.CommitUploadParams) middleware.Responder {
+	scopedLog := log.Ctx(params.HTTPRequest.Context()).With().
+		Str("handler", "handleCommitUpload").
+		Str("id", params.ID).
+		Logger()
+
+	session, ok := api.uploads.get(params.ID)
+	if !ok {
+		return ops_content.NewCommitUploadNotFound().
+			WithPayload(newAPIError(http.StatusNotFound, WithMessage("Upload session not found")))
+	}
+
+	existed, err := api.commitUpload(params.HTTPRequest.Context(), session)
+	if err != nil {
+		var oe *OpError
+		if !errors.As(err, &oe) {
+			oe = newOpError(http.StatusInternalServerError, "Upload failed", err)
+		}
+
+		// An incomplete or a locked upload may still be resumed, anything else ends the
+		// session
+		if !errors.Is(err, errUploadIncomplete) && !errors.Is(err, errPathLocked) {
+			api.uploads.remove(session.id)
+		}
+
+		switch oe.Code {
+		case http.StatusForbidden:
+			scopedLog.Warn().Err(oe.Cause).Msg(oe.Msg)
+			return ops_content.NewCommitUploadForbidden().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		case http.StatusConflict:
+			return ops_content.NewCommitUploadConflict().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		case http.StatusUnprocessableEntity:
+			scopedLog.Error().Err(oe.Cause).Msg(oe.Msg)
+			return ops_content.NewCommitUploadUnprocessableEntity().
+				WithPayload(newAPIError(oe.Code, WithMessage(oe.Msg)))
+		default:
+			scopedLog.Error().Err(oe.Cause).Msg(oe.Msg)
+			return ops_content.NewCommitUploadInternalServerError().
+				WithPayload(newAPIError(http.StatusInternalServerError, WithMessage(oe.Msg)))
+		}
+	}
+
+	api.uploads.remove(session.id)
+	api.events.publish(params.HTTPRequest.Context(), &models.AgentEvent{Type: eventContentUploaded, Path: session.path})
+
+	if existed {
+		return ops_content.NewCommitUploadNoContent()
+	}
+	return ops_content.NewCommitUploadCreated()
+}
+
+// commitUpload verifies the content of the session and extracts it to its path
+func (api *API) commitUpload(ctx context.Context, session *uploadSession) (bool, error) {
+	// The allowed paths or the policy may have changed since the session was created
+	if err := api.checkPath(ctx, session.path); err != nil {
+		return false, newOpError(http.StatusForbidden, "Path not allowed", err)
+	}
+
+	content, err := session.content()
+	if err != nil {
+		return false, err
+	}
+	return api.extractUpload(ctx, session.path, session.recursive, session.preserve, session.format, content)
+}
+
+func (api *API) handleAbortUpload(params ops_content.AbortUploadParams) middleware.Responder {
+	if !api.uploads.remove(params.ID) {
+		return ops_content.NewAbortUploadNotFound().
+			WithPayload(newAPIError(http.StatusNotFound, WithMessage("Upload session not found")))
+	}
+	return ops_content.NewAbortUploadNoContent()
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeDirectory(t *testing.T) {
+	path := t.TempDir()
+	staging := filepath.Join(path, ".upload")
+
+	files := map[string]string{
+		"keep.txt":              "kept",
+		"conf/app.conf":         "old",
+		"conf/local.conf":       "local",
+		"replaced/dir.txt":      "dir",
+		".upload/conf/app.conf": "new",
+		".upload/new.txt":       "new",
+		".upload/replaced":      "file",
+	}
+	for name, content := range files {
+		p := filepath.Join(path, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mergeDirectory(staging, path, false); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"keep.txt":        "kept",
+		"conf/app.conf":   "new",
+		"conf/local.conf": "local",
+		"new.txt":         "new",
+		"replaced":        "file",
+	}
+	for name, content := range expected {
+		data, err := os.ReadFile(filepath.Join(path, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s: expected %q, got %q", name, content, data)
+		}
+	}
+}
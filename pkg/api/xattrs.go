@@ -0,0 +1,76 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"strings"
+	"syscall"
+)
+
+// paxXattrPrefix is the prefix of the PAX records holding extended attributes, as
+// written by GNU tar and bsdtar
+const paxXattrPrefix = "SCHILY.xattr."
+
+// readXattrs adds the extended attributes of path to the PAX records of header.
+// Filesystems without extended attributes report none.
+func readXattrs(path string, header *tar.Header) error {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			return nil
+		}
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := getXattr(path, string(name))
+		if err != nil {
+			return err
+		}
+		if header.PAXRecords == nil {
+			header.PAXRecords = make(map[string]string)
+		}
+		header.PAXRecords[paxXattrPrefix+string(name)] = string(value)
+	}
+	return nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, size)
+	size, err = syscall.Getxattr(path, name, value)
+	if err != nil {
+		return nil, err
+	}
+	return value[:size], nil
+}
+
+// writeXattrs sets the extended attributes in the PAX records of header on path
+func writeXattrs(path string, header *tar.Header) error {
+	for key, value := range header.PAXRecords {
+		name, ok := strings.CutPrefix(key, paxXattrPrefix)
+		if !ok {
+			continue
+		}
+		if err := syscall.Setxattr(path, name, []byte(value), 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
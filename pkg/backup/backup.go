@@ -0,0 +1,282 @@
+// Package backup manages the backups axionctl keeps of resources in the backup
+// directory. Each resource has a current backup, which rollbacks restore; backups it
+// supersedes are kept next to it as versions, named after the current backup and the
+// time they were taken, until pruned by the retention Policy.
+//
+// Backups can be copied to a remote Storage as well, e.g. S3 or SFTP, so they survive
+// the loss of the machine taking them. The remote storage only holds versions, the
+// newest of a resource is its current backup.
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// versionLayout is the layout of the time suffix of versions
+const versionLayout = "20060102T150405.000Z"
+
+// etagSuffix is the suffix of the file holding the ETag of the current backup
+const etagSuffix = ".etag"
+
+// versionPattern matches the names of versions, capturing the name of the current
+// backup and the time
+var versionPattern = regexp.MustCompile(`^(.+)\.(\d{8}T\d{6}\.\d{3}Z)$`)
+
+// Backup is a backup in a Storage
+type Backup struct {
+	// Key is the key of the backup in the storage
+	Key string
+	// Resource identifies the resource by the key of its current backup
+	Resource string
+	// Current is set for the backup rollbacks restore, unset for superseded versions
+	Current bool
+	Time    time.Time
+	Size    int64
+}
+
+// VersionPath returns the path the backup at path taken at t is kept at once superseded
+func VersionPath(path string, t time.Time) string {
+	return path + "." + t.UTC().Format(versionLayout)
+}
+
+// Supersede keeps the current backup at path as version, so that a new backup can be
+// written to path. Its checksum is kept with it, its ETag is discarded as versions
+// aren't refreshed.
+func Supersede(path string) error {
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	version := VersionPath(path, fi.ModTime())
+	if err := os.Rename(path, version); err != nil {
+		return err
+	}
+	if err := os.Rename(path+checksumSuffix, version+checksumSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(path + etagSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List returns the backups in the storage, the newest first. Resources without current
+// backup, e.g. in remote storages, have their newest version marked current.
+func List(ctx context.Context, s Storage) ([]Backup, error) {
+	objects, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]Backup, 0, len(objects))
+	for _, o := range objects {
+		b := Backup{Key: o.Key, Resource: o.Key, Current: true, Time: o.Modified, Size: o.Size}
+		if m := versionPattern.FindStringSubmatch(o.Key); m != nil {
+			b.Resource, b.Current = m[1], false
+			if t, err := time.Parse(versionLayout, m[2]); err == nil {
+				b.Time = t
+			}
+		}
+		backups = append(backups, b)
+	}
+
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].Time.After(backups[j].Time)
+	})
+
+	current := make(map[string]bool)
+	for _, b := range backups {
+		if b.Current {
+			current[b.Resource] = true
+		}
+	}
+	for i, b := range backups {
+		if !current[b.Resource] {
+			backups[i].Current = true
+			current[b.Resource] = true
+		}
+	}
+	return backups, nil
+}
+
+// Remove deletes the backup from the storage, with its checksum and the ETag of a
+// current backup
+func Remove(ctx context.Context, s Storage, b Backup) error {
+	if err := s.Delete(ctx, b.Key); err != nil {
+		return err
+	}
+	if err := s.Delete(ctx, b.Key+checksumSuffix); err != nil {
+		return err
+	}
+	if b.Key == b.Resource {
+		return s.Delete(ctx, b.Key+etagSuffix)
+	}
+	return nil
+}
+
+// Discard removes the current backup at path in the backup directory dir, with its
+// copy in the storage s, if not nil, e.g. once the run it was taken for succeeded. The
+// versions it superseded are left to the retention.
+func Discard(ctx context.Context, s Storage, dir, file string) error {
+	key, err := Key(dir, file)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := Remove(ctx, Dir(dir), Backup{Key: key, Resource: key, Current: true}); err != nil {
+		return err
+	}
+	if s != nil {
+		version := Backup{Key: VersionPath(key, fi.ModTime()), Resource: key}
+		if err := Remove(ctx, s, version); err != nil {
+			return fmt.Errorf("failed to remove backup from %s: %w", s, err)
+		}
+	}
+	return nil
+}
+
+// Upload copies the current backup at path in the backup directory dir to the storage,
+// as version taken at the modification time of the file, with its checksum
+func Upload(ctx context.Context, s Storage, dir, file string) error {
+	key, err := Key(dir, file)
+	if err != nil {
+		return err
+	}
+
+	fd, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+
+	version := VersionPath(key, fi.ModTime())
+	if err := s.Put(ctx, version, fd, fi.Size()); err != nil {
+		return fmt.Errorf("failed to upload backup to %s: %w", s, err)
+	}
+
+	checksum, err := os.ReadFile(file + checksumSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.Put(ctx, version+checksumSuffix, bytes.NewReader(checksum), int64(len(checksum))); err != nil {
+		return fmt.Errorf("failed to upload backup checksum to %s: %w", s, err)
+	}
+	return nil
+}
+
+// Download restores the current backup at path in the backup directory dir from the
+// newest backup of the resource in the storage, e.g. if it was lost with the machine
+// which took it. Its checksum is downloaded as well, if recorded, see Verify.
+func Download(ctx context.Context, s Storage, dir, file string) error {
+	key, err := Key(dir, file)
+	if err != nil {
+		return err
+	}
+
+	backups, err := List(ctx, s)
+	if err != nil {
+		return fmt.Errorf("failed to list backups in %s: %w", s, err)
+	}
+	var newest *Backup
+	for i, b := range backups {
+		if b.Resource == key {
+			newest = &backups[i]
+			break
+		}
+	}
+	if newest == nil {
+		return fmt.Errorf("no backup of %s found in %s", key, s)
+	}
+
+	r, err := s.Get(ctx, newest.Key)
+	if err != nil {
+		return fmt.Errorf("failed to download backup from %s: %w", s, err)
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download backup from %s: %w", s, err)
+	}
+	if err := os.Chtimes(tmp.Name(), newest.Time, newest.Time); err != nil {
+		return err
+	}
+	if err := downloadChecksum(ctx, s, newest.Key, file); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// downloadChecksum replaces the checksum of the backup at file with the one of key in
+// the storage. Storages don't tell missing objects apart, backups without checksum
+// are restored without.
+func downloadChecksum(ctx context.Context, s Storage, key, file string) error {
+	r, err := s.Get(ctx, key+checksumSuffix)
+	if err != nil {
+		if err := os.Remove(file + checksumSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	defer r.Close()
+
+	checksum, err := io.ReadAll(io.LimitReader(r, 4<<10))
+	if err != nil {
+		return fmt.Errorf("failed to download backup checksum from %s: %w", s, err)
+	}
+	return os.WriteFile(file+checksumSuffix, checksum, 0644)
+}
+
+// Key returns the key of the backup at path in the backup directory dir
+func Key(dir, file string) (string, error) {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil {
+		return "", err
+	}
+	key := filepath.ToSlash(rel)
+	if key == "." || !fs.ValidPath(key) {
+		return "", fmt.Errorf("backup %s is outside of the backup directory %s", file, dir)
+	}
+	return path.Clean(key), nil
+}
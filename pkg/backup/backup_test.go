@@ -0,0 +1,262 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeBackup(t *testing.T, path string, size int, modTime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSupersede(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "etc-app.conf.bak")
+	taken := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	writeBackup(t, path, 10, taken)
+	os.WriteFile(path+".etag", []byte("abc\n"), 0644)
+	WriteChecksum(path, "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	os.WriteFile(filepath.Join(dir, ".etc-app.conf.bak.123"), nil, 0644)
+
+	if err := Supersede(path); err != nil {
+		t.Fatal(err)
+	}
+	writeBackup(t, path, 20, taken.Add(time.Hour))
+
+	backups, err := List(context.Background(), Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected current backup and version, got %+v", backups)
+	}
+	if !backups[0].Current || backups[0].Resource != "etc-app.conf.bak" || backups[0].Size != 20 {
+		t.Errorf("unexpected current backup %+v", backups[0])
+	}
+	if backups[1].Current || backups[1].Resource != "etc-app.conf.bak" || !backups[1].Time.Equal(taken) ||
+		backups[1].Key != VersionPath("etc-app.conf.bak", taken) {
+		t.Errorf("unexpected version %+v", backups[1])
+	}
+	if _, err := os.Stat(path + ".etag"); !os.IsNotExist(err) {
+		t.Errorf("expected ETag of superseded backup to be removed, got %v", err)
+	}
+	if _, err := os.Stat(VersionPath(path, taken) + ".sha256"); err != nil {
+		t.Errorf("expected checksum to be kept with the version: %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	conf := filepath.Join(dir, "etc-app.conf.bak")
+	for i := 1; i <= 4; i++ {
+		writeBackup(t, VersionPath(conf, now.Add(-time.Duration(i)*time.Hour)), 10, now)
+	}
+	writeBackup(t, conf, 10, now)
+	os.WriteFile(conf+".etag", []byte("abc\n"), 0644)
+	writeBackup(t, filepath.Join(dir, "web1", "etc-dir.tar.zst"), 100, now.Add(-48*time.Hour))
+
+	removed, err := Prune(context.Background(), Dir(dir), Policy{KeepLast: 3}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || removed[0].Key != VersionPath("etc-app.conf.bak", now.Add(-3*time.Hour)) {
+		t.Errorf("expected the 2 oldest versions to be removed, got %+v", removed)
+	}
+
+	removed, err = Prune(context.Background(), Dir(dir), Policy{MaxAge: 24 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].Resource != "web1/etc-dir.tar.zst" {
+		t.Errorf("expected the backup of the directory to expire, got %+v", removed)
+	}
+
+	removed, err = Prune(context.Background(), Dir(dir), Policy{MaxSize: 15}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || !removed[0].Time.Equal(now.Add(-time.Hour)) {
+		t.Errorf("expected the versions to be removed, got %+v", removed)
+	}
+
+	backups, _ := List(context.Background(), Dir(dir))
+	if len(backups) != 1 || !backups[0].Current {
+		t.Errorf("expected only the current backup to be kept, got %+v", backups)
+	}
+	if _, err := os.Stat(conf + ".etag"); err != nil {
+		t.Errorf("expected ETag of current backup to be kept: %v", err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{
+		"1024":  1024,
+		"500MB": 500e6,
+		"2GiB":  2 << 30,
+		"1.5 k": 1536,
+		"10b":   10,
+	} {
+		got, err := ParseSize(s)
+		if err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v, expected %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "MB", "-1", "ten"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestUploadDownload(t *testing.T) {
+	dir, remote := t.TempDir(), Dir(t.TempDir())
+	path := filepath.Join(dir, "web1", "etc-app.conf.bak")
+	taken := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	writeBackup(t, path, 10, taken)
+
+	ctx := context.Background()
+	if err := Upload(ctx, remote, dir, path); err != nil {
+		t.Fatal(err)
+	}
+	writeBackup(t, path, 20, taken.Add(time.Hour))
+	WriteChecksum(path, "de47c9b27eb8d300dbb5f2c353e632c393262cf06340c4fa7f1b40c4cbd36f90")
+	if err := Upload(ctx, remote, dir, path); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := List(ctx, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || !backups[0].Current || backups[1].Current ||
+		backups[0].Key != VersionPath("web1/etc-app.conf.bak", taken.Add(time.Hour)) {
+		t.Fatalf("expected the newest version to be current, got %+v", backups)
+	}
+
+	os.RemoveAll(dir)
+	if err := Download(ctx, remote, dir, path); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 20 || !fi.ModTime().Equal(taken.Add(time.Hour)) {
+		t.Errorf("expected the newest backup to be downloaded, got %d bytes from %s", fi.Size(), fi.ModTime())
+	}
+	if _, err := os.Stat(path + ".sha256"); err != nil {
+		t.Errorf("expected the checksum to be downloaded: %v", err)
+	} else if err := Verify(path); err != nil {
+		t.Errorf("expected the downloaded backup to pass, got %v", err)
+	}
+
+	if err := Download(ctx, remote, dir, filepath.Join(dir, "other.bak")); err == nil {
+		t.Error("expected error downloading missing backup")
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	dir, remote := t.TempDir(), Dir(t.TempDir())
+	path := filepath.Join(dir, "etc-app.conf.bak")
+	taken := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	writeBackup(t, path, 10, taken)
+	if err := Supersede(path); err != nil {
+		t.Fatal(err)
+	}
+	writeBackup(t, path, 20, taken.Add(time.Hour))
+	os.WriteFile(path+".etag", []byte("abc\n"), 0644)
+	WriteChecksum(path, strings.Repeat("0", 64))
+
+	ctx := context.Background()
+	if err := Upload(ctx, remote, dir, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := Discard(ctx, remote, dir, path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{path, path + ".etag", path + ".sha256"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", p, err)
+		}
+	}
+	backups, err := List(ctx, Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Key != VersionPath("etc-app.conf.bak", taken) {
+		t.Errorf("expected the version to be kept, got %+v", backups)
+	}
+	if objects, _ := remote.List(ctx); len(objects) != 0 {
+		t.Errorf("expected the copy to be removed from the storage, got %+v", objects)
+	}
+
+	if err := Discard(ctx, remote, dir, path); err != nil {
+		t.Errorf("expected discarding a missing backup to pass, got %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "etc-app.conf.bak")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(path); err != nil {
+		t.Errorf("expected backup without checksum to pass, got %v", err)
+	}
+
+	// sha256 of "content"
+	if err := WriteChecksum(path, "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(path); err != nil {
+		t.Errorf("expected backup to pass, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("cont"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(path); err == nil || !strings.Contains(err.Error(), "is corrupt") {
+		t.Errorf("expected truncated backup to fail, got %v", err)
+	}
+
+	os.WriteFile(path+".sha256", []byte("garbage\n"), 0644)
+	if err := Verify(path); err == nil {
+		t.Error("expected invalid checksum file to fail")
+	}
+}
+
+func TestWithPrefix(t *testing.T) {
+	ctx := context.Background()
+	root := Dir(t.TempDir())
+	root.Put(ctx, "web2/etc-app.conf.bak", strings.NewReader("other"), 5)
+
+	s := WithPrefix(root, "web1")
+	if err := s.Put(ctx, "etc-app.conf.bak", strings.NewReader("content"), 7); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "etc-app.conf.bak" || objects[0].Size != 7 {
+		t.Errorf("expected only the object below the prefix, got %+v", objects)
+	}
+	if _, err := os.Stat(filepath.Join(string(root), "web1", "etc-app.conf.bak")); err != nil {
+		t.Errorf("expected object below the prefix: %v", err)
+	}
+}
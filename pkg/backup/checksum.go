@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checksumSuffix is the suffix of the file holding the SHA-256 of a backup, in the
+// format of sha256sum so it can be checked by hand as well
+const checksumSuffix = ".sha256"
+
+// isMetadata reports whether the object name is metadata of a backup rather than a
+// backup itself
+func isMetadata(name string) bool {
+	return strings.HasSuffix(name, etagSuffix) || strings.HasSuffix(name, checksumSuffix)
+}
+
+// WriteChecksum records sum as SHA-256 of the backup at path
+func WriteChecksum(path, sum string) error {
+	return os.WriteFile(path+checksumSuffix, []byte(sum+"  "+filepath.Base(path)+"\n"), 0644)
+}
+
+// readChecksum returns the SHA-256 recorded for the backup at path, empty if there is
+// none
+func readChecksum(path string) (string, error) {
+	data, err := os.ReadFile(path + checksumSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	sum, _, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	if len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid checksum file %s", path+checksumSuffix)
+	}
+	return sum, nil
+}
+
+// Verify checks the backup at path against its recorded SHA-256, e.g. so a truncated
+// backup isn't restored. Backups taken without checksum, by earlier versions, pass.
+func Verify(path string) error {
+	want, err := readChecksum(path)
+	if err != nil {
+		return err
+	}
+	if want == "" {
+		return nil
+	}
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, fd); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(hasher.Sum(nil)); got != want {
+		return fmt.Errorf("backup %s is corrupt: checksum %s doesn't match the recorded %s", path, got, want)
+	}
+	return nil
+}
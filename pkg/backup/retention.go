@@ -0,0 +1,117 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy limits the backups kept in the backup directory. Zero values don't limit.
+type Policy struct {
+	// KeepLast is the number of backups kept per resource, including the current one
+	KeepLast int `yaml:"keeplast"`
+	// MaxAge removes backups taken longer ago
+	MaxAge time.Duration `yaml:"maxage"`
+	// MaxSize is the total size of the backups in bytes, the oldest are removed until
+	// the backups fit
+	MaxSize int64 `yaml:"maxsize"`
+}
+
+// DefaultPolicy keeps the last three backups of each resource
+var DefaultPolicy = Policy{KeepLast: 3}
+
+// IsZero reports whether the policy doesn't limit the backups
+func (p Policy) IsZero() bool {
+	return p == Policy{}
+}
+
+// Expired returns the backups which exceed the policy at now, of the backups as
+// returned by List
+func (p Policy) Expired(backups []Backup, now time.Time) []Backup {
+	expired := make(map[string]bool)
+	var kept []Backup
+
+	perResource := make(map[string]int)
+	for _, b := range backups {
+		perResource[b.Resource]++
+		switch {
+		case p.KeepLast > 0 && perResource[b.Resource] > p.KeepLast:
+			expired[b.Key] = true
+		case p.MaxAge > 0 && now.Sub(b.Time) > p.MaxAge:
+			expired[b.Key] = true
+		default:
+			kept = append(kept, b)
+		}
+	}
+
+	if p.MaxSize > 0 {
+		var total int64
+		for _, b := range kept {
+			total += b.Size
+		}
+		// The oldest backups are removed first
+		for i := len(kept) - 1; i >= 0 && total > p.MaxSize; i-- {
+			expired[kept[i].Key] = true
+			total -= kept[i].Size
+		}
+	}
+
+	var result []Backup
+	for _, b := range backups {
+		if expired[b.Key] {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// Prune removes the backups in the storage exceeding the policy and returns them
+func Prune(ctx context.Context, s Storage, p Policy, now time.Time) ([]Backup, error) {
+	if p.IsZero() {
+		return nil, nil
+	}
+
+	backups, err := List(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	expired := p.Expired(backups, now)
+	for i, b := range expired {
+		if err := Remove(ctx, s, b); err != nil {
+			return expired[:i], fmt.Errorf("failed to remove backup: %w", err)
+		}
+	}
+	return expired, nil
+}
+
+// sizeUnits are the units accepted by ParseSize, the longest first
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseSize parses a size in bytes with an optional unit, e.g. "500MB", "2GiB" or "1024"
+func ParseSize(s string) (int64, error) {
+	num := strings.TrimSpace(s)
+	factor := int64(1)
+	for _, u := range sizeUnits {
+		if len(num) > len(u.suffix) && strings.EqualFold(num[len(num)-len(u.suffix):], u.suffix) {
+			num, factor = strings.TrimSpace(num[:len(num)-len(u.suffix)]), u.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(factor)), nil
+}
@@ -0,0 +1,336 @@
+package backup
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty body, as signed for requests without body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// unsignedPayload is signed instead of the hash of uploads, so they can be streamed
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3 is a Storage in a bucket of S3 or of an S3 compatible object store, e.g. MinIO.
+// Requests are signed with AWS Signature Version 4.
+type S3 struct {
+	// Endpoint is the URL of the object store, the bucket is addressed in the host name
+	// unless PathStyle is set
+	Endpoint  string
+	PathStyle bool
+	Bucket    string
+	// Prefix is prepended to the keys of the objects
+	Prefix string
+	Region string
+
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	Client *http.Client
+}
+
+// NewS3 returns the storage of s3://bucket/prefix. The region is taken from the region
+// parameter, $AWS_REGION or $AWS_DEFAULT_REGION, and the credentials from
+// $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and $AWS_SESSION_TOKEN. The endpoint
+// parameter selects an S3 compatible object store, addressed with path-style requests.
+func NewS3(u *url.URL) (*S3, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid backup storage URL %q: no bucket", u.Redacted())
+	}
+
+	q := u.Query()
+	s := &S3{
+		Bucket:       u.Host,
+		Prefix:       strings.Trim(u.Path, "/"),
+		Region:       q.Get("region"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		Client:       http.DefaultClient,
+	}
+	if s.Prefix != "" {
+		s.Prefix += "/"
+	}
+	if s.Region == "" {
+		s.Region = os.Getenv("AWS_REGION")
+	}
+	if s.Region == "" {
+		s.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return nil, errors.New("S3 backup storage requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	if endpoint := q.Get("endpoint"); endpoint != "" {
+		s.Endpoint = strings.TrimSuffix(endpoint, "/")
+		s.PathStyle = true
+	} else {
+		s.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.Region)
+	}
+	return s, nil
+}
+
+// url returns the URL of the object key, or of the bucket if key is empty
+func (s *S3) url(key string) (*url.URL, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	path := "/"
+	if s.PathStyle {
+		path += s.Bucket + "/"
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	if key != "" {
+		path += s.Prefix + key
+	}
+	u.Path = path
+	u.RawPath = uriEncode(path, false)
+	return u, nil
+}
+
+// do signs and sends the request, responses other than 2xx are returned as error
+func (s *S3) do(ctx context.Context, method string, u *url.URL, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		req.ContentLength = size
+		payloadHash = unsignedPayload
+	}
+	s.sign(req, payloadHash, time.Now())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return resp, s3Error(resp)
+	}
+	return resp, nil
+}
+
+// s3Error returns the error of the response, with the code and message of S3
+func s3Error(resp *http.Response) error {
+	var e struct {
+		Code    string
+		Message string
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("S3 responded with %s: %s: %s", resp.Status, e.Code, e.Message)
+	}
+	return fmt.Errorf("S3 responded with %s", resp.Status)
+}
+
+// Put implements Storage
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	u, err := s.url(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, u, r, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get implements Storage
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	u, err := s.url(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, u, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete implements Storage
+func (s *S3) Delete(ctx context.Context, key string) error {
+	u, err := s.url(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, u, nil, 0)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listBucketResult is the response of ListObjectsV2
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List implements Storage, the objects below the prefix are listed with ListObjectsV2
+func (s *S3) List(ctx context.Context) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		u, err := s.url("")
+		if err != nil {
+			return nil, err
+		}
+		q := url.Values{"list-type": {"2"}, "prefix": {s.Prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = canonicalQuery(q)
+
+		resp, err := s.do(ctx, http.MethodGet, u, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid response listing objects: %w", err)
+		}
+
+		for _, c := range result.Contents {
+			key := strings.TrimPrefix(c.Key, s.Prefix)
+			if key == "" || strings.HasSuffix(key, "/") || isMetadata(key) {
+				continue
+			}
+			objects = append(objects, Object{Key: key, Size: c.Size, Modified: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.Bucket, s.Prefix)
+}
+
+// sign adds the AWS Signature Version 4 of the request at t to its headers. The host
+// and all headers set on the request are signed.
+func (s *S3) sign(req *http.Request, payloadHash string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the query sorted by name and value, encoded as signed
+func canonicalQuery(q url.Values) string {
+	var params []string
+	for name, values := range q {
+		for _, v := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent-encodes all characters of s but the unreserved ones, and slashes
+// unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hashHex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,231 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SFTP is a Storage in a directory of an SFTP server, accessed with the sftp command of
+// OpenSSH in batch mode. Authentication must not be interactive, e.g. with keys of the
+// SSH agent, the host is configured by the ssh configuration as usual.
+type SFTP struct {
+	// Host is the host to connect to, as [user@]host
+	Host string
+	Port string
+	Dir  string
+	// Command is the sftp command, "sftp" if empty
+	Command string
+}
+
+// NewSFTP returns the storage of sftp://[user@]host[:port]/path, relative paths start
+// with /~/
+func NewSFTP(u *url.URL) (*SFTP, error) {
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid backup storage URL %q: no host", u.Redacted())
+	}
+	if _, ok := u.User.Password(); ok {
+		return nil, fmt.Errorf("invalid backup storage URL %q: passwords aren't supported, use keys", u.Redacted())
+	}
+
+	s := &SFTP{Host: u.Hostname(), Port: u.Port(), Dir: u.Path}
+	if u.User != nil {
+		s.Host = u.User.Username() + "@" + s.Host
+	}
+	if rel, ok := strings.CutPrefix(s.Dir, "/~/"); ok {
+		s.Dir = rel
+	}
+	if s.Dir == "" || s.Dir == "/~" {
+		s.Dir = "."
+	}
+	return s, nil
+}
+
+func (s *SFTP) path(key string) string {
+	return path.Join(s.Dir, key)
+}
+
+// run runs the batch of commands, which fails on the first failed command unless it is
+// prefixed with "-", and returns the output
+func (s *SFTP) run(ctx context.Context, commands ...string) (string, error) {
+	command := s.Command
+	if command == "" {
+		command = "sftp"
+	}
+	args := []string{"-b", "-", "-q"}
+	if s.Port != "" {
+		args = append(args, "-P", s.Port)
+	}
+	args = append(args, s.Host)
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("sftp failed: %s", msg)
+		}
+		return "", fmt.Errorf("sftp failed: %w", err)
+	}
+	return stdout.String(), nil
+}
+
+// Put implements Storage, the content is staged in a temporary file
+func (s *SFTP) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	local, cleanup, err := localFile(r)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	target := s.path(key)
+	var commands []string
+	for dir := path.Dir(target); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		commands = append([]string{"-mkdir " + sftpQuote(dir)}, commands...)
+	}
+	commands = append(commands, "put "+sftpQuote(local)+" "+sftpQuote(target))
+	_, err = s.run(ctx, commands...)
+	return err
+}
+
+// localFile returns the path of a file with the content of r, r itself if it is a file
+func localFile(r io.Reader) (string, func(), error) {
+	if f, ok := r.(*os.File); ok {
+		return f.Name(), func() {}, nil
+	}
+
+	tmp, err := os.CreateTemp("", "axion-backup-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return tmp.Name(), cleanup, nil
+}
+
+// Get implements Storage, the content is downloaded to a temporary file removed once
+// closed
+func (s *SFTP) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	tmp, err := os.CreateTemp("", "axion-backup-*")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+
+	if _, err := s.run(ctx, "get "+sftpQuote(s.path(key))+" "+sftpQuote(tmp.Name())); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tempFile{f}, nil
+}
+
+// tempFile is a file removed once closed
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// Delete implements Storage, errors are ignored as sftp doesn't tell missing files apart
+func (s *SFTP) Delete(ctx context.Context, key string) error {
+	_, err := s.run(ctx, "-rm "+sftpQuote(s.path(key)))
+	return err
+}
+
+// List implements Storage, each directory is listed with a batch of its own
+func (s *SFTP) List(ctx context.Context) ([]Object, error) {
+	var objects []Object
+	var list func(dir string) error
+	list = func(dir string) error {
+		out, err := s.run(ctx, "ls -l "+sftpQuote(s.path(dir)))
+		if err != nil {
+			return err
+		}
+		for _, e := range parseLongListing(out) {
+			key := path.Join(dir, e.name)
+			switch {
+			case e.dir:
+				if !strings.HasPrefix(e.name, ".") {
+					if err := list(key); err != nil {
+						return err
+					}
+				}
+			case !strings.HasPrefix(e.name, ".") && !isMetadata(e.name):
+				objects = append(objects, Object{Key: key, Size: e.size})
+			}
+		}
+		return nil
+	}
+
+	if err := list(""); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (s *SFTP) String() string {
+	return fmt.Sprintf("sftp://%s/%s", s.Host, strings.TrimPrefix(s.Dir, "/"))
+}
+
+// listingEntry is an entry of the long listing of a directory
+type listingEntry struct {
+	name string
+	dir  bool
+	size int64
+}
+
+// longListingPattern matches the entries of ls -l, capturing the type, the size and the
+// name
+var longListingPattern = regexp.MustCompile(`^([-dl])\S*\s+\d+\s+\S+\s+\S+\s+(\d+)\s+\S+\s+\S+\s+\S+\s(.+)$`)
+
+// parseLongListing returns the files and directories of the output of ls -l, the echoed
+// commands and other lines are skipped
+func parseLongListing(out string) []listingEntry {
+	var entries []listingEntry
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		m := longListingPattern.FindStringSubmatch(sc.Text())
+		if m == nil || m[1] == "l" {
+			continue
+		}
+		name := path.Base(m[3])
+		if name == "." || name == ".." {
+			continue
+		}
+		size, _ := strconv.ParseInt(m[2], 10, 64)
+		entries = append(entries, listingEntry{name: name, dir: m[1] == "d", size: size})
+	}
+	return entries
+}
+
+// sftpQuote quotes the path for a command of a batch
+func sftpQuote(p string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(p) + `"`
+}
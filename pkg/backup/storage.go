@@ -0,0 +1,189 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Object is a file in a Storage
+type Object struct {
+	// Key is the path of the object relative to the root of the storage, separated by
+	// slashes
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// Storage stores backups by key
+type Storage interface {
+	// Put stores the size bytes read from r as key, replacing an existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get returns the content of key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes key, keys which don't exist are ignored
+	Delete(ctx context.Context, key string) error
+	// List returns all objects of the storage
+	List(ctx context.Context) ([]Object, error)
+	// String describes the storage for messages
+	String() string
+}
+
+// Open returns the storage given by target: s3://bucket/prefix for an S3 bucket (see
+// NewS3), sftp://user@host:port/path for a directory on an SFTP server (see NewSFTP)
+// or a local directory, as path or file URL.
+func Open(target string) (Storage, error) {
+	scheme, _, ok := strings.Cut(target, "://")
+	if !ok {
+		return Dir(target), nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup storage URL: %w", err)
+	}
+	switch scheme {
+	case "file":
+		return Dir(u.Path), nil
+	case "s3":
+		return NewS3(u)
+	case "sftp":
+		return NewSFTP(u)
+	default:
+		return nil, fmt.Errorf("invalid backup storage URL %q: unsupported scheme %q, expected s3, sftp or file", target, scheme)
+	}
+}
+
+// Dir is a Storage in a local directory, e.g. the backup directory
+type Dir string
+
+func (d Dir) path(key string) string {
+	return filepath.Join(string(d), filepath.FromSlash(key))
+}
+
+// Put implements Storage, the object is only replaced once written completely
+func (d Dir) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get implements Storage
+func (d Dir) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(d.path(key))
+}
+
+// Delete implements Storage
+func (d Dir) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(d.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List implements Storage, the objects of subdirectories, e.g. of the hosts of
+// inventories, are included. Hidden files, e.g. backups being written, ETags and
+// checksums are left out.
+func (d Dir) List(ctx context.Context) ([]Object, error) {
+	root := string(d)
+	var objects []Object
+	err := filepath.WalkDir(root, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		name := e.Name()
+		if path != root && strings.HasPrefix(name, ".") {
+			if e.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if e.IsDir() || !e.Type().IsRegular() || isMetadata(name) {
+			return nil
+		}
+
+		fi, err := e.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: filepath.ToSlash(rel), Size: fi.Size(), Modified: fi.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+func (d Dir) String() string {
+	return string(d)
+}
+
+// prefixed is a Storage keeping its objects below a prefix of another one
+type prefixed struct {
+	Storage
+	prefix string
+}
+
+// WithPrefix returns a Storage keeping its objects below prefix in s, e.g. to keep the
+// backups of hosts apart
+func WithPrefix(s Storage, prefix string) Storage {
+	return prefixed{Storage: s, prefix: strings.Trim(prefix, "/") + "/"}
+}
+
+func (p prefixed) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	return p.Storage.Put(ctx, p.prefix+key, r, size)
+}
+
+func (p prefixed) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return p.Storage.Get(ctx, p.prefix+key)
+}
+
+func (p prefixed) Delete(ctx context.Context, key string) error {
+	return p.Storage.Delete(ctx, p.prefix+key)
+}
+
+func (p prefixed) List(ctx context.Context) ([]Object, error) {
+	all, err := p.Storage.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var objects []Object
+	for _, o := range all {
+		if key, ok := strings.CutPrefix(o.Key, p.prefix); ok {
+			o.Key = key
+			objects = append(objects, o)
+		}
+	}
+	return objects, nil
+}
+
+func (p prefixed) String() string {
+	return strings.TrimSuffix(p.Storage.String(), "/") + "/" + p.prefix
+}
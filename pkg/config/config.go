@@ -1,23 +1,149 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"peertech.de/axion/api/client"
+	"peertech.de/axion/pkg/backup"
+	"peertech.de/axion/pkg/secret"
 )
 
-const BackupEnvVar = "AXION_BACKUP_DIR"
+const (
+	BackupEnvVar = "AXION_BACKUP_DIR"
+	StateEnvVar  = "AXION_STATE_DIR"
+	TokenEnvVar  = "AXION_TOKEN"
+)
 
 type Config struct {
 	EnableBackups bool
 	BackupDir     string
-	Concurrency   int
+	// AgentBackups keeps the backups on the agent if it supports them, instead of
+	// transferring them to BackupDir
+	AgentBackups bool
+	// BackupRetention limits the backups kept in BackupDir and BackupStorage, they are
+	// pruned after successful runs
+	BackupRetention backup.Policy
+	// BackupStorage is the URL of the remote storage backups are copied to, see
+	// backup.Open, RemoteBackups is set up from it
+	BackupStorage string
+	RemoteBackups backup.Storage `yaml:"-"`
+	// DiscardBackups removes the backups taken by an apply once all its changes were
+	// applied, keeping only those of failed runs
+	DiscardBackups bool
+	Concurrency    int
+
+	// InferDependencies makes resources managing a path depend on the resource managing
+	// the closest parent directory.
+	InferDependencies bool
+
+	// Variables override or extend the variables declared in a manifest. They are
+	// merged into the manifest variables before templating takes place.
+	Variables map[string]any
+
+	// AllowedEnv lists the environment variables manifests may read, "*" allows access to
+	// all of them. Without entries manifests cannot read the environment.
+	AllowedEnv []string
+
+	// Secrets resolves secret references in variables and tracks sensitive values for
+	// redaction. If nil, references are left untouched.
+	Secrets *secret.Resolver
+
+	// TLS configures the client certificate presented to the agent and the CA verifying
+	// the agent certificate.
+	TLS TLSConfig
+
+	// TokenFile is the path of the file holding the bearer token the client presents to
+	// the agent, e.g. to be authorized by a role of its policy
+	TokenFile string
+
+	// BearerToken is the bearer token presented to the agent, it takes precedence over
+	// TokenFile
+	BearerToken string `yaml:"token"`
+
+	// SigningSecretFile is the path of the file holding the secret REST requests are
+	// signed with, for agents requiring signed requests
+	SigningSecretFile string
+
+	// Capabilities are the optional API features the agent supports, nil if they are
+	// unknown, in which case all are assumed
+	Capabilities []string
 
 	Client *client.ConfigurationManagement
 }
 
+// Supports reports whether the agent supports the API feature, see the capabilities in
+// the version package
+func (c *Config) Supports(capability string) bool {
+	return c.Capabilities == nil || slices.Contains(c.Capabilities, capability)
+}
+
+// Token returns the bearer token, read from TokenFile unless BearerToken is set, empty
+// if there is none
+func (c *Config) Token() (string, error) {
+	if c.BearerToken != "" {
+		return c.BearerToken, nil
+	}
+	if c.TokenFile == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(c.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// TLSConfig holds the paths of the PEM encoded files used for TLS connections to the
+// agent. Without a CA the system roots are used.
+type TLSConfig struct {
+	CertFile   string
+	KeyFile    string
+	CAFile     string
+	ServerName string
+
+	// InsecureSkipVerify accepts any agent certificate, for tests against agents with
+	// self-signed certificates only
+	InsecureSkipVerify bool
+}
+
+// ClientConfig returns the TLS configuration of connections to the agent, as used by
+// transports not built on the HTTP client
+func (c TLSConfig) ClientConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA %q", c.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
+
 func DefaultBackupDir() string {
 	if env := os.Getenv(BackupEnvVar); env != "" {
 		return env
@@ -29,6 +155,18 @@ func DefaultBackupDir() string {
 	return filepath.Join(home, ".config", "axion", "backups")
 }
 
+// DefaultStateDir returns the directory holding the state axionctl keeps of each host.
+func DefaultStateDir() string {
+	if env := os.Getenv(StateEnvVar); env != "" {
+		return env
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "/tmp/axionctl/state"
+	}
+	return filepath.Join(home, ".config", "axion", "state")
+}
+
 func ValidateBackupDir(path string) error {
 	if path == "" {
 		return fmt.Errorf("backup directory is empty")
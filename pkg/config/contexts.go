@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ContextsEnvVar overrides the path of the file holding the contexts
+const ContextsEnvVar = "AXION_CONFIG"
+
+// Contexts are named sets of connection settings, so the agent to talk to can be
+// selected by name instead of passing its endpoint and credentials every time
+type Contexts struct {
+	// Current is the context used if none is selected
+	Current  string             `yaml:"current-context,omitempty"`
+	Contexts map[string]Context `yaml:"contexts,omitempty"`
+}
+
+// Context holds the settings of connections to an agent, empty values are left to the
+// flags and the config file
+type Context struct {
+	Endpoint  string    `yaml:"endpoint,omitempty"`
+	TLS       TLSConfig `yaml:"tls,omitempty"`
+	TokenFile string    `yaml:"tokenfile,omitempty"`
+	BackupDir string    `yaml:"backupdir,omitempty"`
+}
+
+// DefaultContextsFile returns the path of the file holding the contexts
+func DefaultContextsFile() string {
+	if env := os.Getenv(ContextsEnvVar); env != "" {
+		return env
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "/tmp/axionctl/config"
+	}
+	return filepath.Join(home, ".config", "axion", "config")
+}
+
+// LoadContexts reads the contexts from the file at path, there are none if it doesn't
+// exist
+func LoadContexts(path string) (*Contexts, error) {
+	c := &Contexts{}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contexts: %w", err)
+	}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse contexts %q: %w", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the contexts to the file at path
+func (c *Contexts) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Write atomically so a concurrent reader never sees partial contexts
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Get returns the context called name
+func (c *Contexts) Get(name string) (Context, error) {
+	ctx, ok := c.Contexts[name]
+	if !ok {
+		return Context{}, fmt.Errorf("context %q doesn't exist", name)
+	}
+	return ctx, nil
+}
+
+// Names returns the names of the contexts in alphabetical order
+func (c *Contexts) Names() []string {
+	names := make([]string, 0, len(c.Contexts))
+	for name := range c.Contexts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
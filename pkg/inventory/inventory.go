@@ -0,0 +1,76 @@
+// Package inventory reads the hosts a manifest is applied to in a single run.
+//
+//	hosts:
+//	  - name: web1
+//	    endpoint: https://web1.example.com:8080
+//	    variables:
+//	      role: primary
+//	  - endpoint: https://web2.example.com:8080
+package inventory
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Inventory lists the hosts of a run
+type Inventory struct {
+	Hosts []Host `yaml:"hosts"`
+}
+
+// Host is an agent a manifest is applied to
+type Host struct {
+	// Name identifies the host in the output, the host of the endpoint if empty
+	Name string `yaml:"name"`
+
+	// Endpoint is the URL of the agent
+	Endpoint string `yaml:"endpoint"`
+
+	// Variables override the manifest variables for this host
+	Variables map[string]any `yaml:"variables"`
+}
+
+// Load reads and validates the inventory at path
+func Load(path string) (*Inventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse parses and validates an inventory, hosts without a name are named after the
+// host of their endpoint
+func Parse(data []byte) (*Inventory, error) {
+	var inv Inventory
+	if err := yaml.Unmarshal(data, &inv); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory: %w", err)
+	}
+	if len(inv.Hosts) == 0 {
+		return nil, fmt.Errorf("inventory has no hosts")
+	}
+
+	names := make(map[string]bool, len(inv.Hosts))
+	for i := range inv.Hosts {
+		h := &inv.Hosts[i]
+		if h.Endpoint == "" {
+			return nil, fmt.Errorf("host %d: endpoint is required", i+1)
+		}
+		u, err := url.Parse(h.Endpoint)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("host %d: invalid endpoint %q", i+1, h.Endpoint)
+		}
+		if h.Name == "" {
+			h.Name = u.Host
+		}
+		if names[h.Name] {
+			return nil, fmt.Errorf("host %d: duplicate name %q", i+1, h.Name)
+		}
+		names[h.Name] = true
+	}
+
+	return &inv, nil
+}
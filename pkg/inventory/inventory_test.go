@@ -0,0 +1,46 @@
+package inventory_test
+
+import (
+	"testing"
+
+	"peertech.de/axion/pkg/inventory"
+)
+
+func TestParse(t *testing.T) {
+	inv, err := inventory.Parse([]byte(`
+hosts:
+  - name: web1
+    endpoint: https://web1:8080
+    variables:
+      role: primary
+  - endpoint: grpcs://web2:9090
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inv.Hosts) != 2 {
+		t.Fatalf("expected 2 hosts, got %d", len(inv.Hosts))
+	}
+	if h := inv.Hosts[0]; h.Name != "web1" || h.Variables["role"] != "primary" {
+		t.Errorf("unexpected host %+v", h)
+	}
+	if h := inv.Hosts[1]; h.Name != "web2:9090" {
+		t.Errorf("expected the name to default to the endpoint host, got %q", h.Name)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":          `hosts: []`,
+		"no endpoint":    "hosts:\n  - name: web1\n",
+		"invalid url":    "hosts:\n  - endpoint: web1\n",
+		"duplicate name": "hosts:\n  - endpoint: https://web1\n  - name: web1\n    endpoint: https://web2\n",
+	}
+
+	for name, data := range tests {
+		if _, err := inventory.Parse([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package cue
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	cueerrors "cuelang.org/go/cue/errors"
+
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/manifest"
+	"peertech.de/axion/pkg/manifest/remote"
+	"peertech.de/axion/pkg/orchestrator"
+	"peertech.de/axion/pkg/resource"
+	"peertech.de/axion/pkg/secret"
+)
+
+// schemaFile is the file name the embedded schema is compiled as, used to tell schema
+// positions apart from manifest positions in errors
+const schemaFile = "schema.cue"
+
+//go:embed schema.cue
+var schema string
+
+// Manifest is the decoded structure of a CUE manifest after it has been unified with
+// the schema
+type Manifest struct {
+	Variables map[string]any `json:"variables" yaml:"variables,omitempty"`
+	Resources []Resource     `json:"resources" yaml:"resources"`
+}
+
+// Resource represents a single resource definition in the manifest. Before is the
+// inverse of Dependencies: the listed resources depend on this resource.
+type Resource struct {
+	Id           string            `json:"id" yaml:"id"`
+	Type         string            `json:"type" yaml:"type"`
+	State        string            `json:"state" yaml:"state,omitempty"`
+	Properties   map[string]any    `json:"properties" yaml:"properties,omitempty"`
+	Dependencies []string          `json:"dependencies" yaml:"dependencies,omitempty"`
+	Before       []string          `json:"before" yaml:"before,omitempty"`
+	Options      *manifest.Options `json:"options" yaml:"options,omitempty"`
+}
+
+// Loader implements the manifest.Loader interface for CUE manifests. Resource definitions
+// are validated against the CUE schema at load time, constraint violations are reported
+// as *manifest.SchemaError with their position in the manifest.
+type Loader struct{}
+
+// Load evaluates a CUE manifest and extracts resource specifications
+func (l *Loader) Load(ctx context.Context, cfg *config.Config, path string) ([]orchestrator.ResourceSpec, error) {
+	overrides, err := resolveOverrides(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("manifest load error [%s]: %w", path, err)
+	}
+	m, err := load(path, overrides, cfg.Secrets)
+	if err != nil {
+		return nil, fmt.Errorf("manifest load error [%s]: %w", path, err)
+	}
+
+	var out []orchestrator.ResourceSpec
+	for _, spec := range m.Resources {
+		r, err := instantiateResource(cfg, spec)
+		if err != nil {
+			return nil, fmt.Errorf("manifest error: %s", err.Error())
+		}
+		opts, err := manifest.SpecOptions(spec.Options)
+		if err != nil {
+			return nil, fmt.Errorf("manifest error: invalid options for %q: %s", spec.Id, err.Error())
+		}
+		out = append(out, orchestrator.ResourceSpec{
+			Id:           spec.Id,
+			Resource:     r,
+			Dependencies: spec.Dependencies,
+			Options:      opts,
+			Digest:       manifest.ResourceDigest(spec.Type, spec.State, spec.Properties),
+		})
+	}
+
+	return out, nil
+}
+
+// resolveOverrides returns the variables overriding the ones of the manifest with
+// remote variables fetched and secret references resolved, as in YAML and Starlark
+// manifests. CUE manifests can't read the environment, so the env allowlist doesn't
+// apply.
+func resolveOverrides(ctx context.Context, cfg *config.Config) (map[string]any, error) {
+	vars, err := remote.NewResolver().ResolveVariables(ctx, cfg.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("remote variables error: %w", err)
+	}
+	vars, err = cfg.Secrets.ResolveVariables(ctx, vars)
+	if err != nil {
+		return nil, fmt.Errorf("resolve variables error: %w", err)
+	}
+	return vars, nil
+}
+
+// checkReferences returns an error if a variable declared in the manifest is a remote
+// variable or a secret reference. The references to variables are evaluated along with
+// the manifest, so these can't be resolved beforehand and must be given as overrides,
+// e.g. with --var-file, instead of being used unresolved.
+func checkReferences(declared, overrides map[string]any, secrets *secret.Resolver) error {
+	for name, v := range declared {
+		if _, ok := overrides[name]; ok {
+			continue
+		}
+		if remote.IsRemote(v) || secrets.IsReference(v) {
+			return fmt.Errorf("variable %q: remote variables and secret references aren't supported in CUE manifests, pass them with --var or --var-file", name)
+		}
+	}
+	return nil
+}
+
+// load evaluates the CUE manifest at path, unifies it with the schema and decodes the
+// result. The overrides are unified with the variables of the manifest, so they must
+// not conflict with concrete values declared there. secrets tells secret references
+// apart, see checkReferences.
+func load(path string, overrides map[string]any, secrets *secret.Resolver) (*Manifest, error) {
+	raw, err := manifest.Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest file error: %w", err)
+	}
+
+	cctx := cuecontext.New()
+
+	def := cctx.CompileString(schema, cue.Filename(schemaFile)).
+		LookupPath(cue.ParsePath("#Manifest"))
+	if err := def.Err(); err != nil {
+		return nil, fmt.Errorf("schema error: %w", err)
+	}
+
+	v := cctx.CompileBytes(raw, cue.Filename(path))
+	if err := v.Err(); err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+	if len(overrides) > 0 {
+		v = v.FillPath(cue.ParsePath("variables"), overrides)
+	}
+
+	v = def.Unify(v)
+	if err := v.Validate(cue.Concrete(true)); err != nil {
+		return nil, schemaError(path, err)
+	}
+
+	var m Manifest
+	if err := v.Decode(&m); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	if err := checkReferences(m.Variables, overrides, secrets); err != nil {
+		return nil, err
+	}
+
+	err = manifest.ApplyBefore(m.Resources, func(r *Resource) (string, *[]string, *[]string) {
+		return r.Id, &r.Before, &r.Dependencies
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// schemaError converts CUE evaluation errors into a *manifest.SchemaError. Each issue is
+// reported at the first position within the manifest, positions within the schema only
+// point at the violated constraint.
+func schemaError(path string, err error) error {
+	var issues []manifest.SchemaIssue
+	for _, e := range cueerrors.Errors(err) {
+		pos := manifest.Position{File: path}
+		for _, p := range cueerrors.Positions(e) {
+			if p.IsValid() && p.Filename() != schemaFile {
+				pos.Line, pos.Column = p.Line(), p.Column()
+				break
+			}
+		}
+		issues = append(issues, manifest.SchemaIssue{Position: pos, Message: e.Error()})
+	}
+	return &manifest.SchemaError{Issues: issues}
+}
+
+// instantiateResource creates a concrete resource object from a resource specification.
+// The schema already guarantees the properties are present and well-formed, Validate is
+// still called as the resources may enforce additional constraints.
+func instantiateResource(cfg *config.Config, res Resource) (resource.Resource, error) {
+	var r resource.Resource
+
+	if err := manifest.CheckOptions(res.Type, res.Options); err != nil {
+		return nil, fmt.Errorf("invalid %q resource (id: %s): %w", res.Type, res.Id, err)
+	}
+
+	props := res.Properties
+	switch res.Type {
+	case "command":
+		r = resource.NewCommand(cfg, manifest.ToString(props["command"]), manifest.CommandOptions(res.Options)...)
+	case "file":
+		r = resource.NewFile(
+			cfg,
+			resource.State(res.State),
+			manifest.ToString(props["path"]),
+			manifest.OptString(props["mode"]),
+			manifest.OptString(props["owner"]),
+			manifest.OptString(props["group"]),
+		)
+	case "directory":
+		r = resource.NewDirectory(
+			cfg,
+			resource.State(res.State),
+			manifest.ToString(props["path"]),
+			manifest.OptString(props["mode"]),
+			manifest.OptString(props["owner"]),
+			manifest.OptString(props["group"]),
+			manifest.DirectoryOptions(res.Options)...,
+		)
+	case "symlink":
+		// Target is optional for absent symlinks
+		target, _ := props["target"].(string)
+		force, _ := props["force"].(bool)
+		r = resource.NewSymlink(
+			cfg,
+			resource.State(res.State),
+			manifest.ToString(props["path"]),
+			target,
+			force,
+		)
+	case "package":
+		version, _ := props["version"].(string)
+		r = resource.NewPackage(
+			cfg,
+			resource.State(res.State),
+			manifest.ToString(props["name"]),
+			version,
+			manifest.OptBool(props["pinned"]),
+		)
+	case "service":
+		r = resource.NewService(
+			cfg,
+			manifest.ToString(props["name"]),
+			manifest.OptBool(props["running"]),
+			manifest.OptBool(props["enabled"]),
+		)
+	default:
+		return nil, fmt.Errorf("unsupported resource type %q", res.Type)
+	}
+
+	if v, ok := r.(resource.Validatable); ok {
+		if err := v.Validate(); err != nil {
+			return nil, fmt.Errorf(
+				"invalid %q resource (id: %s): %s", res.Type, res.Id, err.Error(),
+			)
+		}
+	}
+
+	return r, nil
+}
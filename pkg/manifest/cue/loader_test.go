@@ -0,0 +1,154 @@
+package cue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"peertech.de/axion/pkg/manifest"
+	"peertech.de/axion/pkg/secret"
+)
+
+func writeManifest(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "manifest.cue")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeManifest(t, `
+variables: owner: *"root" | string
+
+resources: [{
+	id:   "config"
+	type: "file"
+	properties: {
+		path:  "/etc/app.conf"
+		mode:  "0640"
+		owner: variables.owner
+	}
+	dependencies: ["dir"]
+}, {
+	id:   "dir"
+	type: "directory"
+	properties: path: "/etc/app"
+}, {
+	id:     "cleanup"
+	type:   "command"
+	before: ["dir"]
+	properties: command: "rm -rf /etc/app.old"
+}]
+`)
+
+	m, err := load(path, map[string]any{"owner": "app"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Resources) != 3 {
+		t.Fatalf("expected 3 resources, got %d", len(m.Resources))
+	}
+
+	config := m.Resources[0]
+	if config.State != "present" {
+		t.Errorf("expected default state present, got %q", config.State)
+	}
+	if owner := config.Properties["owner"]; owner != "app" {
+		t.Errorf("expected overridden owner %q, got %q", "app", owner)
+	}
+	if deps := m.Resources[1].Dependencies; !slices.Equal(deps, []string{"cleanup"}) {
+		t.Errorf("expected dependency on cleanup, got %v", deps)
+	}
+}
+
+func TestLoadSchemaValidation(t *testing.T) {
+	path := writeManifest(t, `
+resources: [{
+	id:   "a"
+	type: "file"
+	properties: {
+		path: "/tmp/a.txt"
+		mode: "rw-r--r--"
+	}
+}, {
+	id:    "b"
+	type:  "directory"
+	state: "missing"
+	properties: path: "/tmp/b"
+}]
+`)
+
+	_, err := load(path, nil, nil)
+
+	var schemaErr *manifest.SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("expected schema error, got %v", err)
+	}
+	if len(schemaErr.Issues) < 2 {
+		t.Fatalf("expected an issue per invalid resource, got %v", err)
+	}
+	for _, issue := range schemaErr.Issues {
+		if issue.Position.File != path || issue.Position.Line == 0 {
+			t.Errorf("expected issue positioned within the manifest, got %q", issue.String())
+		}
+	}
+}
+
+func TestLoadOptions(t *testing.T) {
+	path := writeManifest(t, `
+resources: [{
+	id:   "migrate"
+	type: "command"
+	properties: command: "./migrate.sh"
+	options: {
+		timeout: "5m"
+		retries: 3
+		backup:  false
+	}
+}]
+`)
+
+	m, err := load(path, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := manifest.SpecOptions(m.Resources[0].Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Timeout != 5*time.Minute || opts.Retries != 3 {
+		t.Errorf("unexpected timeout %s or retries %d", opts.Timeout, opts.Retries)
+	}
+	if opts.Backup == nil || *opts.Backup {
+		t.Errorf("expected backup to be disabled")
+	}
+}
+
+func TestLoadReferences(t *testing.T) {
+	secrets := secret.NewResolver()
+	for name, variable := range map[string]string{
+		"remote": `{source: "http", url: "https://config.example.com/db.json"}`,
+		"secret": `"vault:secret/data/app#password"`,
+	} {
+		path := writeManifest(t, `
+variables: db: `+variable+`
+
+resources: [{
+	id:   "config"
+	type: "file"
+	properties: path: "/etc/app.conf"
+}]
+`)
+
+		if _, err := load(path, nil, secrets); err == nil {
+			t.Errorf("%s: expected error for a reference declared in the manifest", name)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package cue
+
+import (
+	"context"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"peertech.de/axion/pkg/config"
+)
+
+// Render returns the manifest after it has been unified with the schema, i.e. with all
+// references and defaults resolved.
+func (l *Loader) Render(ctx context.Context, cfg *config.Config, path string) ([]byte, error) {
+	overrides, err := resolveOverrides(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("manifest load error [%s]: %w", path, err)
+	}
+	m, err := load(path, overrides, cfg.Secrets)
+	if err != nil {
+		return nil, fmt.Errorf("manifest load error [%s]: %w", path, err)
+	}
+
+	return yaml.Marshal(m)
+}
@@ -0,0 +1,78 @@
+// Schema of CUE manifests. Manifests are unified with #Manifest at load time, so every
+// resource is validated before any resource is instantiated.
+
+#Manifest: {
+	// Variables may be overridden from the command line, declare them with a default
+	// (e.g. owner: *"root" | string) to allow overrides.
+	variables?: {...}
+	resources: [...#Resource]
+}
+
+#State: "present" | "absent"
+
+// Octal permission bits, e.g. "0644"
+#Mode: =~"^[0-7]{3,4}$"
+
+#Resource: {
+	id:    string & !=""
+	type:  "file" | "directory" | "symlink" | "package" | "service" | "command"
+	dependencies?: [...string]
+	before?: [...string]
+	options?: #Options
+
+	if type == "file" {
+		state:      *"present" | #State
+		properties: #PathProperties
+	}
+	if type == "directory" {
+		state:      *"present" | #State
+		properties: #PathProperties
+	}
+	if type == "symlink" {
+		state: *"present" | #State
+		properties: {
+			path:    =~"^/"
+			target?: string & !=""
+			force?:  bool
+		}
+	}
+	if type == "package" {
+		state: *"present" | #State
+		properties: {
+			name:     =~"^[a-zA-Z0-9][a-zA-Z0-9+._-]*$"
+			version?: string & !=""
+			pinned?:  bool
+		}
+	}
+	if type == "service" {
+		properties: {
+			name:     string & !=""
+			running?: bool
+			enabled?: bool
+		}
+	}
+	if type == "command" {
+		properties: {
+			command: string & !=""
+		}
+	}
+}
+
+// Execution options, validated against the resource type when it is instantiated
+#Options: {
+	timeout?:    =~"^[0-9]" // duration, e.g. "5m"
+	retries?:    int & >=0
+	concurrent?: bool
+	backup?:     bool
+	tags?: [...string]
+	backup_include?: [...string]
+	backup_exclude?: [...string]
+	output?: "head" | "tail"
+}
+
+#PathProperties: {
+	path:   =~"^/"
+	mode?:  #Mode
+	owner?: string & !=""
+	group?: string & !=""
+}
@@ -0,0 +1,56 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"peertech.de/axion/pkg/orchestrator"
+)
+
+// Digest returns the digest of a loaded manifest in the form "sha256:<hex>". It covers
+// the manifest itself, the variables it was loaded with, e.g. set with --var, and the
+// resources it resolved to, so that it changes with the modules and files referenced by
+// the manifest as well. Variables which can't be encoded are left out.
+func Digest(data []byte, variables map[string]any, specs []orchestrator.ResourceSpec) string {
+	type spec struct {
+		Id           string   `json:"id"`
+		Digest       string   `json:"digest"`
+		Dependencies []string `json:"dependencies,omitempty"`
+	}
+	resources := make([]spec, 0, len(specs))
+	for _, s := range specs {
+		deps := append([]string(nil), s.Dependencies...)
+		sort.Strings(deps)
+		resources = append(resources, spec{s.Id, s.Digest, deps})
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Id < resources[j].Id })
+
+	h := sha256.New()
+	h.Write(data)
+	if vars, err := json.Marshal(variables); err == nil {
+		h.Write(vars)
+	}
+	if res, err := json.Marshal(resources); err == nil {
+		h.Write(res)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// ResourceDigest returns the digest of the specification of a resource, its type,
+// state and properties after templating, in the form "sha256:<hex>". It changes with the
+// variables and modules the resource depends on, but not with other resources. It is
+// empty if the properties can't be encoded.
+func ResourceDigest(typ, state string, properties map[string]any) string {
+	data, err := json.Marshal(struct {
+		Type       string         `json:"type"`
+		State      string         `json:"state,omitempty"`
+		Properties map[string]any `json:"properties,omitempty"`
+	}{typ, state, properties})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,52 @@
+package manifest
+
+import (
+	"testing"
+
+	"peertech.de/axion/pkg/orchestrator"
+)
+
+func TestDigest(t *testing.T) {
+	data := []byte("resources: []\n")
+	vars := map[string]any{"owner": "alice", "mode": "0644"}
+	specs := []orchestrator.ResourceSpec{
+		{Id: "a", Digest: "sha256:a"},
+		{Id: "b", Digest: "sha256:b", Dependencies: []string{"a"}},
+	}
+
+	digest := Digest(data, vars, specs)
+	reordered := []orchestrator.ResourceSpec{specs[1], specs[0]}
+	if digest != Digest(data, map[string]any{"mode": "0644", "owner": "alice"}, reordered) {
+		t.Errorf("expected the digest to be independent of the order of variables and resources")
+	}
+	if digest == Digest([]byte("resources: [] \n"), vars, specs) {
+		t.Error("expected the digest to change with the manifest")
+	}
+	if digest == Digest(data, map[string]any{"owner": "bob", "mode": "0644"}, specs) {
+		t.Error("expected the digest to change with the variables")
+	}
+	changed := []orchestrator.ResourceSpec{specs[0], {Id: "b", Digest: "sha256:c", Dependencies: []string{"a"}}}
+	if digest == Digest(data, vars, changed) {
+		t.Error("expected the digest to change with the resources, e.g. of a module")
+	}
+	if digest == Digest(data, vars, specs[:1]) {
+		t.Error("expected the digest to change with the set of resources")
+	}
+}
+
+func TestResourceDigest(t *testing.T) {
+	props := map[string]any{"path": "/etc/app.conf", "mode": "0644"}
+	digest := ResourceDigest("file", "present", props)
+	if digest == "" || digest != ResourceDigest("file", "present", map[string]any{"mode": "0644", "path": "/etc/app.conf"}) {
+		t.Errorf("expected the digest to be independent of the order of properties, got %q", digest)
+	}
+	if digest == ResourceDigest("file", "present", map[string]any{"path": "/etc/app.conf", "mode": "0600"}) {
+		t.Error("expected the digest to change with the properties")
+	}
+	if digest == ResourceDigest("file", "absent", props) {
+		t.Error("expected the digest to change with the state")
+	}
+	if got := ResourceDigest("file", "present", map[string]any{"fn": func() {}}); got != "" {
+		t.Errorf("expected no digest for properties which can't be encoded, got %q", got)
+	}
+}
@@ -0,0 +1,24 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"slices"
+)
+
+// AllEnv is the allowlist entry granting manifests access to all environment variables
+const AllEnv = "*"
+
+// Getenv returns the value of the environment variable name for use in manifests. Only
+// the variables listed in allowed may be read, or all of them if it contains AllEnv; any
+// other name results in an error so that manifests cannot silently pick up arbitrary parts
+// of the environment. Unset variables resolve to an empty string.
+func Getenv(allowed []string, name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("environment variable name cannot be empty")
+	}
+	if !slices.Contains(allowed, AllEnv) && !slices.Contains(allowed, name) {
+		return "", fmt.Errorf("access to environment variable %q is not allowed, see --allow-env", name)
+	}
+	return os.Getenv(name), nil
+}
@@ -0,0 +1,45 @@
+package manifest
+
+import (
+	"fmt"
+
+	"peertech.de/axion/pkg/config"
+)
+
+// Severity classifies a lint diagnostic
+type Severity int
+
+const (
+	// SeverityError marks problems that prevent the manifest from being applied
+	SeverityError Severity = iota
+	// SeverityWarning marks style issues that do not affect the outcome
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+// Diagnostic is a single problem found while linting a manifest
+type Diagnostic struct {
+	Severity Severity
+	Position Position
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Position, d.Severity, d.Message)
+}
+
+// Linter is implemented by loaders that can report format specific style issues, e.g.
+// variables that are declared but never used. Linting must not require an agent.
+type Linter interface {
+	Lint(cfg *config.Config, path string) ([]Diagnostic, error)
+}
@@ -0,0 +1,16 @@
+package manifest
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// CheckCycle returns an error if the module at path is already being loaded, stack
+// holds the paths of the modules being loaded by the loaders
+func CheckCycle(path string, stack []string) error {
+	if slices.Contains(stack, path) {
+		return fmt.Errorf("module cycle detected: %s", strings.Join(append(stack, path), " -> "))
+	}
+	return nil
+}
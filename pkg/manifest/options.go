@@ -0,0 +1,100 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"peertech.de/axion/pkg/orchestrator"
+	"peertech.de/axion/pkg/output"
+	"peertech.de/axion/pkg/resource"
+)
+
+// Options tune the execution of a single resource, as declared in YAML and CUE manifests
+type Options struct {
+	Timeout       string   `yaml:"timeout,omitempty" json:"timeout,omitempty"` // duration, e.g. "5m"
+	Retries       int      `yaml:"retries,omitempty" json:"retries,omitempty"`
+	Concurrent    *bool    `yaml:"concurrent,omitempty" json:"concurrent,omitempty"` // command resources only
+	Backup        *bool    `yaml:"backup,omitempty" json:"backup,omitempty"`
+	Tags          []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	BackupInclude []string `yaml:"backup_include,omitempty" json:"backup_include,omitempty"` // glob patterns, directory resources only
+	BackupExclude []string `yaml:"backup_exclude,omitempty" json:"backup_exclude,omitempty"` // glob patterns, directory resources only
+	Output        string   `yaml:"output,omitempty" json:"output,omitempty"`                 // head or tail, command resources only
+}
+
+// CheckOptions returns an error if opts hold options resources of type typ don't support
+func CheckOptions(typ string, opts *Options) error {
+	if opts == nil {
+		return nil
+	}
+
+	isCommand := typ == "command" || typ == "script"
+	if opts.Concurrent != nil && !isCommand {
+		return errors.New("concurrent is only supported by command and script resources")
+	}
+	if opts.Output != "" && !isCommand {
+		return errors.New("output is only supported by command and script resources")
+	}
+	if (len(opts.BackupInclude) > 0 || len(opts.BackupExclude) > 0) && typ != "directory" {
+		return errors.New("backup_include and backup_exclude are only supported by directory resources")
+	}
+	return nil
+}
+
+// CommandOptions returns the options of command and script resources set by opts
+func CommandOptions(opts *Options) []resource.CommandOption {
+	if opts == nil {
+		return nil
+	}
+
+	var out []resource.CommandOption
+	if opts.Concurrent != nil {
+		out = append(out, resource.WithConcurrent(*opts.Concurrent))
+	}
+	// Let the agent enforce the timeout as well
+	if d, err := time.ParseDuration(opts.Timeout); err == nil && d > 0 {
+		out = append(out, resource.WithTimeout(d))
+	}
+	if opts.Output != "" {
+		out = append(out, resource.WithOutputRetention(output.Retention(opts.Output)))
+	}
+	return out
+}
+
+// DirectoryOptions returns the options of directory resources set by opts
+func DirectoryOptions(opts *Options) []resource.DirectoryOption {
+	if opts == nil {
+		return nil
+	}
+	return []resource.DirectoryOption{resource.WithBackupFilter(opts.BackupInclude, opts.BackupExclude)}
+}
+
+// SpecOptions converts the manifest options of a resource into orchestrator options
+func SpecOptions(opts *Options) (orchestrator.SpecOptions, error) {
+	if opts == nil {
+		return orchestrator.SpecOptions{}, nil
+	}
+
+	spec := orchestrator.SpecOptions{
+		Retries: opts.Retries,
+		Backup:  opts.Backup,
+		Tags:    opts.Tags,
+	}
+
+	if opts.Retries < 0 {
+		return spec, fmt.Errorf("retries must not be negative")
+	}
+
+	if opts.Timeout != "" {
+		d, err := time.ParseDuration(opts.Timeout)
+		if err != nil {
+			return spec, fmt.Errorf("invalid timeout: %w", err)
+		}
+		if d <= 0 {
+			return spec, fmt.Errorf("timeout must be positive")
+		}
+		spec.Timeout = d
+	}
+
+	return spec, nil
+}
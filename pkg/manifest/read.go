@@ -0,0 +1,82 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Stdin is the manifest path denoting the standard input
+const Stdin = "-"
+
+// Format is the format of a manifest
+type Format string
+
+const (
+	FormatYAML     Format = "yaml"
+	FormatJSON     Format = "json"
+	FormatStarlark Format = "starlark"
+	FormatCUE      Format = "cue"
+)
+
+// yamlKeys matches the top level keys of YAML manifests, which aren't valid Starlark
+var yamlKeys = regexp.MustCompile(`(?m)^["']?(resources|variables|defaults)["']?[ \t]*:`)
+
+var stdin struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// Read returns the content of the manifest at path. If path is Stdin, the manifest is
+// read from the standard input. As stdin can only be consumed once, its content is
+// cached so that the manifest can be read multiple times, e.g. for format detection and
+// loading. Relative paths within a manifest read from stdin resolve against the working
+// directory.
+func Read(path string) ([]byte, error) {
+	if path != Stdin {
+		return os.ReadFile(path)
+	}
+
+	stdin.once.Do(func() {
+		stdin.data, stdin.err = io.ReadAll(os.Stdin)
+	})
+	return stdin.data, stdin.err
+}
+
+// DetectFormat determines the format of a manifest. A known file extension takes
+// precedence (CUE manifests are only detected by their .cue extension), otherwise the
+// format is derived from the content: JSON documents start with an object, YAML
+// manifests have at least one of the top level manifest keys and anything else is
+// considered Starlark. The keys are looked up line by line rather than by parsing the
+// manifest, as templated YAML is rarely valid YAML before templating.
+func DetectFormat(path string, data []byte) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".json":
+		return FormatJSON, nil
+	case ".star":
+		return FormatStarlark, nil
+	case ".cue":
+		return FormatCUE, nil
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return "", fmt.Errorf("cannot detect format of empty manifest %q", path)
+	}
+	if trimmed[0] == '{' {
+		return FormatJSON, nil
+	}
+
+	if yamlKeys.Match(trimmed) {
+		return FormatYAML, nil
+	}
+	return FormatStarlark, nil
+}
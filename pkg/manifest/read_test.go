@@ -0,0 +1,38 @@
+package manifest
+
+import "testing"
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		data     string
+		expected Format
+	}{
+		{name: "yaml extension", path: "site.yml", data: "x = 1", expected: FormatYAML},
+		{name: "starlark extension", path: "site.star", data: "resources: []", expected: FormatStarlark},
+		{name: "cue extension", path: "site.cue", data: "resources: []", expected: FormatCUE},
+		{name: "json content", path: Stdin, data: ` {"resources": []}`, expected: FormatJSON},
+		{name: "yaml content", path: Stdin, data: "# site\nresources:\n  - id: a\n", expected: FormatYAML},
+		{name: "templated yaml", path: Stdin, data: "variables:\n  users: [a, b]\nresources:\n{{ range .users }}\n  - id: {{ . }}\n    type: command\n{{ end }}\n", expected: FormatYAML},
+		{name: "templated values", path: Stdin, data: "resources:\n  - id: a\n    owner: {{ .owner }}\n", expected: FormatYAML},
+		{name: "starlark content", path: Stdin, data: `a = resources.command(command = "date")`, expected: FormatStarlark},
+		{name: "starlark function", path: "manifest", data: "def f():\n    return 1\n", expected: FormatStarlark},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := DetectFormat(tt.path, []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != tt.expected {
+				t.Errorf("expected format %q, got %q", tt.expected, format)
+			}
+		})
+	}
+
+	if _, err := DetectFormat(Stdin, []byte("  \n")); err == nil {
+		t.Errorf("expected error for empty manifest")
+	}
+}
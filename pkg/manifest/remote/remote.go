@@ -0,0 +1,346 @@
+// Package remote fetches manifest variables from remote sources. A variable is fetched if
+// its value is a mapping with a source key naming a supported source:
+//
+//	variables:
+//	  app:
+//	    source: http
+//	    url: https://config.example.com/app.json
+//	  db:
+//	    source: consul
+//	    key: app/db
+//	  cluster:
+//	    source: etcd
+//	    key: /app/cluster
+//	    timeout: 5s
+//	    cache: 10m
+//
+// Fetched values are decoded as JSON or YAML if possible and used as plain strings
+// otherwise. Values are cached on disk for the duration given by cache (default 5m).
+package remote
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultTimeout bounds a single fetch unless overridden by the timeout key
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultCacheTTL is the time a fetched value is reused unless overridden by the
+	// cache key. A value of 0 disables caching.
+	DefaultCacheTTL = 5 * time.Minute
+)
+
+// fetcher fetches the raw value described by spec
+type fetcher func(ctx context.Context, client *http.Client, spec map[string]any) ([]byte, error)
+
+var fetchers = map[string]fetcher{
+	"http":   fetchHTTP,
+	"consul": fetchConsul,
+	"etcd":   fetchEtcd,
+}
+
+// NewResolver returns a resolver caching values in the axion directory of the user cache
+// directory.
+func NewResolver() *Resolver {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return &Resolver{
+		CacheDir: filepath.Join(dir, "axion", "variables"),
+		Client:   &http.Client{},
+	}
+}
+
+// Resolver fetches remote variables
+type Resolver struct {
+	// CacheDir holds the cached values, caching is disabled if empty
+	CacheDir string
+
+	Client *http.Client
+}
+
+// IsRemote reports whether value describes a remote variable
+func IsRemote(value any) bool {
+	spec, ok := value.(map[string]any)
+	if !ok {
+		return false
+	}
+	source, ok := spec["source"].(string)
+	if !ok {
+		return false
+	}
+	_, ok = fetchers[source]
+	return ok
+}
+
+// ResolveVariables returns a copy of vars with all top level remote variables fetched. A
+// nil Resolver returns vars unchanged.
+func (r *Resolver) ResolveVariables(ctx context.Context, vars map[string]any) (map[string]any, error) {
+	if r == nil || vars == nil {
+		return vars, nil
+	}
+
+	out := make(map[string]any, len(vars))
+	for k, v := range vars {
+		if !IsRemote(v) {
+			out[k] = v
+			continue
+		}
+
+		value, err := r.Fetch(ctx, v.(map[string]any))
+		if err != nil {
+			return nil, fmt.Errorf("variable %q: %w", k, err)
+		}
+		out[k] = value
+	}
+
+	return out, nil
+}
+
+// Fetch returns the decoded value described by spec, using the cache if possible
+func (r *Resolver) Fetch(ctx context.Context, spec map[string]any) (any, error) {
+	source, _ := spec["source"].(string)
+	fetch, ok := fetchers[source]
+	if !ok {
+		return nil, fmt.Errorf("unsupported source %q", source)
+	}
+
+	timeout, err := duration(spec, "timeout", DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := duration(spec, "cache", DefaultCacheTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheFile := r.cacheFile(spec)
+	if ttl > 0 && cacheFile != "" {
+		if info, err := os.Stat(cacheFile); err == nil && time.Since(info.ModTime()) < ttl {
+			if data, err := os.ReadFile(cacheFile); err == nil {
+				return decode(data), nil
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	data, err := fetch(ctx, client, spec)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	if ttl > 0 && cacheFile != "" {
+		// Caching is best effort
+		if err := os.MkdirAll(filepath.Dir(cacheFile), 0700); err == nil {
+			_ = os.WriteFile(cacheFile, data, 0600)
+		}
+	}
+
+	return decode(data), nil
+}
+
+// cacheFile returns the cache file for spec, derived from a hash of the spec
+func (r *Resolver) cacheFile(spec map[string]any) string {
+	if r.CacheDir == "" {
+		return ""
+	}
+
+	// json.Marshal sorts map keys, the hash is therefore stable
+	key, err := json.Marshal(spec)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(key)
+	return filepath.Join(r.CacheDir, hex.EncodeToString(sum[:]))
+}
+
+// decode decodes data as JSON or YAML. Scalars and undecodable data are returned as a
+// trimmed string.
+func decode(data []byte) any {
+	var v any
+	if err := yaml.Unmarshal(data, &v); err == nil {
+		switch v.(type) {
+		case map[string]any, []any:
+			return v
+		}
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func fetchHTTP(ctx context.Context, client *http.Client, spec map[string]any) ([]byte, error) {
+	url, err := str(spec, "url", "")
+	if err != nil {
+		return nil, err
+	}
+	if url == "" {
+		return nil, fmt.Errorf("url cannot be empty")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if headers, ok := spec["headers"].(map[string]any); ok {
+		for k, v := range headers {
+			req.Header.Set(k, fmt.Sprintf("%v", v))
+		}
+	}
+
+	return do(client, req)
+}
+
+func fetchConsul(ctx context.Context, client *http.Client, spec map[string]any) ([]byte, error) {
+	key, err := str(spec, "key", "")
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, fmt.Errorf("key cannot be empty")
+	}
+
+	address, err := str(spec, "address", envOr("CONSUL_HTTP_ADDR", "http://127.0.0.1:8500"))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	token, err := str(spec, "token", os.Getenv("CONSUL_HTTP_TOKEN"))
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimSuffix(address, "/") + "/v1/kv/" + strings.TrimPrefix(key, "/") + "?raw"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+
+	return do(client, req)
+}
+
+func fetchEtcd(ctx context.Context, client *http.Client, spec map[string]any) ([]byte, error) {
+	key, err := str(spec, "key", "")
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, fmt.Errorf("key cannot be empty")
+	}
+
+	endpoint, err := str(spec, "endpoint", envOr("ETCD_ENDPOINT", "http://127.0.0.1:2379"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The etcd v3 JSON gateway expects base64 encoded keys and returns base64 values,
+	// which encoding/json handles for []byte fields.
+	body, err := json.Marshal(struct {
+		Key []byte `json:"key"`
+	}{Key: []byte(key)})
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimSuffix(endpoint, "/") + "/v3/kv/range"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(string(body)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	data, err := do(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Kvs []struct {
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode etcd response: %w", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("key %q not found", key)
+	}
+
+	return resp.Kvs[0].Value, nil
+}
+
+func do(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", req.URL.Redacted(), resp.Status)
+	}
+	return data, nil
+}
+
+func str(spec map[string]any, key, def string) (string, error) {
+	v, ok := spec[key]
+	if !ok {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", key)
+	}
+	return s, nil
+}
+
+func duration(spec map[string]any, key string, def time.Duration) (time.Duration, error) {
+	s, err := str(spec, key, "")
+	if err != nil {
+		return 0, err
+	}
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,81 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestResolveVariables(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		switch req.URL.Path {
+		case "/app.json":
+			fmt.Fprint(w, `{"name": "web", "replicas": 2}`)
+		case "/v1/kv/app/db":
+			if _, raw := req.URL.Query()["raw"]; !raw {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, "postgres://db\n")
+		case "/v3/kv/range":
+			var body struct {
+				Key []byte `json:"key"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil || string(body.Key) != "/app/cluster" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"kvs": []map[string]any{{"value": []byte("- a\n- b\n")}},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	r := &Resolver{CacheDir: t.TempDir()}
+	vars := map[string]any{
+		"plain":   "value",
+		"app":     map[string]any{"source": "http", "url": srv.URL + "/app.json"},
+		"db":      map[string]any{"source": "consul", "key": "app/db", "address": srv.URL},
+		"cluster": map[string]any{"source": "etcd", "key": "/app/cluster", "endpoint": srv.URL},
+		"other":   map[string]any{"source": "git"},
+	}
+
+	for i := 0; i < 2; i++ {
+		resolved, err := r.ResolveVariables(context.Background(), vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]any{
+			"plain":   "value",
+			"app":     map[string]any{"name": "web", "replicas": 2},
+			"db":      "postgres://db",
+			"cluster": []any{"a", "b"},
+			"other":   map[string]any{"source": "git"},
+		}
+		if !reflect.DeepEqual(resolved, expected) {
+			t.Errorf("expected %v, got %v", expected, resolved)
+		}
+	}
+
+	// The second resolution is served from the cache
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+
+	_, err := r.ResolveVariables(context.Background(), map[string]any{
+		"missing": map[string]any{"source": "http", "url": srv.URL + "/missing"},
+	})
+	if err == nil {
+		t.Errorf("expected error for missing remote variable")
+	}
+}
@@ -0,0 +1,14 @@
+package manifest
+
+import (
+	"context"
+
+	"peertech.de/axion/pkg/config"
+)
+
+// Renderer is implemented by loaders that can print the fully resolved manifest, i.e.
+// after templating, variable substitution and module expansion, as YAML. Rendering must
+// not require an agent.
+type Renderer interface {
+	Render(ctx context.Context, cfg *config.Config, path string) ([]byte, error)
+}
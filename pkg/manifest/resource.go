@@ -0,0 +1,60 @@
+package manifest
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ApplyBefore translates the before field of every resource into dependencies of the
+// resources it references on that resource. fields returns the id, the before field and
+// the dependencies of a resource, the before fields are cleared.
+func ApplyBefore[R any](resources []R, fields func(r *R) (id string, before, dependencies *[]string)) error {
+	index := make(map[string]int, len(resources))
+	for i := range resources {
+		id, _, _ := fields(&resources[i])
+		index[id] = i
+	}
+
+	for i := range resources {
+		id, before, _ := fields(&resources[i])
+		for _, target := range *before {
+			j, ok := index[target]
+			if !ok {
+				return fmt.Errorf("resource %q: before references unknown resource %q", id, target)
+			}
+			_, _, deps := fields(&resources[j])
+			if !slices.Contains(*deps, id) {
+				*deps = append(*deps, id)
+			}
+		}
+		*before = nil
+	}
+	return nil
+}
+
+// ToString returns the property value v as string, values which aren't strings, e.g.
+// unquoted numbers, are formatted
+func ToString(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// OptBool returns the property value v if it is a bool, nil otherwise
+func OptBool(v any) *bool {
+	b, ok := v.(bool)
+	if !ok {
+		return nil
+	}
+	return &b
+}
+
+// OptString returns the property value v as string, nil if it isn't set
+func OptString(v any) *string {
+	if v == nil {
+		return nil
+	}
+	s := ToString(v)
+	return &s
+}
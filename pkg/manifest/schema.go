@@ -0,0 +1,50 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Position identifies a location within a manifest file. Line and Column are 1-based,
+// a zero value means the position is unknown.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	switch {
+	case p.Line == 0:
+		return p.File
+	case p.Column == 0:
+		return fmt.Sprintf("%s:%d", p.File, p.Line)
+	default:
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+	}
+}
+
+// SchemaIssue describes a single schema violation, e.g. an unknown field, a value of the
+// wrong type or a missing required property.
+type SchemaIssue struct {
+	Position Position
+	Message  string
+}
+
+func (i SchemaIssue) String() string {
+	return fmt.Sprintf("%s: %s", i.Position, i.Message)
+}
+
+// SchemaError is returned by loaders when a manifest does not conform to the schema. It
+// collects all issues found instead of stopping at the first one.
+type SchemaError struct {
+	Issues []SchemaIssue
+}
+
+func (e *SchemaError) Error() string {
+	lines := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		lines[i] = issue.String()
+	}
+	return fmt.Sprintf("manifest schema validation failed:\n  %s", strings.Join(lines, "\n  "))
+}
@@ -1,8 +1,225 @@
 package starlark
 
 import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
+	"gopkg.in/yaml.v3"
+
+	"peertech.de/axion/pkg/manifest"
 )
 
 var MakeStruct = starlark.NewBuiltin("struct", starlarkstruct.Make)
+
+// NewEnv returns a starlark.Builtin for reading environment variables, restricted to
+// the allowed names (all names if allowed is empty).
+func NewEnv(allowed []string) *starlark.Builtin {
+	return starlark.NewBuiltin("env", func(
+		thread *starlark.Thread,
+		b *starlark.Builtin,
+		args starlark.Tuple,
+		kwargs []starlark.Tuple,
+	) (starlark.Value, error) {
+		var name, def starlark.String
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs,
+			"name", &name,
+			"default?", &def,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := manifest.Getenv(allowed, string(name))
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			return def, nil
+		}
+
+		return starlark.String(value), nil
+	})
+}
+
+// Facts describes the machine evaluating the manifest
+var Facts = starlark.NewBuiltin("facts", facts)
+
+func facts(
+	thread *starlark.Thread,
+	b *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine hostname: %w", err)
+	}
+
+	username := ""
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	return starlarkstruct.FromStringDict(starlark.String("facts"), starlark.StringDict{
+		"hostname": starlark.String(hostname),
+		"os":       starlark.String(runtime.GOOS),
+		"arch":     starlark.String(runtime.GOARCH),
+		"num_cpu":  starlark.MakeInt(runtime.NumCPU()),
+		"user":     starlark.String(username),
+	}), nil
+}
+
+// ReadFile reads a file relative to the directory of the manifest
+var ReadFile = starlark.NewBuiltin("read_file", readFile)
+
+func readFile(
+	thread *starlark.Thread,
+	b *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var path starlark.String
+
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &path); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(resolvePath(thread, string(path)))
+	if err != nil {
+		return nil, err
+	}
+
+	return starlark.String(data), nil
+}
+
+// RenderTemplate renders a Go text/template file relative to the directory of the
+// manifest with the given variables.
+var RenderTemplate = starlark.NewBuiltin("render_template", renderTemplate)
+
+func renderTemplate(
+	thread *starlark.Thread,
+	b *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var path starlark.String
+	var vars *starlark.Dict
+
+	err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"path", &path,
+		"vars?", &vars,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var data any
+	if vars != nil {
+		data, err = fromStarlark(vars)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vars: %w", err)
+		}
+	}
+
+	file := resolvePath(thread, string(path))
+	tmpl, err := template.New(filepath.Base(file)).ParseFiles(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return nil, err
+	}
+
+	return starlark.String(sb.String()), nil
+}
+
+// YAML is the yaml module, yaml.decode parses a YAML document into Starlark values.
+var YAML = &starlarkstruct.Module{
+	Name: "yaml",
+	Members: starlark.StringDict{
+		"decode": starlark.NewBuiltin("yaml.decode", yamlDecode),
+	},
+}
+
+func yamlDecode(
+	thread *starlark.Thread,
+	b *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var doc starlark.String
+
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "doc", &doc); err != nil {
+		return nil, err
+	}
+
+	var data any
+	if err := yaml.Unmarshal([]byte(doc), &data); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+
+	v, err := toStarlark(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return v, nil
+}
+
+// resolvePath resolves path relative to the directory of the executing manifest.
+func resolvePath(thread *starlark.Thread, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	if dir, ok := thread.Local(localBaseDir).(string); ok {
+		return filepath.Join(dir, path)
+	}
+	return path
+}
+
+// Register names a resource or a collection of resources, e.g. a list built within a
+// function, as if it was bound to a global variable. Resources inside lists and dicts
+// are named after their element, e.g. "web[0]". The value is returned unchanged.
+var Register = starlark.NewBuiltin("register", register)
+
+func register(
+	thread *starlark.Thread,
+	b *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var name starlark.String
+	var value starlark.Value
+
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "value", &value); err != nil {
+		return nil, err
+	}
+
+	if string(name) == "" {
+		return nil, fmt.Errorf("name cannot be empty")
+	}
+
+	reg, ok := thread.Local(localRegistry).(*registry)
+	if !ok {
+		return nil, fmt.Errorf("register is not supported in this context")
+	}
+	if _, exists := reg.names[string(name)]; exists {
+		return nil, fmt.Errorf("name %q is already registered", string(name))
+	}
+	reg.names[string(name)] = value
+
+	return value, nil
+}
@@ -2,6 +2,7 @@ package starlark
 
 import (
 	"fmt"
+	"time"
 
 	"go.starlark.net/starlark"
 )
@@ -18,11 +19,23 @@ func newCommand(
 	kwargs []starlark.Tuple,
 ) (starlark.Value, error) {
 	var command starlark.String
+	var timeout starlark.Value
+	var expectedExitCodes *starlark.List
+	var concurrent starlark.Bool
+	var creates, unless, output starlark.String
 	var dependencies *starlark.List
+	var id starlark.String
 
 	err := starlark.UnpackArgs(b.Name(), args, kwargs,
 		"command", &command,
+		"timeout?", &timeout,
+		"expected_exit_codes?", &expectedExitCodes,
+		"concurrent?", &concurrent,
+		"creates?", &creates,
+		"unless?", &unless,
+		"output?", &output,
 		"dependencies?", &dependencies,
+		"id?", &id,
 	)
 	if err != nil {
 		return nil, err
@@ -34,7 +47,28 @@ func newCommand(
 	}
 
 	cmd := &Command{
-		Command: string(command),
+		Name:       string(id),
+		Command:    string(command),
+		Concurrent: bool(concurrent),
+		Creates:    string(creates),
+		Unless:     string(unless),
+		Output:     string(output),
+	}
+
+	if timeout != nil {
+		d, err := parseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+		cmd.Timeout = d
+	}
+
+	if expectedExitCodes != nil {
+		codes, err := parseInts(expectedExitCodes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expected_exit_codes: %w", err)
+		}
+		cmd.ExpectedExitCodes = codes
 	}
 
 	// Parse dependencies as resource values
@@ -46,18 +80,47 @@ func newCommand(
 		cmd.Dependencies = deps
 	}
 
+	record(thread, cmd)
+
 	return cmd, nil
 }
 
 type Command struct {
-	Command      string
-	Dependencies []starlark.Value
+	// Name is the explicit id given via the id kwarg
+	Name string
+
+	Command           string
+	Timeout           time.Duration
+	ExpectedExitCodes []int
+	Concurrent        bool
+	Creates           string
+	Unless            string
+	Output            string
+	Dependencies      []starlark.Value
 }
 
 func (c *Command) Attr(name string) (starlark.Value, error) {
 	switch name {
 	case "command":
 		return starlark.String(c.Command), nil
+	case "timeout":
+		return starlark.String(c.Timeout.String()), nil
+	case "expected_exit_codes":
+		codes := make([]starlark.Value, len(c.ExpectedExitCodes))
+		for i, code := range c.ExpectedExitCodes {
+			codes[i] = starlark.MakeInt(code)
+		}
+		return starlark.NewList(codes), nil
+	case "concurrent":
+		return starlark.Bool(c.Concurrent), nil
+	case "creates":
+		return starlark.String(c.Creates), nil
+	case "unless":
+		return starlark.String(c.Unless), nil
+	case "output":
+		return starlark.String(c.Output), nil
+	case "id":
+		return starlark.String(c.Name), nil
 	case "dependencies":
 		deps := make([]starlark.Value, len(c.Dependencies))
 		copy(deps, c.Dependencies)
@@ -71,8 +134,12 @@ func (c *Command) Id() string {
 	return "command:" + c.Command
 }
 
+func (c *Command) ExplicitId() string {
+	return c.Name
+}
+
 func (c *Command) AttrNames() []string {
-	return []string{"command", "dependencies"}
+	return []string{"command", "timeout", "expected_exit_codes", "concurrent", "creates", "unless", "output", "dependencies", "id"}
 }
 
 func (c *Command) Type() string {
@@ -103,3 +170,33 @@ func (c *Command) GetDependencies() []starlark.Value {
 	copy(deps, c.Dependencies)
 	return deps
 }
+
+// parseDuration accepts either a duration string (e.g. "5m") or an integer number of
+// seconds.
+func parseDuration(v starlark.Value) (time.Duration, error) {
+	switch v := v.(type) {
+	case starlark.String:
+		return time.ParseDuration(string(v))
+	case starlark.Int:
+		seconds, ok := v.Int64()
+		if !ok {
+			return 0, fmt.Errorf("value out of range")
+		}
+		return time.Duration(seconds) * time.Second, nil
+	default:
+		return 0, fmt.Errorf("expected string or int, got %s", v.Type())
+	}
+}
+
+// parseInts extracts integers from a Starlark list
+func parseInts(list *starlark.List) ([]int, error) {
+	ints := make([]int, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		n, err := starlark.AsInt32(list.Index(i))
+		if err != nil {
+			return nil, fmt.Errorf("item at index %d: %w", i, err)
+		}
+		ints[i] = n
+	}
+	return ints, nil
+}
@@ -0,0 +1,117 @@
+package starlark
+
+import (
+	"fmt"
+	"sort"
+
+	"go.starlark.net/starlark"
+)
+
+// toStarlark converts a Go value (as produced by YAML/JSON decoding) into a frozen
+// Starlark value.
+func toStarlark(v any) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case int:
+		return starlark.MakeInt(v), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case uint64:
+		return starlark.MakeUint64(v), nil
+	case float64:
+		return starlark.Float(v), nil
+	case []any:
+		elems := make([]starlark.Value, len(v))
+		for i, item := range v {
+			elem, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = elem
+		}
+		list := starlark.NewList(elems)
+		list.Freeze()
+		return list, nil
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		dict := starlark.NewDict(len(v))
+		for _, k := range keys {
+			val, err := toStarlark(v[k])
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(starlark.String(k), val); err != nil {
+				return nil, err
+			}
+		}
+		dict.Freeze()
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+// fromStarlark converts a Starlark value into its Go equivalent.
+func fromStarlark(v starlark.Value) (any, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return nil, fmt.Errorf("integer %s out of range", v)
+	case starlark.Float:
+		return float64(v), nil
+	case *starlark.List:
+		items := make([]any, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			item, err := fromStarlark(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	case starlark.Tuple:
+		items := make([]any, len(v))
+		for i, elem := range v {
+			item, err := fromStarlark(elem)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	case *starlark.Dict:
+		m := make(map[string]any, v.Len())
+		for _, item := range v.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, got %s", item[0].Type())
+			}
+			val, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[string(key)] = val
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %s", v.Type())
+	}
+}
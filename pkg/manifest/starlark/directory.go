@@ -20,6 +20,7 @@ func newDirectory(
 	var state, path starlark.String
 	var mode, owner, group starlark.String
 	var dependencies *starlark.List
+	var id starlark.String
 
 	err := starlark.UnpackArgs(b.Name(), args, kwargs,
 		"state", &state,
@@ -28,6 +29,7 @@ func newDirectory(
 		"owner?", &owner,
 		"group?", &group,
 		"dependencies?", &dependencies,
+		"id?", &id,
 	)
 	if err != nil {
 		return nil, err
@@ -42,6 +44,7 @@ func newDirectory(
 	}
 
 	dir := &Directory{
+		Name:  string(id),
 		State: string(state),
 		Path:  string(path),
 		Mode:  string(mode),
@@ -58,10 +61,15 @@ func newDirectory(
 		dir.Dependencies = deps
 	}
 
+	record(thread, dir)
+
 	return dir, nil
 }
 
 type Directory struct {
+	// Name is the explicit id given via the id kwarg
+	Name string
+
 	State        string
 	Path         string
 	Mode         string
@@ -82,6 +90,8 @@ func (d *Directory) Attr(name string) (starlark.Value, error) {
 		return starlark.String(d.Owner), nil
 	case "group":
 		return starlark.String(d.Group), nil
+	case "id":
+		return starlark.String(d.Name), nil
 	case "dependencies":
 		deps := make([]starlark.Value, len(d.Dependencies))
 		copy(deps, d.Dependencies)
@@ -95,8 +105,12 @@ func (d *Directory) Id() string {
 	return "directory:" + d.Path
 }
 
+func (d *Directory) ExplicitId() string {
+	return d.Name
+}
+
 func (d *Directory) AttrNames() []string {
-	return []string{"state", "path", "mode", "owner", "group", "dependencies"}
+	return []string{"state", "path", "mode", "owner", "group", "dependencies", "id"}
 }
 
 func (d *Directory) Type() string {
@@ -20,6 +20,7 @@ func newFile(
 	var state, path starlark.String
 	var mode, owner, group starlark.String
 	var dependencies *starlark.List
+	var id starlark.String
 
 	err := starlark.UnpackArgs(b.Name(), args, kwargs,
 		"state", &state,
@@ -28,6 +29,7 @@ func newFile(
 		"owner?", &owner,
 		"group?", &group,
 		"dependencies?", &dependencies,
+		"id?", &id,
 	)
 	if err != nil {
 		return nil, err
@@ -42,6 +44,7 @@ func newFile(
 	}
 
 	file := &File{
+		Name:  string(id),
 		State: string(state),
 		Path:  string(path),
 		Mode:  string(mode),
@@ -58,10 +61,15 @@ func newFile(
 		file.Dependencies = deps
 	}
 
+	record(thread, file)
+
 	return file, nil
 }
 
 type File struct {
+	// Name is the explicit id given via the id kwarg
+	Name string
+
 	State        string
 	Path         string
 	Mode         string
@@ -82,6 +90,8 @@ func (f *File) Attr(name string) (starlark.Value, error) {
 		return starlark.String(f.Owner), nil
 	case "group":
 		return starlark.String(f.Group), nil
+	case "id":
+		return starlark.String(f.Name), nil
 	case "dependencies":
 		deps := make([]starlark.Value, len(f.Dependencies))
 		copy(deps, f.Dependencies)
@@ -95,8 +105,12 @@ func (f *File) Id() string {
 	return "file:" + f.Path
 }
 
+func (f *File) ExplicitId() string {
+	return f.Name
+}
+
 func (f *File) AttrNames() []string {
-	return []string{"state", "path", "mode", "owner", "group", "dependencies"}
+	return []string{"state", "path", "mode", "owner", "group", "dependencies", "id"}
 }
 
 func (f *File) Type() string {
@@ -1,16 +1,11 @@
 package starlark
 
 import (
-	"os"
-	"path/filepath"
+	"peertech.de/axion/pkg/manifest"
 )
 
 func load(path string) (string, error) {
-	abs, err := filepath.Abs(path)
-	if err != nil {
-		return "", err
-	}
-	body, err := os.ReadFile(abs)
+	body, err := manifest.Read(path)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,152 @@
+package starlark
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"go.starlark.net/starlark"
+)
+
+// NewModule returns a starlark.Builtin for instantiating modules. A module is a Starlark
+// file evaluated with its own globals and the predeclared params value. The resources it
+// declares are namespaced as "<module id>.<resource id>".
+func NewModule() *starlark.Builtin {
+	return starlark.NewBuiltin("module", newModule)
+}
+
+func newModule(
+	thread *starlark.Thread,
+	b *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var path starlark.String
+	var params *starlark.Dict
+	var dependencies *starlark.List
+	var id starlark.String
+
+	err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"path", &path,
+		"params?", &params,
+		"dependencies?", &dependencies,
+		"id?", &id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(path) == "" {
+		return nil, fmt.Errorf("path cannot be empty")
+	}
+
+	parent, ok := thread.Local(localRuntime).(*Runtime)
+	if !ok {
+		return nil, fmt.Errorf("modules are not supported in this context")
+	}
+	ctx, ok := thread.Local(localContext).(context.Context)
+	if !ok {
+		ctx = context.Background()
+	}
+
+	if params == nil {
+		params = starlark.NewDict(0)
+	}
+	params.Freeze()
+
+	child := parent.module(params)
+	globals, err := child.Load(ctx, resolvePath(thread, string(path)))
+	if err != nil {
+		return nil, fmt.Errorf("module %q: %w", string(path), err)
+	}
+
+	mod := &Module{
+		Name:       string(id),
+		Path:       string(path),
+		Globals:    globals,
+		Registered: child.Registered(),
+		Resources:  child.Declared(),
+	}
+
+	// Parse dependencies as resource values
+	if dependencies != nil {
+		deps, err := parseDependencies(dependencies)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dependencies: %w", err)
+		}
+		mod.Dependencies = deps
+	}
+
+	record(thread, mod)
+
+	return mod, nil
+}
+
+// Module is an instantiated module. The globals of the module are accessible as
+// attributes, e.g. web.config for a resource bound to config within the module.
+type Module struct {
+	// Name is the explicit id given via the id kwarg
+	Name string
+
+	Path         string
+	Globals      starlark.StringDict
+	Registered   starlark.StringDict
+	Resources    []Resource
+	Dependencies []starlark.Value
+}
+
+func (m *Module) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "id":
+		return starlark.String(m.Name), nil
+	case "dependencies":
+		deps := make([]starlark.Value, len(m.Dependencies))
+		copy(deps, m.Dependencies)
+		return starlark.NewList(deps), nil
+	default:
+		return m.Globals[name], nil
+	}
+}
+
+func (m *Module) Id() string {
+	return "module:" + m.Path
+}
+
+func (m *Module) ExplicitId() string {
+	return m.Name
+}
+
+func (m *Module) AttrNames() []string {
+	names := append([]string{"id", "dependencies"}, m.Globals.Keys()...)
+	sort.Strings(names)
+	return names
+}
+
+func (m *Module) Type() string {
+	return "module"
+}
+
+func (m *Module) Freeze() {
+	// Freeze dependencies as well
+	for _, dep := range m.Dependencies {
+		dep.Freeze()
+	}
+}
+
+func (m *Module) Truth() starlark.Bool {
+	return starlark.True
+}
+
+func (m *Module) Hash() (uint32, error) {
+	return 0, fmt.Errorf("module is unhashable")
+}
+
+func (m *Module) String() string {
+	return m.Id()
+}
+
+func (m *Module) GetDependencies() []starlark.Value {
+	deps := make([]starlark.Value, len(m.Dependencies))
+	copy(deps, m.Dependencies)
+	return deps
+}
@@ -0,0 +1,142 @@
+package starlark
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+)
+
+// NewPackage returns a starlark.Builtin for creating Package resources
+func NewPackage() *starlark.Builtin {
+	return starlark.NewBuiltin("package", newPackage)
+}
+
+func newPackage(
+	thread *starlark.Thread,
+	b *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var state, name, version starlark.String
+	var pinned starlark.Value = starlark.None
+	var dependencies *starlark.List
+	var id starlark.String
+
+	err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"state", &state,
+		"name", &name,
+		"version?", &version,
+		"pinned?", &pinned,
+		"dependencies?", &dependencies,
+		"id?", &id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate required fields
+	if string(state) == "" {
+		return nil, fmt.Errorf("state cannot be empty")
+	}
+	if string(name) == "" {
+		return nil, fmt.Errorf("name cannot be empty")
+	}
+
+	pkg := &Package{
+		Name:        string(id),
+		State:       string(state),
+		PackageName: string(name),
+		Version:     string(version),
+	}
+
+	// Pinned is left unmanaged unless given
+	if pkg.Pinned, err = optionalBool("pinned", pinned); err != nil {
+		return nil, err
+	}
+
+	// Parse dependencies as resource values
+	if dependencies != nil {
+		deps, err := parseDependencies(dependencies)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dependencies: %w", err)
+		}
+		pkg.Dependencies = deps
+	}
+
+	record(thread, pkg)
+
+	return pkg, nil
+}
+
+type Package struct {
+	// Name is the explicit id given via the id kwarg
+	Name string
+
+	State        string
+	PackageName  string
+	Version      string
+	Pinned       *bool
+	Dependencies []starlark.Value
+}
+
+func (p *Package) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "state":
+		return starlark.String(p.State), nil
+	case "name":
+		return starlark.String(p.PackageName), nil
+	case "version":
+		return starlark.String(p.Version), nil
+	case "pinned":
+		return optionalBoolValue(p.Pinned), nil
+	case "id":
+		return starlark.String(p.Name), nil
+	case "dependencies":
+		deps := make([]starlark.Value, len(p.Dependencies))
+		copy(deps, p.Dependencies)
+		return starlark.NewList(deps), nil
+	default:
+		return nil, nil
+	}
+}
+
+func (p *Package) Id() string {
+	return "package:" + p.PackageName
+}
+
+func (p *Package) ExplicitId() string {
+	return p.Name
+}
+
+func (p *Package) AttrNames() []string {
+	return []string{"state", "name", "version", "pinned", "dependencies", "id"}
+}
+
+func (p *Package) Type() string {
+	return "package"
+}
+
+func (p *Package) Freeze() {
+	// Freeze dependencies as well
+	for _, dep := range p.Dependencies {
+		dep.Freeze()
+	}
+}
+
+func (p *Package) Truth() starlark.Bool {
+	return starlark.True
+}
+
+func (p *Package) Hash() (uint32, error) {
+	return 0, fmt.Errorf("package is unhashable")
+}
+
+func (p *Package) String() string {
+	return p.Id()
+}
+
+func (p *Package) GetDependencies() []starlark.Value {
+	deps := make([]starlark.Value, len(p.Dependencies))
+	copy(deps, p.Dependencies)
+	return deps
+}
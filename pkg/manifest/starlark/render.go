@@ -0,0 +1,101 @@
+package starlark
+
+import (
+	"context"
+
+	"gopkg.in/yaml.v3"
+
+	"peertech.de/axion/pkg/config"
+)
+
+// rendered is the YAML manifest representation of a resource
+type rendered struct {
+	Id           string         `yaml:"id"`
+	Type         string         `yaml:"type"`
+	State        string         `yaml:"state,omitempty"`
+	Properties   map[string]any `yaml:"properties,omitempty"`
+	Dependencies []string       `yaml:"dependencies,omitempty"`
+}
+
+// Render executes a Starlark script and returns the resources it declares as a YAML
+// manifest, using the ids and dependencies assigned when loading it.
+func (l *Loader) Render(ctx context.Context, cfg *config.Config, path string) ([]byte, error) {
+	e, err := l.execute(ctx, cfg, path)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]rendered, len(e.specs))
+	for i, spec := range e.specs {
+		out[i] = describe(e.values[i])
+		out[i].Id = spec.Id
+		out[i].Dependencies = spec.Dependencies
+	}
+
+	return yaml.Marshal(map[string][]rendered{"resources": out})
+}
+
+// describe returns the type, state and properties of a resource
+func describe(value Resource) rendered {
+	props := make(map[string]any)
+	set := func(key, value string) {
+		if value != "" {
+			props[key] = value
+		}
+	}
+
+	switch v := value.(type) {
+	case *Command:
+		set("command", v.Command)
+		set("creates", v.Creates)
+		set("unless", v.Unless)
+		set("output", v.Output)
+		if v.Timeout > 0 {
+			props["timeout"] = v.Timeout.String()
+		}
+		if len(v.ExpectedExitCodes) > 0 {
+			props["expected_exit_codes"] = v.ExpectedExitCodes
+		}
+		if v.Concurrent {
+			props["concurrent"] = true
+		}
+		return rendered{Type: "command", Properties: props}
+	case *File:
+		set("path", v.Path)
+		set("mode", v.Mode)
+		set("owner", v.Owner)
+		set("group", v.Group)
+		return rendered{Type: "file", State: v.State, Properties: props}
+	case *Directory:
+		set("path", v.Path)
+		set("mode", v.Mode)
+		set("owner", v.Owner)
+		set("group", v.Group)
+		return rendered{Type: "directory", State: v.State, Properties: props}
+	case *Symlink:
+		set("path", v.Path)
+		set("target", v.Target)
+		if v.Force {
+			props["force"] = true
+		}
+		return rendered{Type: "symlink", State: v.State, Properties: props}
+	case *Package:
+		set("name", v.PackageName)
+		set("version", v.Version)
+		if v.Pinned != nil {
+			props["pinned"] = *v.Pinned
+		}
+		return rendered{Type: "package", State: v.State, Properties: props}
+	case *Service:
+		set("name", v.ServiceName)
+		if v.Running != nil {
+			props["running"] = *v.Running
+		}
+		if v.Enabled != nil {
+			props["enabled"] = *v.Enabled
+		}
+		return rendered{Type: "service", Properties: props}
+	default:
+		return rendered{Type: value.Type()}
+	}
+}
@@ -13,10 +13,30 @@ type Resource interface {
 	// Id returns a unique identifier for this resource
 	Id() string
 
+	// ExplicitId returns the id given via the id kwarg, if any
+	ExplicitId() string
+
 	// TODO: GetDependencies
 	GetDependencies() []starlark.Value
 }
 
+// localRegistry is the thread local key holding the resources declared during execution
+const localRegistry = "registry"
+
+// registry records resources in the order they are declared and the values named via
+// register
+type registry struct {
+	resources []Resource
+	names     starlark.StringDict
+}
+
+// record adds the resource to the registry of the executing thread
+func record(thread *starlark.Thread, res Resource) {
+	if reg, ok := thread.Local(localRegistry).(*registry); ok {
+		reg.resources = append(reg.resources, res)
+	}
+}
+
 // isResource can now use the interface
 func isResource(v starlark.Value) bool {
 	_, ok := v.(Resource)
@@ -4,13 +4,21 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 
+	starlarkjson "go.starlark.net/lib/json"
+	starlarkmath "go.starlark.net/lib/math"
+	starlarktime "go.starlark.net/lib/time"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 	"go.starlark.net/syntax"
 
 	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/manifest"
+	"peertech.de/axion/pkg/manifest/remote"
 	"peertech.de/axion/pkg/orchestrator"
+	"peertech.de/axion/pkg/output"
 	"peertech.de/axion/pkg/resource"
 )
 
@@ -21,6 +29,9 @@ var resources = starlarkstruct.FromStringDict(
 		"command":   NewCommand(),
 		"directory": NewDirectory(),
 		"file":      NewFile(),
+		"package":   NewPackage(),
+		"service":   NewService(),
+		"symlink":   NewSymlink(),
 	},
 )
 
@@ -29,71 +40,243 @@ type Loader struct{}
 
 // Load executes a Starlark script and extracts resource specifications
 func (l *Loader) Load(ctx context.Context, cfg *config.Config, path string) ([]orchestrator.ResourceSpec, error) {
-	r := NewRuntime(nil)
+	e, err := l.execute(ctx, cfg, path)
+	if err != nil {
+		return nil, err
+	}
+	return e.specs, nil
+}
+
+// execute executes a Starlark script and extracts its resources
+func (l *Loader) execute(ctx context.Context, cfg *config.Config, path string) (*extractor, error) {
+	variables, err := remote.NewResolver().ResolveVariables(ctx, cfg.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch remote variables: %w", err)
+	}
+	variables, err = cfg.Secrets.ResolveVariables(ctx, variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve variables: %w", err)
+	}
+
+	vars, err := toStarlark(variables)
+	if err != nil {
+		return nil, fmt.Errorf("invalid variables: %w", err)
+	}
+
+	r := NewRuntime(starlark.StringDict{
+		"vars": vars,
+		"env":  NewEnv(cfg.AllowedEnv),
+	})
 
 	globals, err := r.Load(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("starlark execution error: %w", err)
 	}
 
-	return l.extractResources(cfg, globals)
+	return l.extractResources(cfg, globals, r.Registered(), r.Declared())
 }
 
-// extractResources converts Starlark values to orchestrator resource specs
-func (l *Loader) extractResources(cfg *config.Config, globals starlark.StringDict) ([]orchestrator.ResourceSpec, error) {
-	// Discover all resources and map them to their variable names.
-	resources := make(map[string]Resource)
-	reverse := make(map[Resource]string)
+// extractResources converts Starlark values to orchestrator resource specs. Specs are
+// returned in declaration order. A resource is identified by its id kwarg, the global
+// variable or register name it is bound to (including list and dict elements, e.g.
+// "dirs[0]") or, if neither is present, its natural id (e.g. "file:/etc/motd").
+// Resources declared by a module are prefixed with the id of the module.
+func (l *Loader) extractResources(
+	cfg *config.Config,
+	globals starlark.StringDict,
+	registered starlark.StringDict,
+	declared []Resource,
+) (*extractor, error) {
+	e := &extractor{
+		loader: l,
+		cfg:    cfg,
+		ids:    make(map[Resource]string),
+		groups: make(map[*Module][]string),
+		seen:   make(map[string]bool),
+	}
 
-	for name, value := range globals {
-		if res, ok := value.(Resource); ok {
-			resources[name] = res
-			reverse[res] = name
-		}
+	if _, err := e.assign(globals, registered, declared, ""); err != nil {
+		return nil, err
+	}
+	if err := e.build(declared, nil); err != nil {
+		return nil, err
 	}
 
-	// Resolve dependencies and build the final ResourceSpec list
-	var specs []orchestrator.ResourceSpec
-	for name, obj := range resources {
-		// Convert the Starlark resource to a concrete orchestrator resource
-		res, ok := l.convertToResource(cfg, obj)
+	return e, nil
+}
+
+// extractor holds the state of a single extractResources call
+type extractor struct {
+	loader *Loader
+	cfg    *config.Config
+
+	ids    map[Resource]string  // id of every resource and module
+	groups map[*Module][]string // ids of all resources within a module
+	seen   map[string]bool      // assigned ids, to detect duplicates
+
+	specs  []orchestrator.ResourceSpec
+	values []Resource // Starlark value of every spec
+}
+
+// assign assigns an id to every declared resource and returns the ids of all resources
+// excluding modules, which are replaced by their resources.
+func (e *extractor) assign(
+	globals, registered starlark.StringDict,
+	declared []Resource,
+	prefix string,
+) ([]string, error) {
+	names := globalNames(globals, registered)
+
+	var leaves []string
+	for _, res := range declared {
+		id := res.ExplicitId()
+		if id == "" {
+			id = names[res]
+		}
+		if id == "" {
+			id = res.Id()
+		}
+		id = prefix + id
+
+		if e.seen[id] {
+			return nil, fmt.Errorf("duplicate resource id %q", id)
+		}
+		e.seen[id] = true
+		e.ids[res] = id
+
+		mod, ok := res.(*Module)
 		if !ok {
-			return nil, fmt.Errorf("failed to convert starlark resource %q", name)
+			leaves = append(leaves, id)
+			continue
 		}
 
-		// Resolve dependencies.
-		var ids []string
-		deps := obj.GetDependencies()
-		for _, dep := range deps {
+		ids, err := e.assign(mod.Globals, mod.Registered, mod.Resources, id+".")
+		if err != nil {
+			return nil, err
+		}
+		e.groups[mod] = ids
+		leaves = append(leaves, ids...)
+	}
+
+	return leaves, nil
+}
+
+// build converts the declared resources into specs. The inherited dependencies are the
+// ones of the enclosing modules and apply to every resource.
+func (e *extractor) build(declared []Resource, inherited []string) error {
+	for _, obj := range declared {
+		name := e.ids[obj]
+
+		// Resolve dependencies, a dependency on a module covers all of its resources
+		var deps []string
+		for _, dep := range obj.GetDependencies() {
+			if mod, ok := dep.(*Module); ok {
+				deps = append(deps, e.groups[mod]...)
+				continue
+			}
 			if depRes, ok := dep.(Resource); ok {
-				// Look up the dependency's variable name
-				name, found := reverse[depRes]
+				id, found := e.ids[depRes]
 				if !found {
-					return nil, fmt.Errorf("resource %q depends on an unregistered resource object (%s)", name, dep.String())
+					return fmt.Errorf("resource %q depends on an unregistered resource object (%s)", name, dep.String())
 				}
-				ids = append(ids, name)
+				deps = append(deps, id)
 			}
 		}
+		deps = append(deps, inherited...)
 
-		spec := orchestrator.ResourceSpec{
-			Id:           name, // The Id is the Starlark variable name
+		if mod, ok := obj.(*Module); ok {
+			if err := e.build(mod.Resources, deps); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Convert the Starlark resource to a concrete orchestrator resource
+		res, ok := e.loader.convertToResource(e.cfg, obj)
+		if !ok {
+			return fmt.Errorf("failed to convert starlark resource %q", name)
+		}
+
+		d := describe(obj)
+		e.specs = append(e.specs, orchestrator.ResourceSpec{
+			Id:           name,
 			Resource:     res,
-			Dependencies: ids,
+			Dependencies: deps,
+			Digest:       manifest.ResourceDigest(d.Type, d.State, d.Properties),
+		})
+		e.values = append(e.values, obj)
+	}
+
+	return nil
+}
+
+// globalNames maps resources to the names they are bound to. Names given via register
+// take precedence over global variables. Resources inside lists, tuples and dicts are
+// named after the element, e.g. "dirs[0]" or "users[alice]", also when nested. Names are
+// visited in sorted order, the first name found for a resource wins.
+func globalNames(globals, registered starlark.StringDict) map[Resource]string {
+	names := make(map[Resource]string)
+
+	var bind func(value starlark.Value, name string)
+	bind = func(value starlark.Value, name string) {
+		switch v := value.(type) {
+		case Resource:
+			if _, ok := names[v]; !ok {
+				names[v] = name
+			}
+		case *starlark.List:
+			for i := 0; i < v.Len(); i++ {
+				bind(v.Index(i), fmt.Sprintf("%s[%d]", name, i))
+			}
+		case starlark.Tuple:
+			for i, elem := range v {
+				bind(elem, fmt.Sprintf("%s[%d]", name, i))
+			}
+		case *starlark.Dict:
+			for _, item := range v.Items() {
+				if key, ok := item[0].(starlark.String); ok {
+					bind(item[1], fmt.Sprintf("%s[%s]", name, string(key)))
+				}
+			}
+		}
+	}
+
+	for _, dict := range []starlark.StringDict{registered, globals} {
+		for _, name := range dict.Keys() {
+			bind(dict[name], name)
 		}
-		specs = append(specs, spec)
 	}
 
-	return specs, nil
+	return names
 }
 
 // convertToResource attempts to convert a Starlark value to a concrete resource
 func (l *Loader) convertToResource(cfg *config.Config, value starlark.Value) (resource.Resource, bool) {
 	switch v := value.(type) {
 	case *Command:
-		// TODO: isConcurrent, timeout, expectedExitCodes
+		var opts []resource.CommandOption
+		if v.Timeout > 0 {
+			opts = append(opts, resource.WithTimeout(v.Timeout))
+		}
+		if len(v.ExpectedExitCodes) > 0 {
+			opts = append(opts, resource.WithExpectedExitCodes(v.ExpectedExitCodes...))
+		}
+		if v.Concurrent {
+			opts = append(opts, resource.WithConcurrent(true))
+		}
+		if v.Creates != "" {
+			opts = append(opts, resource.WithCreates(v.Creates))
+		}
+		if v.Unless != "" {
+			opts = append(opts, resource.WithUnless(v.Unless))
+		}
+		if v.Output != "" {
+			opts = append(opts, resource.WithOutputRetention(output.Retention(v.Output)))
+		}
 		return resource.NewCommand(
 			cfg,
 			v.Command,
+			opts...,
 		), true
 	case *File:
 		return resource.NewFile(
@@ -113,15 +296,57 @@ func (l *Loader) convertToResource(cfg *config.Config, value starlark.Value) (re
 			optionalString(v.Owner),
 			optionalString(v.Group),
 		), true
+	case *Symlink:
+		return resource.NewSymlink(
+			cfg,
+			resource.State(v.State),
+			v.Path,
+			v.Target,
+			v.Force,
+		), true
+	case *Package:
+		return resource.NewPackage(
+			cfg,
+			resource.State(v.State),
+			v.PackageName,
+			v.Version,
+			v.Pinned,
+		), true
+	case *Service:
+		return resource.NewService(
+			cfg,
+			v.ServiceName,
+			v.Running,
+			v.Enabled,
+		), true
 	default:
 		return nil, false
 	}
 }
 
+// Thread local keys
+const (
+	// localBaseDir holds the directory of the executing manifest
+	localBaseDir = "basedir"
+	// localRuntime holds the executing runtime
+	localRuntime = "runtime"
+	// localContext holds the context of the execution
+	localContext = "context"
+)
+
 func NewRuntime(extra starlark.StringDict) *Runtime {
 	globals := starlark.StringDict{
-		"struct":    MakeStruct,
-		"resources": resources,
+		"struct":          MakeStruct,
+		"resources":       resources,
+		"facts":           Facts,
+		"read_file":       ReadFile,
+		"render_template": RenderTemplate,
+		"module":          NewModule(),
+		"register":        Register,
+		"json":            starlarkjson.Module,
+		"math":            starlarkmath.Module,
+		"time":            starlarktime.Module,
+		"yaml":            YAML,
 	}
 
 	// Add extra predeclared values
@@ -138,6 +363,16 @@ func NewRuntime(extra starlark.StringDict) *Runtime {
 type Runtime struct {
 	opts    *syntax.FileOptions
 	globals starlark.StringDict
+
+	// declared holds the resources of the last run in declaration order
+	declared []Resource
+	// registered holds the values named via register during the last run
+	registered starlark.StringDict
+
+	// dir is the directory relative paths are resolved against
+	dir string
+	// stack holds the paths of the files being loaded, to detect module cycles
+	stack []string
 }
 
 func (r *Runtime) Load(ctx context.Context, path string) (starlark.StringDict, error) {
@@ -146,12 +381,57 @@ func (r *Runtime) Load(ctx context.Context, path string) (starlark.StringDict, e
 		return nil, fmt.Errorf("failed to load module: %w", err)
 	}
 
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := manifest.CheckCycle(abs, r.stack); err != nil {
+		return nil, err
+	}
+	r.dir = filepath.Dir(abs)
+	r.stack = append(r.stack, abs)
+
 	return r.Run(ctx, src)
 }
 
 func (r *Runtime) Run(ctx context.Context, src string) (starlark.StringDict, error) {
+	reg := &registry{names: make(starlark.StringDict)}
 	thread := r.thread(ctx)
-	return starlark.ExecFileOptions(r.opts, thread, "main", src, r.globals)
+	thread.SetLocal(localRegistry, reg)
+	thread.SetLocal(localRuntime, r)
+	thread.SetLocal(localContext, ctx)
+
+	globals, err := starlark.ExecFileOptions(r.opts, thread, "main", src, r.globals)
+	r.declared = reg.resources
+	r.registered = reg.names
+	return globals, err
+}
+
+// Registered returns the values named via register during the last run
+func (r *Runtime) Registered() starlark.StringDict {
+	return r.registered
+}
+
+// module returns a runtime for evaluating a module of r. It shares the predeclared values
+// of r, with params made available to the module.
+func (r *Runtime) module(params starlark.Value) *Runtime {
+	globals := make(starlark.StringDict, len(r.globals)+1)
+	for k, v := range r.globals {
+		globals[k] = v
+	}
+	globals["params"] = params
+
+	return &Runtime{
+		opts:    r.opts,
+		globals: globals,
+		stack:   slices.Clone(r.stack),
+	}
+}
+
+// Declared returns the resources of the last run in declaration order, including those
+// that are not bound to a global variable.
+func (r *Runtime) Declared() []Resource {
+	return r.declared
 }
 
 func (r *Runtime) thread(ctx context.Context) *starlark.Thread {
@@ -161,6 +441,9 @@ func (r *Runtime) thread(ctx context.Context) *starlark.Thread {
 			fmt.Fprintf(os.Stderr, "[%s:%d] %s\n", pos.Filename(), pos.Line, msg)
 		},
 	}
+	if r.dir != "" {
+		thread.SetLocal(localBaseDir, r.dir)
+	}
 
 	return thread
 }
@@ -184,3 +467,23 @@ func optionalString(s string) *string {
 	}
 	return &s
 }
+
+// optionalBool converts a bool kwarg defaulting to None, which leaves it unset
+func optionalBool(name string, v starlark.Value) (*bool, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		b := bool(v)
+		return &b, nil
+	default:
+		return nil, fmt.Errorf("%s must be a bool, got %s", name, v.Type())
+	}
+}
+
+func optionalBoolValue(b *bool) starlark.Value {
+	if b == nil {
+		return starlark.None
+	}
+	return starlark.Bool(*b)
+}
@@ -2,8 +2,13 @@ package starlark_test
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
+	"peertech.de/axion/pkg/config"
 	"peertech.de/axion/pkg/manifest/starlark"
 )
 
@@ -101,3 +106,224 @@ log_file = resources.file(
 		t.Errorf("Expected %d resources, got %d", expectedResources, count)
 	}
 }
+
+func TestCommandOptions(t *testing.T) {
+	src := `
+migrate = resources.command(
+    command = "/usr/local/bin/migrate",
+    timeout = "10m",
+    expected_exit_codes = [0, 2],
+    concurrent = True,
+    creates = "/var/lib/app/.migrated",
+)
+`
+
+	r := starlark.NewRuntime(nil)
+	globals, err := r.Run(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, ok := globals["migrate"].(*starlark.Command)
+	if !ok {
+		t.Fatalf("expected command resource, got %T", globals["migrate"])
+	}
+	if cmd.Timeout != 10*time.Minute {
+		t.Errorf("expected timeout 10m, got %v", cmd.Timeout)
+	}
+	if len(cmd.ExpectedExitCodes) != 2 || cmd.ExpectedExitCodes[1] != 2 {
+		t.Errorf("expected exit codes [0 2], got %v", cmd.ExpectedExitCodes)
+	}
+	if !cmd.Concurrent {
+		t.Errorf("expected command to be concurrent")
+	}
+	if cmd.Creates != "/var/lib/app/.migrated" {
+		t.Errorf("expected creates path, got %q", cmd.Creates)
+	}
+}
+
+func TestFileBuiltins(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"motd.txt": "welcome",
+		"app.tmpl": "name={{ .name }}",
+		"main.star": `
+motd = read_file("motd.txt")
+rendered = render_template("app.tmpl", {"name": "web"})
+host = facts().hostname
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rt := starlark.NewRuntime(nil)
+	globals, err := rt.Load(context.Background(), filepath.Join(dir, "main.star"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if motd := globals["motd"].String(); motd != `"welcome"` {
+		t.Errorf("expected motd %q, got %s", "welcome", motd)
+	}
+	if rendered := globals["rendered"].String(); rendered != `"name=web"` {
+		t.Errorf("expected rendered %q, got %s", "name=web", rendered)
+	}
+	if host := globals["host"].String(); host == `""` {
+		t.Errorf("expected non-empty hostname")
+	}
+}
+
+func TestStandardModules(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"users.yaml": "users:\n  - name: alice\n    uid: 1001\n  - name: bob\n    uid: 1002\n",
+		"main.star": `
+users = yaml.decode(read_file("users.yaml"))["users"]
+names = ",".join([u["name"] for u in users])
+config = json.encode({"workers": int(math.ceil(len(users) / 2))})
+timeout = time.parse_duration("90s").seconds
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rt := starlark.NewRuntime(nil)
+	globals, err := rt.Load(context.Background(), filepath.Join(dir, "main.star"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := globals["names"].String(); names != `"alice,bob"` {
+		t.Errorf("expected names %q, got %s", "alice,bob", names)
+	}
+	if config := globals["config"].String(); config != `"{\"workers\":1}"` {
+		t.Errorf("expected config %q, got %s", `{"workers":1}`, config)
+	}
+	if timeout := globals["timeout"].String(); timeout != "90.0" {
+		t.Errorf("expected timeout 90.0, got %s", timeout)
+	}
+}
+
+func TestLoaderOrderAndIds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.star")
+	src := `
+def user_dir(name):
+    return resources.directory(state = "present", path = "/home/" + name, id = "home-" + name)
+
+base = resources.directory(state = "present", path = "/srv")
+dirs = [resources.directory(state = "present", path = "/srv/" + n, dependencies = [base]) for n in ["a", "b"]]
+user_dir("alice")
+resources.file(state = "present", path = "/srv/motd")
+`
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loader := &starlark.Loader{}
+	specs, err := loader.Load(context.Background(), &config.Config{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"base", "dirs[0]", "dirs[1]", "home-alice", "file:/srv/motd"}
+	if len(specs) != len(expected) {
+		t.Fatalf("expected %d specs, got %d", len(expected), len(specs))
+	}
+	for i, id := range expected {
+		if specs[i].Id != id {
+			t.Errorf("spec %d: expected id %q, got %q", i, id, specs[i].Id)
+		}
+	}
+	if deps := specs[2].Dependencies; len(deps) != 1 || deps[0] != "base" {
+		t.Errorf("expected dependency on base, got %v", deps)
+	}
+}
+
+func TestLoaderModules(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"app.star": `
+root = resources.directory(state = "present", path = "/srv/" + params["name"])
+config = resources.file(state = "present", path = "/srv/" + params["name"] + "/config.yml", dependencies = [root])
+`,
+		"main.star": `
+base = resources.directory(state = "present", path = "/srv")
+web = module("app.star", params = {"name": "web"}, dependencies = [base])
+done = resources.command(command = "true", dependencies = [web])
+reload = resources.command(command = "reload", dependencies = [web.config])
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loader := &starlark.Loader{}
+	specs, err := loader.Load(context.Background(), &config.Config{}, filepath.Join(dir, "main.star"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"base":       nil,
+		"web.root":   {"base"},
+		"web.config": {"web.root", "base"},
+		"done":       {"web.root", "web.config"},
+		"reload":     {"web.config"},
+	}
+	if len(specs) != len(expected) {
+		t.Fatalf("expected %d specs, got %d", len(expected), len(specs))
+	}
+	for _, spec := range specs {
+		deps, ok := expected[spec.Id]
+		if !ok {
+			t.Errorf("unexpected spec %q", spec.Id)
+			continue
+		}
+		if !slices.Equal(spec.Dependencies, deps) {
+			t.Errorf("spec %q: expected dependencies %v, got %v", spec.Id, deps, spec.Dependencies)
+		}
+	}
+}
+
+func TestLoaderRegister(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.star")
+	src := `
+def vhosts(names):
+    return register("vhosts", {
+        name: [
+            resources.directory(state = "present", path = "/srv/" + name),
+            resources.file(state = "present", path = "/srv/" + name + "/index.html"),
+        ]
+        for name in names
+    })
+
+vhosts(["a", "b"])
+`
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loader := &starlark.Loader{}
+	specs, err := loader.Load(context.Background(), &config.Config{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"vhosts[a][0]", "vhosts[a][1]", "vhosts[b][0]", "vhosts[b][1]"}
+	if len(specs) != len(expected) {
+		t.Fatalf("expected %d specs, got %d", len(expected), len(specs))
+	}
+	for i, id := range expected {
+		if specs[i].Id != id {
+			t.Errorf("spec %d: expected id %q, got %q", i, id, specs[i].Id)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package starlark
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+)
+
+// NewService returns a starlark.Builtin for creating Service resources
+func NewService() *starlark.Builtin {
+	return starlark.NewBuiltin("service", newService)
+}
+
+func newService(
+	thread *starlark.Thread,
+	b *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var name starlark.String
+	var running, enabled starlark.Value = starlark.None, starlark.None
+	var dependencies *starlark.List
+	var id starlark.String
+
+	err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"name", &name,
+		"running?", &running,
+		"enabled?", &enabled,
+		"dependencies?", &dependencies,
+		"id?", &id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate required fields
+	if string(name) == "" {
+		return nil, fmt.Errorf("name cannot be empty")
+	}
+
+	svc := &Service{
+		Name:        string(id),
+		ServiceName: string(name),
+	}
+
+	// Running and enabled are left unmanaged unless given
+	if svc.Running, err = optionalBool("running", running); err != nil {
+		return nil, err
+	}
+	if svc.Enabled, err = optionalBool("enabled", enabled); err != nil {
+		return nil, err
+	}
+
+	// Parse dependencies as resource values
+	if dependencies != nil {
+		deps, err := parseDependencies(dependencies)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dependencies: %w", err)
+		}
+		svc.Dependencies = deps
+	}
+
+	record(thread, svc)
+
+	return svc, nil
+}
+
+type Service struct {
+	// Name is the explicit id given via the id kwarg
+	Name string
+
+	ServiceName  string
+	Running      *bool
+	Enabled      *bool
+	Dependencies []starlark.Value
+}
+
+func (s *Service) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "name":
+		return starlark.String(s.ServiceName), nil
+	case "running":
+		return optionalBoolValue(s.Running), nil
+	case "enabled":
+		return optionalBoolValue(s.Enabled), nil
+	case "id":
+		return starlark.String(s.Name), nil
+	case "dependencies":
+		deps := make([]starlark.Value, len(s.Dependencies))
+		copy(deps, s.Dependencies)
+		return starlark.NewList(deps), nil
+	default:
+		return nil, nil
+	}
+}
+
+func (s *Service) Id() string {
+	return "service:" + s.ServiceName
+}
+
+func (s *Service) ExplicitId() string {
+	return s.Name
+}
+
+func (s *Service) AttrNames() []string {
+	return []string{"name", "running", "enabled", "dependencies", "id"}
+}
+
+func (s *Service) Type() string {
+	return "service"
+}
+
+func (s *Service) Freeze() {
+	// Freeze dependencies as well
+	for _, dep := range s.Dependencies {
+		dep.Freeze()
+	}
+}
+
+func (s *Service) Truth() starlark.Bool {
+	return starlark.True
+}
+
+func (s *Service) Hash() (uint32, error) {
+	return 0, fmt.Errorf("service is unhashable")
+}
+
+func (s *Service) String() string {
+	return s.Id()
+}
+
+func (s *Service) GetDependencies() []starlark.Value {
+	deps := make([]starlark.Value, len(s.Dependencies))
+	copy(deps, s.Dependencies)
+	return deps
+}
@@ -0,0 +1,138 @@
+package starlark
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+)
+
+// NewSymlink returns a starlark.Builtin for creating Symlink resources
+func NewSymlink() *starlark.Builtin {
+	return starlark.NewBuiltin("symlink", newSymlink)
+}
+
+func newSymlink(
+	thread *starlark.Thread,
+	b *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var state, path, target starlark.String
+	var force starlark.Bool
+	var dependencies *starlark.List
+	var id starlark.String
+
+	err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"state", &state,
+		"path", &path,
+		"target?", &target,
+		"force?", &force,
+		"dependencies?", &dependencies,
+		"id?", &id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate required fields
+	if string(state) == "" {
+		return nil, fmt.Errorf("state cannot be empty")
+	}
+	if string(path) == "" {
+		return nil, fmt.Errorf("path cannot be empty")
+	}
+
+	link := &Symlink{
+		Name:   string(id),
+		State:  string(state),
+		Path:   string(path),
+		Target: string(target),
+		Force:  bool(force),
+	}
+
+	// Parse dependencies as resource values
+	if dependencies != nil {
+		deps, err := parseDependencies(dependencies)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dependencies: %w", err)
+		}
+		link.Dependencies = deps
+	}
+
+	record(thread, link)
+
+	return link, nil
+}
+
+type Symlink struct {
+	// Name is the explicit id given via the id kwarg
+	Name string
+
+	State        string
+	Path         string
+	Target       string
+	Force        bool
+	Dependencies []starlark.Value
+}
+
+func (s *Symlink) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "state":
+		return starlark.String(s.State), nil
+	case "path":
+		return starlark.String(s.Path), nil
+	case "target":
+		return starlark.String(s.Target), nil
+	case "force":
+		return starlark.Bool(s.Force), nil
+	case "id":
+		return starlark.String(s.Name), nil
+	case "dependencies":
+		deps := make([]starlark.Value, len(s.Dependencies))
+		copy(deps, s.Dependencies)
+		return starlark.NewList(deps), nil
+	default:
+		return nil, nil
+	}
+}
+
+func (s *Symlink) Id() string {
+	return "symlink:" + s.Path
+}
+
+func (s *Symlink) ExplicitId() string {
+	return s.Name
+}
+
+func (s *Symlink) AttrNames() []string {
+	return []string{"state", "path", "target", "force", "dependencies", "id"}
+}
+
+func (s *Symlink) Type() string {
+	return "symlink"
+}
+
+func (s *Symlink) Freeze() {
+	// Freeze dependencies as well
+	for _, dep := range s.Dependencies {
+		dep.Freeze()
+	}
+}
+
+func (s *Symlink) Truth() starlark.Bool {
+	return starlark.True
+}
+
+func (s *Symlink) Hash() (uint32, error) {
+	return 0, fmt.Errorf("symlink is unhashable")
+}
+
+func (s *Symlink) String() string {
+	return s.Id()
+}
+
+func (s *Symlink) GetDependencies() []starlark.Value {
+	deps := make([]starlark.Value, len(s.Dependencies))
+	copy(deps, s.Dependencies)
+	return deps
+}
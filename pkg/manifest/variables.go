@@ -0,0 +1,40 @@
+package manifest
+
+import (
+	"context"
+
+	"peertech.de/axion/pkg/config"
+)
+
+// Sources of the value of a variable
+const (
+	// SourceManifest marks values declared in the manifest
+	SourceManifest = "manifest"
+	// SourceOverride marks values overriding the manifest, e.g. from --var or --var-file
+	SourceOverride = "override"
+	// SourceRemote marks values fetched from a remote source
+	SourceRemote = "remote"
+	// SourceSecret marks values resolved from a secret reference
+	SourceSecret = "secret"
+	// SourceEnv marks environment variables read by the manifest
+	SourceEnv = "env"
+)
+
+// Variable describes a variable of a manifest, where its value comes from and which
+// resources reference it
+type Variable struct {
+	Name   string
+	Source string
+	// Value is the resolved value, secret values are not redacted
+	Value     any
+	Sensitive bool
+	// References are the ids of the resources referencing the variable, as declared in
+	// the manifest before expansion
+	References []string
+}
+
+// VariableInspector is implemented by loaders that can list the variables of a
+// manifest with their resolved values. Inspecting must not require an agent.
+type VariableInspector interface {
+	Variables(ctx context.Context, cfg *config.Config, path string) ([]Variable, error)
+}
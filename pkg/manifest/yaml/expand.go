@@ -0,0 +1,179 @@
+package yaml
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"peertech.de/axion/pkg/manifest"
+)
+
+// placeholderPattern matches the instance placeholders available in resources using
+// count or for_each: ${count.index}, ${each.key}, ${each.value} and ${each.value.<field>}.
+var placeholderPattern = regexp.MustCompile(`\$\{\s*(count\.index|each\.key|each\.value(?:\.[A-Za-z0-9_-]+)*)\s*\}`)
+
+// instance describes a single expansion of a resource using count or for_each.
+type instance struct {
+	key   string
+	index int
+	value any
+}
+
+// expand replaces every resource using count or for_each with one resource per instance.
+// Instances are identified by "<id>[<key>]". Dependencies and before references to the
+// id of an expanded resource are rewritten to reference all of its instances.
+func expand(m *Manifest) error {
+	groups := make(map[string][]string)
+
+	var out []Resource
+	for _, res := range m.Resources {
+		instances, err := instancesOf(res)
+		if err != nil {
+			return fmt.Errorf("resource %q: %w", res.Id, err)
+		}
+		if instances == nil {
+			out = append(out, res)
+			continue
+		}
+
+		ids := make([]string, 0, len(instances))
+		for _, inst := range instances {
+			expanded := Resource{
+				Id:           fmt.Sprintf("%s[%s]", res.Id, inst.key),
+				Type:         res.Type,
+				State:        substitute(res.State, inst).(string),
+				Properties:   substitute(res.Properties, inst).(map[string]any),
+				Dependencies: substitute(res.Dependencies, inst).([]string),
+				Before:       substitute(res.Before, inst).([]string),
+				Options:      res.Options,
+			}
+			ids = append(ids, expanded.Id)
+			out = append(out, expanded)
+		}
+		groups[res.Id] = ids
+	}
+
+	// Rewrite references to groups to references to all instances
+	for i := range out {
+		out[i].Dependencies = ungroup(out[i].Dependencies, groups)
+		out[i].Before = ungroup(out[i].Before, groups)
+	}
+
+	m.Resources = out
+	return nil
+}
+
+// ungroup replaces every reference to a group with references to all of its members
+func ungroup(refs []string, groups map[string][]string) []string {
+	var out []string
+	for _, ref := range refs {
+		if ids, ok := groups[ref]; ok {
+			out = append(out, ids...)
+		} else {
+			out = append(out, ref)
+		}
+	}
+	return out
+}
+
+// instancesOf returns the instances of a resource or nil if the resource uses neither
+// count nor for_each.
+func instancesOf(res Resource) ([]instance, error) {
+	switch {
+	case res.Count != nil && res.ForEach != nil:
+		return nil, fmt.Errorf("count and for_each are mutually exclusive")
+	case res.Count != nil:
+		if *res.Count < 0 {
+			return nil, fmt.Errorf("count must not be negative")
+		}
+		instances := make([]instance, *res.Count)
+		for i := range instances {
+			instances[i] = instance{key: strconv.Itoa(i), index: i, value: i}
+		}
+		return instances, nil
+	case res.ForEach != nil:
+		switch items := res.ForEach.(type) {
+		case []any:
+			instances := make([]instance, len(items))
+			for i, item := range items {
+				instances[i] = instance{key: strconv.Itoa(i), index: i, value: item}
+			}
+			return instances, nil
+		case map[string]any:
+			keys := make([]string, 0, len(items))
+			for k := range items {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			instances := make([]instance, len(keys))
+			for i, k := range keys {
+				instances[i] = instance{key: k, index: i, value: items[k]}
+			}
+			return instances, nil
+		default:
+			return nil, fmt.Errorf("for_each must be a list or a map, got %T", res.ForEach)
+		}
+	}
+
+	return nil, nil
+}
+
+// substitute returns a copy of v with all instance placeholders replaced.
+func substitute(v any, inst instance) any {
+	switch v := v.(type) {
+	case string:
+		return placeholderPattern.ReplaceAllStringFunc(v, func(match string) string {
+			expr := placeholderPattern.FindStringSubmatch(match)[1]
+			return manifest.ToString(resolvePlaceholder(expr, inst))
+		})
+	case []string:
+		if v == nil {
+			return []string(nil)
+		}
+		out := make([]string, len(v))
+		for i, item := range v {
+			out[i] = substitute(item, inst).(string)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = substitute(item, inst)
+		}
+		return out
+	case map[string]any:
+		if v == nil {
+			return map[string]any(nil)
+		}
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[k] = substitute(item, inst)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func resolvePlaceholder(expr string, inst instance) any {
+	switch expr {
+	case "count.index":
+		return inst.index
+	case "each.key":
+		return inst.key
+	}
+
+	// each.value with an optional field path
+	value := inst.value
+	for _, field := range strings.Split(expr, ".")[2:] {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return ""
+		}
+		value = m[field]
+	}
+	return value
+}
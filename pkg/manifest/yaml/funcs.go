@@ -0,0 +1,104 @@
+package yaml
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+
+	"peertech.de/axion/pkg/manifest"
+)
+
+// templateFuncs returns the functions available in manifest templates. The set is
+// modelled after the commonly used Helm/Sprig functions.
+func templateFuncs(allowedEnv []string) template.FuncMap {
+	return template.FuncMap{
+		"env": func(name string) (string, error) {
+			return manifest.Getenv(allowedEnv, name)
+		},
+		"default":  defaultValue,
+		"required": required,
+		"toYaml":   toYaml,
+		"indent":   indent,
+		"b64enc":   b64enc,
+		"trim":     strings.TrimSpace,
+		"lookup":   lookup,
+	}
+}
+
+// defaultValue returns def if value is empty, otherwise value.
+//
+// Usage: {{ .owner | default "root" }}
+func defaultValue(def, value any) any {
+	if isEmpty(value) {
+		return def
+	}
+	return value
+}
+
+// required fails template execution with msg if value is empty.
+//
+// Usage: {{ required "owner must be set" .owner }}
+func required(msg string, value any) (any, error) {
+	if isEmpty(value) {
+		return nil, fmt.Errorf("%s", msg)
+	}
+	return value, nil
+}
+
+// toYaml encodes value as YAML without the trailing newline.
+func toYaml(value any) (string, error) {
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// indent prefixes every line of s with n spaces.
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+func b64enc(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// lookup resolves a dot separated key path within a map and returns nil if any segment
+// is missing.
+//
+// Usage: {{ lookup "database.port" . | default 5432 }}
+func lookup(key string, data any) any {
+	current := data
+	for _, segment := range strings.Split(key, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil
+		}
+		current, ok = m[segment]
+		if !ok {
+			return nil
+		}
+	}
+	return current
+}
+
+func isEmpty(value any) bool {
+	if value == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Array, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	default:
+		return v.IsZero()
+	}
+}
@@ -0,0 +1,178 @@
+package yaml
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"gopkg.in/yaml.v3"
+
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/manifest"
+)
+
+// Lint reports variables which are declared in the manifest but never referenced by a
+// template action.
+func (l *Loader) Lint(cfg *config.Config, path string) ([]manifest.Diagnostic, error) {
+	raw, err := manifest.Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest file error: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("parse manifest error: %w", err)
+	}
+
+	tmpl, err := template.New("manifest").
+		Funcs(templateFuncs(cfg.AllowedEnv)).
+		Parse(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("template parse error: %w", err)
+	}
+
+	used := make(map[string]bool)
+	if !referencedVariables(tmpl.Tree.Root, used) {
+		// The whole variable set is passed around, usage can't be determined
+		return nil, nil
+	}
+
+	var diags []manifest.Diagnostic
+	for _, key := range variableKeys(&doc) {
+		if used[key.Value] {
+			continue
+		}
+		diags = append(diags, manifest.Diagnostic{
+			Severity: manifest.SeverityWarning,
+			Position: manifest.Position{File: path, Line: key.Line, Column: key.Column},
+			Message:  fmt.Sprintf("variable %q is declared but never used", key.Value),
+		})
+	}
+
+	return diags, nil
+}
+
+// variableKeys returns the key nodes of the top level variables mapping
+func variableKeys(doc *yaml.Node) []*yaml.Node {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "variables" {
+			continue
+		}
+		vars := root.Content[i+1]
+		if vars.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		keys := make([]*yaml.Node, 0, len(vars.Content)/2)
+		for j := 0; j < len(vars.Content); j += 2 {
+			keys = append(keys, vars.Content[j])
+		}
+		return keys
+	}
+	return nil
+}
+
+// referencedVariables records the names of the top level variables referenced within
+// the template tree in used. It returns false if the variables are passed as a whole
+// (e.g. {{ toYaml . }}) in which case any variable may be used.
+func referencedVariables(node parse.Node, used map[string]bool) bool {
+	return walkReferences(node, func(ref reference) {
+		if !ref.env {
+			used[ref.name] = true
+		}
+	})
+}
+
+// reference is a top level variable or, if env is set, an environment variable read by
+// a template action
+type reference struct {
+	name string
+	env  bool
+	pos  parse.Pos
+}
+
+// walkReferences calls visit for every reference within the template tree. Like
+// referencedVariables it returns false if the variables are passed as a whole.
+func walkReferences(node parse.Node, visit func(reference)) bool {
+	complete := true
+	var walk func(parse.Node, bool)
+	walk = func(node parse.Node, lookup bool) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child, false)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe, false)
+		case *parse.IfNode:
+			walk(&n.BranchNode, false)
+		case *parse.RangeNode:
+			walk(&n.BranchNode, false)
+		case *parse.WithNode:
+			walk(&n.BranchNode, false)
+		case *parse.BranchNode:
+			walk(n.Pipe, false)
+			walk(n.List, false)
+			walk(n.ElseList, false)
+		case *parse.TemplateNode:
+			walk(n.Pipe, false)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd, false)
+			}
+		case *parse.CommandNode:
+			// lookup "a.b" . references the first segment of its key
+			isLookup := len(n.Args) > 0 && isIdentifier(n.Args[0], "lookup")
+			isEnv := len(n.Args) > 0 && isIdentifier(n.Args[0], "env")
+			for i, arg := range n.Args {
+				if (isLookup || isEnv) && i == 1 {
+					if s, ok := arg.(*parse.StringNode); ok {
+						if isEnv {
+							visit(reference{name: s.Text, env: true, pos: s.Pos})
+						} else {
+							visit(reference{name: strings.SplitN(s.Text, ".", 2)[0], pos: s.Pos})
+						}
+						continue
+					}
+				}
+				walk(arg, isLookup)
+			}
+		case *parse.FieldNode:
+			visit(reference{name: n.Ident[0], pos: n.Pos})
+		case *parse.VariableNode:
+			if len(n.Ident) > 1 && n.Ident[0] == "$" {
+				visit(reference{name: n.Ident[1], pos: n.Pos})
+			} else if len(n.Ident) == 1 && n.Ident[0] == "$" {
+				complete = false
+			}
+		case *parse.ChainNode:
+			walk(n.Node, false)
+		case *parse.DotNode:
+			if !lookup {
+				complete = false
+			}
+		}
+	}
+	walk(node, false)
+	return complete
+}
+
+func isIdentifier(node parse.Node, name string) bool {
+	ident, ok := node.(*parse.IdentifierNode)
+	return ok && ident.Ident == name
+}
@@ -4,38 +4,65 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"html/template"
-	"os"
+	"path/filepath"
+	"text/template"
 
 	"gopkg.in/yaml.v3"
 
 	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/manifest"
+	"peertech.de/axion/pkg/manifest/remote"
 	"peertech.de/axion/pkg/orchestrator"
 	"peertech.de/axion/pkg/resource"
+	"peertech.de/axion/pkg/secret"
 )
 
 // Manifest represents the complete YAML manifest structure containing variables for
-// templating and a list of resources to be managed.
+// templating, per resource type property defaults and a list of resources to be managed.
 type Manifest struct {
-	Variables map[string]any `yaml:"variables" json:"variables"`
-	Resources []Resource     `yaml:"resources" json:"resources"`
+	Variables map[string]any            `yaml:"variables,omitempty" json:"variables,omitempty"`
+	Defaults  map[string]map[string]any `yaml:"defaults,omitempty" json:"defaults,omitempty"`
+	Resources []Resource                `yaml:"resources" json:"resources"`
 }
 
-// Resource represents a single resource definition in the manifest.
+// Resource represents a single resource definition in the manifest. A resource using
+// Count or ForEach is expanded into multiple resources when the manifest is loaded.
+// Before is the inverse of Dependencies: the listed resources depend on this resource.
 type Resource struct {
-	Id           string         `yaml:"id" json:"id"`
-	Type         string         `yaml:"type" json:"type"`
-	State        string         `yaml:"state" json:"state"`
-	Properties   map[string]any `yaml:"properties" json:"properties"`
-	Dependencies []string       `yaml:"dependencies" json:"dependencies"`
+	Id           string            `yaml:"id" json:"id"`
+	Type         string            `yaml:"type" json:"type"`
+	State        string            `yaml:"state,omitempty" json:"state,omitempty"`
+	Count        *int              `yaml:"count,omitempty" json:"count,omitempty"`
+	ForEach      any               `yaml:"for_each,omitempty" json:"for_each,omitempty"`
+	Properties   map[string]any    `yaml:"properties,omitempty" json:"properties,omitempty"`
+	Dependencies []string          `yaml:"dependencies,omitempty" json:"dependencies,omitempty"`
+	Before       []string          `yaml:"before,omitempty" json:"before,omitempty"`
+	Options      *manifest.Options `yaml:"options,omitempty" json:"options,omitempty"`
 }
 
-// Loader implements the manifest.Loader interface for YAML-based manifests
+// loadOptions are the settings shared by a manifest and all of its modules
+type loadOptions struct {
+	// allowedEnv are the environment variables accessible through the env function
+	allowedEnv []string
+	// remote fetches variables from remote sources, may be nil
+	remote *remote.Resolver
+	// secrets resolves secret references within the variables, may be nil
+	secrets *secret.Resolver
+	// stack holds the paths of the manifests currently being loaded to detect cycles
+	stack []string
+}
+
+// Loader implements the manifest.Loader interface for YAML-based manifests. As JSON is a
+// subset of YAML, it also handles JSON manifests.
 type Loader struct{}
 
 // Load executes a Starlark script and extracts resource specifications
 func (l *Loader) Load(ctx context.Context, cfg *config.Config, path string) ([]orchestrator.ResourceSpec, error) {
-	m, err := load(path)
+	m, err := load(ctx, path, cfg.Variables, loadOptions{
+		allowedEnv: cfg.AllowedEnv,
+		remote:     remote.NewResolver(),
+		secrets:    cfg.Secrets,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("manifest load error [%s]: %w", path, err)
 	}
@@ -54,10 +81,16 @@ func (l *Loader) Load(ctx context.Context, cfg *config.Config, path string) ([]o
 	var out []orchestrator.ResourceSpec
 	for _, spec := range m.Resources {
 		r := resources[spec.Id]
+		opts, err := manifest.SpecOptions(spec.Options)
+		if err != nil {
+			return nil, fmt.Errorf("manifest error: invalid options for %q: %s", spec.Id, err.Error())
+		}
 		out = append(out, orchestrator.ResourceSpec{
 			Id:           spec.Id,
 			Resource:     r,
 			Dependencies: spec.Dependencies,
+			Options:      opts,
+			Digest:       manifest.ResourceDigest(spec.Type, spec.State, spec.Properties),
 		})
 	}
 
@@ -66,16 +99,32 @@ func (l *Loader) Load(ctx context.Context, cfg *config.Config, path string) ([]o
 
 // load reads and processes a YAML manifest file with template variable substitution.
 //
-// Template syntax uses {{ }} delimiters for variable substitution.
+// Template syntax uses {{ }} delimiters for variable substitution. See templateFuncs for
+// the functions available within templates.
+//
+// Remote variables are fetched and secret references within the variables are resolved
+// before templating.
 //
 // Parameters:
+//   - ctx: Context for cancellation, used when resolving secrets
 //   - path: File system path to the YAML manifest file
+//   - overrides: Variables that take precedence over the ones declared in the manifest
+//   - opts: Settings shared by the manifest and all of its modules
 //
 // Returns:
 //   - *Manifest: Parsed manifest with all variables substituted
-//   - error: Any error from file reading, template parsing, or YAML parsing
-func load(path string) (*Manifest, error) {
-	raw, err := os.ReadFile(path)
+//   - error: Any error from file reading, template parsing, or YAML parsing. Schema
+//     violations are reported as *manifest.SchemaError.
+func load(ctx context.Context, path string, overrides map[string]any, opts loadOptions) (*Manifest, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := manifest.CheckCycle(abs, opts.stack); err != nil {
+		return nil, err
+	}
+
+	raw, err := manifest.Read(path)
 	if err != nil {
 		return nil, fmt.Errorf("read manifest file error: %w", err)
 	}
@@ -87,32 +136,97 @@ func load(path string) (*Manifest, error) {
 	if err := yaml.Unmarshal(raw, &preliminary); err != nil {
 		return nil, fmt.Errorf("parse variables error: %w", err)
 	}
+	vars, err := opts.remote.ResolveVariables(ctx, mergeVariables(preliminary.Variables, overrides))
+	if err != nil {
+		return nil, fmt.Errorf("remote variables error: %w", err)
+	}
+	vars, err = opts.secrets.ResolveVariables(ctx, vars)
+	if err != nil {
+		return nil, fmt.Errorf("resolve variables error: %w", err)
+	}
 
 	// Substitute variables
-	tmpl, err := template.New("manifest").Delims("{{", "}}").Parse(string(raw))
+	tmpl, err := template.New("manifest").
+		Delims("{{", "}}").
+		Funcs(templateFuncs(opts.allowedEnv)).
+		Parse(string(raw))
 	if err != nil {
 		return nil, fmt.Errorf("template parse error: %w", err)
 	}
 
 	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, preliminary.Variables); err != nil {
+	if err := tmpl.Execute(&buf, vars); err != nil {
 		return nil, fmt.Errorf("template execution error: %w", err)
 	}
 
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		return nil, fmt.Errorf("final manifest parse error: %w", err)
+	}
+
+	if err := validate(&doc, path); err != nil {
+		return nil, err
+	}
+
 	var m Manifest
-	if err := yaml.Unmarshal(buf.Bytes(), &m); err != nil {
+	if err := doc.Decode(&m); err != nil {
 		return nil, fmt.Errorf("final manifest parse error: %w", err)
 	}
 
+	applyDefaults(&m)
+
+	if err := expand(&m); err != nil {
+		return nil, fmt.Errorf("resource expansion error: %w", err)
+	}
+
+	opts.stack = append(opts.stack, abs)
+	if err := expandModules(ctx, &m, abs, opts); err != nil {
+		return nil, fmt.Errorf("module expansion error: %w", err)
+	}
+
+	err = manifest.ApplyBefore(m.Resources, func(r *Resource) (string, *[]string, *[]string) {
+		return r.Id, &r.Before, &r.Dependencies
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	return &m, nil
 }
 
+// applyDefaults merges the defaults declared for a resource type into the properties of
+// every resource of that type. Properties set on the resource itself take precedence.
+func applyDefaults(m *Manifest) {
+	for i := range m.Resources {
+		res := &m.Resources[i]
+
+		defaults, ok := m.Defaults[res.Type]
+		if !ok {
+			continue
+		}
+
+		if res.Properties == nil {
+			res.Properties = make(map[string]any, len(defaults))
+		}
+		for k, v := range defaults {
+			if _, exists := res.Properties[k]; !exists {
+				res.Properties[k] = v
+			}
+		}
+	}
+}
+
 // instantiateResource creates a concrete resource object from a resource specification.
 // The function maps resource types to their corresponding implementations and validates
 // the resulting resource if it implements the Validatable interface.
 //
 // Currently supported resource types:
+//   - "command": Commands with command property
+//   - "script": Scripts with script and interpreter properties
 //   - "file": File system resources with path, mode, owner, and group properties
+//   - "symlink": Symbolic links with path, target and force properties
+//   - "package": Packages with name, version and pinned properties
+//   - "service": systemd services with name, running and enabled properties
 //
 // Parameters:
 //   - cfg: Application configuration needed for resource construction
@@ -124,32 +238,81 @@ func load(path string) (*Manifest, error) {
 func instantiateResource(cfg *config.Config, res Resource) (resource.Resource, error) {
 	var r resource.Resource
 
+	if err := manifest.CheckOptions(res.Type, res.Options); err != nil {
+		return nil, fmt.Errorf("invalid %q resource (id: %s): %w", res.Type, res.Id, err)
+	}
+
 	switch res.Type {
-	case "command":
+	case "command", "script":
 		props := res.Properties
+
+		opts := manifest.CommandOptions(res.Options)
+
+		if res.Type == "script" {
+			interpreter, _ := props["interpreter"].(string)
+			r = resource.NewScript(cfg, manifest.ToString(props["script"]), interpreter, opts...)
+			break
+		}
 		r = resource.NewCommand(
 			cfg,
-			toString(props["command"]),
+			manifest.ToString(props["command"]),
+			opts...,
 		)
 	case "file":
 		props := res.Properties
 		r = resource.NewFile(
 			cfg,
 			resource.State(res.State),
-			toString(props["path"]),
-			optString(props["mode"]),
-			optString(props["owner"]),
-			optString(props["group"]),
+			manifest.ToString(props["path"]),
+			manifest.OptString(props["mode"]),
+			manifest.OptString(props["owner"]),
+			manifest.OptString(props["group"]),
 		)
 	case "directory":
 		props := res.Properties
+
 		r = resource.NewDirectory(
 			cfg,
 			resource.State(res.State),
-			toString(props["path"]),
-			optString(props["mode"]),
-			optString(props["owner"]),
-			optString(props["group"]),
+			manifest.ToString(props["path"]),
+			manifest.OptString(props["mode"]),
+			manifest.OptString(props["owner"]),
+			manifest.OptString(props["group"]),
+			manifest.DirectoryOptions(res.Options)...,
+		)
+	case "symlink":
+		props := res.Properties
+		// Target is optional for absent symlinks
+		target, _ := props["target"].(string)
+		force, _ := props["force"].(bool)
+		r = resource.NewSymlink(
+			cfg,
+			resource.State(res.State),
+			manifest.ToString(props["path"]),
+			target,
+			force,
+		)
+	case "package":
+		props := res.Properties
+		// Unquoted versions like 1.2 are decoded as numbers
+		var version string
+		if v := props["version"]; v != nil {
+			version = manifest.ToString(v)
+		}
+		r = resource.NewPackage(
+			cfg,
+			resource.State(res.State),
+			manifest.ToString(props["name"]),
+			version,
+			manifest.OptBool(props["pinned"]),
+		)
+	case "service":
+		props := res.Properties
+		r = resource.NewService(
+			cfg,
+			manifest.ToString(props["name"]),
+			manifest.OptBool(props["running"]),
+			manifest.OptBool(props["enabled"]),
 		)
 	default:
 		return nil, fmt.Errorf("unsupported resource type %q", res.Type)
@@ -166,17 +329,15 @@ func instantiateResource(cfg *config.Config, res Resource) (resource.Resource, e
 	return r, nil
 }
 
-func toString(v any) string {
-	if s, ok := v.(string); ok {
-		return s
+// mergeVariables returns a new map containing all variables from base, with the values
+// from overrides taking precedence.
+func mergeVariables(base, overrides map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(overrides))
+	for k, v := range base {
+		merged[k] = v
 	}
-	return fmt.Sprintf("%v", v)
-}
-
-func optString(v any) *string {
-	if v == nil {
-		return nil
+	for k, v := range overrides {
+		merged[k] = v
 	}
-	s := toString(v)
-	return &s
+	return merged
 }
@@ -0,0 +1,626 @@
+package yaml
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/manifest"
+)
+
+func writeManifest(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "manifest.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadVariableOverrides(t *testing.T) {
+	path := writeManifest(t, `
+variables:
+  owner: alice
+  group: staff
+
+resources:
+  - id: a
+    type: file
+    state: present
+    properties:
+      path: /tmp/a.txt
+      owner: "{{ .owner }}"
+      group: "{{ .group }}"
+`)
+
+	tests := []struct {
+		name      string
+		overrides map[string]any
+		owner     string
+		group     string
+	}{
+		{
+			name:  "manifest variables",
+			owner: "alice",
+			group: "staff",
+		},
+		{
+			name:      "override existing variable",
+			overrides: map[string]any{"owner": "bob"},
+			owner:     "bob",
+			group:     "staff",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := load(context.Background(), path, tt.overrides, loadOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Resources) != 1 {
+				t.Fatalf("expected 1 resource, got %d", len(m.Resources))
+			}
+
+			props := m.Resources[0].Properties
+			if props["owner"] != tt.owner {
+				t.Errorf("expected owner %q, got %q", tt.owner, props["owner"])
+			}
+			if props["group"] != tt.group {
+				t.Errorf("expected group %q, got %q", tt.group, props["group"])
+			}
+		})
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("AXION_TEST_OWNER", "carol")
+
+	path := writeManifest(t, `
+resources:
+  - id: a
+    type: file
+    state: present
+    properties:
+      path: /tmp/a.txt
+      owner: '{{ env "AXION_TEST_OWNER" }}'
+`)
+
+	if _, err := load(context.Background(), path, nil, loadOptions{}); err == nil {
+		t.Errorf("expected error for environment variable without allowlist")
+	}
+
+	for _, allowed := range [][]string{{"AXION_TEST_OWNER"}, {"*"}} {
+		m, err := load(context.Background(), path, nil, loadOptions{allowedEnv: allowed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if owner := m.Resources[0].Properties["owner"]; owner != "carol" {
+			t.Errorf("allowlist %v: expected owner %q, got %q", allowed, "carol", owner)
+		}
+	}
+
+	if _, err := load(context.Background(), path, nil, loadOptions{allowedEnv: []string{"HOME"}}); err == nil {
+		t.Errorf("expected error for environment variable outside the allowlist")
+	}
+}
+
+func TestLoadTemplateFuncs(t *testing.T) {
+	path := writeManifest(t, `
+variables:
+  app:
+    name: " web "
+  token: secret
+
+resources:
+  - id: a
+    type: command
+    properties:
+      command: 'echo {{ .missing | default "fallback" }} {{ lookup "app.name" . | trim }} {{ b64enc .token }}'
+`)
+
+	m, err := load(context.Background(), path, nil, loadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "echo fallback web c2VjcmV0"
+	if cmd := m.Resources[0].Properties["command"]; cmd != expected {
+		t.Errorf("expected command %q, got %q", expected, cmd)
+	}
+}
+
+func TestLoadTemplateRequired(t *testing.T) {
+	path := writeManifest(t, `
+resources:
+  - id: a
+    type: command
+    properties:
+      command: 'echo {{ required "message must be set" .message }}'
+`)
+
+	if _, err := load(context.Background(), path, nil, loadOptions{}); err == nil {
+		t.Errorf("expected error for missing required variable")
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	path := writeManifest(t, `
+defaults:
+  file:
+    owner: root
+    mode: "0644"
+
+resources:
+  - id: a
+    type: file
+    state: present
+    properties:
+      path: /tmp/a.txt
+  - id: b
+    type: file
+    state: present
+    properties:
+      path: /tmp/b.txt
+      mode: "0600"
+  - id: c
+    type: directory
+    state: present
+    properties:
+      path: /tmp/c
+`)
+
+	m, err := load(context.Background(), path, nil, loadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		index int
+		key   string
+		value any
+	}{
+		{index: 0, key: "owner", value: "root"},
+		{index: 0, key: "mode", value: "0644"},
+		{index: 1, key: "owner", value: "root"},
+		{index: 1, key: "mode", value: "0600"},
+		{index: 2, key: "owner", value: nil},
+	}
+
+	for _, tt := range tests {
+		if got := m.Resources[tt.index].Properties[tt.key]; got != tt.value {
+			t.Errorf("resource %q: expected %s %v, got %v", m.Resources[tt.index].Id, tt.key, tt.value, got)
+		}
+	}
+}
+
+func TestLoadExpansion(t *testing.T) {
+	path := writeManifest(t, `
+resources:
+  - id: dirs
+    type: directory
+    state: present
+    for_each:
+      - name: logs
+        mode: "0750"
+      - name: data
+        mode: "0700"
+    properties:
+      path: /srv/${each.value.name}
+      mode: ${each.value.mode}
+
+  - id: markers
+    type: file
+    state: present
+    count: 2
+    properties:
+      path: /srv/marker-${count.index}
+    dependencies:
+      - dirs[${count.index}]
+
+  - id: done
+    type: command
+    properties:
+      command: "true"
+    dependencies:
+      - dirs
+`)
+
+	m, err := load(context.Background(), path, nil, loadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byId := make(map[string]Resource)
+	for _, res := range m.Resources {
+		byId[res.Id] = res
+	}
+	if len(byId) != 5 {
+		t.Fatalf("expected 5 resources, got %d", len(byId))
+	}
+
+	if path := byId["dirs[1]"].Properties["path"]; path != "/srv/data" {
+		t.Errorf("expected path %q, got %q", "/srv/data", path)
+	}
+	if mode := byId["dirs[0]"].Properties["mode"]; mode != "0750" {
+		t.Errorf("expected mode %q, got %q", "0750", mode)
+	}
+	if deps := byId["markers[1]"].Dependencies; len(deps) != 1 || deps[0] != "dirs[1]" {
+		t.Errorf("expected dependency on dirs[1], got %v", deps)
+	}
+	if deps := byId["done"].Dependencies; len(deps) != 2 {
+		t.Errorf("expected dependencies on all dirs instances, got %v", deps)
+	}
+}
+
+func TestLoadSchemaValidation(t *testing.T) {
+	path := writeManifest(t, `resources:
+  - id: a
+    type: file
+    state: present
+    properties:
+      path: /tmp/a.txt
+      mdoe: "0644"
+  - id: b
+    type: directory
+    count: two
+    properties:
+      mode: "0755"
+  - id: c
+    type: unknown
+  - id: d
+    type: symlink
+    properties:
+      path: /tmp/link
+      force: maybe
+`)
+
+	_, err := load(context.Background(), path, nil, loadOptions{})
+
+	var schemaErr *manifest.SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("expected schema error, got %v", err)
+	}
+
+	expected := []string{
+		path + ":7:7: unknown property \"mdoe\" for file resource",
+		path + ":10:12: count must be of type integer, got string",
+		path + ":12:7: missing required property \"path\" for directory resource",
+		path + ":14:11: unsupported resource type \"unknown\" (expected one of command, directory, file, module, package, service, symlink)",
+		path + ":19:14: force must be of type boolean, got string",
+	}
+	if len(schemaErr.Issues) != len(expected) {
+		t.Fatalf("expected %d issues, got %d: %v", len(expected), len(schemaErr.Issues), err)
+	}
+	for i, issue := range schemaErr.Issues {
+		if issue.String() != expected[i] {
+			t.Errorf("expected issue %q, got %q", expected[i], issue.String())
+		}
+	}
+}
+
+func TestLintUnusedVariables(t *testing.T) {
+	path := writeManifest(t, `variables:
+  owner: alice
+  unused: bob
+  app:
+    name: web
+
+resources:
+  - id: a
+    type: file
+    state: present
+    properties:
+      path: '/srv/{{ lookup "app.name" . }}'
+      owner: "{{ .owner }}"
+`)
+
+	diags, err := (&Loader{}).Lint(&config.Config{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %d: %v", len(diags), diags)
+	}
+	expected := path + `:3:3: warning: variable "unused" is declared but never used`
+	if diags[0].String() != expected {
+		t.Errorf("expected diagnostic %q, got %q", expected, diags[0].String())
+	}
+}
+
+func TestVariables(t *testing.T) {
+	t.Setenv("AXION_TEST_GROUP", "staff")
+
+	path := writeManifest(t, `variables:
+  owner: alice
+  mode: "0644"
+
+resources:
+  - id: a
+    type: file
+    state: present
+    properties:
+      path: /tmp/a.txt
+      owner: "{{ .owner }}"
+      group: '{{ env "AXION_TEST_GROUP" }}'
+  - id: b
+    type: file
+    state: present
+    properties:
+      path: /tmp/b.txt
+      owner: "{{ .owner }}"
+      mode: "{{ .mode }}"
+`)
+
+	cfg := &config.Config{
+		Variables:  map[string]any{"mode": "0600"},
+		AllowedEnv: []string{"AXION_TEST_GROUP"},
+	}
+	vars, err := (&Loader{}).Variables(context.Background(), cfg, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []manifest.Variable{
+		{Name: "mode", Source: manifest.SourceOverride, Value: "0600", References: []string{"b"}},
+		{Name: "owner", Source: manifest.SourceManifest, Value: "alice", References: []string{"a", "b"}},
+		{Name: "AXION_TEST_GROUP", Source: manifest.SourceEnv, Value: "staff", References: []string{"a"}},
+	}
+	if len(vars) != len(expected) {
+		t.Fatalf("expected %d variables, got %d: %v", len(expected), len(vars), vars)
+	}
+	for i, v := range vars {
+		e := expected[i]
+		if v.Name != e.Name || v.Source != e.Source || v.Value != e.Value || !slices.Equal(v.References, e.References) {
+			t.Errorf("expected variable %+v, got %+v", e, v)
+		}
+	}
+}
+
+func TestLoadModules(t *testing.T) {
+	dir := t.TempDir()
+	module := `
+variables:
+  name: app
+  mode: "0755"
+
+resources:
+  - id: root
+    type: directory
+    state: present
+    properties:
+      path: /srv/{{ .name }}
+      mode: "{{ .mode }}"
+  - id: config
+    type: file
+    state: present
+    properties:
+      path: /srv/{{ .name }}/config.yml
+    dependencies:
+      - root
+`
+	if err := os.WriteFile(filepath.Join(dir, "app.yaml"), []byte(module), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "manifest.yaml")
+	parent := `
+resources:
+  - id: base
+    type: directory
+    state: present
+    properties:
+      path: /srv
+  - id: web
+    type: module
+    properties:
+      source: app.yaml
+      params:
+        name: web
+    dependencies:
+      - base
+  - id: done
+    type: command
+    properties:
+      command: "true"
+    dependencies:
+      - web
+`
+	if err := os.WriteFile(path, []byte(parent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := load(context.Background(), path, nil, loadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byId := make(map[string]Resource)
+	for _, res := range m.Resources {
+		byId[res.Id] = res
+	}
+	if len(byId) != 4 {
+		t.Fatalf("expected 4 resources, got %d", len(byId))
+	}
+
+	if path := byId["web.config"].Properties["path"]; path != "/srv/web/config.yml" {
+		t.Errorf("expected path %q, got %q", "/srv/web/config.yml", path)
+	}
+	if mode := byId["web.root"].Properties["mode"]; mode != "0755" {
+		t.Errorf("expected mode %q, got %q", "0755", mode)
+	}
+	if deps := byId["web.config"].Dependencies; !slices.Equal(deps, []string{"web.root", "base"}) {
+		t.Errorf("expected dependencies on web.root and base, got %v", deps)
+	}
+	if deps := byId["done"].Dependencies; !slices.Equal(deps, []string{"web.root", "web.config"}) {
+		t.Errorf("expected dependencies on all module resources, got %v", deps)
+	}
+}
+
+func TestLoadOptions(t *testing.T) {
+	path := writeManifest(t, `
+resources:
+  - id: migrate
+    type: command
+    count: 2
+    properties:
+      command: ./migrate.sh
+    options:
+      timeout: 5m
+      retries: 3
+      concurrent: true
+      backup: false
+      tags: [db]
+`)
+
+	m, err := load(context.Background(), path, nil, loadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, res := range m.Resources {
+		opts, err := manifest.SpecOptions(res.Options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.Timeout != 5*time.Minute || opts.Retries != 3 {
+			t.Errorf("%s: unexpected timeout %s or retries %d", res.Id, opts.Timeout, opts.Retries)
+		}
+		if opts.Backup == nil || *opts.Backup {
+			t.Errorf("%s: expected backup to be disabled", res.Id)
+		}
+		if !slices.Equal(opts.Tags, []string{"db"}) {
+			t.Errorf("%s: expected tags [db], got %v", res.Id, opts.Tags)
+		}
+	}
+
+	if _, err := manifest.SpecOptions(&manifest.Options{Timeout: "soon"}); err == nil {
+		t.Errorf("expected error for invalid timeout")
+	}
+}
+
+func TestLoadBackupFilter(t *testing.T) {
+	path := writeManifest(t, `
+resources:
+  - id: data
+    type: directory
+    properties:
+      path: /var/lib/app
+    options:
+      backup_include: [db, "*.conf"]
+      backup_exclude: [cache, "*.log"]
+`)
+
+	m, err := load(context.Background(), path, nil, loadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := m.Resources[0].Options
+	if !slices.Equal(opts.BackupInclude, []string{"db", "*.conf"}) {
+		t.Errorf("expected backup_include [db *.conf], got %v", opts.BackupInclude)
+	}
+	if !slices.Equal(opts.BackupExclude, []string{"cache", "*.log"}) {
+		t.Errorf("expected backup_exclude [cache *.log], got %v", opts.BackupExclude)
+	}
+	if _, err := instantiateResource(nil, m.Resources[0]); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	file := Resource{
+		Id:         "config",
+		Type:       "file",
+		Properties: map[string]any{"path": "/etc/app.conf"},
+		Options:    &manifest.Options{BackupExclude: []string{"*.log"}},
+	}
+	if _, err := instantiateResource(nil, file); err == nil {
+		t.Errorf("expected error for backup filter on a file resource")
+	}
+}
+
+func TestLoadBefore(t *testing.T) {
+	path := writeManifest(t, `
+resources:
+  - id: app
+    type: directory
+    count: 2
+    properties:
+      path: /srv/app${count.index}
+  - id: cleanup
+    type: command
+    before: [app, config]
+    properties:
+      command: rm -rf /srv/legacy
+  - id: config
+    type: file
+    properties:
+      path: /etc/app.conf
+`)
+
+	m, err := load(context.Background(), path, nil, loadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, res := range m.Resources {
+		if res.Id == "cleanup" {
+			continue
+		}
+		if !slices.Equal(res.Dependencies, []string{"cleanup"}) {
+			t.Errorf("%s: expected dependency on cleanup, got %v", res.Id, res.Dependencies)
+		}
+	}
+
+	path = writeManifest(t, `
+resources:
+  - id: cleanup
+    type: command
+    before: [missing]
+    properties:
+      command: "true"
+`)
+	if _, err := load(context.Background(), path, nil, loadOptions{}); err == nil {
+		t.Errorf("expected error for unknown before reference")
+	}
+}
+
+func TestRender(t *testing.T) {
+	path := writeManifest(t, `
+variables:
+  root: /srv
+resources:
+  - id: dir
+    type: directory
+    count: 2
+    properties:
+      path: "{{ .root }}/${count.index}"
+`)
+
+	out, err := (&Loader{}).Render(context.Background(), &config.Config{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `variables:
+    root: /srv
+resources:
+    - id: dir[0]
+      type: directory
+      properties:
+        path: /srv/0
+    - id: dir[1]
+      type: directory
+      properties:
+        path: /srv/1
+`
+	if string(out) != expected {
+		t.Errorf("expected rendered manifest:\n%s\ngot:\n%s", expected, out)
+	}
+}
@@ -0,0 +1,87 @@
+package yaml
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"peertech.de/axion/pkg/manifest"
+)
+
+// moduleType is the resource type instantiating a module
+const moduleType = "module"
+
+// expandModules replaces every module resource with the resources of the manifest it
+// references. The module manifest is loaded with its params overriding the variables it
+// declares, so the variables block doubles as the parameter defaults.
+//
+// Resources of a module are namespaced as "<module id>.<resource id>". Dependencies
+// within the module are rewritten accordingly, dependencies and before references of the
+// module itself apply to all of its resources and references to the module id are
+// rewritten to reference all of its resources.
+//
+// Parameters:
+//   - ctx: Context for cancellation
+//   - m: Manifest to expand in place
+//   - path: Path of the manifest, module sources are resolved relative to it
+//   - opts: Settings of the manifest, passed on to its modules
+func expandModules(ctx context.Context, m *Manifest, path string, opts loadOptions) error {
+	groups := make(map[string][]string)
+
+	var out []Resource
+	for _, res := range m.Resources {
+		if res.Type != moduleType {
+			out = append(out, res)
+			continue
+		}
+
+		source := manifest.ToString(res.Properties["source"])
+		if source == "" {
+			return fmt.Errorf("module %q: source cannot be empty", res.Id)
+		}
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(filepath.Dir(path), source)
+		}
+
+		params, _ := res.Properties["params"].(map[string]any)
+
+		child, err := load(ctx, source, params, opts)
+		if err != nil {
+			return fmt.Errorf("module %q: %w", res.Id, err)
+		}
+
+		internal := make(map[string]bool, len(child.Resources))
+		for _, c := range child.Resources {
+			internal[c.Id] = true
+		}
+
+		ids := make([]string, 0, len(child.Resources))
+		for _, c := range child.Resources {
+			c.Id = res.Id + "." + c.Id
+
+			deps := make([]string, 0, len(c.Dependencies)+len(res.Dependencies))
+			for _, dep := range c.Dependencies {
+				if internal[dep] {
+					dep = res.Id + "." + dep
+				}
+				deps = append(deps, dep)
+			}
+			c.Dependencies = append(deps, res.Dependencies...)
+			c.Before = slices.Clone(res.Before)
+
+			ids = append(ids, c.Id)
+			out = append(out, c)
+		}
+		groups[res.Id] = ids
+	}
+
+	// Rewrite references to modules to references to all of their resources
+	for i := range out {
+		out[i].Dependencies = ungroup(out[i].Dependencies, groups)
+		out[i].Before = ungroup(out[i].Before, groups)
+	}
+
+	m.Resources = out
+	return nil
+}
@@ -0,0 +1,26 @@
+package yaml
+
+import (
+	"context"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/manifest/remote"
+)
+
+// Render returns the manifest after templating, defaults, expansion of count and
+// for_each and module expansion.
+func (l *Loader) Render(ctx context.Context, cfg *config.Config, path string) ([]byte, error) {
+	m, err := load(ctx, path, cfg.Variables, loadOptions{
+		allowedEnv: cfg.AllowedEnv,
+		remote:     remote.NewResolver(),
+		secrets:    cfg.Secrets,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("manifest load error [%s]: %w", path, err)
+	}
+
+	return yaml.Marshal(m)
+}
@@ -0,0 +1,370 @@
+package yaml
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"peertech.de/axion/pkg/manifest"
+)
+
+// kind is the expected type of a manifest value
+type kind int
+
+const (
+	kindAny kind = iota
+	kindScalar
+	kindInt
+	kindBool
+	kindList
+	kindMap
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindScalar:
+		return "scalar"
+	case kindInt:
+		return "integer"
+	case kindBool:
+		return "boolean"
+	case kindList:
+		return "list"
+	case kindMap:
+		return "mapping"
+	default:
+		return "any"
+	}
+}
+
+// field describes a single key of a mapping in the manifest
+type field struct {
+	kind     kind
+	required bool
+}
+
+// manifestFields are the top level keys of a manifest
+var manifestFields = map[string]field{
+	"variables": {kind: kindMap},
+	"defaults":  {kind: kindMap},
+	"resources": {kind: kindList},
+}
+
+// resourceFields are the keys of a resource entry
+var resourceFields = map[string]field{
+	"id":           {kind: kindScalar, required: true},
+	"type":         {kind: kindScalar, required: true},
+	"state":        {kind: kindScalar},
+	"count":        {kind: kindInt},
+	"for_each":     {kind: kindAny},
+	"properties":   {kind: kindMap},
+	"dependencies": {kind: kindList},
+	"before":       {kind: kindList},
+	"options":      {kind: kindMap},
+}
+
+// optionFields are the keys of the execution options of a resource
+var optionFields = map[string]field{
+	"timeout":        {kind: kindScalar},
+	"retries":        {kind: kindInt},
+	"concurrent":     {kind: kindBool},
+	"backup":         {kind: kindBool},
+	"tags":           {kind: kindList},
+	"backup_include": {kind: kindList},
+	"backup_exclude": {kind: kindList},
+	"output":         {kind: kindScalar},
+}
+
+// resourceProperties are the properties supported by each resource type
+var resourceProperties = map[string]map[string]field{
+	"command": {
+		"command": {kind: kindScalar, required: true},
+	},
+	"script": {
+		"script":      {kind: kindScalar, required: true},
+		"interpreter": {kind: kindScalar},
+	},
+	"file": {
+		"path":  {kind: kindScalar, required: true},
+		"mode":  {kind: kindScalar},
+		"owner": {kind: kindScalar},
+		"group": {kind: kindScalar},
+	},
+	moduleType: {
+		"source": {kind: kindScalar, required: true},
+		"params": {kind: kindMap},
+	},
+	"directory": {
+		"path":  {kind: kindScalar, required: true},
+		"mode":  {kind: kindScalar},
+		"owner": {kind: kindScalar},
+		"group": {kind: kindScalar},
+	},
+	"symlink": {
+		"path":   {kind: kindScalar, required: true},
+		"target": {kind: kindScalar},
+		"force":  {kind: kindBool},
+	},
+	"package": {
+		"name":    {kind: kindScalar, required: true},
+		"version": {kind: kindScalar},
+		"pinned":  {kind: kindBool},
+	},
+	"service": {
+		"name":    {kind: kindScalar, required: true},
+		"running": {kind: kindBool},
+		"enabled": {kind: kindBool},
+	},
+}
+
+// validator collects schema issues of a single manifest document
+type validator struct {
+	file   string
+	issues []manifest.SchemaIssue
+}
+
+// validate checks the parsed manifest document against the manifest schema. Unknown
+// fields, values of the wrong type and missing required fields are reported with their
+// position in file.
+func validate(doc *yaml.Node, file string) error {
+	v := &validator{file: file}
+	v.manifest(doc)
+
+	if len(v.issues) == 0 {
+		return nil
+	}
+	return &manifest.SchemaError{Issues: v.issues}
+}
+
+func (v *validator) report(node *yaml.Node, format string, args ...any) {
+	v.issues = append(v.issues, manifest.SchemaIssue{
+		Position: manifest.Position{File: v.file, Line: node.Line, Column: node.Column},
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) manifest(doc *yaml.Node) {
+	if doc.Kind == yaml.DocumentNode {
+		if len(doc.Content) == 0 {
+			return
+		}
+		doc = doc.Content[0]
+	}
+
+	fields := v.mapping(doc, "manifest", manifestFields)
+
+	// Defaults must refer to known resource types and their properties
+	var defaults map[string]*yaml.Node
+	if node, ok := fields["defaults"]; ok {
+		defaults = v.defaults(node)
+	}
+
+	if node, ok := fields["resources"]; ok {
+		for _, res := range node.Content {
+			v.resource(res, defaults)
+		}
+	}
+}
+
+func (v *validator) defaults(node *yaml.Node) map[string]*yaml.Node {
+	defaults := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		props, ok := resourceProperties[key.Value]
+		if !ok {
+			v.report(key, "defaults for unsupported resource type %q", key.Value)
+			continue
+		}
+		if !v.kind(value, "defaults."+key.Value, kindMap) {
+			continue
+		}
+		v.properties(value, key.Value, props, nil, false)
+		defaults[key.Value] = value
+	}
+	return defaults
+}
+
+func (v *validator) resource(node *yaml.Node, defaults map[string]*yaml.Node) {
+	fields := v.mapping(node, "resource", resourceFields)
+	if fields == nil {
+		return
+	}
+
+	typ, ok := fields["type"]
+	if !ok {
+		return
+	}
+	props, known := resourceProperties[typ.Value]
+	if !known {
+		v.report(typ, "unsupported resource type %q (expected one of %s)",
+			typ.Value, strings.Join(resourceTypes(), ", "))
+		return
+	}
+
+	if _, hasCount := fields["count"]; hasCount {
+		if forEach, hasForEach := fields["for_each"]; hasForEach {
+			v.report(forEach, "count and for_each are mutually exclusive")
+		}
+	}
+	if forEach, ok := fields["for_each"]; ok && forEach.Kind != yaml.SequenceNode && forEach.Kind != yaml.MappingNode {
+		v.report(forEach, "for_each must be a list or mapping, got %s", nodeKind(forEach))
+	}
+	if options, ok := fields["options"]; ok {
+		v.mapping(options, "options", optionFields)
+	}
+	if deps, ok := fields["dependencies"]; ok {
+		for _, dep := range deps.Content {
+			v.kind(dep, "dependency", kindScalar)
+		}
+	}
+	if before, ok := fields["before"]; ok {
+		for _, ref := range before.Content {
+			v.kind(ref, "before reference", kindScalar)
+		}
+	}
+
+	properties, ok := fields["properties"]
+	if !ok {
+		// Report missing required properties at the resource itself
+		properties = &yaml.Node{Kind: yaml.MappingNode, Line: node.Line, Column: node.Column}
+	}
+	v.properties(properties, typ.Value, props, defaults[typ.Value], true)
+}
+
+// properties validates the properties of a resource of type typ. If checkRequired is set,
+// missing required properties are reported unless provided by the defaults for that type.
+func (v *validator) properties(
+	node *yaml.Node,
+	typ string,
+	props map[string]field,
+	defaults *yaml.Node,
+	checkRequired bool,
+) {
+	present := make(map[string]bool)
+	if defaults != nil {
+		for i := 0; i < len(defaults.Content); i += 2 {
+			present[defaults.Content[i].Value] = true
+		}
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		present[key.Value] = true
+
+		f, ok := props[key.Value]
+		if !ok {
+			v.report(key, "unknown property %q for %s resource", key.Value, typ)
+			continue
+		}
+		v.kind(value, key.Value, f.kind)
+	}
+
+	if !checkRequired {
+		return
+	}
+	for _, name := range sortedKeys(props) {
+		if props[name].required && !present[name] {
+			v.report(node, "missing required property %q for %s resource", name, typ)
+		}
+	}
+}
+
+// mapping validates that node is a mapping containing only known fields and all required
+// ones. It returns the value nodes by key, or nil if node is not a mapping.
+func (v *validator) mapping(node *yaml.Node, what string, fields map[string]field) map[string]*yaml.Node {
+	if !v.kind(node, what, kindMap) {
+		return nil
+	}
+
+	values := make(map[string]*yaml.Node, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		if _, dup := values[key.Value]; dup {
+			v.report(key, "duplicate field %q in %s", key.Value, what)
+			continue
+		}
+
+		f, ok := fields[key.Value]
+		if !ok {
+			v.report(key, "unknown field %q in %s", key.Value, what)
+			continue
+		}
+		if v.kind(value, key.Value, f.kind) {
+			values[key.Value] = value
+		}
+	}
+
+	for _, name := range sortedKeys(fields) {
+		if _, ok := values[name]; !ok && fields[name].required {
+			v.report(node, "missing required field %q in %s", name, what)
+		}
+	}
+
+	return values
+}
+
+// kind reports an issue if node is not of the expected kind
+func (v *validator) kind(node *yaml.Node, name string, expected kind) bool {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	ok := true
+	switch expected {
+	case kindScalar:
+		ok = node.Kind == yaml.ScalarNode && node.Tag != "!!null"
+	case kindInt:
+		ok = node.Kind == yaml.ScalarNode && node.Tag == "!!int"
+	case kindBool:
+		ok = node.Kind == yaml.ScalarNode && node.Tag == "!!bool"
+	case kindList:
+		ok = node.Kind == yaml.SequenceNode
+	case kindMap:
+		ok = node.Kind == yaml.MappingNode
+	}
+
+	if !ok {
+		v.report(node, "%s must be of type %s, got %s", name, expected, nodeKind(node))
+	}
+	return ok
+}
+
+// nodeKind returns a human readable description of the node type
+func nodeKind(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return "list"
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.ScalarNode:
+		switch node.Tag {
+		case "!!str":
+			return "string"
+		case "!!int":
+			return "integer"
+		case "!!bool":
+			return "boolean"
+		default:
+			return strings.TrimPrefix(node.Tag, "!!")
+		}
+	default:
+		return "unknown"
+	}
+}
+
+func resourceTypes() []string {
+	return sortedKeys(resourceProperties)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
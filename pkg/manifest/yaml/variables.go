@@ -0,0 +1,174 @@
+package yaml
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/manifest"
+	"peertech.de/axion/pkg/manifest/remote"
+)
+
+// Variables returns the variables declared in the manifest or given as overrides, sorted
+// by name, followed by the environment variables the manifest reads. Resources of
+// modules are not inspected.
+func (l *Loader) Variables(ctx context.Context, cfg *config.Config, path string) ([]manifest.Variable, error) {
+	raw, err := manifest.Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest file error: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("parse manifest error: %w", err)
+	}
+	var preliminary struct {
+		Variables map[string]any `yaml:"variables"`
+	}
+	if err := doc.Decode(&preliminary); err != nil {
+		return nil, fmt.Errorf("parse variables error: %w", err)
+	}
+
+	declared := mergeVariables(preliminary.Variables, cfg.Variables)
+	fetched, err := remote.NewResolver().ResolveVariables(ctx, declared)
+	if err != nil {
+		return nil, fmt.Errorf("remote variables error: %w", err)
+	}
+	resolved, err := cfg.Secrets.ResolveVariables(ctx, fetched)
+	if err != nil {
+		return nil, fmt.Errorf("resolve variables error: %w", err)
+	}
+
+	tmpl, err := template.New("manifest").
+		Funcs(templateFuncs(cfg.AllowedEnv)).
+		Parse(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("template parse error: %w", err)
+	}
+
+	spans := resourceSpans(&doc)
+	refs := make(map[reference][]string)
+	walkReferences(tmpl.Tree.Root, func(ref reference) {
+		id := spans.resource(strings.Count(string(raw[:ref.pos]), "\n") + 1)
+		ref.pos = 0
+		if _, ok := refs[ref]; !ok {
+			refs[ref] = nil
+		}
+		if id != "" && !slices.Contains(refs[ref], id) {
+			refs[ref] = append(refs[ref], id)
+		}
+	})
+
+	vars := make([]manifest.Variable, 0, len(declared))
+	for name, value := range declared {
+		v := manifest.Variable{
+			Name:       name,
+			Source:     manifest.SourceManifest,
+			Value:      resolved[name],
+			References: refs[reference{name: name}],
+		}
+		switch _, override := cfg.Variables[name]; {
+		case remote.IsRemote(value):
+			v.Source = manifest.SourceRemote
+		case !reflect.DeepEqual(fetched[name], resolved[name]):
+			v.Source = manifest.SourceSecret
+		case override:
+			v.Source = manifest.SourceOverride
+		}
+		vars = append(vars, v)
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+
+	var env []manifest.Variable
+	for ref, ids := range refs {
+		if !ref.env {
+			continue
+		}
+		value, err := manifest.Getenv(cfg.AllowedEnv, ref.name)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, manifest.Variable{
+			Name:       ref.name,
+			Source:     manifest.SourceEnv,
+			Value:      value,
+			References: ids,
+		})
+	}
+	sort.Slice(env, func(i, j int) bool { return env[i].Name < env[j].Name })
+	vars = append(vars, env...)
+
+	for i := range vars {
+		s := fmt.Sprint(vars[i].Value)
+		vars[i].Sensitive = cfg.Secrets.Redact(s) != s
+	}
+
+	return vars, nil
+}
+
+// span is the range of lines a resource is declared in
+type span struct {
+	id         string
+	start, end int
+}
+
+type spans []span
+
+// resourceSpans returns the line ranges of the top level resources. Ids containing
+// template actions are kept as they are written.
+func resourceSpans(doc *yaml.Node) spans {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "resources" || root.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+
+		// The resources end where the next top level key starts
+		end := math.MaxInt
+		if i+2 < len(root.Content) {
+			end = root.Content[i+2].Line - 1
+		}
+
+		items := root.Content[i+1].Content
+		var s spans
+		for j, item := range items {
+			sp := span{start: item.Line, end: end}
+			if j+1 < len(items) {
+				sp.end = items[j+1].Line - 1
+			}
+			for k := 0; k+1 < len(item.Content); k += 2 {
+				if item.Content[k].Value == "id" {
+					sp.id = item.Content[k+1].Value
+				}
+			}
+			s = append(s, sp)
+		}
+		return s
+	}
+	return nil
+}
+
+// resource returns the id of the resource declared at line, empty if there is none
+func (s spans) resource(line int) string {
+	for _, sp := range s {
+		if line >= sp.start && line <= sp.end {
+			return sp.id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+// Package metrics pushes the metrics of runs to a Prometheus Pushgateway or a StatsD
+// daemon, so that the runs across a fleet, e.g. of enforce, can be graphed and alerted
+// on.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"peertech.de/axion/pkg/report"
+)
+
+// DefaultJob is the job the metrics are pushed to the Pushgateway as
+const DefaultJob = "axionctl"
+
+// Operations of runs
+const (
+	OperationPlan  = "plan"
+	OperationApply = "apply"
+)
+
+// Run are the metrics of a run against an endpoint
+type Run struct {
+	// Instance identifies what the run was against, e.g. the endpoint or the host of
+	// the inventory
+	Instance  string
+	Operation string
+	Success   bool
+	// Changes is the number of resources which differed from the manifest, i.e. the
+	// drifted resources of enforced or checked systems
+	Changes  int
+	Counts   report.Counts
+	Duration time.Duration
+	Time     time.Time
+}
+
+// outcomes returns the counts of the resources by outcome
+func (r Run) outcomes() []outcome {
+	return []outcome{
+		{"applied", r.Counts.Applied},
+		{"changes", r.Counts.Changes},
+		{"unchanged", r.Counts.Unchanged},
+		{"failed", r.Counts.Failed},
+		{"skipped", r.Counts.Skipped},
+		{"rolled_back", r.Counts.RolledBack},
+		{"excluded", r.Counts.Excluded},
+	}
+}
+
+type outcome struct {
+	name  string
+	count int
+}
+
+// Pusher pushes the metrics of runs
+type Pusher interface {
+	Push(ctx context.Context, r Run) error
+}
+
+// New returns the Pusher for target: http and https URLs are Pushgateways, to which the
+// metrics are pushed as job, and statsd or udp URLs StatsD daemons, e.g.
+// statsd://localhost:8125/axion, with the path as prefix of the metric names.
+func New(target, job string) (Pusher, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics URL: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid metrics URL %q: no host", target)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return NewPushgateway(target, job), nil
+	case "statsd", "udp":
+		return NewStatsD(u.Host, u.Path), nil
+	default:
+		return nil, fmt.Errorf("invalid metrics URL %q: unsupported scheme %q, expected http, https, statsd or udp", target, u.Scheme)
+	}
+}
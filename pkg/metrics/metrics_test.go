@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"peertech.de/axion/pkg/report"
+)
+
+var testRun = Run{
+	Instance:  "https://web1:8080",
+	Operation: OperationApply,
+	Changes:   3,
+	Counts:    report.Counts{Applied: 2, Unchanged: 10, Failed: 1},
+	Duration:  1500 * time.Millisecond,
+	Time:      time.Unix(1700000000, 0),
+}
+
+func TestPushgateway(t *testing.T) {
+	var path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("expected PUT, got %s", r.Method)
+		}
+		data, _ := io.ReadAll(r.Body)
+		path, body = r.URL.Path, string(data)
+	}))
+	defer srv.Close()
+
+	p, err := New(srv.URL+"/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Push(context.Background(), testRun); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/metrics/job/axionctl/instance@base64/aHR0cHM6Ly93ZWIxOjgwODA/operation/apply" {
+		t.Errorf("unexpected path %q", path)
+	}
+	for _, want := range []string{
+		"axion_run_success 0\n",
+		"axion_run_duration_seconds 1.5\n",
+		"axion_run_timestamp_seconds 1700000000\n",
+		"axion_run_changes 3\n",
+		`axion_run_resources{outcome="applied"} 2` + "\n",
+		`axion_run_resources{outcome="failed"} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in pushed metrics:\n%s", want, body)
+		}
+	}
+}
+
+func TestStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	p, err := New("statsd://"+conn.LocalAddr().String()+"/fleet/prod", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Push(context.Background(), testRun); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := string(buf[:n])
+	for _, want := range []string{
+		"fleet.prod.web1_8080.apply.runs:1|c\n",
+		"fleet.prod.web1_8080.apply.failures:1|c\n",
+		"fleet.prod.web1_8080.apply.duration:1500|ms\n",
+		"fleet.prod.web1_8080.apply.resources.unchanged:10|g\n",
+	} {
+		if !strings.Contains(lines, want) {
+			t.Errorf("expected %q in sent metrics:\n%s", want, lines)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, target := range []string{"ftp://host", "localhost:9091", "http://"} {
+		if _, err := New(target, ""); err == nil {
+			t.Errorf("expected error for %q", target)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// pushTimeout bounds the delivery of the metrics of a run
+const pushTimeout = 10 * time.Second
+
+// Pushgateway pushes the metrics of runs to a Prometheus Pushgateway. The metrics of
+// each run replace the previous ones of the same job, instance and operation.
+type Pushgateway struct {
+	URL    string
+	Job    string
+	Client *http.Client
+}
+
+func NewPushgateway(url, job string) *Pushgateway {
+	if job == "" {
+		job = DefaultJob
+	}
+	return &Pushgateway{URL: strings.TrimSuffix(url, "/"), Job: job, Client: http.DefaultClient}
+}
+
+// Push implements Pusher
+func (p *Pushgateway) Push(ctx context.Context, r Run) error {
+	ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, p.groupURL(r), bytes.NewReader(Exposition(r)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pushgateway responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// groupURL returns the URL of the group of the metrics of the run
+func (p *Pushgateway) groupURL(r Run) string {
+	return fmt.Sprintf("%s/metrics/%s/%s/%s", p.URL,
+		grouping("job", p.Job), grouping("instance", r.Instance), grouping("operation", r.Operation))
+}
+
+// grouping returns the label and value of the grouping key for the path of the URL,
+// values which can't be part of a path, e.g. endpoint URLs, are base64 encoded
+func grouping(label, value string) string {
+	switch {
+	case value == "":
+		return label + "@base64/="
+	case strings.Contains(value, "/"):
+		return label + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return label + "/" + url.PathEscape(value)
+}
+
+// Exposition returns the metrics of the run in the Prometheus text exposition format
+func Exposition(r Run) []byte {
+	var b bytes.Buffer
+	gauge := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	gauge("axion_run_success", "Whether the last run succeeded.")
+	success := 0
+	if r.Success {
+		success = 1
+	}
+	fmt.Fprintf(&b, "axion_run_success %d\n", success)
+
+	gauge("axion_run_duration_seconds", "Duration of the last run.")
+	fmt.Fprintf(&b, "axion_run_duration_seconds %g\n", r.Duration.Seconds())
+
+	gauge("axion_run_timestamp_seconds", "Time the last run finished.")
+	fmt.Fprintf(&b, "axion_run_timestamp_seconds %d\n", r.Time.Unix())
+
+	gauge("axion_run_changes", "Resources which differed from the manifest in the last run.")
+	fmt.Fprintf(&b, "axion_run_changes %d\n", r.Changes)
+
+	gauge("axion_run_resources", "Resources of the last run by outcome.")
+	for _, o := range r.outcomes() {
+		fmt.Fprintf(&b, "axion_run_resources{outcome=%q} %d\n", o.name, o.count)
+	}
+	return b.Bytes()
+}
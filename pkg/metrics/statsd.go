@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// DefaultPrefix is the prefix of the metric names sent to StatsD
+const DefaultPrefix = "axion"
+
+// StatsD sends the metrics of runs to a StatsD daemon over UDP. The names of the metrics
+// are the prefix, the instance and the operation joined by dots, e.g.
+// axion.web1_8080.apply.duration.
+type StatsD struct {
+	Addr   string
+	Prefix string
+}
+
+func NewStatsD(addr, prefix string) *StatsD {
+	prefix = strings.Trim(prefix, "/.")
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	return &StatsD{Addr: addr, Prefix: strings.ReplaceAll(prefix, "/", ".")}
+}
+
+// Push implements Pusher, all metrics are sent in a single packet
+func (s *StatsD) Push(ctx context.Context, r Run) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(s.Lines(r)))
+	return err
+}
+
+// Lines returns the metrics of the run in the StatsD line protocol
+func (s *StatsD) Lines(r Run) string {
+	prefix := fmt.Sprintf("%s.%s.%s.", s.Prefix, metricName(r.Instance), metricName(r.Operation))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%sruns:1|c\n", prefix)
+	if !r.Success {
+		fmt.Fprintf(&b, "%sfailures:1|c\n", prefix)
+	}
+	fmt.Fprintf(&b, "%sduration:%d|ms\n", prefix, r.Duration.Milliseconds())
+	fmt.Fprintf(&b, "%schanges:%d|g\n", prefix, r.Changes)
+	for _, o := range r.outcomes() {
+		fmt.Fprintf(&b, "%sresources.%s:%d|g\n", prefix, o.name, o.count)
+	}
+	return b.String()
+}
+
+var invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// metricName turns s into a single component of a metric name, the scheme of URLs is
+// dropped
+func metricName(s string) string {
+	if _, rest, ok := strings.Cut(s, "://"); ok {
+		s = rest
+	}
+	s = strings.Trim(invalidNameChars.ReplaceAllString(s, "_"), "_")
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,52 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+
+	"peertech.de/axion/pkg/report"
+	"peertech.de/axion/pkg/resource"
+)
+
+// Applied is the state of a resource recorded after it was last applied
+type Applied struct {
+	// ETag identifies the state of the resource on the agent after the apply, empty if
+	// it isn't versioned or didn't exist
+	ETag string
+	// Digest identifies the specification the resource was applied with, see
+	// ResourceSpec.Digest
+	Digest string
+}
+
+// revalidate reports whether the resource of rs is still in the state it was last
+// applied in with the same specification, so it is in the desired state without being
+// checked. Only resources implementing resource.Revalidator are revalidated, and none
+// while destroying, as their desired state differs from the specification.
+func (o *Orchestrator) revalidate(ctx context.Context, attempt *Attempt, rs ResourceSpec) bool {
+	applied, ok := o.options.Applied[attempt.Id]
+	if !ok || o.options.Destroy || applied.ETag == "" || applied.Digest == "" || applied.Digest != rs.Digest {
+		return false
+	}
+	r, ok := rs.Resource.(resource.Revalidator)
+	if !ok || !r.Unchanged(ctx, applied.ETag) {
+		return false
+	}
+	attempt.Revalidated = true
+	return true
+}
+
+// checkApplied marks the attempt as changed outside of axion if the state of its
+// versioned resource differs from the one recorded after it was last applied
+func (o *Orchestrator) checkApplied(attempt *Attempt, rs ResourceSpec) {
+	applied, ok := o.options.Applied[attempt.Id]
+	if !ok || attempt.Revalidated {
+		return
+	}
+	if _, versioned := rs.Resource.(resource.Versioned); !versioned || attempt.ETag == applied.ETag {
+		return
+	}
+
+	attempt.ChangedOutside = true
+	o.emit(report.Event{Kind: report.KindWarn, Id: attempt.Id, Name: attempt.Name,
+		Message: fmt.Sprintf("%s changed outside of axion since it was last applied", attempt.Name)})
+}
@@ -0,0 +1,51 @@
+package orchestrator
+
+// Drift classifies the state of a resource against the state recorded after it was
+// last applied, see WithApplied
+type Drift string
+
+const (
+	// DriftInSync is the drift of resources still in the state they were last applied
+	// in with the same specification
+	DriftInSync Drift = "in-sync"
+	// DriftDrifted is the drift of resources whose state differs from the one they
+	// were last applied in, e.g. after changes by hand
+	DriftDrifted Drift = "drifted"
+	// DriftChangedInManifest is the drift of resources whose specification changed
+	// since they were last applied, their changes are pending rather than drift
+	DriftChangedInManifest Drift = "changed-in-manifest"
+)
+
+// DriftCounts are the resources of a run by drift
+type DriftCounts struct {
+	InSync            int
+	Drifted           int
+	ChangedInManifest int
+}
+
+// Total returns the number of classified resources
+func (c DriftCounts) Total() int {
+	return c.InSync + c.Drifted + c.ChangedInManifest
+}
+
+// classify sets the drift of the evaluated attempt. Resources which weren't applied
+// before, or are destroyed, aren't classified. Resources changed outside of axion
+// drifted even if their specification changed as well. Without digest, as recorded by
+// earlier versions, needed changes are taken as drift.
+func (o *Orchestrator) classify(attempt *Attempt) {
+	applied, ok := o.options.Applied[attempt.Id]
+	if !ok || o.options.Destroy {
+		return
+	}
+
+	switch {
+	case attempt.ChangedOutside:
+		attempt.Drift = DriftDrifted
+	case applied.Digest != "" && attempt.Digest != "" && applied.Digest != attempt.Digest:
+		attempt.Drift = DriftChangedInManifest
+	case attempt.NeedsApply:
+		attempt.Drift = DriftDrifted
+	default:
+		attempt.Drift = DriftInSync
+	}
+}
@@ -0,0 +1,147 @@
+package orchestrator
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// JUnitSuites is the root element of a JUnit XML report, as understood by the test
+// panels of CI systems
+type JUnitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Suites   []JUnitSuite `xml:"testsuite"`
+}
+
+// JUnitSuite holds the test cases of the resources of a run
+type JUnitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Cases    []JUnitCase `xml:"testcase"`
+}
+
+// JUnitCase is the test case of a single resource
+type JUnitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *JUnitMessage `xml:"failure,omitempty"`
+	Error     *JUnitMessage `xml:"error,omitempty"`
+	Skipped   *JUnitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// JUnitMessage is the failure, error or reason for skipping of a test case
+type JUnitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// NewJUnitSuite returns the test suite name of the run of summary with a test case per
+// resource. Resources which failed to evaluate, back up or apply are failures, skipped
+// and excluded resources are skipped and the diffs are kept as output. An error
+// aborting the run is added as an erroneous test case. redact is applied to names,
+// diffs and errors.
+func NewJUnitSuite(name string, summary *Summary, redact func(string) string) JUnitSuite {
+	suite := JUnitSuite{Name: name}
+
+	for _, id := range summary.Order {
+		a := summary.Attempts[id]
+		c := JUnitCase{
+			Name:      a.Id,
+			ClassName: name,
+			SystemOut: redact(a.Changes),
+		}
+		if a.Name != "" && a.Name != a.Id {
+			c.Name = fmt.Sprintf("%s (%s)", redact(a.Name), a.Id)
+		}
+
+		var kind string
+		var err error
+		switch {
+		case a.EvaluationError != nil:
+			kind, err = "evaluation", a.EvaluationError
+		case a.BackupError != nil:
+			kind, err = "backup", a.BackupError
+		case a.ApplyError != nil:
+			kind, err = "apply", a.ApplyError
+		}
+
+		switch {
+		case err != nil:
+			text := redact(err.Error())
+			if a.RollbackError != nil {
+				text += "\nrollback failed: " + redact(a.RollbackError.Error())
+			} else if a.RolledBack {
+				text += "\nrolled back"
+			}
+			c.Failure = &JUnitMessage{
+				Message: firstLine(text),
+				Type:    kind,
+				Text:    text,
+			}
+			suite.Failures++
+		case a.Excluded:
+			c.Skipped = &JUnitMessage{Message: "excluded"}
+			suite.Skipped++
+		case a.Skipped:
+			c.Skipped = &JUnitMessage{Message: "skipped due to failure"}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	if summary.Error != nil {
+		suite.AddError("run", redact(summary.Error.Error()))
+	}
+
+	suite.Tests = len(suite.Cases)
+	return suite
+}
+
+// AddError adds an erroneous test case, e.g. for a run that could not be started
+func (s *JUnitSuite) AddError(name, text string) {
+	s.Cases = append(s.Cases, JUnitCase{
+		Name:      name,
+		ClassName: s.Name,
+		Error:     &JUnitMessage{Message: firstLine(text), Text: text},
+	})
+	s.Tests++
+	s.Errors++
+}
+
+// WriteJUnit writes the suites to w as JUnit XML report
+func WriteJUnit(w io.Writer, suites ...JUnitSuite) error {
+	report := JUnitSuites{Suites: suites}
+	for _, s := range suites {
+		report.Tests += s.Tests
+		report.Failures += s.Failures
+		report.Errors += s.Errors
+		report.Skipped += s.Skipped
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// firstLine returns the first line of s
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
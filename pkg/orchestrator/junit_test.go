@@ -0,0 +1,55 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestJUnit(t *testing.T) {
+	redact := func(s string) string { return strings.ReplaceAll(s, "secret", "***") }
+	summary := planSummary(
+		&Attempt{Id: "conf", Name: "file:/etc/app.conf", NeedsApply: true, Changes: "+ password=secret", Applied: true},
+		&Attempt{Id: "svc", Name: "service:app", ApplyError: errors.New("exit status 1\nsecret output"), RolledBack: true},
+		&Attempt{Id: "after", Name: "command:login --token secret", Skipped: true},
+		&Attempt{Id: "other", Name: "file:/tmp/other", Excluded: true},
+	)
+	summary.Success = false
+
+	var buf bytes.Buffer
+	if err := WriteJUnit(&buf, NewJUnitSuite("manifest.yaml", summary, redact)); err != nil {
+		t.Fatal(err)
+	}
+
+	var report JUnitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report: %s\n%s", err, buf.String())
+	}
+	if report.Tests != 4 || report.Failures != 1 || report.Skipped != 2 || report.Errors != 0 {
+		t.Errorf("unexpected counts %+v", report)
+	}
+
+	cases := report.Suites[0].Cases
+	if cases[0].Name != "file:/etc/app.conf (conf)" || cases[0].SystemOut != "+ password=***" {
+		t.Errorf("unexpected case %+v", cases[0])
+	}
+	failure := cases[1].Failure
+	if failure == nil || failure.Type != "apply" || failure.Message != "exit status 1" ||
+		failure.Text != "exit status 1\n*** output\nrolled back" {
+		t.Errorf("unexpected failure %+v", failure)
+	}
+	if cases[2].Name != "command:login --token *** (after)" {
+		t.Errorf("expected redacted name, got %q", cases[2].Name)
+	}
+	if cases[2].Skipped == nil || cases[3].Skipped == nil {
+		t.Errorf("expected skipped and excluded resources to be skipped, got %+v", cases[2:])
+	}
+
+	suite := NewJUnitSuite("host", newSummary(), redact)
+	suite.AddError("connect", "connection refused")
+	if suite.Tests != 1 || suite.Errors != 1 || suite.Cases[0].Error.Message != "connection refused" {
+		t.Errorf("unexpected suite %+v", suite)
+	}
+}
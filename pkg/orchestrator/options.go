@@ -5,13 +5,48 @@ import "peertech.de/axion/pkg/report"
 type Option = func(*Options)
 
 type Options struct {
-	Reporter      report.Reporter
+	Reporter      report.EventReporter
 	DryRun        bool
 	BackupEnabled bool
 	Concurrency   int
+
+	// DiscardBackups removes the backups taken by a run once all its changes were
+	// applied, from resources implementing resource.BackupDiscarder
+	DiscardBackups bool
+
+	// InferDependencies makes resources managing a path depend on the resource managing
+	// the closest parent directory
+	InferDependencies bool
+
+	// ManifestDigest identifies the revision of the manifest the resources were loaded
+	// from, it is passed on to the Summary
+	ManifestDigest string
+
+	// Targets limits a run to the resources with these ids and their dependencies
+	Targets []string
+
+	// Skip excludes the resources with these ids and their dependents from a run
+	Skip []string
+
+	// Destroy removes the resources instead of applying them: removable resources are
+	// set absent and processed after the resources depending on them, others are
+	// excluded
+	Destroy bool
+
+	// Applied is the state of the resources recorded after they were last applied,
+	// keyed by id, see WithApplied
+	Applied map[string]Applied
 }
 
+// WithReporter reports the events of the runs to r, see WithEventReporter
 func WithReporter(r report.Reporter) Option {
+	return func(o *Options) {
+		o.Reporter = report.NewAdapter(r)
+	}
+}
+
+// WithEventReporter reports the events of the runs to r
+func WithEventReporter(r report.EventReporter) Option {
 	return func(o *Options) {
 		o.Reporter = r
 	}
@@ -29,8 +64,53 @@ func WithEnableBackups() Option {
 	}
 }
 
+func WithDiscardBackups() Option {
+	return func(o *Options) {
+		o.DiscardBackups = true
+	}
+}
+
 func WithConcurrency(n int) Option {
 	return func(o *Options) {
 		o.Concurrency = n
 	}
 }
+
+func WithInferDependencies() Option {
+	return func(o *Options) {
+		o.InferDependencies = true
+	}
+}
+
+func WithManifestDigest(digest string) Option {
+	return func(o *Options) {
+		o.ManifestDigest = digest
+	}
+}
+
+func WithTargets(ids ...string) Option {
+	return func(o *Options) {
+		o.Targets = append(o.Targets, ids...)
+	}
+}
+
+func WithSkip(ids ...string) Option {
+	return func(o *Options) {
+		o.Skip = append(o.Skip, ids...)
+	}
+}
+
+func WithDestroy() Option {
+	return func(o *Options) {
+		o.Destroy = true
+	}
+}
+
+// WithApplied passes the state of the resources recorded after they were last applied:
+// resources whose state changed since are reported as changed outside of axion, and
+// resources still in that state with the same specification aren't checked again
+func WithApplied(applied map[string]Applied) Option {
+	return func(o *Options) {
+		o.Applied = applied
+	}
+}
@@ -3,11 +3,17 @@ package orchestrator
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+	"slices"
 	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 
 	"peertech.de/axion/pkg/graph"
 	"peertech.de/axion/pkg/report"
 	"peertech.de/axion/pkg/resource"
+	"peertech.de/axion/pkg/tracing"
 )
 
 // ResourceSpec defines a resource along with its unique identifier and dependencies
@@ -15,6 +21,26 @@ type ResourceSpec struct {
 	Id           string
 	Resource     resource.Resource
 	Dependencies []string
+	Options      SpecOptions
+	// Digest identifies the specification of the resource in the manifest, see
+	// manifest.ResourceDigest. Resources without digest are always checked.
+	Digest string
+}
+
+// SpecOptions tune the execution of a single resource
+type SpecOptions struct {
+	// Timeout bounds the evaluation, backup and apply of the resource (0: no timeout)
+	Timeout time.Duration
+
+	// Retries is the number of times a failed check or apply is retried
+	Retries int
+
+	// Backup overrides whether the resource is backed up before applying changes. If
+	// nil, the orchestrator setting applies.
+	Backup *bool
+
+	// Tags are arbitrary labels used to select resources
+	Tags []string
 }
 
 // Attempt stores the outcome of an attempt to process a single resource.
@@ -34,12 +60,34 @@ type Attempt struct {
 	RolledBack        bool
 	RollbackError     error
 	Skipped           bool
+	Excluded          bool // Out of scope of the targets or skipped, not evaluated
+
+	// Operation is the operation performed by the apply, for resources reporting it
+	Operation resource.Operation
+	// ETag identifies the state of the resource on the agent after the evaluation or
+	// apply, for resources versioned by the agent
+	ETag string
+	// Duration is the time spent evaluating, backing up, applying and rolling back the
+	// resource
+	Duration time.Duration
+
+	// Digest identifies the specification of the resource, see ResourceSpec.Digest
+	Digest string
+	// Revalidated is set if the resource wasn't checked, as it is still in the state it
+	// was last applied in with the same specification, see WithApplied
+	Revalidated bool
+	// ChangedOutside is set if the state of the resource changed since it was last
+	// applied, e.g. by hand, see WithApplied
+	ChangedOutside bool
+	// Drift classifies the resource against the state it was last applied in, empty if
+	// it wasn't applied before
+	Drift Drift
 }
 
 func NewOrchestrator(options ...Option) *Orchestrator {
 	// Default options
 	opts := Options{
-		Reporter:    report.EmojiReporter{},
+		Reporter:    report.NewAdapter(report.EmojiReporter{}),
 		Concurrency: 1,
 	}
 
@@ -63,7 +111,11 @@ type Orchestrator struct {
 	mu    sync.RWMutex            // protects the specs
 	specs map[string]ResourceSpec // specs tracked by resource id
 
-	g *graph.Graph
+	g           *graph.Graph
+	initialized bool // whether the dependency edges have been wired
+
+	// dependencies are the resolved dependencies of each resource, set on initialization
+	dependencies map[string]map[string]bool
 }
 
 // Add registers a new resource with the orchestrator. The resources must have a unique
@@ -73,6 +125,7 @@ type Orchestrator struct {
 //   - A resource with the same ID already exists
 //   - The resource fails validation (if it implements Validatable)
 //   - The resource ID is empty
+//   - The dependency graph has already been initialized (by Validate or Run)
 func (o *Orchestrator) Add(rs ResourceSpec) error {
 	if rs.Id == "" {
 		return fmt.Errorf("resource ID cannot be empty")
@@ -80,6 +133,9 @@ func (o *Orchestrator) Add(rs ResourceSpec) error {
 
 	o.mu.Lock()
 	defer o.mu.Unlock()
+	if o.initialized {
+		return fmt.Errorf("cannot add resource %q after initialization", rs.Id)
+	}
 	if _, exists := o.specs[rs.Id]; exists {
 		return fmt.Errorf("duplicate resource spec id: %q", rs.Id)
 	}
@@ -100,25 +156,133 @@ func (o *Orchestrator) Add(rs ResourceSpec) error {
 	return nil
 }
 
-// initialize builds the dependency graph.
-// Returns an error if any dependency references a unknown resource.
+// initialize builds the dependency graph. It is safe to call multiple times, the edges
+// are only wired once.
+//
+// Dependencies are resolved by resource id or, if no resource with that id exists, by
+// resource name (e.g. "file:/etc/app.conf"). If enabled, dependencies on parent
+// directories are inferred.
+//
+// Returns an error if any dependency references a unknown resource or a name managed by
+// more than one resource.
 func (o *Orchestrator) initialize() error {
-	o.mu.RLock()
-	defer o.mu.RUnlock()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.initialized {
+		return nil
+	}
+
+	// Index resources by name, an empty id marks an ambiguous name
+	names := make(map[string]string, len(o.specs))
+	for id, rs := range o.specs {
+		name := rs.Resource.Name()
+		if _, exists := names[name]; exists {
+			names[name] = ""
+		} else {
+			names[name] = id
+		}
+	}
+
+	resolve := func(dep string) (string, error) {
+		if _, exists := o.specs[dep]; exists {
+			return dep, nil
+		}
+		id, exists := names[dep]
+		if !exists {
+			return "", fmt.Errorf("unknown resource %q", dep)
+		}
+		if id == "" {
+			return "", fmt.Errorf("resource name %q is managed by multiple resources", dep)
+		}
+		return id, nil
+	}
 
+	deps := make(map[string]map[string]bool, len(o.specs))
 	for _, rs := range o.specs {
 		id := rs.Id
-		for _, dep := range rs.Dependencies {
-			// Validate dependency exists before creating edge
-			if _, exists := o.specs[dep]; !exists {
-				return fmt.Errorf("resource %q depends on unknown resource %q", id, dep)
+		deps[id] = make(map[string]bool)
+		for _, ref := range rs.Dependencies {
+			dep, err := resolve(ref)
+			if err != nil {
+				return fmt.Errorf("resource %q depends on %w", id, err)
+			}
+			deps[id][dep] = true
+		}
+	}
+
+	if o.options.InferDependencies {
+		o.inferDependencies(deps)
+	}
+
+	for id, set := range deps {
+		for dep := range set {
+			// Resources are destroyed before the resources they depend on
+			from, to := dep, id
+			if o.options.Destroy {
+				from, to = id, dep
 			}
-			err := o.g.AddEdgeByName(dep, id)
+			err := o.g.AddEdgeByName(from, to)
 			if err != nil {
 				return fmt.Errorf("failed wiring dependency from %q to %q: %w", dep, id, err)
 			}
 		}
 	}
+	o.dependencies = deps
+
+	if o.options.Destroy {
+		for _, rs := range o.specs {
+			if r, ok := rs.Resource.(resource.Removable); ok {
+				r.SetAbsent()
+			}
+		}
+	}
+
+	o.initialized = true
+	return nil
+}
+
+// inferDependencies adds a dependency of every resource managing a path on the resource
+// managing the closest parent directory. Dependencies that would contradict an existing
+// one in the opposite direction are not added.
+func (o *Orchestrator) inferDependencies(deps map[string]map[string]bool) {
+	dirs := make(map[string]string)
+	for id, rs := range o.specs {
+		if pm, ok := rs.Resource.(resource.PathManager); ok && pm.IsDir() {
+			dirs[filepath.Clean(pm.Path())] = id
+		}
+	}
+
+	for id, rs := range o.specs {
+		pm, ok := rs.Resource.(resource.PathManager)
+		if !ok {
+			continue
+		}
+
+		path := filepath.Clean(pm.Path())
+		for parent := filepath.Dir(path); parent != path; path, parent = parent, filepath.Dir(parent) {
+			dir, ok := dirs[parent]
+			if !ok {
+				continue
+			}
+			if dir != id && !deps[dir][id] {
+				deps[id][dir] = true
+			}
+			break
+		}
+	}
+}
+
+// Validate checks that all dependencies reference registered resources and that the
+// dependency graph is free of cycles. No resource is evaluated.
+func (o *Orchestrator) Validate() error {
+	if err := o.initialize(); err != nil {
+		return err
+	}
+
+	if _, err := o.g.Sort(); err != nil {
+		return fmt.Errorf("dependency resolution failed: %w", err)
+	}
 
 	return nil
 }
@@ -151,8 +315,22 @@ func (o *Orchestrator) initialize() error {
 // TODO: Implement alternatives
 //   - Remove Reporter dependency, return Summary only (caller handles reporting)
 //   - Add Observer pattern for live updates, keep Summary for final state
-func (o *Orchestrator) Run(ctx context.Context, planOnly bool) *Summary {
-	summary := newSummary()
+func (o *Orchestrator) Run(ctx context.Context, planOnly bool) (summary *Summary) {
+	ctx, span := tracing.Start(ctx, "run", attribute.Bool("axion.plan", planOnly))
+	defer func() { tracing.End(span, summary.Error) }()
+	defer report.Finish(o.options.Reporter)
+
+	start := time.Now()
+	defer func() {
+		summary.Duration = time.Since(start)
+		// Runs failing before processing any resource have nothing to summarize
+		if summary.Error == nil || len(summary.Attempts) > 0 {
+			o.emit(report.Event{Kind: report.KindFinished, Counts: summary.Counts(), Duration: summary.Duration})
+		}
+	}()
+
+	summary = newSummary()
+	summary.ManifestDigest = o.options.ManifestDigest
 
 	if err := o.initialize(); err != nil {
 		summary.Error = fmt.Errorf("failed to initialize: %w", err)
@@ -168,6 +346,23 @@ func (o *Orchestrator) Run(ctx context.Context, planOnly bool) *Summary {
 	}
 	summary.TotalCount = len(nodes)
 
+	scope, err := o.scope()
+	if err != nil {
+		summary.Error = err
+		summary.Success = false
+		return summary
+	}
+
+	// Fetch the state of resources supporting it in batches, it is valid until the
+	// first change is applied
+	resources := make([]resource.Resource, 0, len(nodes))
+	for _, node := range nodes {
+		if scope == nil || scope[node.Name] {
+			resources = append(resources, o.specs[node.Name].Resource)
+		}
+	}
+	resource.Prefetch(ctx, resources)
+
 	var failed bool
 	applied := make([]*Attempt, 0, len(nodes))
 
@@ -181,56 +376,211 @@ func (o *Orchestrator) Run(ctx context.Context, planOnly bool) *Summary {
 		}
 
 		rs := o.specs[node.Name]
-		res := rs.Resource
 
-		attempt := &Attempt{Id: node.Name, Name: res.Name()}
+		attempt := &Attempt{Id: node.Name, Name: rs.Resource.Name(), Digest: rs.Digest}
 		summary.Attempts[node.Name] = attempt
+		summary.Order = append(summary.Order, node.Name)
+
+		_, removable := rs.Resource.(resource.Removable)
+		if (scope != nil && !scope[node.Name]) || (o.options.Destroy && !removable) {
+			o.emit(report.Event{Kind: report.KindExcluded, Id: attempt.Id, Name: attempt.Name})
+			attempt.Excluded = true
+			summary.ExcludedCount++
+			continue
+		}
 
 		// Skip if previous resource failed
 		if failed {
-			o.options.Reporter.Skipped(attempt.Id, attempt.Name)
+			o.emit(report.Event{Kind: report.KindSkipped, Id: attempt.Id, Name: attempt.Name})
 			attempt.Skipped = true
 			summary.SkippedCount++
 			continue // Continue to mark remaining as skipped
 		}
 
-		err = o.evaluate(ctx, attempt, res)
-		if err != nil {
-			failed = true
-			continue // Continue to mark remaining as skipped
-		}
-
-		if planOnly || !attempt.NeedsApply {
-			continue
-		}
-
-		// TODO: Rollback if backup fails?
-		// Currently we error out, no rollback attempted here for backup failure.
-		err = o.backup(ctx, attempt, res)
+		began := time.Now()
+		ok, err := o.process(ctx, rs, attempt, planOnly)
+		attempt.Duration = time.Since(began)
 		if err != nil {
 			failed = true
 			continue // Continue to mark remaining as skipped
 		}
 
-		err = o.apply(ctx, attempt, res)
-		if err != nil {
-			failed = true
-			continue
+		if ok {
+			if len(applied) == 0 {
+				resource.DiscardPrefetched(resources)
+			}
+			applied = append(applied, attempt)
+			summary.AppliedCount++
 		}
-
-		applied = append(applied, attempt)
-		summary.AppliedCount++
 	}
 
 	if failed && !planOnly {
 		n := o.rollback(ctx, applied)
 		summary.RollbackCount = n
 	}
+	if !failed && !planOnly && o.options.DiscardBackups {
+		o.discardBackups(ctx, applied)
+	}
 
 	summary.Success = !failed
 	return summary
 }
 
+// Dependents returns the ids of the resources depending on the resource with id, in
+// lexical order. The dependencies are resolved by Run.
+func (o *Orchestrator) Dependents(id string) []string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	var dependents []string
+	for dependent, deps := range o.dependencies {
+		if deps[id] {
+			dependents = append(dependents, dependent)
+		}
+	}
+	slices.Sort(dependents)
+	return dependents
+}
+
+// scope returns the ids of the resources in scope of the targets and skipped resources,
+// nil if all resources are. Targets bring their dependencies into scope, skipped
+// resources take their dependents out of it.
+func (o *Orchestrator) scope() (map[string]bool, error) {
+	if len(o.options.Targets) == 0 && len(o.options.Skip) == 0 {
+		return nil, nil
+	}
+
+	for _, id := range slices.Concat(o.options.Targets, o.options.Skip) {
+		if _, ok := o.specs[id]; !ok {
+			return nil, fmt.Errorf("unknown resource %q", id)
+		}
+	}
+
+	in := make(map[string]bool, len(o.specs))
+	if len(o.options.Targets) == 0 {
+		for id := range o.specs {
+			in[id] = true
+		}
+	} else {
+		// The edges of the reversed graph point from a resource to its dependencies
+		dependencies := o.g.Reversed()
+		var include func(id string)
+		include = func(id string) {
+			if in[id] {
+				return
+			}
+			in[id] = true
+			node, _ := dependencies.GetNode(id)
+			for _, dep := range node.Edges() {
+				include(dep.Name)
+			}
+		}
+		for _, id := range o.options.Targets {
+			include(id)
+		}
+	}
+
+	excluded := make(map[string]bool)
+	var exclude func(id string)
+	exclude = func(id string) {
+		if excluded[id] {
+			return
+		}
+		excluded[id] = true
+		delete(in, id)
+		for _, dependent := range o.g.GetDependents(id) {
+			exclude(dependent.Name)
+		}
+	}
+	for _, id := range o.options.Skip {
+		exclude(id)
+	}
+
+	return in, nil
+}
+
+// process evaluates a single resource and, unless planOnly is set, backs it up and
+// applies the changes. The resource options (timeout, retries, backup) are taken into
+// account.
+//
+// Returns whether the resource was applied and any error which should stop the run.
+func (o *Orchestrator) process(ctx context.Context, rs ResourceSpec, attempt *Attempt, planOnly bool) (ok bool, err error) {
+	ctx, span := tracing.Start(ctx, "resource",
+		attribute.String("axion.resource.id", attempt.Id),
+		attribute.String("axion.resource.name", attempt.Name),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	if rs.Options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rs.Options.Timeout)
+		defer cancel()
+	}
+
+	if err := o.evaluate(ctx, attempt, rs); err != nil {
+		return false, err
+	}
+	track(attempt, rs.Resource)
+	o.checkApplied(attempt, rs)
+	o.classify(attempt)
+
+	if planOnly || !attempt.NeedsApply {
+		return false, nil
+	}
+
+	// TODO: Rollback if backup fails?
+	// Currently we error out, no rollback attempted here for backup failure.
+	if err := o.backup(ctx, attempt, rs); err != nil {
+		return false, err
+	}
+
+	if err := o.apply(ctx, attempt, rs); err != nil {
+		return false, err
+	}
+	track(attempt, rs.Resource)
+
+	return true, nil
+}
+
+// track records the operation and ETag of r in attempt if it reports them
+func track(attempt *Attempt, r resource.Resource) {
+	if t, ok := r.(resource.Tracked); ok {
+		attempt.Operation = t.LastOperation()
+	}
+	if v, ok := r.(resource.Versioned); ok && attempt.Operation != resource.OperationDelete {
+		attempt.ETag = v.ETag()
+	}
+}
+
+// emit reports the event, stamped with the current time
+func (o *Orchestrator) emit(e report.Event) {
+	e.Time = time.Now()
+	o.options.Reporter.Report(e)
+}
+
+// retry calls fn until it succeeds, the retries are exhausted or the context is done.
+// The delay between attempts doubles, starting at one second.
+func (o *Orchestrator) retry(ctx context.Context, attempt *Attempt, retries int, fn func() error) error {
+	delay := time.Second
+
+	err := fn()
+	for i := 1; err != nil && i <= retries; i++ {
+		o.emit(report.Event{Kind: report.KindWarn, Message: fmt.Sprintf("Retrying %s (%d/%d) after error: %s",
+			attempt.Name, i, retries, err)})
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+
+		err = fn()
+	}
+
+	return err
+}
+
 // evaluate determines the current state of a resource and generates a human-readable diff
 // of pending changes.
 //
@@ -242,12 +592,28 @@ func (o *Orchestrator) Run(ctx context.Context, planOnly bool) *Summary {
 //   - bool: true if the resource needs to be applied
 //   - string: human-readable description of changes (empty if no changes needed)
 //   - error: any error encountered during evaluation
-func (o *Orchestrator) evaluate(ctx context.Context, attempt *Attempt, r resource.Resource) error {
-	o.options.Reporter.Evaluate(attempt.Id, attempt.Name)
+func (o *Orchestrator) evaluate(ctx context.Context, attempt *Attempt, rs ResourceSpec) (err error) {
+	ctx, span := tracing.Start(ctx, "evaluate")
+	defer func() { tracing.End(span, err) }()
+
+	start := time.Now()
+	o.emit(report.Event{Kind: report.KindEvaluate, Id: attempt.Id, Name: attempt.Name, Phase: report.PhaseEvaluate})
+	r := rs.Resource
+
+	if o.revalidate(ctx, attempt, rs) {
+		o.emit(report.Event{Kind: report.KindNoChanges, Id: attempt.Id, Name: attempt.Name, Phase: report.PhaseEvaluate,
+			Duration: time.Since(start)})
+		return nil
+	}
 
-	needsApply, err := r.Check(ctx)
+	var needsApply bool
+	err = o.retry(ctx, attempt, rs.Options.Retries, func() (err error) {
+		needsApply, err = r.Check(ctx)
+		return err
+	})
 	if err != nil {
-		o.options.Reporter.Fail(attempt.Id, attempt.Name, err)
+		o.emit(report.Event{Kind: report.KindFail, Id: attempt.Id, Name: attempt.Name, Phase: report.PhaseEvaluate,
+			Err: err, Duration: time.Since(start)})
 		attempt.EvaluationError = err
 		return err
 	}
@@ -260,9 +626,11 @@ func (o *Orchestrator) evaluate(ctx context.Context, attempt *Attempt, r resourc
 		} else {
 			attempt.Changes = diff
 		}
-		o.options.Reporter.Diff(attempt.Id, attempt.Name, attempt.Changes)
+		o.emit(report.Event{Kind: report.KindDiff, Id: attempt.Id, Name: attempt.Name, Phase: report.PhaseEvaluate,
+			Diff: attempt.Changes, Duration: time.Since(start)})
 	} else {
-		o.options.Reporter.NoChanges(attempt.Id, attempt.Name)
+		o.emit(report.Event{Kind: report.KindNoChanges, Id: attempt.Id, Name: attempt.Name, Phase: report.PhaseEvaluate,
+			Duration: time.Since(start)})
 	}
 
 	return nil
@@ -277,19 +645,35 @@ func (o *Orchestrator) evaluate(ctx context.Context, attempt *Attempt, r resourc
 //
 // Returns any error encountered during the apply operation. A nil return indicates the
 // resource was successfully applied.
-func (o *Orchestrator) apply(ctx context.Context, attempt *Attempt, r resource.Resource) error {
-	o.options.Reporter.Apply(attempt.Id, attempt.Name)
+func (o *Orchestrator) apply(ctx context.Context, attempt *Attempt, rs ResourceSpec) (err error) {
+	ctx, span := tracing.Start(ctx, "apply")
+	defer func() { tracing.End(span, err) }()
+
+	start := time.Now()
+	o.emit(report.Event{Kind: report.KindApply, Id: attempt.Id, Name: attempt.Name, Phase: report.PhaseApply})
+
+	// Relay output produced while applying, e.g. by long-running commands
+	if s, ok := rs.Resource.(resource.OutputStreamer); ok {
+		s.SetOutput(func(stream, line string) {
+			o.emit(report.Event{Kind: report.KindOutput, Id: attempt.Id, Name: attempt.Name, Phase: report.PhaseApply,
+				Stream: stream, Line: line})
+		})
+	}
 
 	attempt.ApplyAttempted = true
-	err := r.Apply(ctx)
+	err = o.retry(ctx, attempt, rs.Options.Retries, func() error {
+		return rs.Resource.Apply(ctx)
+	})
 	if err != nil {
-		o.options.Reporter.Fail(attempt.Id, attempt.Name, err)
+		o.emit(report.Event{Kind: report.KindFail, Id: attempt.Id, Name: attempt.Name, Phase: report.PhaseApply,
+			Err: err, Duration: time.Since(start)})
 		attempt.ApplyError = err
 		return fmt.Errorf("apply failed: %w", err)
 	}
 
 	attempt.Applied = true
-	o.options.Reporter.Success(attempt.Id, attempt.Name)
+	o.emit(report.Event{Kind: report.KindSuccess, Id: attempt.Id, Name: attempt.Name, Phase: report.PhaseApply,
+		Duration: time.Since(start)})
 	return nil
 }
 
@@ -305,34 +689,63 @@ func (o *Orchestrator) apply(ctx context.Context, attempt *Attempt, r resource.R
 //   - error: any error encountered during backup
 //
 // A backup may not be created even without error if: - Backup is disabled in orchestrator
-// options - Resource doesn't implement Backupable interface - Resource determines no
-// backup is needed (returns false from Backup method)
-func (o *Orchestrator) backup(ctx context.Context, attempt *Attempt, r resource.Resource) error {
-	if !o.options.BackupEnabled {
+// options or the resource options - Resource doesn't implement Backupable interface -
+// Resource determines no backup is needed (returns false from Backup method)
+func (o *Orchestrator) backup(ctx context.Context, attempt *Attempt, rs ResourceSpec) (err error) {
+	enabled := o.options.BackupEnabled
+	if rs.Options.Backup != nil {
+		enabled = *rs.Options.Backup
+	}
+	if !enabled {
 		return nil
 	}
 
-	b, ok := r.(resource.Backupable)
+	b, ok := rs.Resource.(resource.Backupable)
 	if !ok {
 		return nil
 	}
 
+	ctx, span := tracing.Start(ctx, "backup")
+	defer func() { tracing.End(span, err) }()
+
 	attempt.BackupAttempted = true
+	start := time.Now()
 	backuped, err := b.Backup(ctx)
 	if err != nil {
-		o.options.Reporter.Fail(attempt.Id, attempt.Name, err)
+		o.emit(report.Event{Kind: report.KindFail, Id: attempt.Id, Name: attempt.Name, Phase: report.PhaseBackup,
+			Err: err, Duration: time.Since(start)})
 		attempt.BackupError = err
 		return fmt.Errorf("backup failed: %w", err)
 	}
 
 	if backuped {
-		o.options.Reporter.Backuped(attempt.Id, attempt.Name)
+		o.emit(report.Event{Kind: report.KindBackuped, Id: attempt.Id, Name: attempt.Name, Phase: report.PhaseBackup,
+			Duration: time.Since(start)})
 		attempt.BackedUp = true
 	}
 
 	return nil
 }
 
+// discardBackups removes the backups taken for the applied resources once the run
+// succeeded, see WithDiscardBackups. Backups which can't be removed are reported as
+// warnings, they don't fail the run and are left to the retention.
+func (o *Orchestrator) discardBackups(ctx context.Context, applied []*Attempt) {
+	for _, attempt := range applied {
+		if !attempt.BackedUp {
+			continue
+		}
+		d, ok := o.specs[attempt.Id].Resource.(resource.BackupDiscarder)
+		if !ok {
+			continue
+		}
+		if err := d.DiscardBackup(ctx); err != nil {
+			o.emit(report.Event{Kind: report.KindWarn,
+				Message: fmt.Sprintf("Failed to discard backup of %s: %s", attempt.Name, err)})
+		}
+	}
+}
+
 // rollback reverts all successfully applied resources to their previous state in reverse
 // dependency order.
 //
@@ -346,13 +759,17 @@ func (o *Orchestrator) backup(ctx context.Context, attempt *Attempt, r resource.
 //
 // Returns the number of resources that were successfully rolled back.
 func (o *Orchestrator) rollback(ctx context.Context, applied []*Attempt) int {
+	ctx, span := tracing.Start(ctx, "rollback")
+	defer span.End()
+
 	count := 0
 
-	o.options.Reporter.Info("Starting rollback...")
+	o.emit(report.Event{Kind: report.KindInfo, Message: "Starting rollback..."})
 	for i := len(applied) - 1; i >= 0; i-- {
 		select {
 		case <-ctx.Done():
-			o.options.Reporter.Warn(fmt.Sprintf("Rollback interrupted by context cancellation after %d steps", len(applied)-(i+1)))
+			o.emit(report.Event{Kind: report.KindWarn,
+				Message: fmt.Sprintf("Rollback interrupted by context cancellation after %d steps", len(applied)-(i+1))})
 			return count
 		default:
 		}
@@ -360,18 +777,26 @@ func (o *Orchestrator) rollback(ctx context.Context, applied []*Attempt) int {
 		attempt := applied[i]
 		r := o.specs[attempt.Id].Resource
 
-		o.options.Reporter.Rollback(attempt.Id, attempt.Name)
+		start := time.Now()
+		o.emit(report.Event{Kind: report.KindRollback, Id: attempt.Id, Name: attempt.Name, Phase: report.PhaseRollback})
 		attempt.RollbackAttempted = true
-		err := r.Rollback(ctx)
+		rctx, rspan := tracing.Start(ctx, "resource.rollback",
+			attribute.String("axion.resource.id", attempt.Id),
+			attribute.String("axion.resource.name", attempt.Name),
+		)
+		err := r.Rollback(rctx)
+		tracing.End(rspan, err)
 		if err != nil {
-			o.options.Reporter.Fail(attempt.Id, attempt.Name, fmt.Errorf("rollback failed: %w", err))
+			o.emit(report.Event{Kind: report.KindFail, Id: attempt.Id, Name: attempt.Name, Phase: report.PhaseRollback,
+				Err: fmt.Errorf("rollback failed: %w", err), Duration: time.Since(start)})
 			attempt.RollbackError = err
 		} else {
 			attempt.RolledBack = true
 			count++
 		}
+		attempt.Duration += time.Since(start)
 	}
 
-	o.options.Reporter.Info("Rollback finished.")
+	o.emit(report.Event{Kind: report.KindInfo, Message: "Rollback finished."})
 	return count
 }
@@ -0,0 +1,395 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"peertech.de/axion/pkg/report"
+)
+
+// fakeResource is a resource managing a path which never needs changes
+type fakeResource struct {
+	name  string
+	path  string
+	isDir bool
+}
+
+func (r *fakeResource) Name() string                             { return r.name }
+func (r *fakeResource) IsConcurrent() bool                       { return true }
+func (r *fakeResource) Check(ctx context.Context) (bool, error)  { return false, nil }
+func (r *fakeResource) Diff(ctx context.Context) (string, error) { return "", nil }
+func (r *fakeResource) Apply(ctx context.Context) error          { return nil }
+func (r *fakeResource) Rollback(ctx context.Context) error       { return nil }
+func (r *fakeResource) Path() string                             { return r.path }
+func (r *fakeResource) IsDir() bool                              { return r.isDir }
+
+func file(path string) *fakeResource {
+	return &fakeResource{name: "file:" + path, path: path}
+}
+
+func directory(path string) *fakeResource {
+	return &fakeResource{name: "directory:" + path, path: path, isDir: true}
+}
+
+func order(t *testing.T, o *Orchestrator) []string {
+	t.Helper()
+
+	if err := o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := o.g.Sort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, len(nodes))
+	for i, n := range nodes {
+		names[i] = n.Name
+	}
+	return names
+}
+
+func before(order []string, a, b string) bool {
+	return slices.Index(order, a) < slices.Index(order, b)
+}
+
+func TestDependenciesByName(t *testing.T) {
+	o := NewOrchestrator(WithReporter(report.NilReporter{}))
+	specs := []ResourceSpec{
+		{Id: "config", Resource: file("/etc/app/app.conf"), Dependencies: []string{"directory:/etc/app"}},
+		{Id: "dir", Resource: directory("/etc/app")},
+	}
+	for _, spec := range specs {
+		if err := o.Add(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := order(t, o); !before(got, "dir", "config") {
+		t.Errorf("expected dir before config, got %v", got)
+	}
+
+	o = NewOrchestrator(WithReporter(report.NilReporter{}))
+	specs = []ResourceSpec{
+		{Id: "a", Resource: file("/tmp/a")},
+		{Id: "b", Resource: file("/tmp/a")},
+		{Id: "c", Resource: file("/tmp/c"), Dependencies: []string{"file:/tmp/a"}},
+	}
+	for _, spec := range specs {
+		if err := o.Add(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.Validate(); err == nil {
+		t.Errorf("expected error for ambiguous resource name")
+	}
+}
+
+func TestInferDependencies(t *testing.T) {
+	o := NewOrchestrator(WithReporter(report.NilReporter{}), WithInferDependencies())
+	specs := []ResourceSpec{
+		{Id: "index", Resource: file("/srv/www/html/index.html")},
+		{Id: "html", Resource: directory("/srv/www/html")},
+		{Id: "srv", Resource: directory("/srv")},
+		{Id: "motd", Resource: file("/etc/motd")},
+	}
+	for _, spec := range specs {
+		if err := o.Add(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := order(t, o)
+	if !before(got, "html", "index") || !before(got, "srv", "html") {
+		t.Errorf("expected srv, html, index order, got %v", got)
+	}
+
+	// Validate is idempotent and does not wire the edges twice
+	if err := o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if deps := o.g.GetDependents("html"); len(deps) != 1 {
+		t.Errorf("expected a single dependent of html, got %d", len(deps))
+	}
+}
+
+func TestScope(t *testing.T) {
+	specs := []ResourceSpec{
+		{Id: "dir", Resource: directory("/etc/app")},
+		{Id: "config", Resource: file("/etc/app/app.conf"), Dependencies: []string{"dir"}},
+		{Id: "service", Resource: file("/etc/app/service"), Dependencies: []string{"config"}},
+		{Id: "motd", Resource: file("/etc/motd")},
+	}
+
+	tests := []struct {
+		name     string
+		options  []Option
+		expected []string
+	}{
+		{"targets with dependencies", []Option{WithTargets("config")}, []string{"config", "dir"}},
+		{"skip with dependents", []Option{WithSkip("config")}, []string{"dir", "motd"}},
+		{"targets and skip", []Option{WithTargets("service", "motd"), WithSkip("service")}, []string{"config", "dir", "motd"}},
+	}
+
+	for _, tt := range tests {
+		o := NewOrchestrator(append(tt.options, WithReporter(report.NilReporter{}))...)
+		for _, spec := range specs {
+			if err := o.Add(spec); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		summary := o.Run(context.Background(), true)
+		if summary.Error != nil {
+			t.Fatalf("%s: %v", tt.name, summary.Error)
+		}
+
+		var got []string
+		for id, a := range summary.Attempts {
+			if !a.Excluded {
+				got = append(got, id)
+			}
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.expected) {
+			t.Errorf("%s: expected %v in scope, got %v", tt.name, tt.expected, got)
+		}
+		if summary.ExcludedCount != len(specs)-len(tt.expected) {
+			t.Errorf("%s: expected %d excluded, got %d", tt.name, len(specs)-len(tt.expected), summary.ExcludedCount)
+		}
+	}
+}
+
+func TestScopeUnknownResource(t *testing.T) {
+	o := NewOrchestrator(WithReporter(report.NilReporter{}), WithTargets("missing"))
+	if err := o.Add(ResourceSpec{Id: "motd", Resource: file("/etc/motd")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if summary := o.Run(context.Background(), true); summary.Error == nil {
+		t.Error("expected an error for an unknown target")
+	}
+}
+
+// removableResource is a fakeResource which can be set absent
+type removableResource struct {
+	fakeResource
+	absent bool
+}
+
+func (r *removableResource) SetAbsent() { r.absent = true }
+
+func TestDestroy(t *testing.T) {
+	dir := &removableResource{fakeResource: *directory("/etc/app")}
+	config := &removableResource{fakeResource: *file("/etc/app/app.conf")}
+	o := NewOrchestrator(WithReporter(report.NilReporter{}), WithDestroy())
+	specs := []ResourceSpec{
+		{Id: "dir", Resource: dir},
+		{Id: "config", Resource: config, Dependencies: []string{"dir"}},
+		{Id: "reload", Resource: file("/etc/app/.reload"), Dependencies: []string{"config"}},
+	}
+	for _, spec := range specs {
+		if err := o.Add(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := order(t, o); !before(got, "config", "dir") {
+		t.Errorf("expected config to be destroyed before dir, got %v", got)
+	}
+	if !dir.absent || !config.absent {
+		t.Error("expected removable resources to be set absent")
+	}
+	if got := o.Dependents("dir"); !slices.Equal(got, []string{"config"}) {
+		t.Errorf("expected config to depend on dir, got %v", got)
+	}
+
+	summary := o.Run(context.Background(), true)
+	if !summary.Attempts["reload"].Excluded || summary.Attempts["config"].Excluded {
+		t.Errorf("expected only the resource which can't be removed to be excluded, got %+v", summary.Attempts)
+	}
+}
+
+// backedUpResource is a fakeResource which needs changes and is backed up before they
+// are applied
+type backedUpResource struct {
+	fakeResource
+	fail      bool
+	discarded bool
+}
+
+func (r *backedUpResource) Check(ctx context.Context) (bool, error)  { return true, nil }
+func (r *backedUpResource) Backup(ctx context.Context) (bool, error) { return true, nil }
+
+func (r *backedUpResource) Apply(ctx context.Context) error {
+	if r.fail {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func (r *backedUpResource) DiscardBackup(ctx context.Context) error {
+	r.discarded = true
+	return nil
+}
+
+func TestDiscardBackups(t *testing.T) {
+	run := func(fail bool, options ...Option) *backedUpResource {
+		a := &backedUpResource{fakeResource: *file("/etc/a")}
+		options = append(options, WithReporter(report.NilReporter{}), WithEnableBackups())
+		o := NewOrchestrator(options...)
+		o.Add(ResourceSpec{Id: "a", Resource: a})
+		o.Add(ResourceSpec{Id: "b", Resource: &backedUpResource{fakeResource: *file("/etc/b"), fail: fail},
+			Dependencies: []string{"a"}})
+		if summary := o.Run(context.Background(), false); summary.Success == fail {
+			t.Fatalf("unexpected outcome %v", summary.Error)
+		}
+		return a
+	}
+
+	if !run(false, WithDiscardBackups()).discarded {
+		t.Error("expected the backup to be discarded after a successful run")
+	}
+	if run(true, WithDiscardBackups()).discarded {
+		t.Error("expected the backup to be kept after a failed run")
+	}
+	if run(false).discarded {
+		t.Error("expected the backup to be kept without WithDiscardBackups")
+	}
+}
+
+// versionedResource is a fakeResource versioned by an ETag which can be revalidated
+type versionedResource struct {
+	fakeResource
+	etag       string
+	needsApply bool
+	checked    bool
+}
+
+func (r *versionedResource) ETag() string { return r.etag }
+
+func (r *versionedResource) Check(ctx context.Context) (bool, error) {
+	r.checked = true
+	return r.needsApply, nil
+}
+
+func (r *versionedResource) Unchanged(ctx context.Context, etag string) bool {
+	return etag == r.etag
+}
+
+func TestApplied(t *testing.T) {
+	unchanged := &versionedResource{fakeResource: *file("/etc/a"), etag: "a"}
+	respecified := &versionedResource{fakeResource: *file("/etc/b"), etag: "b"}
+	modified := &versionedResource{fakeResource: *file("/etc/c"), etag: "c2"}
+	var events eventRecorder
+	o := NewOrchestrator(WithEventReporter(&events), WithApplied(map[string]Applied{
+		"a": {ETag: "a", Digest: "sha256:a"},
+		"b": {ETag: "b", Digest: "sha256:b"},
+		"c": {ETag: "c1", Digest: "sha256:c"},
+	}))
+	o.Add(ResourceSpec{Id: "a", Resource: unchanged, Digest: "sha256:a"})
+	o.Add(ResourceSpec{Id: "b", Resource: respecified, Digest: "sha256:b2"})
+	o.Add(ResourceSpec{Id: "c", Resource: modified, Digest: "sha256:c"})
+
+	summary := o.Run(context.Background(), true)
+	if !summary.Success {
+		t.Fatalf("expected success, got %v", summary.Error)
+	}
+
+	if a := summary.Attempts["a"]; unchanged.checked || !a.Revalidated || a.ETag != "a" || a.ChangedOutside {
+		t.Errorf("expected the unchanged resource to be revalidated instead of checked, got %+v", a)
+	}
+	if b := summary.Attempts["b"]; !respecified.checked || b.Revalidated || b.ChangedOutside {
+		t.Errorf("expected the resource with another specification to be checked, got %+v", b)
+	}
+	if c := summary.Attempts["c"]; !modified.checked || !c.ChangedOutside {
+		t.Errorf("expected the modified resource to be changed outside, got %+v", c)
+	}
+	warned := slices.ContainsFunc(events, func(e report.Event) bool {
+		return e.Kind == report.KindWarn && e.Id == "c"
+	})
+	if !warned {
+		t.Errorf("expected a warning about the modified resource, got %+v", events)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	o := NewOrchestrator(WithReporter(report.NilReporter{}), WithApplied(map[string]Applied{
+		"synced":      {ETag: "a", Digest: "sha256:a"},
+		"respecified": {ETag: "b", Digest: "sha256:b"},
+		"modified":    {ETag: "c1", Digest: "sha256:c"},
+		"both":        {ETag: "d1", Digest: "sha256:d"},
+		"unversioned": {Digest: "sha256:f"},
+	}))
+	o.Add(ResourceSpec{Id: "synced", Resource: &versionedResource{fakeResource: *file("/etc/a"), etag: "a"}, Digest: "sha256:a"})
+	o.Add(ResourceSpec{Id: "respecified", Resource: &versionedResource{fakeResource: *file("/etc/b"), etag: "b", needsApply: true}, Digest: "sha256:b2"})
+	o.Add(ResourceSpec{Id: "modified", Resource: &versionedResource{fakeResource: *file("/etc/c"), etag: "c2", needsApply: true}, Digest: "sha256:c"})
+	o.Add(ResourceSpec{Id: "both", Resource: &versionedResource{fakeResource: *file("/etc/d"), etag: "d2", needsApply: true}, Digest: "sha256:d2"})
+	o.Add(ResourceSpec{Id: "new", Resource: &versionedResource{fakeResource: *file("/etc/e"), etag: "e", needsApply: true}, Digest: "sha256:e"})
+	o.Add(ResourceSpec{Id: "unversioned", Resource: file("/etc/f"), Digest: "sha256:f"})
+
+	summary := o.Run(context.Background(), true)
+	if !summary.Success {
+		t.Fatalf("expected success, got %v", summary.Error)
+	}
+
+	want := map[string]Drift{
+		"synced":      DriftInSync,
+		"respecified": DriftChangedInManifest,
+		"modified":    DriftDrifted,
+		"both":        DriftDrifted,
+		"new":         "",
+		"unversioned": DriftInSync,
+	}
+	for id, drift := range want {
+		if got := summary.Attempts[id].Drift; got != drift {
+			t.Errorf("expected drift %q of %s, got %q", drift, id, got)
+		}
+	}
+	if got := summary.DriftCounts(); got != (DriftCounts{InSync: 2, Drifted: 2, ChangedInManifest: 1}) {
+		t.Errorf("unexpected drift counts %+v", got)
+	}
+
+	// Destroyed resources aren't classified
+	destroy := NewOrchestrator(WithReporter(report.NilReporter{}), WithDestroy(), WithApplied(map[string]Applied{
+		"config": {Digest: "sha256:config"},
+	}))
+	destroy.Add(ResourceSpec{Id: "config", Resource: &removableResource{fakeResource: *file("/etc/app/app.conf")}, Digest: "sha256:config"})
+	if summary := destroy.Run(context.Background(), true); summary.DriftCounts().Total() != 0 {
+		t.Errorf("expected no drift while destroying, got %+v", summary.DriftCounts())
+	}
+}
+
+// eventRecorder records the events of a run
+type eventRecorder []report.Event
+
+func (r *eventRecorder) Report(e report.Event) {
+	*r = append(*r, e)
+}
+
+func TestEventReporter(t *testing.T) {
+	var events eventRecorder
+	o := NewOrchestrator(WithEventReporter(&events))
+	if err := o.Add(ResourceSpec{Id: "a", Resource: file("/tmp/a")}); err != nil {
+		t.Fatal(err)
+	}
+
+	summary := o.Run(context.Background(), false)
+	if !summary.Success {
+		t.Fatalf("expected success, got %v", summary.Error)
+	}
+
+	if len(events) != 3 || events[0].Kind != report.KindEvaluate || events[1].Kind != report.KindNoChanges ||
+		events[2].Kind != report.KindFinished {
+		t.Fatalf("expected evaluate, no changes and finished events, got %+v", events)
+	}
+	if events[2].Counts != (report.Counts{Unchanged: 1}) {
+		t.Errorf("expected 1 unchanged resource, got %+v", events[2].Counts)
+	}
+	e := events[1]
+	if e.Id != "a" || e.Name != "file:/tmp/a" || e.Phase != report.PhaseEvaluate || e.Time.IsZero() {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
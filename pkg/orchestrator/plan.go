@@ -0,0 +1,151 @@
+package orchestrator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// planVersion is the version of the saved plan format
+const planVersion = 1
+
+// Plan is the saved outcome of a plan run. Applying it verifies that the changes
+// planned for every resource are still the ones needed, i.e. that neither the manifest
+// nor the state of the resources changed since planning.
+type Plan struct {
+	Version        int               `json:"version"`
+	Created        time.Time         `json:"created"`
+	Endpoint       string            `json:"endpoint"`
+	Manifest       string            `json:"manifest"`
+	ManifestDigest string            `json:"manifest_digest"`
+	Targets        []string          `json:"targets,omitempty"`
+	Skip           []string          `json:"skip,omitempty"`
+	Resources      []PlannedResource `json:"resources"`
+}
+
+// PlannedResource is the planned change of a single resource
+type PlannedResource struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	NeedsApply bool   `json:"needs_apply"`
+	// Diff is the redacted diff shown for review
+	Diff string `json:"diff,omitempty"`
+	// Fingerprint identifies the unredacted diff, see fingerprint
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// NewPlan returns the plan of a successful plan run, redact is applied to the names and
+// diffs of the resources
+func NewPlan(summary *Summary, redact func(string) string) (*Plan, error) {
+	if summary.Error != nil || !summary.Success {
+		return nil, fmt.Errorf("failed plans can't be saved")
+	}
+
+	p := &Plan{
+		Version:        planVersion,
+		Created:        time.Now().UTC(),
+		ManifestDigest: summary.ManifestDigest,
+	}
+	for _, id := range summary.Order {
+		a := summary.Attempts[id]
+		if a.Excluded {
+			continue
+		}
+		p.Resources = append(p.Resources, PlannedResource{
+			Id:          a.Id,
+			Name:        redact(a.Name),
+			NeedsApply:  a.NeedsApply,
+			Diff:        redact(a.Changes),
+			Fingerprint: fingerprint(a),
+		})
+	}
+	return p, nil
+}
+
+// fingerprint returns the digest of the changes planned for the resource of a, empty
+// if it doesn't need changes
+func fingerprint(a *Attempt) string {
+	if !a.NeedsApply {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", a.Id, a.Name, a.Changes)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify checks that summary, the outcome of planning again, matches the plan. It
+// returns an error naming the resources whose changes differ.
+func (p *Plan) Verify(summary *Summary) error {
+	if summary.ManifestDigest != p.ManifestDigest {
+		return fmt.Errorf("manifest changed since planning")
+	}
+
+	planned := make(map[string]PlannedResource, len(p.Resources))
+	for _, r := range p.Resources {
+		planned[r.Id] = r
+	}
+
+	var changed []string
+	for _, id := range summary.Order {
+		a := summary.Attempts[id]
+		if a.Excluded {
+			continue
+		}
+		r, ok := planned[id]
+		delete(planned, id)
+		if !ok || r.NeedsApply != a.NeedsApply || r.Fingerprint != fingerprint(a) {
+			changed = append(changed, id)
+		}
+	}
+	for id := range planned {
+		changed = append(changed, id)
+	}
+
+	if len(changed) > 0 {
+		slices.Sort(changed)
+		return fmt.Errorf("state changed since planning: %s", strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// Changes returns the number of resources the plan changes
+func (p *Plan) Changes() int {
+	n := 0
+	for _, r := range p.Resources {
+		if r.NeedsApply {
+			n++
+		}
+	}
+	return n
+}
+
+// Write saves the plan to the file at path
+func (p *Plan) Write(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// ReadPlan reads the plan saved to the file at path
+func ReadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+	if p.Version != planVersion {
+		return nil, fmt.Errorf("unsupported plan version %d", p.Version)
+	}
+	return &p, nil
+}
@@ -0,0 +1,85 @@
+package orchestrator
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func planSummary(attempts ...*Attempt) *Summary {
+	s := newSummary()
+	s.Success = true
+	s.ManifestDigest = "sha256:abc"
+	for _, a := range attempts {
+		s.Attempts[a.Id] = a
+		s.Order = append(s.Order, a.Id)
+	}
+	return s
+}
+
+func TestPlanVerify(t *testing.T) {
+	redact := func(s string) string { return strings.ReplaceAll(s, "secret", "***") }
+	planned := planSummary(
+		&Attempt{Id: "conf", Name: "file:/etc/app.conf", NeedsApply: true, Changes: "+ password=secret"},
+		&Attempt{Id: "dir", Name: "command:login --token secret", NeedsApply: false},
+		&Attempt{Id: "other", Name: "file:/tmp/other", Excluded: true},
+	)
+
+	p, err := NewPlan(planned, redact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Resources) != 2 {
+		t.Fatalf("expected excluded resources to be left out, got %+v", p.Resources)
+	}
+	if p.Resources[0].Diff != "+ password=***" {
+		t.Errorf("expected redacted diff, got %q", p.Resources[0].Diff)
+	}
+	if p.Resources[1].Name != "command:login --token ***" {
+		t.Errorf("expected redacted name, got %q", p.Resources[1].Name)
+	}
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := p.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	p, err = ReadPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Verify(planned); err != nil {
+		t.Errorf("expected unchanged plan to verify, got %v", err)
+	}
+
+	// The state of the file changed, the diff differs in the redacted part only
+	changed := planSummary(
+		&Attempt{Id: "conf", Name: "file:/etc/app.conf", NeedsApply: true, Changes: "+ password=secret2"},
+		&Attempt{Id: "dir", Name: "directory:/etc", NeedsApply: false},
+	)
+	if err := p.Verify(changed); err == nil || !strings.Contains(err.Error(), "conf") {
+		t.Errorf("expected conf to be reported as changed, got %v", err)
+	}
+
+	// The directory was removed in the meantime
+	changed = planSummary(
+		&Attempt{Id: "conf", Name: "file:/etc/app.conf", NeedsApply: true, Changes: "+ password=secret"},
+		&Attempt{Id: "dir", Name: "directory:/etc", NeedsApply: true, Changes: "+ create"},
+	)
+	if err := p.Verify(changed); err == nil || !strings.Contains(err.Error(), "dir") {
+		t.Errorf("expected dir to be reported as changed, got %v", err)
+	}
+
+	changed = planSummary(
+		&Attempt{Id: "conf", Name: "file:/etc/app.conf", NeedsApply: true, Changes: "+ password=secret"},
+	)
+	changed.ManifestDigest = "sha256:def"
+	if err := p.Verify(changed); err == nil {
+		t.Error("expected a changed manifest to be rejected")
+	}
+
+	planned.Success = false
+	if _, err := NewPlan(planned, redact); err == nil {
+		t.Error("expected failed plans to be rejected")
+	}
+}
@@ -0,0 +1,252 @@
+package orchestrator
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"peertech.de/axion/pkg/report"
+)
+
+// Outcomes of the resources in a run report
+const (
+	outcomeUnchanged  = "unchanged"
+	outcomeChanges    = "changes"
+	outcomeApplied    = "applied"
+	outcomeFailed     = "failed"
+	outcomeRolledBack = "rolled back"
+	outcomeSkipped    = "skipped"
+	outcomeExcluded   = "excluded"
+)
+
+// RunReport is a human-readable report of runs, e.g. for attaching to change tickets or
+// as artifact of CI jobs. It is written as Markdown or HTML.
+type RunReport struct {
+	Title string
+	Plan  bool
+	Time  time.Time
+	Runs  []ReportRun
+}
+
+// ReportRun is a run against a single endpoint in a RunReport
+type ReportRun struct {
+	Name      string
+	Footer    string // Counts and duration of the run, empty if it couldn't be started
+	Error     string
+	Resources []ReportResource
+}
+
+// ReportResource is the outcome of a single resource in a ReportRun
+type ReportResource struct {
+	Name     string
+	Outcome  string
+	Duration time.Duration
+	Diff     string
+	Error    string // Error the resource failed with, prefixed with the failed phase
+	Rollback string // Outcome of the rollback, empty if not rolled back
+}
+
+// NewRunReport returns an empty report of the runs titled title
+func NewRunReport(title string, plan bool) *RunReport {
+	return &RunReport{Title: title, Plan: plan, Time: time.Now()}
+}
+
+// Add adds the run of summary as name to the report. redact is applied to resource
+// names, diffs and errors.
+func (r *RunReport) Add(name string, summary *Summary, redact func(string) string) {
+	run := ReportRun{
+		Name:   name,
+		Footer: report.Footer(summary.Counts(), summary.Duration),
+	}
+	if summary.Error != nil {
+		run.Error = redact(summary.Error.Error())
+	}
+
+	for _, id := range summary.Order {
+		a := summary.Attempts[id]
+		res := ReportResource{
+			Name:     a.Id,
+			Duration: a.Duration,
+			Diff:     redact(a.Changes),
+		}
+		if a.Name != "" && a.Name != a.Id {
+			res.Name = fmt.Sprintf("%s (%s)", redact(a.Name), a.Id)
+		}
+
+		switch {
+		case a.EvaluationError != nil:
+			res.Error = "evaluation: " + redact(a.EvaluationError.Error())
+		case a.BackupError != nil:
+			res.Error = "backup: " + redact(a.BackupError.Error())
+		case a.ApplyError != nil:
+			res.Error = "apply: " + redact(a.ApplyError.Error())
+		}
+		switch {
+		case a.RollbackError != nil:
+			res.Rollback = "failed: " + redact(a.RollbackError.Error())
+		case a.RolledBack:
+			res.Rollback = "restored the previous state"
+		}
+
+		switch {
+		case a.Excluded:
+			res.Outcome = outcomeExcluded
+		case a.Skipped:
+			res.Outcome = outcomeSkipped
+		case res.Error != "":
+			res.Outcome = outcomeFailed
+		case a.RolledBack:
+			res.Outcome = outcomeRolledBack
+		case a.Applied:
+			res.Outcome = outcomeApplied
+		case a.NeedsApply:
+			res.Outcome = outcomeChanges
+		default:
+			res.Outcome = outcomeUnchanged
+		}
+		run.Resources = append(run.Resources, res)
+	}
+
+	r.Runs = append(r.Runs, run)
+}
+
+// AddError adds a run that could not be started, e.g. as the endpoint wasn't reachable
+func (r *RunReport) AddError(name, text string) {
+	r.Runs = append(r.Runs, ReportRun{Name: name, Error: text})
+}
+
+// Operation returns the operation of the runs for display
+func (r *RunReport) Operation() string {
+	if r.Plan {
+		return "Plan"
+	}
+	return "Apply"
+}
+
+// Details returns the resources with diffs, errors or rollbacks, which are detailed
+// below the table of resources
+func (run ReportRun) Details() []ReportResource {
+	var details []ReportResource
+	for _, res := range run.Resources {
+		if res.Diff != "" || res.Error != "" || res.Rollback != "" {
+			details = append(details, res)
+		}
+	}
+	return details
+}
+
+var reportFuncs = map[string]any{
+	"duration": func(d time.Duration) string {
+		return d.Round(time.Millisecond).String()
+	},
+	"time": func(t time.Time) string {
+		return t.Format(time.RFC3339)
+	},
+	"cell":  markdownCell,
+	"fence": markdownFence,
+}
+
+// WriteMarkdown writes the report to w as Markdown
+func (r *RunReport) WriteMarkdown(w io.Writer) error {
+	return markdownReport.Execute(w, r)
+}
+
+// WriteHTML writes the report to w as standalone HTML page
+func (r *RunReport) WriteHTML(w io.Writer) error {
+	return htmlReport.Execute(w, r)
+}
+
+// markdownCell escapes s for a cell of a Markdown table
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+var backticks = regexp.MustCompile("`{3,}")
+
+// markdownFence returns a code fence longer than any run of backticks in s, so that it
+// isn't closed early
+func markdownFence(s string) string {
+	fence := "```"
+	for _, run := range backticks.FindAllString(s, -1) {
+		if len(run) >= len(fence) {
+			fence = strings.Repeat("`", len(run)+1)
+		}
+	}
+	return fence
+}
+
+var markdownReport = template.Must(template.New("markdown").Funcs(reportFuncs).Parse(
+	`# {{ .Operation }} of {{ .Title }}
+
+{{ time .Time }}
+{{ range .Runs }}
+## {{ .Name }}
+{{ if .Footer }}
+{{ .Footer }}
+{{ end }}{{ if .Error }}
+**Error:** {{ cell .Error }}
+{{ end }}{{ if .Resources }}
+| Resource | Outcome | Duration |
+| --- | --- | --- |
+{{ range .Resources }}| {{ cell .Name }} | {{ .Outcome }} | {{ duration .Duration }} |
+{{ end }}{{ end }}{{ range .Details }}
+### {{ .Name }}
+{{ if .Error }}
+**Error:**
+
+{{ fence .Error }}
+{{ .Error }}
+{{ fence .Error }}
+{{ end }}{{ if .Rollback }}
+**Rollback:** {{ cell .Rollback }}
+{{ end }}{{ if .Diff }}
+{{ fence .Diff }}diff
+{{ .Diff }}
+{{ fence .Diff }}
+{{ end }}{{ end }}{{ end }}`))
+
+var htmlReport = htmltemplate.Must(htmltemplate.New("html").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Operation }} of {{ .Title }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+pre { background: #f6f8fa; padding: 0.6em; overflow-x: auto; }
+.failed, .error { color: #b00; }
+.applied { color: #080; }
+.changes, .rolled { color: #a60; }
+.skipped, .excluded { color: #888; }
+</style>
+</head>
+<body>
+<h1>{{ .Operation }} of {{ .Title }}</h1>
+<p>{{ time .Time }}</p>
+{{ range .Runs }}
+<h2>{{ .Name }}</h2>
+{{ if .Footer }}<p>{{ .Footer }}</p>{{ end }}
+{{ if .Error }}<p class="error"><strong>Error:</strong> {{ .Error }}</p>{{ end }}
+{{ if .Resources }}
+<table>
+<tr><th>Resource</th><th>Outcome</th><th>Duration</th></tr>
+{{ range .Resources }}<tr><td>{{ .Name }}</td><td class="{{ .Outcome }}">{{ .Outcome }}</td><td>{{ duration .Duration }}</td></tr>
+{{ end }}</table>
+{{ end }}
+{{ range .Details }}
+<h3>{{ .Name }}</h3>
+{{ if .Error }}<p class="error"><strong>Error:</strong></p>
+<pre>{{ .Error }}</pre>{{ end }}
+{{ if .Rollback }}<p><strong>Rollback:</strong> {{ .Rollback }}</p>{{ end }}
+{{ if .Diff }}<pre>{{ .Diff }}</pre>{{ end }}
+{{ end }}
+{{ end }}
+</body>
+</html>
+`))
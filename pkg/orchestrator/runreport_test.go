@@ -0,0 +1,66 @@
+package orchestrator
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunReport(t *testing.T) {
+	redact := func(s string) string { return strings.ReplaceAll(s, "secret", "***") }
+	summary := planSummary(
+		&Attempt{Id: "conf", Name: "file:/etc/app.conf", NeedsApply: true, Changes: "+ password=secret",
+			Applied: true, RolledBack: true, Duration: 1200 * time.Millisecond},
+		&Attempt{Id: "svc", Name: "command:login --token secret", NeedsApply: true, ApplyError: errors.New("exit status 1\nsecret | output")},
+		&Attempt{Id: "after", Name: "file:/etc/after", Skipped: true},
+		&Attempt{Id: "dir", Name: "directory:/etc"},
+	)
+	summary.Success = false
+	summary.Duration = 3 * time.Second
+
+	r := NewRunReport("manifest.yaml", false)
+	r.Add("web1", summary, redact)
+	r.AddError("web2", "connection refused")
+
+	var md bytes.Buffer
+	if err := r.WriteMarkdown(&md); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# Apply of manifest.yaml\n",
+		"## web1\n\nUnchanged 1, failed 1, skipped 1, rolled back 1 in 3s\n",
+		"| file:/etc/app.conf (conf) | rolled back | 1.2s |\n",
+		"| command:login --token *** (svc) | failed | 0s |\n",
+		"### command:login --token *** (svc)\n\n**Error:**\n\n```\napply: exit status 1\n*** | output\n```\n",
+		"**Rollback:** restored the previous state\n",
+		"```diff\n+ password=***\n```\n",
+		"## web2\n\n**Error:** connection refused\n",
+	} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("expected %q in Markdown report:\n%s", want, md.String())
+		}
+	}
+	if strings.Contains(md.String(), "### directory:/etc") {
+		t.Errorf("expected no details of unchanged resources:\n%s", md.String())
+	}
+
+	var html bytes.Buffer
+	if err := r.WriteHTML(&html); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<title>Apply of manifest.yaml</title>",
+		`<td class="failed">failed</td>`,
+		"<pre>&#43; password=***</pre>",
+	} {
+		if !strings.Contains(html.String(), want) {
+			t.Errorf("expected %q in HTML report:\n%s", want, html.String())
+		}
+	}
+
+	if fence := markdownFence("a ```` b"); fence != "`````" {
+		t.Errorf("expected fence longer than the backticks, got %q", fence)
+	}
+}
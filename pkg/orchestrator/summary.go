@@ -1,5 +1,11 @@
 package orchestrator
 
+import (
+	"time"
+
+	"peertech.de/axion/pkg/report"
+)
+
 func newSummary() *Summary {
 	return &Summary{
 		Attempts: make(map[string]*Attempt),
@@ -8,11 +14,56 @@ func newSummary() *Summary {
 
 // Summary provides a detailed report of the Apply operation.
 type Summary struct {
-	Success       bool
-	Error         error
-	Attempts      map[string]*Attempt // Atttempts keyed by resource Id
-	TotalCount    int
-	AppliedCount  int
-	SkippedCount  int
-	RollbackCount int
+	Success        bool
+	ManifestDigest string // Digest of the manifest, see WithManifestDigest
+	Error          error
+	Attempts       map[string]*Attempt // Atttempts keyed by resource Id
+	Order          []string            // Ids of the attempts in the order they were processed
+	TotalCount     int
+	AppliedCount   int
+	SkippedCount   int
+	ExcludedCount  int // Resources out of scope of the targets or skipped, see WithTargets
+	RollbackCount  int
+	Duration       time.Duration // Duration of the run
+}
+
+// Counts returns the outcomes of the resources for reporting. Resources rolled back are
+// counted as such, not as applied.
+func (s *Summary) Counts() report.Counts {
+	var c report.Counts
+	for _, a := range s.Attempts {
+		switch {
+		case a.Excluded:
+			c.Excluded++
+		case a.Skipped:
+			c.Skipped++
+		case a.EvaluationError != nil || a.BackupError != nil || a.ApplyError != nil:
+			c.Failed++
+		case a.RolledBack:
+			c.RolledBack++
+		case a.Applied:
+			c.Applied++
+		case a.NeedsApply:
+			c.Changes++
+		default:
+			c.Unchanged++
+		}
+	}
+	return c
+}
+
+// DriftCounts returns the resources classified by drift, see Attempt.Drift
+func (s *Summary) DriftCounts() DriftCounts {
+	var c DriftCounts
+	for _, a := range s.Attempts {
+		switch a.Drift {
+		case DriftInSync:
+			c.InSync++
+		case DriftDrifted:
+			c.Drifted++
+		case DriftChangedInManifest:
+			c.ChangedInManifest++
+		}
+	}
+	return c
 }
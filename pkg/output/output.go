@@ -0,0 +1,81 @@
+// Package output bounds the output of commands kept in memory.
+package output
+
+import "strings"
+
+// DefaultLimit is the number of bytes kept of each output stream unless configured
+// otherwise
+const DefaultLimit = 16 << 20
+
+// Retention selects the part of output exceeding the limit that is kept
+type Retention string
+
+const (
+	// Head keeps the beginning of the output
+	Head Retention = "head"
+	// Tail keeps the end of the output, usually where errors are reported
+	Tail Retention = "tail"
+)
+
+// Buffer keeps up to a limit of bytes of the output written to it and counts all bytes
+// written, so truncation can be reported. Writes never fail.
+type Buffer struct {
+	limit     int
+	retention Retention
+	buf       []byte
+	size      int64
+}
+
+// NewBuffer returns a buffer keeping limit bytes, DefaultLimit if limit isn't positive.
+// Retention defaults to Head if empty.
+func NewBuffer(limit int, retention Retention) *Buffer {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if retention == "" {
+		retention = Head
+	}
+	return &Buffer{limit: limit, retention: retention}
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.size += int64(len(p))
+
+	if b.retention != Tail {
+		if n := b.limit - len(b.buf); n > 0 {
+			b.buf = append(b.buf, p[:min(n, len(p))]...)
+		}
+		return len(p), nil
+	}
+
+	if len(p) >= b.limit {
+		b.buf = append(b.buf[:0], p[len(p)-b.limit:]...)
+		return len(p), nil
+	}
+	b.buf = append(b.buf, p...)
+	// Drop the beginning in bulk rather than on every write, the buffer holds at most
+	// twice the limit
+	if len(b.buf) >= 2*b.limit {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.limit:]...)
+	}
+	return len(p), nil
+}
+
+// String returns the kept output. Characters cut by the truncation are dropped.
+func (b *Buffer) String() string {
+	s := string(b.buf[max(len(b.buf)-b.limit, 0):])
+	if b.Truncated() {
+		s = strings.ToValidUTF8(s, "")
+	}
+	return s
+}
+
+// Size returns the number of bytes written, including the ones not kept
+func (b *Buffer) Size() int64 {
+	return b.size
+}
+
+// Truncated reports whether more bytes were written than kept
+func (b *Buffer) Truncated() bool {
+	return b.size > int64(b.limit)
+}
@@ -0,0 +1,81 @@
+package output_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"peertech.de/axion/pkg/output"
+)
+
+func TestBufferWithinLimit(t *testing.T) {
+	for _, retention := range []output.Retention{output.Head, output.Tail} {
+		b := output.NewBuffer(10, retention)
+		fmt.Fprint(b, "hello")
+		fmt.Fprint(b, "world")
+
+		if got := b.String(); got != "helloworld" {
+			t.Errorf("%s: expected %q, got %q", retention, "helloworld", got)
+		}
+		if b.Truncated() {
+			t.Errorf("%s: expected output not to be truncated", retention)
+		}
+		if b.Size() != 10 {
+			t.Errorf("%s: expected size 10, got %d", retention, b.Size())
+		}
+	}
+}
+
+func TestBufferHead(t *testing.T) {
+	b := output.NewBuffer(8, output.Head)
+	for i := range 10 {
+		fmt.Fprintf(b, "%d\n", i)
+	}
+
+	if got := b.String(); got != "0\n1\n2\n3\n" {
+		t.Errorf("expected the first lines, got %q", got)
+	}
+	if !b.Truncated() {
+		t.Error("expected output to be truncated")
+	}
+	if b.Size() != 20 {
+		t.Errorf("expected size 20, got %d", b.Size())
+	}
+}
+
+func TestBufferTail(t *testing.T) {
+	b := output.NewBuffer(8, output.Tail)
+	for i := range 100 {
+		fmt.Fprintf(b, "%d\n", i%10)
+	}
+
+	if got := b.String(); got != "6\n7\n8\n9\n" {
+		t.Errorf("expected the last lines, got %q", got)
+	}
+	if !b.Truncated() {
+		t.Error("expected output to be truncated")
+	}
+	if b.Size() != 200 {
+		t.Errorf("expected size 200, got %d", b.Size())
+	}
+
+	// A write exceeding the limit on its own
+	fmt.Fprint(b, strings.Repeat("x", 20)+"end")
+	if got := b.String(); got != "xxxxxend" {
+		t.Errorf("expected the end of the last write, got %q", got)
+	}
+}
+
+func TestBufferDropsCutCharacters(t *testing.T) {
+	b := output.NewBuffer(2, output.Head)
+	fmt.Fprint(b, "aäb")
+	if got := b.String(); got != "a" {
+		t.Errorf("expected %q, got %q", "a", got)
+	}
+
+	b = output.NewBuffer(2, output.Tail)
+	fmt.Fprint(b, "aäb")
+	if got := b.String(); got != "b" {
+		t.Errorf("expected %q, got %q", "b", got)
+	}
+}
@@ -0,0 +1,98 @@
+package report
+
+import (
+	"io"
+	"time"
+)
+
+// ANSI escape sequences of the colors used by the ColorReporter
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorBlue   = "\033[34m"
+	colorCyan   = "\033[36m"
+	colorGray   = "\033[90m"
+)
+
+// colorize wraps s in the color
+func colorize(color, s string) string {
+	return color + s + colorReset
+}
+
+// ColorReporter reports like the PlainReporter with the labels colored by outcome
+type ColorReporter struct {
+	writers
+}
+
+// NewColorReporter returns a ColorReporter printing to w and the warnings, errors and
+// failures to errW. Either may be nil, see writers.
+func NewColorReporter(w, errW io.Writer) ColorReporter {
+	return ColorReporter{writers{out: w, errOut: errW}}
+}
+
+func (r ColorReporter) Info(msg string) {
+	r.printf("%s %s %s\n", timestamp(), colorize(colorBlue, "Info:"), msg)
+}
+
+func (r ColorReporter) Warn(msg string) {
+	r.errorf("%s %s %s\n", timestamp(), colorize(colorYellow, "Warning:"), msg)
+}
+
+func (r ColorReporter) Error(msg string) {
+	r.errorf("%s %s %s\n", timestamp(), colorize(colorRed, "Error:"), msg)
+}
+
+func (r ColorReporter) Evaluate(id, name string) {
+	r.printf("%s %s %s\n", timestamp(), colorize(colorGray, "Evaluating:"), display(id, name))
+}
+
+func (r ColorReporter) NoChanges(id, name string) {
+	r.printf("%s %s %s\n", timestamp(), colorize(colorGray, "No changes needed:"), display(id, name))
+}
+
+func (r ColorReporter) Skipped(id, name string) {
+	r.printf("%s %s %s\n", timestamp(), colorize(colorYellow, "Skipped due to failure:"), display(id, name))
+}
+
+func (r ColorReporter) Excluded(id, name string) {
+	r.printf("%s %s %s\n", timestamp(), colorize(colorGray, "Excluded:"), display(id, name))
+}
+
+func (r ColorReporter) Diff(id, name, diff string) {
+	r.printf("%s %s %s:\n%s\n", timestamp(), colorize(colorCyan, "Diff for"), display(id, name), diff)
+}
+
+func (r ColorReporter) Apply(id, name string) {
+	r.printf("%s %s %s\n", timestamp(), colorize(colorBlue, "Applying:"), display(id, name))
+}
+
+func (r ColorReporter) Output(id, name, stream, line string) {
+	r.printf("%s %s %s: %s\n", timestamp(), display(id, name), colorize(colorGray, stream), line)
+}
+
+func (r ColorReporter) Backuped(id, name string) {
+	r.printf("%s %s %s\n", timestamp(), colorize(colorBlue, "Backed up:"), display(id, name))
+}
+
+func (r ColorReporter) Rollback(id, name string) {
+	r.printf("%s %s %s\n", timestamp(), colorize(colorYellow, "Rolling back:"), display(id, name))
+}
+
+func (r ColorReporter) Success(id, name string) {
+	r.printf("%s %s %s\n", timestamp(), colorize(colorGreen, "Success:"), display(id, name))
+}
+
+func (r ColorReporter) Fail(id, name string, err error) {
+	r.errorf("%s %s %s — %v\n", timestamp(), colorize(colorRed, "Failed:"), display(id, name), err)
+}
+
+// Finished implements Summarizer, the label is red if any resource failed
+func (r ColorReporter) Finished(c Counts, elapsed time.Duration) {
+	color := colorGreen
+	if c.Failed > 0 {
+		color = colorRed
+	}
+	r.printf("%s %s %s\n", timestamp(), colorize(color, "Finished:"), Footer(c, elapsed))
+}
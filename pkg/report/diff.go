@@ -0,0 +1,206 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultDiffContext is the number of unchanged lines kept around the changes of a diff
+const DefaultDiffContext = 3
+
+// DiffRenderer renders the diffs of resources for display. Lines prefixed with "+" or
+// "-" are changes, lines starting with "diff " or "@@" headers and all other lines
+// unchanged context.
+type DiffRenderer struct {
+	// Color colors additions green, removals red and headers cyan
+	Color bool
+	// Context is the number of unchanged lines kept before and after each change, longer
+	// runs of unchanged lines are collapsed into a single line. Negative values keep all
+	// lines.
+	Context int
+}
+
+// diffLine is a line of a diff and its kind
+type diffLine struct {
+	text string
+	kind byte // '+', '-', '@' for headers or ' ' for context
+}
+
+func parseDiffLine(s string) diffLine {
+	switch {
+	case strings.HasPrefix(s, "diff "), strings.HasPrefix(s, "@@"),
+		strings.HasPrefix(s, "+++ "), strings.HasPrefix(s, "--- "):
+		return diffLine{text: s, kind: '@'}
+	case strings.HasPrefix(s, "+"):
+		return diffLine{text: s, kind: '+'}
+	case strings.HasPrefix(s, "-"):
+		return diffLine{text: s, kind: '-'}
+	}
+	return diffLine{text: s, kind: ' '}
+}
+
+// Render returns diff with the unchanged lines collapsed and colored as configured
+func (d DiffRenderer) Render(diff string) string {
+	if diff == "" {
+		return diff
+	}
+	trailing := strings.HasSuffix(diff, "\n")
+
+	raw := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	lines := make([]diffLine, len(raw))
+	for i, s := range raw {
+		lines[i] = parseDiffLine(s)
+	}
+	keep := d.keep(lines)
+
+	var sb strings.Builder
+	for i := 0; i < len(lines); i++ {
+		if !keep[i] {
+			n := 1
+			for i+n < len(lines) && !keep[i+n] {
+				n++
+			}
+			d.write(&sb, diffLine{text: fmt.Sprintf("… %d unchanged lines", n), kind: '~'})
+			i += n - 1
+			continue
+		}
+		d.write(&sb, lines[i])
+	}
+
+	out := strings.TrimSuffix(sb.String(), "\n")
+	if trailing {
+		out += "\n"
+	}
+	return out
+}
+
+// keep returns which lines are kept: changes, headers and the context within reach of a
+// change. Single lines are never collapsed, the marker would be as long.
+func (d DiffRenderer) keep(lines []diffLine) []bool {
+	keep := make([]bool, len(lines))
+	if d.Context < 0 {
+		for i := range keep {
+			keep[i] = true
+		}
+		return keep
+	}
+
+	for i, l := range lines {
+		if l.kind == ' ' {
+			continue
+		}
+		keep[i] = true
+		if l.kind == '@' {
+			continue
+		}
+		for j := max(0, i-d.Context); j <= min(len(lines)-1, i+d.Context); j++ {
+			keep[j] = true
+		}
+	}
+
+	for i := range keep {
+		if !keep[i] && (i == 0 || keep[i-1]) && (i == len(keep)-1 || keep[i+1]) {
+			keep[i] = true
+		}
+	}
+	return keep
+}
+
+// write writes the line, colored if enabled
+func (d DiffRenderer) write(sb *strings.Builder, l diffLine) {
+	if !d.Color {
+		sb.WriteString(l.text + "\n")
+		return
+	}
+
+	switch l.kind {
+	case '+':
+		sb.WriteString(colorize(colorGreen, l.text))
+	case '-':
+		sb.WriteString(colorize(colorRed, l.text))
+	case '@':
+		sb.WriteString(colorize(colorCyan, l.text))
+	case '~':
+		sb.WriteString(colorize(colorGray, l.text))
+	default:
+		sb.WriteString(l.text)
+	}
+	sb.WriteString("\n")
+}
+
+// DiffReporter wraps a Reporter and renders the diffs passed on with a DiffRenderer
+type DiffReporter struct {
+	Reporter Reporter
+	Renderer DiffRenderer
+}
+
+func NewDiffReporter(r Reporter, renderer DiffRenderer) DiffReporter {
+	return DiffReporter{Reporter: r, Renderer: renderer}
+}
+
+func (r DiffReporter) Info(msg string) {
+	r.Reporter.Info(msg)
+}
+
+func (r DiffReporter) Warn(msg string) {
+	r.Reporter.Warn(msg)
+}
+
+func (r DiffReporter) Error(msg string) {
+	r.Reporter.Error(msg)
+}
+
+func (r DiffReporter) Evaluate(id, name string) {
+	r.Reporter.Evaluate(id, name)
+}
+
+func (r DiffReporter) NoChanges(id, name string) {
+	r.Reporter.NoChanges(id, name)
+}
+
+func (r DiffReporter) Skipped(id, name string) {
+	r.Reporter.Skipped(id, name)
+}
+
+func (r DiffReporter) Excluded(id, name string) {
+	r.Reporter.Excluded(id, name)
+}
+
+func (r DiffReporter) Diff(id, name, diff string) {
+	r.Reporter.Diff(id, name, r.Renderer.Render(diff))
+}
+
+func (r DiffReporter) Apply(id, name string) {
+	r.Reporter.Apply(id, name)
+}
+
+func (r DiffReporter) Output(id, name, stream, line string) {
+	r.Reporter.Output(id, name, stream, line)
+}
+
+func (r DiffReporter) Backuped(id, name string) {
+	r.Reporter.Backuped(id, name)
+}
+
+func (r DiffReporter) Rollback(id, name string) {
+	r.Reporter.Rollback(id, name)
+}
+
+func (r DiffReporter) Success(id, name string) {
+	r.Reporter.Success(id, name)
+}
+
+func (r DiffReporter) Fail(id, name string, err error) {
+	r.Reporter.Fail(id, name, err)
+}
+
+// Finish implements Finisher
+func (r DiffReporter) Finish() {
+	Finish(r.Reporter)
+}
+
+// Finished implements Summarizer
+func (r DiffReporter) Finished(c Counts, elapsed time.Duration) {
+	Finished(r.Reporter, c, elapsed)
+}
@@ -0,0 +1,260 @@
+package report
+
+import (
+	"time"
+)
+
+// Kind identifies what happened in an Event
+type Kind int
+
+const (
+	// KindInfo is a general informational message
+	KindInfo Kind = iota
+	// KindWarn is a warning about misconfiguration or a recoverable issue
+	KindWarn
+	// KindError is a non-fatal error
+	KindError
+	// KindEvaluate is the start of the evaluation of a resource
+	KindEvaluate
+	// KindNoChanges is a resource that doesn't need changes after evaluation
+	KindNoChanges
+	// KindSkipped is a resource that was skipped due to previous failures
+	KindSkipped
+	// KindExcluded is a resource out of scope of the targeted or skipped resources
+	KindExcluded
+	// KindDiff is a resource that has differences, see Event.Diff
+	KindDiff
+	// KindApply is the start of the apply of a resource
+	KindApply
+	// KindOutput is a line of output a resource wrote while being applied, see
+	// Event.Stream and Event.Line
+	KindOutput
+	// KindBackuped is a successful backup of a resource
+	KindBackuped
+	// KindRollback is the start of the rollback of a resource
+	KindRollback
+	// KindSuccess is a successfully applied resource
+	KindSuccess
+	// KindFail is a failure of a resource in Event.Phase, see Event.Err
+	KindFail
+	// KindFinished is the end of a run, see Event.Counts and Event.Duration
+	KindFinished
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindInfo:
+		return "info"
+	case KindWarn:
+		return "warn"
+	case KindError:
+		return "error"
+	case KindEvaluate:
+		return "evaluate"
+	case KindNoChanges:
+		return "no_changes"
+	case KindSkipped:
+		return "skipped"
+	case KindExcluded:
+		return "excluded"
+	case KindDiff:
+		return "diff"
+	case KindApply:
+		return "apply"
+	case KindOutput:
+		return "output"
+	case KindBackuped:
+		return "backuped"
+	case KindRollback:
+		return "rollback"
+	case KindSuccess:
+		return "success"
+	case KindFail:
+		return "fail"
+	case KindFinished:
+		return "finished"
+	default:
+		return "unknown"
+	}
+}
+
+// Phases of the processing of a resource
+const (
+	PhaseEvaluate = "evaluate"
+	PhaseBackup   = "backup"
+	PhaseApply    = "apply"
+	PhaseRollback = "rollback"
+)
+
+// Event is something that happened during a run. Fields which don't apply to the Kind
+// are left empty.
+type Event struct {
+	Kind Kind
+	Time time.Time
+
+	// Id and Name identify the resource, they are empty for messages
+	Id   string
+	Name string
+
+	// Phase is the phase of the resource the event belongs to
+	Phase string
+
+	// Message is the text of info, warning and error messages
+	Message string
+
+	// Diff is the description of the pending changes of a resource
+	Diff string
+
+	// Stream and Line are the stream and line of output of a resource
+	Stream string
+	Line   string
+
+	// Err is the error a resource failed with
+	Err error
+
+	// Counts are the outcomes of the resources of a finished run
+	Counts Counts
+
+	// Duration is the time spent in Phase for events ending it, i.e. the evaluation for
+	// NoChanges and Diff, the apply for Success and any phase for Fail, and the duration
+	// of the run for Finished
+	Duration time.Duration
+}
+
+// EventReporter receives the events of a run. Unlike a Reporter it has a single method,
+// so that new kinds of events and fields don't break its implementations.
+type EventReporter interface {
+	Report(e Event)
+}
+
+// Adapter passes events on to the methods of a Reporter, for the reporters which
+// aren't EventReporters
+type Adapter struct {
+	Reporter Reporter
+}
+
+func NewAdapter(r Reporter) Adapter {
+	return Adapter{Reporter: r}
+}
+
+// Report implements EventReporter
+func (a Adapter) Report(e Event) {
+	switch e.Kind {
+	case KindInfo:
+		a.Reporter.Info(e.Message)
+	case KindWarn:
+		a.Reporter.Warn(e.Message)
+	case KindError:
+		a.Reporter.Error(e.Message)
+	case KindEvaluate:
+		a.Reporter.Evaluate(e.Id, e.Name)
+	case KindNoChanges:
+		a.Reporter.NoChanges(e.Id, e.Name)
+	case KindSkipped:
+		a.Reporter.Skipped(e.Id, e.Name)
+	case KindExcluded:
+		a.Reporter.Excluded(e.Id, e.Name)
+	case KindDiff:
+		a.Reporter.Diff(e.Id, e.Name, e.Diff)
+	case KindApply:
+		a.Reporter.Apply(e.Id, e.Name)
+	case KindOutput:
+		a.Reporter.Output(e.Id, e.Name, e.Stream, e.Line)
+	case KindBackuped:
+		a.Reporter.Backuped(e.Id, e.Name)
+	case KindRollback:
+		a.Reporter.Rollback(e.Id, e.Name)
+	case KindSuccess:
+		a.Reporter.Success(e.Id, e.Name)
+	case KindFail:
+		a.Reporter.Fail(e.Id, e.Name, e.Err)
+	case KindFinished:
+		Finished(a.Reporter, e.Counts, e.Duration)
+	}
+}
+
+// Finish implements Finisher
+func (a Adapter) Finish() {
+	Finish(a.Reporter)
+}
+
+// Emitter turns the calls of the Reporter methods into events for an EventReporter, e.g.
+// to wrap it in a QuietReporter. The events carry no phase durations.
+type Emitter struct {
+	Reporter EventReporter
+}
+
+func NewEmitter(r EventReporter) Emitter {
+	return Emitter{Reporter: r}
+}
+
+func (r Emitter) emit(e Event) {
+	e.Time = time.Now()
+	r.Reporter.Report(e)
+}
+
+func (r Emitter) Info(msg string) {
+	r.emit(Event{Kind: KindInfo, Message: msg})
+}
+
+func (r Emitter) Warn(msg string) {
+	r.emit(Event{Kind: KindWarn, Message: msg})
+}
+
+func (r Emitter) Error(msg string) {
+	r.emit(Event{Kind: KindError, Message: msg})
+}
+
+func (r Emitter) Evaluate(id, name string) {
+	r.emit(Event{Kind: KindEvaluate, Id: id, Name: name, Phase: PhaseEvaluate})
+}
+
+func (r Emitter) NoChanges(id, name string) {
+	r.emit(Event{Kind: KindNoChanges, Id: id, Name: name, Phase: PhaseEvaluate})
+}
+
+func (r Emitter) Skipped(id, name string) {
+	r.emit(Event{Kind: KindSkipped, Id: id, Name: name})
+}
+
+func (r Emitter) Excluded(id, name string) {
+	r.emit(Event{Kind: KindExcluded, Id: id, Name: name})
+}
+
+func (r Emitter) Diff(id, name, diff string) {
+	r.emit(Event{Kind: KindDiff, Id: id, Name: name, Phase: PhaseEvaluate, Diff: diff})
+}
+
+func (r Emitter) Apply(id, name string) {
+	r.emit(Event{Kind: KindApply, Id: id, Name: name, Phase: PhaseApply})
+}
+
+func (r Emitter) Output(id, name, stream, line string) {
+	r.emit(Event{Kind: KindOutput, Id: id, Name: name, Phase: PhaseApply, Stream: stream, Line: line})
+}
+
+func (r Emitter) Backuped(id, name string) {
+	r.emit(Event{Kind: KindBackuped, Id: id, Name: name, Phase: PhaseBackup})
+}
+
+func (r Emitter) Rollback(id, name string) {
+	r.emit(Event{Kind: KindRollback, Id: id, Name: name, Phase: PhaseRollback})
+}
+
+func (r Emitter) Success(id, name string) {
+	r.emit(Event{Kind: KindSuccess, Id: id, Name: name, Phase: PhaseApply})
+}
+
+func (r Emitter) Fail(id, name string, err error) {
+	r.emit(Event{Kind: KindFail, Id: id, Name: name, Err: err})
+}
+
+// Finished implements Summarizer
+func (r Emitter) Finished(c Counts, elapsed time.Duration) {
+	r.emit(Event{Kind: KindFinished, Counts: c, Duration: elapsed})
+}
+
+// Finish implements Finisher
+func (r Emitter) Finish() {
+	Finish(r.Reporter)
+}
@@ -0,0 +1,65 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Counts are the outcomes of the resources of a run
+type Counts struct {
+	Applied    int
+	Changes    int // Resources needing changes which weren't applied, e.g. in plans
+	Unchanged  int
+	Failed     int
+	Skipped    int
+	RolledBack int
+	Excluded   int
+}
+
+// String returns the non-zero counts, e.g. "applied 4, unchanged 10"
+func (c Counts) String() string {
+	var parts []string
+	add := func(n int, what string) {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", what, n))
+		}
+	}
+	add(c.Applied, "applied")
+	add(c.Changes, "changes")
+	add(c.Unchanged, "unchanged")
+	add(c.Failed, "failed")
+	add(c.Skipped, "skipped")
+	add(c.RolledBack, "rolled back")
+	add(c.Excluded, "excluded")
+	return strings.Join(parts, ", ")
+}
+
+// Footer returns the line summarizing a run, e.g. "Applied 4, unchanged 10, skipped 2,
+// rolled back 1 in 12.3s"
+func Footer(c Counts, elapsed time.Duration) string {
+	counts := c.String()
+	if counts == "" {
+		counts = "nothing to do"
+	}
+	return fmt.Sprintf("%s%s in %s", strings.ToUpper(counts[:1]), counts[1:], elapsed.Round(100*time.Millisecond))
+}
+
+// Summarizer is implemented by reporters printing a footer once a run is done. Reporter
+// can't be extended without breaking its implementations, hence the separate interface.
+type Summarizer interface {
+	// Finished reports the outcomes of the resources of the run and its duration
+	Finished(c Counts, elapsed time.Duration)
+}
+
+// Finished reports the outcome of the run to r if it is a Summarizer
+func Finished(r any, c Counts, elapsed time.Duration) {
+	if s, ok := r.(Summarizer); ok {
+		s.Finished(c, elapsed)
+	}
+}
+
+// needsAttention reports whether the run changed or failed anything
+func (c Counts) needsAttention() bool {
+	return c.Applied > 0 || c.Changes > 0 || c.Failed > 0 || c.RolledBack > 0
+}
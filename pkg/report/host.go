@@ -0,0 +1,77 @@
+package report
+
+// HostReporter wraps a Reporter and prefixes all messages and resource names with the
+// host they concern, for runs against multiple hosts.
+type HostReporter struct {
+	Reporter Reporter
+	Host     string
+}
+
+func NewHostReporter(r Reporter, host string) HostReporter {
+	return HostReporter{Reporter: r, Host: host}
+}
+
+func (r HostReporter) prefix(s string) string {
+	return "[" + r.Host + "] " + s
+}
+
+func (r HostReporter) Info(msg string) {
+	r.Reporter.Info(r.prefix(msg))
+}
+
+func (r HostReporter) Warn(msg string) {
+	r.Reporter.Warn(r.prefix(msg))
+}
+
+func (r HostReporter) Error(msg string) {
+	r.Reporter.Error(r.prefix(msg))
+}
+
+func (r HostReporter) Evaluate(id, name string) {
+	r.Reporter.Evaluate(id, r.prefix(name))
+}
+
+func (r HostReporter) NoChanges(id, name string) {
+	r.Reporter.NoChanges(id, r.prefix(name))
+}
+
+func (r HostReporter) Skipped(id, name string) {
+	r.Reporter.Skipped(id, r.prefix(name))
+}
+
+func (r HostReporter) Excluded(id, name string) {
+	r.Reporter.Excluded(id, r.prefix(name))
+}
+
+func (r HostReporter) Diff(id, name, diff string) {
+	r.Reporter.Diff(id, r.prefix(name), diff)
+}
+
+func (r HostReporter) Apply(id, name string) {
+	r.Reporter.Apply(id, r.prefix(name))
+}
+
+func (r HostReporter) Output(id, name, stream, line string) {
+	r.Reporter.Output(id, r.prefix(name), stream, line)
+}
+
+func (r HostReporter) Backuped(id, name string) {
+	r.Reporter.Backuped(id, r.prefix(name))
+}
+
+func (r HostReporter) Rollback(id, name string) {
+	r.Reporter.Rollback(id, r.prefix(name))
+}
+
+func (r HostReporter) Success(id, name string) {
+	r.Reporter.Success(id, r.prefix(name))
+}
+
+func (r HostReporter) Fail(id, name string, err error) {
+	r.Reporter.Fail(id, r.prefix(name), err)
+}
+
+// Finish implements Finisher
+func (r HostReporter) Finish() {
+	Finish(r.Reporter)
+}
@@ -0,0 +1,134 @@
+package report
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// LogReporter logs the events of a run through zerolog with the resource id, name and
+// phase as fields, like the agent logs its operations. Evaluations and applies are
+// logged once they are done, with their duration. Progress and resources out of scope
+// are logged at debug level.
+type LogReporter struct {
+	logger zerolog.Logger
+
+	mu     sync.Mutex
+	phases map[string]phase // current phase by resource id
+}
+
+// phase is the current phase of a resource and when it started
+type phase struct {
+	name  string
+	start time.Time
+}
+
+func NewLogReporter(logger zerolog.Logger) *LogReporter {
+	return &LogReporter{
+		logger: logger,
+		phases: make(map[string]phase),
+	}
+}
+
+// begin records the start of a phase of the resource
+func (r *LogReporter) begin(id, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.phases[id] = phase{name: name, start: time.Now()}
+}
+
+// current returns the current phase of the resource, evaluate if it is unknown
+func (r *LogReporter) current(id string) phase {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.phases[id]
+	if !ok {
+		return phase{name: PhaseEvaluate}
+	}
+	return p
+}
+
+// event returns an event with the fields of the resource, the phase and, if the phase
+// is done, its duration
+func (r *LogReporter) event(e *zerolog.Event, id, name string, done bool) *zerolog.Event {
+	p := r.current(id)
+	e = e.Str("resource", id).Str("name", name).Str("phase", p.name)
+	if done && !p.start.IsZero() {
+		e = e.Dur("duration", time.Since(p.start))
+	}
+	return e
+}
+
+func (r *LogReporter) Info(msg string) {
+	r.logger.Info().Msg(msg)
+}
+
+func (r *LogReporter) Warn(msg string) {
+	r.logger.Warn().Msg(msg)
+}
+
+func (r *LogReporter) Error(msg string) {
+	r.logger.Error().Msg(msg)
+}
+
+func (r *LogReporter) Evaluate(id, name string) {
+	r.begin(id, PhaseEvaluate)
+	r.event(r.logger.Debug(), id, name, false).Msg("Evaluating resource")
+}
+
+func (r *LogReporter) NoChanges(id, name string) {
+	r.event(r.logger.Info(), id, name, true).Msg("No changes needed")
+}
+
+func (r *LogReporter) Skipped(id, name string) {
+	r.event(r.logger.Warn(), id, name, false).Msg("Skipped due to failure")
+}
+
+func (r *LogReporter) Excluded(id, name string) {
+	r.event(r.logger.Debug(), id, name, false).Msg("Excluded")
+}
+
+func (r *LogReporter) Diff(id, name, diff string) {
+	r.event(r.logger.Info(), id, name, true).Str("diff", diff).Msg("Changes found")
+}
+
+func (r *LogReporter) Apply(id, name string) {
+	r.begin(id, PhaseApply)
+	r.event(r.logger.Debug(), id, name, false).Msg("Applying resource")
+}
+
+func (r *LogReporter) Output(id, name, stream, line string) {
+	r.event(r.logger.Debug(), id, name, false).Str("stream", stream).Msg(line)
+}
+
+func (r *LogReporter) Backuped(id, name string) {
+	r.event(r.logger.Info(), id, name, false).Msg("Backed up")
+}
+
+func (r *LogReporter) Rollback(id, name string) {
+	r.begin(id, PhaseRollback)
+	r.event(r.logger.Warn(), id, name, false).Msg("Rolling back")
+}
+
+func (r *LogReporter) Success(id, name string) {
+	r.event(r.logger.Info(), id, name, true).Msg("Applied")
+}
+
+func (r *LogReporter) Fail(id, name string, err error) {
+	r.event(r.logger.Error(), id, name, true).Err(err).Msg("Failed")
+}
+
+// Finished implements Summarizer
+func (r *LogReporter) Finished(c Counts, elapsed time.Duration) {
+	r.logger.Info().
+		Int("applied", c.Applied).
+		Int("changes", c.Changes).
+		Int("unchanged", c.Unchanged).
+		Int("failed", c.Failed).
+		Int("skipped", c.Skipped).
+		Int("rolled_back", c.RolledBack).
+		Int("excluded", c.Excluded).
+		Dur("duration", elapsed).
+		Msg("Run finished")
+}
@@ -0,0 +1,229 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Finisher is implemented by reporters rendering state which has to be finalized once
+// a run is done, e.g. a live progress view
+type Finisher interface {
+	// Finish finalizes the output of the run
+	Finish()
+}
+
+// Finish finalizes the output of r if it is a Finisher, r is a Reporter or an
+// EventReporter
+func Finish(r any) {
+	if f, ok := r.(Finisher); ok {
+		f.Finish()
+	}
+}
+
+var spinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// maxStatusName limits the length of the resource name in the status line, which must
+// not wrap to be redrawn in place
+const maxStatusName = 48
+
+// ProgressReporter renders a live view of a run on a terminal: a status line with the
+// current resource, its phase, the elapsed time and the counts so far, which collapses
+// into a summary once the run is finished. Diffs, failures and messages are printed
+// above the status line, resources without changes only show up in the counts.
+type ProgressReporter struct {
+	w io.Writer
+
+	mu      sync.Mutex
+	start   time.Time // zero if no run is in progress
+	phase   string
+	current string
+	frame   int
+	drawn   bool
+	stop    chan struct{}
+	counts  Counts
+}
+
+func NewProgressReporter(w io.Writer) *ProgressReporter {
+	return &ProgressReporter{w: w}
+}
+
+// begin starts the clock and the redrawing of the status line on the first event
+func (r *ProgressReporter) begin() {
+	if !r.start.IsZero() {
+		return
+	}
+	r.start = time.Now()
+	r.stop = make(chan struct{})
+
+	stop := r.stop
+	go func() {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				r.mu.Lock()
+				// The run may have finished while waiting for the lock
+				select {
+				case <-stop:
+				default:
+					r.frame++
+					r.draw()
+				}
+				r.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// clear removes the status line
+func (r *ProgressReporter) clear() {
+	if r.drawn {
+		fmt.Fprint(r.w, "\r\033[K")
+		r.drawn = false
+	}
+}
+
+// draw renders the status line
+func (r *ProgressReporter) draw() {
+	r.clear()
+
+	current := []rune(r.current)
+	if len(current) > maxStatusName {
+		current = append([]rune("…"), current[len(current)-maxStatusName+1:]...)
+	}
+
+	elapsed := time.Since(r.start).Truncate(100 * time.Millisecond)
+	status := fmt.Sprintf("%s %s %s [%s]", spinner[r.frame%len(spinner)], r.phase, string(current), elapsed)
+	if counts := r.counts.String(); counts != "" {
+		status += " " + counts
+	}
+	fmt.Fprint(r.w, status)
+	r.drawn = true
+}
+
+// event updates the status line, printing the lines of format above it
+func (r *ProgressReporter) event(phase, name string, format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.update(phase, name, format, args...)
+}
+
+// count increments a count of the run and updates the status line like event
+func (r *ProgressReporter) count(n *int, format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*n++
+	r.update("", "", format, args...)
+}
+
+func (r *ProgressReporter) update(phase, name string, format string, args ...any) {
+	r.begin()
+	if phase != "" {
+		r.phase, r.current = phase, name
+	}
+	if format != "" {
+		r.clear()
+		fmt.Fprintf(r.w, format, args...)
+	}
+	r.draw()
+}
+
+func (r *ProgressReporter) Info(msg string) {
+	r.event("", "", "📢 %s\n", msg)
+}
+
+func (r *ProgressReporter) Warn(msg string) {
+	r.event("", "", "⚠️  %s\n", msg)
+}
+
+func (r *ProgressReporter) Error(msg string) {
+	r.event("", "", "❌ %s\n", msg)
+}
+
+func (r *ProgressReporter) Evaluate(id, name string) {
+	r.event("Evaluating", display(id, name), "")
+}
+
+func (r *ProgressReporter) NoChanges(id, name string) {
+	r.count(&r.counts.Unchanged, "")
+}
+
+func (r *ProgressReporter) Skipped(id, name string) {
+	r.count(&r.counts.Skipped, "⏭️ Skipped due to failure: %s\n", display(id, name))
+}
+
+func (r *ProgressReporter) Excluded(id, name string) {
+	r.count(&r.counts.Excluded, "")
+}
+
+func (r *ProgressReporter) Diff(id, name, diff string) {
+	r.count(&r.counts.Changes, "📄 Diff for %s:\n%s\n", display(id, name), diff)
+}
+
+func (r *ProgressReporter) Apply(id, name string) {
+	r.event("Applying", display(id, name), "")
+}
+
+func (r *ProgressReporter) Output(id, name, stream, line string) {
+	r.event("", "", "   │ %s\n", line)
+}
+
+func (r *ProgressReporter) Backuped(id, name string) {
+	r.event("", "", "💾 Backed up: %s\n", display(id, name))
+}
+
+func (r *ProgressReporter) Rollback(id, name string) {
+	r.event("Rolling back", display(id, name), "↩️ Rolling back: %s\n", display(id, name))
+}
+
+func (r *ProgressReporter) Success(id, name string) {
+	r.count(&r.counts.Applied, "✅ Success: %s\n", display(id, name))
+}
+
+func (r *ProgressReporter) Fail(id, name string, err error) {
+	r.count(&r.counts.Failed, "❌ Failed: %s — %s\n", display(id, name), err)
+}
+
+// end stops the redrawing and removes the status line, it reports whether a run was in
+// progress
+func (r *ProgressReporter) end() bool {
+	if r.start.IsZero() {
+		return false
+	}
+	close(r.stop)
+	r.clear()
+	return true
+}
+
+// reset prepares the reporter for another run, e.g. the apply following a plan
+func (r *ProgressReporter) reset() {
+	r.start, r.phase, r.current, r.counts = time.Time{}, "", "", Counts{}
+}
+
+// Finished implements Summarizer, the status line is replaced by the footer of the run
+func (r *ProgressReporter) Finished(c Counts, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.end()
+	fmt.Fprintf(r.w, "🏁 %s\n", Footer(c, elapsed))
+	r.reset()
+}
+
+// Finish implements Finisher, the status line is replaced by a footer with the counts
+// of the reporter unless the run was summarized already
+func (r *ProgressReporter) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.end() {
+		return
+	}
+	fmt.Fprintf(r.w, "🏁 %s\n", Footer(r.counts, time.Since(r.start)))
+	r.reset()
+}
@@ -0,0 +1,62 @@
+package report
+
+import "time"
+
+// QuietReporter wraps a Reporter and only passes on what needs attention: the changes,
+// the resources applied, failures, rollbacks, warnings and errors. Progress and
+// resources without changes are left out, so runs against converged systems, e.g. from
+// cron, report nothing at all.
+type QuietReporter struct {
+	Reporter Reporter
+}
+
+func NewQuietReporter(r Reporter) QuietReporter {
+	return QuietReporter{Reporter: r}
+}
+
+func (r QuietReporter) Info(msg string) {}
+
+func (r QuietReporter) Warn(msg string) {
+	r.Reporter.Warn(msg)
+}
+
+func (r QuietReporter) Error(msg string) {
+	r.Reporter.Error(msg)
+}
+
+func (r QuietReporter) Evaluate(id, name string)             {}
+func (r QuietReporter) NoChanges(id, name string)            {}
+func (r QuietReporter) Skipped(id, name string)              {}
+func (r QuietReporter) Excluded(id, name string)             {}
+func (r QuietReporter) Apply(id, name string)                {}
+func (r QuietReporter) Output(id, name, stream, line string) {}
+func (r QuietReporter) Backuped(id, name string)             {}
+
+func (r QuietReporter) Diff(id, name, diff string) {
+	r.Reporter.Diff(id, name, diff)
+}
+
+func (r QuietReporter) Success(id, name string) {
+	r.Reporter.Success(id, name)
+}
+
+func (r QuietReporter) Rollback(id, name string) {
+	r.Reporter.Rollback(id, name)
+}
+
+func (r QuietReporter) Fail(id, name string, err error) {
+	r.Reporter.Fail(id, name, err)
+}
+
+// Finish implements Finisher
+func (r QuietReporter) Finish() {
+	Finish(r.Reporter)
+}
+
+// Finished implements Summarizer, the footer is only passed on if the run changed or
+// failed anything
+func (r QuietReporter) Finished(c Counts, elapsed time.Duration) {
+	if c.needsAttention() {
+		Finished(r.Reporter, c, elapsed)
+	}
+}
@@ -0,0 +1,86 @@
+package report
+
+import (
+	"errors"
+	"time"
+)
+
+// RedactingReporter wraps a Reporter and redacts sensitive values, e.g. resolved secrets,
+// from all messages before passing them on.
+type RedactingReporter struct {
+	Reporter Reporter
+	Redact   func(string) string
+}
+
+func NewRedactingReporter(r Reporter, redact func(string) string) RedactingReporter {
+	return RedactingReporter{Reporter: r, Redact: redact}
+}
+
+func (r RedactingReporter) Info(msg string) {
+	r.Reporter.Info(r.Redact(msg))
+}
+
+func (r RedactingReporter) Warn(msg string) {
+	r.Reporter.Warn(r.Redact(msg))
+}
+
+func (r RedactingReporter) Error(msg string) {
+	r.Reporter.Error(r.Redact(msg))
+}
+
+func (r RedactingReporter) Evaluate(id, name string) {
+	r.Reporter.Evaluate(id, r.Redact(name))
+}
+
+func (r RedactingReporter) NoChanges(id, name string) {
+	r.Reporter.NoChanges(id, r.Redact(name))
+}
+
+func (r RedactingReporter) Skipped(id, name string) {
+	r.Reporter.Skipped(id, r.Redact(name))
+}
+
+func (r RedactingReporter) Excluded(id, name string) {
+	r.Reporter.Excluded(id, r.Redact(name))
+}
+
+func (r RedactingReporter) Diff(id, name, diff string) {
+	r.Reporter.Diff(id, r.Redact(name), r.Redact(diff))
+}
+
+func (r RedactingReporter) Apply(id, name string) {
+	r.Reporter.Apply(id, r.Redact(name))
+}
+
+func (r RedactingReporter) Output(id, name, stream, line string) {
+	r.Reporter.Output(id, r.Redact(name), stream, r.Redact(line))
+}
+
+func (r RedactingReporter) Backuped(id, name string) {
+	r.Reporter.Backuped(id, r.Redact(name))
+}
+
+func (r RedactingReporter) Rollback(id, name string) {
+	r.Reporter.Rollback(id, r.Redact(name))
+}
+
+func (r RedactingReporter) Success(id, name string) {
+	r.Reporter.Success(id, r.Redact(name))
+}
+
+func (r RedactingReporter) Fail(id, name string, err error) {
+	if err != nil {
+		err = errors.New(r.Redact(err.Error()))
+	}
+	r.Reporter.Fail(id, r.Redact(name), err)
+}
+
+// Finish implements Finisher
+func (r RedactingReporter) Finish() {
+	Finish(r.Reporter)
+}
+
+// Finished implements Summarizer
+func (r RedactingReporter) Finished(c Counts, elapsed time.Duration) {
+	Finished(r.Reporter, c, elapsed)
+}
@@ -2,6 +2,7 @@ package report
 
 import (
 	"fmt"
+	"io"
 	"time"
 )
 
@@ -24,12 +25,18 @@ type Reporter interface {
 	// Skipped reports a resource that was skipped due to previous failures
 	Skipped(id, name string)
 
+	// Excluded reports a resource out of scope of the targeted or skipped resources
+	Excluded(id, name string)
+
 	// Diff reports that a resource has differences
 	Diff(id, name, diff string)
 
 	// Apply reports the start of a resource apply
 	Apply(id, name string)
 
+	// Output reports a line of output a resource wrote to stream while being applied
+	Output(id, name, stream, line string)
+
 	// Backuped reports a successfuly backup of a resource
 	Backuped(id, name string)
 
@@ -54,117 +61,163 @@ func display(id, name string) string {
 	return name
 }
 
-type EmojiReporter struct{}
+// EmojiReporter prints a line with a timestamp and an emoji for every event
+type EmojiReporter struct {
+	writers
+}
+
+// NewEmojiReporter returns an EmojiReporter printing to w and the warnings, errors and
+// failures to errW. Either may be nil, see writers.
+func NewEmojiReporter(w, errW io.Writer) EmojiReporter {
+	return EmojiReporter{writers{out: w, errOut: errW}}
+}
 
 func (r EmojiReporter) Info(msg string) {
-	fmt.Printf("%s 📢 %s\n", timestamp(), msg)
+	r.printf("%s 📢 %s\n", timestamp(), msg)
 }
 
 func (r EmojiReporter) Warn(msg string) {
-	fmt.Printf("%s ⚠️  %s\n", timestamp(), msg)
+	r.errorf("%s ⚠️  %s\n", timestamp(), msg)
 }
 
 func (r EmojiReporter) Error(msg string) {
-	fmt.Printf("%s ❌ %s\n", timestamp(), msg)
+	r.errorf("%s ❌ %s\n", timestamp(), msg)
 }
 
 func (r EmojiReporter) Evaluate(id, name string) {
-	fmt.Printf("%s 🔍 Evaluating: %s\n", timestamp(), display(id, name))
+	r.printf("%s 🔍 Evaluating: %s\n", timestamp(), display(id, name))
 }
 
 func (r EmojiReporter) NoChanges(id, name string) {
-	fmt.Printf("%s ✨ No changes needed: %s\n", timestamp(), display(id, name))
+	r.printf("%s ✨ No changes needed: %s\n", timestamp(), display(id, name))
 }
 
 func (r EmojiReporter) Skipped(id, name string) {
-	fmt.Printf("%s ⏭️ Skipped due to failure: %s\n", timestamp(), display(id, name))
+	r.printf("%s ⏭️ Skipped due to failure: %s\n", timestamp(), display(id, name))
+}
+
+func (r EmojiReporter) Excluded(id, name string) {
+	r.printf("%s 🚫 Excluded: %s\n", timestamp(), display(id, name))
 }
 
 func (r EmojiReporter) Diff(id, name, diff string) {
-	fmt.Printf("%s 📄 Diff for %s:\n%s\n", timestamp(), display(id, name), diff)
+	r.printf("%s 📄 Diff for %s:\n%s\n", timestamp(), display(id, name), diff)
 }
 
 func (r EmojiReporter) Apply(id, name string) {
-	fmt.Printf("%s 🔧 Applying: %s\n", timestamp(), display(id, name))
+	r.printf("%s 🔧 Applying: %s\n", timestamp(), display(id, name))
+}
+
+func (r EmojiReporter) Output(id, name, stream, line string) {
+	r.printf("%s    │ %s\n", timestamp(), line)
 }
 
 func (r EmojiReporter) Backuped(id, name string) {
-	fmt.Printf("%s 💾 Backed up: %s\n", timestamp(), display(id, name))
+	r.printf("%s 💾 Backed up: %s\n", timestamp(), display(id, name))
 }
 
 func (r EmojiReporter) Rollback(id, name string) {
-	fmt.Printf("%s ↩️ Rolling back: %s\n", timestamp(), display(id, name))
+	r.printf("%s ↩️ Rolling back: %s\n", timestamp(), display(id, name))
 }
 
 func (r EmojiReporter) Success(id, name string) {
-	fmt.Printf("%s ✅ Success: %s\n", timestamp(), display(id, name))
+	r.printf("%s ✅ Success: %s\n", timestamp(), display(id, name))
 }
 
 func (r EmojiReporter) Fail(id, name string, err error) {
-	fmt.Printf("%s ❌ Failed: %s — %s\n", timestamp(), display(id, name), err)
+	r.errorf("%s ❌ Failed: %s — %s\n", timestamp(), display(id, name), err)
+}
+
+// Finished implements Summarizer
+func (r EmojiReporter) Finished(c Counts, elapsed time.Duration) {
+	r.printf("%s 🏁 %s\n", timestamp(), Footer(c, elapsed))
 }
 
-type PlainReporter struct{}
+// PlainReporter prints a line with a timestamp and a label for every event
+type PlainReporter struct {
+	writers
+}
+
+// NewPlainReporter returns a PlainReporter printing to w and the warnings, errors and
+// failures to errW. Either may be nil, see writers.
+func NewPlainReporter(w, errW io.Writer) PlainReporter {
+	return PlainReporter{writers{out: w, errOut: errW}}
+}
 
 func (r PlainReporter) Info(msg string) {
-	fmt.Printf("%s Info: %s\n", timestamp(), msg)
+	r.printf("%s Info: %s\n", timestamp(), msg)
 }
 
 func (r PlainReporter) Warn(msg string) {
-	fmt.Printf("%s Warning: %s\n", timestamp(), msg)
+	r.errorf("%s Warning: %s\n", timestamp(), msg)
 }
 
 func (r PlainReporter) Error(msg string) {
-	fmt.Printf("%s Error: %s\n", timestamp(), msg)
+	r.errorf("%s Error: %s\n", timestamp(), msg)
 }
 
 func (r PlainReporter) Evaluate(id, name string) {
-	fmt.Printf("%s Evaluating: %s\n", timestamp(), display(id, name))
+	r.printf("%s Evaluating: %s\n", timestamp(), display(id, name))
 }
 
 func (r PlainReporter) NoChanges(id, name string) {
-	fmt.Printf("%s No changes needed: %s\n", timestamp(), display(id, name))
+	r.printf("%s No changes needed: %s\n", timestamp(), display(id, name))
 }
 
 func (r PlainReporter) Skipped(id, name string) {
-	fmt.Printf("%s Skipped due to failure: %s\n", timestamp(), display(id, name))
+	r.printf("%s Skipped due to failure: %s\n", timestamp(), display(id, name))
+}
+
+func (r PlainReporter) Excluded(id, name string) {
+	r.printf("%s Excluded: %s\n", timestamp(), display(id, name))
 }
 
 func (r PlainReporter) Diff(id, name, diff string) {
-	fmt.Printf("%s Diff for %s:\n%s\n", timestamp(), display(id, name), diff)
+	r.printf("%s Diff for %s:\n%s\n", timestamp(), display(id, name), diff)
 }
 
 func (r PlainReporter) Apply(id, name string) {
-	fmt.Printf("%s Applying: %s\n", timestamp(), display(id, name))
+	r.printf("%s Applying: %s\n", timestamp(), display(id, name))
+}
+
+func (r PlainReporter) Output(id, name, stream, line string) {
+	r.printf("%s %s %s: %s\n", timestamp(), display(id, name), stream, line)
 }
 
 func (r PlainReporter) Backuped(id, name string) {
-	fmt.Printf("%s Backed up: %s\n", timestamp(), display(id, name))
+	r.printf("%s Backed up: %s\n", timestamp(), display(id, name))
 }
 
 func (r PlainReporter) Rollback(id, name string) {
-	fmt.Printf("%s Rolling back: %s\n", timestamp(), display(id, name))
+	r.printf("%s Rolling back: %s\n", timestamp(), display(id, name))
 }
 
 func (r PlainReporter) Success(id, name string) {
-	fmt.Printf("%s Success: %s\n", timestamp(), display(id, name))
+	r.printf("%s Success: %s\n", timestamp(), display(id, name))
 }
 
 func (r PlainReporter) Fail(id, name string, err error) {
-	fmt.Printf("%s Failed: %s — %v\n", timestamp(), display(id, name), err)
+	r.errorf("%s Failed: %s — %v\n", timestamp(), display(id, name), err)
+}
+
+// Finished implements Summarizer
+func (r PlainReporter) Finished(c Counts, elapsed time.Duration) {
+	r.printf("%s Finished: %s\n", timestamp(), Footer(c, elapsed))
 }
 
 type NilReporter struct{}
 
-func (r NilReporter) Info(msg string)                 {}
-func (r NilReporter) Warn(msg string)                 {}
-func (r NilReporter) Error(msg string)                {}
-func (r NilReporter) Evaluate(id, name string)        {}
-func (r NilReporter) NoChanges(id, name string)       {}
-func (r NilReporter) Skipped(id, name string)         {}
-func (r NilReporter) Diff(id, name, diff string)      {}
-func (r NilReporter) Apply(id, name string)           {}
-func (r NilReporter) Backuped(id, name string)        {}
-func (r NilReporter) Rollback(id, name string)        {}
-func (r NilReporter) Success(id, name string)         {}
-func (r NilReporter) Fail(id, name string, err error) {}
+func (r NilReporter) Info(msg string)                      {}
+func (r NilReporter) Warn(msg string)                      {}
+func (r NilReporter) Error(msg string)                     {}
+func (r NilReporter) Evaluate(id, name string)             {}
+func (r NilReporter) NoChanges(id, name string)            {}
+func (r NilReporter) Skipped(id, name string)              {}
+func (r NilReporter) Excluded(id, name string)             {}
+func (r NilReporter) Diff(id, name, diff string)           {}
+func (r NilReporter) Apply(id, name string)                {}
+func (r NilReporter) Output(id, name, stream, line string) {}
+func (r NilReporter) Backuped(id, name string)             {}
+func (r NilReporter) Rollback(id, name string)             {}
+func (r NilReporter) Success(id, name string)              {}
+func (r NilReporter) Fail(id, name string, err error)      {}
@@ -0,0 +1,225 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestReporterWriters(t *testing.T) {
+	var out, errOut bytes.Buffer
+	r := NewPlainReporter(&out, &errOut)
+
+	r.Apply("a", "/tmp/a")
+	r.Warn("careful")
+	r.Fail("a", "/tmp/a", errors.New("boom"))
+
+	if !strings.Contains(out.String(), "Applying: /tmp/a (a)") {
+		t.Errorf("expected apply on the output, got %q", out.String())
+	}
+	if strings.Contains(out.String(), "careful") || strings.Contains(out.String(), "boom") {
+		t.Errorf("expected no warnings or failures on the output, got %q", out.String())
+	}
+	if !strings.Contains(errOut.String(), "Warning: careful") || !strings.Contains(errOut.String(), "Failed: /tmp/a (a) — boom") {
+		t.Errorf("expected warning and failure on the error output, got %q", errOut.String())
+	}
+
+	// Without an error writer everything goes to the output
+	out.Reset()
+	NewEmojiReporter(&out, nil).Error("broken")
+	if !strings.Contains(out.String(), "❌ broken") {
+		t.Errorf("expected error on the output, got %q", out.String())
+	}
+}
+
+func TestLogReporter(t *testing.T) {
+	var out bytes.Buffer
+	r := NewLogReporter(zerolog.New(&out))
+
+	r.Evaluate("a", "/tmp/a")
+	r.Diff("a", "/tmp/a", "+content")
+	r.Apply("a", "/tmp/a")
+	r.Fail("a", "/tmp/a", errors.New("boom"))
+
+	var events []map[string]any
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var e map[string]any
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+
+	diff := events[1]
+	if diff["resource"] != "a" || diff["phase"] != PhaseEvaluate || diff["diff"] != "+content" {
+		t.Errorf("unexpected diff event %v", diff)
+	}
+	if _, ok := diff["duration"]; !ok {
+		t.Errorf("expected duration of the evaluation, got %v", diff)
+	}
+
+	fail := events[3]
+	if fail["level"] != "error" || fail["phase"] != PhaseApply || fail["error"] != "boom" {
+		t.Errorf("unexpected failure event %v", fail)
+	}
+}
+
+func TestTimingReporter(t *testing.T) {
+	var out bytes.Buffer
+	r := NewTimingReporter(NilReporter{}, &out)
+
+	r.Evaluate("fast", "fast")
+	r.NoChanges("fast", "fast")
+	r.Evaluate("slow", "slow")
+	r.Apply("slow", "slow")
+	time.Sleep(10 * time.Millisecond)
+	r.Fail("slow", "slow", errors.New("boom"))
+	r.Excluded("other", "other")
+	r.Finish()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header and 2 rows, got %q", out.String())
+	}
+	if fields := strings.Fields(lines[1]); fields[0] != "slow" || fields[1] != timingApply || fields[2] != timingFailed {
+		t.Errorf("expected the failed apply first, got %q", lines[1])
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != "fast" || fields[1] != timingNone || fields[2] != timingOK {
+		t.Errorf("expected the unchanged resource last, got %q", lines[2])
+	}
+
+	// The rows are reset for the next run
+	out.Reset()
+	r.Finish()
+	if out.Len() != 0 {
+		t.Errorf("expected no table without resources, got %q", out.String())
+	}
+}
+
+// recorder records the events reported to it
+type recorder []Event
+
+func (r *recorder) Report(e Event) {
+	*r = append(*r, e)
+}
+
+func TestAdapters(t *testing.T) {
+	var events recorder
+	// The events pass through the methods of a Reporter and back
+	r := NewAdapter(NewEmitter(&events))
+
+	boom := errors.New("boom")
+	r.Report(Event{Kind: KindEvaluate, Id: "a", Name: "/tmp/a", Phase: PhaseEvaluate})
+	r.Report(Event{Kind: KindDiff, Id: "a", Name: "/tmp/a", Phase: PhaseEvaluate, Diff: "+content"})
+	r.Report(Event{Kind: KindOutput, Id: "a", Name: "/tmp/a", Phase: PhaseApply, Stream: "stdout", Line: "done"})
+	r.Report(Event{Kind: KindFail, Id: "a", Name: "/tmp/a", Err: boom})
+	r.Report(Event{Kind: KindWarn, Message: "careful"})
+
+	kinds := []Kind{KindEvaluate, KindDiff, KindOutput, KindFail, KindWarn}
+	if len(events) != len(kinds) {
+		t.Fatalf("expected %d events, got %d: %+v", len(kinds), len(events), events)
+	}
+	for i, e := range events {
+		if e.Kind != kinds[i] {
+			t.Errorf("expected event %d to be %s, got %s", i, kinds[i], e.Kind)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("expected event %d to have a time", i)
+		}
+	}
+	if events[1].Diff != "+content" || events[2].Line != "done" || events[3].Err != boom || events[4].Message != "careful" {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestQuietReporter(t *testing.T) {
+	var out bytes.Buffer
+	r := NewQuietReporter(NewPlainReporter(&out, nil))
+
+	r.Info("Starting")
+	r.Evaluate("a", "a")
+	r.NoChanges("a", "a")
+	if out.Len() != 0 {
+		t.Fatalf("expected no output for converged resources, got %q", out.String())
+	}
+
+	r.Evaluate("b", "b")
+	r.Diff("b", "b", "+content")
+	r.Apply("b", "b")
+	r.Success("b", "b")
+	for _, expected := range []string{"Diff for b:\n+content", "Success: b"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %q in the output, got %q", expected, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "Applying") {
+		t.Errorf("expected no progress in the output, got %q", out.String())
+	}
+}
+
+func TestDiffRenderer(t *testing.T) {
+	var diff strings.Builder
+	diff.WriteString("diff -- file: /etc/app.conf\n")
+	for i := 1; i <= 10; i++ {
+		fmt.Fprintf(&diff, " line %d\n", i)
+	}
+	diff.WriteString("-old\n+new\n")
+	for i := 11; i <= 12; i++ {
+		fmt.Fprintf(&diff, " line %d\n", i)
+	}
+
+	got := DiffRenderer{Context: 2}.Render(diff.String())
+	expected := `diff -- file: /etc/app.conf
+… 8 unchanged lines
+ line 9
+ line 10
+-old
++new
+ line 11
+ line 12
+`
+	if got != expected {
+		t.Errorf("expected collapsed diff\n%s\ngot\n%s", expected, got)
+	}
+
+	if got := (DiffRenderer{Context: -1}).Render(diff.String()); got != diff.String() {
+		t.Errorf("expected diff unchanged without collapsing, got\n%s", got)
+	}
+
+	got = DiffRenderer{Color: true, Context: -1}.Render("-old\n+new")
+	if got != colorize(colorRed, "-old")+"\n"+colorize(colorGreen, "+new") {
+		t.Errorf("unexpected colored diff %q", got)
+	}
+}
+
+func TestFooter(t *testing.T) {
+	c := Counts{Applied: 4, Unchanged: 10, Skipped: 2, RolledBack: 1}
+	if got := Footer(c, 12345*time.Millisecond); got != "Applied 4, unchanged 10, skipped 2, rolled back 1 in 12.3s" {
+		t.Errorf("unexpected footer %q", got)
+	}
+	if got := Footer(Counts{}, 0); got != "Nothing to do in 0s" {
+		t.Errorf("unexpected footer %q", got)
+	}
+
+	// Quiet runs only show the footer if anything changed
+	var out bytes.Buffer
+	r := NewQuietReporter(NewPlainReporter(&out, nil))
+	Finished(r, Counts{Unchanged: 10}, time.Second)
+	if out.Len() != 0 {
+		t.Errorf("expected no footer for a converged run, got %q", out.String())
+	}
+	Finished(r, Counts{Applied: 1, Unchanged: 9}, time.Second)
+	if !strings.Contains(out.String(), "Finished: Applied 1, unchanged 9 in 1s") {
+		t.Errorf("expected footer, got %q", out.String())
+	}
+}
@@ -0,0 +1,116 @@
+package report
+
+import (
+	"sync"
+	"time"
+)
+
+// SyncReporter wraps a Reporter and serializes its calls with a lock shared by the
+// reporters of concurrent runs, so that their multi-line messages, e.g. diffs, aren't
+// interleaved.
+type SyncReporter struct {
+	Reporter Reporter
+	mu       *sync.Mutex
+}
+
+func NewSyncReporter(r Reporter, mu *sync.Mutex) SyncReporter {
+	return SyncReporter{Reporter: r, mu: mu}
+}
+
+func (r SyncReporter) Info(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reporter.Info(msg)
+}
+
+func (r SyncReporter) Warn(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reporter.Warn(msg)
+}
+
+func (r SyncReporter) Error(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reporter.Error(msg)
+}
+
+func (r SyncReporter) Evaluate(id, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reporter.Evaluate(id, name)
+}
+
+func (r SyncReporter) NoChanges(id, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reporter.NoChanges(id, name)
+}
+
+func (r SyncReporter) Skipped(id, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reporter.Skipped(id, name)
+}
+
+func (r SyncReporter) Excluded(id, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reporter.Excluded(id, name)
+}
+
+func (r SyncReporter) Diff(id, name, diff string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reporter.Diff(id, name, diff)
+}
+
+func (r SyncReporter) Apply(id, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reporter.Apply(id, name)
+}
+
+func (r SyncReporter) Output(id, name, stream, line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reporter.Output(id, name, stream, line)
+}
+
+func (r SyncReporter) Backuped(id, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reporter.Backuped(id, name)
+}
+
+func (r SyncReporter) Rollback(id, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reporter.Rollback(id, name)
+}
+
+func (r SyncReporter) Success(id, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reporter.Success(id, name)
+}
+
+func (r SyncReporter) Fail(id, name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reporter.Fail(id, name, err)
+}
+
+// Finish implements Finisher
+func (r SyncReporter) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	Finish(r.Reporter)
+}
+
+// Finished implements Summarizer
+func (r SyncReporter) Finished(c Counts, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	Finished(r.Reporter, c, elapsed)
+}
@@ -0,0 +1,182 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Operations and results of the resources in the table of the TimingReporter
+const (
+	timingNone     = "none"
+	timingChange   = "change"
+	timingApply    = "apply"
+	timingRollback = "rollback"
+
+	timingOK       = "ok"
+	timingPlanned  = "planned"
+	timingFailed   = "failed"
+	timingSkipped  = "skipped"
+	timingRunning  = "running"
+	timingExcluded = "excluded"
+)
+
+// TimingReporter wraps a Reporter and times the resources of a run. Once the run is
+// finished, a table of the resources with their operation, result and duration is
+// written below the output of the wrapped reporter, the slowest first. Excluded
+// resources are left out.
+type TimingReporter struct {
+	Reporter Reporter
+	w        io.Writer
+
+	mu      sync.Mutex
+	timings map[string]*timing
+}
+
+// timing is the row of a resource in the table
+type timing struct {
+	name      string
+	operation string
+	result    string
+	start     time.Time
+	duration  time.Duration
+}
+
+func NewTimingReporter(r Reporter, w io.Writer) *TimingReporter {
+	return &TimingReporter{Reporter: r, w: w, timings: make(map[string]*timing)}
+}
+
+// start starts the clock of the resource, replacing the row of a previous evaluation
+func (r *TimingReporter) start(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timings[id] = &timing{operation: timingNone, start: time.Now()}
+}
+
+// record updates the row of the resource, the duration is measured from the start of
+// its evaluation
+func (r *TimingReporter) record(id, name, operation, result string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.timings[id]
+	if !ok {
+		t = &timing{operation: timingNone, start: time.Now()}
+		r.timings[id] = t
+	}
+	t.name = display(id, name)
+	if operation != "" {
+		t.operation = operation
+	}
+	t.result = result
+	t.duration = time.Since(t.start)
+}
+
+func (r *TimingReporter) Info(msg string) {
+	r.Reporter.Info(msg)
+}
+
+func (r *TimingReporter) Warn(msg string) {
+	r.Reporter.Warn(msg)
+}
+
+func (r *TimingReporter) Error(msg string) {
+	r.Reporter.Error(msg)
+}
+
+func (r *TimingReporter) Evaluate(id, name string) {
+	r.start(id)
+	r.record(id, name, timingNone, timingRunning)
+	r.Reporter.Evaluate(id, name)
+}
+
+func (r *TimingReporter) NoChanges(id, name string) {
+	r.record(id, name, timingNone, timingOK)
+	r.Reporter.NoChanges(id, name)
+}
+
+func (r *TimingReporter) Skipped(id, name string) {
+	r.record(id, name, "", timingSkipped)
+	r.Reporter.Skipped(id, name)
+}
+
+func (r *TimingReporter) Excluded(id, name string) {
+	r.record(id, name, timingNone, timingExcluded)
+	r.Reporter.Excluded(id, name)
+}
+
+func (r *TimingReporter) Diff(id, name, diff string) {
+	r.record(id, name, timingChange, timingPlanned)
+	r.Reporter.Diff(id, name, diff)
+}
+
+func (r *TimingReporter) Apply(id, name string) {
+	r.record(id, name, timingApply, timingRunning)
+	r.Reporter.Apply(id, name)
+}
+
+func (r *TimingReporter) Output(id, name, stream, line string) {
+	r.Reporter.Output(id, name, stream, line)
+}
+
+func (r *TimingReporter) Backuped(id, name string) {
+	r.Reporter.Backuped(id, name)
+}
+
+func (r *TimingReporter) Rollback(id, name string) {
+	r.record(id, name, timingRollback, timingRunning)
+	r.Reporter.Rollback(id, name)
+}
+
+func (r *TimingReporter) Success(id, name string) {
+	r.record(id, name, "", timingOK)
+	r.Reporter.Success(id, name)
+}
+
+func (r *TimingReporter) Fail(id, name string, err error) {
+	r.record(id, name, "", timingFailed)
+	r.Reporter.Fail(id, name, err)
+}
+
+// Finish implements Finisher, the table is written after the wrapped reporter is
+// finished
+func (r *TimingReporter) Finish() {
+	Finish(r.Reporter)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rows := make([]*timing, 0, len(r.timings))
+	for _, t := range r.timings {
+		if t.result != timingExcluded {
+			rows = append(rows, t)
+		}
+	}
+	// The reporter may be used for another run, e.g. the apply following a plan
+	r.timings = make(map[string]*timing)
+	if len(rows) == 0 {
+		return
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].duration != rows[j].duration {
+			return rows[i].duration > rows[j].duration
+		}
+		return rows[i].name < rows[j].name
+	})
+
+	tw := tabwriter.NewWriter(r.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESOURCE\tOPERATION\tRESULT\tDURATION")
+	for _, t := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.name, t.operation, t.result, t.duration.Round(time.Millisecond))
+	}
+	tw.Flush()
+}
+
+// Finished implements Summarizer
+func (r *TimingReporter) Finished(c Counts, elapsed time.Duration) {
+	Finished(r.Reporter, c, elapsed)
+}
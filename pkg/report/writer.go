@@ -0,0 +1,33 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// writers holds the writers a reporter prints to. The output goes to stdout unless out
+// is set, errors, warnings and failures go to the output unless errOut is set. The zero
+// value therefore prints everything to stdout.
+type writers struct {
+	out    io.Writer
+	errOut io.Writer
+}
+
+// printf writes to the output
+func (w writers) printf(format string, args ...any) {
+	out := w.out
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprintf(out, format, args...)
+}
+
+// errorf writes to the error output
+func (w writers) errorf(format string, args ...any) {
+	if w.errOut == nil {
+		w.printf(format, args...)
+		return
+	}
+	fmt.Fprintf(w.errOut, format, args...)
+}
@@ -6,15 +6,29 @@ import (
 
 	ops_directories "peertech.de/axion/api/client/directories"
 	ops_files "peertech.de/axion/api/client/files"
+	ops_packages "peertech.de/axion/api/client/packages"
+	ops_services "peertech.de/axion/api/client/services"
+	ops_symlinks "peertech.de/axion/api/client/symlinks"
 	"peertech.de/axion/api/models"
 )
 
 type APIError struct {
 	Code    int64
 	Message string
+	// RequestID identifies the failed request in the logs of the agent, empty if the
+	// agent didn't report it
+	RequestID string
+}
+
+// newAPIError converts the error payload of the agent
+func newAPIError(payload *models.Error) *APIError {
+	return &APIError{Code: payload.Code, Message: payload.Message, RequestID: payload.RequestID}
 }
 
 func (ae *APIError) Error() string {
+	if ae.RequestID != "" {
+		return fmt.Sprintf("Error code %d: %s (request %s)", ae.Code, ae.Message, ae.RequestID)
+	}
 	return fmt.Sprintf("Error code %d: %s", ae.Code, ae.Message)
 }
 
@@ -23,11 +37,42 @@ func fileNotFound(err error) bool {
 	return errors.As(err, &notFound)
 }
 
+func fileNotModified(err error) bool {
+	var notModified *ops_files.GetFilePropertiesNotModified
+	return errors.As(err, &notModified)
+}
+
 func directoryNotFound(err error) bool {
 	var notFound *ops_directories.GetDirectoryPropertiesNotFound
 	return errors.As(err, &notFound)
 }
 
+func directoryNotModified(err error) bool {
+	var notModified *ops_directories.GetDirectoryPropertiesNotModified
+	return errors.As(err, &notModified)
+}
+
+func symlinkNotFound(err error) bool {
+	var notFound *ops_symlinks.GetSymlinkPropertiesNotFound
+	return errors.As(err, &notFound)
+}
+
+func packageNotFound(err error) bool {
+	var notFound *ops_packages.GetPackageNotFound
+	return errors.As(err, &notFound)
+}
+
+func serviceNotFound(err error) bool {
+	var notFound *ops_services.GetServiceNotFound
+	return errors.As(err, &notFound)
+}
+
+// notSymlink reports whether the agent refused the path as it is not a symlink
+func notSymlink(err error) bool {
+	var badRequest *ops_symlinks.GetSymlinkPropertiesBadRequest
+	return errors.As(err, &badRequest)
+}
+
 type errorWithPayload interface {
 	GetPayload() *models.Error
 }
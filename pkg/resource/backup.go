@@ -0,0 +1,212 @@
+package resource
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ops_backup "peertech.de/axion/api/client/backup"
+	"peertech.de/axion/pkg/backup"
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/pointer"
+	"peertech.de/axion/pkg/version"
+)
+
+// backupFetcher writes the content to back up to w. ifNoneMatch is the ETag of the
+// existing backup, if any, the fetcher reports whether it is still current instead.
+type backupFetcher func(w io.Writer, ifNoneMatch *string) (etag string, notModified bool, err error)
+
+// writeBackup stores the content fetched by fetch at path. The ETag of the content is
+// stored next to it, so a backup which is still current isn't fetched again. The
+// existing backup is only superseded once the content has been fetched completely, it
+// is kept as version until pruned, see the backup package. The checksum of new backups
+// is recorded for verifyBackup, and they are copied to the remote backup storage, if
+// configured.
+func writeBackup(ctx context.Context, cfg *config.Config, path string, fetch backupFetcher) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	var ifNoneMatch *string
+	if _, err := os.Stat(path); err == nil {
+		if etag, err := os.ReadFile(path + ".etag"); err == nil && len(etag) > 0 {
+			ifNoneMatch = pointer.To(strings.TrimSpace(string(etag)))
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	etag, notModified, err := fetch(io.MultiWriter(tmp, hasher), ifNoneMatch)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || notModified {
+		return err
+	}
+
+	if err := backup.Supersede(path); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if err := backup.WriteChecksum(path, hex.EncodeToString(hasher.Sum(nil))); err != nil {
+		return err
+	}
+	if etag == "" {
+		os.Remove(path + ".etag")
+	} else if err := os.WriteFile(path+".etag", []byte(etag+"\n"), 0644); err != nil {
+		return err
+	}
+
+	if cfg.RemoteBackups != nil {
+		return backup.Upload(ctx, cfg.RemoteBackups, cfg.BackupDir, path)
+	}
+	return nil
+}
+
+// ensureBackup checks that the backup at path exists, backups lost with the backup
+// directory are downloaded from the remote backup storage, if configured
+func ensureBackup(ctx context.Context, cfg *config.Config, path string) error {
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return err
+	}
+	if cfg.RemoteBackups == nil {
+		return fmt.Errorf("no backup file found at %s", path)
+	}
+	return backup.Download(ctx, cfg.RemoteBackups, cfg.BackupDir, path)
+}
+
+// reuseBackup records etag, of the state the backup at path was restored to, as ETag
+// of the backup, so the next backup of the unchanged resource reuses it instead of
+// fetching the same content again
+func reuseBackup(path, etag string) error {
+	if etag == "" {
+		return nil
+	}
+	return os.WriteFile(path+".etag", []byte(etag+"\n"), 0644)
+}
+
+// discardBackup removes the backup at path taken by the run, with its copy in the
+// remote backup storage, see backup.Discard
+func discardBackup(ctx context.Context, cfg *config.Config, path string) error {
+	return backup.Discard(ctx, cfg.RemoteBackups, cfg.BackupDir, path)
+}
+
+// verifyBackup checks the backup at path against its recorded checksum before it is
+// restored, archives are read completely as well. Rollbacks fail early instead of
+// restoring a truncated backup.
+func verifyBackup(path string, archive bool) error {
+	if err := backup.Verify(path); err != nil {
+		return err
+	}
+	if !archive {
+		return nil
+	}
+	if err := verifyArchive(path); err != nil {
+		return fmt.Errorf("backup %s is corrupt: %w", path, err)
+	}
+	return nil
+}
+
+// verifyArchive reads all entries of the archive at path, and the compressed stream up
+// to its end so its trailer is checked as well
+func verifyArchive(path string) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	format, err := archiveFormat(fd)
+	if err != nil {
+		return err
+	}
+	dr, release, err := decompress(fd, format)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	tr := tar.NewReader(dr)
+	for {
+		_, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			_, err = io.Copy(io.Discard, dr)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// useAgentBackups reports whether backups are taken on the agent instead of being
+// transferred to the client
+func useAgentBackups(cfg *config.Config) bool {
+	return cfg.AgentBackups && cfg.Supports(version.CapabilityBackups)
+}
+
+// createAgentBackup backs up the file or directory at path on the agent and returns the
+// id of the backup. include and exclude filter the entries of a directory.
+func createAgentBackup(ctx context.Context, cfg *config.Config, path string, include, exclude []string) (string, error) {
+	params := ops_backup.NewCreateBackupParamsWithContext(ctx)
+	params.Path = path
+	params.Include = include
+	params.Exclude = exclude
+
+	resp, err := cfg.Client.Backup.CreateBackup(params)
+	if err != nil {
+		if payload := getErrorPayload(err); payload != nil {
+			return "", newAPIError(payload)
+		}
+		return "", fmt.Errorf("failed to create backup on agent: %w", err)
+	}
+	return resp.Payload.ID, nil
+}
+
+// restoreAgentBackup restores the backup with the given id on the agent and removes it
+func restoreAgentBackup(ctx context.Context, cfg *config.Config, id string) error {
+	params := ops_backup.NewRestoreBackupParamsWithContext(ctx)
+	params.ID = id
+
+	if _, _, err := cfg.Client.Backup.RestoreBackup(params); err != nil {
+		if payload := getErrorPayload(err); payload != nil {
+			return newAPIError(payload)
+		}
+		return fmt.Errorf("failed to restore backup on agent: %w", err)
+	}
+
+	// A backup which can't be removed expires with the retention of the agent
+	deleteAgentBackup(ctx, cfg, id)
+	return nil
+}
+
+// deleteAgentBackup removes the backup with the given id from the agent
+func deleteAgentBackup(ctx context.Context, cfg *config.Config, id string) error {
+	params := ops_backup.NewDeleteBackupParamsWithContext(ctx)
+	params.ID = id
+
+	if _, err := cfg.Client.Backup.DeleteBackup(params); err != nil {
+		if payload := getErrorPayload(err); payload != nil {
+			return newAPIError(payload)
+		}
+		return fmt.Errorf("failed to delete backup on agent: %w", err)
+	}
+	return nil
+}
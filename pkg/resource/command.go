@@ -1,16 +1,25 @@
 package resource
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"strings"
 	"time"
 
 	"peertech.de/axion/api/models"
 	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/output"
+	"peertech.de/axion/pkg/version"
 
 	ops_command "peertech.de/axion/api/client/command"
+	ops_directories "peertech.de/axion/api/client/directories"
+	ops_files "peertech.de/axion/api/client/files"
 )
 
 func NewCommand(cfg *config.Config, command string, opts ...CommandOption) *Command {
@@ -30,18 +39,51 @@ func NewCommand(cfg *config.Config, command string, opts ...CommandOption) *Comm
 	}
 }
 
+// NewScript returns a command resource executing script with the interpreter, the
+// default interpreter of the agent (/bin/sh) if empty. The command shown for it is the
+// first line of the script.
+func NewScript(cfg *config.Config, script, interpreter string, opts ...CommandOption) *Command {
+	c := NewCommand(cfg, firstLine(script), opts...)
+	c.script = script
+	c.interpreter = interpreter
+	return c
+}
+
+const (
+	// asyncThreshold is the timeout above which commands run as jobs polled by the
+	// client, as a single request would likely exceed HTTP timeouts
+	asyncThreshold = 5 * time.Minute
+
+	// maxPollInterval limits the interval in which jobs are polled
+	maxPollInterval = 10 * time.Second
+
+	// responseMargin is the time granted to the agent beyond the timeout of a command
+	// to respond with its result, or with the partial output once it timed out
+	responseMargin = 30 * time.Second
+)
+
 type CommandOption func(co *CommandOptions)
 
-// TODO: Implement a Conditioner (Conditional), like Ansible creates/removes
 type CommandOptions struct {
 	// Whether this command can run concurrently with other resources (default: false)
 	IsConcurrent bool
 
-	// Timeout for command execution (default: 30s)
+	// Timeout for command execution (default: 30s). Commands with a timeout above
+	// asyncThreshold run as jobs, see executeAsync.
 	Timeout time.Duration
 
 	// Expected exit codes (default: [0])
 	ExpectedExitCodes []int
+
+	// Creates skips the command if the given path already exists on the target system
+	Creates string
+
+	// Unless skips the command if the given guard command exits with code 0
+	Unless string
+
+	// OutputRetention selects whether the beginning or the end of output exceeding the
+	// limit of the agent is kept (default: head)
+	OutputRetention output.Retention
 }
 
 func WithConcurrent(concurrent bool) CommandOption {
@@ -62,6 +104,24 @@ func WithExpectedExitCodes(codes ...int) CommandOption {
 	}
 }
 
+func WithCreates(path string) CommandOption {
+	return func(co *CommandOptions) {
+		co.Creates = path
+	}
+}
+
+func WithUnless(command string) CommandOption {
+	return func(co *CommandOptions) {
+		co.Unless = command
+	}
+}
+
+func WithOutputRetention(retention output.Retention) CommandOption {
+	return func(co *CommandOptions) {
+		co.OutputRetention = retention
+	}
+}
+
 // CommandExecutionError represents a command that executed but failed
 type CommandExecutionError struct {
 	Command  string
@@ -83,14 +143,27 @@ type Command struct {
 
 	command string
 	options CommandOptions
+
+	// script is executed with the interpreter instead of the command, if set
+	script      string
+	interpreter string
+
+	// output receives the output while the command runs, if set
+	output OutputFunc
 }
 
 func (c *Command) Name() string {
+	if c.script != "" {
+		return "script:" + c.command
+	}
 	return "command:" + c.command
 }
 
 func (c *Command) Validate() error {
 	if c.command == "" {
+		if c.script != "" || c.interpreter != "" {
+			return fmt.Errorf("script cannot be empty")
+		}
 		return fmt.Errorf("command cannot be empty")
 	}
 
@@ -98,6 +171,12 @@ func (c *Command) Validate() error {
 		return fmt.Errorf("timeout must be positive")
 	}
 
+	switch c.options.OutputRetention {
+	case "", output.Head, output.Tail:
+	default:
+		return fmt.Errorf("invalid output retention %q, must be head or tail", c.options.OutputRetention)
+	}
+
 	if len(c.options.ExpectedExitCodes) == 0 {
 		return fmt.Errorf("at least one expected exit code must be specified")
 	}
@@ -105,28 +184,148 @@ func (c *Command) Validate() error {
 	return nil
 }
 
+// SetOutput makes Apply stream the output of the command to fn while it runs
+func (c *Command) SetOutput(fn OutputFunc) {
+	c.output = fn
+}
+
 func (c *Command) IsConcurrent() bool {
 	return c.options.IsConcurrent
 }
 
+// Check reports whether the command needs to run. Commands always run unless one of the
+// guards (creates, unless) indicates that the work has already been done.
 func (c *Command) Check(ctx context.Context) (bool, error) {
+	if c.options.Creates != "" {
+		exists, err := c.pathExists(ctx, c.options.Creates)
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			return false, nil
+		}
+	}
+
+	if c.options.Unless != "" {
+		succeeded, err := c.guardSucceeds(ctx, c.options.Unless)
+		if err != nil {
+			return false, err
+		}
+		if succeeded {
+			return false, nil
+		}
+	}
+
 	return true, nil
 }
 
+// pathExists reports whether a file or directory exists at path on the target system.
+// Symlinks are followed, dangling ones don't exist.
+func (c *Command) pathExists(ctx context.Context, path string) (bool, error) {
+	if path == "" {
+		return false, fmt.Errorf("creates path cannot be empty")
+	}
+
+	params := ops_directories.NewGetDirectoryPropertiesParamsWithContext(ctx)
+	params.Path = path
+
+	_, err := c.cfg.Client.Directories.GetDirectoryProperties(params)
+	if err == nil {
+		return true, nil
+	}
+	if directoryNotFound(err) {
+		return false, nil
+	}
+	var badRequest *ops_directories.GetDirectoryPropertiesBadRequest
+	if !errors.As(err, &badRequest) {
+		return false, createsError(path, err)
+	}
+
+	// The path is not a directory, stat it as a file
+	fileParams := ops_files.NewGetFilePropertiesParamsWithContext(ctx)
+	fileParams.Path = path
+
+	_, err = c.cfg.Client.Files.GetFileProperties(fileParams)
+	if err == nil {
+		return true, nil
+	}
+	if fileNotFound(err) {
+		return false, nil
+	}
+	// The agent doesn't stat files through symlinks, the directory lookup above
+	// followed it to an existing target
+	var notRegular *ops_files.GetFilePropertiesBadRequest
+	if errors.As(err, &notRegular) {
+		return true, nil
+	}
+	return false, createsError(path, err)
+}
+
+func createsError(path string, err error) error {
+	if payload := getErrorPayload(err); payload != nil {
+		return newAPIError(payload)
+	}
+	return fmt.Errorf("failed to check creates path %q: %w", path, err)
+}
+
+// guardSucceeds executes the guard command and reports whether it exited with code 0.
+func (c *Command) guardSucceeds(ctx context.Context, command string) (bool, error) {
+	params := ops_command.NewExecuteCommandParamsWithContext(ctx)
+	params.Command = &models.CommandRequest{
+		Command:           command,
+		ExpectedExitCodes: []int64{0},
+	}
+
+	resp, err := c.cfg.Client.Command.ExecuteCommand(params)
+	if err != nil {
+		if payload := getErrorPayload(err); payload != nil {
+			return false, newAPIError(payload)
+		}
+		return false, fmt.Errorf("failed to execute unless guard '%s': %w", command, err)
+	}
+	if resp.Payload == nil {
+		return false, fmt.Errorf("received empty response for unless guard: %s", command)
+	}
+
+	return resp.Payload.Success, nil
+}
+
 func (c *Command) Diff(ctx context.Context) (string, error) {
 	var sb strings.Builder
-	fmt.Fprintf(&sb, "diff -- command: %s\n", c.command)
-	fmt.Fprintf(&sb, "+ will execute\n")
+	if c.script != "" {
+		fmt.Fprintf(&sb, "diff -- script: %s\n", c.command)
+		fmt.Fprintf(&sb, "+ will execute\n")
+		if c.interpreter != "" {
+			fmt.Fprintf(&sb, "  interpreter: %s\n", c.interpreter)
+		}
+		fmt.Fprintf(&sb, "  script: |\n")
+		for _, line := range strings.Split(strings.TrimRight(c.script, "\n"), "\n") {
+			fmt.Fprintf(&sb, "    %s\n", line)
+		}
+	} else {
+		fmt.Fprintf(&sb, "diff -- command: %s\n", c.command)
+		fmt.Fprintf(&sb, "+ will execute\n")
+	}
 	fmt.Fprintf(&sb, "  timeout: %v\n", c.options.Timeout)
 	fmt.Fprintf(&sb, "  expected_exit_codes: %v\n", c.options.ExpectedExitCodes)
+	if c.options.Creates != "" {
+		fmt.Fprintf(&sb, "  creates: %s\n", c.options.Creates)
+	}
+	if c.options.Unless != "" {
+		fmt.Fprintf(&sb, "  unless: %s\n", c.options.Unless)
+	}
 
 	return sb.String(), nil
 }
 
-func (c *Command) Apply(ctx context.Context) error {
+// Run executes the command and returns its result, also if it exited with an unexpected
+// code. The output is relayed to the output function while it runs, if set.
+func (c *Command) Run(ctx context.Context) (*models.CommandResponse, error) {
 	r := &models.CommandRequest{
 		Command:           c.command,
 		ExpectedExitCodes: make([]int64, len(c.options.ExpectedExitCodes)),
+		Timeout:           int64(math.Ceil(c.options.Timeout.Seconds())),
+		OutputRetention:   string(c.options.OutputRetention),
 	}
 
 	// Convert expected exit codes
@@ -134,65 +333,94 @@ func (c *Command) Apply(ctx context.Context) error {
 		r.ExpectedExitCodes[i] = int64(code)
 	}
 
-	// Execute command via API
-	params := ops_command.NewExecuteCommandParamsWithContext(ctx)
-	params.Command = r
-
-	resp, err := c.cfg.Client.Command.ExecuteCommand(params)
+	// Execute command via API, agents lacking jobs or streaming run it synchronously
+	var result *models.CommandResponse
+	var err error
+	switch {
+	case c.script != "":
+		result, err = c.executeScript(ctx, r)
+	case c.options.Timeout > asyncThreshold && c.cfg.Supports(version.CapabilityAsyncCommands):
+		result, err = c.executeAsync(ctx, r)
+	case c.output != nil && c.cfg.Supports(version.CapabilityCommandStream):
+		result, err = c.executeStream(ctx, r)
+	default:
+		result, err = c.execute(ctx, r)
+	}
 	if err != nil {
 		// Only handle actual HTTP/API errors here (network, timeout, server errors)
 		if payload := getErrorPayload(err); payload != nil {
 			switch payload.Code {
 			case http.StatusBadRequest:
-				return &APIError{
-					Code:    payload.Code,
-					Message: fmt.Sprintf("Invalid command request '%s': %s", c.command, payload.Message),
+				return nil, &APIError{
+					Code:      payload.Code,
+					Message:   fmt.Sprintf("Invalid command request '%s': %s", c.command, payload.Message),
+					RequestID: payload.RequestID,
 				}
 			case http.StatusRequestTimeout:
-				return &APIError{
-					Code:    payload.Code,
-					Message: fmt.Sprintf("Command timed out after %v: %s", c.options.Timeout, c.command),
+				msg := fmt.Sprintf("Command timed out after %v: %s", c.options.Timeout, c.command)
+				if payload.Details != "" {
+					msg += "\n" + payload.Details
+				}
+				return nil, &APIError{
+					Code:      payload.Code,
+					Message:   msg,
+					RequestID: payload.RequestID,
 				}
 			case http.StatusInternalServerError:
-				return &APIError{
-					Code:    payload.Code,
-					Message: fmt.Sprintf("Server error executing command '%s': %s", c.command, payload.Message),
+				return nil, &APIError{
+					Code:      payload.Code,
+					Message:   fmt.Sprintf("Server error executing command '%s': %s", c.command, payload.Message),
+					RequestID: payload.RequestID,
 				}
 			default:
-				return &APIError{
-					Code:    payload.Code,
-					Message: fmt.Sprintf("Failed to execute command '%s': %s", c.command, payload.Message),
+				return nil, &APIError{
+					Code:      payload.Code,
+					Message:   fmt.Sprintf("Failed to execute command '%s': %s", c.command, payload.Message),
+					RequestID: payload.RequestID,
 				}
 			}
 		}
-		return fmt.Errorf("failed to execute command '%s': %w", c.command, err)
+		return nil, fmt.Errorf("failed to execute command '%s': %w", c.command, err)
 	}
 
-	if resp.Payload == nil {
-		return fmt.Errorf("received empty response for command: %s", c.command)
+	if result == nil {
+		return nil, fmt.Errorf("received empty response for command: %s", c.command)
 	}
 
-	if !resp.Payload.Success {
+	return result, nil
+}
+
+func (c *Command) Apply(ctx context.Context) error {
+	result, err := c.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !result.Success {
 		// Build detailed error message with execution details
 		var details strings.Builder
 		fmt.Fprintf(&details, "Command: %s\n", c.command)
-		fmt.Fprintf(&details, "Exit Code: %d\n", resp.Payload.ExitCode)
+		fmt.Fprintf(&details, "Exit Code: %d\n", result.ExitCode)
 		fmt.Fprintf(&details, "Expected Exit Codes: %v\n", c.options.ExpectedExitCodes)
 
-		if resp.Payload.Stdout != "" {
-			fmt.Fprintf(&details, "Stdout:\n%s\n", resp.Payload.Stdout)
+		if result.Stdout != "" {
+			fmt.Fprintf(&details, "Stdout:\n%s\n", result.Stdout)
+		}
+
+		if result.Stderr != "" {
+			fmt.Fprintf(&details, "Stderr:\n%s\n", result.Stderr)
 		}
 
-		if resp.Payload.Stderr != "" {
-			fmt.Fprintf(&details, "Stderr:\n%s\n", resp.Payload.Stderr)
+		if result.Truncated {
+			fmt.Fprintf(&details, "Output truncated (stdout %d bytes, stderr %d bytes)\n", result.StdoutSize, result.StderrSize)
 		}
 
 		return &CommandExecutionError{
 			Command:  c.command,
-			ExitCode: int(resp.Payload.ExitCode),
+			ExitCode: int(result.ExitCode),
 			Expected: c.options.ExpectedExitCodes,
-			Stdout:   resp.Payload.Stdout,
-			Stderr:   resp.Payload.Stderr,
+			Stdout:   result.Stdout,
+			Stderr:   result.Stderr,
 			Details:  details.String(),
 		}
 	}
@@ -200,6 +428,232 @@ func (c *Command) Apply(ctx context.Context) error {
 	return nil
 }
 
+// executeAsync starts the command as a job and polls it until it finishes. Polling backs
+// off up to maxPollInterval. If the timeout expires, the job is cancelled.
+func (c *Command) executeAsync(ctx context.Context, r *models.CommandRequest) (*models.CommandResponse, error) {
+	params := ops_command.NewExecuteCommandAsyncParamsWithContext(ctx)
+	params.Command = r
+
+	resp, err := c.cfg.Client.Command.ExecuteCommandAsync(params)
+	if err != nil {
+		return nil, err
+	}
+	id := resp.Payload.ID
+
+	ctx, cancel := context.WithTimeout(ctx, c.options.Timeout)
+	defer cancel()
+
+	delay := time.Second
+	for {
+		select {
+		case <-ctx.Done():
+			c.cancelJob(id)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, &payloadError{payload: &models.Error{
+					Code:    http.StatusRequestTimeout,
+					Message: "Command execution timed out",
+				}}
+			}
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxPollInterval)
+
+		params := ops_command.NewGetCommandJobParamsWithContext(ctx)
+		params.ID = id
+
+		resp, err := c.cfg.Client.Command.GetCommandJob(params)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue // Cancel the job
+			}
+			return nil, err
+		}
+
+		switch job := resp.Payload; job.State {
+		case models.CommandJobStateCompleted:
+			return job.Result, nil
+		case models.CommandJobStateFailed:
+			return nil, &payloadError{payload: job.Error}
+		case models.CommandJobStateCancelled:
+			return nil, fmt.Errorf("command job %s was cancelled", id)
+		}
+	}
+}
+
+// cancelJob cancels a command job, errors are ignored as the job is abandoned anyway
+func (c *Command) cancelJob(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	params := ops_command.NewCancelCommandJobParamsWithContext(ctx)
+	params.ID = id
+	_, _ = c.cfg.Client.Command.CancelCommandJob(params)
+}
+
+// requestTimeout returns the timeout of requests running the command synchronously.
+// The default timeout of the client would cancel commands running longer than 30s
+// before the agent enforces their timeout, commands without timeout are only limited
+// by the context.
+func (c *Command) requestTimeout() time.Duration {
+	if c.options.Timeout <= 0 {
+		return 0
+	}
+	return c.options.Timeout + responseMargin
+}
+
+// execute runs the command and returns its result including the output
+func (c *Command) execute(ctx context.Context, r *models.CommandRequest) (*models.CommandResponse, error) {
+	params := ops_command.NewExecuteCommandParamsWithContext(ctx)
+	params.SetTimeout(c.requestTimeout())
+	params.Command = r
+
+	resp, err := c.cfg.Client.Command.ExecuteCommand(params)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Payload, nil
+}
+
+// executeScript runs the script with the options of r, streaming its output if the
+// output function is set. Scripts can't run as jobs.
+func (c *Command) executeScript(ctx context.Context, r *models.CommandRequest) (*models.CommandResponse, error) {
+	if !c.cfg.Supports(version.CapabilityScripts) {
+		return nil, fmt.Errorf("the agent does not support scripts")
+	}
+
+	script := &models.ScriptRequest{
+		Script:            c.script,
+		Interpreter:       c.interpreter,
+		ExpectedExitCodes: r.ExpectedExitCodes,
+		Timeout:           r.Timeout,
+		OutputRetention:   r.OutputRetention,
+	}
+
+	if c.output != nil {
+		return c.collectStream(func(events io.Writer) error {
+			params := ops_command.NewExecuteScriptStreamParamsWithContext(ctx)
+			params.SetTimeout(c.requestTimeout())
+			params.Script = script
+			_, err := c.cfg.Client.Command.ExecuteScriptStream(params, events)
+			return err
+		})
+	}
+
+	params := ops_command.NewExecuteScriptParamsWithContext(ctx)
+	params.SetTimeout(c.requestTimeout())
+	params.Script = script
+
+	resp, err := c.cfg.Client.Command.ExecuteScript(params)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Payload, nil
+}
+
+// executeStream runs the command using the streaming endpoint, see collectStream
+func (c *Command) executeStream(ctx context.Context, r *models.CommandRequest) (*models.CommandResponse, error) {
+	return c.collectStream(func(events io.Writer) error {
+		params := ops_command.NewExecuteCommandStreamParamsWithContext(ctx)
+		params.SetTimeout(c.requestTimeout())
+		params.Command = r
+		_, err := c.cfg.Client.Command.ExecuteCommandStream(params, events)
+		return err
+	})
+}
+
+// collectStream decodes the command events written by stream. Every line of output is
+// passed to the output function as it arrives and collected for the returned result, up
+// to the default output limit.
+func (c *Command) collectStream(stream func(events io.Writer) error) (*models.CommandResponse, error) {
+	stdout := output.NewBuffer(0, c.options.OutputRetention)
+	stderr := output.NewBuffer(0, c.options.OutputRetention)
+	var result *models.CommandResponse
+	var failure *models.Error
+
+	events := &eventDecoder{handle: func(ev *models.CommandEvent) {
+		switch {
+		case ev.Result != nil:
+			result = ev.Result
+		case ev.Error != nil:
+			failure = ev.Error
+		case ev.Stream == models.CommandEventStreamStderr:
+			fmt.Fprintln(stderr, ev.Data)
+			c.output(ev.Stream, ev.Data)
+		default:
+			fmt.Fprintln(stdout, ev.Data)
+			c.output(ev.Stream, ev.Data)
+		}
+	}}
+
+	if err := stream(events); err != nil {
+		return nil, err
+	}
+	if failure != nil {
+		return nil, &payloadError{payload: failure}
+	}
+	if result == nil {
+		return nil, fmt.Errorf("command output stream ended without a result")
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.Truncated = stdout.Truncated() || stderr.Truncated()
+	return result, nil
+}
+
+// payloadError is an error reported within a successful response, e.g. by the final
+// event of an output stream or by a failed job. It carries the error payload like the
+// errors of regular API responses.
+type payloadError struct {
+	payload *models.Error
+}
+
+func (e *payloadError) Error() string {
+	return fmt.Sprintf("command error %d: %s", e.payload.Code, e.payload.Message)
+}
+
+func (e *payloadError) GetPayload() *models.Error {
+	return e.payload
+}
+
+// eventDecoder decodes the newline delimited command events written to it
+type eventDecoder struct {
+	handle func(ev *models.CommandEvent)
+	buf    []byte
+}
+
+func (d *eventDecoder) Write(p []byte) (int, error) {
+	d.buf = append(d.buf, p...)
+	for {
+		i := bytes.IndexByte(d.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := d.buf[:i]
+		d.buf = d.buf[i+1:]
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var ev models.CommandEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return 0, fmt.Errorf("invalid command event: %w", err)
+		}
+		d.handle(&ev)
+	}
+}
+
+// firstLine returns the first non-empty line of s without surrounding whitespace
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
 func (c *Command) Backup(ctx context.Context) (bool, error) {
 	return false, nil
 }
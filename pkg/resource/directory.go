@@ -2,8 +2,9 @@ package resource
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"io"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -11,17 +12,34 @@ import (
 	ops_content "peertech.de/axion/api/client/content"
 	ops_directories "peertech.de/axion/api/client/directories"
 	"peertech.de/axion/api/models"
+	"peertech.de/axion/pkg/backup"
 	"peertech.de/axion/pkg/config"
 	"peertech.de/axion/pkg/pointer"
+	"peertech.de/axion/pkg/version"
 )
 
-func NewDirectory(cfg *config.Config, state State, path string, mode, owner, group *string) *Directory {
-	return &Directory{
+func NewDirectory(cfg *config.Config, state State, path string, mode, owner, group *string, opts ...DirectoryOption) *Directory {
+	d := &Directory{
 		cfg:               cfg,
 		desiredState:      state,
 		path:              path,
 		desiredProperties: &directoryProperties{Mode: mode, Owner: owner, Group: group},
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+type DirectoryOption func(d *Directory)
+
+// WithBackupFilter limits the backup of the directory to the entries matching the glob
+// patterns of include, leaving out the ones matching exclude, e.g. caches and logs
+func WithBackupFilter(include, exclude []string) DirectoryOption {
+	return func(d *Directory) {
+		d.backupInclude = include
+		d.backupExclude = exclude
+	}
 }
 
 type directoryProperties struct {
@@ -36,19 +54,51 @@ type Directory struct {
 	desiredState      State
 	path              string
 	desiredProperties *directoryProperties
+	backupInclude     []string
+	backupExclude     []string
 
 	currentState      State
 	currentProperties *models.DirectoryProperties
 	etag              string
+	// agentBackupID is the id of the backup taken on the agent, if any
+	agentBackupID string
+
+	// cached are the properties last fetched, revalidated by their ETag
+	cached *cachedDirectory
 
 	// Track the operation we made
 	lastOperation Operation
 }
 
+type cachedDirectory struct {
+	properties *models.DirectoryProperties
+	etag       string
+}
+
 func (d *Directory) Name() string {
 	return "directory:" + d.path
 }
 
+func (d *Directory) SetAbsent() {
+	d.desiredState = StateAbsent
+}
+
+func (d *Directory) LastOperation() Operation {
+	return d.lastOperation
+}
+
+func (d *Directory) ETag() string {
+	return d.etag
+}
+
+func (d *Directory) Path() string {
+	return d.path
+}
+
+func (d *Directory) IsDir() bool {
+	return true
+}
+
 func (d *Directory) Validate() error {
 	switch d.desiredState {
 	case StateAbsent, StatePresent:
@@ -79,9 +129,16 @@ func (d *Directory) IsConcurrent() bool {
 func (d *Directory) Check(ctx context.Context) (bool, error) {
 	params := ops_directories.NewGetDirectoryPropertiesParamsWithContext(ctx)
 	params.Path = d.path
+	if d.cached != nil {
+		params.IfNoneMatch = pointer.To(d.cached.etag)
+	}
 
 	resp, err := d.cfg.Client.Directories.GetDirectoryProperties(params)
+	if directoryNotModified(err) {
+		resp, err = &ops_directories.GetDirectoryPropertiesOK{Payload: d.cached.properties, ETag: d.cached.etag}, nil
+	}
 	if err != nil {
+		d.cached = nil
 		if directoryNotFound(err) {
 			d.currentState = StateAbsent
 			d.currentProperties = nil
@@ -92,7 +149,7 @@ func (d *Directory) Check(ctx context.Context) (bool, error) {
 			return d.desiredState == StatePresent, nil
 		}
 		if payload := getErrorPayload(err); payload != nil {
-			return false, &APIError{Code: payload.Code, Message: payload.Message}
+			return false, newAPIError(payload)
 		}
 
 		return false, fmt.Errorf("failed to check file")
@@ -105,6 +162,7 @@ func (d *Directory) Check(ctx context.Context) (bool, error) {
 	d.currentState = StatePresent
 	d.currentProperties = resp.Payload
 	d.etag = resp.ETag
+	d.cached = &cachedDirectory{properties: resp.Payload, etag: resp.ETag}
 
 	// Directory exists but should be absent, needs action
 	if d.desiredState == StateAbsent {
@@ -115,6 +173,26 @@ func (d *Directory) Check(ctx context.Context) (bool, error) {
 	return !d.propertiesMatch(), nil
 }
 
+// Unchanged reports whether the directory still has etag, see Revalidator. Properties
+// which changed are cached for Check.
+func (d *Directory) Unchanged(ctx context.Context, etag string) bool {
+	params := ops_directories.NewGetDirectoryPropertiesParamsWithContext(ctx)
+	params.Path = d.path
+	params.IfNoneMatch = pointer.To(etag)
+
+	resp, err := d.cfg.Client.Directories.GetDirectoryProperties(params)
+	if directoryNotModified(err) {
+		d.currentState = StatePresent
+		d.currentProperties = nil
+		d.etag = etag
+		return true
+	}
+	if err == nil && resp.Payload != nil {
+		d.cached = &cachedDirectory{properties: resp.Payload, etag: resp.ETag}
+	}
+	return false
+}
+
 // propertiesMatch checks if current properties match desired properties
 func (d *Directory) propertiesMatch() bool {
 	if d.currentProperties == nil {
@@ -186,7 +264,7 @@ func (d *Directory) Apply(ctx context.Context) error {
 		_, err := d.cfg.Client.Directories.DeleteDirectory(params)
 		if err != nil {
 			if payload := getErrorPayload(err); payload != nil {
-				return &APIError{Code: payload.Code, Message: payload.Message}
+				return newAPIError(payload)
 			}
 
 			return fmt.Errorf("failed to apply file: %w", err)
@@ -219,7 +297,7 @@ func (d *Directory) Apply(ctx context.Context) error {
 	created, noContent, err := d.cfg.Client.Directories.PutDirectory(params)
 	if err != nil {
 		if payload := getErrorPayload(err); payload != nil {
-			return &APIError{Code: payload.Code, Message: payload.Message}
+			return newAPIError(payload)
 		}
 
 		return fmt.Errorf("failed to apply file: %w", err)
@@ -256,32 +334,45 @@ func (d *Directory) Backup(ctx context.Context) (bool, error) {
 }
 
 func (d *Directory) backup(ctx context.Context) (bool, error) {
-	if err := os.MkdirAll(filepath.Dir(d.backupPath()), 0755); err != nil {
-		return false, err
-	}
-
-	fd, err := os.Create(d.backupPath())
-	if err != nil {
-		return false, err
+	if useAgentBackups(d.cfg) {
+		id, err := createAgentBackup(ctx, d.cfg, d.path, d.backupInclude, d.backupExclude)
+		if err != nil {
+			return false, err
+		}
+		d.agentBackupID = id
+		return true, nil
 	}
-	defer fd.Close()
 
-	params := ops_content.NewDownloadParamsWithContext(ctx)
-	params.Path = d.path
-	params.Recursive = pointer.To(true)
+	err := writeBackup(ctx, d.cfg, d.backupPath(), func(w io.Writer, ifNoneMatch *string) (string, bool, error) {
+		params := ops_content.NewDownloadParamsWithContext(ctx)
+		params.Path = d.path
+		params.Recursive = pointer.To(true)
+		// Keep owners, extended attributes and hardlinks for a faithful restore
+		params.Preserve = pointer.To(true)
+		params.Include = d.backupInclude
+		params.Exclude = d.backupExclude
+		if d.cfg.Supports(version.CapabilityZstd) {
+			params.AcceptEncoding = pointer.To("zstd")
+		}
+		params.IfNoneMatch = ifNoneMatch
 
-	_, err = d.cfg.Client.Content.Download(params, fd)
-	if err != nil {
-		// Clean up backup file on error
-		os.Remove(d.backupPath())
+		resp, err := d.cfg.Client.Content.Download(params, w)
+		if err != nil {
+			var notModified *ops_content.DownloadNotModified
+			if errors.As(err, &notModified) {
+				return "", true, nil
+			}
+			if payload := getErrorPayload(err); payload != nil {
+				return "", false, newAPIError(payload)
+			}
 
-		if payload := getErrorPayload(err); payload != nil {
-			return false, &APIError{Code: payload.Code, Message: payload.Message}
+			return "", false, fmt.Errorf("failed to backup directory: %w", err)
 		}
-
-		return false, fmt.Errorf("failed to backup directory: %w", err)
+		return resp.ETag, false, nil
+	})
+	if err != nil {
+		return false, err
 	}
-
 	return true, nil
 }
 
@@ -297,7 +388,7 @@ func (d *Directory) Rollback(ctx context.Context) error {
 		_, err := d.cfg.Client.Directories.DeleteDirectory(params)
 		if err != nil {
 			if payload := getErrorPayload(err); payload != nil {
-				return &APIError{Code: payload.Code, Message: payload.Message}
+				return newAPIError(payload)
 			}
 
 			return fmt.Errorf("failed to delete file: %w", err)
@@ -334,7 +425,7 @@ func (d *Directory) rollbackProperties(ctx context.Context) error {
 	_, _, err := d.cfg.Client.Directories.PutDirectory(params)
 	if err != nil {
 		if payload := getErrorPayload(err); payload != nil {
-			return &APIError{Code: payload.Code, Message: payload.Message}
+			return newAPIError(payload)
 		}
 
 		return fmt.Errorf("failed to put file: %w", err)
@@ -345,35 +436,48 @@ func (d *Directory) rollbackProperties(ctx context.Context) error {
 
 func (d *Directory) backupPath() string {
 	safe := strings.ReplaceAll(strings.TrimPrefix(d.path, "/"), "/", "-")
-	return filepath.Join(d.cfg.BackupDir, safe+"-dir.tar.gz")
+	if d.cfg.Supports(version.CapabilityZstd) {
+		return filepath.Join(d.cfg.BackupDir, safe+"-dir."+archiveFormatZstd)
+	}
+	return filepath.Join(d.cfg.BackupDir, safe+"-dir."+archiveFormatGzip)
 }
 
 func (d *Directory) restoreFromBackup(ctx context.Context) error {
-	// Check if backup file exists
-	if _, err := os.Stat(d.backupPath()); os.IsNotExist(err) {
-		return fmt.Errorf("no backup file found at %s", d.backupPath())
+	if d.agentBackupID != "" {
+		return restoreAgentBackup(ctx, d.cfg, d.agentBackupID)
 	}
 
-	fd, err := os.Open(d.backupPath())
-	if err != nil {
-		return fmt.Errorf("failed to open backup: %w", err)
+	if err := ensureBackup(ctx, d.cfg, d.backupPath()); err != nil {
+		return err
+	}
+	if err := verifyBackup(d.backupPath(), true); err != nil {
+		return err
 	}
-	defer fd.Close()
-
-	params := ops_content.NewUploadParamsWithContext(ctx)
-	params.Path = d.path
-	params.Recursive = pointer.To(true)
-	params.Content = fd
 
-	_, _, err = d.cfg.Client.Content.Upload(params)
-	if err != nil {
-		if payload := getErrorPayload(err); payload != nil {
-			return &APIError{Code: payload.Code, Message: payload.Message}
+	if err := uploadArchive(ctx, d.cfg, d.path, true, true, d.backupPath(), nil); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			return err
 		}
 		return fmt.Errorf("failed to restore directory from backup: %w", err)
 	}
 
-	// TODO: Clean up backup file after successful restore
+	// Restoring changes the modification times the ETag of the backup is derived from,
+	// so the next backup can't reuse it. Its remote copy is kept.
+	if err := backup.Discard(ctx, nil, d.cfg.BackupDir, d.backupPath()); err != nil {
+		return fmt.Errorf("failed to remove restored backup: %w", err)
+	}
 
 	return nil
 }
+
+// DiscardBackup removes the backup taken by the last Backup, see
+// resource.BackupDiscarder
+func (d *Directory) DiscardBackup(ctx context.Context) error {
+	if d.agentBackupID != "" {
+		id := d.agentBackupID
+		d.agentBackupID = ""
+		return deleteAgentBackup(ctx, d.cfg, id)
+	}
+	return discardBackup(ctx, d.cfg, d.backupPath())
+}
@@ -2,13 +2,18 @@ package resource
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
-	ops_content "peertech.de/axion/api/client/content"
 	ops_files "peertech.de/axion/api/client/files"
 	"peertech.de/axion/api/models"
 	"peertech.de/axion/pkg/config"
@@ -40,15 +45,49 @@ type File struct {
 	currentState      State
 	currentProperties *models.FileProperties
 	etag              string
+	// agentBackupID is the id of the backup taken on the agent, if any
+	agentBackupID string
+	// backedUp is set once the content of the file checked last is backed up
+	backedUp bool
+
+	// cached are the properties last fetched, revalidated by their ETag
+	cached *cachedFile
+	// prefetched is the state fetched along with other files, see Prefetch
+	prefetched atomic.Pointer[models.BatchStatResult]
 
 	// Track the operation we made
 	lastOperation Operation
 }
 
+type cachedFile struct {
+	properties *models.FileProperties
+	etag       string
+}
+
 func (f *File) Name() string {
 	return "file:" + f.path
 }
 
+func (f *File) SetAbsent() {
+	f.desiredState = StateAbsent
+}
+
+func (f *File) LastOperation() Operation {
+	return f.lastOperation
+}
+
+func (f *File) ETag() string {
+	return f.etag
+}
+
+func (f *File) Path() string {
+	return f.path
+}
+
+func (f *File) IsDir() bool {
+	return false
+}
+
 func (f *File) Validate() error {
 	switch f.desiredState {
 	case StateAbsent, StatePresent:
@@ -77,22 +116,28 @@ func (f *File) IsConcurrent() bool {
 }
 
 func (f *File) Check(ctx context.Context) (bool, error) {
+	// The state prefetched with other files is only used once, retries query it again
+	if prefetched := f.prefetched.Swap(nil); prefetched != nil {
+		return f.checkPrefetched(prefetched)
+	}
+
 	params := ops_files.NewGetFilePropertiesParamsWithContext(ctx)
 	params.Path = f.path
+	if f.cached != nil {
+		params.IfNoneMatch = pointer.To(f.cached.etag)
+	}
 
 	resp, err := f.cfg.Client.Files.GetFileProperties(params)
+	if fileNotModified(err) {
+		return f.checkState(f.cached.properties, f.cached.etag), nil
+	}
 	if err != nil {
+		f.cached = nil
 		if fileNotFound(err) {
-			f.currentState = StateAbsent
-			f.currentProperties = nil
-			f.etag = ""
-
-			// If desired state is absent, no action needed
-			// If desired state is present, action needed
-			return f.desiredState == StatePresent, nil
+			return f.checkState(nil, ""), nil
 		}
 		if payload := getErrorPayload(err); payload != nil {
-			return false, &APIError{Code: payload.Code, Message: payload.Message}
+			return false, newAPIError(payload)
 		}
 
 		return false, fmt.Errorf("failed to check file")
@@ -102,17 +147,90 @@ func (f *File) Check(ctx context.Context) (bool, error) {
 		return false, fmt.Errorf("received empty payload")
 	}
 
+	f.cached = &cachedFile{properties: resp.Payload, etag: resp.ETag}
+	return f.checkState(resp.Payload, resp.ETag), nil
+}
+
+// Unchanged reports whether the file still has etag, see Revalidator. The prefetched
+// state is used if available, otherwise the properties are requested conditionally;
+// properties which changed are cached for Check.
+func (f *File) Unchanged(ctx context.Context, etag string) bool {
+	if prefetched := f.prefetched.Load(); prefetched != nil {
+		if prefetched.Error != nil || prefetched.Etag != etag {
+			return false
+		}
+		f.prefetched.Store(nil)
+		f.revalidated(etag)
+		return true
+	}
+
+	params := ops_files.NewGetFilePropertiesParamsWithContext(ctx)
+	params.Path = f.path
+	params.IfNoneMatch = pointer.To(etag)
+
+	resp, err := f.cfg.Client.Files.GetFileProperties(params)
+	if fileNotModified(err) {
+		f.revalidated(etag)
+		return true
+	}
+	if err == nil && resp.Payload != nil {
+		f.cached = &cachedFile{properties: resp.Payload, etag: resp.ETag}
+	}
+	return false
+}
+
+// revalidated records that the file still has etag, its properties aren't known
+func (f *File) revalidated(etag string) {
+	f.currentState = StatePresent
+	f.currentProperties = nil
+	f.etag = etag
+	f.backedUp = false
+	f.agentBackupID = ""
+}
+
+// checkPrefetched checks the file against its state from a batch stat
+func (f *File) checkPrefetched(result *models.BatchStatResult) (bool, error) {
+	if result.Error != nil {
+		if result.Error.Code == http.StatusNotFound {
+			return f.checkState(nil, ""), nil
+		}
+		return false, newAPIError(result.Error)
+	}
+	if result.Properties == nil {
+		return false, fmt.Errorf("received empty payload")
+	}
+	f.cached = &cachedFile{properties: result.Properties, etag: result.Etag}
+	return f.checkState(result.Properties, result.Etag), nil
+}
+
+// checkState records the current state of the file, nil properties if it doesn't
+// exist, and reports whether it needs to be applied
+func (f *File) checkState(props *models.FileProperties, etag string) bool {
+	// Backups of a previous state don't apply anymore
+	f.backedUp = false
+	f.agentBackupID = ""
+
+	if props == nil {
+		f.currentState = StateAbsent
+		f.currentProperties = nil
+		f.etag = ""
+
+		// If desired state is absent, no action needed
+		// If desired state is present, action needed
+		return f.desiredState == StatePresent
+	}
+
 	f.currentState = StatePresent
-	f.currentProperties = resp.Payload
-	f.etag = resp.ETag
+	f.currentProperties = props
+	f.etag = etag
 
 	// File exists but should be absent, needs action
 	if f.desiredState == StateAbsent {
-		return true, nil
+		return true
 	}
 
 	// Check if all desired properties match current properties
-	return !f.propertiesMatch(), nil
+	return !f.propertiesMatch()
 }
 
 // propertiesMatch checks if current properties match desired properties
@@ -186,7 +304,7 @@ func (f *File) Apply(ctx context.Context) error {
 		_, err := f.cfg.Client.Files.DeleteFile(params)
 		if err != nil {
 			if payload := getErrorPayload(err); payload != nil {
-				return &APIError{Code: payload.Code, Message: payload.Message}
+				return newAPIError(payload)
 			}
 
 			return fmt.Errorf("failed to apply file: %w", err)
@@ -219,7 +337,7 @@ func (f *File) Apply(ctx context.Context) error {
 	created, noContent, err := f.cfg.Client.Files.PutFile(params)
 	if err != nil {
 		if payload := getErrorPayload(err); payload != nil {
-			return &APIError{Code: payload.Code, Message: payload.Message}
+			return newAPIError(payload)
 		}
 
 		return fmt.Errorf("failed to apply file: %w", err)
@@ -245,43 +363,51 @@ func (f *File) Backup(ctx context.Context) (bool, error) {
 		return false, nil
 	}
 
-	// If desired state is absent, backup content for full restore
-	if f.desiredState == StateAbsent {
-		return f.backup(ctx)
-	}
-
-	// If desired state is present and properties are changing, backup current properties
-	// (f.currentProperties is already stored).
-	return false, nil
+	// Backup the content whether the file is deleted or its properties change, so a
+	// rollback restores it byte for byte along with its properties (f.currentProperties
+	// is already stored)
+	return f.backup(ctx)
 }
 
 func (f *File) backup(ctx context.Context) (bool, error) {
-	if err := os.MkdirAll(filepath.Dir(f.backupPath()), 0755); err != nil {
-		return false, err
-	}
-
-	fd, err := os.Create(f.backupPath())
-	if err != nil {
-		return false, err
+	if useAgentBackups(f.cfg) {
+		id, err := createAgentBackup(ctx, f.cfg, f.path, nil, nil)
+		if err != nil {
+			return false, err
+		}
+		f.agentBackupID = id
+		f.backedUp = true
+		return true, nil
 	}
-	defer fd.Close()
 
-	params := ops_content.NewDownloadParamsWithContext(ctx)
-	params.Path = f.path
-	params.Recursive = pointer.To(false)
+	err := writeBackup(ctx, f.cfg, f.backupPath(), func(w io.Writer, ifNoneMatch *string) (string, bool, error) {
+		params := ops_files.NewGetFileContentParamsWithContext(ctx)
+		params.Path = f.path
+		params.IfNoneMatch = ifNoneMatch
 
-	_, err = f.cfg.Client.Content.Download(params, fd)
-	if err != nil {
-		// Clean up backup file on error
-		os.Remove(f.backupPath())
+		hasher := sha256.New()
+		resp, err := f.cfg.Client.Files.GetFileContent(params, io.MultiWriter(w, hasher))
+		if err != nil {
+			var notModified *ops_files.GetFileContentNotModified
+			if errors.As(err, &notModified) {
+				return "", true, nil
+			}
+			if payload := getErrorPayload(err); payload != nil {
+				return "", false, newAPIError(payload)
+			}
 
-		if payload := getErrorPayload(err); payload != nil {
-			return false, &APIError{Code: payload.Code, Message: payload.Message}
+			return "", false, fmt.Errorf("failed to backup file: %w", err)
 		}
 
-		return false, fmt.Errorf("failed to backup file: %w", err)
+		if resp.ContentSha256 != "" && resp.ContentSha256 != hex.EncodeToString(hasher.Sum(nil)) {
+			return "", false, fmt.Errorf("failed to backup file: checksum mismatch")
+		}
+		return resp.ETag, false, nil
+	})
+	if err != nil {
+		return false, err
 	}
-
+	f.backedUp = true
 	return true, nil
 }
 
@@ -297,13 +423,16 @@ func (f *File) Rollback(ctx context.Context) error {
 		_, err := f.cfg.Client.Files.DeleteFile(params)
 		if err != nil {
 			if payload := getErrorPayload(err); payload != nil {
-				return &APIError{Code: payload.Code, Message: payload.Message}
+				return newAPIError(payload)
 			}
 
 			return fmt.Errorf("failed to delete file: %w", err)
 		}
 		return err
 	case OperationUpdate:
+		if f.backedUp {
+			return f.restoreFromBackup(ctx)
+		}
 		return f.rollbackProperties(ctx)
 	case OperationDelete:
 		return f.restoreFromBackup(ctx)
@@ -331,27 +460,45 @@ func (f *File) rollbackProperties(ctx context.Context) error {
 		params.SetIfMatch(pointer.To(f.etag))
 	}
 
-	_, _, err := f.cfg.Client.Files.PutFile(params)
+	created, noContent, err := f.cfg.Client.Files.PutFile(params)
 	if err != nil {
 		if payload := getErrorPayload(err); payload != nil {
-			return &APIError{Code: payload.Code, Message: payload.Message}
+			return newAPIError(payload)
 		}
 
 		return fmt.Errorf("failed to put file: %w", err)
 	}
 
+	switch {
+	case created != nil:
+		f.etag = created.ETag
+	case noContent != nil:
+		f.etag = noContent.ETag
+	}
+
 	return nil
 }
 
 func (f *File) backupPath() string {
 	safe := strings.ReplaceAll(strings.TrimPrefix(f.path, "/"), "/", "-")
-	return filepath.Join(f.cfg.BackupDir, safe+".tar.gz")
+	return filepath.Join(f.cfg.BackupDir, safe+".bak")
 }
 
 func (f *File) restoreFromBackup(ctx context.Context) error {
-	// Check if backup file exists
-	if _, err := os.Stat(f.backupPath()); os.IsNotExist(err) {
-		return fmt.Errorf("no backup file found at %s", f.backupPath())
+	if f.agentBackupID != "" {
+		return restoreAgentBackup(ctx, f.cfg, f.agentBackupID)
+	}
+
+	if err := ensureBackup(ctx, f.cfg, f.backupPath()); err != nil {
+		return err
+	}
+	if err := verifyBackup(f.backupPath(), false); err != nil {
+		return err
+	}
+
+	checksum, err := fileChecksum(f.backupPath())
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
 	}
 
 	fd, err := os.Open(f.backupPath())
@@ -360,20 +507,62 @@ func (f *File) restoreFromBackup(ctx context.Context) error {
 	}
 	defer fd.Close()
 
-	params := ops_content.NewUploadParamsWithContext(ctx)
+	params := ops_files.NewPutFileContentParamsWithContext(ctx)
 	params.Path = f.path
-	params.Recursive = pointer.To(false)
 	params.Content = fd
+	params.ContentSha256 = pointer.To(checksum)
 
-	_, _, err = f.cfg.Client.Content.Upload(params)
+	created, noContent, err := f.cfg.Client.Files.PutFileContent(params)
 	if err != nil {
 		if payload := getErrorPayload(err); payload != nil {
-			return &APIError{Code: payload.Code, Message: payload.Message}
+			return newAPIError(payload)
 		}
 		return fmt.Errorf("failed to restore file from backup: %w", err)
 	}
 
-	// TODO: Clean up backup file after successful restore
+	switch {
+	case created != nil:
+		f.etag = created.ETag
+	case noContent != nil:
+		f.etag = noContent.ETag
+	}
+
+	// The content is restored into a new file or replaces the updated one, restore its
+	// properties as well
+	if err := f.rollbackProperties(ctx); err != nil {
+		return err
+	}
+
+	// The file is back in the state it was backed up in, the backup is reused by the
+	// next run unless the file changes in the meantime
+	if err := reuseBackup(f.backupPath(), f.etag); err != nil {
+		return fmt.Errorf("failed to update backup: %w", err)
+	}
 
 	return nil
 }
+
+// DiscardBackup removes the backup taken by the last Backup, see
+// resource.BackupDiscarder
+func (f *File) DiscardBackup(ctx context.Context) error {
+	if f.agentBackupID != "" {
+		id := f.agentBackupID
+		f.agentBackupID = ""
+		return deleteAgentBackup(ctx, f.cfg, id)
+	}
+	return discardBackup(ctx, f.cfg, f.backupPath())
+}
+
+func fileChecksum(path string) (string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, fd); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,180 @@
+package resource
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	ops_content "peertech.de/axion/api/client/content"
+	ops_directories "peertech.de/axion/api/client/directories"
+	ops_files "peertech.de/axion/api/client/files"
+	ops_symlinks "peertech.de/axion/api/client/symlinks"
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/pointer"
+	"peertech.de/axion/pkg/version"
+)
+
+// Observed is the current state of a path on the agent
+type Observed struct {
+	Path string
+	// Type is the resource type managing the path: file, directory or symlink
+	Type   string
+	Mode   string
+	Owner  string
+	Group  string
+	Target string // symlinks only
+}
+
+// Inspect returns the current state of the file, directory or symlink at p
+func Inspect(ctx context.Context, cfg *config.Config, p string) (*Observed, error) {
+	linkParams := ops_symlinks.NewGetSymlinkPropertiesParamsWithContext(ctx)
+	linkParams.Path = p
+	link, err := cfg.Client.Symlinks.GetSymlinkProperties(linkParams)
+	switch {
+	case err == nil:
+		if link.Payload == nil {
+			return nil, fmt.Errorf("received empty payload")
+		}
+		return &Observed{Path: p, Type: "symlink", Target: link.Payload.Target}, nil
+	case symlinkNotFound(err):
+		return nil, fmt.Errorf("%s not found on the agent", p)
+	case !notSymlink(err):
+		return nil, inspectError(p, err)
+	}
+
+	dirParams := ops_directories.NewGetDirectoryPropertiesParamsWithContext(ctx)
+	dirParams.Path = p
+	dir, err := cfg.Client.Directories.GetDirectoryProperties(dirParams)
+	if err == nil {
+		if dir.Payload == nil {
+			return nil, fmt.Errorf("received empty payload")
+		}
+		props := dir.Payload
+		return &Observed{Path: p, Type: "directory", Mode: props.Mode, Owner: props.Owner, Group: props.Group}, nil
+	}
+	var badRequest *ops_directories.GetDirectoryPropertiesBadRequest
+	if !errors.As(err, &badRequest) {
+		return nil, inspectError(p, err)
+	}
+
+	// The path exists but is neither a symlink nor a directory
+	fileParams := ops_files.NewGetFilePropertiesParamsWithContext(ctx)
+	fileParams.Path = p
+	file, err := cfg.Client.Files.GetFileProperties(fileParams)
+	if err != nil {
+		return nil, inspectError(p, err)
+	}
+	if file.Payload == nil {
+		return nil, fmt.Errorf("received empty payload")
+	}
+	props := file.Payload
+	return &Observed{Path: p, Type: "file", Mode: props.Mode, Owner: props.Owner, Group: props.Group}, nil
+}
+
+func inspectError(p string, err error) error {
+	if payload := getErrorPayload(err); payload != nil {
+		return newAPIError(payload)
+	}
+	return fmt.Errorf("failed to inspect %s: %w", p, err)
+}
+
+// Glob returns the paths on the agent matching pattern, see path.Match, in lexical
+// order. The agent has no listing, the directory the pattern starts with is downloaded
+// to find the matches, including the content of its files.
+func Glob(ctx context.Context, cfg *config.Config, pattern string) ([]string, error) {
+	if !path.IsAbs(pattern) {
+		return nil, fmt.Errorf("pattern %q is not absolute", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	// The longest leading directory without meta characters
+	base := pattern
+	for strings.ContainsAny(base, `*?[\`) {
+		base = path.Dir(base)
+	}
+
+	var matches []string
+	err := walkRemote(ctx, cfg, base, true, func(header *tar.Header) error {
+		p := path.Join(base, header.Name)
+		if ok, _ := path.Match(pattern, p); ok && !slices.Contains(matches, p) {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(matches)
+	return matches, nil
+}
+
+// Size returns the size of the content of the file, or of all files below the
+// directory, at p. The agent doesn't report sizes, the content is downloaded.
+func Size(ctx context.Context, cfg *config.Config, p string, isDir bool) (int64, error) {
+	var size int64
+	err := walkRemote(ctx, cfg, p, isDir, func(header *tar.Header) error {
+		if header.Typeflag == tar.TypeReg {
+			size += header.Size
+		}
+		return nil
+	})
+	return size, err
+}
+
+// walkRemote downloads the archive of the file or directory at p and calls fn for each
+// of its entries
+func walkRemote(ctx context.Context, cfg *config.Config, p string, isDir bool, fn func(header *tar.Header) error) error {
+	tmp, err := os.CreateTemp("", "axion-walk-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	params := ops_content.NewDownloadParamsWithContext(ctx)
+	params.Path = p
+	params.Recursive = pointer.To(isDir)
+	if cfg.Supports(version.CapabilityZstd) {
+		params.AcceptEncoding = pointer.To("zstd")
+	}
+	if _, err := cfg.Client.Content.Download(params, tmp); err != nil {
+		if payload := getErrorPayload(err); payload != nil {
+			return newAPIError(payload)
+		}
+		return fmt.Errorf("failed to download %s: %w", p, err)
+	}
+
+	format, err := archiveFormat(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	tr, closeArchive, err := openArchive(tmp, format)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer closeArchive()
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		if err := fn(header); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	ops_packages "peertech.de/axion/api/client/packages"
+	"peertech.de/axion/api/models"
+	"peertech.de/axion/pkg/config"
+)
+
+// NewPackage returns a package installed through the package manager of the host. An
+// empty version accepts any installed version, pinned is left unmanaged if nil.
+func NewPackage(cfg *config.Config, state State, name, version string, pinned *bool) *Package {
+	return &Package{
+		cfg:            cfg,
+		desiredState:   state,
+		name:           name,
+		desiredVersion: version,
+		desiredPinned:  pinned,
+	}
+}
+
+type Package struct {
+	cfg *config.Config
+
+	desiredState   State
+	name           string
+	desiredVersion string
+	desiredPinned  *bool
+
+	currentState   State
+	currentVersion string
+	currentPinned  bool
+
+	// Track the operation we made
+	lastOperation Operation
+}
+
+func (p *Package) Name() string {
+	return "package:" + p.name
+}
+
+func (p *Package) SetAbsent() {
+	p.desiredState = StateAbsent
+	p.desiredVersion = ""
+	p.desiredPinned = nil
+}
+
+func (p *Package) LastOperation() Operation {
+	return p.lastOperation
+}
+
+func (p *Package) Validate() error {
+	switch p.desiredState {
+	case StateAbsent, StatePresent:
+	default:
+		return fmt.Errorf("invalid desired state for package: %q", p.desiredState)
+	}
+
+	if p.name == "" {
+		return fmt.Errorf("package name cannot be empty")
+	}
+
+	if p.desiredState == StateAbsent && (p.desiredVersion != "" || p.desiredPinned != nil) {
+		return fmt.Errorf("version and pinned are not supported for absent packages")
+	}
+
+	return nil
+}
+
+// IsConcurrent reports false as package managers hold a global lock
+func (p *Package) IsConcurrent() bool {
+	return false
+}
+
+func (p *Package) Check(ctx context.Context) (bool, error) {
+	params := ops_packages.NewGetPackageParamsWithContext(ctx)
+	params.Name = p.name
+
+	resp, err := p.cfg.Client.Packages.GetPackage(params)
+	if err != nil {
+		if packageNotFound(err) {
+			p.currentState = StateAbsent
+			p.currentVersion = ""
+			p.currentPinned = false
+
+			return p.desiredState == StatePresent, nil
+		}
+		if payload := getErrorPayload(err); payload != nil {
+			return false, newAPIError(payload)
+		}
+
+		return false, fmt.Errorf("failed to check package")
+	}
+
+	if resp.Payload == nil {
+		return false, fmt.Errorf("received empty payload")
+	}
+
+	p.currentState = StatePresent
+	p.currentVersion = resp.Payload.Version
+	p.currentPinned = resp.Payload.Pinned
+
+	// Package is installed but should be absent, needs action
+	if p.desiredState == StateAbsent {
+		return true, nil
+	}
+
+	return !p.versionMatches() || !p.pinnedMatches(), nil
+}
+
+func (p *Package) Diff(ctx context.Context) (string, error) {
+	switch {
+	case p.desiredState == StateAbsent && p.currentState == StatePresent:
+		return fmt.Sprintf("diff -- package: %s\n- present (version %s will be removed)\n", p.name, p.currentVersion), nil
+	case p.desiredState == StatePresent && p.currentState == StateAbsent:
+		version := p.desiredVersion
+		if version == "" {
+			version = "latest"
+		}
+		return fmt.Sprintf("diff -- package: %s\n+ present (version %s will be installed)\n", p.name, version), nil
+	}
+
+	var sb strings.Builder
+	if !p.versionMatches() {
+		fmt.Fprintf(&sb, "- version: %s\n+ version: %s\n", p.currentVersion, p.desiredVersion)
+	}
+	if !p.pinnedMatches() {
+		fmt.Fprintf(&sb, "- pinned: %t\n+ pinned: %t\n", p.currentPinned, *p.desiredPinned)
+	}
+	if sb.Len() == 0 {
+		return "", nil
+	}
+
+	return fmt.Sprintf("diff -- package: %s\n%s", p.name, sb.String()), nil
+}
+
+func (p *Package) Apply(ctx context.Context) error {
+	p.lastOperation = OperationNone
+
+	if p.desiredState == StateAbsent {
+		if p.currentState == p.desiredState {
+			return nil
+		}
+
+		if err := p.delete(ctx); err != nil {
+			return err
+		}
+
+		p.lastOperation = OperationDelete
+		return nil
+	}
+
+	if err := p.put(ctx, p.desiredVersion, p.desiredPinned); err != nil {
+		return err
+	}
+
+	if p.currentState == StateAbsent {
+		p.lastOperation = OperationCreate
+	} else {
+		p.lastOperation = OperationUpdate
+	}
+
+	return nil
+}
+
+// Rollback removes an installed package or reinstalls the previous version. The
+// previous version may no longer be available from the repositories, so rolling back
+// an update or removal is best effort.
+func (p *Package) Rollback(ctx context.Context) error {
+	switch p.lastOperation {
+	case OperationNone:
+		return nil
+	case OperationCreate:
+		return p.delete(ctx)
+	case OperationUpdate, OperationDelete:
+		pinned := p.currentPinned
+		return p.put(ctx, p.currentVersion, &pinned)
+	}
+
+	return nil
+}
+
+// versionMatches reports whether the installed version satisfies the desired one,
+// which may omit the package release (e.g. "1.2.3" matches "1.2.3-1ubuntu1")
+func (p *Package) versionMatches() bool {
+	return p.desiredVersion == "" ||
+		p.currentVersion == p.desiredVersion ||
+		strings.HasPrefix(p.currentVersion, p.desiredVersion+"-")
+}
+
+func (p *Package) pinnedMatches() bool {
+	return p.desiredPinned == nil || *p.desiredPinned == p.currentPinned
+}
+
+func (p *Package) put(ctx context.Context, version string, pinned *bool) error {
+	params := ops_packages.NewPutPackageParamsWithContext(ctx)
+	params.Name = p.name
+	params.Package = &models.PackageRequest{Version: version, Pinned: pinned}
+
+	_, err := p.cfg.Client.Packages.PutPackage(params)
+	if err != nil {
+		if payload := getErrorPayload(err); payload != nil {
+			return newAPIError(payload)
+		}
+
+		return fmt.Errorf("failed to put package: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Package) delete(ctx context.Context) error {
+	params := ops_packages.NewDeletePackageParamsWithContext(ctx)
+	params.Name = p.name
+
+	_, err := p.cfg.Client.Packages.DeletePackage(params)
+	if err != nil {
+		if payload := getErrorPayload(err); payload != nil {
+			return newAPIError(payload)
+		}
+
+		return fmt.Errorf("failed to delete package: %w", err)
+	}
+
+	return nil
+}
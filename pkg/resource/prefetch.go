@@ -0,0 +1,54 @@
+package resource
+
+import (
+	"context"
+
+	ops_files "peertech.de/axion/api/client/files"
+	"peertech.de/axion/api/models"
+	"peertech.de/axion/pkg/version"
+)
+
+// maxBatchStatPaths is the number of files the agent stats in one request
+const maxBatchStatPaths = 1000
+
+// Prefetch fetches the current state of the file resources among resources in batches,
+// saving a round trip per file. The next Check of each file uses the fetched state.
+// Prefetching is an optimization only, files whose state couldn't be fetched are
+// queried by Check as usual.
+func Prefetch(ctx context.Context, resources []Resource) {
+	var files []*File
+	for _, r := range resources {
+		if f, ok := r.(*File); ok && f.cfg.Supports(version.CapabilityBatchStat) {
+			files = append(files, f)
+		}
+	}
+
+	for len(files) > 0 {
+		batch := files[:min(len(files), maxBatchStatPaths)]
+		files = files[len(batch):]
+
+		params := ops_files.NewBatchStatFilesParamsWithContext(ctx)
+		params.Request = &models.BatchStatRequest{Paths: make([]string, len(batch))}
+		for i, f := range batch {
+			params.Request.Paths[i] = f.path
+		}
+
+		resp, err := batch[0].cfg.Client.Files.BatchStatFiles(params)
+		if err != nil || resp.Payload == nil || len(resp.Payload.Results) != len(batch) {
+			return
+		}
+		for i, f := range batch {
+			f.prefetched.Store(resp.Payload.Results[i])
+		}
+	}
+}
+
+// DiscardPrefetched drops the prefetched states not used yet, once changes made to the
+// system may have outdated them
+func DiscardPrefetched(resources []Resource) {
+	for _, r := range resources {
+		if f, ok := r.(*File); ok {
+			f.prefetched.Store(nil)
+		}
+	}
+}
@@ -24,6 +24,19 @@ const (
 	OperationDelete
 )
 
+func (o Operation) String() string {
+	switch o {
+	case OperationCreate:
+		return "create"
+	case OperationUpdate:
+		return "update"
+	case OperationDelete:
+		return "delete"
+	default:
+		return "none"
+	}
+}
+
 // Resource defines the core interface for all manageable resources in the system.
 //
 // Implementations must be safe for concurrent use if IsConcurrent returns true.
@@ -85,6 +98,57 @@ type Validatable interface {
 	Validate() error
 }
 
+// PathManager is implemented by resources managing a file system path. It allows the
+// orchestrator to infer dependencies, e.g. of a file on its parent directory.
+type PathManager interface {
+	// Path returns the managed path
+	Path() string
+
+	// IsDir reports whether the managed path is a directory
+	IsDir() bool
+}
+
+// Removable is implemented by resources which can be removed from the system. Destroying
+// a manifest sets their desired state to absent, their diff shows what is deleted.
+type Removable interface {
+	// SetAbsent changes the desired state to absent
+	SetAbsent()
+}
+
+// Tracked is implemented by resources reporting what their last Apply did, so that
+// the client can keep track of the state it believes to manage
+type Tracked interface {
+	// LastOperation returns the operation performed by the last Apply
+	LastOperation() Operation
+}
+
+// Versioned is implemented by resources whose state on the agent is identified by an
+// ETag
+type Versioned interface {
+	// ETag returns the ETag of the state last fetched or applied, empty if the resource
+	// doesn't exist
+	ETag() string
+}
+
+// Revalidator is implemented by versioned resources which can tell whether their state
+// on the agent still has an ETag more cheaply than checking it, e.g. with a conditional
+// request
+type Revalidator interface {
+	// Unchanged reports whether the state of the resource on the agent still has etag.
+	// If so, ETag returns it, the resource isn't checked. Errors are left to Check.
+	Unchanged(ctx context.Context, etag string) bool
+}
+
+// OutputFunc receives a line of output written to stream, e.g. "stdout" or "stderr"
+type OutputFunc func(stream, line string)
+
+// OutputStreamer is implemented by resources producing output while they are applied,
+// e.g. commands. Once an OutputFunc is set, the output is relayed to it as it is
+// produced instead of being returned at the end.
+type OutputStreamer interface {
+	SetOutput(fn OutputFunc)
+}
+
 // Backupable extends Resource with backup capabilities. Resources implementing this
 // interface can create backups of their current state before making changes, enabling
 // more reliable rollbacks.
@@ -106,3 +170,10 @@ type Backupable interface {
 	// that support backup/restore functionality.
 	Backup(ctx context.Context) (bool, error)
 }
+
+// BackupDiscarder is implemented by Backupable resources whose backups can be removed
+// once they are no longer needed, e.g. after all changes of a run were applied
+type BackupDiscarder interface {
+	// DiscardBackup removes the backup taken by the last Backup
+	DiscardBackup(ctx context.Context) error
+}
@@ -0,0 +1,191 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	ops_services "peertech.de/axion/api/client/services"
+	"peertech.de/axion/pkg/config"
+)
+
+// NewService returns a systemd service. Running and enabled are left unmanaged if nil.
+func NewService(cfg *config.Config, name string, running, enabled *bool) *Service {
+	return &Service{
+		cfg:            cfg,
+		name:           name,
+		desiredRunning: running,
+		desiredEnabled: enabled,
+	}
+}
+
+type Service struct {
+	cfg *config.Config
+
+	name           string
+	desiredRunning *bool
+	desiredEnabled *bool
+
+	currentRunning bool
+	currentEnabled bool
+	unitFileState  string
+
+	// Track the actions we performed, so rollback can revert them
+	changedRunning bool
+	changedEnabled bool
+}
+
+func (s *Service) Name() string {
+	return "service:" + s.name
+}
+
+func (s *Service) Validate() error {
+	if s.name == "" {
+		return fmt.Errorf("service name cannot be empty")
+	}
+
+	if s.desiredRunning == nil && s.desiredEnabled == nil {
+		return fmt.Errorf("service requires running or enabled to be set")
+	}
+
+	return nil
+}
+
+func (s *Service) IsConcurrent() bool {
+	return true
+}
+
+func (s *Service) Check(ctx context.Context) (bool, error) {
+	params := ops_services.NewGetServiceParamsWithContext(ctx)
+	params.Name = s.name
+
+	resp, err := s.cfg.Client.Services.GetService(params)
+	if err != nil {
+		if serviceNotFound(err) {
+			return false, fmt.Errorf("service %s not found", s.name)
+		}
+		if payload := getErrorPayload(err); payload != nil {
+			return false, newAPIError(payload)
+		}
+
+		return false, fmt.Errorf("failed to check service")
+	}
+
+	if resp.Payload == nil {
+		return false, fmt.Errorf("received empty payload")
+	}
+
+	s.currentRunning = resp.Payload.Active
+	s.currentEnabled = resp.Payload.Enabled
+	s.unitFileState = resp.Payload.UnitFileState
+
+	if s.desiredEnabled != nil && !s.canEnable() {
+		return false, fmt.Errorf("service %s can't be enabled or disabled (unit file state %s)", s.name, s.unitFileState)
+	}
+
+	return !s.runningMatches() || !s.enabledMatches(), nil
+}
+
+func (s *Service) Diff(ctx context.Context) (string, error) {
+	var sb strings.Builder
+	if !s.runningMatches() {
+		fmt.Fprintf(&sb, "- running: %t\n+ running: %t\n", s.currentRunning, *s.desiredRunning)
+	}
+	if !s.enabledMatches() {
+		fmt.Fprintf(&sb, "- enabled: %t\n+ enabled: %t\n", s.currentEnabled, *s.desiredEnabled)
+	}
+	if sb.Len() == 0 {
+		return "", nil
+	}
+
+	return fmt.Sprintf("diff -- service: %s\n%s", s.name, sb.String()), nil
+}
+
+func (s *Service) Apply(ctx context.Context) error {
+	s.changedRunning = false
+	s.changedEnabled = false
+
+	if !s.enabledMatches() {
+		if err := s.action(ctx, enableAction(*s.desiredEnabled)); err != nil {
+			return err
+		}
+		s.changedEnabled = true
+	}
+
+	if !s.runningMatches() {
+		if err := s.action(ctx, startAction(*s.desiredRunning)); err != nil {
+			return err
+		}
+		s.changedRunning = true
+	}
+
+	return nil
+}
+
+// Rollback reverts the start/stop and enable/disable actions of the last Apply
+func (s *Service) Rollback(ctx context.Context) error {
+	if s.changedRunning {
+		if err := s.action(ctx, startAction(s.currentRunning)); err != nil {
+			return err
+		}
+		s.changedRunning = false
+	}
+
+	if s.changedEnabled {
+		if err := s.action(ctx, enableAction(s.currentEnabled)); err != nil {
+			return err
+		}
+		s.changedEnabled = false
+	}
+
+	return nil
+}
+
+func (s *Service) runningMatches() bool {
+	return s.desiredRunning == nil || *s.desiredRunning == s.currentRunning
+}
+
+func (s *Service) enabledMatches() bool {
+	return s.desiredEnabled == nil || *s.desiredEnabled == s.currentEnabled
+}
+
+// canEnable reports whether the unit file can be enabled or disabled, which isn't the
+// case e.g. for units without an [Install] section
+func (s *Service) canEnable() bool {
+	switch s.unitFileState {
+	case "static", "generated", "transient", "indirect":
+		return false
+	}
+	return true
+}
+
+func (s *Service) action(ctx context.Context, action string) error {
+	params := ops_services.NewServiceActionParamsWithContext(ctx)
+	params.Name = s.name
+	params.Action = action
+
+	_, err := s.cfg.Client.Services.ServiceAction(params)
+	if err != nil {
+		if payload := getErrorPayload(err); payload != nil {
+			return newAPIError(payload)
+		}
+
+		return fmt.Errorf("failed to %s service: %w", action, err)
+	}
+
+	return nil
+}
+
+func startAction(running bool) string {
+	if running {
+		return "start"
+	}
+	return "stop"
+}
+
+func enableAction(enabled bool) string {
+	if enabled {
+		return "enable"
+	}
+	return "disable"
+}
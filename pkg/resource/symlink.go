@@ -0,0 +1,257 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+
+	ops_symlinks "peertech.de/axion/api/client/symlinks"
+	"peertech.de/axion/api/models"
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/pointer"
+)
+
+// NewSymlink returns a symlink at path pointing to target. With force a file or empty
+// directory existing at path is replaced by the symlink.
+func NewSymlink(cfg *config.Config, state State, path, target string, force bool) *Symlink {
+	return &Symlink{
+		cfg:           cfg,
+		desiredState:  state,
+		path:          path,
+		desiredTarget: target,
+		force:         force,
+	}
+}
+
+type Symlink struct {
+	cfg *config.Config
+
+	desiredState  State
+	path          string
+	desiredTarget string
+	force         bool
+
+	currentState  State
+	currentTarget string
+	etag          string
+
+	// occupied is set if something other than a symlink exists at the path
+	occupied bool
+
+	// Track the operation we made
+	lastOperation Operation
+}
+
+func (s *Symlink) Name() string {
+	return "symlink:" + s.path
+}
+
+func (s *Symlink) SetAbsent() {
+	s.desiredState = StateAbsent
+}
+
+func (s *Symlink) LastOperation() Operation {
+	return s.lastOperation
+}
+
+func (s *Symlink) ETag() string {
+	return s.etag
+}
+
+func (s *Symlink) Path() string {
+	return s.path
+}
+
+func (s *Symlink) IsDir() bool {
+	return false
+}
+
+func (s *Symlink) Validate() error {
+	switch s.desiredState {
+	case StateAbsent, StatePresent:
+	default:
+		return fmt.Errorf("invalid desired state for symlink: %q", s.desiredState)
+	}
+
+	if s.path == "" {
+		return fmt.Errorf("symlink path cannot be empty")
+	}
+
+	if s.desiredState == StatePresent && s.desiredTarget == "" {
+		return fmt.Errorf("symlink target cannot be empty")
+	}
+
+	return nil
+}
+
+func (s *Symlink) IsConcurrent() bool {
+	return true
+}
+
+func (s *Symlink) Check(ctx context.Context) (bool, error) {
+	params := ops_symlinks.NewGetSymlinkPropertiesParamsWithContext(ctx)
+	params.Path = s.path
+
+	s.occupied = false
+
+	resp, err := s.cfg.Client.Symlinks.GetSymlinkProperties(params)
+	if err != nil {
+		if symlinkNotFound(err) {
+			s.currentState = StateAbsent
+			s.currentTarget = ""
+			s.etag = ""
+
+			return s.desiredState == StatePresent, nil
+		}
+		if notSymlink(err) {
+			// Something else exists at the path, which is not our business unless the
+			// symlink should replace it
+			s.currentState = StateAbsent
+			s.currentTarget = ""
+			s.etag = ""
+			s.occupied = true
+
+			if s.desiredState == StateAbsent {
+				return false, nil
+			}
+			if !s.force {
+				return false, fmt.Errorf("path %s exists and is not a symlink, set force to replace it", s.path)
+			}
+			return true, nil
+		}
+		if payload := getErrorPayload(err); payload != nil {
+			return false, newAPIError(payload)
+		}
+
+		return false, fmt.Errorf("failed to check symlink")
+	}
+
+	if resp.Payload == nil {
+		return false, fmt.Errorf("received empty payload")
+	}
+
+	s.currentState = StatePresent
+	s.currentTarget = resp.Payload.Target
+	s.etag = resp.ETag
+
+	// Symlink exists but should be absent, needs action
+	if s.desiredState == StateAbsent {
+		return true, nil
+	}
+
+	return s.currentTarget != s.desiredTarget, nil
+}
+
+func (s *Symlink) Diff(ctx context.Context) (string, error) {
+	switch {
+	case s.desiredState == StateAbsent && s.currentState == StatePresent:
+		return fmt.Sprintf("diff -- symlink: %s\n- present (symlink will be deleted)\n", s.path), nil
+	case s.desiredState == StatePresent && s.occupied:
+		return fmt.Sprintf("diff -- symlink: %s\n+ present (existing path will be replaced by symlink to %q)\n", s.path, s.desiredTarget), nil
+	case s.desiredState == StatePresent && s.currentState == StateAbsent:
+		return fmt.Sprintf("diff -- symlink: %s\n+ present (symlink to %q will be created)\n", s.path, s.desiredTarget), nil
+	}
+
+	if s.desiredState == StatePresent && s.currentTarget != s.desiredTarget {
+		return fmt.Sprintf("diff -- symlink: %s\n- target: %q\n+ target: %q\n", s.path, s.currentTarget, s.desiredTarget), nil
+	}
+
+	return "", nil
+}
+
+func (s *Symlink) Apply(ctx context.Context) error {
+	s.lastOperation = OperationNone
+
+	if s.desiredState == StateAbsent {
+		if s.currentState == s.desiredState {
+			return nil
+		}
+
+		if err := s.delete(ctx); err != nil {
+			return err
+		}
+
+		s.lastOperation = OperationDelete
+		return nil
+	}
+
+	etag, created, err := s.put(ctx, s.desiredTarget, s.etag)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		s.lastOperation = OperationCreate
+	} else {
+		s.lastOperation = OperationUpdate
+	}
+	s.etag = etag
+
+	return nil
+}
+
+// Rollback restores the previous target of the symlink. A file or directory replaced
+// via force is not restored.
+func (s *Symlink) Rollback(ctx context.Context) error {
+	switch s.lastOperation {
+	case OperationNone:
+		return nil
+	case OperationCreate:
+		return s.delete(ctx)
+	case OperationUpdate:
+		_, _, err := s.put(ctx, s.currentTarget, s.etag)
+		return err
+	case OperationDelete:
+		_, _, err := s.put(ctx, s.currentTarget, "")
+		return err
+	}
+
+	return nil
+}
+
+func (s *Symlink) put(ctx context.Context, target, etag string) (string, bool, error) {
+	params := ops_symlinks.NewPutSymlinkParamsWithContext(ctx)
+	params.Path = s.path
+	params.Properties = &models.SymlinkProperties{Target: target}
+	params.Force = pointer.To(s.force)
+
+	if etag != "" {
+		params.SetIfMatch(pointer.To(etag))
+	}
+
+	created, noContent, err := s.cfg.Client.Symlinks.PutSymlink(params)
+	if err != nil {
+		if payload := getErrorPayload(err); payload != nil {
+			return "", false, newAPIError(payload)
+		}
+
+		return "", false, fmt.Errorf("failed to put symlink: %w", err)
+	}
+
+	switch {
+	case created != nil:
+		return created.ETag, true, nil
+	case noContent != nil:
+		return noContent.ETag, false, nil
+	default:
+		return "", false, fmt.Errorf("unexpected nil response")
+	}
+}
+
+func (s *Symlink) delete(ctx context.Context) error {
+	params := ops_symlinks.NewDeleteSymlinkParamsWithContext(ctx)
+	params.Path = s.path
+	if s.etag != "" {
+		params.SetIfMatch(pointer.To(s.etag))
+	}
+
+	_, err := s.cfg.Client.Symlinks.DeleteSymlink(params)
+	if err != nil {
+		if payload := getErrorPayload(err); payload != nil {
+			return newAPIError(payload)
+		}
+
+		return fmt.Errorf("failed to delete symlink: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,361 @@
+package resource
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+
+	ops_content "peertech.de/axion/api/client/content"
+	ops_directories "peertech.de/axion/api/client/directories"
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/pointer"
+	"peertech.de/axion/pkg/version"
+)
+
+// ProgressFunc receives the number of bytes transferred so far and the total number,
+// which is negative if it is not known in advance
+type ProgressFunc func(transferred, total int64)
+
+// Upload copies the local file or directory src to dst on the agent and returns the
+// path it was copied to. Like cp, src is copied into dst if that is an existing
+// directory. Directories replace the directory at the destination as a whole.
+func Upload(ctx context.Context, cfg *config.Config, src, dst string, progress ProgressFunc) (string, error) {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+
+	exists, isDir, err := remoteKind(ctx, cfg, dst)
+	if err != nil {
+		return "", err
+	}
+	if exists && isDir {
+		dst = path.Join(dst, filepath.Base(src))
+	}
+
+	tmp, err := os.CreateTemp("", "axion-upload-*."+archiveFormatGzip)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = writeArchive(tmp, src, fi.IsDir())
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to archive %s: %w", src, err)
+	}
+
+	if err := uploadArchive(ctx, cfg, dst, fi.IsDir(), false, tmp.Name(), progress); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// Download copies the file or directory src on the agent to the local path dst and
+// returns the path it was copied to. Like cp, src is copied into dst if that is an
+// existing directory.
+func Download(ctx context.Context, cfg *config.Config, src, dst string, progress ProgressFunc) (string, error) {
+	exists, isDir, err := remoteKind(ctx, cfg, src)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("%s not found on the agent", src)
+	}
+	if fi, err := os.Stat(dst); err == nil && fi.IsDir() {
+		dst = filepath.Join(dst, path.Base(src))
+	}
+
+	tmp, err := os.CreateTemp("", "axion-download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	params := ops_content.NewDownloadParamsWithContext(ctx)
+	params.Path = src
+	params.Recursive = pointer.To(isDir)
+	if cfg.Supports(version.CapabilityZstd) {
+		params.AcceptEncoding = pointer.To("zstd")
+	}
+
+	var w io.Writer = tmp
+	if progress != nil {
+		w = &progressWriter{Writer: tmp, progress: progress}
+	}
+	if _, err := cfg.Client.Content.Download(params, w); err != nil {
+		if payload := getErrorPayload(err); payload != nil {
+			return "", newAPIError(payload)
+		}
+		return "", fmt.Errorf("failed to download %s: %w", src, err)
+	}
+
+	format, err := archiveFormat(tmp)
+	if err != nil {
+		return "", fmt.Errorf("failed to read archive: %w", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if err := extractArchive(tmp, format, dst, isDir); err != nil {
+		return "", fmt.Errorf("failed to extract %s: %w", src, err)
+	}
+	return dst, nil
+}
+
+// remoteKind reports whether path exists on the agent and whether it is a directory
+func remoteKind(ctx context.Context, cfg *config.Config, path string) (exists, isDir bool, err error) {
+	params := ops_directories.NewGetDirectoryPropertiesParamsWithContext(ctx)
+	params.Path = path
+
+	_, err = cfg.Client.Directories.GetDirectoryProperties(params)
+	if err == nil {
+		return true, true, nil
+	}
+	if directoryNotFound(err) {
+		return false, false, nil
+	}
+
+	// The path exists but is not a directory
+	var badRequest *ops_directories.GetDirectoryPropertiesBadRequest
+	if errors.As(err, &badRequest) {
+		return true, false, nil
+	}
+
+	if payload := getErrorPayload(err); payload != nil {
+		return false, false, newAPIError(payload)
+	}
+	return false, false, fmt.Errorf("failed to stat %s: %w", path, err)
+}
+
+// writeArchive writes the gzip compressed TAR archive of the file or directory at src
+// to w, laid out like the archives of the agent: a single entry named like the file,
+// or the content of the directory relative to it
+func writeArchive(w io.Writer, src string, isDir bool) error {
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+
+	var err error
+	if isDir {
+		err = filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil || rel == "." {
+				return err
+			}
+			return addToArchive(tw, p, filepath.ToSlash(rel), info)
+		})
+	} else {
+		var info os.FileInfo
+		if info, err = os.Stat(src); err == nil {
+			err = addToArchive(tw, src, filepath.Base(src), info)
+		}
+	}
+
+	if cerr := tw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := gzw.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// addToArchive writes the entry name of the file at p to tw, sockets and devices are
+// left out
+func addToArchive(tw *tar.Writer, p, name string, info os.FileInfo) error {
+	var link string
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(p)
+		if err != nil {
+			return err
+		}
+		link = target
+	case !info.IsDir() && !info.Mode().IsRegular():
+		return nil
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	// Owners are left to the agent, see preserve
+	header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	fd, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	_, err = io.Copy(tw, fd)
+	return err
+}
+
+// extractArchive extracts the TAR archive compressed with format read from r to dst,
+// the directory archived by the agent if isDir is set, otherwise the single file in it
+func extractArchive(r io.Reader, format, dst string, isDir bool) error {
+	tr, closeArchive, err := openArchive(r, format)
+	if err != nil {
+		return err
+	}
+	defer closeArchive()
+
+	if isDir {
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+	}
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if !isDir {
+			if header.Typeflag != tar.TypeReg {
+				continue
+			}
+			return extractFile(tr, dst, header.FileInfo().Mode().Perm())
+		}
+
+		// Entries must not escape the destination
+		if !filepath.IsLocal(header.Name) {
+			return fmt.Errorf("invalid entry %q in archive", header.Name)
+		}
+		target := filepath.Join(dst, header.Name)
+		mode := header.FileInfo().Mode().Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(tr, target, mode); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			os.Remove(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			if !filepath.IsLocal(header.Linkname) {
+				return fmt.Errorf("invalid hardlink %q in archive", header.Linkname)
+			}
+			os.Remove(target)
+			if err := os.Link(filepath.Join(dst, header.Linkname), target); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !isDir {
+		return fmt.Errorf("archive contains no file")
+	}
+	return nil
+}
+
+// openArchive returns a reader of the TAR archive compressed with format read from r,
+// the returned function releases the decompressor
+func openArchive(r io.Reader, format string) (*tar.Reader, func(), error) {
+	dr, release, err := decompress(r, format)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tar.NewReader(dr), release, nil
+}
+
+// decompress returns a reader of the content compressed with format read from r, the
+// returned function releases the decompressor
+func decompress(r io.Reader, format string) (io.Reader, func(), error) {
+	switch format {
+	case archiveFormatZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	default:
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gzr, func() { gzr.Close() }, nil
+	}
+}
+
+// extractFile writes the content read from r to the file at path with mode
+func extractFile(r io.Reader, path string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fd, r); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+// progressReader reports the bytes read through it
+type progressReader struct {
+	io.ReadCloser
+	read     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	r.progress(r.read, r.total)
+	return n, err
+}
+
+// progressWriter reports the bytes written through it, the total is not known
+type progressWriter struct {
+	io.Writer
+	written  int64
+	progress ProgressFunc
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.written += int64(n)
+	w.progress(w.written, -1)
+	return n, err
+}
@@ -0,0 +1,211 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	ops_content "peertech.de/axion/api/client/content"
+	"peertech.de/axion/api/models"
+	"peertech.de/axion/pkg/config"
+	"peertech.de/axion/pkg/pointer"
+	"peertech.de/axion/pkg/version"
+)
+
+const (
+	// chunkSize is the size of the chunks large archives are uploaded in
+	chunkSize = 8 * 1024 * 1024
+	// maxChunkRetries is how often a chunk is retried before the upload is given up
+	maxChunkRetries = 5
+)
+
+// The compressions of transferred TAR archives, as given by X-Archive-Format
+const (
+	archiveFormatGzip = "tar.gz"
+	archiveFormatZstd = "tar.zst"
+)
+
+// zstdMagic starts zstd compressed data
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// uploadArchive uploads the gzip or zstd compressed archive at archive to path.
+// Archives larger than a chunk are uploaded in chunks if the agent supports it, so a
+// dropped connection only costs the current chunk. With preserve, the agent restores
+// the owners, extended attributes and hardlinks recorded in the archive. The bytes sent
+// are reported to progress, if set.
+func uploadArchive(ctx context.Context, cfg *config.Config, path string, recursive, preserve bool, archive string, progress ProgressFunc) error {
+	fi, err := os.Stat(archive)
+	if err != nil {
+		return err
+	}
+
+	checksum, err := fileChecksum(archive)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	fd, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer fd.Close()
+
+	format, err := archiveFormat(fd)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	if format == archiveFormatZstd && !cfg.Supports(version.CapabilityZstd) {
+		return fmt.Errorf("agent doesn't support zstd compressed archives")
+	}
+
+	if fi.Size() > chunkSize && cfg.Supports(version.CapabilityChunkedUploads) {
+		return uploadChunked(ctx, cfg, path, recursive, preserve, format, fd, fi.Size(), checksum, progress)
+	}
+
+	var content io.ReadCloser = fd
+	if progress != nil {
+		content = &progressReader{ReadCloser: fd, total: fi.Size(), progress: progress}
+	}
+
+	params := ops_content.NewUploadParamsWithContext(ctx)
+	params.Path = path
+	params.Recursive = pointer.To(recursive)
+	params.Preserve = pointer.To(preserve)
+	params.XArchiveFormat = pointer.To(format)
+	params.Content = content
+	params.ContentSha256 = pointer.To(checksum)
+
+	_, _, err = cfg.Client.Content.Upload(params)
+	if err != nil {
+		if payload := getErrorPayload(err); payload != nil {
+			return newAPIError(payload)
+		}
+		return err
+	}
+	return nil
+}
+
+// uploadChunked uploads the archive through an upload session. Failed chunks are
+// retried from the offset the agent received.
+func uploadChunked(ctx context.Context, cfg *config.Config, path string, recursive, preserve bool, format string, archive io.ReaderAt, size int64, checksum string, progress ProgressFunc) error {
+	params := ops_content.NewInitUploadParamsWithContext(ctx)
+	params.Session = &models.UploadSessionRequest{
+		Path:      path,
+		Recursive: recursive,
+		Preserve:  preserve,
+		Format:    format,
+		Size:      size,
+		Sha256:    checksum,
+	}
+
+	created, err := cfg.Client.Content.InitUpload(params)
+	if err != nil {
+		if payload := getErrorPayload(err); payload != nil {
+			return newAPIError(payload)
+		}
+		return err
+	}
+	id := created.Payload.ID
+
+	buf := make([]byte, chunkSize)
+	offset, retries := int64(0), 0
+	for offset < size {
+		chunk := buf[:min(int64(chunkSize), size-offset)]
+		if _, err := archive.ReadAt(chunk, offset); err != nil && !errors.Is(err, io.EOF) {
+			abortUpload(ctx, cfg, id)
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		sum := sha256.Sum256(chunk)
+
+		appendParams := ops_content.NewAppendUploadParamsWithContext(ctx)
+		appendParams.ID = id
+		appendParams.UploadOffset = offset
+		appendParams.ContentSha256 = pointer.To(hex.EncodeToString(sum[:]))
+		appendParams.Chunk = io.NopCloser(bytes.NewReader(chunk))
+
+		resp, err := cfg.Client.Content.AppendUpload(appendParams)
+		if err == nil {
+			offset = resp.Payload.Offset
+			retries = 0
+			if progress != nil {
+				progress(offset, size)
+			}
+			continue
+		}
+
+		// Offset conflicts and corrupted chunks are recovered from like dropped
+		// connections, any other error reported by the agent is final
+		var conflict *ops_content.AppendUploadConflict
+		var corrupted *ops_content.AppendUploadUnprocessableEntity
+		if payload := getErrorPayload(err); payload != nil && !errors.As(err, &conflict) && !errors.As(err, &corrupted) {
+			abortUpload(ctx, cfg, id)
+			return newAPIError(payload)
+		}
+
+		retries++
+		if retries > maxChunkRetries {
+			abortUpload(ctx, cfg, id)
+			return fmt.Errorf("failed to upload chunk at offset %d: %w", offset, err)
+		}
+		log.Info().Err(err).
+			Str("upload", id).
+			Int64("offset", offset).
+			Int("attempt", retries).
+			Msg("Retrying chunk upload")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(retries) * time.Second):
+		}
+
+		// Resume from the offset the agent received, keep the current one if the agent
+		// is still unreachable
+		getParams := ops_content.NewGetUploadSessionParamsWithContext(ctx)
+		getParams.ID = id
+		if session, err := cfg.Client.Content.GetUploadSession(getParams); err == nil {
+			offset = session.Payload.Offset
+		}
+	}
+
+	commitParams := ops_content.NewCommitUploadParamsWithContext(ctx)
+	commitParams.ID = id
+
+	_, _, err = cfg.Client.Content.CommitUpload(commitParams)
+	if err != nil {
+		if payload := getErrorPayload(err); payload != nil {
+			return newAPIError(payload)
+		}
+		return err
+	}
+	return nil
+}
+
+// abortUpload discards the upload session, errors are ignored as the session expires
+// on the agent anyway
+func abortUpload(ctx context.Context, cfg *config.Config, id string) {
+	params := ops_content.NewAbortUploadParamsWithContext(ctx)
+	params.ID = id
+	_, _ = cfg.Client.Content.AbortUpload(params)
+}
+
+// archiveFormat detects the compression of the archive read by r
+func archiveFormat(r io.ReaderAt) (string, error) {
+	magic := make([]byte, len(zstdMagic))
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if bytes.Equal(magic[:n], zstdMagic) {
+		return archiveFormatZstd, nil
+	}
+	return archiveFormatGzip, nil
+}
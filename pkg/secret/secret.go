@@ -0,0 +1,255 @@
+// Package secret resolves secret references in manifest variables. A reference has the
+// form "<scheme>:<ref>", e.g. "vault:secret/data/app#password" or
+// "sops:secrets.yaml#db.password". Resolved values are recorded as sensitive so that
+// they can be redacted from any output.
+package secret
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces sensitive values in redacted output
+const Redacted = "******"
+
+// Source resolves references of a single scheme
+type Source interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// Decrypter is implemented by sources which can decrypt whole files
+type Decrypter interface {
+	Decrypt(ctx context.Context, path string) ([]byte, error)
+}
+
+type Option func(*Options)
+
+type Options struct {
+	Sources map[string]Source
+}
+
+// WithSource registers a source for the given scheme, replacing any existing one
+func WithSource(scheme string, source Source) Option {
+	return func(o *Options) {
+		o.Sources[scheme] = source
+	}
+}
+
+// NewResolver returns a resolver with the vault and sops sources registered by default.
+// Vault is configured from the VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE environment
+// variables, sops uses the sops binary found in PATH.
+func NewResolver(options ...Option) *Resolver {
+	opts := Options{
+		Sources: map[string]Source{
+			"vault": NewVaultFromEnv(),
+			"sops":  &SOPS{Binary: "sops"},
+		},
+	}
+
+	for _, option := range options {
+		option(&opts)
+	}
+
+	return &Resolver{
+		options:   opts,
+		sensitive: make(map[string]struct{}),
+	}
+}
+
+// Resolver resolves secret references and keeps track of all sensitive values. A nil
+// Resolver leaves references untouched and redacts nothing.
+type Resolver struct {
+	options Options
+
+	mu        sync.RWMutex        // protects sensitive
+	sensitive map[string]struct{} // resolved secret values
+}
+
+// Resolve resolves value if it is a reference to a registered scheme and returns
+// whether it was a reference.
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, bool, error) {
+	if r == nil {
+		return value, false, nil
+	}
+
+	scheme, ref, ok := strings.Cut(value, ":")
+	if !ok {
+		return value, false, nil
+	}
+	source, ok := r.options.Sources[scheme]
+	if !ok {
+		return value, false, nil
+	}
+
+	resolved, err := source.Resolve(ctx, ref)
+	if err != nil {
+		return "", true, fmt.Errorf("failed to resolve %s secret %q: %w", scheme, ref, err)
+	}
+
+	r.MarkSensitive(resolved)
+	return resolved, true, nil
+}
+
+// IsReference reports whether v is a reference to a registered scheme, without
+// resolving it
+func (r *Resolver) IsReference(v any) bool {
+	s, ok := v.(string)
+	if r == nil || !ok {
+		return false
+	}
+	scheme, _, ok := strings.Cut(s, ":")
+	if !ok {
+		return false
+	}
+	_, ok = r.options.Sources[scheme]
+	return ok
+}
+
+// ResolveVariables returns a copy of vars with all references resolved, including the
+// ones nested in maps and lists.
+func (r *Resolver) ResolveVariables(ctx context.Context, vars map[string]any) (map[string]any, error) {
+	if r == nil || vars == nil {
+		return vars, nil
+	}
+
+	resolved, err := r.resolveValue(ctx, vars)
+	if err != nil {
+		return nil, err
+	}
+	return resolved.(map[string]any), nil
+}
+
+func (r *Resolver) resolveValue(ctx context.Context, v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		resolved, _, err := r.Resolve(ctx, v)
+		return resolved, err
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			resolved, err := r.resolveValue(ctx, item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = resolved
+		}
+		return out, nil
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			resolved, err := r.resolveValue(ctx, item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			out[k] = resolved
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+// DecryptFile decrypts the file using the sops source. The strings of the decrypted
+// file are marked sensitive, see leaves.
+func (r *Resolver) DecryptFile(ctx context.Context, path string) ([]byte, error) {
+	if r == nil {
+		return nil, fmt.Errorf("secrets are not configured")
+	}
+
+	d, ok := r.options.Sources["sops"].(Decrypter)
+	if !ok {
+		return nil, fmt.Errorf("no sops source configured")
+	}
+
+	data, err := d.Decrypt(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	r.MarkSensitive(leaves(vars)...)
+
+	return data, nil
+}
+
+// MarkSensitive records values which must be redacted from output
+func (r *Resolver) MarkSensitive(values ...string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range values {
+		if v != "" {
+			r.sensitive[v] = struct{}{}
+		}
+	}
+}
+
+// Sensitive returns all sensitive values, longest first
+func (r *Resolver) Sensitive() []string {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	values := make([]string, 0, len(r.sensitive))
+	for v := range r.sensitive {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+	return values
+}
+
+// Redact replaces all sensitive values in s
+func (r *Resolver) Redact(s string) string {
+	for _, v := range r.Sensitive() {
+		s = strings.ReplaceAll(s, v, Redacted)
+	}
+	return s
+}
+
+// unencryptedSuffix marks keys sops leaves in plain text in encrypted files
+const unencryptedSuffix = "_unencrypted"
+
+// leaves returns all strings in v except the values of keys ending in unencryptedSuffix.
+// Other scalars, e.g. replicas: 3 or enabled: true, are settings rather than secrets.
+func leaves(v any) []string {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []any:
+		var out []string
+		for _, item := range v {
+			out = append(out, leaves(item)...)
+		}
+		return out
+	case map[string]any:
+		var out []string
+		for k, item := range v {
+			if strings.HasSuffix(k, unencryptedSuffix) {
+				continue
+			}
+			out = append(out, leaves(item)...)
+		}
+		return out
+	case string:
+		return []string{v}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,122 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type staticSource map[string]string
+
+func (s staticSource) Resolve(ctx context.Context, ref string) (string, error) {
+	if v, ok := s[ref]; ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("not found")
+}
+
+func TestResolveVariables(t *testing.T) {
+	r := NewResolver(WithSource("static", staticSource{"db": "s3cr3t"}))
+
+	vars, err := r.ResolveVariables(context.Background(), map[string]any{
+		"plain":  "value",
+		"nested": map[string]any{"password": "static:db"},
+		"list":   []any{"static:db", 1},
+		"scheme": "unknown:db",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := vars["nested"].(map[string]any)["password"]; got != "s3cr3t" {
+		t.Errorf("expected nested secret to be resolved, got %v", got)
+	}
+	if got := vars["list"].([]any)[0]; got != "s3cr3t" {
+		t.Errorf("expected list secret to be resolved, got %v", got)
+	}
+	if got := vars["scheme"]; got != "unknown:db" {
+		t.Errorf("expected unknown scheme to be left untouched, got %v", got)
+	}
+
+	if got := r.Redact("password=s3cr3t"); got != "password="+Redacted {
+		t.Errorf("expected secret to be redacted, got %q", got)
+	}
+	if !r.IsReference("static:db") || r.IsReference("unknown:db") || r.IsReference(1) {
+		t.Errorf("expected only references to registered schemes to be references")
+	}
+}
+
+// decryptedSource is a sops source returning a decrypted file
+type decryptedSource string
+
+func (s decryptedSource) Resolve(ctx context.Context, ref string) (string, error) {
+	return "", fmt.Errorf("not found")
+}
+
+func (s decryptedSource) Decrypt(ctx context.Context, path string) ([]byte, error) {
+	return []byte(s), nil
+}
+
+func TestDecryptFile(t *testing.T) {
+	r := NewResolver(WithSource("sops", decryptedSource("db:\n  password: s3cr3t\n  user: app\n  host_unencrypted: db.local\nreplicas: 3\nenabled: true\n")))
+	if _, err := r.DecryptFile(context.Background(), "secrets.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := r.Redact("password=s3cr3t user=app host=db.local replicas=3 enabled=true")
+	if want := "password=" + Redacted + " user=" + Redacted + " host=db.local replicas=3 enabled=true"; got != want {
+		t.Errorf("expected the encrypted strings to be redacted, got %q", got)
+	}
+}
+
+func TestVault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch req.URL.Path {
+		case "/v1/secret/data/app":
+			fmt.Fprint(w, `{"data": {"data": {"password": "v2"}, "metadata": {"version": 1}}}`)
+		case "/v1/kv/app":
+			fmt.Fprint(w, `{"data": {"password": "v1"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	v := &Vault{Address: srv.URL, Token: "token"}
+
+	tests := []struct {
+		ref      string
+		expected string
+		fail     bool
+	}{
+		{ref: "secret/data/app#password", expected: "v2"},
+		{ref: "kv/app#password", expected: "v1"},
+		{ref: "kv/app#missing", fail: true},
+		{ref: "kv/unknown#password", fail: true},
+		{ref: "kv/app", fail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := v.Resolve(context.Background(), tt.ref)
+			if tt.fail {
+				if err == nil {
+					t.Errorf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
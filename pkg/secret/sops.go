@@ -0,0 +1,88 @@
+package secret
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SOPS resolves references of the form "<file>#<key>" by decrypting the file with the
+// sops binary, e.g. "secrets.yaml#db.password". Nested keys are separated by dots.
+type SOPS struct {
+	Binary string
+}
+
+func (s *SOPS) Resolve(ctx context.Context, ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("invalid reference, expected <file>#<key>")
+	}
+
+	data, err := s.Decrypt(ctx, path)
+	if err != nil {
+		return "", err
+	}
+
+	vars, err := parse(data)
+	if err != nil {
+		return "", err
+	}
+
+	var value any = vars
+	for _, part := range strings.Split(key, ".") {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("key %q not found", key)
+		}
+		if value, ok = m[part]; !ok {
+			return "", fmt.Errorf("key %q not found", key)
+		}
+	}
+
+	switch value.(type) {
+	case map[string]any, []any:
+		return "", fmt.Errorf("key %q is not a scalar value", key)
+	}
+	return fmt.Sprintf("%v", value), nil
+}
+
+// Decrypt returns the decrypted content of the file
+func (s *SOPS) Decrypt(ctx context.Context, path string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.Binary, "--decrypt", path)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("sops failed to decrypt %q: %s", path, strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("failed to run sops: %w", err)
+	}
+	return out, nil
+}
+
+// IsEncrypted reports whether data is a SOPS encrypted YAML or JSON document, which is
+// identified by the top level sops metadata key.
+func IsEncrypted(data []byte) bool {
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return false
+	}
+	_, ok := doc["sops"]
+	return ok
+}
+
+func parse(data []byte) (map[string]any, error) {
+	var vars map[string]any
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("failed to parse decrypted data: %w", err)
+	}
+	return vars, nil
+}
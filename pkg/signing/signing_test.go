@@ -0,0 +1,79 @@
+package signing_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"peertech.de/axion/pkg/signing"
+)
+
+var secret = []byte("0123456789abcdef")
+
+func verify(r *http.Request, secret []byte) bool {
+	return signing.Verify(secret,
+		r.Header.Get(signing.SignatureHeader),
+		r.Method,
+		r.URL.RequestURI(),
+		r.Header.Get(signing.DateHeader),
+		r.Header.Get(signing.NonceHeader),
+		r.Header.Get(signing.ContentHeader),
+	)
+}
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"command":"true"}`)
+	r, err := http.NewRequest(http.MethodPost, "http://agent/api/v1/command?x=1", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signing.Sign(r, secret, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := r.Header.Get(signing.ContentHeader); got != signing.ContentHash(body) {
+		t.Errorf("expected the digest of the body, got %q", got)
+	}
+	if !verify(r, secret) {
+		t.Error("expected the signature to be valid")
+	}
+	if verify(r, []byte("fedcba9876543210")) {
+		t.Error("expected the signature to be invalid with another secret")
+	}
+
+	r.URL.RawQuery = "x=2"
+	if verify(r, secret) {
+		t.Error("expected the signature to be invalid for another URI")
+	}
+}
+
+func TestSignNonces(t *testing.T) {
+	sign := func() string {
+		r, _ := http.NewRequest(http.MethodGet, "http://agent/api/v1/version", nil)
+		if err := signing.Sign(r, secret, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		return r.Header.Get(signing.NonceHeader)
+	}
+
+	if a, b := sign(), sign(); a == "" || a == b {
+		t.Errorf("expected distinct nonces, got %q and %q", a, b)
+	}
+}
+
+func TestSignStreamedBody(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodPost, "http://agent/api/v1/upload", io.NopCloser(strings.NewReader("data")))
+	if err := signing.Sign(r, secret, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := r.Header.Get(signing.ContentHeader); got != signing.UnsignedPayload {
+		t.Errorf("expected %q, got %q", signing.UnsignedPayload, got)
+	}
+	if !verify(r, secret) {
+		t.Error("expected the signature to be valid")
+	}
+}
@@ -0,0 +1,189 @@
+// Package state keeps what axionctl believes to manage on each host: the manifest last
+// applied, the resources it applied, the operation last performed on them and the ETag
+// of their state on the agent. The state of each host is a JSON file in the state
+// directory, readable by its owner only.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned if there is no state of a host
+var ErrNotFound = errors.New("no state")
+
+// State is the state of a host
+type State struct {
+	Endpoint string `json:"endpoint" yaml:"endpoint"`
+	// Manifest is the manifest last applied to the host and Digest its digest
+	Manifest string `json:"manifest" yaml:"manifest"`
+	Digest   string `json:"digest" yaml:"digest"`
+	// AppliedAt is the time the host was last converged with the whole manifest, zero
+	// if it never was. Changes and Total are the resources changed by and part of
+	// that run.
+	AppliedAt time.Time  `json:"applied_at" yaml:"applied_at"`
+	Changes   int        `json:"changes" yaml:"changes"`
+	Total     int        `json:"total" yaml:"total"`
+	Updated   time.Time  `json:"updated" yaml:"updated"`
+	Resources []Resource `json:"resources" yaml:"resources"`
+}
+
+// Resource is the state of a resource applied to the host
+type Resource struct {
+	Id   string `json:"id" yaml:"id"`
+	Name string `json:"name" yaml:"name"`
+	// Operation is the last operation which changed the resource, "none" if it was in
+	// the desired state already when it was first applied
+	Operation string `json:"operation" yaml:"operation"`
+	// ETag identifies the state of the resource on the agent, empty if it isn't
+	// versioned or doesn't exist
+	ETag string `json:"etag,omitempty" yaml:"etag,omitempty"`
+	// Digest identifies the specification the resource was applied with, see
+	// manifest.ResourceDigest
+	Digest string `json:"digest,omitempty" yaml:"digest,omitempty"`
+	// Updated is the time the resource was last changed, or first applied
+	Updated time.Time `json:"updated" yaml:"updated"`
+}
+
+// Store reads and writes the states of the hosts in a directory
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Load returns the state of endpoint, ErrNotFound if there is none
+func (s *Store) Load(endpoint string) (*State, error) {
+	return s.read(s.file(endpoint))
+}
+
+// Save writes st, replacing the previous state of its endpoint
+func (s *Store) Save(st *State) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+
+	// Write atomically so a concurrent reader never sees a partial state
+	file := s.file(st.Endpoint)
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Delete removes the state of endpoint, ErrNotFound if there is none
+func (s *Store) Delete(endpoint string) error {
+	err := os.Remove(s.file(endpoint))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w for %s", ErrNotFound, endpoint)
+	}
+	return err
+}
+
+// List returns the states of all hosts, ordered by endpoint
+func (s *Store) List() ([]*State, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]*State, 0, len(files))
+	for _, file := range files {
+		st, err := s.read(file)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, st)
+	}
+	slices.SortFunc(states, func(a, b *State) int { return strings.Compare(a.Endpoint, b.Endpoint) })
+	return states, nil
+}
+
+func (s *Store) read(file string) (*State, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("invalid state %s: %w", file, err)
+	}
+	return &st, nil
+}
+
+// file returns the file holding the state of endpoint, keyed by its host
+func (s *Store) file(endpoint string) string {
+	host := endpoint
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return filepath.Join(s.dir, strings.NewReplacer(":", "_", "/", "_").Replace(host)+".json")
+}
+
+// Resource returns the state of the resource with id, nil if it isn't part of st
+func (st *State) Resource(id string) *Resource {
+	for i := range st.Resources {
+		if st.Resources[i].Id == id {
+			return &st.Resources[i]
+		}
+	}
+	return nil
+}
+
+// Merge updates st with the resources of a run at now. Resources which were already in
+// the desired state keep their operation and time. If complete, the run covered the
+// whole manifest and resources which aren't part of it anymore are dropped.
+func (st *State) Merge(resources []Resource, complete bool, now time.Time) {
+	merged := make([]Resource, 0, len(resources))
+	seen := make(map[string]bool, len(resources))
+	for _, r := range resources {
+		seen[r.Id] = true
+		if prev := st.Resource(r.Id); prev != nil && r.Operation == "none" {
+			r.Operation, r.Updated = prev.Operation, prev.Updated
+		} else {
+			r.Updated = now
+		}
+		merged = append(merged, r)
+	}
+	if !complete {
+		for _, r := range st.Resources {
+			if !seen[r.Id] {
+				merged = append(merged, r)
+			}
+		}
+	}
+
+	st.Resources = merged
+	st.Updated = now
+}
+
+// Remove drops the resources with ids from st, it fails if one of them isn't part of it
+func (st *State) Remove(ids ...string) error {
+	for _, id := range ids {
+		if st.Resource(id) == nil {
+			return fmt.Errorf("resource %q is not in the state of %s", id, st.Endpoint)
+		}
+	}
+	st.Resources = slices.DeleteFunc(st.Resources, func(r Resource) bool {
+		return slices.Contains(ids, r.Id)
+	})
+	return nil
+}
@@ -0,0 +1,93 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	s := NewStore(t.TempDir())
+
+	if _, err := s.Load("https://web1:8080"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	for _, endpoint := range []string{"https://web2:8080", "https://web1:8080"} {
+		if err := s.Save(&State{Endpoint: endpoint, Resources: []Resource{{Id: "conf"}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st, err := s.Load("https://web1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(s.file(st.Endpoint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expected the state to be readable by its owner only, got %v", fi.Mode())
+	}
+	if st.Resource("conf") == nil {
+		t.Errorf("expected conf in state, got %+v", st.Resources)
+	}
+
+	states, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || states[0].Endpoint != "https://web1:8080" {
+		t.Errorf("expected the states ordered by endpoint, got %+v", states)
+	}
+
+	if err := s.Delete("https://web1:8080"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("https://web1:8080"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+
+	st := &State{}
+	st.Merge([]Resource{
+		{Id: "conf", Operation: "create", ETag: "a"},
+		{Id: "dir", Operation: "none"},
+		{Id: "old", Operation: "create"},
+	}, true, first)
+
+	// conf is unchanged but its ETag is refreshed, old was left out of a partial run
+	st.Merge([]Resource{
+		{Id: "conf", Operation: "none", ETag: "b"},
+		{Id: "dir", Operation: "update"},
+	}, false, second)
+
+	conf := st.Resource("conf")
+	if conf.Operation != "create" || !conf.Updated.Equal(first) || conf.ETag != "b" {
+		t.Errorf("expected unchanged conf to keep its operation, got %+v", conf)
+	}
+	if dir := st.Resource("dir"); dir.Operation != "update" || !dir.Updated.Equal(second) {
+		t.Errorf("expected dir to be updated, got %+v", dir)
+	}
+	if st.Resource("old") == nil {
+		t.Error("expected old to be kept by a partial run")
+	}
+
+	st.Merge([]Resource{{Id: "conf", Operation: "none"}}, true, second)
+	if len(st.Resources) != 1 {
+		t.Errorf("expected resources removed from the manifest to be dropped, got %+v", st.Resources)
+	}
+
+	if err := st.Remove("dir"); err == nil {
+		t.Error("expected removing an unknown resource to fail")
+	}
+	if err := st.Remove("conf"); err != nil || len(st.Resources) != 0 {
+		t.Errorf("expected conf to be removed, got %v, %+v", err, st.Resources)
+	}
+}
@@ -0,0 +1,77 @@
+// Package version holds the version of axion, set at build time via -ldflags, and the
+// capabilities of the agent API.
+package version
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+var (
+	// Version is the released version, dev for local builds
+	Version = "dev"
+	// Commit is the revision the binaries were built from, taken from the build info
+	// of the module if not set
+	Commit = ""
+	// BuildDate is the time the binaries were built
+	BuildDate = ""
+)
+
+// APIVersion is the major version of the agent API, agents and clients of different
+// API versions are incompatible
+const APIVersion = "v1"
+
+// The optional features of the agent API, older agents may lack some and the audit log,
+// gRPC and backups depend on the agent configuration
+const (
+	CapabilityCommandStream  = "commandStream"
+	CapabilityAsyncCommands  = "asyncCommands"
+	CapabilityChunkedUploads = "chunkedUploads"
+	CapabilityBatchStat      = "batchStat"
+	CapabilityEvents         = "events"
+	CapabilityAudit          = "audit"
+	CapabilityHealth         = "health"
+	CapabilityGRPC           = "grpc"
+	CapabilityZstd           = "zstd"
+	CapabilityBackups        = "backups"
+	CapabilityScripts        = "scripts"
+)
+
+func init() {
+	if Commit != "" {
+		return
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				Commit = s.Value[:min(len(s.Value), 12)]
+			}
+		}
+	}
+}
+
+// Describe returns the version line of program, e.g. for --version
+func Describe(program string) string {
+	return Format(program, Version, APIVersion, Commit, BuildDate)
+}
+
+// Format returns a version line of program, unknown values are left out
+func Format(program, version, apiVersion, commit, buildDate string) string {
+	var details []string
+	if apiVersion != "" {
+		details = append(details, "API "+apiVersion)
+	}
+	if commit != "" {
+		details = append(details, "commit "+commit)
+	}
+	if buildDate != "" {
+		details = append(details, "built "+buildDate)
+	}
+
+	line := fmt.Sprintf("%s %s", program, version)
+	if len(details) > 0 {
+		line += " (" + strings.Join(details, ", ") + ")"
+	}
+	return line
+}
@@ -0,0 +1,24 @@
+package version_test
+
+import (
+	"testing"
+
+	"peertech.de/axion/pkg/version"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		version, apiVersion, commit, buildDate string
+		expected                               string
+	}{
+		{"v1.2.0", "v1", "abc123", "2024-01-02T03:04:05+0000", "axiond v1.2.0 (API v1, commit abc123, built 2024-01-02T03:04:05+0000)"},
+		{"dev", "v1", "", "", "axiond dev (API v1)"},
+		{"v0.9.0", "", "", "", "axiond v0.9.0"},
+	}
+
+	for _, tt := range tests {
+		if got := version.Format("axiond", tt.version, tt.apiVersion, tt.commit, tt.buildDate); got != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, got)
+		}
+	}
+}